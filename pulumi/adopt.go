@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"cluster-studio/internal/adopt"
+	clusterpkg "cluster-studio/internal/cluster"
+	"cluster-studio/internal/previous"
+	fluxpkg "cluster-studio/pkg/flux"
+	"cluster-studio/pkg/kube"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// adoptTimeout bounds the inspection of the cluster before the update
+const adoptTimeout = 30 * time.Second
+
+// adoptExisting inspects the cluster for what the stack installs but someone
+// else already did, so a partially provisioned cluster is taken over instead
+// of failing with "already exists". It registers the adoption of the existing
+// namespaces and returns the Linkerd control plane found, or fails when what
+// exists conflicts with the config. A cluster that can't be reached yet (e.g.
+// it is about to be created) has nothing to adopt.
+func adoptExisting(ctx *pulumi.Context, cfg Config, cluster *clusterpkg.Cluster, previousDeployment *previous.Deployment) (*adopt.Namespaces, *adopt.Linkerd, error) {
+	var adopted []string
+	if _, err := previousDeployment.Output(adopt.NamespacesOutput, &adopted); err != nil {
+		return nil, nil, err
+	}
+	var installed map[string]bool
+	if _, err := previousDeployment.Output("components", &installed); err != nil {
+		return nil, nil, err
+	}
+
+	found := &adopt.Cluster{}
+	client, err := kube.NewClientset(cluster.KubeconfigPath, cluster.KubeContext)
+	if err == nil {
+		inspectCtx, cancel := context.WithTimeout(context.Background(), adoptTimeout)
+		defer cancel()
+		found, err = adopt.Inspect(inspectCtx, client)
+	}
+	if err != nil {
+		_ = ctx.Log.Debug(fmt.Sprintf("not adopting existing objects, cluster %s can't be inspected: %v", cfg.Cluster.Name, err), nil)
+		found = &adopt.Cluster{}
+	}
+
+	for _, ns := range cfg.Namespaces {
+		labels, ok := found.Namespaces[ns.Name]
+		if !ok {
+			continue
+		}
+		required := map[string]string{}
+		for key, value := range ns.Labels {
+			required[key] = value
+		}
+		for key, value := range cfg.Cluster.ResourceLabels {
+			required[key] = value
+		}
+		if conflicts := adopt.LabelConflicts(labels, required); len(conflicts) > 0 {
+			return nil, nil, fmt.Errorf("namespace %s already exists with other labels: %s, relabel it or change %s:namespaces",
+				ns.Name, strings.Join(conflicts, ", "), configNamespace)
+		}
+	}
+	namespaces, err := adopt.RegisterNamespaces(ctx, found.Namespaces, adopted)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cfg.Components.Flux && !installed["flux"] && found.Flux != nil {
+		version := found.Flux.Version
+		if version != "" && strings.TrimPrefix(version, "v") != strings.TrimPrefix(cfg.Flux.Version, "v") {
+			return nil, nil, fmt.Errorf("Flux %s is already installed in namespace %s, not %s: set %s:fluxVersion=%[1]s to adopt it, "+
+				"or uninstall it first with `flux uninstall`", version, fluxpkg.Namespace, cfg.Flux.Version, configNamespace)
+		}
+		_ = ctx.Log.Warn(fmt.Sprintf("adopting the Flux installation in namespace %s, which already exists: flux %s completes it",
+			fluxpkg.Namespace, cfg.Flux.Mode), nil)
+	}
+
+	var existingLinkerd *adopt.Linkerd
+	if cfg.Components.Linkerd && !installed["linkerd"] {
+		existingLinkerd = found.Linkerd
+	}
+	return namespaces, existingLinkerd, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"cluster-studio/pkg/flagger"
+	"cluster-studio/pkg/kube"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// runCanaryDemo rolls the demo podinfo through a Canary once Flagger and its
+// load tester are installed and returns the outcome as a structured output.
+// A canary that isn't promoted fails the update.
+func runCanaryDemo(ctx *pulumi.Context, kubeconfig pulumi.StringOutput, install *flagger.Install, flaggerCfg *FlaggerConfig) pulumi.Output {
+	demoCfg := flaggerCfg.Demo
+	return pulumi.All(kubeconfig, install.LoadtesterURL, install.Version).ApplyT(func(args []interface{}) (map[string]interface{}, error) {
+		if ctx.DryRun() {
+			return map[string]interface{}{}, nil
+		}
+
+		client, err := kube.NewClientsetFromKubeconfig(args[0].(string))
+		if err != nil {
+			return nil, err
+		}
+		dynamicClient, err := kube.NewDynamicClientFromKubeconfig(args[0].(string))
+		if err != nil {
+			return nil, err
+		}
+		result, err := flagger.RunDemo(context.Background(), client, dynamicClient, flagger.DemoOptions{
+			MeshProvider:  flaggerCfg.MeshProvider,
+			LoadtesterURL: args[1].(string),
+			Analysis:      demoCfg.Analysis,
+			Timeout:       demoCfg.Timeout,
+			Keep:          demoCfg.Keep,
+			Logf: func(format string, a ...interface{}) {
+				_ = ctx.Log.Info(fmt.Sprintf(format, a...), nil)
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("canary demo: %w", err)
+		}
+		_ = ctx.Log.Info(fmt.Sprintf("canary demo promoted %s in %s", result.To, result.Duration), nil)
+		return jsonMap(result)
+	})
+}
@@ -0,0 +1,17 @@
+// pulumi-resource-homekind is the resource provider of the Kind clusters of
+// the stacks, loaded by the engine from the bin directory (see Pulumi.yaml)
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"cluster-studio/pkg/kind"
+)
+
+func main() {
+	if err := kind.ServeProvider(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", kind.ProviderName, err)
+		os.Exit(1)
+	}
+}
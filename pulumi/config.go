@@ -0,0 +1,4624 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	clusterpkg "cluster-studio/internal/cluster"
+	"cluster-studio/internal/gitops"
+	"cluster-studio/internal/mesh"
+	"cluster-studio/internal/observability"
+	"cluster-studio/pkg/ciaccess"
+	"cluster-studio/pkg/cloudflare"
+	"cluster-studio/pkg/cnpg"
+	"cluster-studio/pkg/coredns"
+	"cluster-studio/pkg/credentials"
+	"cluster-studio/pkg/externaldns"
+	"cluster-studio/pkg/externalsecrets"
+	"cluster-studio/pkg/flagger"
+	fluxpkg "cluster-studio/pkg/flux"
+	"cluster-studio/pkg/grafana"
+	"cluster-studio/pkg/infra"
+	"cluster-studio/pkg/istio"
+	"cluster-studio/pkg/k3d"
+	"cluster-studio/pkg/keda"
+	"cluster-studio/pkg/kind"
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/kyverno"
+	"cluster-studio/pkg/linkerd"
+	"cluster-studio/pkg/logging"
+	metallbpkg "cluster-studio/pkg/metallb"
+	"cluster-studio/pkg/metricsserver"
+	"cluster-studio/pkg/minio"
+	"cluster-studio/pkg/monitoring"
+	"cluster-studio/pkg/netpol"
+	"cluster-studio/pkg/notify"
+	"cluster-studio/pkg/portforward"
+	"cluster-studio/pkg/shell"
+	"cluster-studio/pkg/smoketest"
+	"cluster-studio/pkg/stacks"
+	"cluster-studio/pkg/tracing"
+	"cluster-studio/pkg/uptimekuma"
+	"cluster-studio/pkg/velero"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// configNamespace is the Pulumi config namespace used for all keys (e.g. home:clusterName)
+const configNamespace = "home"
+
+// Stack profiles of home:profile, whose defaults each key still overrides
+const (
+	// profileCI is a throwaway cluster testing the manifests of a branch: a
+	// single Kind node with Flux only, destroyed once home:ttl has elapsed
+	profileCI = "ci"
+)
+
+// defaultCITTL is used when home:ttl is not set on a ci stack
+const defaultCITTL = 4 * time.Hour
+
+// defaultMemberStacks are the stacks an umbrella stack deploys when
+// home:clusters is not set
+var defaultMemberStacks = map[string][]string{
+	"all": {"studio", "homelab"},
+}
+
+// Config is the complete configuration of a stack
+type Config struct {
+	// Stacks deployed by this umbrella stack, nil for a stack with its own
+	// cluster. The other settings are not loaded for an umbrella stack.
+	Members *MembersConfig
+	// home:profile, empty for the default one
+	Profile string
+	// How long after its creation a ci stack is destroyed, 0 to keep it
+	TTL      time.Duration
+	Cluster  *ClusterConfig
+	Timeouts *TimeoutsConfig
+	Retry    *shell.RetryPolicy
+	Flux     *gitops.FluxConfig
+	// Reconcile failures pushed by the notification-controller (optional)
+	FluxAlerts *FluxAlertsConfig
+	// Image tags bumped in the repository by Flux (optional)
+	FluxImageAutomation *FluxImageAutomationConfig
+	// Web UI of Flux, with home:enableFluxUI
+	FluxUI        *FluxUIConfig
+	Git           *gitops.GitConfig
+	Linkerd       *mesh.LinkerdConfig
+	MetalLB       *MetalLBConfig
+	Ingress       *IngressConfig
+	Monitoring    *MonitoringConfig
+	Logging       *LoggingConfig
+	Tracing       *TracingConfig
+	MinIO         *MinIOConfig
+	Velero        *VeleroConfig
+	Postgres      *PostgresConfig
+	SealedSecrets *SealedSecretsConfig
+	// External Secrets Operator and the Secrets materialized from config
+	ExternalSecrets *ExternalSecretsConfig
+	Kyverno         *KyvernoConfig
+	MetricsServer   *MetricsServerConfig
+	// KEDA and the ScaledObjects created from config
+	KEDA *KEDAConfig
+	// Blackbox exporter and its probes
+	Blackbox *observability.BlackboxConfig
+	// Uptime Kuma and where its data lives
+	UptimeKuma *observability.UptimeKumaConfig
+	// Images loaded into the Kind nodes before anything is installed, nil
+	// when none is
+	Preload *PreloadConfig
+	// Images built from the repository for the workloads of the
+	// infrastructure and of the extra kustomize directories
+	LocalImages []kind.LocalImage
+	// ServiceAccounts with scoped kubeconfigs for CI, nil when none is
+	CIAccounts []ciaccess.Account
+	// Read-only kubeconfig for dashboards, nil when disabled
+	Viewer *ViewerConfig
+	// Local CA and wildcard certificate of ingress-nginx, nil when disabled
+	LocalTLS *LocalTLSConfig
+	// Hostnames CoreDNS resolves to in-cluster Services, nil when unset
+	DNSRewrites coredns.Hosts
+	// Grafana dashboards of home:grafanaDashboardsDir, nil when unset
+	GrafanaDashboards []grafana.Dashboard
+	Flagger           *FlaggerConfig
+	CertManager       *CertManagerConfig
+	Components        *ComponentsConfig
+	// Pre-install checks of the cluster
+	ClusterChecks *ClusterChecksConfig
+	// Service mesh the platform runs on: "linkerd", "istio" or "none"
+	Mesh        string
+	Istio       *IstioConfig
+	Tunnel      *TunnelConfig
+	DDNS        *DDNSConfig
+	ExternalDNS *ExternalDNSConfig
+	Namespaces  []NamespaceConfig
+	// Default-deny NetworkPolicies of the namespaces
+	NetworkPolicies *NetworkPolicyConfig
+	ServiceURLs     []kube.ServiceRef
+	// Port forwards left running after the deploy (none by default)
+	PortForwards []portforward.Forward
+	SmokeTests   *SmokeTestsConfig
+	// Where the JSON deployment report is written
+	ReportPath string
+	// Lines of stdout and stderr per provisioning step kept in the
+	// provisionLogs output, 0 to leave it out
+	ProvisionLogLines int
+	// Counter of home:rotate, bumping it generates the passwords again
+	Rotate int
+	// linkerd-multicluster and the link to the peer stack, nil when disabled
+	LinkerdMulticluster *LinkerdMulticlusterConfig
+	// Meshed sample workload verifying the injection, nil when disabled
+	MeshSample *MeshSampleConfig
+	// linkerd-jaeger and where the proxies send their spans, nil when
+	// disabled
+	LinkerdJaeger *LinkerdJaegerConfig
+	// Minimum version of each CLI checked by the preflight
+	ToolVersions map[string]string
+	// Skip the validation of the Kind config and the kustomizations
+	SkipValidation bool
+}
+
+// loadConfig reads the whole stack configuration and validates it, see
+// Config.Validate. The problems of every loader are reported at once; a
+// loader whose inputs failed to load is skipped.
+func loadConfig(ctx *pulumi.Context) (Config, error) {
+	var cfg Config
+	var err error
+
+	// The members of an umbrella stack deploy a cluster each
+	if stacks.MemberOf(ctx) == nil {
+		if cfg.Members, err = loadMembersConfig(ctx); err != nil || cfg.Members != nil {
+			return cfg, err
+		}
+	}
+
+	var problems []string
+	loaded := func(err error) bool {
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		return err == nil
+	}
+
+	cfg.Profile, cfg.TTL, err = loadProfile(ctx)
+	loaded(err)
+	cfg.Cluster, err = loadClusterConfig(ctx, cfg.Profile)
+	clusterLoaded := loaded(err)
+	cfg.Timeouts, err = loadTimeoutsConfig(ctx)
+	loaded(err)
+	cfg.Retry, err = loadRetryPolicy(ctx)
+	loaded(err)
+	cfg.Flux, err = loadFluxConfig(ctx)
+	fluxLoaded := loaded(err)
+	gitLoaded := false
+	if clusterLoaded {
+		cfg.Git, err = loadGitConfig(ctx, cfg.Cluster)
+		gitLoaded = loaded(err)
+	}
+	cfg.Linkerd, err = loadLinkerdConfig(ctx)
+	linkerdLoaded := loaded(err)
+	metallbLoaded := false
+	if clusterLoaded {
+		cfg.MetalLB, err = loadMetalLBConfig(ctx, cfg.Cluster)
+		metallbLoaded = loaded(err)
+	}
+	cfg.CertManager, err = loadCertManagerConfig(ctx)
+	loaded(err)
+	cfg.Monitoring, err = loadMonitoringConfig(ctx)
+	loaded(err)
+	cfg.Logging, err = loadLoggingConfig(ctx)
+	loaded(err)
+	cfg.Tracing, err = loadTracingConfig(ctx)
+	tracingLoaded := loaded(err)
+	cfg.MinIO, err = loadMinIOConfig(ctx)
+	loaded(err)
+	cfg.Components = loadComponentsConfig(ctx, cfg.Profile)
+	cfg.Ingress, err = loadIngressConfig(ctx, cfg.Components)
+	loaded(err)
+	cfg.Velero, err = loadVeleroConfig(ctx, cfg.Components)
+	loaded(err)
+	cfg.Postgres, err = loadPostgresConfig(ctx)
+	postgresLoaded := loaded(err)
+	cfg.SealedSecrets, err = loadSealedSecretsConfig(ctx)
+	loaded(err)
+	cfg.ExternalSecrets, err = loadExternalSecretsConfig(ctx)
+	externalSecretsLoaded := loaded(err)
+	if clusterLoaded {
+		cfg.MetricsServer, err = loadMetricsServerConfig(ctx, cfg.Cluster)
+		loaded(err)
+	}
+	cfg.Kyverno, err = loadKyvernoConfig(ctx)
+	loaded(err)
+	cfg.KEDA, err = loadKEDAConfig(ctx, cfg.Components)
+	loaded(err)
+	cfg.Blackbox, err = loadBlackboxConfig(ctx, cfg.Components)
+	loaded(err)
+	if fluxLoaded {
+		cfg.FluxAlerts, err = loadFluxAlertsConfig(ctx, cfg.Components, cfg.Flux)
+		loaded(err)
+	}
+	if fluxLoaded && gitLoaded {
+		cfg.FluxImageAutomation, err = loadFluxImageAutomationConfig(ctx, cfg.Components, cfg.Flux, cfg.Git)
+		loaded(err)
+	}
+	cfg.FluxUI, err = loadFluxUIConfig(ctx, cfg.Components)
+	loaded(err)
+	cfg.GrafanaDashboards, err = loadGrafanaDashboards(ctx, cfg.Components)
+	loaded(err)
+	if clusterLoaded {
+		cfg.Preload, err = loadPreloadConfig(ctx, cfg.Cluster, cfg.Components)
+		loaded(err)
+		cfg.LocalImages, err = loadLocalImages(ctx, cfg.Cluster)
+		loaded(err)
+	}
+	cfg.CIAccounts, err = loadCIAccounts(ctx)
+	loaded(err)
+	cfg.Viewer, err = loadViewerConfig(ctx)
+	loaded(err)
+	cfg.LocalTLS, err = loadLocalTLSConfig(ctx, cfg.Components)
+	loaded(err)
+	cfg.DNSRewrites, err = loadDNSRewrites(ctx)
+	loaded(err)
+	cfg.Mesh, err = loadMeshConfig(ctx, cfg.Components)
+	meshLoaded := loaded(err)
+	if clusterLoaded && linkerdLoaded {
+		cfg.LinkerdMulticluster, err = loadLinkerdMulticlusterConfig(ctx, cfg.Cluster, cfg.Linkerd, cfg.Components)
+		loaded(err)
+	}
+	cfg.MeshSample, err = loadMeshSampleConfig(ctx, cfg.Components)
+	loaded(err)
+	if linkerdLoaded && tracingLoaded {
+		cfg.LinkerdJaeger, err = loadLinkerdJaegerConfig(ctx, cfg.Linkerd, cfg.Tracing, cfg.Components)
+		loaded(err)
+	}
+	cfg.ClusterChecks, err = loadClusterChecksConfig(ctx)
+	loaded(err)
+	cfg.Istio, err = loadIstioConfig(ctx)
+	loaded(err)
+	if meshLoaded {
+		cfg.Flagger, err = loadFlaggerConfig(ctx, cfg.Mesh, cfg.Components)
+		loaded(err)
+	}
+	if clusterLoaded {
+		cfg.Tunnel, err = loadTunnelConfig(ctx, cfg.Cluster, cfg.Components)
+		if loaded(err) {
+			cfg.UptimeKuma, err = loadUptimeKumaConfig(ctx, cfg.Components, cfg.Cluster, cfg.Tunnel)
+			loaded(err)
+		}
+	}
+	cfg.DDNS, err = loadDDNSConfig(ctx)
+	ddnsLoaded := loaded(err)
+	if clusterLoaded {
+		cfg.ExternalDNS, err = loadExternalDNSConfig(ctx, cfg.Cluster, cfg.Components)
+		loaded(err)
+	}
+	if ddnsLoaded && postgresLoaded && externalSecretsLoaded {
+		cfg.Namespaces, err = loadNamespacesConfig(ctx, cfg.Components, cfg.DDNS, cfg.Postgres, cfg.ExternalSecrets)
+		if loaded(err) {
+			cfg.NetworkPolicies, err = loadNetworkPolicyConfig(ctx, cfg.Namespaces)
+			loaded(err)
+		}
+	}
+	cfg.ServiceURLs, err = loadServiceURLsConfig(ctx)
+	loaded(err)
+	cfg.PortForwards, err = loadPortForwardsConfig(ctx)
+	loaded(err)
+	cfg.SmokeTests, err = loadSmokeTestsConfig(ctx)
+	loaded(err)
+	cfg.ReportPath, err = loadReportPath(ctx)
+	loaded(err)
+	cfg.Rotate, err = loadRotate(ctx)
+	loaded(err)
+	cfg.ProvisionLogLines, err = loadProvisionLogLines(ctx)
+	loaded(err)
+	cfg.ToolVersions, err = loadToolVersions(ctx)
+	loaded(err)
+	cfg.SkipValidation = getBool(newConfig(ctx), "skipValidation", false)
+
+	// The cross-field checks need the settings they compare
+	if clusterLoaded && fluxLoaded && gitLoaded && metallbLoaded {
+		problems = append(problems, cfg.problems()...)
+	}
+	return cfg, configError(problems)
+}
+
+// Validate checks the settings that constrain each other across components
+// and reports every violation at once, with the keys to set. The settings of
+// a single component are checked when they are loaded.
+func (cfg Config) Validate() error {
+	return configError(cfg.problems())
+}
+
+// configError reports the configuration problems, nil without any
+func configError(problems []string) error {
+	switch len(problems) {
+	case 0:
+		return nil
+	case 1:
+		return errors.New(problems[0])
+	}
+	return fmt.Errorf("%d configuration problems:\n  - %s", len(problems), strings.Join(problems, "\n  - "))
+}
+
+// problems lists the violations of the cross-field checks, see Validate
+func (cfg Config) problems() []string {
+	var problems []string
+	components := cfg.Components
+
+	if components.LinkerdViz && !components.Linkerd {
+		problems = append(problems, fmt.Sprintf("%[1]s:enableLinkerdViz requires %[1]s:mesh=linkerd, set %[1]s:enableLinkerdViz=false", configNamespace))
+	}
+
+	if cfg.Git.Token == nil {
+		if cfg.Flux.Mode == "bootstrap" {
+			problems = append(problems, fmt.Sprintf("%[1]s:fluxMode=bootstrap requires the secret %[1]s:gitToken", configNamespace))
+		}
+		if cfg.Git.Auth == "https" && cfg.Git.Sync {
+			problems = append(problems, fmt.Sprintf("%[1]s:gitAuth=https requires the secret %[1]s:gitToken", configNamespace))
+		}
+	}
+	// The age key is the secret home:sopsAgeKey, generated when not set
+	if cfg.Git.SOPS && (cfg.Flux.Mode == "bootstrap" || !cfg.Git.Sync) {
+		problems = append(problems, fmt.Sprintf("%[1]s:fluxSops requires %[1]s:fluxMode=install and %[1]s:fluxSync=true", configNamespace))
+	}
+	if cfg.Git.AgeKey != nil && !cfg.Git.SOPS {
+		problems = append(problems, fmt.Sprintf("%[1]s:sopsAgeKey requires %[1]s:fluxSops=true", configNamespace))
+	}
+	// flux bootstrap pushes to the branch
+	if cfg.Git.Ref != "" && cfg.Flux.Mode == "bootstrap" {
+		problems = append(problems, fmt.Sprintf("%[1]s:gitRef requires %[1]s:fluxMode=install", configNamespace))
+	}
+
+	if cfg.TTL > 0 && cfg.Cluster.Protect {
+		problems = append(problems, fmt.Sprintf("%[1]s:ttl destroys the stack, which is protected: set %[1]s:ttl=0 or unprotect it", configNamespace))
+	}
+
+	if len(cfg.MetalLB.Addresses) > 0 {
+		if !components.MetalLB {
+			problems = append(problems, fmt.Sprintf("%[1]s:metallbRange requires %[1]s:enableMetallb", configNamespace))
+		}
+		problems = append(problems, metallbRangeProblems(cfg.MetalLB, cfg.Cluster)...)
+	}
+	return problems
+}
+
+// MembersConfig describes the clusters of an umbrella stack
+type MembersConfig struct {
+	// Stacks of this project whose clusters it deploys, each after the ones
+	// it depends on
+	Stacks []string
+	// Stack -> stacks it is deployed after, e.g. the peer it links to
+	Dependencies map[string][]string
+	// Stack -> configuration of its cluster, read from <stack>:key then from
+	// the shared home:key
+	Configs map[string]Config
+}
+
+// loadMembersConfig reads home:clusters, the stacks of this project whose
+// clusters an umbrella stack deploys, home:clusterDependencies, and the
+// configuration of each cluster. It returns nil for a stack deploying its
+// own cluster.
+func loadMembersConfig(ctx *pulumi.Context) (*MembersConfig, error) {
+	cfg := newConfig(ctx)
+
+	membersCfg := &MembersConfig{}
+	err := cfg.TryObject("clusters", &membersCfg.Stacks)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:clusters: %w", configNamespace, err)
+	}
+	if err != nil {
+		membersCfg.Stacks = defaultMemberStacks[ctx.Stack()]
+	}
+	err = cfg.TryObject("clusterDependencies", &membersCfg.Dependencies)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:clusterDependencies: %w", configNamespace, err)
+	}
+	if len(membersCfg.Stacks) == 0 {
+		if len(membersCfg.Dependencies) > 0 {
+			return nil, fmt.Errorf("%[1]s:clusterDependencies requires %[1]s:clusters", configNamespace)
+		}
+		return nil, nil
+	}
+
+	known := make(map[string]bool, len(membersCfg.Stacks))
+	for _, stack := range membersCfg.Stacks {
+		switch {
+		case stack == "":
+			return nil, fmt.Errorf("invalid %s:clusters: empty stack name", configNamespace)
+		case stack == ctx.Stack():
+			return nil, fmt.Errorf("invalid %s:clusters: %s is this stack", configNamespace, stack)
+		case stack == "clusters":
+			return nil, fmt.Errorf("invalid %s:clusters: clusters is the output listing them", configNamespace)
+		case known[stack]:
+			return nil, fmt.Errorf("invalid %s:clusters: %s is listed twice", configNamespace, stack)
+		}
+		known[stack] = true
+	}
+	// The names of the resources of a member start with <member>-
+	for _, stack := range membersCfg.Stacks {
+		for _, other := range membersCfg.Stacks {
+			if strings.HasPrefix(other, stack+"-") {
+				return nil, fmt.Errorf("invalid %s:clusters: %s starts with %s-, which prefixes the resources of %[3]s", configNamespace, other, stack)
+			}
+		}
+	}
+	for stack, deps := range membersCfg.Dependencies {
+		for _, dep := range append([]string{stack}, deps...) {
+			if !known[dep] {
+				return nil, fmt.Errorf("invalid %s:clusterDependencies: %s is not in %s:clusters", configNamespace, dep, configNamespace)
+			}
+		}
+	}
+
+	// Each stack after its dependencies
+	const (
+		visiting = iota + 1
+		done
+	)
+	state := map[string]int{}
+	var order []string
+	var visit func(stack string, path []string) error
+	visit = func(stack string, path []string) error {
+		switch state[stack] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("invalid %s:clusterDependencies: cycle %s", configNamespace, strings.Join(append(path, stack), " -> "))
+		}
+		state[stack] = visiting
+		for _, dep := range membersCfg.Dependencies[stack] {
+			if err := visit(dep, append(path, stack)); err != nil {
+				return err
+			}
+		}
+		state[stack] = done
+		order = append(order, stack)
+		return nil
+	}
+	for _, stack := range membersCfg.Stacks {
+		if err := visit(stack, nil); err != nil {
+			return nil, err
+		}
+	}
+	membersCfg.Stacks = order
+
+	membersCfg.Configs = make(map[string]Config, len(order))
+	// Cluster name -> member deploying it
+	clusters := make(map[string]string, len(order))
+	for _, stack := range order {
+		memberCfg, err := loadConfig(stacks.With(ctx, &stacks.Member{Name: stack}))
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", stack, err)
+		}
+		if other, ok := clusters[memberCfg.Cluster.Name]; ok {
+			return nil, fmt.Errorf("clusters %s and %s are both named %s, set %[2]s:clusterName", other, stack, memberCfg.Cluster.Name)
+		}
+		clusters[memberCfg.Cluster.Name] = stack
+		membersCfg.Configs[stack] = memberCfg
+	}
+	return membersCfg, nil
+}
+
+// loadProfile reads home:profile and the home:ttl of a ci stack
+func loadProfile(ctx *pulumi.Context) (string, time.Duration, error) {
+	cfg := newConfig(ctx)
+
+	profile := cfg.Get("profile")
+	switch profile {
+	case "":
+		if cfg.Get("ttl") != "" {
+			return "", 0, fmt.Errorf("%[1]s:ttl requires %[1]s:profile=%[2]s", configNamespace, profileCI)
+		}
+		return profile, 0, nil
+	case profileCI:
+	default:
+		return "", 0, fmt.Errorf("invalid %s:profile %q, use %q or leave it unset", configNamespace, profile, profileCI)
+	}
+
+	ttl, err := getDuration(cfg, "ttl", defaultCITTL)
+	if err != nil {
+		return "", 0, err
+	}
+	if ttl < 0 {
+		return "", 0, fmt.Errorf("invalid %s:ttl %s, use a positive duration or 0 to keep the stack", configNamespace, ttl)
+	}
+	// The teardown destroys the stack, the other members with it
+	if member := stacks.Prefix(ctx); member != "" && ttl > 0 {
+		return "", 0, fmt.Errorf("cluster %[1]s of umbrella stack %[2]s can't expire, set %[1]s:ttl=0", member, ctx.Stack())
+	}
+	return profile, ttl, nil
+}
+
+// metallbRangeProblems checks that each range of home:metallbRange is a CIDR
+// or a start-end range inside the Docker network of the nodes, when it could
+// be inspected, and that on a generated or given Kind config it is outside
+// the pod and Service subnets. MetalLB would announce unreachable addresses.
+func metallbRangeProblems(metallbCfg *MetalLBConfig, clusterCfg *ClusterConfig) []string {
+	var subnets []netip.Prefix
+	if clusterCfg.Backend == "kind" && clusterCfg.KindConfig != "" {
+		if networking, err := kind.ParseNetworking(clusterCfg.KindConfig); err == nil {
+			for _, cidr := range strings.Split(networking.PodSubnet+","+networking.ServiceSubnet, ",") {
+				if prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr)); err == nil {
+					subnets = append(subnets, prefix.Masked())
+				}
+			}
+		}
+	}
+
+	var problems []string
+	for _, addresses := range metallbCfg.Addresses {
+		first, last, err := metallbpkg.ParseRange(addresses)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid %s:metallbRange %q: %v", configNamespace, addresses, err))
+			continue
+		}
+		if metallbCfg.Subnets != "" {
+			outside, err := metallbpkg.RangesOutside([]string{addresses}, metallbCfg.Subnets)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("can't check %s:metallbRange against Docker network %s: %v", configNamespace, metallbCfg.Network, err))
+			} else if len(outside) > 0 {
+				problems = append(problems, fmt.Sprintf("%s:metallbRange %s is outside Docker network %s (%s), use addresses of the network of the nodes",
+					configNamespace, addresses, metallbCfg.Network, metallbCfg.Subnets))
+			}
+		}
+		for _, subnet := range subnets {
+			if subnet.Contains(first) || subnet.Contains(last) || (first.Less(subnet.Addr()) && subnet.Addr().Less(last)) {
+				problems = append(problems, fmt.Sprintf("%s:metallbRange %s overlaps the Kind pod or Service subnet %s, use addresses of the Docker network of the nodes",
+					configNamespace, addresses, subnet))
+			}
+		}
+	}
+	return problems
+}
+
+// defaultMinToolVersions are overridden per tool by home:toolVersions
+var defaultMinToolVersions = map[string]string{
+	"kind":    "0.20.0",
+	"k3d":     "5.0.0",
+	"flux":    "2.2.0",
+	"kubectl": "1.27.0",
+}
+
+// loadToolVersions returns the minimum version of each CLI, home:toolVersions
+// over defaultMinToolVersions
+func loadToolVersions(ctx *pulumi.Context) (map[string]string, error) {
+	cfg := newConfig(ctx)
+
+	minVersions := map[string]string{}
+	for tool, version := range defaultMinToolVersions {
+		minVersions[tool] = version
+	}
+	var overrides map[string]string
+	err := cfg.TryObject("toolVersions", &overrides)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:toolVersions: %w", configNamespace, err)
+	}
+	for tool, version := range overrides {
+		minVersions[tool] = version
+	}
+	return minVersions, nil
+}
+
+// ClusterConfig describes the cluster managed by the current stack and how
+// the infrastructure tree is applied to it
+type ClusterConfig struct {
+	clusterpkg.Config
+	// Directory with the infrastructure kustomization
+	InfraDir string
+	// Infrastructure component -> components it must be applied after,
+	// merged over infra.DefaultDependencies
+	InfraDependencies map[string][]string
+	// Never delete in-cluster resources, only the cluster itself. Also keeps
+	// objects removed from or replaced by the program in the cluster.
+	FastDestroy bool
+	// Registry host -> mirror URL configured in containerd on every node
+	RegistryMirrors map[string]string
+	// Stack whose registry mirrors, Prometheus and Loki this one uses, and
+	// whose cluster it links to (home:linkerdMulticlusterLink)
+	// (optional), see peer.go
+	PeerStack string
+	// Run a Docker Hub pull-through cache used as the docker.io mirror
+	PullThroughCache bool
+	// Labels set on every object applied from the infrastructure tree
+	ResourceLabels map[string]string
+	// Namespaces of the infrastructure tree meshed by the selected mesh
+	InjectNamespaces []string
+	// Address of the machine on the LAN, the server of the lanKubeconfig
+	// output (home:lanAddress)
+	LANAddress string
+	// Diff the infrastructure tree against the cluster object by object
+	// during previews (slow on big trees)
+	PreviewDiff bool
+	// Kustomizations applied after the infrastructure (e.g. per-machine overlays)
+	ExtraKustomizeDirs []infra.Directory
+	// Apply the HelmRepositories and CRDs of the tree first and the other
+	// components once they are Ready (requires Flux)
+	InfraPrerequisites bool
+	// HelmRepositories and CRD manifests (files, directories or URLs)
+	// established with those of the tree
+	HelmRepositories []fluxpkg.HelmRepository
+	CRDManifests     []string
+	// Pass the host's NVIDIA GPUs to a Kind node and install the device plugin
+	GPU bool
+	// Chart version of the NVIDIA device plugin
+	GPUDevicePluginVersion string
+	// Host directories mounted into the Kind nodes and offered as
+	// PersistentVolumes of StorageClass
+	PersistentVolumes []kind.Volume
+	StorageClass      string
+}
+
+// defaultGPUDevicePluginVersion is used when home:gpuDevicePluginVersion is not set
+const defaultGPUDevicePluginVersion = "0.17.4"
+
+// defaultCiliumVersion is used when home:ciliumVersion is not set
+const defaultCiliumVersion = "1.18.2"
+
+// stackDefaults holds the built-in cluster definitions for the known stacks
+var stackDefaults = map[string]ClusterConfig{
+	"studio": {
+		Config: clusterpkg.Config{
+			Name: "studio",
+			Kind: kind.Config{Workers: 1},
+		},
+		InfraDir: "../flux/clusters/studio/infrastructure",
+	},
+	"homelab": {
+		Config: clusterpkg.Config{
+			Name:    "homelab",
+			Kind:    kind.Config{Workers: 4},
+			Protect: true,
+		},
+		InfraDir: "../flux/clusters/homelab/infrastructure",
+	},
+}
+
+// loadClusterConfig reads the cluster definition from Pulumi config, falling
+// back to the built-in defaults for known stacks. A ci stack defaults to a
+// single node named after the stack, and to home:fastDestroy.
+func loadClusterConfig(ctx *pulumi.Context, profile string) (*ClusterConfig, error) {
+	cfg := newConfig(ctx)
+	stack := stacks.Stack(ctx)
+	defaults := stackDefaults[stack]
+	if profile == profileCI {
+		defaults.Kind.Workers = 0
+		if defaults.Name == "" {
+			defaults.Name = stack
+			defaults.InfraDir = fmt.Sprintf("../flux/clusters/%s/infrastructure", stack)
+		}
+	}
+
+	clusterCfg := &ClusterConfig{
+		Config: clusterpkg.Config{
+			Name:           cfg.Get("clusterName"),
+			Backend:        cfg.Get("clusterBackend"),
+			Provision:      getBool(cfg, "provisionCluster", true),
+			KubeconfigPath: cfg.Get("kubeconfigPath"),
+			KubeContext:    cfg.Get("kubeContext"),
+			KindConfigPath: cfg.Get("kindConfigPath"),
+			NodeImage:      cfg.Get("nodeImage"),
+			Recreate:       cfg.GetBool("recreateCluster"),
+			AllowRecreate:  cfg.GetBool("allowRecreate"),
+		},
+		InfraDir:               cfg.Get("infraDir"),
+		PreviewDiff:            cfg.GetBool("previewDiff"),
+		InfraPrerequisites:     getBool(cfg, "infraPrerequisites", true),
+		FastDestroy:            getBool(cfg, "fastDestroy", profile == profileCI && getBool(cfg, "provisionCluster", true)),
+		PeerStack:              cfg.Get("peerStack"),
+		PullThroughCache:       cfg.GetBool("pullThroughCache"),
+		GPU:                    cfg.GetBool("enableGpu"),
+		GPUDevicePluginVersion: cfg.Get("gpuDevicePluginVersion"),
+		StorageClass:           cfg.Get("storageClass"),
+	}
+
+	if clusterCfg.Backend == "" {
+		clusterCfg.Backend = "kind"
+	}
+	if clusterCfg.Backend != "kind" && clusterCfg.Backend != "k3d" {
+		return nil, fmt.Errorf("invalid %s:clusterBackend %q, use \"kind\" or \"k3d\"", configNamespace, clusterCfg.Backend)
+	}
+	if clusterCfg.Backend != "kind" && clusterCfg.KindConfigPath != "" {
+		return nil, fmt.Errorf("%[1]s:kindConfigPath requires %[1]s:clusterBackend=kind", configNamespace)
+	}
+
+	// The GPUs reach the cluster through the Kind nodes
+	if clusterCfg.GPU && (clusterCfg.Backend != "kind" || !clusterCfg.Provision) {
+		return nil, fmt.Errorf("%[1]s:enableGpu requires %[1]s:clusterBackend=kind and %[1]s:provisionCluster=true", configNamespace)
+	}
+	if clusterCfg.GPUDevicePluginVersion == "" {
+		clusterCfg.GPUDevicePluginVersion = defaultGPUDevicePluginVersion
+	}
+	if err := loadCNIConfig(cfg, clusterCfg); err != nil {
+		return nil, err
+	}
+
+	protect, err := loadProtect(ctx, cfg, defaults.Protect)
+	if err != nil {
+		return nil, err
+	}
+	clusterCfg.Protect = protect
+	clusterCfg.ProtectedByDefault = defaults.Protect
+
+	if !clusterCfg.Provision && clusterCfg.FastDestroy {
+		return nil, fmt.Errorf("%[1]s:fastDestroy requires %[1]s:provisionCluster=true, it would leave everything in the existing cluster", configNamespace)
+	}
+
+	// A provisioned cluster gets a kubeconfig file of its own, so the
+	// default one and its current context are never touched
+	if clusterCfg.Provision && clusterCfg.KubeconfigPath == "" {
+		clusterCfg.KubeconfigPath = filepath.Join("~", ".kube", fmt.Sprintf("home-%s.yaml", stack))
+	}
+	if clusterCfg.KubeconfigPath != "" {
+		path, err := expandPath(clusterCfg.KubeconfigPath)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s:kubeconfigPath: %w", configNamespace, err)
+		}
+		clusterCfg.KubeconfigPath = path
+	}
+
+	if !clusterCfg.Provision {
+		// Fail early on a missing file or context, and pin the current context
+		_, kubeContext, err := kube.LoadKubeconfig(clusterCfg.KubeconfigPath, clusterCfg.KubeContext)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %[1]s:kubeconfigPath or %[1]s:kubeContext: %[2]w", configNamespace, err)
+		}
+		clusterCfg.KubeContext = kubeContext
+	} else if clusterCfg.KubeContext != "" {
+		return nil, fmt.Errorf("%[1]s:kubeContext requires %[1]s:provisionCluster=false", configNamespace)
+	}
+
+	if version := cfg.Get("kubernetesVersion"); version != "" && clusterCfg.NodeImage == "" {
+		nodeImage := kind.NodeImage
+		if clusterCfg.Backend == "k3d" {
+			nodeImage = k3d.NodeImage
+		}
+		image, err := nodeImage(version)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s:kubernetesVersion: %w", configNamespace, err)
+		}
+		clusterCfg.NodeImage = image
+	}
+
+	var missing []string
+	if clusterCfg.Name == "" {
+		clusterCfg.Name = defaults.Name
+		if clusterCfg.Name == "" {
+			missing = append(missing, configNamespace+":clusterName")
+		}
+	}
+	if clusterCfg.InfraDir == "" {
+		clusterCfg.InfraDir = defaults.InfraDir
+		if clusterCfg.InfraDir == "" {
+			missing = append(missing, configNamespace+":infraDir")
+		}
+	}
+
+	clusterCfg.InfraDependencies = make(map[string][]string, len(infra.DefaultDependencies))
+	for component, deps := range infra.DefaultDependencies {
+		clusterCfg.InfraDependencies[component] = deps
+	}
+	var infraDependencies map[string][]string
+	err = cfg.TryObject("infraDependencies", &infraDependencies)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:infraDependencies: %w", configNamespace, err)
+	}
+	for component, deps := range infraDependencies {
+		clusterCfg.InfraDependencies[component] = deps
+	}
+
+	// The standard labels can be overridden but not removed
+	clusterCfg.ResourceLabels = map[string]string{
+		"app.kubernetes.io/managed-by": "pulumi",
+		"home.lucena.cloud/stack":      stack,
+	}
+	var resourceLabels map[string]string
+	err = cfg.TryObject("resourceLabels", &resourceLabels)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:resourceLabels: %w", configNamespace, err)
+	}
+	for key, value := range resourceLabels {
+		clusterCfg.ResourceLabels[key] = value
+	}
+
+	// home:linkerdInjectNamespaces predates home:mesh and is still read
+	for _, key := range []string{"meshInjectNamespaces", "linkerdInjectNamespaces"} {
+		err = cfg.TryObject(key, &clusterCfg.InjectNamespaces)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, config.ErrMissingVar) {
+			return nil, fmt.Errorf("invalid %s:%s: %w", configNamespace, key, err)
+		}
+	}
+
+	extraDirs, err := loadExtraKustomizeDirs(cfg)
+	if err != nil {
+		return nil, err
+	}
+	clusterCfg.ExtraKustomizeDirs = extraDirs
+
+	if err := loadInfraPrerequisites(cfg, clusterCfg); err != nil {
+		return nil, err
+	}
+
+	if err := loadPersistentVolumes(cfg, clusterCfg); err != nil {
+		return nil, err
+	}
+
+	if err := loadNodeResources(cfg, clusterCfg); err != nil {
+		return nil, err
+	}
+
+	err = cfg.TryObject("registryMirrors", &clusterCfg.RegistryMirrors)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:registryMirrors: %w", configNamespace, err)
+	}
+	if clusterCfg.PeerStack == stack || clusterCfg.PeerStack == fmt.Sprintf("%s/%s/%s", ctx.Organization(), ctx.Project(), stack) {
+		return nil, fmt.Errorf("invalid %s:peerStack: %s is this stack", configNamespace, clusterCfg.PeerStack)
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("stack %q has no built-in cluster definition, set the following config keys: %s",
+			stack, strings.Join(missing, ", "))
+	}
+
+	if err := loadKindConfig(cfg, clusterCfg, defaults.Kind); err != nil {
+		return nil, err
+	}
+
+	return clusterCfg, nil
+}
+
+// loadCNIConfig reads home:cni: "default" keeps the CNI of the backend,
+// "cilium" creates the Kind cluster without kindnet and installs Cilium, with
+// home:ciliumVersion, home:ciliumKubeProxyReplacement and home:ciliumHubbleUi
+func loadCNIConfig(cfg *homeConfig, clusterCfg *ClusterConfig) error {
+	switch cni := cfg.Get("cni"); cni {
+	case "", "default":
+		for _, key := range []string{"ciliumKubeProxyReplacement", "ciliumHubbleUi"} {
+			if cfg.GetBool(key) {
+				return fmt.Errorf("%[1]s:%[2]s requires %[1]s:cni=cilium", configNamespace, key)
+			}
+		}
+		return nil
+	case "cilium":
+	default:
+		return fmt.Errorf("invalid %s:cni %q, use \"default\" or \"cilium\"", configNamespace, cni)
+	}
+
+	// The nodes are created without a CNI through the Kind config
+	if clusterCfg.Backend != "kind" || !clusterCfg.Provision {
+		return fmt.Errorf("%[1]s:cni=cilium requires %[1]s:clusterBackend=kind and %[1]s:provisionCluster=true", configNamespace)
+	}
+	clusterCfg.CNI = &clusterpkg.CNIConfig{
+		Version:              cfg.Get("ciliumVersion"),
+		KubeProxyReplacement: cfg.GetBool("ciliumKubeProxyReplacement"),
+		HubbleUI:             cfg.GetBool("ciliumHubbleUi"),
+	}
+	if clusterCfg.CNI.Version == "" {
+		clusterCfg.CNI.Version = defaultCiliumVersion
+	}
+	return nil
+}
+
+// loadProtect decides whether the stack is protected. home:protect turns
+// protection on, but a stack protected by default (homelab) can only be
+// unprotected with home:confirmDestroy set to its name, so a destroy meant
+// for another stack can't slip through.
+func loadProtect(ctx *pulumi.Context, cfg *homeConfig, protectByDefault bool) (bool, error) {
+	stack := stacks.Stack(ctx)
+	protect := getBool(cfg, "protect", protectByDefault)
+
+	namespace, confirm := configNamespace, cfg.Get("confirmDestroy")
+	// The members of an umbrella stack are confirmed one by one
+	if cfg.member != nil {
+		namespace, confirm = cfg.prefix, cfg.member.Get("confirmDestroy")
+	}
+	switch {
+	case confirm == stack:
+		_ = ctx.Log.Warn(fmt.Sprintf("%s:confirmDestroy is set, stack %s is unprotected: run `pulumi up` to lift the protection, then `pulumi destroy`",
+			namespace, stack), nil)
+		return false, nil
+	case confirm != "":
+		return false, fmt.Errorf("%s:confirmDestroy is %q but this is stack %q", namespace, confirm, stack)
+	case protectByDefault && !protect:
+		return false, fmt.Errorf("stack %[2]s is protected, set %[1]s:confirmDestroy=%[2]s instead of %[1]s:protect=false to unprotect it",
+			namespace, stack)
+	}
+	return protect, nil
+}
+
+// loadKindConfig reads the settings of the generated Kind config and renders
+// it for the kind backend, or reads the file set with home:kindConfigPath
+func loadKindConfig(cfg *homeConfig, clusterCfg *ClusterConfig, defaults kind.Config) error {
+	networking := kind.Networking{
+		IPFamily:      cfg.Get("ipFamily"),
+		PodSubnet:     cfg.Get("podSubnet"),
+		ServiceSubnet: cfg.Get("serviceSubnet"),
+	}
+	if networking != (kind.Networking{}) {
+		if clusterCfg.Backend != "kind" || !clusterCfg.Provision || clusterCfg.KindConfigPath != "" {
+			return fmt.Errorf("%[1]s:ipFamily, %[1]s:podSubnet and %[1]s:serviceSubnet only apply to the generated Kind config, set them in the networking of %[1]s:kindConfigPath instead", configNamespace)
+		}
+		// Checked before the cluster is created with them, they can't change afterwards
+		if err := networking.Validate(); err != nil {
+			return fmt.Errorf("invalid Kind networking (%[1]s:ipFamily, %[1]s:podSubnet, %[1]s:serviceSubnet): %[2]w", configNamespace, err)
+		}
+	}
+
+	apiServer, err := loadAPIServerConfig(cfg, clusterCfg)
+	if err != nil {
+		return err
+	}
+
+	if clusterCfg.KindConfigPath != "" {
+		data, err := os.ReadFile(clusterCfg.KindConfigPath)
+		if err != nil {
+			return fmt.Errorf("failed to read %s:kindConfigPath: %w", configNamespace, err)
+		}
+		clusterCfg.KindConfig = string(data)
+		if clusterCfg.CNI != nil && !strings.Contains(clusterCfg.KindConfig, "disableDefaultCNI: true") {
+			return fmt.Errorf("%[1]s:cni=cilium requires networking.disableDefaultCNI: true in %[1]s:kindConfigPath", configNamespace)
+		}
+		return nil
+	}
+
+	clusterCfg.Kind = kind.Config{
+		Name:         clusterCfg.Name,
+		Workers:      defaults.Workers,
+		PortMappings: kind.DefaultPortMappings,
+		GPU:          clusterCfg.GPU,
+		Networking:   networking,
+
+		APIServerAddress: apiServer.Address,
+		APIServerPort:    apiServer.Port,
+	}
+	if clusterCfg.LANAddress != "" {
+		clusterCfg.Kind.APIServerCertSANs = []string{clusterCfg.LANAddress}
+	}
+	if clusterCfg.CNI != nil {
+		clusterCfg.Kind.DisableDefaultCNI = true
+		// Kind doesn't install kube-proxy, Cilium takes over Services
+		if clusterCfg.CNI.KubeProxyReplacement {
+			clusterCfg.Kind.KubeProxyMode = "none"
+		}
+	}
+
+	workers, err := cfg.TryInt("kindWorkers")
+	if err == nil {
+		clusterCfg.Kind.Workers = workers
+	} else if !errors.Is(err, config.ErrMissingVar) {
+		return fmt.Errorf("invalid %s:kindWorkers: %w", configNamespace, err)
+	}
+	err = cfg.TryObject("extraPortMappings", &clusterCfg.Kind.PortMappings)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return fmt.Errorf("invalid %s:extraPortMappings: %w", configNamespace, err)
+	}
+	err = cfg.TryObject("extraMounts", &clusterCfg.Kind.Mounts)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return fmt.Errorf("invalid %s:extraMounts: %w", configNamespace, err)
+	}
+	err = cfg.TryObject("featureGates", &clusterCfg.Kind.FeatureGates)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return fmt.Errorf("invalid %s:featureGates: %w", configNamespace, err)
+	}
+	for _, volume := range clusterCfg.PersistentVolumes {
+		clusterCfg.Kind.Mounts = append(clusterCfg.Kind.Mounts, volume.Mount())
+	}
+
+	if clusterCfg.Backend != "kind" {
+		return nil
+	}
+	clusterCfg.KindConfig, err = clusterCfg.Kind.Render()
+	if err != nil {
+		return fmt.Errorf("invalid Kind settings: %w", err)
+	}
+	return nil
+}
+
+// apiServerConfig is the host address and port the API server of a Kind
+// cluster is published on
+type apiServerConfig struct {
+	Address string
+	Port    int
+}
+
+// loadAPIServerConfig reads the fixed address and port of the API server and
+// the LAN address of the machine the lanKubeconfig output points to
+func loadAPIServerConfig(cfg *homeConfig, clusterCfg *ClusterConfig) (apiServerConfig, error) {
+	apiServer := apiServerConfig{Address: cfg.Get("apiServerAddress")}
+	port, err := cfg.TryInt("apiServerPort")
+	if err == nil {
+		if port < 1 || port > 65535 {
+			return apiServer, fmt.Errorf("invalid %s:apiServerPort %d, use a port between 1 and 65535", configNamespace, port)
+		}
+		apiServer.Port = port
+	} else if !errors.Is(err, config.ErrMissingVar) {
+		return apiServer, fmt.Errorf("invalid %s:apiServerPort: %w", configNamespace, err)
+	}
+	clusterCfg.LANAddress = cfg.Get("lanAddress")
+	if apiServer.Address == "" && apiServer.Port == 0 && clusterCfg.LANAddress == "" {
+		return apiServer, nil
+	}
+
+	if clusterCfg.Backend != "kind" || !clusterCfg.Provision || clusterCfg.KindConfigPath != "" {
+		return apiServer, fmt.Errorf("%[1]s:apiServerAddress, %[1]s:apiServerPort and %[1]s:lanAddress only apply to the generated Kind config, set networking.apiServerAddress and apiServerPort in %[1]s:kindConfigPath instead", configNamespace)
+	}
+	if apiServer.Address != "" && net.ParseIP(apiServer.Address) == nil {
+		return apiServer, fmt.Errorf("invalid %s:apiServerAddress %q, use an IP address", configNamespace, apiServer.Address)
+	}
+	if clusterCfg.LANAddress == "" {
+		return apiServer, nil
+	}
+
+	if net.ParseIP(clusterCfg.LANAddress) == nil {
+		return apiServer, fmt.Errorf("invalid %s:lanAddress %q, use an IP address", configNamespace, clusterCfg.LANAddress)
+	}
+	// A random port changes whenever the cluster is created
+	if apiServer.Port == 0 {
+		return apiServer, fmt.Errorf("%[1]s:lanAddress requires %[1]s:apiServerPort", configNamespace)
+	}
+	// Published on every interface unless bound to the LAN address itself
+	if apiServer.Address == "" {
+		apiServer.Address = "0.0.0.0"
+	}
+	if apiServer.Address != "0.0.0.0" && apiServer.Address != clusterCfg.LANAddress {
+		return apiServer, fmt.Errorf("%[1]s:apiServerAddress %[2]s isn't reachable on %[1]s:lanAddress %[3]s, use 0.0.0.0 or %[3]s", configNamespace, apiServer.Address, clusterCfg.LANAddress)
+	}
+	return apiServer, nil
+}
+
+const (
+	// defaultFluxVersion is used when home:fluxVersion is not set
+	defaultFluxVersion = "v2.6.4"
+	// defaultReconcileTimeout is used when home:reconcileTimeout is not set
+	defaultReconcileTimeout = 10 * time.Minute
+)
+
+// loadFluxConfig reads the Flux installation settings from Pulumi config
+func loadFluxConfig(ctx *pulumi.Context) (*gitops.FluxConfig, error) {
+	cfg := newConfig(ctx)
+
+	fluxCfg := &gitops.FluxConfig{
+		Mode:    cfg.Get("fluxMode"),
+		Version: cfg.Get("fluxVersion"),
+	}
+	if fluxCfg.Mode == "" {
+		fluxCfg.Mode = "install"
+	}
+	if fluxCfg.Mode != "install" && fluxCfg.Mode != "bootstrap" {
+		return nil, fmt.Errorf("invalid %s:fluxMode %q, use \"install\" or \"bootstrap\"", configNamespace, fluxCfg.Mode)
+	}
+	if fluxCfg.Version == "" {
+		fluxCfg.Version = defaultFluxVersion
+	}
+	if !strings.HasPrefix(fluxCfg.Version, "v") {
+		fluxCfg.Version = "v" + fluxCfg.Version
+	}
+
+	err := cfg.TryObject("fluxComponents", &fluxCfg.Components)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:fluxComponents: %w", configNamespace, err)
+	}
+
+	fluxCfg.ReconcileTimeout, err = getDuration(cfg, "reconcileTimeout", defaultReconcileTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return fluxCfg, nil
+}
+
+// FluxAlertsConfig describes the notification-controller Provider and Alert
+type FluxAlertsConfig struct {
+	// Provider type, empty when the alerts are disabled
+	Type string
+	// Webhook URL (Slack, Discord) and bot token (Telegram), secrets
+	Address pulumi.StringOutput
+	Token   pulumi.StringOutput
+	// Channel, the chat ID for Telegram
+	Channel string
+	// Lowest severity sent: "info" or "error"
+	Severity string
+	// Namespaces whose Kustomizations and HelmReleases are watched
+	Namespaces []string
+}
+
+// loadFluxAlertsConfig reads home:fluxAlertProvider (slack, discord or
+// telegram, empty disables the alerts), its secret home:fluxAlertAddress
+// (Slack and Discord webhook URL) or home:fluxAlertToken (Telegram bot token),
+// home:fluxAlertChannel (the Telegram chat ID), home:fluxAlertSeverity
+// (default error) and home:fluxAlertNamespaces (default flux-system)
+func loadFluxAlertsConfig(ctx *pulumi.Context, components *ComponentsConfig, fluxCfg *gitops.FluxConfig) (*FluxAlertsConfig, error) {
+	cfg := newConfig(ctx)
+
+	alertsCfg := &FluxAlertsConfig{
+		Type:     cfg.Get("fluxAlertProvider"),
+		Channel:  cfg.Get("fluxAlertChannel"),
+		Severity: cfg.Get("fluxAlertSeverity"),
+	}
+	if alertsCfg.Type == "" {
+		return alertsCfg, nil
+	}
+	if !components.Flux {
+		return nil, fmt.Errorf("%[1]s:fluxAlertProvider requires %[1]s:enableFlux", configNamespace)
+	}
+	if len(fluxCfg.Components) > 0 && !slices.Contains(fluxCfg.Components, "notification-controller") {
+		return nil, fmt.Errorf("%[1]s:fluxAlertProvider requires the notification-controller in %[1]s:fluxComponents", configNamespace)
+	}
+
+	switch alertsCfg.Type {
+	case fluxpkg.AlertSlack, fluxpkg.AlertDiscord:
+		if cfg.Get("fluxAlertAddress") == "" {
+			return nil, fmt.Errorf("%[1]s:fluxAlertProvider %[2]s requires the webhook URL, set it with: pulumi config set --secret %[1]s:fluxAlertAddress <url>",
+				configNamespace, alertsCfg.Type)
+		}
+		alertsCfg.Address = cfg.GetSecret("fluxAlertAddress")
+	case fluxpkg.AlertTelegram:
+		if cfg.Get("fluxAlertToken") == "" || alertsCfg.Channel == "" {
+			return nil, fmt.Errorf("%[1]s:fluxAlertProvider telegram requires the bot token (pulumi config set --secret %[1]s:fluxAlertToken <token>) and the chat ID in %[1]s:fluxAlertChannel",
+				configNamespace)
+		}
+		alertsCfg.Token = cfg.GetSecret("fluxAlertToken")
+	default:
+		return nil, fmt.Errorf("invalid %s:fluxAlertProvider %q, use %s, %s or %s", configNamespace, alertsCfg.Type,
+			fluxpkg.AlertSlack, fluxpkg.AlertDiscord, fluxpkg.AlertTelegram)
+	}
+
+	if alertsCfg.Severity == "" {
+		alertsCfg.Severity = "error"
+	}
+	if alertsCfg.Severity != "info" && alertsCfg.Severity != "error" {
+		return nil, fmt.Errorf("invalid %s:fluxAlertSeverity %q, use info or error", configNamespace, alertsCfg.Severity)
+	}
+
+	err := cfg.TryObject("fluxAlertNamespaces", &alertsCfg.Namespaces)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:fluxAlertNamespaces: %w", configNamespace, err)
+	}
+	if len(alertsCfg.Namespaces) == 0 {
+		alertsCfg.Namespaces = []string{fluxpkg.Namespace}
+	}
+
+	return alertsCfg, nil
+}
+
+// FluxImageAutomationConfig describes the images whose tags Flux bumps in the
+// repository
+type FluxImageAutomationConfig struct {
+	// Automated images, empty when the automation is disabled
+	Images []fluxpkg.AutomatedImage
+	// HTTPS URL and branch of the repository the tags are pushed to
+	URL    string
+	Branch string
+	// Username and token with write access to the repository (secret)
+	Username string
+	Token    pulumi.StringInput
+	// Author of the commits
+	AuthorName  string
+	AuthorEmail string
+	// How often the automations run
+	Interval string
+}
+
+// loadFluxImageAutomationConfig reads home:fluxImageAutomation, the images
+// ({name, image, semver, path, interval}) whose tags Flux bumps in the setter
+// markers of their path (default home:gitPath). The commits are pushed with
+// the secret home:fluxImageAutomationToken (default home:gitToken) by
+// home:fluxImageAutomationAuthor ({name, email}) every
+// home:fluxImageAutomationInterval (default 30m). The image controllers are
+// added to home:fluxComponents.
+func loadFluxImageAutomationConfig(ctx *pulumi.Context, components *ComponentsConfig, fluxCfg *gitops.FluxConfig, gitCfg *gitops.GitConfig) (*FluxImageAutomationConfig, error) {
+	cfg := newConfig(ctx)
+
+	automationCfg := &FluxImageAutomationConfig{
+		Branch:   gitCfg.Branch,
+		Username: gitCfg.Username,
+		Interval: cfg.Get("fluxImageAutomationInterval"),
+	}
+	err := cfg.TryObject("fluxImageAutomation", &automationCfg.Images)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:fluxImageAutomation: %w", configNamespace, err)
+	}
+	if len(automationCfg.Images) == 0 {
+		return automationCfg, nil
+	}
+	if !components.Flux {
+		return nil, fmt.Errorf("%[1]s:fluxImageAutomation requires %[1]s:enableFlux", configNamespace)
+	}
+
+	names := map[string]bool{}
+	for i := range automationCfg.Images {
+		image := &automationCfg.Images[i]
+		if msgs := validation.IsDNS1123Label(image.Name); len(msgs) > 0 {
+			return nil, fmt.Errorf("invalid name %q in %s:fluxImageAutomation: %s", image.Name, configNamespace, strings.Join(msgs, ", "))
+		}
+		if names[image.Name] {
+			return nil, fmt.Errorf("duplicate name %q in %s:fluxImageAutomation", image.Name, configNamespace)
+		}
+		names[image.Name] = true
+		if image.Image == "" || image.Semver == "" {
+			return nil, fmt.Errorf("%s in %s:fluxImageAutomation requires an image and a semver range", image.Name, configNamespace)
+		}
+		if strings.Contains(image.Image[strings.LastIndex(image.Image, "/")+1:], ":") {
+			return nil, fmt.Errorf("image %s of %s in %s:fluxImageAutomation must not have a tag", image.Image, image.Name, configNamespace)
+		}
+		if image.Path == "" {
+			image.Path = gitCfg.Path
+		}
+		if image.Interval != "" {
+			if _, err := time.ParseDuration(image.Interval); err != nil {
+				return nil, fmt.Errorf("invalid interval %q of %s in %s:fluxImageAutomation: %w", image.Interval, image.Name, configNamespace, err)
+			}
+		}
+	}
+
+	if automationCfg.Interval == "" {
+		automationCfg.Interval = "30m"
+	}
+	if _, err := time.ParseDuration(automationCfg.Interval); err != nil {
+		return nil, fmt.Errorf("invalid %s:fluxImageAutomationInterval: %w", configNamespace, err)
+	}
+
+	// The tags are pushed over HTTPS, whatever the sync authenticates with
+	automationCfg.URL = gitCfg.URL
+	if !strings.HasPrefix(automationCfg.URL, "https://") {
+		automationCfg.URL = fmt.Sprintf("https://github.com/%s/%s", gitCfg.Owner, gitCfg.Repository)
+	}
+	switch {
+	case cfg.Get("fluxImageAutomationToken") != "":
+		automationCfg.Token = cfg.GetSecret("fluxImageAutomationToken")
+	case gitCfg.Token != nil:
+		automationCfg.Token = gitCfg.Token
+	default:
+		return nil, fmt.Errorf("%[1]s:fluxImageAutomation requires a token with write access to the repository, set it with: "+
+			"pulumi config set --secret %[1]s:fluxImageAutomationToken <token>", configNamespace)
+	}
+
+	var author struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	err = cfg.TryObject("fluxImageAutomationAuthor", &author)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:fluxImageAutomationAuthor: %w", configNamespace, err)
+	}
+	automationCfg.AuthorName, automationCfg.AuthorEmail = author.Name, author.Email
+	if automationCfg.AuthorName == "" {
+		automationCfg.AuthorName = "fluxcdbot"
+	}
+	if automationCfg.AuthorEmail == "" {
+		automationCfg.AuthorEmail = "fluxcdbot@users.noreply.github.com"
+	}
+
+	// Installed along with the other controllers
+	if len(fluxCfg.Components) == 0 {
+		fluxCfg.Components = append([]string{}, fluxpkg.DefaultComponents...)
+	}
+	for _, component := range fluxpkg.ImageAutomationComponents {
+		if !slices.Contains(fluxCfg.Components, component) {
+			fluxCfg.Components = append(fluxCfg.Components, component)
+		}
+	}
+
+	return automationCfg, nil
+}
+
+// FluxUIConfig describes the web UI of Flux
+type FluxUIConfig struct {
+	// fluxpkg.UICapacitor or fluxpkg.UIWeaveGitOps
+	UI string
+	// Capacitor release or Weave GitOps chart version
+	Version string
+	// Host of an Ingress routing to the UI, empty for none
+	Host string
+}
+
+const (
+	// defaultCapacitorVersion is used when home:fluxUIVersion is not set
+	defaultCapacitorVersion = "v0.4.8"
+	// defaultWeaveGitOpsVersion is used when home:fluxUIVersion is not set
+	defaultWeaveGitOpsVersion = "4.0.36"
+)
+
+// loadFluxUIConfig reads home:fluxUI (capacitor, the default, or
+// weave-gitops), home:fluxUIVersion and home:fluxUIHost, the host of an
+// Ingress which requires home:enableIngress. The UI requires
+// home:enableFluxUI and Flux.
+func loadFluxUIConfig(ctx *pulumi.Context, components *ComponentsConfig) (*FluxUIConfig, error) {
+	cfg := newConfig(ctx)
+
+	uiCfg := &FluxUIConfig{
+		UI:      cfg.Get("fluxUI"),
+		Version: cfg.Get("fluxUIVersion"),
+		Host:    cfg.Get("fluxUIHost"),
+	}
+	if !components.FluxUI {
+		if uiCfg.UI != "" || uiCfg.Version != "" || uiCfg.Host != "" {
+			return nil, fmt.Errorf("%[1]s:fluxUI, %[1]s:fluxUIVersion and %[1]s:fluxUIHost require %[1]s:enableFluxUI", configNamespace)
+		}
+		return uiCfg, nil
+	}
+	if !components.Flux {
+		return nil, fmt.Errorf("%[1]s:enableFluxUI requires %[1]s:enableFlux", configNamespace)
+	}
+
+	switch uiCfg.UI {
+	case "", fluxpkg.UICapacitor:
+		uiCfg.UI = fluxpkg.UICapacitor
+		if uiCfg.Version == "" {
+			uiCfg.Version = defaultCapacitorVersion
+		}
+	case fluxpkg.UIWeaveGitOps:
+		if uiCfg.Version == "" {
+			uiCfg.Version = defaultWeaveGitOpsVersion
+		}
+	default:
+		return nil, fmt.Errorf("invalid %s:fluxUI %q, use %s or %s", configNamespace, uiCfg.UI, fluxpkg.UICapacitor, fluxpkg.UIWeaveGitOps)
+	}
+
+	if uiCfg.Host != "" {
+		if !components.Ingress {
+			return nil, fmt.Errorf("%[1]s:fluxUIHost requires %[1]s:enableIngress", configNamespace)
+		}
+		if msgs := validation.IsDNS1123Subdomain(uiCfg.Host); len(msgs) > 0 {
+			return nil, fmt.Errorf("invalid %s:fluxUIHost %q: %s", configNamespace, uiCfg.Host, strings.Join(msgs, ", "))
+		}
+	}
+
+	return uiCfg, nil
+}
+
+const (
+	// defaultGitHTTPSURL and defaultGitSSHURL point at this repository
+	defaultGitHTTPSURL = "https://github.com/brunovlucena/home"
+	defaultGitSSHURL   = "ssh://git@github.com/brunovlucena/home"
+)
+
+// gitCommitPattern matches the full SHA-1 of a commit
+var gitCommitPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// loadGitConfig reads the Flux sync settings from Pulumi config.
+// home:gitRef, a reference or a commit, is synced instead of home:gitBranch.
+func loadGitConfig(ctx *pulumi.Context, clusterCfg *ClusterConfig) (*gitops.GitConfig, error) {
+	cfg := newConfig(ctx)
+
+	gitCfg := &gitops.GitConfig{
+		Sync:       getBool(cfg, "fluxSync", true),
+		URL:        cfg.Get("gitUrl"),
+		Branch:     cfg.Get("gitBranch"),
+		Ref:        cfg.Get("gitRef"),
+		Path:       cfg.Get("gitPath"),
+		Interval:   cfg.Get("gitInterval"),
+		Auth:       cfg.Get("gitAuth"),
+		Username:   cfg.Get("gitUsername"),
+		KnownHosts: cfg.Get("gitKnownHosts"),
+		Owner:      cfg.Get("gitOwner"),
+		Repository: cfg.Get("gitRepository"),
+		Personal:   getBool(cfg, "gitPersonal", true),
+		SOPS:       getBool(cfg, "fluxSops", false),
+	}
+	if gitCfg.Owner == "" {
+		gitCfg.Owner = "brunovlucena"
+	}
+	if gitCfg.Repository == "" {
+		gitCfg.Repository = "home"
+	}
+	if gitCfg.Auth == "" {
+		gitCfg.Auth = "none"
+	}
+	if gitCfg.Branch == "" {
+		gitCfg.Branch = "main"
+	}
+	if gitCfg.Path == "" {
+		gitCfg.Path = fmt.Sprintf("./flux/clusters/%s", clusterCfg.Name)
+	}
+	if gitCfg.Interval == "" {
+		gitCfg.Interval = "10m"
+	}
+	if gitCfg.Username == "" {
+		gitCfg.Username = "git"
+	}
+	if gitCfg.KnownHosts == "" {
+		gitCfg.KnownHosts = fluxpkg.GitHubKnownHosts
+	}
+
+	switch gitCfg.Auth {
+	case "none", "https":
+		if gitCfg.URL == "" {
+			gitCfg.URL = defaultGitHTTPSURL
+		}
+	case "ssh":
+		if gitCfg.URL == "" {
+			gitCfg.URL = defaultGitSSHURL
+		}
+	default:
+		return nil, fmt.Errorf("invalid %s:gitAuth %q, use \"none\", \"https\" or \"ssh\"", configNamespace, gitCfg.Auth)
+	}
+
+	if gitCfg.Ref != "" && !strings.HasPrefix(gitCfg.Ref, "refs/") && !gitCommitPattern.MatchString(gitCfg.Ref) {
+		return nil, fmt.Errorf("invalid %s:gitRef %q, use a full reference (e.g. refs/pull/12/head or refs/tags/v1.0.0) or a commit SHA",
+			configNamespace, gitCfg.Ref)
+	}
+	if cfg.Get("gitToken") != "" {
+		gitCfg.Token = cfg.GetSecret("gitToken")
+	}
+	if cfg.Get("sopsAgeKey") != "" {
+		if _, err := fluxpkg.AgeRecipient(cfg.Get("sopsAgeKey")); err != nil {
+			return nil, fmt.Errorf("invalid %s:sopsAgeKey: %w", configNamespace, err)
+		}
+		gitCfg.AgeKey = cfg.GetSecret("sopsAgeKey")
+	}
+
+	return gitCfg, nil
+}
+
+const (
+	// defaultLinkerdVersion is used when home:linkerdVersion is not set
+	defaultLinkerdVersion = "2025.9.2"
+	// defaultTrustAnchorValidity is used when home:linkerdTrustAnchorValidity is not set
+	defaultTrustAnchorValidity = 10 * 365 * 24 * time.Hour
+	// defaultIssuerValidity is used when home:linkerdIssuerValidity is not set
+	defaultIssuerValidity = 365 * 24 * time.Hour
+)
+
+// loadLinkerdConfig reads the Linkerd installation settings from Pulumi config
+func loadLinkerdConfig(ctx *pulumi.Context) (*mesh.LinkerdConfig, error) {
+	cfg := newConfig(ctx)
+
+	linkerdCfg := &mesh.LinkerdConfig{
+		InstallMethod:     cfg.Get("linkerdInstallMethod"),
+		Version:           cfg.Get("linkerdVersion"),
+		TrustAnchorPEM:    cfg.Get("linkerdTrustAnchorPEM"),
+		TrustAnchorKeyPEM: cfg.Get("linkerdTrustAnchorKeyPEM"),
+		RotateIssuer:      cfg.GetBool("rotateIssuer"),
+		CheckSeverity:     cfg.Get("linkerdCheckSeverity"),
+		HA:                cfg.GetBool("linkerdHA"),
+	}
+	if linkerdCfg.InstallMethod == "" {
+		linkerdCfg.InstallMethod = "helm"
+	}
+	if linkerdCfg.InstallMethod != "helm" && linkerdCfg.InstallMethod != "script" {
+		return nil, fmt.Errorf("invalid %s:linkerdInstallMethod %q, use \"helm\" or \"script\"",
+			configNamespace, linkerdCfg.InstallMethod)
+	}
+	if linkerdCfg.HA && linkerdCfg.InstallMethod != "script" {
+		return nil, fmt.Errorf("%[1]s:linkerdHA requires %[1]s:linkerdInstallMethod=script", configNamespace)
+	}
+	if linkerdCfg.Version == "" {
+		linkerdCfg.Version = defaultLinkerdVersion
+	}
+	if linkerdCfg.CheckSeverity == "" {
+		linkerdCfg.CheckSeverity = "error"
+	}
+	if linkerdCfg.CheckSeverity != "error" && linkerdCfg.CheckSeverity != "warning" {
+		return nil, fmt.Errorf("invalid %s:linkerdCheckSeverity %q, use \"error\" or \"warning\"",
+			configNamespace, linkerdCfg.CheckSeverity)
+	}
+	if (linkerdCfg.TrustAnchorPEM == "") != (linkerdCfg.TrustAnchorKeyPEM == "") {
+		return nil, fmt.Errorf("%[1]s:linkerdTrustAnchorPEM and %[1]s:linkerdTrustAnchorKeyPEM must be set together",
+			configNamespace)
+	}
+
+	var err error
+	if linkerdCfg.TrustAnchorValidity, err = getDuration(cfg, "linkerdTrustAnchorValidity", defaultTrustAnchorValidity); err != nil {
+		return nil, err
+	}
+	if linkerdCfg.IssuerValidity, err = getDuration(cfg, "linkerdIssuerValidity", defaultIssuerValidity); err != nil {
+		return nil, err
+	}
+
+	return linkerdCfg, nil
+}
+
+// LinkerdMulticlusterConfig describes the linkerd-multicluster extension
+type LinkerdMulticlusterConfig struct {
+	// Link this cluster to the one of home:peerStack, mirroring its Services
+	Link bool
+	// Labels of the peer Services mirrored here
+	Selector map[string]string
+	// API server of the peer cluster as reached from this cluster, empty for
+	// the control plane of the peer Kind cluster on the kind network
+	APIServerAddress string
+}
+
+// MeshSampleConfig describes the sample workload deployed into an injected
+// namespace
+type MeshSampleConfig struct {
+	// podinfo image
+	Image string
+	// How long its pod may take to become Ready with the proxy
+	Timeout time.Duration
+}
+
+// loadMeshSampleConfig reads home:enableMeshSample, which deploys podinfo
+// into an injected namespace after Linkerd, with home:meshSampleImage and
+// home:meshSampleTimeout (default 3m)
+func loadMeshSampleConfig(ctx *pulumi.Context, components *ComponentsConfig) (*MeshSampleConfig, error) {
+	cfg := newConfig(ctx)
+
+	if !getBool(cfg, "enableMeshSample", false) {
+		return nil, nil
+	}
+	if !components.Linkerd {
+		return nil, fmt.Errorf("%[1]s:enableMeshSample requires Linkerd as %[1]s:mesh", configNamespace)
+	}
+
+	sampleCfg := &MeshSampleConfig{Image: cfg.Get("meshSampleImage")}
+	if sampleCfg.Image == "" {
+		sampleCfg.Image = linkerd.SampleImage
+	}
+	var err error
+	if sampleCfg.Timeout, err = getDuration(cfg, "meshSampleTimeout", 3*time.Minute); err != nil {
+		return nil, err
+	}
+	return sampleCfg, nil
+}
+
+// LinkerdJaegerConfig describes the linkerd-jaeger extension
+type LinkerdJaegerConfig struct {
+	// OTLP gRPC endpoint (host:port) of an external collector, empty for the
+	// tempo component of the infrastructure tree
+	Collector string
+}
+
+// loadLinkerdJaegerConfig reads home:enableLinkerdJaeger, which installs the
+// extension, and home:linkerdJaegerCollector
+func loadLinkerdJaegerConfig(ctx *pulumi.Context, linkerdCfg *mesh.LinkerdConfig, tracingCfg *TracingConfig, components *ComponentsConfig) (*LinkerdJaegerConfig, error) {
+	cfg := newConfig(ctx)
+
+	jaegerCfg := &LinkerdJaegerConfig{Collector: cfg.Get("linkerdJaegerCollector")}
+	if !getBool(cfg, "enableLinkerdJaeger", false) {
+		if jaegerCfg.Collector != "" {
+			return nil, fmt.Errorf("%[1]s:linkerdJaegerCollector requires %[1]s:enableLinkerdJaeger", configNamespace)
+		}
+		return nil, nil
+	}
+	if !components.Linkerd {
+		return nil, fmt.Errorf("%[1]s:enableLinkerdJaeger requires Linkerd as %[1]s:mesh", configNamespace)
+	}
+	if linkerdCfg.InstallMethod != "helm" {
+		return nil, fmt.Errorf("%[1]s:enableLinkerdJaeger requires %[1]s:linkerdInstallMethod helm", configNamespace)
+	}
+	if jaegerCfg.Collector != "" {
+		if _, port, err := net.SplitHostPort(jaegerCfg.Collector); err != nil || port == "" {
+			return nil, fmt.Errorf("invalid %s:linkerdJaegerCollector %q, use the host:port of an OTLP gRPC endpoint", configNamespace, jaegerCfg.Collector)
+		}
+	} else if components.Tracing && !slices.Contains(tracingCfg.Receivers, tracing.ReceiverOTLPGRPC) {
+		return nil, fmt.Errorf("%[1]s:enableLinkerdJaeger sends the spans to Tempo over OTLP gRPC, add %[2]s to %[1]s:tracingReceivers", configNamespace, tracing.ReceiverOTLPGRPC)
+	}
+	return jaegerCfg, nil
+}
+
+// defaultMulticlusterSelector selects the Services exported with the label
+// linkerd documents
+var defaultMulticlusterSelector = map[string]string{"mirror.linkerd.io/exported": "true"}
+
+// loadLinkerdMulticlusterConfig reads home:enableLinkerdMulticluster, which
+// installs the extension, and home:linkerdMulticlusterLink, which links to
+// the cluster of home:peerStack with home:linkerdMulticlusterSelector and
+// home:linkerdMulticlusterApiServer
+func loadLinkerdMulticlusterConfig(ctx *pulumi.Context, clusterCfg *ClusterConfig, linkerdCfg *mesh.LinkerdConfig, components *ComponentsConfig) (*LinkerdMulticlusterConfig, error) {
+	cfg := newConfig(ctx)
+
+	if !getBool(cfg, "enableLinkerdMulticluster", false) {
+		if cfg.GetBool("linkerdMulticlusterLink") {
+			return nil, fmt.Errorf("%[1]s:linkerdMulticlusterLink requires %[1]s:enableLinkerdMulticluster", configNamespace)
+		}
+		return nil, nil
+	}
+	if !components.Linkerd {
+		return nil, fmt.Errorf("%[1]s:enableLinkerdMulticluster requires Linkerd as %[1]s:mesh", configNamespace)
+	}
+	if linkerdCfg.InstallMethod != "helm" {
+		return nil, fmt.Errorf("%[1]s:enableLinkerdMulticluster requires %[1]s:linkerdInstallMethod helm", configNamespace)
+	}
+
+	multiclusterCfg := &LinkerdMulticlusterConfig{
+		Link:             cfg.GetBool("linkerdMulticlusterLink"),
+		APIServerAddress: cfg.Get("linkerdMulticlusterApiServer"),
+	}
+	if !multiclusterCfg.Link {
+		return multiclusterCfg, nil
+	}
+	if clusterCfg.PeerStack == "" {
+		return nil, fmt.Errorf("%[1]s:linkerdMulticlusterLink requires the stack to link to in %[1]s:peerStack", configNamespace)
+	}
+	if multiclusterCfg.APIServerAddress == "" && clusterCfg.Backend != "kind" {
+		return nil, fmt.Errorf("set %[1]s:linkerdMulticlusterApiServer to the API server of the peer cluster as reached from this %[2]s cluster", configNamespace, clusterCfg.Backend)
+	}
+
+	err := cfg.TryObject("linkerdMulticlusterSelector", &multiclusterCfg.Selector)
+	if errors.Is(err, config.ErrMissingVar) {
+		multiclusterCfg.Selector = defaultMulticlusterSelector
+	} else if err != nil {
+		return nil, fmt.Errorf("invalid %s:linkerdMulticlusterSelector: %w", configNamespace, err)
+	}
+	if len(multiclusterCfg.Selector) == 0 {
+		return nil, fmt.Errorf("%s:linkerdMulticlusterSelector is empty, it would mirror every Service of the peer", configNamespace)
+	}
+	for key, value := range multiclusterCfg.Selector {
+		msgs := append(validation.IsQualifiedName(key), validation.IsValidLabelValue(value)...)
+		if len(msgs) > 0 {
+			return nil, fmt.Errorf("invalid %s:linkerdMulticlusterSelector label %s=%s: %s", configNamespace, key, value, strings.Join(msgs, ", "))
+		}
+	}
+
+	return multiclusterCfg, nil
+}
+
+// TimeoutsConfig limits how long each step of the deployment may take
+type TimeoutsConfig struct {
+	// Creating (or reusing) the Kind or k3d cluster
+	ClusterCreate time.Duration
+	// Waiting for all nodes to become Ready
+	NodeReady time.Duration
+	// flux install or flux bootstrap
+	FluxInstall time.Duration
+	// Linkerd control plane (and viz) installation
+	LinkerdInstall time.Duration
+	// Each pre-install check of the cluster (flux and linkerd check --pre)
+	ClusterChecks time.Duration
+	// Each linkerd check run after the installation
+	LinkerdCheck time.Duration
+	// Waiting for the HelmRepositories and CRDs the infrastructure needs
+	InfraPrerequisites time.Duration
+	// Creating or updating each object of the infrastructure tree
+	InfraApply time.Duration
+	// Running every smoke test check
+	SmokeTests time.Duration
+	// Waiting for the nodes to report allocatable GPUs (home:enableGpu)
+	GPUCheck time.Duration
+	// Restoring home:restoreFromBackup with Velero
+	VeleroRestore time.Duration
+}
+
+// defaultTimeouts are used for the keys missing from home:timeouts
+var defaultTimeouts = TimeoutsConfig{
+	ClusterCreate:      10 * time.Minute,
+	NodeReady:          5 * time.Minute,
+	FluxInstall:        5 * time.Minute,
+	LinkerdInstall:     10 * time.Minute,
+	ClusterChecks:      5 * time.Minute,
+	LinkerdCheck:       5 * time.Minute,
+	InfraPrerequisites: 5 * time.Minute,
+	InfraApply:         10 * time.Minute,
+	SmokeTests:         5 * time.Minute,
+	GPUCheck:           5 * time.Minute,
+	VeleroRestore:      30 * time.Minute,
+}
+
+// loadTimeoutsConfig reads home:timeouts, an object of Go duration strings
+// (e.g. {"clusterCreate": "15m", "infraApply": "20m"}). The older
+// home:nodeReadyTimeout and home:linkerdTimeout keys are still honoured.
+func loadTimeoutsConfig(ctx *pulumi.Context) (*TimeoutsConfig, error) {
+	cfg := newConfig(ctx)
+
+	timeoutsCfg := defaultTimeouts
+	var err error
+	if timeoutsCfg.NodeReady, err = getDuration(cfg, "nodeReadyTimeout", timeoutsCfg.NodeReady); err != nil {
+		return nil, err
+	}
+	if timeoutsCfg.LinkerdInstall, err = getDuration(cfg, "linkerdTimeout", timeoutsCfg.LinkerdInstall); err != nil {
+		return nil, err
+	}
+
+	var timeouts map[string]string
+	err = cfg.TryObject("timeouts", &timeouts)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:timeouts: %w", configNamespace, err)
+	}
+	steps := map[string]*time.Duration{
+		"clusterCreate":      &timeoutsCfg.ClusterCreate,
+		"nodeReady":          &timeoutsCfg.NodeReady,
+		"fluxInstall":        &timeoutsCfg.FluxInstall,
+		"linkerdInstall":     &timeoutsCfg.LinkerdInstall,
+		"clusterChecks":      &timeoutsCfg.ClusterChecks,
+		"linkerdCheck":       &timeoutsCfg.LinkerdCheck,
+		"infraPrerequisites": &timeoutsCfg.InfraPrerequisites,
+		"infraApply":         &timeoutsCfg.InfraApply,
+		"smokeTests":         &timeoutsCfg.SmokeTests,
+		"gpuCheck":           &timeoutsCfg.GPUCheck,
+		"veleroRestore":      &timeoutsCfg.VeleroRestore,
+	}
+	for step, value := range timeouts {
+		timeout, ok := steps[step]
+		if !ok {
+			return nil, fmt.Errorf("unknown step %q in %s:timeouts, use clusterCreate, nodeReady, fluxInstall, linkerdInstall, clusterChecks, linkerdCheck, infraPrerequisites, infraApply, smokeTests, gpuCheck or veleroRestore",
+				step, configNamespace)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid duration %q for %s:timeouts.%s", value, configNamespace, step)
+		}
+		*timeout = d
+	}
+
+	return &timeoutsCfg, nil
+}
+
+// defaultRetryPolicy is used for the keys missing from home:retry
+var defaultRetryPolicy = shell.RetryPolicy{
+	Attempts:   3,
+	Backoff:    10 * time.Second,
+	MaxBackoff: time.Minute,
+}
+
+// loadRetryPolicy reads home:retry ({"attempts": 3, "backoff": "10s",
+// "maxBackoff": "1m"}), the policy of the flaky install steps (flux, Linkerd)
+func loadRetryPolicy(ctx *pulumi.Context) (*shell.RetryPolicy, error) {
+	cfg := newConfig(ctx)
+
+	var retry struct {
+		Attempts   *int   `json:"attempts"`
+		Backoff    string `json:"backoff"`
+		MaxBackoff string `json:"maxBackoff"`
+	}
+	err := cfg.TryObject("retry", &retry)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:retry: %w", configNamespace, err)
+	}
+
+	policy := defaultRetryPolicy
+	if retry.Attempts != nil {
+		if *retry.Attempts < 1 {
+			return nil, fmt.Errorf("invalid %s:retry.attempts %d, use 1 to disable retries", configNamespace, *retry.Attempts)
+		}
+		policy.Attempts = *retry.Attempts
+	}
+	parse := func(key, value string, target *time.Duration) error {
+		if value == "" {
+			return nil
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid duration %q for %s:retry.%s", value, configNamespace, key)
+		}
+		*target = d
+		return nil
+	}
+	if err := parse("backoff", retry.Backoff, &policy.Backoff); err != nil {
+		return nil, err
+	}
+	if err := parse("maxBackoff", retry.MaxBackoff, &policy.MaxBackoff); err != nil {
+		return nil, err
+	}
+
+	return &policy, nil
+}
+
+// MetalLBConfig describes the MetalLB installation
+type MetalLBConfig struct {
+	// Chart version
+	Version string
+	// Address ranges of the pool, derived from the cluster's Docker network when empty
+	Addresses []string
+	// Docker network of the nodes, which the ranges must be in, and its
+	// subnets (empty until the network exists)
+	Network, Subnets string
+	// How long to wait for the controller and CRDs
+	Timeout time.Duration
+}
+
+const (
+	// defaultMetalLBVersion is used when home:metallbVersion is not set
+	defaultMetalLBVersion = "0.15.2"
+	// defaultMetalLBTimeout is used when home:metallbTimeout is not set
+	defaultMetalLBTimeout = 5 * time.Minute
+)
+
+// loadMetalLBConfig reads the MetalLB settings from Pulumi config.
+// home:metallbRange is a comma separated list of ranges or CIDRs, checked
+// against the Docker network of the nodes when it already exists and
+// otherwise once the cluster created it.
+func loadMetalLBConfig(ctx *pulumi.Context, clusterCfg *ClusterConfig) (*MetalLBConfig, error) {
+	cfg := newConfig(ctx)
+
+	metallbCfg := &MetalLBConfig{
+		Version: cfg.Get("metallbVersion"),
+	}
+	if metallbCfg.Version == "" {
+		metallbCfg.Version = defaultMetalLBVersion
+	}
+	for _, addresses := range strings.Split(cfg.Get("metallbRange"), ",") {
+		if addresses = strings.TrimSpace(addresses); addresses != "" {
+			metallbCfg.Addresses = append(metallbCfg.Addresses, addresses)
+		}
+	}
+
+	var err error
+	if metallbCfg.Timeout, err = getDuration(cfg, "metallbTimeout", defaultMetalLBTimeout); err != nil {
+		return nil, err
+	}
+
+	if len(metallbCfg.Addresses) == 0 {
+		return metallbCfg, nil
+	}
+	switch {
+	case !clusterCfg.Provision:
+		_ = ctx.Log.Warn(fmt.Sprintf("%s:metallbRange can't be checked against the network of the nodes of an existing cluster, make sure the host reaches it", configNamespace), nil)
+	case clusterCfg.Backend == "k3d":
+		metallbCfg.Network = k3d.DockerNetwork(clusterCfg.Name)
+	default:
+		metallbCfg.Network = clusterCfg.DockerNetwork
+		if metallbCfg.Network == "" {
+			metallbCfg.Network = kind.DefaultNetwork
+		}
+	}
+	if metallbCfg.Network != "" {
+		if metallbCfg.Subnets, err = metallbpkg.InspectNetwork(metallbCfg.Network); err != nil {
+			_ = ctx.Log.Warn(fmt.Sprintf("%s:metallbRange is checked against Docker network %s once the cluster created it: %v", configNamespace, metallbCfg.Network, err), nil)
+		}
+	}
+
+	return metallbCfg, nil
+}
+
+// IngressConfig describes the ingress controller installation
+type IngressConfig struct {
+	// Ingress controller: nginx, envoy-gateway or none
+	Controller string
+	// Chart version of ingress-nginx
+	Version string
+	// Release of the Gateway API CRDs (envoy-gateway only)
+	GatewayAPIVersion string
+	// Chart version of Envoy Gateway (envoy-gateway only)
+	EnvoyGatewayVersion string
+	// How long to wait for the controller and its admission webhook
+	Timeout time.Duration
+}
+
+const (
+	// Ingress controllers of home:ingressController
+	ingressNginx        = "nginx"
+	ingressEnvoyGateway = "envoy-gateway"
+	ingressNone         = "none"
+
+	// defaultIngressVersion is used when home:ingressVersion is not set
+	defaultIngressVersion = "4.13.2"
+	// defaultGatewayAPIVersion is used when home:gatewayApiVersion is not set
+	defaultGatewayAPIVersion = "v1.3.0"
+	// defaultEnvoyGatewayVersion is used when home:envoyGatewayVersion is not set
+	defaultEnvoyGatewayVersion = "v1.5.1"
+	// defaultIngressTimeout is used when home:ingressTimeout is not set
+	defaultIngressTimeout = 5 * time.Minute
+)
+
+// gatewayAPIVersionPattern matches the releases of the Gateway API (v1.3.0)
+var gatewayAPIVersionPattern = regexp.MustCompile(`^v\d+\.\d+\.\d+$`)
+
+// loadIngressConfig reads home:ingressController (nginx, envoy-gateway or
+// none, defaults to nginx with home:enableIngress) and the settings of the
+// controllers. Like home:mesh, it aligns components.Ingress (ingress-nginx)
+// and components.EnvoyGateway with the controller.
+func loadIngressConfig(ctx *pulumi.Context, components *ComponentsConfig) (*IngressConfig, error) {
+	cfg := newConfig(ctx)
+
+	ingressCfg := &IngressConfig{
+		Controller:          cfg.Get("ingressController"),
+		Version:             cfg.Get("ingressVersion"),
+		GatewayAPIVersion:   cfg.Get("gatewayApiVersion"),
+		EnvoyGatewayVersion: cfg.Get("envoyGatewayVersion"),
+	}
+	switch ingressCfg.Controller {
+	case "":
+		ingressCfg.Controller = ingressNginx
+		if !components.Ingress {
+			ingressCfg.Controller = ingressNone
+		}
+	case ingressNginx:
+	case ingressEnvoyGateway, ingressNone:
+		if components.Ingress {
+			return nil, fmt.Errorf("%[1]s:ingressController is %[2]s but %[1]s:enableIngress is true, remove %[1]s:enableIngress", configNamespace, ingressCfg.Controller)
+		}
+	default:
+		return nil, fmt.Errorf("invalid %s:ingressController %q, use \"nginx\", \"envoy-gateway\" or \"none\"", configNamespace, ingressCfg.Controller)
+	}
+	components.Ingress = ingressCfg.Controller == ingressNginx
+	components.EnvoyGateway = ingressCfg.Controller == ingressEnvoyGateway
+
+	if ingressCfg.Version == "" {
+		ingressCfg.Version = defaultIngressVersion
+	}
+	if ingressCfg.GatewayAPIVersion == "" {
+		ingressCfg.GatewayAPIVersion = defaultGatewayAPIVersion
+	} else if !gatewayAPIVersionPattern.MatchString(ingressCfg.GatewayAPIVersion) {
+		return nil, fmt.Errorf("invalid %s:gatewayApiVersion %q, use a release such as %s", configNamespace, ingressCfg.GatewayAPIVersion, defaultGatewayAPIVersion)
+	}
+	if ingressCfg.EnvoyGatewayVersion == "" {
+		ingressCfg.EnvoyGatewayVersion = defaultEnvoyGatewayVersion
+	}
+	if !components.EnvoyGateway {
+		for _, key := range []string{"gatewayApiVersion", "envoyGatewayVersion"} {
+			if cfg.Get(key) != "" {
+				return nil, fmt.Errorf("%[1]s:%[2]s requires %[1]s:ingressController envoy-gateway", configNamespace, key)
+			}
+		}
+	}
+
+	var err error
+	if ingressCfg.Timeout, err = getDuration(cfg, "ingressTimeout", defaultIngressTimeout); err != nil {
+		return nil, err
+	}
+
+	return ingressCfg, nil
+}
+
+// LocalTLSConfig describes the local CA and the wildcard certificate served
+// by ingress-nginx
+type LocalTLSConfig struct {
+	// Domain suffix of the certificate (e.g. "home.local")
+	Domain string
+	// CA imported instead of generated (optional)
+	CAPEM    string
+	CAKeyPEM string
+	// Validity of a newly generated CA and certificate
+	CAValidity   time.Duration
+	CertValidity time.Duration
+	// The certificate is renewed by the deployment within this window of expiry
+	RenewBefore time.Duration
+}
+
+const (
+	// defaultLocalCAValidity is used when home:localTlsCaValidity is not set
+	defaultLocalCAValidity = 10 * 365 * 24 * time.Hour
+	// defaultLocalCertValidity is used when home:localTlsValidity is not set,
+	// below the 825 days browsers accept for certificates of local CAs
+	defaultLocalCertValidity = 365 * 24 * time.Hour
+	// defaultLocalTLSRenewBefore is used when home:localTlsRenewBefore is not set
+	defaultLocalTLSRenewBefore = 30 * 24 * time.Hour
+)
+
+// PreloadConfig describes the images loaded into the Kind nodes
+type PreloadConfig struct {
+	// Images of home:preloadImages
+	Images []string
+	// Also preload the images of the rendered infrastructure tree
+	InfraImages bool
+	// How many images are pulled and loaded at once
+	Concurrency int
+}
+
+// loadPreloadConfig reads home:preloadImages, home:preloadInfraImages and
+// home:preloadConcurrency. Preloading requires a Kind cluster created by
+// the stack.
+func loadPreloadConfig(ctx *pulumi.Context, clusterCfg *ClusterConfig, components *ComponentsConfig) (*PreloadConfig, error) {
+	cfg := newConfig(ctx)
+
+	preloadCfg := &PreloadConfig{
+		InfraImages: cfg.GetBool("preloadInfraImages"),
+		Concurrency: kind.DefaultPreloadConcurrency,
+	}
+	err := cfg.TryObject("preloadImages", &preloadCfg.Images)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:preloadImages: %w", configNamespace, err)
+	}
+	if len(preloadCfg.Images) == 0 && !preloadCfg.InfraImages {
+		if cfg.Get("preloadConcurrency") != "" {
+			return nil, fmt.Errorf("%[1]s:preloadConcurrency requires %[1]s:preloadImages or %[1]s:preloadInfraImages", configNamespace)
+		}
+		return nil, nil
+	}
+
+	if clusterCfg.Backend != "kind" || !clusterCfg.Provision {
+		return nil, fmt.Errorf("%[1]s:preloadImages and %[1]s:preloadInfraImages require %[1]s:clusterBackend=kind and %[1]s:provisionCluster=true", configNamespace)
+	}
+	if preloadCfg.InfraImages && !components.Infrastructure {
+		return nil, fmt.Errorf("%[1]s:preloadInfraImages requires %[1]s:enableInfrastructure", configNamespace)
+	}
+	for _, image := range preloadCfg.Images {
+		if image == "" || strings.ContainsAny(image, " \t\n") {
+			return nil, fmt.Errorf("invalid image %q in %s:preloadImages", image, configNamespace)
+		}
+	}
+	if value := cfg.Get("preloadConcurrency"); value != "" {
+		concurrency, err := strconv.Atoi(value)
+		if err != nil || concurrency < 1 {
+			return nil, fmt.Errorf("invalid %s:preloadConcurrency %q, use a positive number", configNamespace, value)
+		}
+		preloadCfg.Concurrency = concurrency
+	}
+
+	return preloadCfg, nil
+}
+
+var (
+	// imageNamePattern matches the repository of an image, without registry
+	imageNamePattern = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*(/[a-z0-9]+([._-][a-z0-9]+)*)*$`)
+	// imageTagPattern matches an image tag
+	imageTagPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+)
+
+// loadLocalImages reads home:localImages, a list of {"name", "context",
+// "dockerfile", "tag"} objects. The images are built and loaded into the
+// nodes of the Kind cluster the stack creates.
+func loadLocalImages(ctx *pulumi.Context, clusterCfg *ClusterConfig) ([]kind.LocalImage, error) {
+	cfg := newConfig(ctx)
+
+	var images []kind.LocalImage
+	err := cfg.TryObject("localImages", &images)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:localImages: %w", configNamespace, err)
+	}
+	if len(images) == 0 {
+		return nil, nil
+	}
+	if clusterCfg.Backend != "kind" || !clusterCfg.Provision {
+		return nil, fmt.Errorf("%[1]s:localImages requires %[1]s:clusterBackend=kind and %[1]s:provisionCluster=true", configNamespace)
+	}
+
+	names := make(map[string]bool, len(images))
+	for i := range images {
+		image := &images[i]
+		if !imageNamePattern.MatchString(image.Name) {
+			return nil, fmt.Errorf("invalid %s:localImages: entry %d has an invalid name %q, use a repository like home/api", configNamespace, i, image.Name)
+		}
+		if names[image.Name] {
+			return nil, fmt.Errorf("invalid %s:localImages: %s is listed twice", configNamespace, image.Name)
+		}
+		names[image.Name] = true
+		if image.Tag != "" && !imageTagPattern.MatchString(image.Tag) {
+			return nil, fmt.Errorf("invalid %s:localImages: %s has an invalid tag %q", configNamespace, image.Name, image.Tag)
+		}
+		if image.Context == "" {
+			return nil, fmt.Errorf("invalid %s:localImages: %s has no context", configNamespace, image.Name)
+		}
+		path, err := expandPath(image.Context)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s:localImages: %w", configNamespace, err)
+		}
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("invalid %s:localImages: the context of %s, %s, is not a directory", configNamespace, image.Name, path)
+		}
+		image.Context = path
+	}
+	return images, nil
+}
+
+// loadCIAccounts reads home:ciAccounts, a list of {"name", "rules" or
+// "clusterRole", "namespaces", "rotation"} where each rule is {"apiGroups",
+// "resources", "verbs"}
+func loadCIAccounts(ctx *pulumi.Context) ([]ciaccess.Account, error) {
+	cfg := newConfig(ctx)
+
+	var accounts []ciaccess.Account
+	err := cfg.TryObject("ciAccounts", &accounts)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:ciAccounts: %w", configNamespace, err)
+	}
+
+	names := make(map[string]bool, len(accounts))
+	for i, account := range accounts {
+		if msgs := validation.IsDNS1123Label(account.Name); len(msgs) > 0 {
+			return nil, fmt.Errorf("invalid %s:ciAccounts: entry %d has an invalid name %q: %s", configNamespace, i, account.Name, strings.Join(msgs, ", "))
+		}
+		if names[account.Name] {
+			return nil, fmt.Errorf("invalid %s:ciAccounts: %s is listed twice", configNamespace, account.Name)
+		}
+		names[account.Name] = true
+		if account.ClusterRole != "" && len(account.Rules) > 0 {
+			return nil, fmt.Errorf("invalid %s:ciAccounts: %s has both rules and a clusterRole", configNamespace, account.Name)
+		}
+		if account.ClusterRole == "" && len(account.Rules) == 0 {
+			return nil, fmt.Errorf("invalid %s:ciAccounts: %s has no rules", configNamespace, account.Name)
+		}
+		for j, rule := range account.Rules {
+			if len(rule.Resources) == 0 || len(rule.Verbs) == 0 {
+				return nil, fmt.Errorf("invalid %s:ciAccounts: rule %d of %s needs resources and verbs", configNamespace, j, account.Name)
+			}
+		}
+		for _, namespace := range account.Namespaces {
+			if msgs := validation.IsDNS1123Label(namespace); len(msgs) > 0 {
+				return nil, fmt.Errorf("invalid %s:ciAccounts: %s has an invalid namespace %q", configNamespace, account.Name, namespace)
+			}
+		}
+		if account.Rotation < 0 {
+			return nil, fmt.Errorf("invalid %s:ciAccounts: the rotation of %s is negative", configNamespace, account.Name)
+		}
+	}
+	return accounts, nil
+}
+
+// ViewerConfig is the read-only kubeconfig of a machine showing dashboards
+type ViewerConfig struct {
+	// Namespaces the viewer may get, list and watch
+	Namespaces []string
+	// Bumped to issue a new token, which revokes the previous one
+	Rotation int
+}
+
+// loadViewerConfig reads home:viewerNamespaces, which enables the viewer,
+// and home:viewerRotation
+func loadViewerConfig(ctx *pulumi.Context) (*ViewerConfig, error) {
+	cfg := newConfig(ctx)
+
+	viewerCfg := &ViewerConfig{}
+	err := cfg.TryObject("viewerNamespaces", &viewerCfg.Namespaces)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:viewerNamespaces: %w", configNamespace, err)
+	}
+	rotation, err := cfg.TryInt("viewerRotation")
+	if err == nil {
+		viewerCfg.Rotation = rotation
+	} else if !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:viewerRotation: %w", configNamespace, err)
+	}
+	if len(viewerCfg.Namespaces) == 0 {
+		if err == nil {
+			return nil, fmt.Errorf("%[1]s:viewerRotation requires %[1]s:viewerNamespaces", configNamespace)
+		}
+		return nil, nil
+	}
+	if viewerCfg.Rotation < 0 {
+		return nil, fmt.Errorf("invalid %s:viewerRotation %d, it can't be negative", configNamespace, viewerCfg.Rotation)
+	}
+
+	seen := make(map[string]bool, len(viewerCfg.Namespaces))
+	for _, namespace := range viewerCfg.Namespaces {
+		if msgs := validation.IsDNS1123Label(namespace); len(msgs) > 0 {
+			return nil, fmt.Errorf("invalid %s:viewerNamespaces: %q is not a namespace name", configNamespace, namespace)
+		}
+		if seen[namespace] {
+			return nil, fmt.Errorf("invalid %s:viewerNamespaces: %s is listed twice", configNamespace, namespace)
+		}
+		seen[namespace] = true
+	}
+	return viewerCfg, nil
+}
+
+// loadLocalTLSConfig reads home:localTlsDomain, which enables the local CA and
+// requires home:enableIngress, the CA to import, home:localTlsCaPEM and
+// home:localTlsCaKeyPEM (secret), and the durations home:localTlsCaValidity,
+// home:localTlsValidity and home:localTlsRenewBefore
+func loadLocalTLSConfig(ctx *pulumi.Context, components *ComponentsConfig) (*LocalTLSConfig, error) {
+	cfg := newConfig(ctx)
+
+	domain := strings.TrimPrefix(cfg.Get("localTlsDomain"), "*.")
+	if domain == "" {
+		return nil, nil
+	}
+	if !components.Ingress {
+		return nil, fmt.Errorf("%[1]s:localTlsDomain requires %[1]s:enableIngress", configNamespace)
+	}
+	if msgs := validation.IsDNS1123Subdomain(domain); len(msgs) > 0 {
+		return nil, fmt.Errorf("invalid %s:localTlsDomain %q: %s", configNamespace, domain, strings.Join(msgs, ", "))
+	}
+
+	tlsCfg := &LocalTLSConfig{
+		Domain:   domain,
+		CAPEM:    cfg.Get("localTlsCaPEM"),
+		CAKeyPEM: cfg.Get("localTlsCaKeyPEM"),
+	}
+	if (tlsCfg.CAPEM == "") != (tlsCfg.CAKeyPEM == "") {
+		return nil, fmt.Errorf("%[1]s:localTlsCaPEM and %[1]s:localTlsCaKeyPEM must be set together", configNamespace)
+	}
+
+	var err error
+	if tlsCfg.CAValidity, err = getDuration(cfg, "localTlsCaValidity", defaultLocalCAValidity); err != nil {
+		return nil, err
+	}
+	if tlsCfg.CertValidity, err = getDuration(cfg, "localTlsValidity", defaultLocalCertValidity); err != nil {
+		return nil, err
+	}
+	if tlsCfg.RenewBefore, err = getDuration(cfg, "localTlsRenewBefore", defaultLocalTLSRenewBefore); err != nil {
+		return nil, err
+	}
+	if tlsCfg.RenewBefore >= tlsCfg.CertValidity {
+		return nil, fmt.Errorf("%[1]s:localTlsRenewBefore must be shorter than %[1]s:localTlsValidity, the certificate would be renewed on every deployment", configNamespace)
+	}
+
+	return tlsCfg, nil
+}
+
+// loadDNSRewrites reads home:dnsRewrites, a map of hostname -> in-cluster
+// Service as service.namespace (e.g. {"podinfo.lucena.cloud":
+// "podinfo.podinfo"}). Set it to {} to remove the last rewrites from the
+// Corefile, leaving it unset doesn't touch CoreDNS.
+func loadDNSRewrites(ctx *pulumi.Context) (coredns.Hosts, error) {
+	cfg := newConfig(ctx)
+
+	var hosts coredns.Hosts
+	err := cfg.TryObject("dnsRewrites", &hosts)
+	if errors.Is(err, config.ErrMissingVar) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s:dnsRewrites: %w", configNamespace, err)
+	}
+	if hosts == nil {
+		hosts = coredns.Hosts{}
+	}
+	for host, service := range hosts {
+		if msgs := validation.IsDNS1123Subdomain(host); len(msgs) > 0 {
+			return nil, fmt.Errorf("invalid %s:dnsRewrites: hostname %q: %s", configNamespace, host, strings.Join(msgs, ", "))
+		}
+		name, namespace, ok := strings.Cut(service, ".")
+		if !ok || len(validation.IsDNS1035Label(name)) > 0 || len(validation.IsDNS1123Label(namespace)) > 0 {
+			return nil, fmt.Errorf("invalid %s:dnsRewrites: %s resolves to %q, use service.namespace", configNamespace, host, service)
+		}
+	}
+	return hosts, nil
+}
+
+// MonitoringConfig describes the kube-prometheus-stack installation
+type MonitoringConfig struct {
+	// Chart version
+	Version string
+	// How long Prometheus keeps metrics
+	Retention string
+	// Size of the Prometheus volume
+	StorageSize string
+	// Keep the data on persistent volumes instead of emptyDir
+	Persistence bool
+	// Node port other clusters remote_write to, 0 for none
+	RemoteWriteNodePort int
+	// Scrape and alert on the platform components when Prometheus is deployed
+	PlatformMonitors bool
+	// Thresholds of the platform alerts
+	Alerts monitoring.AlertThresholds
+	// How long to wait for the release to be ready
+	Timeout time.Duration
+}
+
+// defaultAlertThresholds are used for the home:alert* keys not set
+var defaultAlertThresholds = monitoring.AlertThresholds{
+	FluxFailureFor:      15 * time.Minute,
+	LinkerdErrorRate:    0.05,
+	LinkerdErrorRateFor: 10 * time.Minute,
+	TunnelDownFor:       5 * time.Minute,
+	ProbeFailureFor:     5 * time.Minute,
+}
+
+const (
+	// defaultMonitoringVersion is used when home:monitoringVersion is not set,
+	// the version of the prometheus-operator component of the infrastructure tree
+	defaultMonitoringVersion = "77.10.0"
+	// defaultMonitoringTimeout is used when home:monitoringTimeout is not set
+	defaultMonitoringTimeout = 10 * time.Minute
+)
+
+// loadMonitoringConfig reads the kube-prometheus-stack settings from Pulumi
+// config: home:monitoringVersion, home:monitoringRetention (default "7d"),
+// home:monitoringStorageSize (default "10Gi"), home:monitoringPersistence
+// (default true, false for emptyDir volumes), home:prometheusRemoteWriteNodePort
+// and home:monitoringTimeout. home:platformMonitors (default true) scrapes
+// the platform components, with the alerts tuned by home:alertFluxFailureFor,
+// home:alertLinkerdErrorRate (a fraction), home:alertLinkerdErrorRateFor,
+// home:alertTunnelDownFor and home:alertProbeFailureFor.
+func loadMonitoringConfig(ctx *pulumi.Context) (*MonitoringConfig, error) {
+	cfg := newConfig(ctx)
+
+	monitoringCfg := &MonitoringConfig{
+		Version:          cfg.Get("monitoringVersion"),
+		Retention:        cfg.Get("monitoringRetention"),
+		StorageSize:      cfg.Get("monitoringStorageSize"),
+		Persistence:      getBool(cfg, "monitoringPersistence", true),
+		PlatformMonitors: getBool(cfg, "platformMonitors", true),
+		Alerts:           defaultAlertThresholds,
+	}
+	if monitoringCfg.Version == "" {
+		monitoringCfg.Version = defaultMonitoringVersion
+	}
+	if monitoringCfg.Retention == "" {
+		monitoringCfg.Retention = "7d"
+	}
+	if monitoringCfg.StorageSize == "" {
+		monitoringCfg.StorageSize = "10Gi"
+	}
+	nodePort, err := cfg.TryInt("prometheusRemoteWriteNodePort")
+	if err == nil {
+		monitoringCfg.RemoteWriteNodePort = nodePort
+	} else if !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:prometheusRemoteWriteNodePort: %w", configNamespace, err)
+	}
+	if monitoringCfg.RemoteWriteNodePort != 0 && (monitoringCfg.RemoteWriteNodePort < 30000 || monitoringCfg.RemoteWriteNodePort > 32767) {
+		return nil, fmt.Errorf("invalid %s:prometheusRemoteWriteNodePort %d, node ports are between 30000 and 32767", configNamespace, monitoringCfg.RemoteWriteNodePort)
+	}
+
+	if monitoringCfg.Timeout, err = getDuration(cfg, "monitoringTimeout", defaultMonitoringTimeout); err != nil {
+		return nil, err
+	}
+
+	alerts := &monitoringCfg.Alerts
+	for key, d := range map[string]*time.Duration{
+		"alertFluxFailureFor":      &alerts.FluxFailureFor,
+		"alertLinkerdErrorRateFor": &alerts.LinkerdErrorRateFor,
+		"alertTunnelDownFor":       &alerts.TunnelDownFor,
+		"alertProbeFailureFor":     &alerts.ProbeFailureFor,
+	} {
+		if *d, err = getDuration(cfg, key, *d); err != nil {
+			return nil, err
+		}
+		if *d < time.Second {
+			return nil, fmt.Errorf("invalid %s:%s %s, use at least 1s", configNamespace, key, *d)
+		}
+	}
+	rate, err := cfg.TryFloat64("alertLinkerdErrorRate")
+	if err == nil {
+		alerts.LinkerdErrorRate = rate
+	} else if !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:alertLinkerdErrorRate: %w", configNamespace, err)
+	}
+	if alerts.LinkerdErrorRate <= 0 || alerts.LinkerdErrorRate >= 1 {
+		return nil, fmt.Errorf("invalid %s:alertLinkerdErrorRate %g, use a fraction between 0 and 1 (e.g. 0.05)", configNamespace, alerts.LinkerdErrorRate)
+	}
+
+	return monitoringCfg, nil
+}
+
+// ProbeConfig is an entry of home:blackboxProbes
+type ProbeConfig struct {
+	// Name of the Probe, derived from the URL when empty
+	Name string `json:"name,omitempty"`
+	URL  string `json:"url"`
+	// http_2xx (default) or tcp
+	Module string `json:"module,omitempty"`
+	// Go duration, defaults to 60s
+	Interval string `json:"interval,omitempty"`
+}
+
+const (
+	// defaultBlackboxVersion is used when home:blackboxExporterVersion is not set
+	defaultBlackboxVersion = "11.3.1"
+	// defaultProbeInterval is used for the probes without an interval
+	defaultProbeInterval = time.Minute
+)
+
+// probeNameInvalid matches the runs of characters a Probe name can't have
+var probeNameInvalid = regexp.MustCompile(`[^a-z0-9]+`)
+
+// loadBlackboxConfig reads home:blackboxExporterVersion and
+// home:blackboxProbes, a list of {name, url, module, interval} objects which
+// requires home:enableBlackboxExporter. http_2xx probes take an http(s) URL,
+// tcp probes a host:port.
+func loadBlackboxConfig(ctx *pulumi.Context, components *ComponentsConfig) (*observability.BlackboxConfig, error) {
+	cfg := newConfig(ctx)
+
+	blackboxCfg := &observability.BlackboxConfig{
+		Version: cfg.Get("blackboxExporterVersion"),
+	}
+	if blackboxCfg.Version == "" {
+		blackboxCfg.Version = defaultBlackboxVersion
+	}
+
+	var entries []ProbeConfig
+	err := cfg.TryObject("blackboxProbes", &entries)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:blackboxProbes: %w", configNamespace, err)
+	}
+	if len(entries) > 0 && !components.BlackboxExporter {
+		return nil, fmt.Errorf("%[1]s:blackboxProbes requires %[1]s:enableBlackboxExporter", configNamespace)
+	}
+	names := map[string]bool{}
+	for i, entry := range entries {
+		probe := monitoring.Probe{
+			Name:     entry.Name,
+			URL:      entry.URL,
+			Module:   entry.Module,
+			Interval: defaultProbeInterval,
+		}
+		if probe.Module == "" {
+			probe.Module = monitoring.ModuleHTTP
+		}
+		switch probe.Module {
+		case monitoring.ModuleHTTP:
+			if u, err := url.Parse(probe.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("invalid %s:blackboxProbes: entry %d needs an http(s) URL, got %q", configNamespace, i, probe.URL)
+			}
+		case monitoring.ModuleTCP:
+			if _, _, err := net.SplitHostPort(probe.URL); err != nil {
+				return nil, fmt.Errorf("invalid %s:blackboxProbes: entry %d needs a host:port, got %q", configNamespace, i, probe.URL)
+			}
+		default:
+			return nil, fmt.Errorf("invalid %s:blackboxProbes: entry %d has an unknown module %q, use %s or %s",
+				configNamespace, i, probe.Module, monitoring.ModuleHTTP, monitoring.ModuleTCP)
+		}
+		if entry.Interval != "" {
+			if probe.Interval, err = time.ParseDuration(entry.Interval); err != nil || probe.Interval < time.Second {
+				return nil, fmt.Errorf("invalid %s:blackboxProbes: interval %q of %s, use a duration of at least 1s", configNamespace, entry.Interval, probe.URL)
+			}
+		}
+		if probe.Name == "" {
+			probe.Name = probeName(probe.URL)
+		}
+		if msgs := validation.IsDNS1123Label(probe.Name); len(msgs) > 0 {
+			return nil, fmt.Errorf("invalid %s:blackboxProbes: name %q of %s: %s", configNamespace, probe.Name, probe.URL, strings.Join(msgs, ", "))
+		}
+		if names[probe.Name] {
+			return nil, fmt.Errorf("invalid %s:blackboxProbes: %s is listed twice, give the probes distinct names", configNamespace, probe.Name)
+		}
+		names[probe.Name] = true
+		blackboxCfg.Probes = append(blackboxCfg.Probes, probe)
+	}
+
+	return blackboxCfg, nil
+}
+
+// probeName derives a Probe name from its URL, "https://lucena.cloud/" is
+// probed by "lucena-cloud"
+func probeName(target string) string {
+	if u, err := url.Parse(target); err == nil && u.Host != "" {
+		target = u.Host + u.Path
+	}
+	name := strings.Trim(probeNameInvalid.ReplaceAllString(strings.ToLower(target), "-"), "-")
+	if len(name) > 63 {
+		name = strings.TrimRight(name[:63], "-")
+	}
+	return name
+}
+
+// LoggingConfig describes the Loki and Promtail installation
+type LoggingConfig struct {
+	// Chart versions
+	LokiVersion     string
+	PromtailVersion string
+	// How long Loki keeps logs
+	Retention string
+	// Size of the Loki volume
+	StorageSize string
+	// Keep the logs on a persistent volume instead of emptyDir
+	Persistence bool
+	// Node port other clusters push logs to, 0 for none
+	NodePort int
+	// How long to wait for each release to be ready
+	Timeout time.Duration
+}
+
+const (
+	// defaultLokiVersion is used when home:lokiVersion is not set, the version
+	// of the loki component of the infrastructure tree
+	defaultLokiVersion = "6.25.0"
+	// defaultPromtailVersion is used when home:promtailVersion is not set
+	defaultPromtailVersion = "6.16.6"
+	// defaultLoggingTimeout is used when home:loggingTimeout is not set
+	defaultLoggingTimeout = 10 * time.Minute
+)
+
+// persistentLoggingStacks keep their logs on a volume by default
+var persistentLoggingStacks = map[string]bool{
+	"homelab": true,
+}
+
+// loadLoggingConfig reads the Loki and Promtail settings from Pulumi config:
+// home:lokiVersion, home:promtailVersion, home:loggingRetention (default
+// "7d"), home:loggingStorageSize (default "10Gi"), home:loggingPersistence
+// (default true on homelab, false elsewhere), home:loggingNodePort and
+// home:loggingTimeout
+func loadLoggingConfig(ctx *pulumi.Context) (*LoggingConfig, error) {
+	cfg := newConfig(ctx)
+
+	loggingCfg := &LoggingConfig{
+		LokiVersion:     cfg.Get("lokiVersion"),
+		PromtailVersion: cfg.Get("promtailVersion"),
+		Retention:       cfg.Get("loggingRetention"),
+		StorageSize:     cfg.Get("loggingStorageSize"),
+		Persistence:     getBool(cfg, "loggingPersistence", persistentLoggingStacks[stacks.Stack(ctx)]),
+	}
+	if loggingCfg.LokiVersion == "" {
+		loggingCfg.LokiVersion = defaultLokiVersion
+	}
+	if loggingCfg.PromtailVersion == "" {
+		loggingCfg.PromtailVersion = defaultPromtailVersion
+	}
+	if loggingCfg.Retention == "" {
+		loggingCfg.Retention = "7d"
+	}
+	if loggingCfg.StorageSize == "" {
+		loggingCfg.StorageSize = "10Gi"
+	}
+	nodePort, err := cfg.TryInt("loggingNodePort")
+	if err == nil {
+		loggingCfg.NodePort = nodePort
+	} else if !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:loggingNodePort: %w", configNamespace, err)
+	}
+	if loggingCfg.NodePort != 0 && (loggingCfg.NodePort < 30000 || loggingCfg.NodePort > 32767) {
+		return nil, fmt.Errorf("invalid %s:loggingNodePort %d, node ports are between 30000 and 32767", configNamespace, loggingCfg.NodePort)
+	}
+
+	if loggingCfg.Timeout, err = getDuration(cfg, "loggingTimeout", defaultLoggingTimeout); err != nil {
+		return nil, err
+	}
+
+	return loggingCfg, nil
+}
+
+// TracingConfig describes the Tempo installation
+type TracingConfig struct {
+	// Chart version
+	Version string
+	// How long Tempo keeps traces
+	Retention string
+	// Protocols spans are received with, see tracing.Receivers
+	Receivers []string
+	// Size of the Tempo volume
+	StorageSize string
+	// Keep the traces on a persistent volume instead of emptyDir
+	Persistence bool
+	// How long to wait for Tempo to be ready
+	Timeout time.Duration
+}
+
+const (
+	// defaultTempoVersion is used when home:tempoVersion is not set, the
+	// version of the tempo component of the infrastructure tree
+	defaultTempoVersion = "1.23.3"
+	// defaultTracingTimeout is used when home:tracingTimeout is not set
+	defaultTracingTimeout = 10 * time.Minute
+)
+
+// defaultTracingReceivers are the protocols received when
+// home:tracingReceivers is not set
+var defaultTracingReceivers = []string{tracing.ReceiverOTLPGRPC, tracing.ReceiverOTLPHTTP}
+
+// loadTracingConfig reads the Tempo settings from Pulumi config:
+// home:tempoVersion, home:tracingRetention (default "72h"),
+// home:tracingReceivers (default otlp-grpc and otlp-http),
+// home:tracingStorageSize (default "10Gi"), home:tracingPersistence (default
+// true on homelab, false elsewhere) and home:tracingTimeout
+func loadTracingConfig(ctx *pulumi.Context) (*TracingConfig, error) {
+	cfg := newConfig(ctx)
+
+	tracingCfg := &TracingConfig{
+		Version:     cfg.Get("tempoVersion"),
+		Retention:   cfg.Get("tracingRetention"),
+		StorageSize: cfg.Get("tracingStorageSize"),
+		Persistence: getBool(cfg, "tracingPersistence", persistentLoggingStacks[stacks.Stack(ctx)]),
+	}
+	if tracingCfg.Version == "" {
+		tracingCfg.Version = defaultTempoVersion
+	}
+	if tracingCfg.Retention == "" {
+		tracingCfg.Retention = "72h"
+	}
+	if _, err := time.ParseDuration(tracingCfg.Retention); err != nil {
+		return nil, fmt.Errorf("invalid %s:tracingRetention %q, use a duration like 72h", configNamespace, tracingCfg.Retention)
+	}
+	if tracingCfg.StorageSize == "" {
+		tracingCfg.StorageSize = "10Gi"
+	}
+
+	err := cfg.TryObject("tracingReceivers", &tracingCfg.Receivers)
+	if errors.Is(err, config.ErrMissingVar) {
+		tracingCfg.Receivers = defaultTracingReceivers
+	} else if err != nil {
+		return nil, fmt.Errorf("invalid %s:tracingReceivers: %w", configNamespace, err)
+	}
+	if len(tracingCfg.Receivers) == 0 {
+		return nil, fmt.Errorf("%s:tracingReceivers is empty, Tempo would receive no spans", configNamespace)
+	}
+	for _, receiver := range tracingCfg.Receivers {
+		if !slices.Contains(tracing.Receivers, receiver) {
+			return nil, fmt.Errorf("invalid %s:tracingReceivers: unknown receiver %q, use %s", configNamespace, receiver, strings.Join(tracing.Receivers, ", "))
+		}
+	}
+
+	if tracingCfg.Timeout, err = getDuration(cfg, "tracingTimeout", defaultTracingTimeout); err != nil {
+		return nil, err
+	}
+
+	return tracingCfg, nil
+}
+
+// MinIOConfig describes the MinIO installation
+type MinIOConfig struct {
+	// Chart version
+	Version string
+	// Buckets created after the installation
+	Buckets []string
+	// Size of the data volume
+	StorageSize string
+	// How long to wait for the release and the buckets
+	Timeout time.Duration
+}
+
+const (
+	// defaultMinIOVersion is used when home:minioVersion is not set
+	defaultMinIOVersion = "5.4.0"
+	// defaultMinIOTimeout is used when home:minioTimeout is not set
+	defaultMinIOTimeout = 5 * time.Minute
+	// lokiBucket is the MinIO bucket Loki stores its chunks and index in
+	lokiBucket = "loki"
+	// tempoBucket is the MinIO bucket Tempo stores its traces in
+	tempoBucket = "tempo"
+)
+
+// loadMinIOConfig reads the MinIO settings from Pulumi config.
+// home:minioBuckets is a list of bucket names.
+func loadMinIOConfig(ctx *pulumi.Context) (*MinIOConfig, error) {
+	cfg := newConfig(ctx)
+
+	minioCfg := &MinIOConfig{
+		Version:     cfg.Get("minioVersion"),
+		StorageSize: cfg.Get("minioStorageSize"),
+	}
+	if minioCfg.Version == "" {
+		minioCfg.Version = defaultMinIOVersion
+	}
+	if minioCfg.StorageSize == "" {
+		minioCfg.StorageSize = "20Gi"
+	}
+	if _, err := resource.ParseQuantity(minioCfg.StorageSize); err != nil {
+		return nil, fmt.Errorf("invalid %s:minioStorageSize: %w", configNamespace, err)
+	}
+
+	err := cfg.TryObject("minioBuckets", &minioCfg.Buckets)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:minioBuckets: %w", configNamespace, err)
+	}
+	seen := map[string]bool{}
+	for _, bucket := range minioCfg.Buckets {
+		if !bucketName.MatchString(bucket) {
+			return nil, fmt.Errorf("invalid %s:minioBuckets: %q is not a valid bucket name", configNamespace, bucket)
+		}
+		if seen[bucket] {
+			return nil, fmt.Errorf("invalid %s:minioBuckets: %s is listed twice", configNamespace, bucket)
+		}
+		seen[bucket] = true
+	}
+
+	if minioCfg.Timeout, err = getDuration(cfg, "minioTimeout", defaultMinIOTimeout); err != nil {
+		return nil, err
+	}
+
+	return minioCfg, nil
+}
+
+// VeleroConfig describes the Velero installation
+type VeleroConfig struct {
+	// Chart version
+	Version string
+	// Bucket backups are written to
+	Bucket string
+	// S3 API and region of an external bucket, MinIO's when empty
+	S3URL    string
+	S3Region string
+	// Credentials of the external bucket (secrets)
+	AccessKey pulumi.StringOutput
+	SecretKey pulumi.StringOutput
+	// Namespaces backed up by the daily schedule, no schedule when empty
+	Namespaces []string
+	// Cron expression and retention of the schedule
+	Schedule string
+	TTL      string
+	// Backup restored on a fresh cluster
+	RestoreFrom string
+	// How long to wait for the release and the storage location
+	Timeout time.Duration
+}
+
+const (
+	// defaultVeleroVersion is used when home:veleroVersion is not set
+	defaultVeleroVersion = "10.0.10"
+	// defaultVeleroTimeout is used when home:veleroTimeout is not set
+	defaultVeleroTimeout = 10 * time.Minute
+)
+
+// loadVeleroConfig reads the Velero settings from Pulumi config. Backups go
+// to the MinIO bucket home:veleroBucket unless home:veleroS3Url points to
+// external S3, whose credentials are the secrets home:veleroAccessKey and
+// home:veleroSecretKey.
+func loadVeleroConfig(ctx *pulumi.Context, components *ComponentsConfig) (*VeleroConfig, error) {
+	cfg := newConfig(ctx)
+
+	veleroCfg := &VeleroConfig{
+		Version:     cfg.Get("veleroVersion"),
+		Bucket:      cfg.Get("veleroBucket"),
+		S3URL:       cfg.Get("veleroS3Url"),
+		S3Region:    cfg.Get("veleroS3Region"),
+		Schedule:    cfg.Get("veleroSchedule"),
+		TTL:         cfg.Get("veleroTtl"),
+		RestoreFrom: cfg.Get("restoreFromBackup"),
+	}
+	if veleroCfg.Version == "" {
+		veleroCfg.Version = defaultVeleroVersion
+	}
+	if veleroCfg.Bucket == "" {
+		veleroCfg.Bucket = "velero"
+	}
+	if veleroCfg.S3Region == "" {
+		veleroCfg.S3Region = "us-east-1"
+	}
+	if veleroCfg.Schedule == "" {
+		veleroCfg.Schedule = "0 3 * * *"
+	}
+	if veleroCfg.TTL == "" {
+		veleroCfg.TTL = "720h"
+	}
+	if _, err := time.ParseDuration(veleroCfg.TTL); err != nil {
+		return nil, fmt.Errorf("invalid %s:veleroTtl: %w", configNamespace, err)
+	}
+	if !bucketName.MatchString(veleroCfg.Bucket) {
+		return nil, fmt.Errorf("invalid %s:veleroBucket: %q is not a valid bucket name", configNamespace, veleroCfg.Bucket)
+	}
+	err := cfg.TryObject("veleroNamespaces", &veleroCfg.Namespaces)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:veleroNamespaces: %w", configNamespace, err)
+	}
+	if veleroCfg.Timeout, err = getDuration(cfg, "veleroTimeout", defaultVeleroTimeout); err != nil {
+		return nil, err
+	}
+
+	if !components.Velero {
+		if veleroCfg.RestoreFrom != "" {
+			return nil, fmt.Errorf("%[1]s:restoreFromBackup requires %[1]s:enableVelero=true", configNamespace)
+		}
+		return veleroCfg, nil
+	}
+	if veleroCfg.S3URL == "" {
+		if !components.MinIO {
+			return nil, fmt.Errorf("%[1]s:enableVelero requires %[1]s:enableMinio=true or an external bucket in %[1]s:veleroS3Url", configNamespace)
+		}
+	} else {
+		if cfg.Get("veleroAccessKey") == "" || cfg.Get("veleroSecretKey") == "" {
+			return nil, fmt.Errorf("%[1]s:veleroS3Url requires the secrets %[1]s:veleroAccessKey and %[1]s:veleroSecretKey", configNamespace)
+		}
+		veleroCfg.AccessKey = cfg.GetSecret("veleroAccessKey")
+		veleroCfg.SecretKey = cfg.GetSecret("veleroSecretKey")
+	}
+
+	return veleroCfg, nil
+}
+
+// PostgresConfig describes the CloudNativePG operator and Postgres cluster
+type PostgresConfig struct {
+	// Chart version of the operator
+	Version string
+	// Namespace and name of the Postgres cluster
+	Namespace string
+	Name      string
+	// Number of instances
+	Instances int
+	// Size and StorageClass of each instance volume
+	StorageSize  string
+	StorageClass string
+	// Database created at bootstrap and its owner
+	Database string
+	Owner    string
+	// How long to wait for the operator and the instances
+	Timeout time.Duration
+}
+
+const (
+	// defaultCNPGVersion is used when home:cnpgVersion is not set
+	defaultCNPGVersion = "0.26.0"
+	// defaultPostgresTimeout is used when home:postgresTimeout is not set
+	defaultPostgresTimeout = 10 * time.Minute
+)
+
+// loadPostgresConfig reads the Postgres settings from Pulumi config
+func loadPostgresConfig(ctx *pulumi.Context) (*PostgresConfig, error) {
+	cfg := newConfig(ctx)
+
+	postgresCfg := &PostgresConfig{
+		Version:      cfg.Get("cnpgVersion"),
+		Namespace:    cfg.Get("postgresNamespace"),
+		Name:         cfg.Get("postgresClusterName"),
+		Instances:    1,
+		StorageSize:  cfg.Get("postgresStorageSize"),
+		StorageClass: cfg.Get("postgresStorageClass"),
+		Database:     cfg.Get("postgresDatabase"),
+		Owner:        cfg.Get("postgresUser"),
+	}
+	if postgresCfg.Version == "" {
+		postgresCfg.Version = defaultCNPGVersion
+	}
+	if postgresCfg.Namespace == "" {
+		postgresCfg.Namespace = "postgres"
+	}
+	if postgresCfg.Name == "" {
+		postgresCfg.Name = "postgres"
+	}
+	if postgresCfg.StorageSize == "" {
+		postgresCfg.StorageSize = "10Gi"
+	}
+	if postgresCfg.Database == "" {
+		postgresCfg.Database = "app"
+	}
+	if postgresCfg.Owner == "" {
+		postgresCfg.Owner = postgresCfg.Database
+	}
+	if _, err := resource.ParseQuantity(postgresCfg.StorageSize); err != nil {
+		return nil, fmt.Errorf("invalid %s:postgresStorageSize: %w", configNamespace, err)
+	}
+	if postgresCfg.Owner == cnpg.Superuser {
+		return nil, fmt.Errorf("invalid %s:postgresUser: %s is the superuser", configNamespace, cnpg.Superuser)
+	}
+
+	instances, err := cfg.TryInt("postgresInstances")
+	if err == nil {
+		postgresCfg.Instances = instances
+	} else if !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:postgresInstances: %w", configNamespace, err)
+	}
+	if postgresCfg.Instances < 1 {
+		return nil, fmt.Errorf("invalid %s:postgresInstances %d, at least one instance is required", configNamespace, postgresCfg.Instances)
+	}
+
+	if postgresCfg.Timeout, err = getDuration(cfg, "postgresTimeout", defaultPostgresTimeout); err != nil {
+		return nil, err
+	}
+
+	return postgresCfg, nil
+}
+
+// SealedSecretsConfig describes the Sealed Secrets controller
+type SealedSecretsConfig struct {
+	// Chart version
+	Version string
+	// PEM encoded key pair of the controller (secrets), generated by the
+	// controller when not set
+	Certificate pulumi.StringOutput
+	PrivateKey  pulumi.StringOutput
+	KeyPair     bool
+	// How long to wait for the controller and its certificate
+	Timeout time.Duration
+}
+
+const (
+	// defaultSealedSecretsVersion is used when home:sealedSecretsVersion is
+	// not set, the version of the tree's HelmRelease
+	defaultSealedSecretsVersion = "2.17.4"
+	// defaultSealedSecretsTimeout is used when home:sealedSecretsTimeout is
+	// not set
+	defaultSealedSecretsTimeout = 5 * time.Minute
+)
+
+// loadSealedSecretsConfig reads the Sealed Secrets settings from Pulumi
+// config. The key pair is given as the secrets home:sealedSecretsCert and
+// home:sealedSecretsKey, both or neither.
+func loadSealedSecretsConfig(ctx *pulumi.Context) (*SealedSecretsConfig, error) {
+	cfg := newConfig(ctx)
+
+	sealedCfg := &SealedSecretsConfig{
+		Version: cfg.Get("sealedSecretsVersion"),
+	}
+	if sealedCfg.Version == "" {
+		sealedCfg.Version = defaultSealedSecretsVersion
+	}
+
+	cert, key := cfg.Get("sealedSecretsCert") != "", cfg.Get("sealedSecretsKey") != ""
+	if cert != key {
+		return nil, fmt.Errorf("%[1]s:sealedSecretsCert and %[1]s:sealedSecretsKey must be set together", configNamespace)
+	}
+	if cert {
+		sealedCfg.Certificate = cfg.GetSecret("sealedSecretsCert")
+		sealedCfg.PrivateKey = cfg.GetSecret("sealedSecretsKey")
+		sealedCfg.KeyPair = true
+	}
+
+	var err error
+	if sealedCfg.Timeout, err = getDuration(cfg, "sealedSecretsTimeout", defaultSealedSecretsTimeout); err != nil {
+		return nil, err
+	}
+
+	return sealedCfg, nil
+}
+
+// ExternalSecretsConfig describes the External Secrets Operator and the
+// Secrets materialized from home:managedSecrets
+type ExternalSecretsConfig struct {
+	// Chart version
+	Version string
+	// Secrets created from config, through the operator when enabled
+	Secrets []externalsecrets.Secret
+	// How long to wait for the operator and its store
+	Timeout time.Duration
+}
+
+// ManagedSecretConfig is an entry of home:managedSecrets
+type ManagedSecretConfig struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace"`
+	Data      map[string]string `json:"data"`
+}
+
+const (
+	// defaultExternalSecretsVersion is used when home:externalSecretsVersion
+	// is not set
+	defaultExternalSecretsVersion = "0.19.2"
+	// defaultExternalSecretsTimeout is used when home:externalSecretsTimeout
+	// is not set
+	defaultExternalSecretsTimeout = 5 * time.Minute
+)
+
+// loadExternalSecretsConfig reads the External Secrets settings from Pulumi
+// config. home:managedSecrets is a list of {name, namespace, data} objects,
+// set as a secret so the values stay encrypted in the stack config.
+func loadExternalSecretsConfig(ctx *pulumi.Context) (*ExternalSecretsConfig, error) {
+	cfg := newConfig(ctx)
+
+	externalSecretsCfg := &ExternalSecretsConfig{
+		Version: cfg.Get("externalSecretsVersion"),
+	}
+	if externalSecretsCfg.Version == "" {
+		externalSecretsCfg.Version = defaultExternalSecretsVersion
+	}
+
+	var entries []ManagedSecretConfig
+	err := cfg.TryObject("managedSecrets", &entries)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:managedSecrets: %w", configNamespace, err)
+	}
+	seen := map[string]bool{}
+	for i, entry := range entries {
+		if entry.Name == "" || entry.Namespace == "" {
+			return nil, fmt.Errorf("invalid %s:managedSecrets: entry %d needs a name and a namespace", configNamespace, i)
+		}
+		ref := entry.Namespace + "/" + entry.Name
+		if msgs := validation.IsDNS1123Label(entry.Namespace); len(msgs) > 0 {
+			return nil, fmt.Errorf("invalid %s:managedSecrets: namespace of %s: %s", configNamespace, ref, strings.Join(msgs, ", "))
+		}
+		if msgs := validation.IsDNS1123Subdomain(entry.Name); len(msgs) > 0 {
+			return nil, fmt.Errorf("invalid %s:managedSecrets: name of %s: %s", configNamespace, ref, strings.Join(msgs, ", "))
+		}
+		if len(entry.Data) == 0 {
+			return nil, fmt.Errorf("invalid %s:managedSecrets: %s has no data", configNamespace, ref)
+		}
+		if seen[ref] {
+			return nil, fmt.Errorf("invalid %s:managedSecrets: %s is listed twice", configNamespace, ref)
+		}
+		seen[ref] = true
+
+		externalSecretsCfg.Secrets = append(externalSecretsCfg.Secrets, externalsecrets.Secret{
+			Name:      entry.Name,
+			Namespace: entry.Namespace,
+			Data:      pulumi.ToSecret(pulumi.ToStringMap(entry.Data)).(pulumi.StringMapOutput),
+		})
+	}
+
+	if externalSecretsCfg.Timeout, err = getDuration(cfg, "externalSecretsTimeout", defaultExternalSecretsTimeout); err != nil {
+		return nil, err
+	}
+
+	return externalSecretsCfg, nil
+}
+
+// MetricsServerConfig describes the metrics-server installation
+type MetricsServerConfig struct {
+	// Chart version
+	Version string
+	// Pass --kubelet-insecure-tls
+	InsecureTLS bool
+	// How long to wait for the release and the metrics API
+	Timeout time.Duration
+}
+
+const (
+	// defaultMetricsServerVersion is used when home:metricsServerVersion is
+	// not set, the version of the tree's HelmRelease
+	defaultMetricsServerVersion = "3.12.2"
+	// defaultMetricsServerTimeout is used when home:metricsServerTimeout is
+	// not set
+	defaultMetricsServerTimeout = 5 * time.Minute
+)
+
+// loadMetricsServerConfig reads the metrics-server settings from Pulumi
+// config. The kubelet certificates are only trusted blindly on the Kind
+// clusters the program creates, whose kubelets serve self-signed ones,
+// unless home:metricsServerInsecureTls says otherwise.
+func loadMetricsServerConfig(ctx *pulumi.Context, clusterCfg *ClusterConfig) (*MetricsServerConfig, error) {
+	cfg := newConfig(ctx)
+
+	metricsServerCfg := &MetricsServerConfig{
+		Version:     cfg.Get("metricsServerVersion"),
+		InsecureTLS: getBool(cfg, "metricsServerInsecureTls", clusterCfg.Backend == "kind" && clusterCfg.Provision),
+	}
+	if metricsServerCfg.Version == "" {
+		metricsServerCfg.Version = defaultMetricsServerVersion
+	}
+
+	var err error
+	if metricsServerCfg.Timeout, err = getDuration(cfg, "metricsServerTimeout", defaultMetricsServerTimeout); err != nil {
+		return nil, err
+	}
+
+	return metricsServerCfg, nil
+}
+
+// KEDAConfig describes KEDA and the ScaledObjects created from
+// home:scaledObjects
+type KEDAConfig struct {
+	// Chart version
+	Version       string
+	ScaledObjects []keda.ScaledObject
+	// How long to wait for the release and the CRDs
+	Timeout time.Duration
+}
+
+// ScaledObjectConfig is an entry of home:scaledObjects
+type ScaledObjectConfig struct {
+	// Name of the ScaledObject (default the deployment)
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
+	Deployment string `json:"deployment"`
+	// Replica bounds (default 1 and 10)
+	MinReplicas *int `json:"minReplicas"`
+	MaxReplicas *int `json:"maxReplicas"`
+	// Trigger type (default prometheus)
+	Trigger string `json:"trigger"`
+	// Query and threshold of the prometheus trigger, queried on
+	// serverAddress (default the monitoring stack)
+	Query         string `json:"query"`
+	Threshold     string `json:"threshold"`
+	ServerAddress string `json:"serverAddress"`
+	// Metadata of the trigger, for the other types
+	Metadata map[string]string `json:"metadata"`
+}
+
+const (
+	// defaultKEDAVersion is used when home:kedaVersion is not set
+	defaultKEDAVersion = "2.17.2"
+	// defaultKEDATimeout is used when home:kedaTimeout is not set
+	defaultKEDATimeout = 5 * time.Minute
+	// defaultMinReplicas and defaultMaxReplicas bound the ScaledObjects
+	// that don't set minReplicas and maxReplicas
+	defaultMinReplicas = 1
+	defaultMaxReplicas = 10
+)
+
+// loadGrafanaDashboards reads the dashboard JSON files of
+// home:grafanaDashboardsDir, which requires home:enableMonitoring. Setting it
+// also makes the program provision the Grafana datasources.
+func loadGrafanaDashboards(ctx *pulumi.Context, components *ComponentsConfig) ([]grafana.Dashboard, error) {
+	cfg := newConfig(ctx)
+
+	dir := cfg.Get("grafanaDashboardsDir")
+	if dir == "" {
+		return nil, nil
+	}
+	if !components.Monitoring {
+		return nil, fmt.Errorf("%[1]s:grafanaDashboardsDir requires %[1]s:enableMonitoring", configNamespace)
+	}
+	dashboards, err := grafana.LoadDashboards(dir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s:grafanaDashboardsDir: %w", configNamespace, err)
+	}
+	return dashboards, nil
+}
+
+// loadKEDAConfig reads the KEDA settings from Pulumi config.
+// home:scaledObjects is a list of {name, namespace, deployment, minReplicas,
+// maxReplicas, trigger, query, threshold, serverAddress, metadata} objects and
+// requires home:enableKeda.
+func loadKEDAConfig(ctx *pulumi.Context, components *ComponentsConfig) (*KEDAConfig, error) {
+	cfg := newConfig(ctx)
+
+	kedaCfg := &KEDAConfig{
+		Version: cfg.Get("kedaVersion"),
+	}
+	if kedaCfg.Version == "" {
+		kedaCfg.Version = defaultKEDAVersion
+	}
+
+	var entries []ScaledObjectConfig
+	err := cfg.TryObject("scaledObjects", &entries)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:scaledObjects: %w", configNamespace, err)
+	}
+	if len(entries) > 0 && !components.KEDA {
+		return nil, fmt.Errorf("%[1]s:scaledObjects requires %[1]s:enableKeda", configNamespace)
+	}
+	seen := map[string]bool{}
+	for i, entry := range entries {
+		if entry.Namespace == "" || entry.Deployment == "" {
+			return nil, fmt.Errorf("invalid %s:scaledObjects: entry %d needs a namespace and a deployment", configNamespace, i)
+		}
+		if entry.Name == "" {
+			entry.Name = entry.Deployment
+		}
+		ref := entry.Namespace + "/" + entry.Name
+		if msgs := validation.IsDNS1123Label(entry.Namespace); len(msgs) > 0 {
+			return nil, fmt.Errorf("invalid %s:scaledObjects: namespace of %s: %s", configNamespace, ref, strings.Join(msgs, ", "))
+		}
+		if msgs := validation.IsDNS1123Subdomain(entry.Name); len(msgs) > 0 {
+			return nil, fmt.Errorf("invalid %s:scaledObjects: name of %s: %s", configNamespace, ref, strings.Join(msgs, ", "))
+		}
+		if seen[ref] {
+			return nil, fmt.Errorf("invalid %s:scaledObjects: %s is listed twice", configNamespace, ref)
+		}
+		seen[ref] = true
+
+		object := keda.ScaledObject{
+			Name:        entry.Name,
+			Namespace:   entry.Namespace,
+			Deployment:  entry.Deployment,
+			MinReplicas: defaultMinReplicas,
+			MaxReplicas: defaultMaxReplicas,
+			Trigger:     entry.Trigger,
+			Metadata:    map[string]string{},
+		}
+		if entry.MinReplicas != nil {
+			object.MinReplicas = *entry.MinReplicas
+		}
+		if entry.MaxReplicas != nil {
+			object.MaxReplicas = *entry.MaxReplicas
+		}
+		if object.MinReplicas < 0 || object.MaxReplicas < 1 || object.MaxReplicas < object.MinReplicas {
+			return nil, fmt.Errorf("invalid %s:scaledObjects: %s scales between %d and %d replicas", configNamespace, ref, object.MinReplicas, object.MaxReplicas)
+		}
+		for key, value := range entry.Metadata {
+			object.Metadata[key] = value
+		}
+		if object.Trigger == "" {
+			object.Trigger = "prometheus"
+		}
+		if object.Trigger == "prometheus" {
+			if entry.Query == "" || entry.Threshold == "" {
+				return nil, fmt.Errorf("invalid %s:scaledObjects: the prometheus trigger of %s needs a query and a threshold", configNamespace, ref)
+			}
+			if _, err := strconv.ParseFloat(entry.Threshold, 64); err != nil {
+				return nil, fmt.Errorf("invalid %s:scaledObjects: threshold of %s: %q is not a number", configNamespace, ref, entry.Threshold)
+			}
+			object.Metadata["query"] = entry.Query
+			object.Metadata["threshold"] = entry.Threshold
+			object.Metadata["serverAddress"] = entry.ServerAddress
+			if entry.ServerAddress == "" {
+				object.Metadata["serverAddress"] = monitoring.PrometheusURL
+			}
+		} else if len(object.Metadata) == 0 {
+			return nil, fmt.Errorf("invalid %s:scaledObjects: the %s trigger of %s needs metadata", configNamespace, object.Trigger, ref)
+		}
+		kedaCfg.ScaledObjects = append(kedaCfg.ScaledObjects, object)
+	}
+
+	if kedaCfg.Timeout, err = getDuration(cfg, "kedaTimeout", defaultKEDATimeout); err != nil {
+		return nil, err
+	}
+
+	return kedaCfg, nil
+}
+
+// KyvernoConfig describes Kyverno and its baseline policies
+type KyvernoConfig struct {
+	// Chart version
+	Version string
+	// Policies created, those set to off are left out
+	Policies []kyverno.Policy
+	// How long to wait for Kyverno and the policies
+	Timeout time.Duration
+}
+
+const (
+	// defaultKyvernoVersion is used when home:kyvernoVersion is not set
+	defaultKyvernoVersion = "3.5.2"
+	// defaultKyvernoTimeout is used when home:kyvernoTimeout is not set
+	defaultKyvernoTimeout = 5 * time.Minute
+)
+
+var (
+	// defaultKyvernoExcludeNamespaces is used when
+	// home:kyvernoExcludeNamespaces is not set
+	defaultKyvernoExcludeNamespaces = []string{"kube-system", "flux-system", "linkerd"}
+	// defaultKyvernoHostPathNamespaces is used when
+	// home:kyvernoHostPathNamespaces is not set: the Kind volume provisioner
+	// and the Velero node agent
+	defaultKyvernoHostPathNamespaces = []string{"local-path-storage", velero.Namespace}
+)
+
+// loadKyvernoConfig reads the Kyverno settings from Pulumi config. Every
+// baseline policy is audited unless home:kyvernoPolicies sets it to
+// "enforce" or "off" (e.g. {"disallow-latest-tag": "enforce"}).
+// home:kyvernoExcludeNamespaces are left out of every policy and
+// home:kyvernoHostPathNamespaces out of restrict-host-path.
+func loadKyvernoConfig(ctx *pulumi.Context) (*KyvernoConfig, error) {
+	cfg := newConfig(ctx)
+
+	kyvernoCfg := &KyvernoConfig{
+		Version: cfg.Get("kyvernoVersion"),
+	}
+	if kyvernoCfg.Version == "" {
+		kyvernoCfg.Version = defaultKyvernoVersion
+	}
+
+	modes := map[string]string{}
+	err := cfg.TryObject("kyvernoPolicies", &modes)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:kyvernoPolicies: %w", configNamespace, err)
+	}
+	for name, mode := range modes {
+		if _, ok := kyverno.Baseline[name]; !ok {
+			return nil, fmt.Errorf("invalid %s:kyvernoPolicies: unknown policy %s, use one of %s",
+				configNamespace, name, strings.Join(kyverno.PolicyNames(), ", "))
+		}
+		switch mode {
+		case kyverno.Enforce, kyverno.Audit, kyverno.Off:
+		default:
+			return nil, fmt.Errorf("invalid %s:kyvernoPolicies: mode %q of %s, use %q, %q or %q",
+				configNamespace, mode, name, kyverno.Enforce, kyverno.Audit, kyverno.Off)
+		}
+	}
+
+	exclude := defaultKyvernoExcludeNamespaces
+	err = cfg.TryObject("kyvernoExcludeNamespaces", &exclude)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:kyvernoExcludeNamespaces: %w", configNamespace, err)
+	}
+	hostPath := defaultKyvernoHostPathNamespaces
+	err = cfg.TryObject("kyvernoHostPathNamespaces", &hostPath)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:kyvernoHostPathNamespaces: %w", configNamespace, err)
+	}
+
+	for _, name := range kyverno.PolicyNames() {
+		mode := modes[name]
+		if mode == "" {
+			mode = kyverno.Audit
+		}
+		if mode == kyverno.Off {
+			continue
+		}
+		policy := kyverno.Policy{
+			Name:              name,
+			Mode:              mode,
+			ExcludeNamespaces: exclude,
+		}
+		if name == "restrict-host-path" {
+			policy.ExcludeNamespaces = append(append([]string{}, exclude...), hostPath...)
+		}
+		kyvernoCfg.Policies = append(kyvernoCfg.Policies, policy)
+	}
+
+	if kyvernoCfg.Timeout, err = getDuration(cfg, "kyvernoTimeout", defaultKyvernoTimeout); err != nil {
+		return nil, err
+	}
+
+	return kyvernoCfg, nil
+}
+
+// bucketName matches the S3 bucket naming rules
+var bucketName = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// ClusterChecksConfig configures the `flux check --pre` and `linkerd check
+// --pre` run against the cluster before Flux and Linkerd are installed
+type ClusterChecksConfig struct {
+	Enabled bool
+	// Checks whose failure only logs a warning (e.g. ones known to fail on
+	// Kind), matched case-insensitively as substrings of the check descriptions
+	Warnings []string
+}
+
+// loadExtraKustomizeDirs reads home:extraKustomizeDirs, a list of
+// {"name", "dir", "dependsOn"} objects. The name defaults to the base name of
+// the directory.
+func loadExtraKustomizeDirs(cfg *homeConfig) ([]infra.Directory, error) {
+	var dirs []infra.Directory
+	err := cfg.TryObject("extraKustomizeDirs", &dirs)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:extraKustomizeDirs: %w", configNamespace, err)
+	}
+
+	names := make(map[string]bool, len(dirs))
+	for i := range dirs {
+		dir := &dirs[i]
+		if dir.Dir == "" {
+			return nil, fmt.Errorf("invalid %s:extraKustomizeDirs: entry %d has no dir", configNamespace, i)
+		}
+		path, err := expandPath(dir.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s:extraKustomizeDirs: %w", configNamespace, err)
+		}
+		dir.Dir = path
+		if dir.Name == "" {
+			dir.Name = filepath.Base(path)
+		}
+		if names[dir.Name] {
+			return nil, fmt.Errorf("invalid %s:extraKustomizeDirs: %s is listed twice, set a different name", configNamespace, dir.Name)
+		}
+		names[dir.Name] = true
+	}
+	return dirs, nil
+}
+
+// loadNodeResources reads home:kindNetwork, the Docker network of the Kind
+// nodes, and home:nodeMemory and home:nodeCpus, the limits docker update sets
+// on every node
+func loadNodeResources(cfg *homeConfig, clusterCfg *ClusterConfig) error {
+	network, memory, cpus := cfg.Get("kindNetwork"), cfg.Get("nodeMemory"), cfg.Get("nodeCpus")
+	if network == "" && memory == "" && cpus == "" {
+		return nil
+	}
+	if clusterCfg.Backend != "kind" || !clusterCfg.Provision {
+		return fmt.Errorf("%[1]s:kindNetwork, %[1]s:nodeMemory and %[1]s:nodeCpus require %[1]s:clusterBackend=kind and %[1]s:provisionCluster=true", configNamespace)
+	}
+	if network != "" {
+		if err := kind.ValidateNetwork(network); err != nil {
+			return fmt.Errorf("invalid %s:kindNetwork: %w", configNamespace, err)
+		}
+		clusterCfg.DockerNetwork = network
+	}
+	if memory != "" {
+		limit, err := kind.ParseMemoryLimit(memory)
+		if err != nil {
+			return fmt.Errorf("invalid %s:nodeMemory: %w", configNamespace, err)
+		}
+		clusterCfg.NodeLimits.Memory = limit
+	}
+	if cpus != "" {
+		limit, err := kind.ParseCPULimit(cpus, runtime.NumCPU())
+		if err != nil {
+			return fmt.Errorf("invalid %s:nodeCpus: %w", configNamespace, err)
+		}
+		clusterCfg.NodeLimits.CPUs = limit
+	}
+	return nil
+}
+
+// loadPersistentVolumes reads home:persistentVolumes, a list of {"name",
+// "hostPath", "containerPath", "size"} objects. The host paths are mounted
+// into the nodes by the generated Kind config, at /var/local-persistent/<name>
+// without a containerPath.
+func loadPersistentVolumes(cfg *homeConfig, clusterCfg *ClusterConfig) error {
+	err := cfg.TryObject("persistentVolumes", &clusterCfg.PersistentVolumes)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return fmt.Errorf("invalid %s:persistentVolumes: %w", configNamespace, err)
+	}
+	if len(clusterCfg.PersistentVolumes) == 0 {
+		if clusterCfg.StorageClass != "" {
+			return fmt.Errorf("%[1]s:storageClass requires %[1]s:persistentVolumes", configNamespace)
+		}
+		return nil
+	}
+	if clusterCfg.Backend != "kind" || !clusterCfg.Provision || clusterCfg.KindConfigPath != "" {
+		return fmt.Errorf("%[1]s:persistentVolumes requires a provisioned Kind cluster without %[1]s:kindConfigPath", configNamespace)
+	}
+	if clusterCfg.StorageClass == "" {
+		clusterCfg.StorageClass = kind.DefaultStorageClass
+	}
+
+	names := make(map[string]bool, len(clusterCfg.PersistentVolumes))
+	for i := range clusterCfg.PersistentVolumes {
+		volume := &clusterCfg.PersistentVolumes[i]
+		if volume.Name == "" || volume.HostPath == "" {
+			return fmt.Errorf("invalid %s:persistentVolumes: entry %d needs a name and a hostPath", configNamespace, i)
+		}
+		if names[volume.Name] {
+			return fmt.Errorf("invalid %s:persistentVolumes: %s is listed twice", configNamespace, volume.Name)
+		}
+		names[volume.Name] = true
+		path, err := expandPath(volume.HostPath)
+		if err != nil {
+			return fmt.Errorf("invalid %s:persistentVolumes: %w", configNamespace, err)
+		}
+		volume.HostPath = path
+		if volume.ContainerPath == "" {
+			volume.ContainerPath = "/var/local-persistent/" + volume.Name
+		}
+		if !filepath.IsAbs(volume.ContainerPath) {
+			return fmt.Errorf("invalid %s:persistentVolumes: containerPath of %s must be absolute", configNamespace, volume.Name)
+		}
+		if volume.Size != "" {
+			if _, err := resource.ParseQuantity(volume.Size); err != nil {
+				return fmt.Errorf("invalid %s:persistentVolumes: size of %s: %w", configNamespace, volume.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// loadInfraPrerequisites reads home:helmRepositories, a list of {"name",
+// "url", "namespace", "type", "interval"} objects, and home:crdManifests, a
+// list of files, directories or URLs
+func loadInfraPrerequisites(cfg *homeConfig, clusterCfg *ClusterConfig) error {
+	err := cfg.TryObject("helmRepositories", &clusterCfg.HelmRepositories)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return fmt.Errorf("invalid %s:helmRepositories: %w", configNamespace, err)
+	}
+	for i, repository := range clusterCfg.HelmRepositories {
+		if repository.Name == "" || repository.URL == "" {
+			return fmt.Errorf("invalid %s:helmRepositories: entry %d needs a name and a url", configNamespace, i)
+		}
+		if repository.Type != "" && repository.Type != "default" && repository.Type != "oci" {
+			return fmt.Errorf("invalid %s:helmRepositories: type %q of %s is not default or oci", configNamespace, repository.Type, repository.Name)
+		}
+	}
+
+	err = cfg.TryObject("crdManifests", &clusterCfg.CRDManifests)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return fmt.Errorf("invalid %s:crdManifests: %w", configNamespace, err)
+	}
+	for i, source := range clusterCfg.CRDManifests {
+		if strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://") {
+			continue
+		}
+		if source == "" {
+			return fmt.Errorf("invalid %s:crdManifests: entry %d is empty", configNamespace, i)
+		}
+		path, err := expandPath(source)
+		if err != nil {
+			return fmt.Errorf("invalid %s:crdManifests: %w", configNamespace, err)
+		}
+		clusterCfg.CRDManifests[i] = path
+	}
+
+	if !clusterCfg.InfraPrerequisites && (len(clusterCfg.HelmRepositories) > 0 || len(clusterCfg.CRDManifests) > 0) {
+		return fmt.Errorf("%[1]s:helmRepositories and %[1]s:crdManifests require %[1]s:infraPrerequisites", configNamespace)
+	}
+	return nil
+}
+
+// loadClusterChecksConfig reads home:clusterChecks (default true) and
+// home:clusterCheckWarnings, a list of checks downgraded to warnings
+func loadClusterChecksConfig(ctx *pulumi.Context) (*ClusterChecksConfig, error) {
+	cfg := newConfig(ctx)
+
+	checksCfg := &ClusterChecksConfig{
+		Enabled: getBool(cfg, "clusterChecks", true),
+	}
+	err := cfg.TryObject("clusterCheckWarnings", &checksCfg.Warnings)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:clusterCheckWarnings: %w", configNamespace, err)
+	}
+	for _, check := range checksCfg.Warnings {
+		if strings.TrimSpace(check) == "" {
+			return nil, fmt.Errorf("invalid %s:clusterCheckWarnings, checks must not be empty", configNamespace)
+		}
+	}
+
+	return checksCfg, nil
+}
+
+// loadMeshConfig reads home:mesh, the service mesh of the platform:
+// "linkerd", "istio" or "none". Without it the mesh follows
+// home:enableLinkerd, which predates it: Linkerd unless disabled. The
+// Linkerd flag of components is aligned with the selected mesh.
+func loadMeshConfig(ctx *pulumi.Context, components *ComponentsConfig) (string, error) {
+	cfg := newConfig(ctx)
+
+	mesh := cfg.Get("mesh")
+	switch mesh {
+	case "":
+		mesh = "linkerd"
+		if !components.Linkerd {
+			mesh = "none"
+		}
+	case "linkerd":
+		if !components.Linkerd {
+			return "", fmt.Errorf("%[1]s:mesh is linkerd but %[1]s:enableLinkerd is false, remove one of them", configNamespace)
+		}
+	case "istio", "none":
+		if cfg.Get("enableLinkerd") != "" && components.Linkerd {
+			return "", fmt.Errorf("%[1]s:mesh is %[2]s but %[1]s:enableLinkerd is true, remove %[1]s:enableLinkerd", configNamespace, mesh)
+		}
+	default:
+		return "", fmt.Errorf("invalid %s:mesh %q, use \"linkerd\", \"istio\" or \"none\"", configNamespace, mesh)
+	}
+	components.Linkerd = mesh == "linkerd"
+	components.Istio = mesh == "istio"
+	// linkerd-viz follows Linkerd unless set, see Config.Validate
+	if !components.Linkerd && cfg.Get("enableLinkerdViz") == "" {
+		components.LinkerdViz = false
+	}
+	return mesh, nil
+}
+
+// IstioConfig describes the Istio installation
+type IstioConfig struct {
+	// Version of the Istio charts
+	Version string
+	// Values of the base, istiod and gateway charts
+	Values istio.Values
+	// Install the ingress gateway
+	Gateway bool
+	// How long to wait for each release to be ready
+	Timeout time.Duration
+}
+
+const (
+	// defaultIstioVersion is used when home:istioVersion is not set
+	defaultIstioVersion = "1.27.1"
+	// defaultIstioTimeout is used when home:istioTimeout is not set
+	defaultIstioTimeout = 10 * time.Minute
+)
+
+// loadIstioConfig reads the Istio settings from Pulumi config:
+// home:istioVersion, home:istioValues ({"base": {...}, "istiod": {...},
+// "gateway": {...}}), home:istioGateway (default true) and home:istioTimeout
+func loadIstioConfig(ctx *pulumi.Context) (*IstioConfig, error) {
+	cfg := newConfig(ctx)
+
+	istioCfg := &IstioConfig{
+		Version: cfg.Get("istioVersion"),
+		Gateway: getBool(cfg, "istioGateway", true),
+	}
+	if istioCfg.Version == "" {
+		istioCfg.Version = defaultIstioVersion
+	}
+
+	err := cfg.TryObject("istioValues", &istioCfg.Values)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:istioValues: %w", configNamespace, err)
+	}
+
+	if istioCfg.Timeout, err = getDuration(cfg, "istioTimeout", defaultIstioTimeout); err != nil {
+		return nil, err
+	}
+
+	return istioCfg, nil
+}
+
+// FlaggerConfig describes the Flagger installation
+type FlaggerConfig struct {
+	// Chart versions
+	Version           string
+	LoadtesterVersion string
+	// Install the load tester for the analysis webhooks
+	Loadtester bool
+	// Flagger meshProvider: the mesh, or "kubernetes" (blue/green) without one
+	MeshProvider string
+	// Prometheus the canary metrics are queried from
+	MetricsServer string
+	// Analysis of the Canaries
+	Analysis flagger.Analysis
+	// Deployments to create a Canary for
+	Canaries []flagger.Target
+	// How long to wait for each release to be ready
+	Timeout time.Duration
+	// Canary demo verifying a promotion end to end, nil when disabled
+	Demo *CanaryDemoConfig
+}
+
+// CanaryDemoConfig describes the canary demo run after Flagger is installed
+type CanaryDemoConfig struct {
+	// Analysis of the demo Canary, the shared one with its own success rate
+	Analysis flagger.Analysis
+	// How long the demo may take until the canary is promoted
+	Timeout time.Duration
+	// Leave the demo namespace in place afterwards
+	Keep bool
+}
+
+const (
+	// defaultFlaggerVersion is used when home:flaggerVersion is not set
+	defaultFlaggerVersion = "1.41.0"
+	// defaultLoadtesterVersion is used when home:flaggerLoadtesterVersion is not set
+	defaultLoadtesterVersion = "0.34.0"
+	// defaultFlaggerTimeout is used when home:flaggerTimeout is not set
+	defaultFlaggerTimeout = 5 * time.Minute
+	// defaultCanaryDemoTimeout is used when home:canaryDemoTimeout is not set,
+	// long enough for the default analysis to reach maxWeight
+	defaultCanaryDemoTimeout = 15 * time.Minute
+)
+
+// loadFlaggerConfig reads the Flagger settings from Pulumi config:
+// home:flaggerVersion, home:flaggerLoadtester (default false),
+// home:flaggerLoadtesterVersion, home:flaggerMetricsServer (default the
+// Prometheus of Linkerd Viz with Linkerd and without the monitoring stack,
+// else the one of kube-prometheus-stack),
+// home:canaryAnalysis (fields of flagger.Analysis over flagger.DefaultAnalysis),
+// home:canaries (a list of {namespace, deployment, port}) and home:flaggerTimeout
+func loadFlaggerConfig(ctx *pulumi.Context, mesh string, components *ComponentsConfig) (*FlaggerConfig, error) {
+	cfg := newConfig(ctx)
+
+	flaggerCfg := &FlaggerConfig{
+		Version:           cfg.Get("flaggerVersion"),
+		LoadtesterVersion: cfg.Get("flaggerLoadtesterVersion"),
+		Loadtester:        cfg.GetBool("flaggerLoadtester"),
+		MeshProvider:      mesh,
+		MetricsServer:     cfg.Get("flaggerMetricsServer"),
+		Analysis:          flagger.DefaultAnalysis,
+	}
+	if flaggerCfg.Version == "" {
+		flaggerCfg.Version = defaultFlaggerVersion
+	}
+	if flaggerCfg.LoadtesterVersion == "" {
+		flaggerCfg.LoadtesterVersion = defaultLoadtesterVersion
+	}
+	if mesh == "none" {
+		flaggerCfg.MeshProvider = "kubernetes"
+	}
+	if flaggerCfg.MetricsServer == "" {
+		// kube-prometheus-stack, from the program or the infrastructure tree
+		flaggerCfg.MetricsServer = fmt.Sprintf("http://%s-kube-p-prometheus.%s:9090", monitoring.ReleaseName, monitoring.Namespace)
+		if mesh == "linkerd" && !components.Monitoring {
+			flaggerCfg.MetricsServer = "http://prometheus.linkerd-viz:9090"
+		}
+	}
+
+	err := cfg.TryObject("canaryAnalysis", &flaggerCfg.Analysis)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:canaryAnalysis: %w", configNamespace, err)
+	}
+	analysis := flaggerCfg.Analysis
+	if _, err := time.ParseDuration(analysis.Interval); err != nil {
+		return nil, fmt.Errorf("invalid %s:canaryAnalysis: interval: %w", configNamespace, err)
+	}
+	if analysis.Threshold < 1 {
+		return nil, fmt.Errorf("invalid %s:canaryAnalysis: threshold must be at least 1", configNamespace)
+	}
+	if analysis.StepWeight < 1 || analysis.StepWeight > analysis.MaxWeight || analysis.MaxWeight > 100 {
+		return nil, fmt.Errorf("invalid %s:canaryAnalysis: want 0 < stepWeight <= maxWeight <= 100, got %d and %d",
+			configNamespace, analysis.StepWeight, analysis.MaxWeight)
+	}
+
+	err = cfg.TryObject("canaries", &flaggerCfg.Canaries)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:canaries: %w", configNamespace, err)
+	}
+	seen := map[string]bool{}
+	for _, target := range flaggerCfg.Canaries {
+		if target.Namespace == "" || target.Deployment == "" || target.Port == 0 {
+			return nil, fmt.Errorf("invalid %s:canaries: every canary needs a namespace, deployment and port", configNamespace)
+		}
+		key := target.Namespace + "/" + target.Deployment
+		if seen[key] {
+			return nil, fmt.Errorf("invalid %s:canaries: %s is listed twice", configNamespace, key)
+		}
+		seen[key] = true
+	}
+	if len(flaggerCfg.Canaries) > 0 && !components.Flagger {
+		return nil, fmt.Errorf("%[1]s:canaries requires %[1]s:enableFlagger=true", configNamespace)
+	}
+
+	if flaggerCfg.Timeout, err = getDuration(cfg, "flaggerTimeout", defaultFlaggerTimeout); err != nil {
+		return nil, err
+	}
+	if flaggerCfg.Demo, err = loadCanaryDemoConfig(cfg, flaggerCfg, components); err != nil {
+		return nil, err
+	}
+
+	return flaggerCfg, nil
+}
+
+// loadCanaryDemoConfig reads home:enableCanaryDemo, which rolls podinfo
+// through a Canary once Flagger is installed, with home:canaryDemoTimeout,
+// home:canaryDemoMinSuccessRate (default the one of home:canaryAnalysis) and
+// home:keepCanaryDemo
+func loadCanaryDemoConfig(cfg *homeConfig, flaggerCfg *FlaggerConfig, components *ComponentsConfig) (*CanaryDemoConfig, error) {
+	if !getBool(cfg, "enableCanaryDemo", false) {
+		if cfg.GetBool("keepCanaryDemo") {
+			return nil, fmt.Errorf("%[1]s:keepCanaryDemo requires %[1]s:enableCanaryDemo", configNamespace)
+		}
+		return nil, nil
+	}
+	if !components.Flagger || !flaggerCfg.Loadtester {
+		return nil, fmt.Errorf("%[1]s:enableCanaryDemo requires %[1]s:enableFlagger and %[1]s:flaggerLoadtester, which drives its traffic", configNamespace)
+	}
+
+	demoCfg := &CanaryDemoConfig{
+		Analysis: flaggerCfg.Analysis,
+		Keep:     cfg.GetBool("keepCanaryDemo"),
+	}
+	if value := cfg.Get("canaryDemoMinSuccessRate"); value != "" {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate <= 0 || rate > 100 {
+			return nil, fmt.Errorf("invalid %s:canaryDemoMinSuccessRate %q, use a percentage", configNamespace, value)
+		}
+		demoCfg.Analysis.MinSuccessRate = rate
+	}
+	var err error
+	if demoCfg.Timeout, err = getDuration(cfg, "canaryDemoTimeout", defaultCanaryDemoTimeout); err != nil {
+		return nil, err
+	}
+	return demoCfg, nil
+}
+
+// CertManagerConfig describes the cert-manager installation
+type CertManagerConfig struct {
+	// Chart version
+	Version string
+	// Cloudflare API token (secret) for the DNS01 issuer, nil when not set
+	CloudflareAPIToken pulumi.StringInput
+	// ACME account email and directory of the DNS01 issuer
+	ACMEEmail  string
+	ACMEServer string
+	// How long to wait for the controller, CRDs and webhook
+	Timeout time.Duration
+}
+
+const (
+	// defaultCertManagerVersion is used when home:certManagerVersion is not set
+	defaultCertManagerVersion = "v1.18.2"
+	// defaultCertManagerTimeout is used when home:certManagerTimeout is not set
+	defaultCertManagerTimeout = 5 * time.Minute
+)
+
+// loadCertManagerConfig reads the cert-manager settings from Pulumi config
+func loadCertManagerConfig(ctx *pulumi.Context) (*CertManagerConfig, error) {
+	cfg := newConfig(ctx)
+
+	certManagerCfg := &CertManagerConfig{
+		Version:    cfg.Get("certManagerVersion"),
+		ACMEEmail:  cfg.Get("acmeEmail"),
+		ACMEServer: cfg.Get("acmeServer"),
+	}
+	if certManagerCfg.Version == "" {
+		certManagerCfg.Version = defaultCertManagerVersion
+	}
+	if !strings.HasPrefix(certManagerCfg.Version, "v") {
+		certManagerCfg.Version = "v" + certManagerCfg.Version
+	}
+	if cfg.Get("cloudflareApiToken") != "" {
+		if certManagerCfg.ACMEEmail == "" {
+			return nil, fmt.Errorf("%[1]s:cloudflareApiToken requires %[1]s:acmeEmail for the ACME account", configNamespace)
+		}
+		certManagerCfg.CloudflareAPIToken = cfg.GetSecret("cloudflareApiToken")
+	}
+
+	var err error
+	if certManagerCfg.Timeout, err = getDuration(cfg, "certManagerTimeout", defaultCertManagerTimeout); err != nil {
+		return nil, err
+	}
+
+	return certManagerCfg, nil
+}
+
+// TunnelConfig describes the Cloudflare Tunnel connector
+type TunnelConfig struct {
+	// Name of the tunnel, defaults to the cluster name
+	Name string
+	// Tunnel token (secret)
+	Token pulumi.StringOutput
+	// Number of cloudflared replicas
+	Replicas int
+	// cloudflared image (optional)
+	Image string
+	// Routes rendered into the cloudflared config (optional)
+	Routes []cloudflare.Route
+	// How long to wait for the Services of the routes
+	Timeout time.Duration
+}
+
+const (
+	// defaultTunnelReplicas is used when home:cloudflareTunnelReplicas is not set
+	defaultTunnelReplicas = 2
+	// defaultTunnelTimeout is used when home:cloudflareTunnelTimeout is not set
+	defaultTunnelTimeout = 5 * time.Minute
+)
+
+// loadTunnelConfig reads the Cloudflare Tunnel settings from Pulumi config.
+// The token is only required when the tunnel is enabled. home:tunnelRoutes
+// lists {hostname, service, namespace, port, scheme, path, originRequest}.
+func loadTunnelConfig(ctx *pulumi.Context, clusterCfg *ClusterConfig, components *ComponentsConfig) (*TunnelConfig, error) {
+	cfg := newConfig(ctx)
+
+	tunnelCfg := &TunnelConfig{
+		Name:     cfg.Get("cloudflareTunnelName"),
+		Replicas: defaultTunnelReplicas,
+		Image:    cfg.Get("cloudflareTunnelImage"),
+	}
+	if tunnelCfg.Name == "" {
+		tunnelCfg.Name = clusterCfg.Name
+	}
+	replicas, err := cfg.TryInt("cloudflareTunnelReplicas")
+	if err == nil {
+		tunnelCfg.Replicas = replicas
+	} else if !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:cloudflareTunnelReplicas: %w", configNamespace, err)
+	}
+
+	if components.CloudflareTunnel && cfg.Get("cloudflareTunnelToken") == "" {
+		return nil, fmt.Errorf("%[1]s:enableCloudflareTunnel requires the secret %[1]s:cloudflareTunnelToken", configNamespace)
+	}
+	tunnelCfg.Token = cfg.GetSecret("cloudflareTunnelToken")
+	if tunnelCfg.Timeout, err = getDuration(cfg, "cloudflareTunnelTimeout", defaultTunnelTimeout); err != nil {
+		return nil, err
+	}
+
+	err = cfg.TryObject("tunnelRoutes", &tunnelCfg.Routes)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:tunnelRoutes: %w", configNamespace, err)
+	}
+	if len(tunnelCfg.Routes) > 0 && !components.CloudflareTunnel {
+		return nil, fmt.Errorf("%[1]s:tunnelRoutes requires %[1]s:enableCloudflareTunnel", configNamespace)
+	}
+	if err := validateTunnelRoutes(tunnelCfg.Routes); err != nil {
+		return nil, fmt.Errorf("invalid %s:tunnelRoutes: %w", configNamespace, err)
+	}
+
+	return tunnelCfg, nil
+}
+
+// validateTunnelRoutes checks each route and that no hostname is routed twice
+func validateTunnelRoutes(routes []cloudflare.Route) error {
+	originRequestKeys := make(map[string]bool, len(cloudflare.OriginRequestKeys))
+	for _, key := range cloudflare.OriginRequestKeys {
+		originRequestKeys[key] = true
+	}
+	hostnames := make(map[string]bool, len(routes))
+	for i, route := range routes {
+		// cloudflared matches a leading wildcard label
+		if msgs := validation.IsDNS1123Subdomain(strings.TrimPrefix(route.Hostname, "*.")); len(msgs) > 0 {
+			return fmt.Errorf("route %d has an invalid hostname %q: %s", i, route.Hostname, strings.Join(msgs, ", "))
+		}
+		if hostnames[route.Hostname] {
+			return fmt.Errorf("%s is routed twice", route.Hostname)
+		}
+		hostnames[route.Hostname] = true
+		if msgs := validation.IsDNS1035Label(route.Service); len(msgs) > 0 {
+			return fmt.Errorf("%s has an invalid service %q", route.Hostname, route.Service)
+		}
+		if msgs := validation.IsDNS1123Label(route.Namespace); len(msgs) > 0 {
+			return fmt.Errorf("%s has an invalid namespace %q", route.Hostname, route.Namespace)
+		}
+		if route.Port < 1 || route.Port > 65535 {
+			return fmt.Errorf("%s has an invalid port %d", route.Hostname, route.Port)
+		}
+		if route.Scheme != "" && route.Scheme != "http" && route.Scheme != "https" {
+			return fmt.Errorf("%s has an invalid scheme %q, use http or https", route.Hostname, route.Scheme)
+		}
+		if route.Path != "" {
+			if _, err := regexp.Compile(route.Path); err != nil {
+				return fmt.Errorf("%s has an invalid path: %w", route.Hostname, err)
+			}
+		}
+		for key := range route.OriginRequest {
+			if !originRequestKeys[key] {
+				return fmt.Errorf("%s has an unknown originRequest option %q", route.Hostname, key)
+			}
+		}
+	}
+	return nil
+}
+
+// defaultUptimeKumaVersion is used when home:uptimeKumaVersion is not set
+const defaultUptimeKumaVersion = "1.23.16"
+
+// loadUptimeKumaConfig reads home:uptimeKumaVersion, home:uptimeKumaHostname,
+// home:uptimeKumaVolume and home:uptimeKumaStorageSize, which require
+// home:enableUptimeKuma. The hostname is added to the tunnel routes, the
+// volume names an entry of home:persistentVolumes the data claim binds to
+// (the claim then defaults to the size of the volume).
+func loadUptimeKumaConfig(ctx *pulumi.Context, components *ComponentsConfig, clusterCfg *ClusterConfig, tunnelCfg *TunnelConfig) (*observability.UptimeKumaConfig, error) {
+	cfg := newConfig(ctx)
+
+	kumaCfg := &observability.UptimeKumaConfig{
+		Version:     cfg.Get("uptimeKumaVersion"),
+		Hostname:    cfg.Get("uptimeKumaHostname"),
+		Volume:      cfg.Get("uptimeKumaVolume"),
+		StorageSize: cfg.Get("uptimeKumaStorageSize"),
+	}
+	if kumaCfg.Version == "" {
+		kumaCfg.Version = defaultUptimeKumaVersion
+	}
+	if !components.UptimeKuma {
+		for _, key := range []string{"uptimeKumaVersion", "uptimeKumaHostname", "uptimeKumaVolume", "uptimeKumaStorageSize"} {
+			if cfg.Get(key) != "" {
+				return nil, fmt.Errorf("%[1]s:%[2]s requires %[1]s:enableUptimeKuma", configNamespace, key)
+			}
+		}
+		return kumaCfg, nil
+	}
+
+	if kumaCfg.Volume != "" {
+		var volume *kind.Volume
+		for i := range clusterCfg.PersistentVolumes {
+			if clusterCfg.PersistentVolumes[i].Name == kumaCfg.Volume {
+				volume = &clusterCfg.PersistentVolumes[i]
+			}
+		}
+		if volume == nil {
+			return nil, fmt.Errorf("invalid %[1]s:uptimeKumaVolume: %[2]s is not in %[1]s:persistentVolumes", configNamespace, kumaCfg.Volume)
+		}
+		if kumaCfg.StorageSize == "" {
+			kumaCfg.StorageSize = volume.Size
+		}
+		if kumaCfg.StorageSize == "" {
+			kumaCfg.StorageSize = kind.DefaultVolumeSize
+		}
+	}
+	if kumaCfg.StorageSize != "" {
+		if _, err := resource.ParseQuantity(kumaCfg.StorageSize); err != nil {
+			return nil, fmt.Errorf("invalid %s:uptimeKumaStorageSize: %w", configNamespace, err)
+		}
+	}
+
+	if kumaCfg.Hostname != "" {
+		if !components.CloudflareTunnel {
+			return nil, fmt.Errorf("%[1]s:uptimeKumaHostname requires %[1]s:enableCloudflareTunnel", configNamespace)
+		}
+		tunnelCfg.Routes = append(tunnelCfg.Routes, cloudflare.Route{
+			Hostname:  kumaCfg.Hostname,
+			Service:   uptimekuma.ServiceName,
+			Namespace: uptimekuma.Namespace,
+			Port:      uptimekuma.Port,
+		})
+		if err := validateTunnelRoutes(tunnelCfg.Routes); err != nil {
+			return nil, fmt.Errorf("invalid %s:uptimeKumaHostname: %w", configNamespace, err)
+		}
+	}
+
+	return kumaCfg, nil
+}
+
+// DDNSConfig describes the Cloudflare DDNS updater
+type DDNSConfig struct {
+	// Deploy the updater, set when cloudflare:apiToken is present
+	Enabled bool
+	// Cloudflare API token (secret) and zone from the cloudflare: namespace
+	Token pulumi.StringOutput
+	Zone  string
+	// Records to update, relative to Zone or fully qualified
+	Records []string
+}
+
+// ddnsRequiredStacks are the stacks that must run the DDNS updater
+var ddnsRequiredStacks = map[string]bool{
+	"homelab": true,
+}
+
+// loadDDNSConfig reads the DDNS settings. The token and zone live in the
+// cloudflare: namespace (cloudflare:apiToken, cloudflare:zone), the records
+// in home:ddnsRecords (default the zone apex).
+func loadDDNSConfig(ctx *pulumi.Context) (*DDNSConfig, error) {
+	cfg := newConfig(ctx)
+	cloudflareCfg := config.New(ctx, "cloudflare")
+
+	ddnsCfg := &DDNSConfig{
+		Enabled: cloudflareCfg.Get("apiToken") != "",
+		Zone:    cloudflareCfg.Get("zone"),
+	}
+	if !ddnsCfg.Enabled {
+		if ddnsRequiredStacks[stacks.Stack(ctx)] {
+			return nil, fmt.Errorf("stack %s runs the Cloudflare DDNS updater and needs an API token, set it with: pulumi config set --secret cloudflare:apiToken <token>",
+				stacks.Stack(ctx))
+		}
+		return ddnsCfg, nil
+	}
+	if ddnsCfg.Zone == "" {
+		return nil, fmt.Errorf("cloudflare:apiToken requires cloudflare:zone for the DDNS records")
+	}
+	ddnsCfg.Token = cloudflareCfg.GetSecret("apiToken")
+
+	err := cfg.TryObject("ddnsRecords", &ddnsCfg.Records)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:ddnsRecords: %w", configNamespace, err)
+	}
+	if len(ddnsCfg.Records) == 0 {
+		ddnsCfg.Records = []string{"@"}
+	}
+
+	return ddnsCfg, nil
+}
+
+// ExternalDNSConfig describes the external-dns installation
+type ExternalDNSConfig struct {
+	// Chart version
+	Version string
+	// Cloudflare API token (secret) from cloudflare:apiToken
+	Token pulumi.StringOutput
+	// Domains records are managed in, defaults to cloudflare:zone
+	DomainFilters []string
+	// Owner ID of the TXT registry records, defaults to the cluster name
+	TXTOwnerID string
+	// "sync" or "upsert-only" (default)
+	Policy string
+}
+
+// defaultExternalDNSVersion is used when home:externalDnsVersion is not set
+const defaultExternalDNSVersion = "1.18.0"
+
+// loadExternalDNSConfig reads the external-dns settings from Pulumi config.
+// The token is only required when external-dns is enabled.
+func loadExternalDNSConfig(ctx *pulumi.Context, clusterCfg *ClusterConfig, components *ComponentsConfig) (*ExternalDNSConfig, error) {
+	cfg := newConfig(ctx)
+	cloudflareCfg := config.New(ctx, "cloudflare")
+
+	externalDNSCfg := &ExternalDNSConfig{
+		Version:    cfg.Get("externalDnsVersion"),
+		TXTOwnerID: cfg.Get("externalDnsOwnerId"),
+		Policy:     cfg.Get("externalDnsPolicy"),
+	}
+	if externalDNSCfg.Version == "" {
+		externalDNSCfg.Version = defaultExternalDNSVersion
+	}
+	if externalDNSCfg.TXTOwnerID == "" {
+		externalDNSCfg.TXTOwnerID = clusterCfg.Name
+	}
+	if externalDNSCfg.Policy == "" {
+		externalDNSCfg.Policy = "upsert-only"
+	}
+	if externalDNSCfg.Policy != "sync" && externalDNSCfg.Policy != "upsert-only" {
+		return nil, fmt.Errorf("invalid %s:externalDnsPolicy %q, use \"sync\" or \"upsert-only\"", configNamespace, externalDNSCfg.Policy)
+	}
+
+	err := cfg.TryObject("externalDnsDomains", &externalDNSCfg.DomainFilters)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:externalDnsDomains: %w", configNamespace, err)
+	}
+	if len(externalDNSCfg.DomainFilters) == 0 && cloudflareCfg.Get("zone") != "" {
+		externalDNSCfg.DomainFilters = []string{cloudflareCfg.Get("zone")}
+	}
+
+	if components.ExternalDNS {
+		if cloudflareCfg.Get("apiToken") == "" {
+			return nil, fmt.Errorf("%s:enableExternalDns requires the secret cloudflare:apiToken", configNamespace)
+		}
+		if len(externalDNSCfg.DomainFilters) == 0 {
+			return nil, fmt.Errorf("%s:enableExternalDns requires %s:externalDnsDomains or cloudflare:zone", configNamespace, configNamespace)
+		}
+	}
+	externalDNSCfg.Token = cloudflareCfg.GetSecret("apiToken")
+
+	return externalDNSCfg, nil
+}
+
+// NamespaceConfig is a namespace created by the program
+type NamespaceConfig struct {
+	Name        string            `json:"name"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// defaultNamespaces is used when home:namespaces is not set
+var defaultNamespaces = []NamespaceConfig{
+	{Name: cloudflare.DDNSNamespace},
+}
+
+// loadNamespacesConfig reads home:namespaces, a list of
+// {name, labels, annotations} objects, making sure the namespaces required by
+// the enabled components are part of it and none is created twice
+func loadNamespacesConfig(ctx *pulumi.Context, components *ComponentsConfig, ddnsCfg *DDNSConfig, postgresCfg *PostgresConfig, externalSecretsCfg *ExternalSecretsConfig) ([]NamespaceConfig, error) {
+	cfg := newConfig(ctx)
+
+	var namespaces []NamespaceConfig
+	err := cfg.TryObject("namespaces", &namespaces)
+	if errors.Is(err, config.ErrMissingVar) {
+		namespaces = append(namespaces, defaultNamespaces...)
+	} else if err != nil {
+		return nil, fmt.Errorf("invalid %s:namespaces: %w", configNamespace, err)
+	}
+
+	// Namespaces the enabled components create themselves
+	owned := map[string]string{}
+	if components.ExternalDNS {
+		owned[externaldns.Namespace] = "enableExternalDns"
+	}
+	if components.CloudflareTunnel {
+		owned[cloudflare.Namespace] = "enableCloudflareTunnel"
+	}
+	if components.Monitoring {
+		owned[monitoring.Namespace] = "enableMonitoring"
+	}
+	if components.Logging {
+		owned[logging.Namespace] = "enableLogging"
+	}
+	if components.Tracing {
+		owned[tracing.Namespace] = "enableTracing"
+	}
+	if components.Flagger {
+		owned[flagger.Namespace] = "enableFlagger"
+	}
+	if components.MinIO {
+		owned[minio.Namespace] = "enableMinio"
+	}
+	if components.Velero {
+		owned[velero.Namespace] = "enableVelero"
+	}
+	if components.Postgres {
+		owned[postgresCfg.Namespace] = "enablePostgres"
+		owned[cnpg.OperatorNamespace] = "enablePostgres"
+	}
+	if components.ExternalSecrets {
+		owned[externalsecrets.Namespace] = "enableExternalSecrets"
+	}
+	if components.Kyverno {
+		owned[kyverno.Namespace] = "enableKyverno"
+	}
+	if components.MetricsServer {
+		owned[metricsserver.Namespace] = "enableMetricsServer"
+	}
+	if components.KEDA {
+		owned[keda.Namespace] = "enableKeda"
+	}
+	if components.UptimeKuma {
+		owned[uptimekuma.Namespace] = "enableUptimeKuma"
+	}
+	if components.Istio {
+		owned[istio.Namespace] = "mesh"
+		owned[istio.GatewayNamespace] = "mesh"
+	}
+
+	seen := map[string]bool{}
+	for _, ns := range namespaces {
+		if flag, ok := owned[ns.Name]; ok {
+			return nil, fmt.Errorf("invalid %[1]s:namespaces: %[2]s is created by %[1]s:%[3]s, remove it from the list",
+				configNamespace, ns.Name, flag)
+		}
+		if ns.Name == "" {
+			return nil, fmt.Errorf("invalid %s:namespaces: every namespace needs a name", configNamespace)
+		}
+		if seen[ns.Name] {
+			return nil, fmt.Errorf("invalid %s:namespaces: %s is listed twice", configNamespace, ns.Name)
+		}
+		seen[ns.Name] = true
+	}
+	if ddnsCfg.Enabled && !seen[cloudflare.DDNSNamespace] {
+		namespaces = append(namespaces, NamespaceConfig{Name: cloudflare.DDNSNamespace})
+		seen[cloudflare.DDNSNamespace] = true
+	}
+	// The managed secrets need their namespace before the infrastructure
+	// creates it
+	for _, secret := range externalSecretsCfg.Secrets {
+		if _, ok := owned[secret.Namespace]; !ok && !seen[secret.Namespace] {
+			namespaces = append(namespaces, NamespaceConfig{Name: secret.Namespace})
+			seen[secret.Namespace] = true
+		}
+	}
+
+	return namespaces, nil
+}
+
+// NetworkPolicyConfig describes the namespaces isolated by default-deny
+// NetworkPolicies
+type NetworkPolicyConfig struct {
+	// Isolate the namespaces created by the program
+	Enabled bool
+	// Namespaces created by the program that are isolated
+	Namespaces []string
+	// Other namespaces isolated once the infrastructure created them
+	Extra []string
+	// Deny egress too, except DNS, the Kubernetes API and the Linkerd
+	// control plane
+	DenyEgress bool
+}
+
+// loadNetworkPolicyConfig reads home:networkPolicies (default false), which
+// isolates the namespaces of home:namespaces and those of
+// home:networkPolicyNamespaces. Namespaces in home:networkPolicyOptOut or
+// annotated with home.lucena.cloud/network-policy=disabled are left open.
+func loadNetworkPolicyConfig(ctx *pulumi.Context, namespaces []NamespaceConfig) (*NetworkPolicyConfig, error) {
+	cfg := newConfig(ctx)
+
+	netpolCfg := &NetworkPolicyConfig{
+		Enabled:    getBool(cfg, "networkPolicies", false),
+		DenyEgress: getBool(cfg, "networkPolicyDenyEgress", false),
+	}
+	var extra, optOut []string
+	err := cfg.TryObject("networkPolicyNamespaces", &extra)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:networkPolicyNamespaces: %w", configNamespace, err)
+	}
+	err = cfg.TryObject("networkPolicyOptOut", &optOut)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:networkPolicyOptOut: %w", configNamespace, err)
+	}
+	if !netpolCfg.Enabled {
+		if len(extra) > 0 {
+			return nil, fmt.Errorf("%[1]s:networkPolicyNamespaces requires %[1]s:networkPolicies=true", configNamespace)
+		}
+		return netpolCfg, nil
+	}
+
+	skip := map[string]bool{}
+	for _, ns := range optOut {
+		skip[ns] = true
+	}
+	created := map[string]bool{}
+	for _, ns := range namespaces {
+		created[ns.Name] = true
+		if skip[ns.Name] || ns.Annotations[netpol.OptOutAnnotation] == "disabled" {
+			continue
+		}
+		netpolCfg.Namespaces = append(netpolCfg.Namespaces, ns.Name)
+	}
+	for _, ns := range extra {
+		if created[ns] {
+			return nil, fmt.Errorf("invalid %s:networkPolicyNamespaces: %s is created by the program and already isolated", configNamespace, ns)
+		}
+		if !skip[ns] {
+			netpolCfg.Extra = append(netpolCfg.Extra, ns)
+		}
+	}
+
+	return netpolCfg, nil
+}
+
+// defaultServiceURLs are the Services looked up by default after the deploy
+var defaultServiceURLs = []kube.ServiceRef{
+	{Name: "grafana", Namespace: "prometheus", Service: "prometheus-operator-grafana"},
+	{Name: "prometheus", Namespace: "prometheus", Service: "prometheus-operator-kube-p-prometheus", Port: 9090},
+	{Name: "linkerdViz", Namespace: "linkerd-viz", Service: "web", Port: 8084},
+}
+
+// loadServiceURLsConfig reads home:serviceUrls, a list of
+// {name, namespace, service, port} objects added to the default ones (an
+// entry with the name of a default replaces it)
+func loadServiceURLsConfig(ctx *pulumi.Context) ([]kube.ServiceRef, error) {
+	cfg := newConfig(ctx)
+
+	var extra []kube.ServiceRef
+	err := cfg.TryObject("serviceUrls", &extra)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:serviceUrls: %w", configNamespace, err)
+	}
+
+	refs := append([]kube.ServiceRef{}, defaultServiceURLs...)
+	seen := map[string]bool{}
+	for _, ref := range extra {
+		if ref.Name == "" || ref.Namespace == "" || ref.Service == "" {
+			return nil, fmt.Errorf("invalid %s:serviceUrls: every entry needs a name, namespace and service", configNamespace)
+		}
+		if seen[ref.Name] {
+			return nil, fmt.Errorf("invalid %s:serviceUrls: %s is listed twice", configNamespace, ref.Name)
+		}
+		seen[ref.Name] = true
+
+		replaced := false
+		for i := range refs {
+			if refs[i].Name == ref.Name {
+				refs[i], replaced = ref, true
+			}
+		}
+		if !replaced {
+			refs = append(refs, ref)
+		}
+	}
+
+	return refs, nil
+}
+
+// loadPortForwardsConfig reads home:portForwards, a list of
+// namespace/service:localPort:remotePort forwards, e.g.
+// ["prometheus/prometheus-operator-grafana:3000:80", "linkerd-viz/web:8084:8084"]
+func loadPortForwardsConfig(ctx *pulumi.Context) ([]portforward.Forward, error) {
+	cfg := newConfig(ctx)
+
+	var specs []string
+	err := cfg.TryObject("portForwards", &specs)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:portForwards: %w", configNamespace, err)
+	}
+
+	forwards := make([]portforward.Forward, 0, len(specs))
+	localPorts := map[int]string{}
+	for _, spec := range specs {
+		forward, err := portforward.Parse(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s:portForwards: %w", configNamespace, err)
+		}
+		if other, ok := localPorts[forward.LocalPort]; ok {
+			return nil, fmt.Errorf("invalid %s:portForwards: %s and %s both use local port %d", configNamespace, other, spec, forward.LocalPort)
+		}
+		localPorts[forward.LocalPort] = spec
+		forwards = append(forwards, forward)
+	}
+
+	return forwards, nil
+}
+
+// SmokeTestsConfig describes the checks run against the cluster after the deploy
+type SmokeTestsConfig struct {
+	// Endpoints requested from inside the cluster, none disables the smoke tests
+	Checks []smoketest.Check
+	// Namespace of the test pod
+	Namespace string
+	// Image of the test pod, with sh and curl
+	Image string
+}
+
+// NotifyConfig describes the webhook notified when an update finishes
+type NotifyConfig struct {
+	// Incoming webhook URL, it holds the token
+	Webhook string
+	// Payload format: "slack" or "discord"
+	Format string
+	// Notify successful updates too, not only failures
+	OnSuccess bool
+}
+
+// loadNotifyConfig reads home:notifyWebhook, set as a secret, with
+// home:notifyFormat (default slack) and home:notifyOnSuccess (default true).
+// It returns nil when no webhook is set. It is read before the rest of the
+// config so that invalid config is notified too.
+func loadNotifyConfig(ctx *pulumi.Context) (*NotifyConfig, error) {
+	cfg := newConfig(ctx)
+
+	webhook := cfg.Get("notifyWebhook")
+	if webhook == "" {
+		return nil, nil
+	}
+	notifyCfg := &NotifyConfig{
+		Webhook:   webhook,
+		Format:    cfg.Get("notifyFormat"),
+		OnSuccess: getBool(cfg, "notifyOnSuccess", true),
+	}
+	if notifyCfg.Format == "" {
+		notifyCfg.Format = notify.Slack
+	}
+	if notifyCfg.Format != notify.Slack && notifyCfg.Format != notify.Discord {
+		return nil, fmt.Errorf("invalid %s:notifyFormat %q, must be %s or %s", configNamespace, notifyCfg.Format, notify.Slack, notify.Discord)
+	}
+	if !strings.HasPrefix(webhook, "https://") && !strings.HasPrefix(webhook, "http://") {
+		return nil, fmt.Errorf("invalid %s:notifyWebhook, must be an http(s) URL", configNamespace)
+	}
+	if !ctx.IsConfigSecret(configNamespace + ":notifyWebhook") {
+		_ = ctx.Log.Warn(fmt.Sprintf("%[1]s:notifyWebhook holds the webhook token, set it with: pulumi config set --secret %[1]s:notifyWebhook <url>", configNamespace), nil)
+	}
+
+	return notifyCfg, nil
+}
+
+// loadReportPath reads home:deployReport, the path of the deployment report
+// (default ./deploy-report-<stack>.json, or ./deploy-report-<stack>-<member>.json
+// for a member of an umbrella stack), made absolute so the exported path
+// doesn't depend on where it is read from
+func loadReportPath(ctx *pulumi.Context) (string, error) {
+	cfg := newConfig(ctx)
+
+	path := cfg.Get("deployReport")
+	if path == "" {
+		path = fmt.Sprintf("deploy-report-%s.json", ctx.Stack())
+		if member := stacks.Prefix(ctx); member != "" {
+			path = fmt.Sprintf("deploy-report-%s-%s.json", ctx.Stack(), member)
+		}
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s:deployReport: %w", configNamespace, err)
+	}
+	return abs, nil
+}
+
+// homepagePasswords are the Secrets the homepage chart of the infrastructure
+// tree reads its database and Redis passwords from
+var homepagePasswords = []credentials.Password{
+	{Name: "database", Namespace: "bruno", Secret: "bruno-site-db-secret", Key: "password"},
+	{Name: "redis", Namespace: "bruno", Secret: "bruno-site-redis-secret", Key: "password"},
+}
+
+// loadRotate reads home:rotate, a counter bumped to generate every password
+// again (default 0)
+func loadRotate(ctx *pulumi.Context) (int, error) {
+	cfg := newConfig(ctx)
+
+	rotate, err := cfg.TryInt("rotate")
+	if errors.Is(err, config.ErrMissingVar) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s:rotate: %w", configNamespace, err)
+	}
+	if rotate < 0 {
+		return 0, fmt.Errorf("invalid %s:rotate %d, it counts up from 0", configNamespace, rotate)
+	}
+	return rotate, nil
+}
+
+const (
+	// defaultProvisionLogLines is used when home:provisionLogLines is not set
+	defaultProvisionLogLines = 20
+	// maxProvisionLogLines keeps the provisionLogs output, stored in the
+	// state with every update, small
+	maxProvisionLogLines = 200
+)
+
+// loadProvisionLogLines reads home:provisionLogLines, how many lines of the
+// output of each provisioning step are exported
+func loadProvisionLogLines(ctx *pulumi.Context) (int, error) {
+	cfg := newConfig(ctx)
+
+	lines, err := cfg.TryInt("provisionLogLines")
+	if errors.Is(err, config.ErrMissingVar) {
+		return defaultProvisionLogLines, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s:provisionLogLines: %w", configNamespace, err)
+	}
+	if lines < 0 || lines > maxProvisionLogLines {
+		return 0, fmt.Errorf("invalid %s:provisionLogLines %d, use 0 to %d (0 leaves out provisionLogs)", configNamespace, lines, maxProvisionLogLines)
+	}
+	return lines, nil
+}
+
+// loadSmokeTestsConfig reads home:smokeTests, a list of
+// {name, url, expectStatus, optional} checks (e.g. {"name": "podinfo",
+// "url": "http://podinfo.podinfo:9898/readyz"}), and the test pod settings
+// home:smokeTestNamespace (default "default") and home:smokeTestImage
+func loadSmokeTestsConfig(ctx *pulumi.Context) (*SmokeTestsConfig, error) {
+	cfg := newConfig(ctx)
+
+	smokeTestsCfg := &SmokeTestsConfig{
+		Namespace: cfg.Get("smokeTestNamespace"),
+		Image:     cfg.Get("smokeTestImage"),
+	}
+	if smokeTestsCfg.Namespace == "" {
+		smokeTestsCfg.Namespace = "default"
+	}
+	if smokeTestsCfg.Image == "" {
+		smokeTestsCfg.Image = smoketest.DefaultImage
+	}
+
+	err := cfg.TryObject("smokeTests", &smokeTestsCfg.Checks)
+	if err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("invalid %s:smokeTests: %w", configNamespace, err)
+	}
+	seen := map[string]bool{}
+	for _, check := range smokeTestsCfg.Checks {
+		if check.Name == "" || check.URL == "" {
+			return nil, fmt.Errorf("invalid %s:smokeTests: every check needs a name and a url", configNamespace)
+		}
+		if seen[check.Name] {
+			return nil, fmt.Errorf("invalid %s:smokeTests: %s is listed twice", configNamespace, check.Name)
+		}
+		seen[check.Name] = true
+	}
+
+	return smokeTestsCfg, nil
+}
+
+// ComponentsConfig selects which platform components are installed
+type ComponentsConfig struct {
+	Flux           bool
+	Linkerd        bool
+	LinkerdViz     bool
+	Infrastructure bool
+	// Local registry on the host wired into the cluster (default false)
+	LocalRegistry bool
+	// MetalLB for Services of type LoadBalancer (default false)
+	MetalLB bool
+	// ingress-nginx controller (default false)
+	Ingress bool
+	// cert-manager with bootstrap ClusterIssuers (default false)
+	CertManager bool
+	// cloudflared for the Cloudflare Tunnel (default false)
+	CloudflareTunnel bool
+	// external-dns with the Cloudflare provider (default false)
+	ExternalDNS bool
+	// kube-prometheus-stack installed before Flux (default false)
+	Monitoring bool
+	// Loki and Promtail installed before Flux (default false)
+	Logging bool
+	// Tempo installed before Flux (default false)
+	Tracing bool
+	// Flagger installed after the mesh (default false)
+	Flagger bool
+	// MinIO as the in-cluster S3 endpoint (default false)
+	MinIO bool
+	// Velero backing up to MinIO or external S3 (default false)
+	Velero bool
+	// CloudNativePG with a Postgres cluster (default false)
+	Postgres bool
+	// Sealed Secrets controller installed before Flux (default false)
+	SealedSecrets bool
+	// External Secrets Operator serving home:managedSecrets (default false)
+	ExternalSecrets bool
+	// Kyverno with the baseline policies (default false)
+	Kyverno bool
+	// metrics-server for kubectl top and HPAs (default false)
+	MetricsServer bool
+	// KEDA scaling the home:scaledObjects (default false)
+	KEDA bool
+	// blackbox exporter probing the home:blackboxProbes (default false)
+	BlackboxExporter bool
+	// Uptime Kuma status page (default false)
+	UptimeKuma bool
+	// Web UI of Flux, see home:fluxUI (default false)
+	FluxUI bool
+	// Istio installed as the mesh, set from home:mesh
+	Istio bool
+	// Envoy Gateway as the ingress controller, set from home:ingressController
+	EnvoyGateway bool
+}
+
+// loadComponentsConfig reads the home:enable* flags, all default to true
+// (Linkerd and the infrastructure tree default to false on a ci stack)
+// except the optional add-ons (home:enableLocalRegistry, home:enableMetallb,
+// home:enableIngress, home:enableCertManager, home:enableCloudflareTunnel,
+// home:enableExternalDns, home:enableMonitoring, home:enableLogging,
+// home:enableTracing, home:enableFlagger, home:enableMinio, home:enableVelero,
+// home:enablePostgres, home:enableSealedSecrets,
+// home:enableExternalSecrets, home:enableKyverno,
+// home:enableMetricsServer, home:enableKeda, home:enableFluxUI,
+// home:enableBlackboxExporter and home:enableUptimeKuma)
+func loadComponentsConfig(ctx *pulumi.Context, profile string) *ComponentsConfig {
+	cfg := newConfig(ctx)
+
+	return &ComponentsConfig{
+		Flux:             getBool(cfg, "enableFlux", true),
+		Linkerd:          getBool(cfg, "enableLinkerd", profile != profileCI),
+		LinkerdViz:       getBool(cfg, "enableLinkerdViz", true),
+		Infrastructure:   getBool(cfg, "enableInfrastructure", profile != profileCI),
+		LocalRegistry:    getBool(cfg, "enableLocalRegistry", false),
+		MetalLB:          getBool(cfg, "enableMetallb", false),
+		Ingress:          getBool(cfg, "enableIngress", false),
+		CertManager:      getBool(cfg, "enableCertManager", false),
+		CloudflareTunnel: getBool(cfg, "enableCloudflareTunnel", false),
+		ExternalDNS:      getBool(cfg, "enableExternalDns", false),
+		Monitoring:       getBool(cfg, "enableMonitoring", false),
+		Logging:          getBool(cfg, "enableLogging", false),
+		Tracing:          getBool(cfg, "enableTracing", false),
+		Flagger:          getBool(cfg, "enableFlagger", false),
+		MinIO:            getBool(cfg, "enableMinio", false),
+		Velero:           getBool(cfg, "enableVelero", false),
+		Postgres:         getBool(cfg, "enablePostgres", false),
+		SealedSecrets:    getBool(cfg, "enableSealedSecrets", false),
+		ExternalSecrets:  getBool(cfg, "enableExternalSecrets", false),
+		Kyverno:          getBool(cfg, "enableKyverno", false),
+		MetricsServer:    getBool(cfg, "enableMetricsServer", false),
+		KEDA:             getBool(cfg, "enableKeda", false),
+		BlackboxExporter: getBool(cfg, "enableBlackboxExporter", false),
+		UptimeKuma:       getBool(cfg, "enableUptimeKuma", false),
+		FluxUI:           getBool(cfg, "enableFluxUI", false),
+	}
+}
+
+// homeConfig reads the home: keys. A member of an umbrella stack reads
+// <member>:key first, e.g. homelab:workers, and falls back to the home: key
+// shared by the members.
+type homeConfig struct {
+	ctx    *pulumi.Context
+	home   *config.Config
+	member *config.Config
+	prefix string
+}
+
+// newConfig returns the config read by the loaders of the cluster ctx deploys
+func newConfig(ctx *pulumi.Context) *homeConfig {
+	cfg := &homeConfig{ctx: ctx, home: config.New(ctx, configNamespace)}
+	if cfg.prefix = stacks.Prefix(ctx); cfg.prefix != "" {
+		cfg.member = config.New(ctx, cfg.prefix)
+	}
+	return cfg
+}
+
+// scope returns the namespace key is read from
+func (c *homeConfig) scope(key string) *config.Config {
+	if c.member != nil {
+		if _, ok := c.ctx.GetConfig(c.prefix + ":" + key); ok {
+			return c.member
+		}
+	}
+	return c.home
+}
+
+func (c *homeConfig) Get(key string) string { return c.scope(key).Get(key) }
+
+func (c *homeConfig) GetBool(key string) bool { return c.scope(key).GetBool(key) }
+
+func (c *homeConfig) GetSecret(key string) pulumi.StringOutput { return c.scope(key).GetSecret(key) }
+
+func (c *homeConfig) TryInt(key string) (int, error) { return c.scope(key).TryInt(key) }
+
+func (c *homeConfig) TryFloat64(key string) (float64, error) { return c.scope(key).TryFloat64(key) }
+
+func (c *homeConfig) TryObject(key string, output interface{}) error {
+	return c.scope(key).TryObject(key, output)
+}
+
+// getBool reads a boolean from config, returning def when the key is not set
+func getBool(cfg *homeConfig, key string, def bool) bool {
+	if cfg.Get(key) == "" {
+		return def
+	}
+	return cfg.GetBool(key)
+}
+
+// getDuration reads a Go duration string (e.g. "90s", "5m") from config
+func getDuration(cfg *homeConfig, key string, def time.Duration) (time.Duration, error) {
+	value := cfg.Get(key)
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration for %s:%s: %w", configNamespace, key, err)
+	}
+	return d, nil
+}
+
+// expandPath returns path as an absolute path, expanding a leading ~ to the
+// home directory: the CLIs and the Kubernetes provider don't expand it
+func expandPath(path string) (string, error) {
+	if path == "~" || strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(home, path[1:])
+	}
+	return filepath.Abs(path)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cluster-studio/internal/previous"
+	"cluster-studio/pkg/stacks"
+	"cluster-studio/pkg/ttl"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// clusterGenerationEnv is the environment variable of the local commands
+// carrying the generation of the cluster
+const clusterGenerationEnv = "HOME_CLUSTER_GENERATION"
+
+// inClusterCommands are the local commands whose Delete acts on the cluster
+var inClusterCommands = map[string]bool{
+	"install-flux":        true,
+	"bootstrap-flux":      true,
+	"uninstall-flux":      true,
+	"linkerd-install":     true,
+	"linkerd-viz-install": true,
+	"linkerd-peer-link":   true,
+}
+
+// registerFastDestroy marks every Kubernetes resource and in-cluster command
+// as retained on delete, so destroy only drops them from the state and the
+// cluster deletion takes everything with it. In an umbrella stack, only those
+// of the member ctx deploys are.
+func registerFastDestroy(ctx *pulumi.Context) error {
+	return ctx.RegisterStackTransformation(func(args *pulumi.ResourceTransformationArgs) *pulumi.ResourceTransformationResult {
+		name, owned := stacks.Owns(ctx, args.Name)
+		inCluster := strings.HasPrefix(args.Type, "kubernetes:") ||
+			(args.Type == "command:local:Command" && inClusterCommands[name])
+		if !owned || !inCluster {
+			return nil
+		}
+		return &pulumi.ResourceTransformationResult{
+			Props: args.Props,
+			Opts:  append(args.Opts, pulumi.RetainOnDelete(true)),
+		}
+	})
+}
+
+// registerClusterGeneration passes the generation of the cluster to every
+// local command registered afterwards, so the commands installing into a
+// cluster recreated after an out-of-band deletion run again. The commands
+// whose Delete acts on the cluster have an Update, which runs instead of a
+// replacement.
+func registerClusterGeneration(ctx *pulumi.Context, generation int) error {
+	return ctx.RegisterStackTransformation(func(args *pulumi.ResourceTransformationArgs) *pulumi.ResourceTransformationResult {
+		commandArgs, ok := args.Props.(*local.CommandArgs)
+		if _, owned := stacks.Owns(ctx, args.Name); args.Type != "command:local:Command" || !ok || !owned {
+			return nil
+		}
+		environment := pulumi.StringMap{}
+		switch existing := commandArgs.Environment.(type) {
+		case nil:
+		case pulumi.StringMap:
+			for key, value := range existing {
+				environment[key] = value
+			}
+		default:
+			return nil
+		}
+		environment[clusterGenerationEnv] = pulumi.String(strconv.Itoa(generation))
+
+		props := *commandArgs
+		props.Environment = environment
+		return &pulumi.ResourceTransformationResult{Props: &props, Opts: args.Opts}
+	})
+}
+
+// ttlOutput is the stack output with the home:ttl of a ci stack
+const ttlOutput = "ttl"
+
+// stackExpiry returns when a stack living for lifetime expires: lifetime
+// after its first deployment with a TTL, found from the outputs of the
+// previous deployment, so updates don't postpone it but a new home:ttl does
+func stackExpiry(previousDeployment *previous.Deployment, lifetime time.Duration, now time.Time) (time.Time, error) {
+	var previousTTL, previousExpiry string
+	foundTTL, err := previousDeployment.Output(ttlOutput, &previousTTL)
+	if err != nil {
+		return time.Time{}, err
+	}
+	foundExpiry, err := previousDeployment.Output(ttl.ExpiresAtOutput, &previousExpiry)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !foundTTL || !foundExpiry {
+		return now.Add(lifetime).UTC().Truncate(time.Second), nil
+	}
+
+	since, err := time.ParseDuration(previousTTL)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid output %s of the previous deployment: %w", ttlOutput, err)
+	}
+	expiry, err := time.Parse(time.RFC3339, previousExpiry)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid output %s of the previous deployment: %w", ttl.ExpiresAtOutput, err)
+	}
+	return expiry.Add(-since).Add(lifetime).UTC(), nil
+}
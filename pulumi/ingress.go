@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+
+	"cluster-studio/internal/previous"
+	"cluster-studio/pkg/ingress"
+	"cluster-studio/pkg/shell"
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// previousIngressController returns the ingress controller of the last
+// deployment, "" on the first one. Deployments from before
+// home:ingressController only exported whether ingress-nginx was enabled.
+func previousIngressController(previousDeployment *previous.Deployment) (string, error) {
+	var controller string
+	found, err := previousDeployment.Output("ingressController", &controller)
+	if err != nil || found {
+		return controller, err
+	}
+	var enabled map[string]bool
+	found, err = previousDeployment.Output("components", &enabled)
+	if err != nil || !found {
+		return "", err
+	}
+	if enabled["ingress"] {
+		return ingressNginx, nil
+	}
+	return ingressNone, nil
+}
+
+// handoverCommands stop the proxies of a controller, freeing the host ports
+// before the next controller binds them. Pulumi removes the rest of the old
+// controller at the end of the update.
+var handoverCommands = map[string]string{
+	ingressNginx: fmt.Sprintf(`kubectl --context "$KUBE_CONTEXT" -n %s delete deployment,daemonset -l app.kubernetes.io/component=controller --ignore-not-found --wait`,
+		ingress.Namespace),
+	ingressEnvoyGateway: fmt.Sprintf(`kubectl --context "$KUBE_CONTEXT" -n %[1]s delete gateways.gateway.networking.k8s.io %[2]s --ignore-not-found --wait && `+
+		`kubectl --context "$KUBE_CONTEXT" -n %[1]s wait --for=delete pod -l gateway.envoyproxy.io/owning-gateway-name=%[2]s --timeout=5m`,
+		ingress.EnvoyGatewayNamespace, ingress.GatewayName),
+}
+
+// newIngressHandover returns the command stopping the previous ingress
+// controller when home:ingressController changed, nil otherwise. The new
+// controller depends on it so the two never compete for ports 80 and 443.
+func newIngressHandover(ctx *pulumi.Context, previousController, controller string, environment pulumi.StringMap, dependsOn []pulumi.Resource) (pulumi.Resource, error) {
+	command, ok := handoverCommands[previousController]
+	if !ok || previousController == controller {
+		return nil, nil
+	}
+	_ = ctx.Log.Warn(fmt.Sprintf("home:ingressController changed from %s to %s: this update stops %[1]s before installing %[2]s, Ingress and HTTPRoute objects of the other controller stop being served",
+		previousController, controller), nil)
+	return local.NewCommand(ctx, stacks.Name(ctx, "ingress-handover"), &local.CommandArgs{
+		Create:      pulumi.String(command),
+		Environment: environment,
+		Triggers:    pulumi.Array{pulumi.String(previousController + "->" + controller)},
+		Interpreter: shell.Interpreter(),
+	}, pulumi.DependsOn(dependsOn))
+}
@@ -0,0 +1,180 @@
+package adopt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"cluster-studio/pkg/flux"
+	"cluster-studio/pkg/linkerd"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// FieldManager prefixes the field manager of the objects applied by the
+	// Kubernetes provider of Pulumi
+	FieldManager = "pulumi-kubernetes"
+	// VersionLabel is the label flux install sets to the Flux version
+	VersionLabel = "app.kubernetes.io/version"
+)
+
+// Cluster is what Inspect found in a cluster that the stack didn't apply
+type Cluster struct {
+	// Namespaces not applied by Pulumi, name -> labels
+	Namespaces map[string]map[string]string
+	// Flux installed in flux-system, nil without it
+	Flux *Flux
+	// Linkerd installed in its namespace, nil without it
+	Linkerd *Linkerd
+}
+
+// Flux is an installation of Flux found in the cluster
+type Flux struct {
+	// Version it was installed with, empty when flux-system isn't labelled
+	// by flux install
+	Version string
+}
+
+// Linkerd is an installation of the Linkerd control plane found in the cluster
+type Linkerd struct {
+	// Chart version of each Helm release of the control plane found, empty
+	// when it was installed with the Linkerd CLI
+	Releases map[string]string
+	// PEM trust anchors of the control plane, empty while it isn't installed
+	TrustRoots string
+}
+
+// Inspect lists the namespaces of the cluster that weren't applied by Pulumi,
+// and describes the Flux and Linkerd installations found in them
+func Inspect(ctx context.Context, client kubernetes.Interface) (*Cluster, error) {
+	namespaces, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the namespaces: %w", err)
+	}
+	found := &Cluster{Namespaces: map[string]map[string]string{}}
+	for _, namespace := range namespaces.Items {
+		if namespace.DeletionTimestamp != nil || managed(namespace.ManagedFields) {
+			continue
+		}
+		found.Namespaces[namespace.Name] = namespace.Labels
+	}
+
+	for _, namespace := range namespaces.Items {
+		switch namespace.Name {
+		case flux.Namespace:
+			found.Flux = &Flux{Version: namespace.Labels[VersionLabel]}
+		case linkerd.Namespace:
+			if found.Linkerd, err = inspectLinkerd(ctx, client); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return found, nil
+}
+
+// inspectLinkerd reads the Helm releases and the trust anchors of the Linkerd
+// control plane, nil when neither exists
+func inspectLinkerd(ctx context.Context, client kubernetes.Interface) (*Linkerd, error) {
+	found := &Linkerd{Releases: map[string]string{}}
+	for _, name := range []string{linkerd.CRDsRelease, linkerd.ControlPlaneRelease} {
+		version, ok, err := ReleaseVersion(ctx, client, linkerd.Namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			found.Releases[name] = version
+		}
+	}
+	roots, err := client.CoreV1().ConfigMaps(linkerd.Namespace).Get(ctx, linkerd.TrustRootsConfigMap, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		found.TrustRoots = roots.Data["ca-bundle.crt"]
+	case !apierrors.IsNotFound(err):
+		return nil, fmt.Errorf("failed to read the trust anchors of Linkerd: %w", err)
+	}
+	if len(found.Releases) == 0 && found.TrustRoots == "" {
+		return nil, nil
+	}
+	return found, nil
+}
+
+// ReleaseVersion returns the chart version of the deployed Helm release name,
+// read from the Secret Helm stores it in
+func ReleaseVersion(ctx context.Context, client kubernetes.Interface, namespace, name string) (string, bool, error) {
+	secrets, err := client.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("owner=helm,name=%s,status=deployed", name),
+	})
+	if err != nil {
+		return "", false, fmt.Errorf("failed to list the Helm releases of %s/%s: %w", namespace, name, err)
+	}
+	if len(secrets.Items) == 0 {
+		return "", false, nil
+	}
+	// Helm keeps one deployed revision
+	version, err := chartVersion(secrets.Items[0].Data["release"])
+	if err != nil {
+		return "", false, fmt.Errorf("invalid Helm release %s/%s: %w", namespace, name, err)
+	}
+	return version, true, nil
+}
+
+// chartVersion decodes a Helm release, gzipped JSON encoded in base64
+func chartVersion(data []byte) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return "", err
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(decoded))
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	raw, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	var release struct {
+		Chart struct {
+			Metadata struct {
+				Version string `json:"version"`
+			} `json:"metadata"`
+		} `json:"chart"`
+	}
+	if err := json.Unmarshal(raw, &release); err != nil {
+		return "", err
+	}
+	return release.Chart.Metadata.Version, nil
+}
+
+// managed reports whether the Kubernetes provider of Pulumi applied fields of
+// the object
+func managed(fields []metav1.ManagedFieldsEntry) bool {
+	for _, entry := range fields {
+		if strings.HasPrefix(entry.Manager, FieldManager) {
+			return true
+		}
+	}
+	return false
+}
+
+// LabelConflicts returns the labels of required set to another value in
+// labels, as key=value (wanted)
+func LabelConflicts(labels, required map[string]string) []string {
+	var conflicts []string
+	for key, value := range required {
+		if existing, ok := labels[key]; ok && existing != value {
+			conflicts = append(conflicts, fmt.Sprintf("%s=%s (wanted %s)", key, existing, value))
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
@@ -0,0 +1,154 @@
+package adopt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"reflect"
+	"testing"
+
+	"cluster-studio/internal/pulumitest"
+	"cluster-studio/pkg/flux"
+	"cluster-studio/pkg/linkerd"
+
+	pulumicorev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	pulumimetav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// namespace returns a namespace applied by manager
+func namespace(name, manager string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:          name,
+		Labels:        labels,
+		ManagedFields: []metav1.ManagedFieldsEntry{{Manager: manager}},
+	}}
+}
+
+// helmRelease returns the Secret Helm stores a deployed release of chart
+// version in
+func helmRelease(t *testing.T, name, version string) *corev1.Secret {
+	t.Helper()
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write([]byte(`{"name":"` + name + `","chart":{"metadata":{"version":"` + version + `"}}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "sh.helm.release.v1." + name + ".v1",
+			Namespace: linkerd.Namespace,
+			Labels:    map[string]string{"owner": "helm", "name": name, "status": "deployed"},
+		},
+		Data: map[string][]byte{"release": []byte(base64.StdEncoding.EncodeToString(compressed.Bytes()))},
+	}
+}
+
+func TestInspect(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		namespace("external-dns", "kubectl-create", map[string]string{"team": "dns"}),
+		namespace("apps", "pulumi-kubernetes-8e2b1f", nil),
+		namespace(flux.Namespace, "kustomize-controller", map[string]string{VersionLabel: "v2.6.4"}),
+		namespace(linkerd.Namespace, "helm", nil),
+		helmRelease(t, linkerd.ControlPlaneRelease, "2025.9.2"),
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: linkerd.TrustRootsConfigMap, Namespace: linkerd.Namespace},
+			Data:       map[string]string{"ca-bundle.crt": "anchor"},
+		},
+	)
+
+	found, err := Inspect(context.Background(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := found.Namespaces["apps"]; ok {
+		t.Error("a namespace applied by Pulumi is adopted")
+	}
+	if got := found.Namespaces["external-dns"]["team"]; got != "dns" {
+		t.Errorf("labels of external-dns = %v", found.Namespaces["external-dns"])
+	}
+	if found.Flux == nil || found.Flux.Version != "v2.6.4" {
+		t.Errorf("Flux = %+v, expected v2.6.4", found.Flux)
+	}
+	want := &Linkerd{Releases: map[string]string{linkerd.ControlPlaneRelease: "2025.9.2"}, TrustRoots: "anchor"}
+	if !reflect.DeepEqual(found.Linkerd, want) {
+		t.Errorf("Linkerd = %+v, expected %+v", found.Linkerd, want)
+	}
+}
+
+func TestInspectEmptyCluster(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		namespace("default", "kube-apiserver", nil),
+		namespace(linkerd.Namespace, "kubectl-create", nil),
+	)
+
+	found, err := Inspect(context.Background(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Flux != nil || found.Linkerd != nil {
+		t.Errorf("found Flux %+v and Linkerd %+v in an empty cluster", found.Flux, found.Linkerd)
+	}
+}
+
+func TestLabelConflicts(t *testing.T) {
+	got := LabelConflicts(
+		map[string]string{"team": "dns", "env": "dev", "extra": "x"},
+		map[string]string{"team": "platform", "env": "dev", "owner": "home", "tier": "a"},
+	)
+	if want := []string{"team=dns (wanted platform)"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("conflicts = %v, expected %v", got, want)
+	}
+}
+
+func TestRegisterNamespaces(t *testing.T) {
+	m := &pulumitest.Mocks{}
+	var namespaces *Namespaces
+	err := pulumitest.Run(t, "test", nil, m, func(ctx *pulumi.Context) error {
+		var err error
+		existing := map[string]map[string]string{"external-dns": nil}
+		namespaces, err = RegisterNamespaces(ctx, existing, []string{"velero"})
+		if err != nil {
+			return err
+		}
+		for _, name := range []string{"external-dns", "velero", "apps"} {
+			_, err := pulumicorev1.NewNamespace(ctx, name, &pulumicorev1.NamespaceArgs{
+				Metadata: &pulumimetav1.ObjectMetaArgs{
+					Name:        pulumi.String(name),
+					Annotations: pulumi.StringMap{"owner": pulumi.String("home")},
+				},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"external-dns", "velero"} {
+		resource, _ := m.Resource(name)
+		if !resource.RetainOnDelete {
+			t.Errorf("adopted namespace %s is deleted on destroy", name)
+		}
+		annotations := resource.Inputs["metadata"].ObjectValue()["annotations"].ObjectValue()
+		if annotations["pulumi.com/patchForce"].StringValue() != "true" || annotations["owner"].StringValue() != "home" {
+			t.Errorf("annotations of %s = %v", name, annotations)
+		}
+	}
+	if resource, _ := m.Resource("apps"); resource.RetainOnDelete {
+		t.Error("a namespace created by the stack is retained")
+	}
+	if got, want := namespaces.Adopted(), []string{"external-dns", "velero"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("adopted = %v, expected %v", got, want)
+	}
+}
@@ -0,0 +1,95 @@
+package adopt
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"cluster-studio/pkg/stacks"
+
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// NamespacesOutput is the stack output listing the adopted namespaces, which
+// stay adopted on the next updates
+const NamespacesOutput = "adoptedNamespaces"
+
+// Namespaces adopts the existing namespaces the stack creates
+type Namespaces struct {
+	mu      sync.Mutex
+	adopted []string
+}
+
+// RegisterNamespaces adopts the namespaces registered afterwards that exist
+// in the cluster without having been applied by Pulumi, or that were adopted
+// by a previous deployment. They are applied over the existing fields and
+// left in place on delete, since the stack didn't create them.
+func RegisterNamespaces(ctx *pulumi.Context, existing map[string]map[string]string, previous []string) (*Namespaces, error) {
+	adopted := map[string]bool{}
+	for _, name := range previous {
+		adopted[name] = true
+	}
+	namespaces := &Namespaces{}
+	err := ctx.RegisterStackTransformation(func(args *pulumi.ResourceTransformationArgs) *pulumi.ResourceTransformationResult {
+		namespaceArgs, ok := args.Props.(*corev1.NamespaceArgs)
+		if _, owned := stacks.Owns(ctx, args.Name); args.Type != "kubernetes:core/v1:Namespace" || !ok || !owned {
+			return nil
+		}
+		metadata, ok := namespaceArgs.Metadata.(*metav1.ObjectMetaArgs)
+		if !ok {
+			return nil
+		}
+		name, ok := metadata.Name.(pulumi.String)
+		if !ok {
+			return nil
+		}
+		if _, found := existing[string(name)]; !found && !adopted[string(name)] {
+			return nil
+		}
+		annotations := pulumi.StringMap{}
+		switch current := metadata.Annotations.(type) {
+		case nil:
+		case pulumi.StringMap:
+			for key, value := range current {
+				annotations[key] = value
+			}
+		default:
+			return nil
+		}
+		annotations["pulumi.com/patchForce"] = pulumi.String("true")
+
+		if !adopted[string(name)] {
+			_ = ctx.Log.Warn(fmt.Sprintf("adopting namespace %s, which already exists: it is left in place on destroy", name), nil)
+		}
+		namespaces.add(string(name))
+		adoptedMetadata := *metadata
+		adoptedMetadata.Annotations = annotations
+		adoptedArgs := *namespaceArgs
+		adoptedArgs.Metadata = &adoptedMetadata
+		return &pulumi.ResourceTransformationResult{
+			Props: &adoptedArgs,
+			Opts:  append(args.Opts, pulumi.RetainOnDelete(true)),
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return namespaces, nil
+}
+
+func (n *Namespaces) add(name string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.adopted = append(n.adopted, name)
+}
+
+// Adopted returns the names of the namespaces adopted so far, sorted
+func (n *Namespaces) Adopted() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	names := append([]string{}, n.adopted...)
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,510 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cluster-studio/internal/previous"
+	"cluster-studio/pkg/cilium"
+	"cluster-studio/pkg/k3d"
+	"cluster-studio/pkg/kind"
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/shell"
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// NodeImageOutput is the stack output recording the node image the cluster was created with
+	NodeImageOutput = "nodeImage"
+	// KindConfigOutput is the stack output recording the Kind config the cluster was created with
+	KindConfigOutput = "kindConfig"
+	// CNIOutput is the stack output describing the CNI replacing kindnet
+	CNIOutput = "cni"
+	// NetworkingOutput is the stack output with the IP family and subnets of
+	// a Kind cluster, defaults included
+	NetworkingOutput = "networking"
+	// NodeLimitsOutput is the stack output with the Docker network of the
+	// Kind nodes and their effective memory (bytes) and CPU limits
+	NodeLimitsOutput = "nodeLimits"
+	// GenerationOutput is the stack output counting how many times the
+	// cluster was recreated after being deleted outside of Pulumi
+	GenerationOutput = "clusterGeneration"
+)
+
+// Config describes the cluster managed by the current stack
+type Config struct {
+	// Name of the cluster (kube context is kind-<Name> or k3d-<Name>)
+	Name string
+	// Tool creating the cluster: "kind" or "k3d"
+	Backend string
+	// Create the cluster with Backend. When false an existing cluster is
+	// targeted through KubeconfigPath and KubeContext and never deleted.
+	Provision bool
+	// Kubeconfig file a created cluster is written to, or of an existing
+	// cluster (optional, defaults to $KUBECONFIG or ~/.kube/config)
+	KubeconfigPath string
+	// Context of an existing cluster (optional, defaults to the current context)
+	KubeContext string
+	// Path to a hand-written Kind config used instead of the generated one (optional)
+	KindConfigPath string
+	// Settings of the generated Kind config. The k3d backend uses its
+	// Workers and PortMappings.
+	Kind kind.Config
+	// Kind config the cluster is created with, rendered from Kind or read from KindConfigPath
+	KindConfig string
+	// Node image (kindest/node or rancher/k3s) overriding the backend default
+	// (optional), set directly with home:nodeImage or derived from
+	// home:kubernetesVersion
+	NodeImage string
+	// Always delete and recreate the cluster instead of reusing an existing one
+	Recreate bool
+	// Allow changes that require recreating the cluster (e.g. the node image)
+	AllowRecreate bool
+	// Protect the cluster (and whatever the caller protects with it) from deletion
+	Protect bool
+	// The stack is protected by default (homelab): only home:confirmDestroy
+	// lifts the protection, home:protect=false is rejected
+	ProtectedByDefault bool
+	// CNI replacing kindnet, nil for the default one of the backend
+	CNI *CNIConfig
+	// Docker network of the Kind nodes, empty for kind's own
+	DockerNetwork string
+	// Memory and CPU limits of every Kind node
+	NodeLimits kind.NodeLimits
+}
+
+// CNIConfig describes Cilium, installed as the CNI of a Kind cluster created
+// with disableDefaultCNI
+type CNIConfig struct {
+	// Chart version of Cilium
+	Version string
+	// Replace kube-proxy, the Kind config must set kubeProxyMode none
+	KubeProxyReplacement bool
+	// Install Hubble Relay and the Hubble UI
+	HubbleUI bool
+}
+
+// Args configures Deploy
+type Args struct {
+	Config *Config
+	// How long creating (or reusing) the cluster may take
+	CreateTimeout time.Duration
+	// How long to wait for all nodes to become Ready
+	NodeReadyTimeout time.Duration
+	// Last deployment of the stack, to detect changes that require recreating the cluster
+	Previous *previous.Deployment
+	// Resources a created cluster waits for, e.g. the members of an umbrella
+	// stack deployed before it
+	DependsOn []pulumi.Resource
+}
+
+// Cluster is the backend-agnostic view of a created cluster
+type Cluster struct {
+	// Component resource of the cluster, nil for an existing cluster
+	Resource pulumi.Resource
+	// Kube context the CLIs use
+	KubeContext string
+	// Kube context and kubeconfig (secret), resolving once all nodes are Ready
+	Context    pulumi.StringOutput
+	Kubeconfig pulumi.StringOutput
+	// Kubeconfig file the CLIs (flux, kubectl, linkerd) use with Context,
+	// empty for the default ($KUBECONFIG or ~/.kube/config)
+	KubeconfigPath string
+	// Docker network of the nodes, empty for an existing cluster
+	DockerNetwork string
+	// Output of the command creating the cluster, nil for an existing cluster
+	Output *shell.Output
+	// Kind cluster for the kind-only features (local registry, mirrors), nil otherwise
+	Kind *kind.Cluster
+	// Chart version of the CNI installed with Config.CNI, resolved once it
+	// runs
+	CNIVersion pulumi.StringOutput
+	// Generation of the cluster, bumped each time it is recreated after an
+	// out-of-band deletion (see GenerationOutput)
+	Generation int
+	// Stack outputs recording how the cluster was created
+	Exports pulumi.Map
+}
+
+// Deploy creates the cluster with the configured backend, or uses the
+// existing one, and waits for its nodes to be Ready. The cluster is recreated
+// when a setting that can't change in place differs from the previous
+// deployment and Config.AllowRecreate is set, or when it was deleted outside
+// of Pulumi.
+func Deploy(ctx *pulumi.Context, args *Args) (*Cluster, error) {
+	cfg := args.Config
+
+	backend, err := newBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	recreate, generation := false, 0
+	if cfg.Provision {
+		generation, recreate, err = checkDeleted(ctx, cfg, backend, args.Previous)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Nothing is lost recreating a deleted cluster
+	if cfg.Provision && !recreate {
+		recreate, err = checkImmutableChanges(ctx, cfg, args.Previous)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cluster, err := backend.Create(ctx, args, recreate, generation)
+	if err != nil {
+		return nil, err
+	}
+	cluster.KubeContext = backend.KubeContext(cfg)
+	cluster.Generation = generation
+	cluster.Exports = pulumi.Map{}
+	if cfg.Provision {
+		cluster.Exports[NodeImageOutput] = pulumi.String(cfg.NodeImage)
+		cluster.Exports[KindConfigOutput] = pulumi.String(cfg.KindConfig)
+	}
+	if generation > 0 {
+		cluster.Exports[GenerationOutput] = pulumi.Int(generation)
+	}
+	if cfg.Provision && cfg.Backend == "kind" {
+		networking, err := kind.ParseNetworking(cfg.KindConfig)
+		if err != nil {
+			return nil, err
+		}
+		cluster.Exports[NetworkingOutput] = pulumi.StringMap{
+			"ipFamily":      pulumi.String(networking.IPFamily),
+			"podSubnet":     pulumi.String(networking.PodSubnet),
+			"serviceSubnet": pulumi.String(networking.ServiceSubnet),
+		}
+		cluster.Exports[NodeLimitsOutput] = pulumi.Map{
+			"network": pulumi.String(cluster.DockerNetwork),
+			"memory":  cluster.Kind.MemoryLimit,
+			"cpus":    cluster.Kind.CPULimit,
+		}
+	}
+	if cfg.CNI != nil {
+		cluster.Exports[CNIOutput] = pulumi.Map{
+			"name":                 pulumi.String("cilium"),
+			"version":              cluster.CNIVersion,
+			"kubeProxyReplacement": pulumi.Bool(cfg.CNI.KubeProxyReplacement),
+			"hubbleUi":             pulumi.Bool(cfg.CNI.HubbleUI),
+		}
+	}
+	return cluster, nil
+}
+
+// checkDeleted looks for the cluster created by the previous deployment. A
+// cluster deleted outside of Pulumi (e.g. with `kind delete cluster`) bumps
+// the generation of the cluster, which replaces the resources creating it;
+// the new kubeconfig then replaces the Kubernetes provider, and the caller
+// runs the in-cluster commands again with Cluster.Generation, so everything
+// deployed to it is recreated as well. It returns the generation and whether
+// the cluster is gone.
+func checkDeleted(ctx *pulumi.Context, clusterCfg *Config, backend backend, previousDeployment *previous.Deployment) (int, bool, error) {
+	generation := 0
+	if _, err := previousDeployment.Output(GenerationOutput, &generation); err != nil {
+		return 0, false, err
+	}
+	var previousImage string
+	created, err := previousDeployment.Output(NodeImageOutput, &previousImage)
+	if err != nil || !created {
+		return generation, false, err
+	}
+
+	exists, err := backend.Exists(clusterCfg)
+	if err != nil {
+		// The preflight checks report a missing or broken CLI
+		_ = ctx.Log.Debug(fmt.Sprintf("can't tell whether cluster %s still exists: %v", clusterCfg.Name, err), nil)
+		return generation, false, nil
+	}
+	if exists {
+		return generation, false, nil
+	}
+	if clusterCfg.Protect {
+		unprotect := "home:protect=false"
+		if clusterCfg.ProtectedByDefault {
+			unprotect = "home:confirmDestroy=" + ctx.Stack()
+			if member := stacks.Prefix(ctx); member != "" {
+				unprotect = member + ":confirmDestroy=" + member
+			}
+		}
+		return 0, false, fmt.Errorf("cluster %s was deleted outside of Pulumi but is protected, set %s to recreate it", clusterCfg.Name, unprotect)
+	}
+	_ = ctx.Log.Warn(fmt.Sprintf("cluster %s was deleted outside of Pulumi, recreating it along with everything deployed to it", clusterCfg.Name), nil)
+	return generation + 1, true, nil
+}
+
+// checkImmutableChanges compares the cluster settings that can only change by
+// recreating the cluster with the previous deployment. It returns whether the
+// cluster has to be recreated, or an error when that is needed but
+// home:allowRecreate is not set.
+func checkImmutableChanges(ctx *pulumi.Context, clusterCfg *Config, previousDeployment *previous.Deployment) (bool, error) {
+	var previousImage, previousConfig string
+	imageFound, err := previousDeployment.Output(NodeImageOutput, &previousImage)
+	if err != nil {
+		return false, err
+	}
+	configFound, err := previousDeployment.Output(KindConfigOutput, &previousConfig)
+	if err != nil {
+		return false, err
+	}
+
+	var previousLimits struct {
+		Network string  `json:"network"`
+		Memory  float64 `json:"memory"`
+		CPUs    float64 `json:"cpus"`
+	}
+	if _, err := previousDeployment.Output(NodeLimitsOutput, &previousLimits); err != nil {
+		return false, err
+	}
+	network := clusterCfg.DockerNetwork
+	if network == "" {
+		network = kind.DefaultNetwork
+	}
+
+	var change string
+	switch {
+	case imageFound && previousImage != clusterCfg.NodeImage:
+		change = fmt.Sprintf("the node image changed from %q to %q", previousImage, clusterCfg.NodeImage)
+	case configFound && networkingChanged(previousConfig, clusterCfg.KindConfig):
+		previousNetworking, _ := kind.ParseNetworking(previousConfig)
+		networking, _ := kind.ParseNetworking(clusterCfg.KindConfig)
+		change = fmt.Sprintf("the Kind networking changed from %s to %s", previousNetworking, networking)
+	case configFound && previousConfig != clusterCfg.KindConfig:
+		change = "the Kind config changed (see the kindConfig output for the one in use)"
+	case clusterCfg.Backend == "kind" && previousLimits.Network != "" && previousLimits.Network != network:
+		change = fmt.Sprintf("the Docker network of the nodes changed from %s to %s", previousLimits.Network, network)
+	case clusterCfg.Backend == "kind" && (previousLimits.Memory > 0 && clusterCfg.NodeLimits.Memory == 0 || previousLimits.CPUs > 0 && clusterCfg.NodeLimits.CPUs == 0):
+		change = "the memory or CPU limit of the nodes was removed, which Docker can't lift from running containers"
+	default:
+		return false, nil
+	}
+
+	if !clusterCfg.AllowRecreate {
+		return false, fmt.Errorf("%s, which requires recreating cluster %s (all data is lost), set home:allowRecreate=true to proceed",
+			change, clusterCfg.Name)
+	}
+	_ = ctx.Log.Warn(fmt.Sprintf("%s, recreating cluster %s", change, clusterCfg.Name), nil)
+	return true, nil
+}
+
+// networkingChanged reports whether the IP family or the subnets of two Kind
+// configs differ, which reads better than any change of the config
+func networkingChanged(previousConfig, kindConfig string) bool {
+	previousNetworking, err := kind.ParseNetworking(previousConfig)
+	if err != nil {
+		return false
+	}
+	networking, err := kind.ParseNetworking(kindConfig)
+	if err != nil {
+		return false
+	}
+	return previousNetworking != networking
+}
+
+// backend creates the cluster of a stack with a specific tool, so the rest of
+// the program doesn't depend on which one is used
+type backend interface {
+	// KubeContext returns the kube context of the cluster
+	KubeContext(clusterCfg *Config) string
+	// Exists reports whether the cluster is listed by the tool
+	Exists(clusterCfg *Config) (bool, error)
+	// Create creates (or reuses) the cluster and waits for its nodes to be
+	// Ready, generation as returned by checkDeleted
+	Create(ctx *pulumi.Context, args *Args, recreate bool, generation int) (*Cluster, error)
+}
+
+// newBackend returns the backend selected with home:clusterBackend, or the
+// existing cluster when home:provisionCluster is false
+func newBackend(clusterCfg *Config) (backend, error) {
+	if !clusterCfg.Provision {
+		return existingBackend{}, nil
+	}
+	switch name := clusterCfg.Backend; name {
+	case "kind":
+		return kindBackend{}, nil
+	case "k3d":
+		return k3dBackend{}, nil
+	default:
+		return nil, fmt.Errorf("invalid home:clusterBackend %q, use \"kind\" or \"k3d\"", name)
+	}
+}
+
+type kindBackend struct{}
+
+func (kindBackend) KubeContext(clusterCfg *Config) string {
+	return kind.KubeContext(clusterCfg.Name)
+}
+
+func (kindBackend) Exists(clusterCfg *Config) (bool, error) {
+	return kind.Exists(clusterCfg.Name)
+}
+
+func (kindBackend) Create(ctx *pulumi.Context, args *Args, recreate bool, generation int) (*Cluster, error) {
+	clusterCfg := args.Config
+	cluster, err := kind.NewCluster(ctx, stacks.Name(ctx, clusterCfg.Name), &kind.ClusterArgs{
+		Name:           clusterCfg.Name,
+		ConfigFile:     clusterCfg.KindConfigPath,
+		Config:         clusterCfg.KindConfig,
+		NodeImage:      clusterCfg.NodeImage,
+		KubeconfigFile: clusterCfg.KubeconfigPath,
+		CreateTimeout:  args.CreateTimeout,
+		Timeout:        args.NodeReadyTimeout,
+		SkipNodeWait:   clusterCfg.CNI != nil,
+		Recreate:       clusterCfg.Recreate || recreate,
+		Generation:     generation,
+		Network:        clusterCfg.DockerNetwork,
+		Limits:         clusterCfg.NodeLimits,
+	}, pulumi.Protect(clusterCfg.Protect), pulumi.DependsOn(args.DependsOn))
+	if err != nil {
+		return nil, err
+	}
+	created := &Cluster{
+		Resource:       cluster,
+		Context:        cluster.Context,
+		Kubeconfig:     cluster.Kubeconfig,
+		KubeconfigPath: clusterCfg.KubeconfigPath,
+		DockerNetwork:  cluster.Network,
+		Kind:           cluster,
+		Output:         cluster.Output,
+	}
+	if clusterCfg.CNI != nil {
+		if err := installCNI(ctx, args, created); err != nil {
+			return nil, err
+		}
+	}
+	return created, nil
+}
+
+// installCNI installs Cilium on the Kind cluster created without a CNI, then
+// waits for the nodes, which only become Ready once it runs
+func installCNI(ctx *pulumi.Context, args *Args, cluster *Cluster) error {
+	clusterCfg := args.Config
+	provider, err := kubernetes.NewProvider(ctx, stacks.Name(ctx, fmt.Sprintf("%s-cni-provider", clusterCfg.Name)), &kubernetes.ProviderArgs{
+		Kubeconfig:        cluster.Kubeconfig,
+		DeleteUnreachable: pulumi.Bool(true),
+	}, pulumi.Parent(cluster.Kind))
+	if err != nil {
+		return err
+	}
+	install, err := cilium.NewInstall(ctx, stacks.Name(ctx, fmt.Sprintf("%s-cilium", clusterCfg.Name)), &cilium.InstallArgs{
+		Version:              clusterCfg.CNI.Version,
+		KubeProxyReplacement: clusterCfg.CNI.KubeProxyReplacement,
+		// The control-plane node on the kind network
+		APIServerHost: fmt.Sprintf("%s-control-plane", clusterCfg.Name),
+		APIServerPort: 6443,
+		HubbleUI:      clusterCfg.CNI.HubbleUI,
+		Timeout:       args.NodeReadyTimeout,
+	}, pulumi.Parent(cluster.Kind), pulumi.Providers(provider))
+	if err != nil {
+		return err
+	}
+
+	kubeconfig := pulumi.All(install.Version, cluster.Kubeconfig).ApplyT(func(all []interface{}) string {
+		return all[1].(string)
+	}).(pulumi.StringOutput)
+	cluster.Kubeconfig = pulumi.ToSecret(waitForNodes(ctx, kubeconfig, clusterCfg.Name, args.NodeReadyTimeout)).(pulumi.StringOutput)
+	cluster.CNIVersion = install.Version
+	return nil
+}
+
+// waitForNodes returns kubeconfig once all nodes of the cluster are Ready
+func waitForNodes(ctx *pulumi.Context, kubeconfig pulumi.StringOutput, name string, timeout time.Duration) pulumi.StringOutput {
+	return kubeconfig.ApplyT(func(config string) (string, error) {
+		if ctx.DryRun() {
+			return config, nil
+		}
+		client, err := kube.NewClientsetFromKubeconfig(config)
+		if err != nil {
+			return "", err
+		}
+		err = kube.WaitForNodesReady(context.Background(), client, kube.PollOptions{
+			Description: fmt.Sprintf("nodes of %s to become Ready", name),
+			Timeout:     timeout,
+			Logf: func(format string, a ...interface{}) {
+				_ = ctx.Log.Info(fmt.Sprintf(format, a...), nil)
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+		return config, nil
+	}).(pulumi.StringOutput)
+}
+
+type k3dBackend struct{}
+
+func (k3dBackend) KubeContext(clusterCfg *Config) string {
+	return k3d.KubeContext(clusterCfg.Name)
+}
+
+func (k3dBackend) Exists(clusterCfg *Config) (bool, error) {
+	return k3d.Exists(clusterCfg.Name)
+}
+
+func (k3dBackend) Create(ctx *pulumi.Context, args *Args, recreate bool, generation int) (*Cluster, error) {
+	clusterCfg := args.Config
+	// Host ports are published through the k3d load balancer
+	ports := make([]string, 0, len(clusterCfg.Kind.PortMappings))
+	for _, mapping := range clusterCfg.Kind.PortMappings {
+		ports = append(ports, fmt.Sprintf("%d:%d@loadbalancer", mapping.HostPort, mapping.ContainerPort))
+	}
+
+	cluster, err := k3d.NewCluster(ctx, stacks.Name(ctx, clusterCfg.Name), &k3d.ClusterArgs{
+		Name:           clusterCfg.Name,
+		Agents:         clusterCfg.Kind.Workers,
+		NodeImage:      clusterCfg.NodeImage,
+		Ports:          ports,
+		KubeconfigFile: clusterCfg.KubeconfigPath,
+		CreateTimeout:  args.CreateTimeout,
+		Timeout:        args.NodeReadyTimeout,
+		Recreate:       clusterCfg.Recreate || recreate,
+		Generation:     generation,
+	}, pulumi.Protect(clusterCfg.Protect), pulumi.DependsOn(args.DependsOn))
+	if err != nil {
+		return nil, err
+	}
+	return &Cluster{
+		Resource:       cluster,
+		Context:        cluster.Context,
+		Kubeconfig:     cluster.Kubeconfig,
+		KubeconfigPath: clusterCfg.KubeconfigPath,
+		DockerNetwork:  k3d.DockerNetwork(clusterCfg.Name),
+		Output:         cluster.Output,
+	}, nil
+}
+
+// existingBackend targets a cluster created outside of this program. Nothing
+// is created, so nothing is deleted on destroy.
+type existingBackend struct{}
+
+func (existingBackend) KubeContext(clusterCfg *Config) string {
+	return clusterCfg.KubeContext
+}
+
+func (existingBackend) Exists(clusterCfg *Config) (bool, error) {
+	return true, nil
+}
+
+func (existingBackend) Create(ctx *pulumi.Context, args *Args, recreate bool, generation int) (*Cluster, error) {
+	clusterCfg := args.Config
+	kubeconfig, kubeContext, err := kube.LoadKubeconfig(clusterCfg.KubeconfigPath, clusterCfg.KubeContext)
+	if err != nil {
+		return nil, err
+	}
+
+	// Wait for the nodes like the created clusters do
+	readyKubeconfig := waitForNodes(ctx, pulumi.String(kubeconfig).ToStringOutput(), kubeContext, args.NodeReadyTimeout)
+
+	return &Cluster{
+		Context:        pulumi.String(kubeContext).ToStringOutput(),
+		Kubeconfig:     pulumi.ToSecret(readyKubeconfig).(pulumi.StringOutput),
+		KubeconfigPath: clusterCfg.KubeconfigPath,
+	}, nil
+}
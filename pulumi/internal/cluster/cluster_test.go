@@ -0,0 +1,293 @@
+package cluster
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cluster-studio/internal/previous"
+	"cluster-studio/internal/pulumitest"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// runDeploy deploys a cluster with mocks, on top of the given previous deployment outputs
+func runDeploy(t *testing.T, cfg *Config, previousOutputs map[string]interface{}) (*pulumitest.Mocks, *Cluster, error) {
+	t.Helper()
+	m := &pulumitest.Mocks{Previous: previousOutputs}
+	var cluster *Cluster
+	err := pulumitest.Run(t, "test", nil, m, func(ctx *pulumi.Context) error {
+		previousDeployment, err := previous.New(ctx)
+		if err != nil {
+			return err
+		}
+		cluster, err = Deploy(ctx, &Args{
+			Config:           cfg,
+			CreateTimeout:    time.Minute,
+			NodeReadyTimeout: time.Minute,
+			Previous:         previousDeployment,
+		})
+		return err
+	})
+	return m, cluster, err
+}
+
+func TestDeployKind(t *testing.T) {
+	m, cluster, err := runDeploy(t, &Config{
+		Name:           "test",
+		Backend:        "kind",
+		Provision:      true,
+		NodeImage:      "kindest/node:v1.33.1",
+		KindConfig:     "kind: Cluster",
+		KubeconfigPath: "/tmp/test.yaml",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cluster.KubeContext != "kind-test" {
+		t.Errorf("KubeContext = %q, want kind-test", cluster.KubeContext)
+	}
+	if cluster.Kind == nil || cluster.DockerNetwork != "kind" {
+		t.Errorf("expected a Kind cluster on the kind network, got network %q", cluster.DockerNetwork)
+	}
+	res, _ := m.Resource("kind-cluster-test")
+	if res == nil || res.Type != "homekind:index:Cluster" {
+		t.Fatalf("the Kind cluster isn't managed by its provider: %v", res)
+	}
+	for key, want := range map[string]string{
+		"name":           "test",
+		"nodeImage":      "kindest/node:v1.33.1",
+		"config":         "kind: Cluster",
+		"kubeconfigFile": "/tmp/test.yaml",
+	} {
+		if got := m.Input(t, "kind-cluster-test", key); got != want {
+			t.Errorf("input %s = %q, want %q", key, got, want)
+		}
+	}
+	if res.Inputs["recreate"].BoolValue() {
+		t.Error("an existing cluster isn't reused")
+	}
+	if cluster.KubeconfigPath != "/tmp/test.yaml" {
+		t.Errorf("KubeconfigPath = %q, want /tmp/test.yaml", cluster.KubeconfigPath)
+	}
+	// A generation would replace the cluster of existing stacks
+	if res.Inputs.HasValue("generation") {
+		t.Errorf("the cluster has generation %v", res.Inputs["generation"])
+	}
+	for _, output := range []string{NodeImageOutput, KindConfigOutput} {
+		if _, ok := cluster.Exports[output]; !ok {
+			t.Errorf("output %s is not exported", output)
+		}
+	}
+}
+
+func TestDeployK3d(t *testing.T) {
+	m, cluster, err := runDeploy(t, &Config{
+		Name:           "test",
+		Backend:        "k3d",
+		Provision:      true,
+		KubeconfigPath: "/tmp/test.yaml",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cluster.KubeContext != "k3d-test" || cluster.DockerNetwork != "k3d-test" {
+		t.Errorf("got context %q and network %q, want k3d-test", cluster.KubeContext, cluster.DockerNetwork)
+	}
+	if cluster.Kind != nil {
+		t.Error("a k3d cluster has no Kind cluster")
+	}
+	if !m.Has("create-k3d-cluster-test") || m.Has("kind-cluster-test") {
+		t.Error("expected only the k3d cluster to be created")
+	}
+	if create := m.Input(t, "create-k3d-cluster-test", "create"); !strings.Contains(create, "--kubeconfig-update-default=false") ||
+		!strings.Contains(create, "k3d kubeconfig merge test --output") || !strings.Contains(create, "/tmp/test.yaml") {
+		t.Errorf("create command doesn't write the kubeconfig file only:\n%s", create)
+	}
+}
+
+func TestDeployImmutableChange(t *testing.T) {
+	cfg := &Config{
+		Name:      "test",
+		Backend:   "kind",
+		Provision: true,
+		NodeImage: "kindest/node:v1.33.1",
+	}
+	previousOutputs := map[string]interface{}{NodeImageOutput: "kindest/node:v1.32.0"}
+
+	_, _, err := runDeploy(t, cfg, previousOutputs)
+	if err == nil || !strings.Contains(err.Error(), "home:allowRecreate=true") {
+		t.Fatalf("expected an error asking for home:allowRecreate, got %v", err)
+	}
+
+	cfg.AllowRecreate = true
+	m, _, err := runDeploy(t, cfg, previousOutputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, _ := m.Resource("kind-cluster-test"); !res.Inputs["recreate"].BoolValue() {
+		t.Error("expected the cluster to be recreated")
+	}
+}
+
+func TestDeployNetworkingChange(t *testing.T) {
+	cfg := &Config{
+		Name:       "test",
+		Backend:    "kind",
+		Provision:  true,
+		KindConfig: "kind: Cluster\nnetworking:\n  podSubnet: 10.250.0.0/16\n",
+	}
+	previousOutputs := map[string]interface{}{KindConfigOutput: "kind: Cluster\n"}
+
+	_, _, err := runDeploy(t, cfg, previousOutputs)
+	if err == nil || !strings.Contains(err.Error(), "the Kind networking changed from ipv4 with pods on 10.244.0.0/16") {
+		t.Fatalf("expected an error naming the networking change, got %v", err)
+	}
+
+	cfg.AllowRecreate = true
+	_, cluster, err := runDeploy(t, cfg, previousOutputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cluster.Exports[NetworkingOutput]; !ok {
+		t.Errorf("output %s is not exported", NetworkingOutput)
+	}
+}
+
+func TestDeployDockerNetworkChange(t *testing.T) {
+	cfg := &Config{
+		Name:          "test",
+		Backend:       "kind",
+		Provision:     true,
+		DockerNetwork: "studio",
+	}
+	previousOutputs := map[string]interface{}{NodeLimitsOutput: map[string]interface{}{"network": "kind", "memory": 0, "cpus": 0}}
+
+	_, _, err := runDeploy(t, cfg, previousOutputs)
+	if err == nil || !strings.Contains(err.Error(), "the Docker network of the nodes changed from kind to studio") {
+		t.Fatalf("expected an error naming the network change, got %v", err)
+	}
+
+	// Lifting a limit recreates the cluster too
+	cfg.DockerNetwork = ""
+	previousOutputs[NodeLimitsOutput] = map[string]interface{}{"network": "kind", "memory": 4 << 30, "cpus": 0}
+	_, _, err = runDeploy(t, cfg, previousOutputs)
+	if err == nil || !strings.Contains(err.Error(), "limit of the nodes was removed") {
+		t.Fatalf("expected an error about the removed limit, got %v", err)
+	}
+
+	cfg.NodeLimits.Memory = 4 << 30
+	m, cluster, err := runDeploy(t, cfg, previousOutputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, _ := m.Resource("kind-cluster-test"); res.Inputs["recreate"].BoolValue() {
+		t.Error("the cluster is recreated for an unchanged network")
+	}
+	if cluster.DockerNetwork != "kind" {
+		t.Errorf("DockerNetwork = %q, want kind", cluster.DockerNetwork)
+	}
+}
+
+func TestDeployDeletedOutOfBand(t *testing.T) {
+	// kind lists another cluster only
+	bin := t.TempDir()
+	err := os.WriteFile(filepath.Join(bin, "kind"), []byte("#!/bin/sh\necho other\n"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	cfg := &Config{
+		Name:      "test",
+		Backend:   "kind",
+		Provision: true,
+		NodeImage: "kindest/node:v1.32.0",
+		Protect:   true,
+	}
+	// The node image changed too, which needs no home:allowRecreate anymore
+	previousOutputs := map[string]interface{}{NodeImageOutput: "kindest/node:v1.31.0", GenerationOutput: 1}
+
+	_, _, err = runDeploy(t, cfg, previousOutputs)
+	if err == nil || !strings.Contains(err.Error(), "home:protect=false") {
+		t.Fatalf("expected an error asking to unprotect the cluster, got %v", err)
+	}
+	// home:protect=false is rejected on a stack protected by default
+	cfg.ProtectedByDefault = true
+	_, _, err = runDeploy(t, cfg, previousOutputs)
+	if err == nil || !strings.Contains(err.Error(), "home:confirmDestroy=test") {
+		t.Fatalf("expected an error asking to confirm the destroy, got %v", err)
+	}
+
+	cfg.Protect, cfg.ProtectedByDefault = false, false
+	m, cluster, err := runDeploy(t, cfg, previousOutputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, _ := m.Resource("kind-cluster-test")
+	if !res.Inputs["recreate"].BoolValue() || res.Inputs["generation"].NumberValue() != 2 {
+		t.Errorf("expected the cluster to be recreated with generation 2, got %v", res.Inputs)
+	}
+	if _, ok := cluster.Exports[GenerationOutput]; !ok {
+		t.Errorf("output %s is not exported", GenerationOutput)
+	}
+
+	// A cluster that is still there keeps its generation
+	cfg.NodeImage = "kindest/node:v1.31.0"
+	err = os.WriteFile(filepath.Join(bin, "kind"), []byte("#!/bin/sh\necho test\n"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _, err = runDeploy(t, cfg, previousOutputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, _ = m.Resource("kind-cluster-test")
+	if res.Inputs["recreate"].BoolValue() || res.Inputs["generation"].NumberValue() != 1 {
+		t.Errorf("expected the existing cluster to be reused with generation 1, got %v", res.Inputs)
+	}
+}
+
+func TestDeployExisting(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	err := os.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: remote
+  cluster:
+    server: https://127.0.0.1:6443
+users:
+- name: admin
+  user:
+    token: secret
+contexts:
+- name: remote
+  context:
+    cluster: remote
+    user: admin
+current-context: remote
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, cluster, err := runDeploy(t, &Config{
+		Name:           "test",
+		KubeconfigPath: kubeconfig,
+		KubeContext:    "remote",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cluster.KubeContext != "remote" || cluster.KubeconfigPath != kubeconfig {
+		t.Errorf("got context %q in %q, want remote in %q", cluster.KubeContext, cluster.KubeconfigPath, kubeconfig)
+	}
+	if cluster.Resource != nil || m.Has("test") || len(cluster.Exports) != 0 {
+		t.Error("nothing should be created for an existing cluster")
+	}
+}
@@ -0,0 +1,239 @@
+package gitops
+
+import (
+	"fmt"
+	"time"
+
+	"cluster-studio/internal/previous"
+	fluxpkg "cluster-studio/pkg/flux"
+	"cluster-studio/pkg/shell"
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// DeployKeyOutput is the stack output holding the generated SSH deploy key
+	DeployKeyOutput = "fluxDeployKey"
+	// AgeKeyOutput is the stack output holding the generated SOPS age key
+	AgeKeyOutput = "sopsAgeKey"
+)
+
+// FluxConfig describes the Flux installation
+type FluxConfig struct {
+	// How Flux is installed: "install" (controllers, sync managed by Pulumi) or
+	// "bootstrap" (flux bootstrap github, Flux manages its own manifests)
+	Mode string
+	// Flux version to install
+	Version string
+	// Controllers to install
+	Components []string
+	// How long to wait for Kustomizations and HelmReleases to become Ready
+	ReconcileTimeout time.Duration
+}
+
+// GitConfig describes the GitRepository and root Kustomization Flux syncs from
+type GitConfig struct {
+	// Create the GitRepository/Kustomization (false keeps Flux controllers only)
+	Sync bool
+	// Repository URL, branch and cluster path inside the repository
+	URL    string
+	Branch string
+	Path   string
+	// Reference (e.g. refs/pull/12/head) or commit synced instead of Branch,
+	// empty to sync the branch
+	Ref string
+	// Reconcile interval
+	Interval string
+	// Authentication: "none", "https" (token) or "ssh" (generated deploy key)
+	Auth string
+	// Username and token (secret) for HTTPS authentication and flux
+	// bootstrap, nil when unset
+	Username string
+	Token    pulumi.StringInput
+	// GitHub owner and repository for flux bootstrap
+	Owner      string
+	Repository string
+	// Whether the GitHub owner is a user rather than an organization
+	Personal bool
+	// known_hosts for SSH authentication
+	KnownHosts string
+	// Decrypt SOPS files of the repository with an age key
+	SOPS bool
+	// age private key (secret), generated on the first deployment when nil
+	AgeKey pulumi.StringInput
+}
+
+// Args configures Deploy
+type Args struct {
+	Flux *FluxConfig
+	Git  *GitConfig
+	// Name of the cluster, used in the comment of the generated deploy key
+	ClusterName string
+	// Kube context to install into
+	KubeContext string
+	// Kubeconfig file containing KubeContext (optional, defaults to $KUBECONFIG or ~/.kube/config)
+	KubeconfigPath string
+	// How long flux install or flux bootstrap may take, zero for no limit
+	Timeout time.Duration
+	// Retries of a failed flux install or flux bootstrap
+	Retry shell.RetryPolicy
+	// Provider of the cluster, for the GitRepository and Kustomization
+	Provider *kubernetes.Provider
+	// Last deployment of the stack, to reuse the deploy key
+	Previous *previous.Deployment
+	// Resources Flux is installed after
+	DependsOn []pulumi.Resource
+}
+
+// Flux is the result of Deploy
+type Flux struct {
+	// Last resource of the installation, what the next step depends on
+	Resource pulumi.Resource
+	// Installed Flux version, resolved once the installation has completed
+	Version pulumi.StringOutput
+	// Output of the install or bootstrap command
+	Output *shell.Output
+	// Stack outputs of the installation (the deploy key and the age key)
+	Exports pulumi.Map
+}
+
+// Deploy installs pinned Flux controllers and points them at the Git
+// repository, or lets flux bootstrap manage Flux from Git
+func Deploy(ctx *pulumi.Context, args *Args) (*Flux, error) {
+	flux := &Flux{Exports: pulumi.Map{}}
+
+	if args.Flux.Mode == "bootstrap" {
+		bootstrap, err := fluxpkg.NewBootstrap(ctx, stacks.Name(ctx, "flux-bootstrap"), &fluxpkg.BootstrapArgs{
+			Context:    args.KubeContext,
+			Kubeconfig: args.KubeconfigPath,
+			Version:    args.Flux.Version,
+			Components: args.Flux.Components,
+			Owner:      args.Git.Owner,
+			Repository: args.Git.Repository,
+			Branch:     args.Git.Branch,
+			Path:       args.Git.Path,
+			Personal:   args.Git.Personal,
+			Token:      args.Git.Token,
+			Timeout:    args.Timeout,
+			Retry:      args.Retry,
+		}, pulumi.DependsOn(args.DependsOn))
+		if err != nil {
+			return nil, err
+		}
+		flux.Resource, flux.Version, flux.Output = bootstrap, bootstrap.Version, bootstrap.Output
+		return flux, nil
+	}
+
+	install, err := fluxpkg.NewInstall(ctx, stacks.Name(ctx, "flux"), &fluxpkg.InstallArgs{
+		Context:    args.KubeContext,
+		Kubeconfig: args.KubeconfigPath,
+		Version:    args.Flux.Version,
+		Components: args.Flux.Components,
+		Timeout:    args.Timeout,
+		Retry:      args.Retry,
+	}, pulumi.DependsOn(args.DependsOn))
+	if err != nil {
+		return nil, err
+	}
+	flux.Resource, flux.Version, flux.Output = install, install.Version, install.Output
+
+	// Point Flux at this repository unless only the controllers are wanted
+	if args.Git.Sync {
+		sync, err := deploySync(ctx, args, flux.Exports, []pulumi.Resource{install})
+		if err != nil {
+			return nil, err
+		}
+		flux.Resource = sync
+	}
+
+	return flux, nil
+}
+
+// deploySync points Flux at the Git repository with the configured authentication
+func deploySync(ctx *pulumi.Context, args *Args, exports pulumi.Map, deps []pulumi.Resource) (*fluxpkg.Sync, error) {
+	gitCfg := args.Git
+	var credentials pulumi.StringMapInput
+	switch gitCfg.Auth {
+	case "https":
+		credentials = pulumi.StringMap{
+			"username": pulumi.String(gitCfg.Username),
+			"password": gitCfg.Token,
+		}
+	case "ssh":
+		key, err := loadDeployKey(args.ClusterName, args.Previous)
+		if err != nil {
+			return nil, err
+		}
+		exports[DeployKeyOutput] = pulumi.ToSecret(pulumi.StringMap{
+			"privateKeyPEM": pulumi.String(key.PrivateKeyPEM),
+			"publicKey":     pulumi.String(key.PublicKey),
+		})
+		// Add this as a deploy key of the repository
+		exports["fluxDeployPublicKey"] = pulumi.String(key.PublicKey)
+
+		credentials = pulumi.ToSecret(pulumi.StringMap{
+			"identity":     pulumi.String(key.PrivateKeyPEM),
+			"identity.pub": pulumi.String(key.PublicKey),
+			"known_hosts":  pulumi.String(gitCfg.KnownHosts),
+		}).(pulumi.StringMapOutput)
+	}
+
+	var ageKey pulumi.StringInput
+	if gitCfg.SOPS {
+		ageKey = gitCfg.AgeKey
+		if ageKey == nil {
+			key, err := loadAgeKey(args.Previous)
+			if err != nil {
+				return nil, err
+			}
+			exports[AgeKeyOutput] = pulumi.ToSecret(pulumi.StringMap{
+				"identity":  pulumi.String(key.Identity),
+				"recipient": pulumi.String(key.Recipient),
+			})
+			ageKey = pulumi.ToSecret(pulumi.String(key.Identity)).(pulumi.StringOutput)
+		}
+		// Encrypt with sops --age <recipient>
+		exports["sopsAgeRecipient"] = pulumi.Unsecret(ageKey.ToStringOutput().ApplyT(fluxpkg.AgeRecipient)).(pulumi.StringOutput)
+	}
+
+	return fluxpkg.NewSync(ctx, stacks.Name(ctx, "flux-sync"), &fluxpkg.SyncArgs{
+		URL:         gitCfg.URL,
+		Branch:      gitCfg.Branch,
+		Ref:         gitCfg.Ref,
+		Path:        gitCfg.Path,
+		Interval:    gitCfg.Interval,
+		Credentials: credentials,
+		AgeKey:      ageKey,
+	}, pulumi.Providers(args.Provider), pulumi.DependsOn(deps))
+}
+
+// loadAgeKey reuses the age key of the previous deployment so the files
+// encrypted for it can still be decrypted, generating one on the first
+// deployment
+func loadAgeKey(previousDeployment *previous.Deployment) (*fluxpkg.AgeKey, error) {
+	key := &fluxpkg.AgeKey{}
+	found, err := previousDeployment.Output(AgeKeyOutput, key)
+	if err != nil {
+		return nil, err
+	}
+	if found && key.Identity != "" {
+		return key, nil
+	}
+	return fluxpkg.GenerateAgeKey()
+}
+
+// loadDeployKey reuses the deploy key of the previous deployment so the key
+// added to GitHub keeps working, generating one on the first deployment
+func loadDeployKey(clusterName string, previousDeployment *previous.Deployment) (*fluxpkg.DeployKey, error) {
+	key := &fluxpkg.DeployKey{}
+	found, err := previousDeployment.Output(DeployKeyOutput, key)
+	if err != nil {
+		return nil, err
+	}
+	if found && key.PrivateKeyPEM != "" {
+		return key, nil
+	}
+	return fluxpkg.GenerateDeployKey(fmt.Sprintf("flux-%s", clusterName))
+}
@@ -0,0 +1,196 @@
+package gitops
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"cluster-studio/internal/previous"
+	"cluster-studio/internal/pulumitest"
+	"cluster-studio/pkg/shell"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// runDeploy deploys Flux with mocks after the provider of a "cluster"
+// command, on top of the given previous deployment outputs
+func runDeploy(t *testing.T, fluxCfg *FluxConfig, gitCfg *GitConfig, previousOutputs map[string]interface{}) (*pulumitest.Mocks, *Flux, error) {
+	t.Helper()
+	m := &pulumitest.Mocks{Previous: previousOutputs}
+	var flux *Flux
+	err := pulumitest.Run(t, "test", nil, m, func(ctx *pulumi.Context) error {
+		previousDeployment, err := previous.New(ctx)
+		if err != nil {
+			return err
+		}
+		cluster, err := local.NewCommand(ctx, "cluster", &local.CommandArgs{Create: pulumi.String("true")})
+		if err != nil {
+			return err
+		}
+		provider, err := kubernetes.NewProvider(ctx, "provider", &kubernetes.ProviderArgs{
+			Kubeconfig: cluster.Stdout,
+		})
+		if err != nil {
+			return err
+		}
+		flux, err = Deploy(ctx, &Args{
+			Flux:        fluxCfg,
+			Git:         gitCfg,
+			ClusterName: "test",
+			KubeContext: "kind-test",
+			Timeout:     time.Minute,
+			Retry:       shell.RetryPolicy{Attempts: 1},
+			Provider:    provider,
+			Previous:    previousDeployment,
+			DependsOn:   []pulumi.Resource{provider},
+		})
+		return err
+	})
+	return m, flux, err
+}
+
+func TestDeployInstall(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		git     *GitConfig
+		present []string
+		absent  []string
+	}{
+		{
+			name:    "sync",
+			git:     &GitConfig{Sync: true, URL: "https://github.com/brunovlucena/home", Auth: "none"},
+			present: []string{"flux", "flux-sync", "flux-git-repository", "flux-root-kustomization"},
+			absent:  []string{"flux-bootstrap", "flux-git-credentials"},
+		},
+		{
+			name:    "controllers only",
+			git:     &GitConfig{Sync: false},
+			present: []string{"flux"},
+			absent:  []string{"flux-sync", "flux-bootstrap"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, _, err := runDeploy(t, &FluxConfig{Mode: "install", Version: "v2.6.4"}, tc.git, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range tc.present {
+				if !m.Has(name) {
+					t.Errorf("%s was not registered", name)
+				}
+			}
+			for _, name := range tc.absent {
+				if m.Has(name) {
+					t.Errorf("%s was registered", name)
+				}
+			}
+			if !m.DependsOn("flux", "cluster") {
+				t.Error("flux doesn't wait for the cluster")
+			}
+			if tc.git.Sync && !m.DependsOn("flux-git-repository", "flux") {
+				t.Error("the GitRepository doesn't wait for the controllers")
+			}
+		})
+	}
+}
+
+func TestDeployBootstrap(t *testing.T) {
+	m, flux, err := runDeploy(t, &FluxConfig{Mode: "bootstrap", Version: "v2.6.4"},
+		&GitConfig{Sync: true, Owner: "brunovlucena", Repository: "home", Branch: "main", Path: "flux/clusters/test"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !m.Has("flux-bootstrap") || m.Has("flux") || m.Has("flux-sync") {
+		t.Error("expected flux bootstrap to replace the install and the sync")
+	}
+	if !m.DependsOn("flux-bootstrap", "cluster") {
+		t.Error("flux bootstrap doesn't wait for the cluster")
+	}
+	if len(flux.Exports) != 0 {
+		t.Errorf("unexpected outputs %v", flux.Exports)
+	}
+}
+
+func TestDeploySSHDeployKey(t *testing.T) {
+	gitCfg := &GitConfig{Sync: true, URL: "ssh://git@github.com/brunovlucena/home", Auth: "ssh"}
+
+	m, flux, err := runDeploy(t, &FluxConfig{Mode: "install", Version: "v2.6.4"}, gitCfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Has("flux-git-credentials") {
+		t.Error("the SSH credentials were not created")
+	}
+	for _, output := range []string{DeployKeyOutput, "fluxDeployPublicKey"} {
+		if _, ok := flux.Exports[output]; !ok {
+			t.Errorf("output %s is not exported", output)
+		}
+	}
+
+	// The key of the previous deployment is reused
+	m, _, err = runDeploy(t, &FluxConfig{Mode: "install", Version: "v2.6.4"}, gitCfg, map[string]interface{}{
+		DeployKeyOutput: map[string]interface{}{
+			"privateKeyPEM": "previous-private-key",
+			"publicKey":     "previous-public-key",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := m.Resource("flux-git-credentials")
+	stringData := secret.Inputs["stringData"]
+	if stringData.IsSecret() {
+		stringData = stringData.SecretValue().Element
+	}
+	if got := stringData.ObjectValue()["identity"].StringValue(); got != "previous-private-key" {
+		t.Errorf("identity = %q, want the previous deploy key", got)
+	}
+}
+
+func TestDeploySOPS(t *testing.T) {
+	gitCfg := &GitConfig{Sync: true, URL: "https://github.com/brunovlucena/home", Auth: "none", SOPS: true}
+
+	m, flux, err := runDeploy(t, &FluxConfig{Mode: "install", Version: "v2.6.4"}, gitCfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.DependsOn("flux-root-kustomization", "flux-sops-age") {
+		t.Error("the root Kustomization doesn't wait for the age key")
+	}
+	kustomization, _ := m.Resource("flux-root-kustomization")
+	decryption := kustomization.Inputs["spec"].ObjectValue()["decryption"].ObjectValue()
+	if decryption["provider"].StringValue() != "sops" || decryption["secretRef"].ObjectValue()["name"].StringValue() != "sops-age" {
+		t.Errorf("got decryption %v, want sops with the sops-age Secret", decryption)
+	}
+	for _, output := range []string{AgeKeyOutput, "sopsAgeRecipient"} {
+		if _, ok := flux.Exports[output]; !ok {
+			t.Errorf("output %s is not exported", output)
+		}
+	}
+
+	// The key of the previous deployment is reused
+	m, _, err = runDeploy(t, &FluxConfig{Mode: "install", Version: "v2.6.4"}, gitCfg, map[string]interface{}{
+		AgeKeyOutput: map[string]interface{}{
+			"identity":  "AGE-SECRET-KEY-1GFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPQ4EGAEX",
+			"recipient": "age1zvkyg2lqzraa2lnjvqej32nkuu0ues2s82hzrye869xeexvn73equnujwj",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := m.Resource("flux-sops-age")
+	stringData := secret.Inputs["stringData"]
+	if stringData.IsSecret() {
+		stringData = stringData.SecretValue().Element
+	}
+	key := stringData.ObjectValue()["age.agekey"]
+	if key.IsSecret() {
+		key = key.SecretValue().Element
+	}
+	if got := key.StringValue(); !strings.HasSuffix(got, "GFPQ4EGAEX") {
+		t.Errorf("age.agekey = %q, want the previous age key", got)
+	}
+}
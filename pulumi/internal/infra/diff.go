@@ -0,0 +1,272 @@
+package infra
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"cluster-studio/pkg/kube"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+)
+
+// DiffOutput is the stack output holding the preview diff of the
+// infrastructure tree, exported by previews with home:previewDiff
+const DiffOutput = "infrastructureDiff"
+
+// ObjectChange is an object of the infrastructure tree the update adds,
+// changes or removes
+type ObjectChange struct {
+	// Key of the object in its kustomize.Directory ("apps/v1/Deployment::namespace/name")
+	Object string `json:"object"`
+	// "add", "change" or "remove", or "render" when the cluster can't be read
+	Action string `json:"action"`
+	// Changed fields of a changed object (e.g. "spec.replicas: 1 -> 2")
+	Fields []string `json:"fields,omitempty"`
+}
+
+// liveGetter returns the live version of a rendered object, nil when it
+// doesn't exist
+type liveGetter func(object *unstructured.Unstructured) (*unstructured.Unstructured, error)
+
+// previewDiff renders every component and compares the objects with the live
+// ones of the cluster and with those applied by the previous deployment. It
+// is best-effort: a component that doesn't render is skipped with a warning,
+// and every object is reported as "render" when the cluster can't be read.
+func previewDiff(args *Args, components []string, logf func(format string, a ...interface{})) map[string][]ObjectChange {
+	var previousKeys map[string]map[string]interface{}
+	if args.Previous != nil {
+		if _, err := args.Previous.Output("infrastructureResources", &previousKeys); err != nil {
+			logf("not listing the removed objects: %v", err)
+		}
+	}
+
+	live, err := newLiveGetter(args.KubeconfigPath, args.KubeContext)
+	if err != nil {
+		logf("showing the rendered objects only, the cluster can't be read: %v", err)
+	}
+
+	diff := map[string][]ObjectChange{}
+	for _, component := range components {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		data, err := kube.Kustomize(ctx, filepath.Join(args.Dir, component))
+		cancel()
+		if err != nil {
+			logf("not diffing %s: %v", component, err)
+			continue
+		}
+		rendered, err := kube.DecodeObjects(data)
+		if err != nil {
+			logf("not diffing %s: %v", component, err)
+			continue
+		}
+
+		var previous []string
+		for key := range previousKeys[component] {
+			previous = append(previous, key)
+		}
+		changes, err := diffComponent(rendered, live, previous)
+		if err != nil {
+			logf("showing the rendered objects of %s only: %v", component, err)
+			changes, _ = diffComponent(rendered, nil, nil)
+		}
+		if len(changes) > 0 {
+			diff[component] = changes
+		}
+	}
+	return diff
+}
+
+// diffComponent compares the rendered objects of a component with their live
+// versions and lists the previously applied ones that are no longer
+// rendered. Without live every rendered object is reported as "render".
+func diffComponent(rendered []*unstructured.Unstructured, live liveGetter, previous []string) ([]ObjectChange, error) {
+	var changes []ObjectChange
+	keys := map[string]bool{}
+	for _, object := range rendered {
+		key := resourceKey(object)
+		keys[key] = true
+		if live == nil {
+			changes = append(changes, ObjectChange{Object: key, Action: "render"})
+			continue
+		}
+
+		current, err := live(object)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil {
+			changes = append(changes, ObjectChange{Object: key, Action: "add"})
+			continue
+		}
+		if fields := diffFields("", object.Object, current.Object); len(fields) > 0 {
+			changes = append(changes, ObjectChange{Object: key, Action: "change", Fields: fields})
+		}
+	}
+	if live != nil {
+		for _, key := range previous {
+			if !keys[key] {
+				changes = append(changes, ObjectChange{Object: key, Action: "remove"})
+			}
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Object < changes[j].Object })
+	return changes, nil
+}
+
+// diffFields lists the fields of rendered whose value differs in live. Only
+// the fields set in rendered are compared: the server adds defaults and
+// status to the live object, so a field removed from the tree is not listed.
+func diffFields(path string, rendered, live interface{}) []string {
+	switch r := rendered.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return []string{fieldChange(path, live, rendered)}
+		}
+		keys := make([]string, 0, len(r))
+		for key := range r {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var fields []string
+		for _, key := range keys {
+			child := key
+			if path != "" {
+				child = path + "." + key
+			}
+			if _, ok := l[key]; !ok {
+				fields = append(fields, fieldChange(child, nil, r[key]))
+				continue
+			}
+			fields = append(fields, diffFields(child, r[key], l[key])...)
+		}
+		return fields
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok || len(l) != len(r) {
+			return []string{fieldChange(path, live, rendered)}
+		}
+		var fields []string
+		for i := range r {
+			fields = append(fields, diffFields(fmt.Sprintf("%s[%d]", path, i), r[i], l[i])...)
+		}
+		return fields
+	}
+	// Numbers are int64 or float64 depending on how they were decoded
+	if reflect.DeepEqual(rendered, live) || fmt.Sprint(rendered) == fmt.Sprint(live) {
+		return nil
+	}
+	return []string{fieldChange(path, live, rendered)}
+}
+
+// fieldChange formats the change of a field from the live to the rendered value
+func fieldChange(path string, live, rendered interface{}) string {
+	return fmt.Sprintf("%s: %s -> %s", path, formatValue(live), formatValue(rendered))
+}
+
+func formatValue(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "<unset>"
+	case map[string]interface{}, []interface{}:
+		data, _ := json.Marshal(value)
+		if len(data) > 80 {
+			return string(data[:77]) + "..."
+		}
+		return string(data)
+	}
+	return fmt.Sprint(value)
+}
+
+// resourceKey returns the key of object in a kustomize.Directory
+func resourceKey(object *unstructured.Unstructured) string {
+	ref := object.GetName()
+	if object.GetNamespace() != "" {
+		ref = object.GetNamespace() + "/" + ref
+	}
+	return fmt.Sprintf("%s/%s::%s", object.GetAPIVersion(), object.GetKind(), ref)
+}
+
+// newLiveGetter connects to the cluster of the kube context and returns a
+// getter of live objects
+func newLiveGetter(kubeconfigPath, kubeContext string) (liveGetter, error) {
+	restConfig, err := kube.NewRESTConfig(kubeconfigPath, kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	restConfig.Timeout = 10 * time.Second
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := discoveryClient.ServerVersion(); err != nil {
+		return nil, err
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
+
+	return func(object *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+		gvk := object.GroupVersionKind()
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if meta.IsNoMatchError(err) {
+			// The CRD is installed by the update
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		resource := client.Resource(mapping.Resource)
+		var current *unstructured.Unstructured
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			namespace := object.GetNamespace()
+			if namespace == "" {
+				namespace = metav1.NamespaceDefault
+			}
+			current, err = resource.Namespace(namespace).Get(context.Background(), object.GetName(), metav1.GetOptions{})
+		} else {
+			current, err = resource.Get(context.Background(), object.GetName(), metav1.GetOptions{})
+		}
+		if k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return current, err
+	}, nil
+}
+
+// summarizeDiff counts the changes by action (e.g. "2 add, 1 change")
+func summarizeDiff(diff map[string][]ObjectChange) string {
+	counts := map[string]int{}
+	for _, changes := range diff {
+		for _, change := range changes {
+			counts[change.Action]++
+		}
+	}
+	var parts []string
+	for _, action := range []string{"add", "change", "remove", "render"} {
+		if counts[action] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[action], action))
+		}
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,72 @@
+package infra
+
+import (
+	"time"
+
+	infrapkg "cluster-studio/pkg/infra"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/kustomize"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// ExtraOutput is the stack output holding the resources of every extra
+// kustomize directory
+const ExtraOutput = "extraKustomizeResources"
+
+// ExtraArgs configures DeployExtra
+type ExtraArgs struct {
+	// Kustomizations applied after the infrastructure (home:extraKustomizeDirs)
+	Directories []infrapkg.Directory
+	// Applied infrastructure components, empty without the infrastructure
+	Components map[string]*kustomize.Directory
+	// Labels set on every object
+	Labels map[string]string
+	// Mesh of the injected namespaces: "linkerd", "istio" or "none"
+	Mesh string
+	// Namespaces meshed by Mesh
+	InjectNamespaces []string
+	// Image repository -> image built from the repository run instead
+	Images map[string]string
+	// How long creating or updating each object may take
+	Timeout time.Duration
+	// Provider of the cluster
+	Provider *kubernetes.Provider
+	// Resources the directories are applied after
+	DependsOn []pulumi.Resource
+}
+
+// DeployExtra applies the extra kustomize directories after the
+// infrastructure, each as its own resource so a failure is reported per
+// directory. They are never protected: removing a directory from the config
+// prunes its objects on the next update.
+func DeployExtra(ctx *pulumi.Context, args *ExtraArgs) (*Infra, error) {
+	directories, err := infrapkg.DeployDirectories(ctx, &infrapkg.DirectoriesArgs{
+		Directories: args.Directories,
+		Components:  args.Components,
+		DependsOn:   args.DependsOn,
+		Metadata: &infrapkg.Metadata{
+			Labels:           args.Labels,
+			Mesh:             args.Mesh,
+			InjectNamespaces: args.InjectNamespaces,
+			Images:           args.Images,
+		},
+	}, pulumi.Provider(args.Provider), pulumi.Timeouts(&pulumi.CustomTimeouts{
+		Create: args.Timeout.String(),
+		Update: args.Timeout.String(),
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	resources := pulumi.Map{}
+	for name, dir := range directories {
+		resources[name] = dir.Resources
+	}
+	return &Infra{
+		Directories: directories,
+		Applied:     infrapkg.Applied(directories),
+		Inventory:   infrapkg.Inventory(directories),
+		Exports:     pulumi.Map{ExtraOutput: resources},
+	}, nil
+}
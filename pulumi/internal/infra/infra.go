@@ -0,0 +1,246 @@
+package infra
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"cluster-studio/internal/previous"
+	fluxpkg "cluster-studio/pkg/flux"
+	infrapkg "cluster-studio/pkg/infra"
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/kustomize"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// PrerequisitesOutput is the stack output listing the HelmRepositories and
+// CRDs the infrastructure components were applied after
+const PrerequisitesOutput = "infrastructurePrerequisites"
+
+// Args configures Deploy
+type Args struct {
+	// Directory with the infrastructure kustomization
+	Dir string
+	// Component -> components it must be applied after
+	Dependencies map[string][]string
+	// Components installed by the program instead (e.g. cert-manager)
+	Skip []string
+	// Objects installed by the program instead, as Kind/namespace/name
+	Exclude []string
+	// Labels set on every object
+	Labels map[string]string
+	// Mesh of the injected namespaces: "linkerd", "istio" or "none"
+	Mesh string
+	// Namespaces meshed by Mesh
+	InjectNamespaces []string
+	// Image repository -> image built from the repository run instead
+	Images map[string]string
+	// Protect the applied objects from deletion (and destroy)
+	Protect bool
+	// How long creating or updating each object may take
+	Timeout time.Duration
+	// Diff the rendered components against the cluster during previews
+	PreviewDiff bool
+	// Kubeconfig file (empty for the default one) and context of the
+	// cluster, read by the preview diff
+	KubeconfigPath string
+	KubeContext    string
+	// Last deployment of the stack, for the objects the preview diff removes
+	Previous *previous.Deployment
+	// Apply the components defining HelmRepositories and CRDs first and the
+	// others once those are Ready (optional, requires Flux)
+	Bootstrap *BootstrapArgs
+	// Provider of the cluster
+	Provider *kubernetes.Provider
+	// Resources the components are applied after
+	DependsOn []pulumi.Resource
+}
+
+// BootstrapArgs configures the HelmRepositories and CRDs established before
+// the components using them are applied
+type BootstrapArgs struct {
+	// HelmRepositories applied besides those of the tree
+	HelmRepositories []fluxpkg.HelmRepository
+	// CRD manifests applied besides those of the tree: files, directories or URLs
+	CRDManifests []string
+	// Kubeconfig of the cluster (secret)
+	Kubeconfig pulumi.StringInput
+	// How long to wait for the HelmRepositories and CRDs
+	Timeout time.Duration
+}
+
+// Infra is the result of Deploy
+type Infra struct {
+	// Component -> kustomize directory
+	Directories map[string]*kustomize.Directory
+	// Resolves once every object has been created or updated
+	Applied pulumi.ArrayOutput
+	// Objects applied by kind and namespace, resolved with Applied
+	Inventory pulumi.Output
+	// Stack outputs of the infrastructure (the resources of every component)
+	Exports pulumi.Map
+}
+
+// Deploy applies the infrastructure components with Kustomize from the YAML
+// files of the tree, one directory per component following the dependency graph
+func Deploy(ctx *pulumi.Context, args *Args) (*Infra, error) {
+	componentsArgs := &infrapkg.ComponentsArgs{
+		Dir:          args.Dir,
+		Dependencies: args.Dependencies,
+		DependsOn:    args.DependsOn,
+		Skip:         args.Skip,
+		Metadata: &infrapkg.Metadata{
+			Labels:           args.Labels,
+			Mesh:             args.Mesh,
+			InjectNamespaces: args.InjectNamespaces,
+			Images:           args.Images,
+			Exclude:          args.Exclude,
+		},
+	}
+	opts := []pulumi.ResourceOption{pulumi.Provider(args.Provider), pulumi.Protect(args.Protect), pulumi.Timeouts(&pulumi.CustomTimeouts{
+		Create: args.Timeout.String(),
+		Update: args.Timeout.String(),
+	})}
+
+	exports := pulumi.Map{}
+	var directories map[string]*kustomize.Directory
+	var err error
+	if args.Bootstrap != nil {
+		directories, err = deployBootstrapped(ctx, args, componentsArgs, exports, opts)
+	} else {
+		directories, err = infrapkg.DeployComponents(ctx, componentsArgs, opts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resources := pulumi.Map{}
+	components := make([]string, 0, len(directories))
+	for component, dir := range directories {
+		resources[component] = dir.Resources
+		components = append(components, component)
+	}
+	sort.Strings(components)
+	exports["infrastructureResources"] = resources
+
+	// The kustomize directories only show as updated in a preview, list
+	// which objects change
+	if args.PreviewDiff && ctx.DryRun() {
+		diff := previewDiff(args, components, func(format string, a ...interface{}) {
+			_ = ctx.Log.Warn(fmt.Sprintf(format, a...), nil)
+		})
+		_ = ctx.Log.Info(fmt.Sprintf("infrastructure diff: %s", summarizeDiff(diff)), nil)
+		for _, component := range components {
+			for _, change := range diff[component] {
+				_ = ctx.Log.Info(fmt.Sprintf("%s %s: %s", change.Action, component, change.Object), nil)
+				for _, field := range change.Fields {
+					_ = ctx.Log.Info(fmt.Sprintf("    %s", field), nil)
+				}
+			}
+		}
+
+		// Exported as plain values, with the JSON field names
+		data, err := json.Marshal(diff)
+		if err != nil {
+			return nil, err
+		}
+		var value map[string]interface{}
+		if err := json.Unmarshal(data, &value); err != nil {
+			return nil, err
+		}
+		exports[DiffOutput] = pulumi.ToMap(value)
+	}
+
+	return &Infra{
+		Directories: directories,
+		Applied:     infrapkg.Applied(directories),
+		Inventory:   infrapkg.Inventory(directories),
+		Exports:     exports,
+	}, nil
+}
+
+// deployBootstrapped applies the components defining HelmRepositories or CRDs
+// (and those they depend on), then the configured ones, waits for all of them
+// to be Ready and Established, and only then applies the other components,
+// so their HelmReleases and custom resources don't fail on a fresh cluster
+func deployBootstrapped(ctx *pulumi.Context, args *Args, componentsArgs *infrapkg.ComponentsArgs, exports pulumi.Map, opts []pulumi.ResourceOption) (map[string]*kustomize.Directory, error) {
+	discovered, err := infrapkg.DiscoverComponents(args.Dir)
+	if err != nil {
+		return nil, err
+	}
+	skip := make(map[string]bool, len(args.Skip))
+	for _, component := range args.Skip {
+		skip[component] = true
+	}
+	var components []string
+	for _, component := range discovered {
+		if !skip[component] {
+			components = append(components, component)
+		}
+	}
+	found, err := infrapkg.DiscoverPrerequisites(args.Dir, components)
+	if err != nil {
+		return nil, err
+	}
+
+	var repositories, crds, defining []string
+	for _, component := range components {
+		if prerequisites, ok := found[component]; ok {
+			repositories = append(repositories, prerequisites.HelmRepositories...)
+			crds = append(crds, prerequisites.CRDs...)
+			defining = append(defining, component)
+		}
+	}
+	first := map[string]bool{}
+	for _, component := range infrapkg.WithDependencies(defining, args.Dependencies) {
+		first[component] = true
+	}
+	var firstSkip, restSkip []string
+	for _, component := range discovered {
+		if first[component] {
+			restSkip = append(restSkip, component)
+		} else {
+			firstSkip = append(firstSkip, component)
+		}
+	}
+
+	firstArgs := *componentsArgs
+	firstArgs.Skip = append(firstSkip, args.Skip...)
+	firstDirectories, err := infrapkg.DeployComponents(ctx, &firstArgs, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	prerequisites, err := fluxpkg.NewPrerequisites(ctx, stacks.Name(ctx, "infrastructure-prerequisites"), &fluxpkg.PrerequisitesArgs{
+		HelmRepositories:     args.Bootstrap.HelmRepositories,
+		CRDManifests:         args.Bootstrap.CRDManifests,
+		WaitHelmRepositories: repositories,
+		WaitCRDs:             crds,
+		Applied:              infrapkg.Applied(firstDirectories),
+		Kubeconfig:           args.Bootstrap.Kubeconfig,
+		Timeout:              args.Bootstrap.Timeout,
+	}, pulumi.Provider(args.Provider), pulumi.DependsOn(args.DependsOn))
+	if err != nil {
+		return nil, err
+	}
+	exports[PrerequisitesOutput] = pulumi.Map{
+		"helmRepositories": prerequisites.HelmRepositories,
+		"crds":             prerequisites.CRDs,
+	}
+
+	restArgs := *componentsArgs
+	restArgs.Skip = append(restSkip, args.Skip...)
+	restArgs.Applied = firstDirectories
+	restArgs.DependsOn = append(append([]pulumi.Resource{}, args.DependsOn...), prerequisites)
+	directories, err := infrapkg.DeployComponents(ctx, &restArgs, opts...)
+	if err != nil {
+		return nil, err
+	}
+	for component, dir := range firstDirectories {
+		directories[component] = dir
+	}
+	return directories, nil
+}
@@ -0,0 +1,342 @@
+package infra
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cluster-studio/internal/pulumitest"
+	fluxpkg "cluster-studio/pkg/flux"
+	infrapkg "cluster-studio/pkg/infra"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// infraDir creates an infrastructure tree with one kustomization per component
+func infraDir(t *testing.T, components ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, component := range components {
+		if err := os.MkdirAll(filepath.Join(dir, component), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		err := os.WriteFile(filepath.Join(dir, component, "kustomization.yaml"), []byte("resources: []\n"), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// runDeploy applies the infrastructure with mocks after the provider of a
+// "cluster" command
+func runDeploy(t *testing.T, args *Args) (*pulumitest.Mocks, *Infra, error) {
+	t.Helper()
+	m := &pulumitest.Mocks{}
+	var infra *Infra
+	err := pulumitest.Run(t, "test", nil, m, func(ctx *pulumi.Context) error {
+		cluster, err := local.NewCommand(ctx, "cluster", &local.CommandArgs{Create: pulumi.String("true")})
+		if err != nil {
+			return err
+		}
+		provider, err := kubernetes.NewProvider(ctx, "provider", &kubernetes.ProviderArgs{
+			Kubeconfig: cluster.Stdout,
+		})
+		if err != nil {
+			return err
+		}
+		args.Timeout = time.Minute
+		args.Provider = provider
+		args.DependsOn = []pulumi.Resource{cluster}
+		infra, err = Deploy(ctx, args)
+		return err
+	})
+	return m, infra, err
+}
+
+func TestDeploy(t *testing.T) {
+	m, infra, err := runDeploy(t, &Args{
+		Dir:          infraDir(t, "a", "b", "cert-manager"),
+		Dependencies: map[string][]string{"b": {"a"}},
+		Skip:         []string{"cert-manager"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"infrastructure-a", "infrastructure-b"} {
+		if !m.Has(name) {
+			t.Errorf("%s was not registered", name)
+		}
+		if !m.DependsOn(name, "cluster") {
+			t.Errorf("%s doesn't wait for the cluster", name)
+		}
+	}
+	if m.Has("infrastructure-cert-manager") {
+		t.Error("a skipped component was applied")
+	}
+	if _, ok := infra.Directories["cert-manager"]; ok || len(infra.Directories) != 2 {
+		t.Errorf("got directories %v, want a and b", infra.Directories)
+	}
+	if _, ok := infra.Exports["infrastructureResources"]; !ok {
+		t.Error("output infrastructureResources is not exported")
+	}
+}
+
+func TestDeployDependencyCycle(t *testing.T) {
+	_, _, err := runDeploy(t, &Args{
+		Dir:          infraDir(t, "a", "b"),
+		Dependencies: map[string][]string{"a": {"b"}, "b": {"a"}},
+	})
+	if err == nil {
+		t.Fatal("expected an error for a dependency cycle")
+	}
+}
+
+// runDeployExtra applies the infrastructure of args, then the extra directories
+func runDeployExtra(t *testing.T, args *Args, dirs []infrapkg.Directory) (*pulumitest.Mocks, *Infra, error) {
+	t.Helper()
+	m := &pulumitest.Mocks{}
+	var extra *Infra
+	err := pulumitest.Run(t, "test", nil, m, func(ctx *pulumi.Context) error {
+		provider, err := kubernetes.NewProvider(ctx, "provider", &kubernetes.ProviderArgs{})
+		if err != nil {
+			return err
+		}
+		args.Provider = provider
+		infra, err := Deploy(ctx, args)
+		if err != nil {
+			return err
+		}
+		extra, err = DeployExtra(ctx, &ExtraArgs{
+			Directories: dirs,
+			Components:  infra.Directories,
+			Timeout:     time.Minute,
+			Provider:    provider,
+		})
+		return err
+	})
+	return m, extra, err
+}
+
+func TestDeployExtra(t *testing.T) {
+	overlays := infraDir(t, "gpu", "nas", "backups")
+	m, extra, err := runDeployExtra(t, &Args{Dir: infraDir(t, "a", "b")}, []infrapkg.Directory{
+		{Name: "backups", Dir: filepath.Join(overlays, "backups"), DependsOn: []string{"nas"}},
+		{Name: "gpu", Dir: filepath.Join(overlays, "gpu")},
+		{Name: "nas", Dir: filepath.Join(overlays, "nas"), DependsOn: []string{"a"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"extra-backups", "extra-gpu", "extra-nas"} {
+		if !m.Has(name) {
+			t.Errorf("%s was not registered", name)
+		}
+	}
+	if len(extra.Directories) != 3 {
+		t.Errorf("got directories %v, want backups, gpu and nas", extra.Directories)
+	}
+	if _, ok := extra.Exports[ExtraOutput]; !ok {
+		t.Errorf("output %s is not exported", ExtraOutput)
+	}
+
+	for _, tc := range []struct {
+		name string
+		dirs []infrapkg.Directory
+		want string
+	}{
+		{
+			name: "unknown dependency",
+			dirs: []infrapkg.Directory{{Name: "gpu", Dir: overlays, DependsOn: []string{"missing"}}},
+			want: "depends on missing",
+		},
+		{
+			name: "component name",
+			dirs: []infrapkg.Directory{{Name: "a", Dir: overlays}},
+			want: "has the name of an infrastructure component",
+		},
+		{
+			name: "cycle",
+			dirs: []infrapkg.Directory{
+				{Name: "gpu", Dir: overlays, DependsOn: []string{"nas"}},
+				{Name: "nas", Dir: overlays, DependsOn: []string{"gpu"}},
+			},
+			want: "dependency cycle",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := runDeployExtra(t, &Args{Dir: infraDir(t, "a")}, tc.dirs)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected an error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestDeployBootstrap(t *testing.T) {
+	dir := infraDir(t, "repositories", "sealed-secrets", "crds", "apps")
+	files := map[string]string{
+		"repositories/helm.yaml": `apiVersion: source.toolkit.fluxcd.io/v1beta2
+kind: HelmRepository
+metadata:
+  name: bitnami
+  namespace: flux-system
+spec:
+  url: https://charts.bitnami.com/bitnami
+`,
+		"crds/widgets.yaml": `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+`,
+		// Vendored chart templates are not manifests
+		"apps/chart/templates/deployment.yaml": "{{- if .Values.enabled }}\nkind: Deployment\n{{- end }}\n",
+	}
+	crdManifest := filepath.Join(t.TempDir(), "gadgets.yaml")
+	files[crdManifest] = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: gadgets.example.com
+spec:
+  group: example.com
+`
+	for name, content := range files {
+		path := name
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, name)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, infra, err := runDeploy(t, &Args{
+		Dir:          dir,
+		Dependencies: map[string][]string{"repositories": {"sealed-secrets"}, "apps": {"repositories"}},
+		Bootstrap: &BootstrapArgs{
+			HelmRepositories: []fluxpkg.HelmRepository{{Name: "podinfo", URL: "https://stefanprodan.github.io/podinfo"}},
+			CRDManifests:     []string{crdManifest},
+			Timeout:          time.Minute,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{
+		"infrastructure-prerequisites-helmrepository-flux-system-podinfo",
+		"infrastructure-prerequisites-crd-gadgets.example.com",
+	} {
+		if !m.Has(name) {
+			t.Errorf("%s was not applied", name)
+		}
+	}
+	// The components defining sources and CRDs, and their dependencies, go
+	// first; the others wait for the prerequisites to be Ready
+	for _, component := range []string{"repositories", "sealed-secrets", "crds"} {
+		if m.DependsOn("infrastructure-"+component, "infrastructure-prerequisites-report") {
+			t.Errorf("%s waits for the prerequisites it defines", component)
+		}
+	}
+	if !m.DependsOn("infrastructure-apps", "infrastructure-prerequisites-report") {
+		t.Error("apps doesn't wait for the prerequisites")
+	}
+	if len(infra.Directories) != 4 {
+		t.Errorf("got directories %v, want all four components", infra.Directories)
+	}
+	if _, ok := infra.Exports[PrerequisitesOutput]; !ok {
+		t.Errorf("output %s is not exported", PrerequisitesOutput)
+	}
+
+	report, _ := m.Resource("infrastructure-prerequisites-report")
+	status := report.Inputs["data"].ObjectValue()["status.json"].StringValue()
+	for _, want := range []string{"flux-system/bitnami", "flux-system/podinfo", "widgets.example.com", "gadgets.example.com"} {
+		if !strings.Contains(status, want) {
+			t.Errorf("%s is not waited for:\n%s", want, status)
+		}
+	}
+}
+
+func TestDeployPreviewDiff(t *testing.T) {
+	_, infra, err := runDeploy(t, &Args{Dir: infraDir(t, "a")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := infra.Exports[DiffOutput]; ok {
+		t.Errorf("output %s is exported without home:previewDiff", DiffOutput)
+	}
+
+	_, infra, err = runDeploy(t, &Args{
+		Dir:            infraDir(t, "a"),
+		PreviewDiff:    true,
+		KubeconfigPath: filepath.Join(t.TempDir(), "missing"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := infra.Exports[DiffOutput]; !ok {
+		t.Errorf("output %s is not exported", DiffOutput)
+	}
+}
+
+func TestDiffComponent(t *testing.T) {
+	object := func(kind, name string, spec map[string]interface{}) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       kind,
+			"metadata":   map[string]interface{}{"name": name, "namespace": "default"},
+			"spec":       spec,
+		}}
+	}
+	liveObjects := map[string]*unstructured.Unstructured{
+		"web": object("Deployment", "web", map[string]interface{}{
+			"replicas": int64(1),
+			"paused":   false,
+			"strategy": map[string]interface{}{"type": "RollingUpdate"},
+		}),
+		"api": object("Deployment", "api", map[string]interface{}{"replicas": int64(2)}),
+	}
+	live := func(o *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+		return liveObjects[o.GetName()], nil
+	}
+	rendered := []*unstructured.Unstructured{
+		object("Deployment", "web", map[string]interface{}{"replicas": float64(3), "paused": false}),
+		object("Deployment", "api", map[string]interface{}{"replicas": float64(2)}),
+		object("Deployment", "worker", map[string]interface{}{"replicas": int64(1)}),
+	}
+
+	changes, err := diffComponent(rendered, live, []string{
+		"apps/v1/Deployment::default/web",
+		"apps/v1/Deployment::default/cron",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ObjectChange{
+		{Object: "apps/v1/Deployment::default/cron", Action: "remove"},
+		{Object: "apps/v1/Deployment::default/web", Action: "change", Fields: []string{"spec.replicas: 1 -> 3"}},
+		{Object: "apps/v1/Deployment::default/worker", Action: "add"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes = %+v, want %+v", changes, want)
+	}
+
+	// Without the cluster only the rendered objects are known
+	changes, err = diffComponent(rendered, nil, []string{"apps/v1/Deployment::default/cron"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 3 || changes[0].Action != "render" {
+		t.Errorf("changes = %+v, want the 3 rendered objects", changes)
+	}
+}
@@ -0,0 +1,50 @@
+package mesh
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"cluster-studio/internal/adopt"
+	"cluster-studio/pkg/linkerd"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// adoptLinkerd checks a control plane found in the cluster before the stack
+// installs its own, and returns the Helm releases to import. It fails when
+// the installation can't be taken over as is: installed without Helm, at
+// another version, or trusting another anchor, which would break the
+// identity of the meshed workloads.
+func adoptLinkerd(ctx *pulumi.Context, linkerdCfg *LinkerdConfig, identity *linkerd.Identity, existing *adopt.Linkerd) ([]string, error) {
+	if existing == nil {
+		return nil, nil
+	}
+	if linkerdCfg.InstallMethod == "script" {
+		_ = ctx.Log.Warn(fmt.Sprintf("adopting the Linkerd control plane in namespace %s, which already exists: install-linkerd.sh leaves it in place",
+			linkerd.Namespace), nil)
+		return nil, nil
+	}
+
+	if len(existing.Releases) == 0 {
+		return nil, fmt.Errorf("Linkerd is already installed in namespace %s without Helm, uninstall it with `linkerd uninstall` "+
+			"or set home:linkerdInstallMethod=script", linkerd.Namespace)
+	}
+	releases := make([]string, 0, len(existing.Releases))
+	for release, version := range existing.Releases {
+		if version != linkerdCfg.Version {
+			return nil, fmt.Errorf("Helm release %s/%s is already installed at %s, not %s: set home:linkerdVersion=%[3]s to adopt it, "+
+				"or uninstall it first", linkerd.Namespace, release, version, linkerdCfg.Version)
+		}
+		releases = append(releases, release)
+	}
+	sort.Strings(releases)
+	if existing.TrustRoots != "" && !strings.Contains(existing.TrustRoots, strings.TrimSpace(identity.TrustAnchorPEM)) {
+		return nil, fmt.Errorf("the Linkerd control plane in namespace %s trusts another anchor: set home:linkerdTrustAnchorPEM and "+
+			"home:linkerdTrustAnchorKeyPEM to the one it was installed with to adopt it", linkerd.Namespace)
+	}
+
+	_ = ctx.Log.Warn(fmt.Sprintf("adopting the existing Helm releases %s of Linkerd %s in namespace %s",
+		strings.Join(releases, ", "), linkerdCfg.Version, linkerd.Namespace), nil)
+	return releases, nil
+}
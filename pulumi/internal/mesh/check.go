@@ -0,0 +1,69 @@
+package mesh
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"cluster-studio/pkg/linkerd"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// ChecksOutput is the stack output holding the results of the linkerd checks
+// run after the installation
+const ChecksOutput = "linkerdChecks"
+
+// runChecks runs `linkerd check`, and `linkerd viz check` with Viz, once
+// installed resolves. Any check at least as severe as Linkerd.CheckSeverity
+// fails the update. The returned output holds the results of every check.
+func runChecks(ctx *pulumi.Context, args *Args, installed pulumi.StringOutput) pulumi.MapOutput {
+	checks := installed.ApplyT(func(string) ([]interface{}, error) {
+		if ctx.DryRun() {
+			return []interface{}{}, nil
+		}
+
+		commands := [][]string{{"check"}}
+		if args.Viz {
+			commands = append(commands, []string{"viz", "check"})
+		}
+		var checks []linkerd.Check
+		for _, command := range commands {
+			checkCtx, cancel := context.WithTimeout(context.Background(), args.CheckTimeout)
+			results, err := linkerd.RunCheck(checkCtx, args.KubeconfigPath,
+				append(command, "--context", args.KubeContext, "--wait", args.CheckTimeout.String())...)
+			cancel()
+			if err != nil {
+				return nil, err
+			}
+			checks = append(checks, results...)
+		}
+
+		var failed []string
+		for _, check := range checks {
+			if check.AtLeast(args.Linkerd.CheckSeverity) {
+				failed = append(failed, check.String())
+			}
+		}
+		if len(failed) > 0 {
+			return nil, fmt.Errorf("linkerd checks failed:\n  - %s", strings.Join(failed, "\n  - "))
+		}
+
+		// Exported as plain values, with the JSON field names
+		data, err := json.Marshal(checks)
+		if err != nil {
+			return nil, err
+		}
+		var results []interface{}
+		if err := json.Unmarshal(data, &results); err != nil {
+			return nil, err
+		}
+		return results, nil
+	})
+
+	return pulumi.Map{
+		"failOn": pulumi.String(args.Linkerd.CheckSeverity),
+		"checks": checks,
+	}.ToMapOutput()
+}
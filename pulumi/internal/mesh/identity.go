@@ -0,0 +1,51 @@
+package mesh
+
+import (
+	"fmt"
+
+	"cluster-studio/internal/previous"
+	"cluster-studio/pkg/linkerd"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// IdentityOutput is the stack output holding the Linkerd certificates
+const IdentityOutput = "linkerdIdentity"
+
+// loadLinkerdIdentity returns the Linkerd trust anchor and issuer to install.
+// Certificates from the previous deployment of this stack are reused so that
+// a plain `pulumi up` never rotates them; see linkerd.EnsureIdentity.
+func loadLinkerdIdentity(ctx *pulumi.Context, linkerdCfg *LinkerdConfig, previousDeploy *previous.Deployment) (*linkerd.Identity, error) {
+	previous := &linkerd.Identity{}
+	found, err := previousDeploy.Output(IdentityOutput, previous)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		previous = nil
+	}
+
+	// An imported trust anchor replaces whatever was generated before
+	if linkerdCfg.TrustAnchorPEM != "" {
+		if previous == nil || previous.TrustAnchorPEM != linkerdCfg.TrustAnchorPEM {
+			previous = &linkerd.Identity{}
+		}
+		previous.TrustAnchorPEM = linkerdCfg.TrustAnchorPEM
+		previous.TrustAnchorKeyPEM = linkerdCfg.TrustAnchorKeyPEM
+	}
+
+	identity, err := linkerd.EnsureIdentity(previous, linkerd.IdentityOptions{
+		TrustAnchorValidity: linkerdCfg.TrustAnchorValidity,
+		IssuerValidity:      linkerdCfg.IssuerValidity,
+		RotateIssuer:        linkerdCfg.RotateIssuer,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare Linkerd identity certificates: %w", err)
+	}
+
+	if previous != nil && previous.IssuerCertPEM != "" && previous.IssuerCertPEM != identity.IssuerCertPEM {
+		_ = ctx.Log.Info("Linkerd issuer certificate rotated", nil)
+	}
+
+	return identity, nil
+}
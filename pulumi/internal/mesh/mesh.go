@@ -0,0 +1,279 @@
+package mesh
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"cluster-studio/internal/adopt"
+	"cluster-studio/internal/previous"
+	"cluster-studio/pkg/linkerd"
+	"cluster-studio/pkg/shell"
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// LinkerdConfig describes the Linkerd installation
+type LinkerdConfig struct {
+	// How Linkerd is installed: "helm" or "script" (install-linkerd.sh, deprecated)
+	InstallMethod string
+	// Chart version of the Linkerd Helm charts
+	Version string
+	// Existing trust anchor certificate and key to import instead of generating one (optional)
+	TrustAnchorPEM    string
+	TrustAnchorKeyPEM string
+	// Validity of generated trust anchor and issuer certificates
+	TrustAnchorValidity time.Duration
+	IssuerValidity      time.Duration
+	// Regenerate the issuer certificate while keeping the trust anchor
+	RotateIssuer bool
+	// Lowest result of `linkerd check` failing the update: "error" or "warning"
+	CheckSeverity string
+	// Install the control plane in high availability mode (script only)
+	HA bool
+}
+
+// Args configures Deploy
+type Args struct {
+	Linkerd *LinkerdConfig
+	// Install Linkerd Viz after the control plane
+	Viz bool
+	// Name of the cluster, passed to the install scripts
+	ClusterName string
+	// Directory of install-linkerd.sh and install-linkerd-viz.sh
+	ScriptsDir string
+	// Kube context to install into
+	KubeContext string
+	// Kubeconfig of the cluster (secret), to read the running version
+	Kubeconfig pulumi.StringOutput
+	// Kubeconfig file of the linkerd checks, empty for the default one
+	KubeconfigPath string
+	// Environment of the linkerd and kubectl commands (KUBE_CONTEXT, KUBECONFIG)
+	CLIEnvironment pulumi.StringMap
+	// How long the control plane and Viz installation may take each
+	Timeout time.Duration
+	// How long each linkerd check run after the installation may take
+	CheckTimeout time.Duration
+	// Retries of a failed install script
+	Retry shell.RetryPolicy
+	// Provider of the cluster, for the Helm releases
+	Provider *kubernetes.Provider
+	// Last deployment of the stack, to reuse the identity certificates
+	Previous *previous.Deployment
+	// Resources Linkerd is installed after
+	DependsOn []pulumi.Resource
+	// Control plane found in the cluster that the stack didn't install, nil
+	// for none
+	Existing *adopt.Linkerd
+}
+
+// Mesh is the result of Deploy
+type Mesh struct {
+	// Last resource of the installation, what the next step depends on
+	Resource pulumi.Resource
+	// Control plane version, resolved once installed (empty for the script)
+	Version pulumi.StringOutput
+	// Resolves (empty) once Viz is installed, when Args.Viz is set
+	VizVersion pulumi.StringOutput
+	// Stack outputs of the installation (the identity certificates)
+	Exports pulumi.Map
+	// Step -> output of the install and check commands
+	Outputs map[string]*shell.Output
+}
+
+// Deploy installs the Linkerd control plane and, when enabled, Linkerd Viz
+func Deploy(ctx *pulumi.Context, args *Args) (*Mesh, error) {
+	mesh := &Mesh{Exports: pulumi.Map{}, Outputs: map[string]*shell.Output{}}
+
+	controlPlane, version, err := deployLinkerd(ctx, args, mesh.Exports)
+	if err != nil {
+		return nil, err
+	}
+	mesh.Resource, mesh.Version = controlPlane, version
+	if args.Linkerd.InstallMethod == "script" {
+		mesh.Outputs["linkerdInstall"] = shell.CommandOutput(controlPlane)
+	} else {
+		mesh.Outputs["linkerdCheck"] = shell.CommandOutput(controlPlane)
+	}
+	if !args.Viz {
+		mesh.Exports[ChecksOutput] = runChecks(ctx, args, controlPlane.Stdout)
+		return mesh, nil
+	}
+
+	viz, err := installScript(ctx, args, &script{
+		name:      "linkerd-viz-install",
+		step:      "linkerd viz install",
+		file:      "install-linkerd-viz.sh",
+		namespace: "linkerd-viz",
+		uninstall: fmt.Sprintf("linkerd viz uninstall --context %[1]s | kubectl --context %[1]s delete --ignore-not-found -f -", args.KubeContext),
+		settings:  map[string]string{"LINKERD_VERSION": linkerd.CLIVersion(args.Linkerd.Version)},
+	}, []pulumi.Resource{controlPlane})
+	if err != nil {
+		return nil, err
+	}
+	mesh.Resource, mesh.VizVersion = viz, commandVersion(viz, "")
+	mesh.Outputs["linkerdViz"] = shell.CommandOutput(viz)
+	mesh.Exports[ChecksOutput] = runChecks(ctx, args, viz.Stdout)
+
+	return mesh, nil
+}
+
+// deployLinkerd installs the Linkerd control plane with the configured method.
+// With Helm a version change upgrades the releases in place (CRDs first) and
+// the returned resource is the `linkerd check` run afterwards. The returned
+// version resolves once the control plane is installed and is empty for the
+// script, which installs whatever the Linkerd CLI ships.
+func deployLinkerd(ctx *pulumi.Context, args *Args, exports pulumi.Map) (*local.Command, pulumi.StringOutput, error) {
+	linkerdCfg := args.Linkerd
+	identity, err := loadLinkerdIdentity(ctx, linkerdCfg, args.Previous)
+	if err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+	exports[IdentityOutput] = pulumi.ToSecret(pulumi.StringMap{
+		"trustAnchorPEM":    pulumi.String(identity.TrustAnchorPEM),
+		"trustAnchorKeyPEM": pulumi.String(identity.TrustAnchorKeyPEM),
+		"issuerCertPEM":     pulumi.String(identity.IssuerCertPEM),
+		"issuerKeyPEM":      pulumi.String(identity.IssuerKeyPEM),
+	})
+
+	imports, err := adoptLinkerd(ctx, linkerdCfg, identity, args.Existing)
+	if err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+
+	if linkerdCfg.InstallMethod == "script" {
+		// Deprecated: kept for one release while stacks migrate to Helm
+		install, err := installScript(ctx, args, &script{
+			name:      "linkerd-install",
+			step:      "linkerd install",
+			file:      "install-linkerd.sh",
+			namespace: linkerd.Namespace,
+			uninstall: fmt.Sprintf("linkerd uninstall --context %[1]s | kubectl --context %[1]s delete --ignore-not-found -f -", args.KubeContext),
+			settings: map[string]string{
+				"LINKERD_VERSION": linkerd.CLIVersion(linkerdCfg.Version),
+				"LINKERD_HA":      strconv.FormatBool(linkerdCfg.HA),
+			},
+			// linkerd-viz-install owns viz: toggling it must not reinstall
+			// the control plane
+			environment: map[string]string{"VIZ_ENABLED": "false"},
+			secrets: pulumi.StringMap{
+				"LINKERD_TRUST_ANCHOR_PEM": pulumi.String(identity.TrustAnchorPEM),
+				"LINKERD_ISSUER_CERT_PEM":  pulumi.String(identity.IssuerCertPEM),
+				"LINKERD_ISSUER_KEY_PEM":   pulumi.ToSecret(pulumi.String(identity.IssuerKeyPEM)).(pulumi.StringOutput),
+			},
+		}, args.DependsOn)
+		if err != nil {
+			return nil, pulumi.StringOutput{}, err
+		}
+		return install, commandVersion(install, ""), nil
+	}
+
+	if err := checkUpgrade(ctx, args.Previous, linkerdCfg.Version); err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+
+	controlPlane, err := linkerd.NewControlPlane(ctx, stacks.Name(ctx, "linkerd"), &linkerd.ControlPlaneArgs{
+		Version:        linkerdCfg.Version,
+		TrustAnchorPEM: pulumi.String(identity.TrustAnchorPEM),
+		IssuerCertPEM:  pulumi.ToSecret(pulumi.String(identity.IssuerCertPEM)).(pulumi.StringOutput),
+		IssuerKeyPEM:   pulumi.ToSecret(pulumi.String(identity.IssuerKeyPEM)).(pulumi.StringOutput),
+		Timeout:        args.Timeout,
+		Import:         imports,
+	}, pulumi.Providers(args.Provider), pulumi.DependsOn(args.DependsOn))
+	if err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+
+	check, running, err := verifyControlPlane(ctx, args, controlPlane, linkerdCfg.Version)
+	if err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+	exports[VersionOutput] = pulumi.StringMap{
+		"chart":   controlPlane.Version,
+		"running": running,
+	}
+	return check, controlPlane.Version, nil
+}
+
+// script is an install script of Args.ScriptsDir
+type script struct {
+	// Command running it and step reported when it fails
+	name, step string
+	// File in Args.ScriptsDir
+	file string
+	// Namespace reported on a timeout and command deleting the installation
+	namespace, uninstall string
+	// Settings of the installation the script only applies on a fresh
+	// install, passed as environment variables
+	settings map[string]string
+	// Other environment variables, a change runs the script again in place
+	environment map[string]string
+	// Secrets passed as environment variables, never on the command line
+	// where they would end up in the state and the logs in plaintext
+	secrets pulumi.StringMap
+}
+
+// installScript runs an install script with the CLI environment, its settings
+// and secrets. A change of the environment runs it again in place, while an
+// edit of the script or a change of its settings (both are triggers) deletes
+// the installation first, since the scripts leave a working one alone.
+func installScript(ctx *pulumi.Context, args *Args, s *script, dependsOn []pulumi.Resource) (*local.Command, error) {
+	checksum, err := shell.Checksum(filepath.Join(args.ScriptsDir, s.file))
+	if err != nil {
+		return nil, err
+	}
+	run := guardLinkerd(s.step, fmt.Sprintf("./%s %s", s.file, args.ClusterName), args.KubeContext, s.namespace, args.Timeout, args.Retry)
+
+	environment := pulumi.StringMap{}
+	for key, value := range args.CLIEnvironment {
+		environment[key] = value
+	}
+	triggers := pulumi.Array{pulumi.String(checksum)}
+	keys := make([]string, 0, len(s.settings))
+	for key := range s.settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		environment[key] = pulumi.String(s.settings[key])
+		triggers = append(triggers, pulumi.String(key+"="+s.settings[key]))
+	}
+	for key, value := range s.environment {
+		environment[key] = pulumi.String(value)
+	}
+	for key, value := range s.secrets {
+		environment[key] = value
+	}
+
+	return local.NewCommand(ctx, stacks.Name(ctx, s.name), &local.CommandArgs{
+		Create:      pulumi.String(run),
+		Update:      pulumi.String(run),
+		Dir:         pulumi.String(args.ScriptsDir),
+		Environment: environment,
+		Delete:      pulumi.String(s.uninstall),
+		Triggers:    triggers,
+		Interpreter: shell.Interpreter(),
+	}, pulumi.DependsOn(dependsOn), pulumi.DeleteBeforeReplace(true))
+}
+
+// guardLinkerd retries a Linkerd install script according to policy and limits
+// all attempts to timeout, reporting the pods of its namespace when it is exceeded
+func guardLinkerd(step, command, kubeContext, namespace string, timeout time.Duration, policy shell.RetryPolicy) string {
+	return shell.Guard(shell.Retry(command, step, policy), shell.GuardOptions{
+		Step:     step,
+		Timeout:  timeout,
+		Diagnose: fmt.Sprintf("kubectl --context %s -n %s get pods", kubeContext, namespace),
+	})
+}
+
+// commandVersion resolves to version once the command has completed
+func commandVersion(command *local.Command, version string) pulumi.StringOutput {
+	return command.Stdout.ApplyT(func(string) string {
+		return version
+	}).(pulumi.StringOutput)
+}
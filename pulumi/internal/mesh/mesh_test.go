@@ -0,0 +1,381 @@
+package mesh
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cluster-studio/internal/adopt"
+	"cluster-studio/internal/previous"
+	"cluster-studio/internal/pulumitest"
+	"cluster-studio/pkg/linkerd"
+	"cluster-studio/pkg/shell"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// runDeploy deploys Linkerd with mocks after the provider of a "cluster"
+// command, on top of the given previous deployment outputs
+func runDeploy(t *testing.T, linkerdCfg *LinkerdConfig, viz bool, previousOutputs map[string]interface{}) (*pulumitest.Mocks, *Mesh, error) {
+	t.Helper()
+	return runDeployScripts(t, linkerdCfg, viz, previousOutputs, writeScripts(t), nil)
+}
+
+// writeScripts writes stand-ins of the install scripts to a directory
+func writeScripts(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, script := range []string{"install-linkerd.sh", "install-linkerd-viz.sh"} {
+		if err := os.WriteFile(filepath.Join(dir, script), []byte("#!/bin/sh\necho "+script+"\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// runDeployScripts is runDeploy with the install scripts of scriptsDir, into
+// a cluster where existing is installed
+func runDeployScripts(t *testing.T, linkerdCfg *LinkerdConfig, viz bool, previousOutputs map[string]interface{}, scriptsDir string, existing *adopt.Linkerd) (*pulumitest.Mocks, *Mesh, error) {
+	t.Helper()
+	m := &pulumitest.Mocks{Previous: previousOutputs}
+	var mesh *Mesh
+	err := pulumitest.Run(t, "test", nil, m, func(ctx *pulumi.Context) error {
+		previousDeployment, err := previous.New(ctx)
+		if err != nil {
+			return err
+		}
+		cluster, err := local.NewCommand(ctx, "cluster", &local.CommandArgs{Create: pulumi.String("true")})
+		if err != nil {
+			return err
+		}
+		provider, err := kubernetes.NewProvider(ctx, "provider", &kubernetes.ProviderArgs{
+			Kubeconfig: cluster.Stdout,
+		})
+		if err != nil {
+			return err
+		}
+		mesh, err = Deploy(ctx, &Args{
+			Linkerd:        linkerdCfg,
+			Viz:            viz,
+			ClusterName:    "test",
+			ScriptsDir:     scriptsDir,
+			KubeContext:    "kind-test",
+			Kubeconfig:     cluster.Stdout,
+			CLIEnvironment: pulumi.StringMap{"KUBE_CONTEXT": pulumi.String("kind-test")},
+			Timeout:        time.Minute,
+			Retry:          shell.RetryPolicy{Attempts: 1},
+			Provider:       provider,
+			Previous:       previousDeployment,
+			DependsOn:      []pulumi.Resource{provider},
+			Existing:       existing,
+		})
+		return err
+	})
+	return m, mesh, err
+}
+
+// helmConfig installs Linkerd with Helm and generated certificates
+func helmConfig() *LinkerdConfig {
+	return &LinkerdConfig{
+		InstallMethod:       "helm",
+		Version:             "2025.9.2",
+		TrustAnchorValidity: 24 * time.Hour,
+		IssuerValidity:      time.Hour,
+	}
+}
+
+func TestDeployHelm(t *testing.T) {
+	m, mesh, err := runDeploy(t, helmConfig(), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !m.Has("linkerd") || m.Has("linkerd-install") {
+		t.Error("expected the Helm control plane instead of the script")
+	}
+	if !m.DependsOn("linkerd", "cluster") {
+		t.Error("the control plane doesn't wait for the cluster")
+	}
+	if !m.DependsOn("linkerd-viz-install", "linkerd") {
+		t.Error("viz doesn't wait for the control plane")
+	}
+	if got := m.Input(t, "linkerd-viz-install", "create"); !strings.Contains(got, "install-linkerd-viz.sh test") {
+		t.Errorf("viz install doesn't target the cluster:\n%s", got)
+	}
+	for _, output := range []string{IdentityOutput, VersionOutput, ChecksOutput} {
+		if _, ok := mesh.Exports[output]; !ok {
+			t.Errorf("output %s is not exported", output)
+		}
+	}
+}
+
+func TestDeployScript(t *testing.T) {
+	linkerdCfg := helmConfig()
+	linkerdCfg.InstallMethod = "script"
+	linkerdCfg.HA = true
+	m, mesh, err := runDeploy(t, linkerdCfg, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !m.Has("linkerd-install") || m.Has("linkerd") {
+		t.Error("expected the install script instead of the Helm control plane")
+	}
+	if m.Has("linkerd-viz-install") {
+		t.Error("viz was installed while disabled")
+	}
+	create := m.Input(t, "linkerd-install", "create")
+	if !strings.Contains(create, "install-linkerd.sh test") {
+		t.Errorf("install doesn't target the cluster:\n%s", create)
+	}
+	if dir := m.Input(t, "linkerd-install", "dir"); dir == "" || strings.Contains(create, "cd ") {
+		t.Errorf("install doesn't run from the scripts directory (%q):\n%s", dir, create)
+	}
+	if strings.Contains(create, "PRIVATE KEY") {
+		t.Errorf("the issuer key is on the command line:\n%s", create)
+	}
+
+	res, _ := m.Resource("linkerd-install")
+	environment := res.Inputs["environment"].ObjectValue()
+	settings := map[string]string{
+		"KUBE_CONTEXT":    "kind-test",
+		"LINKERD_VERSION": "edge-25.9.2",
+		"LINKERD_HA":      "true",
+		"VIZ_ENABLED":     "false",
+	}
+	for key, want := range settings {
+		if got := environment[resource.PropertyKey(key)]; !got.IsString() || got.StringValue() != want {
+			t.Errorf("environment %s = %v, want %s", key, got, want)
+		}
+	}
+	for _, key := range []string{"LINKERD_TRUST_ANCHOR_PEM", "LINKERD_ISSUER_CERT_PEM", "LINKERD_ISSUER_KEY_PEM"} {
+		if !environment.HasValue(resource.PropertyKey(key)) {
+			t.Errorf("environment %s is not set", key)
+		}
+	}
+	if !environment["LINKERD_ISSUER_KEY_PEM"].IsSecret() {
+		t.Error("the issuer key is passed in plaintext")
+	}
+
+	// The version is that of the Helm charts, the identity is shared
+	for _, output := range []string{IdentityOutput, ChecksOutput} {
+		if _, ok := mesh.Exports[output]; !ok {
+			t.Errorf("output %s is not exported", output)
+		}
+	}
+	if _, ok := mesh.Exports[VersionOutput]; ok {
+		t.Errorf("output %s is exported by the script", VersionOutput)
+	}
+}
+
+func TestDeployScriptTriggers(t *testing.T) {
+	dir := writeScripts(t)
+	checksums := func() map[string]string {
+		t.Helper()
+		m, _, err := runDeployScripts(t, &LinkerdConfig{InstallMethod: "script"}, true, nil, dir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		triggers := map[string]string{}
+		for _, name := range []string{"linkerd-install", "linkerd-viz-install"} {
+			res, _ := m.Resource(name)
+			values := res.Inputs["triggers"]
+			if !values.IsArray() || len(values.ArrayValue()) < 2 {
+				t.Fatalf("triggers of %s = %v, want the checksum of its script and its settings", name, values)
+			}
+			if last := values.ArrayValue()[len(values.ArrayValue())-1]; !strings.HasPrefix(last.StringValue(), "LINKERD_VERSION=") {
+				t.Errorf("triggers of %s end with %v, want a setting", name, last)
+			}
+			for _, value := range values.ArrayValue() {
+				if strings.HasPrefix(value.StringValue(), "VIZ_ENABLED=") {
+					t.Errorf("toggling viz reinstalls %s", name)
+				}
+			}
+			triggers[name] = values.ArrayValue()[0].StringValue()
+			// The environment changing re-runs the script in place
+			if update := m.Input(t, name, "update"); update != m.Input(t, name, "create") {
+				t.Errorf("%s updates with another command:\n%s", name, update)
+			}
+		}
+		return triggers
+	}
+
+	before := checksums()
+	want, err := shell.Checksum(filepath.Join(dir, "install-linkerd.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before["linkerd-install"] != want {
+		t.Errorf("linkerd-install trigger = %s, want %s", before["linkerd-install"], want)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "install-linkerd-viz.sh"), []byte("#!/bin/sh\necho edited\n"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := checksums()
+	if after["linkerd-install"] != before["linkerd-install"] {
+		t.Error("editing the viz script changed the trigger of the control plane")
+	}
+	if after["linkerd-viz-install"] == before["linkerd-viz-install"] {
+		t.Error("editing the viz script didn't change its trigger")
+	}
+
+	// Disabling viz deletes linkerd-viz-install, the control plane keeps
+	// its triggers: the checksum, LINKERD_HA and LINKERD_VERSION
+	m, _, err := runDeployScripts(t, &LinkerdConfig{InstallMethod: "script"}, false, nil, dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, _ := m.Resource("linkerd-install")
+	if got := res.Inputs["triggers"].ArrayValue(); len(got) != 3 || got[0].StringValue() != after["linkerd-install"] {
+		t.Errorf("triggers of linkerd-install without viz = %v", got)
+	}
+}
+
+func TestDeployReusesIdentity(t *testing.T) {
+	identity, err := linkerd.EnsureIdentity(nil, linkerd.IdentityOptions{
+		TrustAnchorValidity: 24 * time.Hour,
+		IssuerValidity:      time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, _, err := runDeploy(t, helmConfig(), false, map[string]interface{}{
+		IdentityOutput: map[string]interface{}{
+			"trustAnchorPEM":    identity.TrustAnchorPEM,
+			"trustAnchorKeyPEM": identity.TrustAnchorKeyPEM,
+			"issuerCertPEM":     identity.IssuerCertPEM,
+			"issuerKeyPEM":      identity.IssuerKeyPEM,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer, ok := m.Resource("linkerd-identity-issuer")
+	if !ok {
+		t.Fatal("the issuer Secret was not registered")
+	}
+	stringData := unsecret(issuer.Inputs["stringData"]).ObjectValue()
+	if got := unsecret(stringData["tls.crt"]).StringValue(); got != identity.IssuerCertPEM {
+		t.Error("the issuer certificate of the previous deployment was not reused")
+	}
+	if got := unsecret(stringData["ca.crt"]).StringValue(); got != identity.TrustAnchorPEM {
+		t.Error("the trust anchor of the previous deployment was not reused")
+	}
+}
+
+func TestDeployUpgrade(t *testing.T) {
+	m, _, err := runDeploy(t, helmConfig(), true, map[string]interface{}{
+		VersionOutput: map[string]interface{}{"chart": "2025.8.1", "running": "edge-25.8.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crds, _ := m.Resource("linkerd-crds")
+	if got := crds.Inputs["version"].StringValue(); got != "2025.9.2" {
+		t.Errorf("linkerd-crds version = %q, want 2025.9.2", got)
+	}
+	if !m.DependsOn("linkerd-control-plane", "linkerd-crds") {
+		t.Error("the control plane is upgraded before the CRDs")
+	}
+	check, ok := m.Resource("linkerd-check")
+	if !ok {
+		t.Fatal("linkerd check was not registered")
+	}
+	if triggers := check.Inputs["triggers"].ArrayValue(); len(triggers) != 1 || triggers[0].StringValue() != "2025.9.2" {
+		t.Errorf("linkerd check is not re-run on version changes: %v", triggers)
+	}
+	if !m.DependsOn("linkerd-check", "linkerd") {
+		t.Error("linkerd check doesn't wait for the control plane")
+	}
+	if !m.DependsOn("linkerd-viz-install", "linkerd-check") {
+		t.Error("viz doesn't wait for linkerd check")
+	}
+}
+
+func TestDeployDowngrade(t *testing.T) {
+	_, _, err := runDeploy(t, helmConfig(), false, map[string]interface{}{
+		VersionOutput: map[string]interface{}{"chart": "2025.10.1", "running": "edge-25.10.1"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "downgrades are not supported") {
+		t.Fatalf("expected an error about the downgrade, got %v", err)
+	}
+}
+
+func TestDeployAdoptsHelmReleases(t *testing.T) {
+	identity, err := linkerd.EnsureIdentity(nil, linkerd.IdentityOptions{
+		TrustAnchorValidity: 24 * time.Hour,
+		IssuerValidity:      time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousOutputs := map[string]interface{}{
+		IdentityOutput: map[string]interface{}{
+			"trustAnchorPEM":    identity.TrustAnchorPEM,
+			"trustAnchorKeyPEM": identity.TrustAnchorKeyPEM,
+			"issuerCertPEM":     identity.IssuerCertPEM,
+			"issuerKeyPEM":      identity.IssuerKeyPEM,
+		},
+	}
+	existing := &adopt.Linkerd{
+		Releases:   map[string]string{linkerd.CRDsRelease: "2025.9.2"},
+		TrustRoots: identity.TrustAnchorPEM,
+	}
+
+	m, _, err := runDeployScripts(t, helmConfig(), false, previousOutputs, writeScripts(t), existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crds, _ := m.Resource(linkerd.CRDsRelease)
+	if crds.ImportID != "linkerd/linkerd-crds" {
+		t.Errorf("linkerd-crds is imported with %q, expected linkerd/linkerd-crds", crds.ImportID)
+	}
+	if controlPlane, _ := m.Resource(linkerd.ControlPlaneRelease); controlPlane.ImportID != "" {
+		t.Errorf("the missing control plane release is imported with %q", controlPlane.ImportID)
+	}
+
+	// The trust anchor of the stack differs from the one of the installation
+	_, _, err = runDeployScripts(t, helmConfig(), false, nil, writeScripts(t), existing)
+	if err == nil || !strings.Contains(err.Error(), "trusts another anchor") {
+		t.Errorf("expected an error about the trust anchor, got %v", err)
+	}
+}
+
+func TestDeployExistingConflicts(t *testing.T) {
+	for name, existing := range map[string]*adopt.Linkerd{
+		"without Helm":  {Releases: map[string]string{}, TrustRoots: "anchor"},
+		"other version": {Releases: map[string]string{linkerd.ControlPlaneRelease: "2025.8.1"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := runDeployScripts(t, helmConfig(), false, nil, writeScripts(t), existing)
+			if err == nil || !strings.Contains(err.Error(), "already installed") {
+				t.Errorf("expected an error about the existing installation, got %v", err)
+			}
+		})
+	}
+
+	// The script leaves an existing installation in place
+	linkerdCfg := helmConfig()
+	linkerdCfg.InstallMethod = "script"
+	_, _, err := runDeployScripts(t, linkerdCfg, false, nil, writeScripts(t), &adopt.Linkerd{TrustRoots: "anchor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func unsecret(value resource.PropertyValue) resource.PropertyValue {
+	if value.IsSecret() {
+		return value.SecretValue().Element
+	}
+	return value
+}
@@ -0,0 +1,80 @@
+package mesh
+
+import (
+	"context"
+	"fmt"
+
+	"cluster-studio/internal/previous"
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/linkerd"
+	"cluster-studio/pkg/shell"
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// VersionOutput is the stack output holding the configured chart version of
+// Linkerd and the version the control plane runs
+const VersionOutput = "linkerdVersion"
+
+// installedVersion is the VersionOutput of a deployment
+type installedVersion struct {
+	Chart   string `json:"chart"`
+	Running string `json:"running"`
+}
+
+// checkUpgrade rejects a version older than the one of the previous
+// deployment: the Linkerd charts only support upgrades in place
+func checkUpgrade(ctx *pulumi.Context, previousDeploy *previous.Deployment, version string) error {
+	var installed installedVersion
+	found, err := previousDeploy.Output(VersionOutput, &installed)
+	if err != nil {
+		return err
+	}
+	if !found || installed.Chart == "" {
+		return nil
+	}
+
+	cmp, err := linkerd.CompareVersions(version, installed.Chart)
+	if err != nil {
+		return err
+	}
+	switch {
+	case cmp < 0:
+		return fmt.Errorf("Linkerd %s is older than the installed %s: downgrades are not supported in place, "+
+			"keep %[2]s or uninstall Linkerd first and install %[1]s again", version, installed.Chart)
+	case cmp > 0:
+		_ = ctx.Log.Info(fmt.Sprintf("upgrading Linkerd from %s to %s", installed.Chart, version), nil)
+	}
+	return nil
+}
+
+// verifyControlPlane runs `linkerd check` once the control plane is installed
+// and again whenever version changes, so an upgrade only completes once the
+// upgraded control plane is healthy. The returned version is the one the
+// control plane runs, read from the cluster after the check.
+func verifyControlPlane(ctx *pulumi.Context, args *Args, controlPlane pulumi.Resource, version string) (*local.Command, pulumi.StringOutput, error) {
+	check, err := local.NewCommand(ctx, stacks.Name(ctx, "linkerd-check"), &local.CommandArgs{
+		Create: pulumi.String(guardLinkerd("linkerd check",
+			fmt.Sprintf("linkerd check --context %s --wait %s", args.KubeContext, args.Timeout), args.KubeContext, linkerd.Namespace, args.Timeout, args.Retry)),
+		Environment: args.CLIEnvironment,
+		Triggers:    pulumi.Array{pulumi.String(version)},
+		Interpreter: shell.Interpreter(),
+	}, pulumi.DependsOn([]pulumi.Resource{controlPlane}))
+	if err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+
+	running := pulumi.All(check.Stdout, args.Kubeconfig).ApplyT(func(values []interface{}) (string, error) {
+		if ctx.DryRun() {
+			return "", nil
+		}
+		client, err := kube.NewClientsetFromKubeconfig(values[1].(string))
+		if err != nil {
+			return "", err
+		}
+		return linkerd.RunningVersion(context.Background(), client)
+	}).(pulumi.StringOutput)
+	return check, running, nil
+}
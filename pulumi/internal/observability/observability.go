@@ -0,0 +1,151 @@
+package observability
+
+import (
+	"errors"
+	"time"
+
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/monitoring"
+	"cluster-studio/pkg/stacks"
+	"cluster-studio/pkg/uptimekuma"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// BlackboxConfig describes the blackbox exporter probing the external
+// hostnames
+type BlackboxConfig struct {
+	// Chart version
+	Version string
+	Probes  []monitoring.Probe
+}
+
+// UptimeKumaConfig describes the Uptime Kuma status page
+type UptimeKumaConfig struct {
+	// Image tag
+	Version string
+	// Public hostname routed to it through the tunnel (optional)
+	Hostname string
+	// Entry of home:persistentVolumes holding the data (optional)
+	Volume string
+	// Size of the data claim
+	StorageSize string
+}
+
+// Args configures Deploy
+type Args struct {
+	// Blackbox exporter, nil when disabled
+	Blackbox *BlackboxConfig
+	// Uptime Kuma, nil when disabled
+	UptimeKuma *UptimeKumaConfig
+	// StorageClass of the persistent volumes, for the data of Uptime Kuma
+	// when UptimeKuma.Volume is set
+	StorageClass pulumi.StringInput
+	// Scrape and alert on the platform components when Prometheus is
+	// deployed
+	PlatformMonitors bool
+	// Platform components deployed, the probes are set from Blackbox
+	Targets monitoring.PlatformTargets
+	// Thresholds of the platform alerts
+	Thresholds monitoring.AlertThresholds
+	// Kubeconfig of the cluster (secret), used to wait for the operator CRDs
+	Kubeconfig pulumi.StringInput
+	// How long to wait for the releases and the operator CRDs
+	Timeout time.Duration
+	// Provider of the cluster
+	Provider *kubernetes.Provider
+	// Prometheus of the monitoring stack or of the infrastructure, empty
+	// when neither is deployed
+	Prometheus []pulumi.Resource
+	// Resources the components are installed after
+	DependsOn []pulumi.Resource
+	// Resources deployed so far, the platform monitors are created after
+	// them so they find the Services they select
+	Deployed []pulumi.Resource
+}
+
+// Observability is the result of Deploy
+type Observability struct {
+	// Components installed, what the teardown waits for
+	Resources []pulumi.Resource
+	// Services of the web UIs, for the service URLs
+	Services []kube.ServiceRef
+	// Stack outputs of the components
+	Exports pulumi.Map
+}
+
+// Deploy installs the blackbox exporter, the monitors and alerts of the
+// platform components, both for the Prometheus in Args.Prometheus, and
+// Uptime Kuma
+func Deploy(ctx *pulumi.Context, args *Args) (*Observability, error) {
+	result := &Observability{Exports: pulumi.Map{}}
+	opts := []pulumi.ResourceOption{pulumi.Providers(args.Provider)}
+
+	// Uptime Kuma, its data on a host directory when home:uptimeKumaVolume
+	// is set so the monitors survive recreating the cluster
+	if kumaCfg := args.UptimeKuma; kumaCfg != nil {
+		kumaArgs := &uptimekuma.Args{
+			Version:     kumaCfg.Version,
+			StorageSize: kumaCfg.StorageSize,
+		}
+		if kumaCfg.Volume != "" {
+			kumaArgs.VolumeName = kumaCfg.Volume
+			kumaArgs.StorageClass = args.StorageClass
+		}
+		kuma, err := uptimekuma.New(ctx, stacks.Name(ctx, "uptime-kuma"), kumaArgs, append(opts, pulumi.DependsOn(args.DependsOn))...)
+		if err != nil {
+			return nil, err
+		}
+		result.Resources = append(result.Resources, kuma)
+		result.Services = append(result.Services, kube.ServiceRef{Name: "uptimeKuma", Namespace: uptimekuma.Namespace, Service: uptimekuma.ServiceName, Port: uptimekuma.Port})
+		exports := pulumi.Map{"url": kuma.URL}
+		if kumaCfg.Hostname != "" {
+			exports["publicUrl"] = pulumi.String("https://" + kumaCfg.Hostname)
+		}
+		result.Exports["uptimeKuma"] = exports
+	}
+
+	// Probes of the external hostnames, scraped by that Prometheus
+	if args.Blackbox != nil {
+		if len(args.Prometheus) == 0 {
+			return nil, errors.New("home:enableBlackboxExporter requires home:enableMonitoring or the prometheus-operator component of the infrastructure")
+		}
+		blackbox, err := monitoring.NewBlackbox(ctx, stacks.Name(ctx, "blackbox-exporter"), &monitoring.BlackboxArgs{
+			Version:    args.Blackbox.Version,
+			Probes:     args.Blackbox.Probes,
+			Kubeconfig: args.Kubeconfig,
+			Timeout:    args.Timeout,
+		}, append(opts, pulumi.DependsOn(append(append([]pulumi.Resource{}, args.DependsOn...), args.Prometheus...)))...)
+		if err != nil {
+			return nil, err
+		}
+		result.Resources = append(result.Resources, blackbox)
+		result.Exports["blackboxProbes"] = blackbox.Probes
+	}
+
+	if !args.PlatformMonitors || len(args.Prometheus) == 0 {
+		return result, nil
+	}
+	targets := args.Targets
+	targets.Probes = args.Blackbox != nil && len(args.Blackbox.Probes) > 0
+	if !targets.Flux && !targets.Linkerd && !targets.Tunnel && !targets.ExternalDNS && !targets.Probes {
+		return result, nil
+	}
+	monitorDeps := append(append(append(append([]pulumi.Resource{}, args.Deployed...), result.Resources...), args.DependsOn...), args.Prometheus...)
+	platformMonitors, err := monitoring.NewPlatformMonitors(ctx, stacks.Name(ctx, "platform-monitors"), &monitoring.PlatformMonitorsArgs{
+		Targets:    targets,
+		Thresholds: args.Thresholds,
+		Kubeconfig: args.Kubeconfig,
+		Timeout:    args.Timeout,
+	}, append(opts, pulumi.DependsOn(monitorDeps))...)
+	if err != nil {
+		return nil, err
+	}
+	result.Resources = append(result.Resources, platformMonitors)
+	result.Exports["platformMonitors"] = pulumi.Map{
+		"monitors": platformMonitors.Monitors,
+		"rules":    platformMonitors.Rules,
+	}
+	return result, nil
+}
@@ -0,0 +1,172 @@
+package observability
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"cluster-studio/internal/pulumitest"
+	"cluster-studio/pkg/monitoring"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// runDeploy deploys with mocks after the provider of a "cluster" command,
+// the resources of args.Prometheus, args.DependsOn and args.Deployed being
+// the commands "prometheus", "platform" and "deployed"
+func runDeploy(t *testing.T, args *Args, prometheus bool) (*pulumitest.Mocks, *Observability, error) {
+	t.Helper()
+	m := &pulumitest.Mocks{}
+	var result *Observability
+	err := pulumitest.Run(t, "test", nil, m, func(ctx *pulumi.Context) error {
+		cluster, err := local.NewCommand(ctx, "cluster", &local.CommandArgs{Create: pulumi.String("true")})
+		if err != nil {
+			return err
+		}
+		provider, err := kubernetes.NewProvider(ctx, "provider", &kubernetes.ProviderArgs{
+			Kubeconfig: cluster.Stdout,
+		})
+		if err != nil {
+			return err
+		}
+		commands := map[string]pulumi.Resource{}
+		for _, name := range []string{"prometheus", "platform", "deployed"} {
+			commands[name], err = local.NewCommand(ctx, name, &local.CommandArgs{Create: pulumi.String("true")}, pulumi.DependsOn([]pulumi.Resource{cluster}))
+			if err != nil {
+				return err
+			}
+		}
+		if prometheus {
+			args.Prometheus = []pulumi.Resource{commands["prometheus"]}
+		}
+		args.DependsOn = []pulumi.Resource{commands["platform"]}
+		args.Deployed = []pulumi.Resource{commands["deployed"]}
+		args.Kubeconfig = cluster.Stdout
+		args.Timeout = time.Minute
+		args.Provider = provider
+		result, err = Deploy(ctx, args)
+		return err
+	})
+	return m, result, err
+}
+
+func TestDeployBlackbox(t *testing.T) {
+	blackbox := &BlackboxConfig{
+		Version: "11.3.1",
+		Probes:  []monitoring.Probe{{Name: "lucena-cloud", URL: "https://lucena.cloud", Module: monitoring.ModuleHTTP, Interval: time.Minute}},
+	}
+	m, result, err := runDeploy(t, &Args{Blackbox: blackbox, PlatformMonitors: true}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dep := range []string{"prometheus", "platform"} {
+		if !m.DependsOn("blackbox-exporter", dep) {
+			t.Errorf("the blackbox exporter is installed before %s", dep)
+		}
+	}
+	if !m.Has("blackbox-exporter-lucena-cloud") {
+		t.Error("the Probe was not created")
+	}
+	// The probes alone are worth a PrometheusRule
+	if !m.DependsOn("platform-monitors", "blackbox-exporter") {
+		t.Error("the monitors are created before the blackbox exporter")
+	}
+	if _, ok := result.Exports["blackboxProbes"]; !ok {
+		t.Error("output blackboxProbes is not exported")
+	}
+	if len(result.Resources) != 2 {
+		t.Errorf("got %d resources, want the blackbox exporter and the monitors", len(result.Resources))
+	}
+
+	_, _, err = runDeploy(t, &Args{Blackbox: blackbox}, false)
+	if err == nil || !strings.Contains(err.Error(), "home:enableBlackboxExporter requires home:enableMonitoring") {
+		t.Errorf("expected an error about the missing Prometheus, got %v", err)
+	}
+}
+
+func TestDeployPlatformMonitors(t *testing.T) {
+	targets := monitoring.PlatformTargets{Flux: true, FluxNamespace: "flux-system"}
+	m, result, err := runDeploy(t, &Args{PlatformMonitors: true, Targets: targets}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Has("platform-monitors-flux-system") {
+		t.Error("the Flux controllers are not monitored")
+	}
+	for _, dep := range []string{"prometheus", "platform", "deployed"} {
+		if !m.DependsOn("platform-monitors", dep) {
+			t.Errorf("the monitors are created before %s", dep)
+		}
+	}
+	if _, ok := result.Exports["platformMonitors"]; !ok {
+		t.Error("the monitors are not exported")
+	}
+
+	for _, tc := range []struct {
+		name       string
+		args       *Args
+		prometheus bool
+	}{
+		{"without Prometheus", &Args{PlatformMonitors: true, Targets: targets}, false},
+		{"disabled", &Args{Targets: targets}, true},
+		{"without targets", &Args{PlatformMonitors: true}, true},
+	} {
+		m, result, err := runDeploy(t, tc.args, tc.prometheus)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if m.Has("platform-monitors") || len(result.Resources) > 0 {
+			t.Errorf("%s: monitors are created", tc.name)
+		}
+	}
+}
+
+func TestDeployUptimeKuma(t *testing.T) {
+	m, result, err := runDeploy(t, &Args{
+		UptimeKuma:   &UptimeKumaConfig{Version: "1.23.16", Hostname: "status.lucena.cloud", Volume: "uptime-kuma", StorageSize: "2Gi"},
+		StorageClass: pulumi.String("persistent"),
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.DependsOn("uptime-kuma", "platform") {
+		t.Error("Uptime Kuma is installed before the platform")
+	}
+	data, ok := m.Resource("uptime-kuma-data")
+	if !ok {
+		t.Fatal("the data claim was not created")
+	}
+	claim := data.Inputs["spec"].ObjectValue()
+	if got := claim["volumeName"].StringValue(); got != "uptime-kuma" {
+		t.Errorf("the data claim binds to %q, want uptime-kuma", got)
+	}
+	if got := claim["storageClassName"].StringValue(); got != "persistent" {
+		t.Errorf("the data claim uses StorageClass %q, want persistent", got)
+	}
+	if len(result.Services) != 1 || result.Services[0].Name != "uptimeKuma" {
+		t.Errorf("got services %v, want uptimeKuma", result.Services)
+	}
+	exports, _ := result.Exports["uptimeKuma"].(pulumi.Map)
+	if got, _ := exports["publicUrl"].(pulumi.String); got != "https://status.lucena.cloud" {
+		t.Errorf("got publicUrl %q, want https://status.lucena.cloud", got)
+	}
+
+	// Without a volume the claim uses the default StorageClass
+	m, _, err = runDeploy(t, &Args{
+		UptimeKuma:   &UptimeKumaConfig{Version: "1.23.16", StorageSize: "1Gi"},
+		StorageClass: pulumi.String("persistent"),
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = m.Resource("uptime-kuma-data")
+	claim = data.Inputs["spec"].ObjectValue()
+	if claim["volumeName"].IsString() || claim["storageClassName"].IsString() {
+		t.Errorf("the data claim is pinned without a volume: %v", claim)
+	}
+	if m.Has("blackbox-exporter") || m.Has("platform-monitors") {
+		t.Error("only Uptime Kuma is enabled")
+	}
+}
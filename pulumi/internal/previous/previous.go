@@ -0,0 +1,87 @@
+package previous
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Deployment reads the outputs exported by the last deployment of this
+// stack. Generated credentials (certificates, keys) are exported and read back
+// here so they stay stable across updates.
+type Deployment struct {
+	ref *pulumi.StackReference
+	// Output of an umbrella stack holding the outputs of one of its members,
+	// empty for a stack deploying its own cluster
+	member string
+}
+
+// New references the last deployment of the current stack, or of the member
+// ctx deploys
+func New(ctx *pulumi.Context) (*Deployment, error) {
+	ref, err := pulumi.NewStackReference(ctx, stacks.Name(ctx, "previous-deployment"), &pulumi.StackReferenceArgs{
+		Name: pulumi.String(fmt.Sprintf("%s/%s/%s", ctx.Organization(), ctx.Project(), ctx.Stack())),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Deployment{ref: ref, member: stacks.Prefix(ctx)}, nil
+}
+
+// NewPeer references the last deployment of another stack, given as
+// organization/project/stack or as the name of a stack of the current project.
+// The peer of a member of an umbrella stack may be another member.
+func NewPeer(ctx *pulumi.Context, stack string) (*Deployment, error) {
+	var member string
+	if m := stacks.MemberOf(ctx); m != nil && slices.Contains(m.Siblings, stack) {
+		member, stack = stack, ctx.Stack()
+	}
+	if !strings.Contains(stack, "/") {
+		stack = fmt.Sprintf("%s/%s/%s", ctx.Organization(), ctx.Project(), stack)
+	}
+	ref, err := pulumi.NewStackReference(ctx, stacks.Name(ctx, "peer-stack"), &pulumi.StackReferenceArgs{
+		Name: pulumi.String(stack),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Deployment{ref: ref, member: member}, nil
+}
+
+// Output decodes the named output into v. It returns false when the previous
+// deployment did not export it (e.g. on the first deployment).
+func (p *Deployment) Output(name string, v interface{}) (bool, error) {
+	output := name
+	if p.member != "" {
+		output = p.member
+	}
+	details, err := p.ref.GetOutputDetails(output)
+	if err != nil {
+		return false, err
+	}
+	value := details.SecretValue
+	if value == nil {
+		value = details.Value
+	}
+	if p.member != "" {
+		outputs, _ := value.(map[string]interface{})
+		value = outputs[name]
+	}
+	if value == nil {
+		return false, nil
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return false, fmt.Errorf("invalid %s output of the previous deployment: %w", name, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,203 @@
+package pulumitest
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// Project is the Pulumi project the programs under test run in
+	Project = "homelab"
+	// ConfigNamespace is the namespace of config keys given without one
+	ConfigNamespace = "home"
+)
+
+// Resource is a resource registered by the program under test
+type Resource struct {
+	Type   string
+	Inputs resource.PropertyMap
+	// Name of the parent component, empty for top-level resources
+	Parent string
+	// Names of the resources it depends on, explicitly or through its inputs.
+	// A dependency on a component is recorded as one on its children.
+	Deps []string
+	// ID the resource is imported with, empty when it is created
+	ImportID string
+	// Whether it is left in place when deleted
+	RetainOnDelete bool
+}
+
+// Mocks records every registered resource instead of creating it
+type Mocks struct {
+	// Outputs of the previous deployment, read through stack references
+	Previous map[string]interface{}
+	// Outputs of other stacks read through stack references, by
+	// organization/project/stack
+	Peers map[string]map[string]interface{}
+
+	mu        sync.Mutex
+	resources map[string]*Resource
+}
+
+func (m *Mocks) NewResource(args pulumi.MockResourceArgs) (string, resource.PropertyMap, error) {
+	outputs := args.Inputs.Copy()
+	switch args.TypeToken {
+	case "pulumi:pulumi:StackReference":
+		stackOutputs := m.Previous
+		if peer, ok := m.Peers[args.Inputs["name"].StringValue()]; ok {
+			stackOutputs = peer
+		}
+		outputs["outputs"] = resource.NewObjectProperty(resource.NewPropertyMapFromMap(stackOutputs))
+	case "command:local:Command":
+		outputs["stdout"] = resource.NewStringProperty("")
+	case "kubernetes:helm.sh/v3:Release":
+		outputs["status"] = resource.NewObjectProperty(resource.PropertyMap{"status": resource.NewStringProperty("deployed")})
+	case "homekind:index:Cluster":
+		outputs["kubeconfig"] = resource.MakeSecret(resource.NewStringProperty(""))
+	case "random:index/randomPassword:RandomPassword":
+		outputs["result"] = resource.MakeSecret(resource.NewStringProperty("password"))
+		outputs["bcryptHash"] = resource.MakeSecret(resource.NewStringProperty("$2a$10$hash"))
+	case "random:index/randomString:RandomString":
+		outputs["result"] = resource.NewStringProperty("random")
+	}
+
+	var parent, importID string
+	var deps []string
+	var retain bool
+	if args.RegisterRPC != nil {
+		parent = urnName(args.RegisterRPC.GetParent())
+		importID, retain = args.RegisterRPC.GetImportId(), args.RegisterRPC.GetRetainOnDelete()
+		urns := append([]string{}, args.RegisterRPC.GetDependencies()...)
+		for _, property := range args.RegisterRPC.GetPropertyDependencies() {
+			urns = append(urns, property.GetUrns()...)
+		}
+		for _, urn := range urns {
+			deps = append(deps, urnName(urn))
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.resources == nil {
+		m.resources = map[string]*Resource{}
+	}
+	m.resources[args.Name] = &Resource{
+		Type: args.TypeToken, Inputs: args.Inputs, Parent: parent, Deps: deps, ImportID: importID, RetainOnDelete: retain,
+	}
+	return args.Name + "_id", outputs, nil
+}
+
+func (m *Mocks) Call(args pulumi.MockCallArgs) (resource.PropertyMap, error) {
+	// The kustomize directories are rendered by the provider, pretend they are empty
+	if args.Token == "kubernetes:kustomize:directory" {
+		return resource.PropertyMap{"result": resource.NewArrayProperty(nil)}, nil
+	}
+	return args.Args, nil
+}
+
+// urnName returns the resource name part of a URN
+func urnName(urn string) string {
+	if urn == "" {
+		return ""
+	}
+	return urn[strings.LastIndex(urn, "::")+2:]
+}
+
+// Has reports whether a resource with this name was registered
+func (m *Mocks) Has(name string) bool {
+	_, ok := m.Resource(name)
+	return ok
+}
+
+// Resource returns the registered resource with this name
+func (m *Mocks) Resource(name string) (*Resource, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res, ok := m.resources[name]
+	return res, ok
+}
+
+// Input returns a string input of a registered resource
+func (m *Mocks) Input(t *testing.T, name, key string) string {
+	t.Helper()
+	res, ok := m.Resource(name)
+	if !ok {
+		t.Fatalf("resource %s was not registered", name)
+	}
+	value := res.Inputs[resource.PropertyKey(key)]
+	if value.IsSecret() {
+		value = value.SecretValue().Element
+	}
+	if !value.IsString() {
+		t.Fatalf("input %s of %s is not a string: %v", key, name, value)
+	}
+	return value.StringValue()
+}
+
+// DependsOn reports whether resource name is created after dep: it depends
+// on dep (or one of its children), directly or through other resources and
+// the components containing them
+func (m *Mocks) DependsOn(name, dep string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seen := map[string]bool{name: true}
+	queue := []string{name}
+	for len(queue) > 0 {
+		res, ok := m.resources[queue[0]]
+		queue = queue[1:]
+		if !ok {
+			continue
+		}
+		for _, d := range append([]string{res.Parent}, res.Deps...) {
+			if d == "" || seen[d] {
+				continue
+			}
+			if d == dep || m.isChild(d, dep) {
+				return true
+			}
+			seen[d] = true
+			queue = append(queue, d)
+		}
+	}
+	return false
+}
+
+// isChild reports whether resource name is contained in component parent.
+// Resources are recorded by name, so a release named like its component
+// looks like its own parent: each name is visited once.
+func (m *Mocks) isChild(name, parent string) bool {
+	seen := map[string]bool{name: true}
+	for res, ok := m.resources[name]; ok && res.Parent != "" && !seen[res.Parent]; res, ok = m.resources[res.Parent] {
+		if res.Parent == parent {
+			return true
+		}
+		seen[res.Parent] = true
+	}
+	return false
+}
+
+// Run runs program as a preview of stack with mocks. Config keys without a
+// namespace are in the home namespace (e.g. "enableFlux").
+func Run(t *testing.T, stack string, config map[string]string, mocks pulumi.MockResourceMonitor, program pulumi.RunFunc) error {
+	t.Helper()
+	values := map[string]string{}
+	for key, value := range config {
+		if !strings.Contains(key, ":") {
+			key = ConfigNamespace + ":" + key
+		}
+		values[key] = value
+	}
+	raw, err := json.Marshal(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PULUMI_CONFIG", string(raw))
+	t.Setenv("PULUMI_DRY_RUN", "true")
+
+	return pulumi.RunErr(program, pulumi.WithMocks(Project, stack, mocks))
+}
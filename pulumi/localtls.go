@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"cluster-studio/internal/previous"
+	"cluster-studio/pkg/localtls"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// localTLSOutput is the stack output holding the local CA and certificate
+	localTLSOutput = "localTls"
+	// localCACertOutput is the stack output with the PEM of the local CA, to
+	// trust on the devices
+	localCACertOutput = "localCaCert"
+	// localTLSSecret is the TLS Secret of ingress-nginx's default certificate
+	localTLSSecret = "local-tls"
+)
+
+// loadLocalCertificates returns the local CA and wildcard certificate to
+// install. Those of the previous deployment of this stack are reused, so the
+// certificate is only renewed within tlsCfg.RenewBefore of its expiry; see
+// localtls.Ensure.
+func loadLocalCertificates(ctx *pulumi.Context, tlsCfg *LocalTLSConfig, previousDeploy *previous.Deployment) (*localtls.Certificates, error) {
+	previous := &localtls.Certificates{}
+	found, err := previousDeploy.Output(localTLSOutput, previous)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		previous = nil
+	}
+
+	// An imported CA replaces whatever was generated before
+	if tlsCfg.CAPEM != "" {
+		if previous == nil || previous.CAPEM != tlsCfg.CAPEM {
+			previous = &localtls.Certificates{}
+		}
+		previous.CAPEM = tlsCfg.CAPEM
+		previous.CAKeyPEM = tlsCfg.CAKeyPEM
+	}
+
+	certs, err := localtls.Ensure(previous, localtls.Options{
+		Domain:       tlsCfg.Domain,
+		CAValidity:   tlsCfg.CAValidity,
+		CertValidity: tlsCfg.CertValidity,
+		RenewBefore:  tlsCfg.RenewBefore,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare the local TLS certificate: %w", err)
+	}
+
+	if previous != nil && previous.CertPEM != "" && previous.CertPEM != certs.CertPEM {
+		expiry, err := localtls.NotAfter(certs.CertPEM)
+		if err != nil {
+			return nil, err
+		}
+		_ = ctx.Log.Info(fmt.Sprintf("local TLS certificate of *.%s renewed, valid until %s", tlsCfg.Domain, expiry.Format(time.DateOnly)), nil)
+	}
+	if previous != nil && previous.CAPEM != "" && previous.CAPEM != certs.CAPEM {
+		_ = ctx.Log.Warn(fmt.Sprintf("local CA regenerated, trust the new %s output on your devices", localCACertOutput), nil)
+	}
+
+	return certs, nil
+}
@@ -1,109 +1,1664 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cluster-studio/internal/adopt"
+	clusterpkg "cluster-studio/internal/cluster"
+	"cluster-studio/internal/gitops"
+	"cluster-studio/internal/infra"
+	"cluster-studio/internal/mesh"
+	"cluster-studio/internal/observability"
+	"cluster-studio/internal/previous"
+	"cluster-studio/pkg/certmanager"
+	"cluster-studio/pkg/ciaccess"
+	"cluster-studio/pkg/cloudflare"
+	"cluster-studio/pkg/cnpg"
+	"cluster-studio/pkg/coredns"
+	"cluster-studio/pkg/credentials"
+	"cluster-studio/pkg/externaldns"
+	"cluster-studio/pkg/externalsecrets"
+	"cluster-studio/pkg/flagger"
+	fluxpkg "cluster-studio/pkg/flux"
+	"cluster-studio/pkg/grafana"
+	"cluster-studio/pkg/ingress"
+	"cluster-studio/pkg/istio"
+	"cluster-studio/pkg/keda"
+	"cluster-studio/pkg/kind"
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/kyverno"
+	"cluster-studio/pkg/linkerd"
+	"cluster-studio/pkg/localtls"
+	"cluster-studio/pkg/logging"
+	metallbpkg "cluster-studio/pkg/metallb"
+	"cluster-studio/pkg/metricsserver"
+	"cluster-studio/pkg/minio"
+	"cluster-studio/pkg/monitoring"
+	"cluster-studio/pkg/nvidia"
+	"cluster-studio/pkg/portforward"
+	"cluster-studio/pkg/preflight"
+	"cluster-studio/pkg/sealedsecrets"
+	"cluster-studio/pkg/shell"
+	"cluster-studio/pkg/stacks"
+	"cluster-studio/pkg/tracing"
+	"cluster-studio/pkg/ttl"
+	"cluster-studio/pkg/uptimekuma"
+	"cluster-studio/pkg/velero"
 
-	"github.com/pulumi/pulumi-command/sdk/go/command/local"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/kustomize"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
+// scriptsDir holds the install scripts run by commands, relative to the project
+const scriptsDir = "../scripts"
+
 func main() {
-	pulumi.Run(func(ctx *pulumi.Context) error {
-		// Get stack configuration
-		stack := ctx.Stack()
-
-		// Stack-specific configurations
-		var clusterName string
-		var clusterConfigFile string
-
-		switch stack {
-		case "studio":
-			clusterName = "studio"
-			clusterConfigFile = "../flux/clusters/studio/kind.yaml"
-		case "homelab":
-			clusterName = "homelab"
-			clusterConfigFile = "../flux/clusters/homelab/kind.yaml"
-		default:
-			return fmt.Errorf("unsupported stack: %s. Use 'studio' or 'homelab'", stack)
-		}
-
-		// Create Kind cluster using Pulumi command provider (with cleanup)
-		cluster, err := local.NewCommand(ctx, fmt.Sprintf("create-kind-cluster-%s", clusterName), &local.CommandArgs{
-			Create: pulumi.String(fmt.Sprintf("kind delete cluster --name %s 2>/dev/null || true && kind create cluster --name %s --config %s && kind export kubeconfig --name %s", clusterName, clusterName, clusterConfigFile, clusterName)),
-			Delete: pulumi.String(fmt.Sprintf("kind delete cluster --name %s 2>/dev/null || true", clusterName)),
-		})
+	// The program doubles as the supervisor of the port forwards
+	if len(os.Args) > 1 && os.Args[1] == portforward.Command {
+		if err := portforward.Main(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// The notification needs the outcome of the whole update, resources
+	// included, which RunErr returns
+	started := time.Now()
+	var notification *deployNotification
+	program := func(ctx *pulumi.Context) error {
+		notifyCfg, err := loadNotifyConfig(ctx)
 		if err != nil {
 			return err
 		}
+		if notifyCfg != nil && !ctx.DryRun() {
+			notification = &deployNotification{config: notifyCfg, stack: ctx.Stack(), step: "config"}
+		}
 
-		// Create Kubernetes provider using the Kind cluster
-		k8sProvider, err := kubernetes.NewProvider(ctx, fmt.Sprintf("%s-provider", clusterName), &kubernetes.ProviderArgs{
-			Kubeconfig: pulumi.String("~/.kube/config"),
-			Context:    pulumi.String(fmt.Sprintf("kind-%s", clusterName)),
-		}, pulumi.DependsOn([]pulumi.Resource{cluster}))
+		cfg, err := loadConfig(ctx)
 		if err != nil {
 			return err
 		}
 
-		// Wait for cluster to be ready using a simple command
-		waitForCluster, err := local.NewCommand(ctx, "wait-for-cluster", &local.CommandArgs{
-			Create: pulumi.String(fmt.Sprintf("kubectl --context kind-%s wait --for=condition=Ready nodes --all --timeout=300s", clusterName)),
-		}, pulumi.DependsOn([]pulumi.Resource{cluster}))
-		if err != nil {
+		// Fail early, before any resource is created, if tools are missing
+		notification.at("preflight")
+		if err := runPreflight(ctx, cfg); err != nil {
+			return err
+		}
+		notification.at("validation")
+		if err := runValidation(ctx, cfg); err != nil {
 			return err
 		}
 
-		// Install Flux controllers only (without GitRepository creation)
-		flux, err := local.NewCommand(ctx, "install-flux", &local.CommandArgs{
-			Create: pulumi.String(fmt.Sprintf("flux install --context kind-%s", clusterName)),
-		}, pulumi.DependsOn([]pulumi.Resource{waitForCluster}))
+		notification.at("")
+		exports, err := deploy(ctx, cfg)
 		if err != nil {
 			return err
 		}
+		notification.changedIn(exports)
+		for name, value := range exports {
+			ctx.Export(name, value)
+		}
+		return nil
+	}
+
+	err := pulumi.RunErr(program)
+	if errors.Is(err, pulumi.ErrPlugins) {
+		// Listing the plugins, which Run handles
+		pulumi.Run(program)
+		return
+	}
+	notification.send(err, time.Since(started))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: program failed: %v\n", err)
+		os.Exit(1)
+	}
+}
 
-		// Create namespaces first
-		_, err = local.NewCommand(ctx, "create-namespace", &local.CommandArgs{
-			Create: pulumi.String(fmt.Sprintf(`kubectl --context kind-%s create namespace cloudflare-ddns --dry-run=client -o yaml | kubectl apply -f - && \
-kubectl --context kind-%s create namespace external-dns --dry-run=client -o yaml | kubectl apply -f -`,
-				clusterName, clusterName)),
-		}, pulumi.DependsOn([]pulumi.Resource{flux}))
+// deploy creates the cluster and everything enabled in cfg on it, or the
+// clusters of an umbrella stack, and returns the stack outputs
+func deploy(ctx *pulumi.Context, cfg Config) (pulumi.Map, error) {
+	if cfg.Members != nil {
+		return deployMembers(ctx, cfg.Members)
+	}
+
+	clusterCfg := cfg.Cluster
+	clusterName := clusterCfg.Name
+	timeouts, retry := cfg.Timeouts, cfg.Retry
+	fluxCfg, gitCfg, linkerdCfg := cfg.Flux, cfg.Git, cfg.Linkerd
+	metallbCfg, ingressCfg, certManagerCfg := cfg.MetalLB, cfg.Ingress, cfg.CertManager
+	monitoringCfg, loggingCfg, flaggerCfg := cfg.Monitoring, cfg.Logging, cfg.Flagger
+	minioCfg, veleroCfg, postgresCfg := cfg.MinIO, cfg.Velero, cfg.Postgres
+	sealedSecretsCfg, externalSecretsCfg, kyvernoCfg := cfg.SealedSecrets, cfg.ExternalSecrets, cfg.Kyverno
+	istioCfg := cfg.Istio
+	components := cfg.Components
+	tunnelCfg, ddnsCfg, externalDNSCfg := cfg.Tunnel, cfg.DDNS, cfg.ExternalDNS
+	namespacesCfg := cfg.Namespaces
+	exports := pulumi.Map{}
+
+	previousDeployment, err := previous.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Registry mirrors, Prometheus and Loki of the peer stack, and its cluster
+	// when linking to it
+	peer := &peerOutputs{}
+	if clusterCfg.PeerStack != "" {
+		linkPeer := cfg.LinkerdMulticluster != nil && cfg.LinkerdMulticluster.Link
+		if peer, err = loadPeerOutputs(ctx, clusterCfg.PeerStack, linkPeer); err != nil {
+			return nil, err
+		}
+	}
+
+	// Leave in-cluster objects alone on destroy, deleting the cluster is enough
+	if clusterCfg.FastDestroy {
+		if err := registerFastDestroy(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	// Create the cluster with the configured backend (or use the existing
+	// one) and wait for its nodes to be Ready
+	cluster, err := clusterpkg.Deploy(ctx, &clusterpkg.Args{
+		Config:           &clusterCfg.Config,
+		CreateTimeout:    timeouts.ClusterCreate,
+		NodeReadyTimeout: timeouts.NodeReady,
+		Previous:         previousDeployment,
+		DependsOn:        memberDependencies(ctx),
+	})
+	if err != nil {
+		return nil, err
+	}
+	kubeContext := cluster.KubeContext
+	addExports(exports, cluster.Exports)
+	if cluster.Generation > 0 {
+		if err := registerClusterGeneration(ctx, cluster.Generation); err != nil {
+			return nil, err
+		}
+	}
+	// Take over what someone else already installed into the cluster
+	adoptedNamespaces, existingLinkerd, err := adoptExisting(ctx, cfg, cluster, previousDeployment)
+	if err != nil {
+		return nil, err
+	}
+	// Step -> output of the commands provisioning it, see provisionLogs
+	provisionOutputs := map[string]*shell.Output{}
+	if cluster.Output != nil {
+		provisionOutputs["cluster"] = cluster.Output
+	}
+	// Kubeconfig for kubectl on the other machines of the LAN, whose API
+	// server the CI and viewer kubeconfigs point to as well
+	var lanServer string
+	if clusterCfg.LANAddress != "" {
+		lanServer = fmt.Sprintf("https://%s", net.JoinHostPort(clusterCfg.LANAddress, strconv.Itoa(clusterCfg.Kind.APIServerPort)))
+		exports["lanKubeconfig"] = pulumi.ToSecret(cluster.Kubeconfig.ApplyT(func(kubeconfig string) (string, error) {
+			return kube.WithServer(kubeconfig, lanServer)
+		})).(pulumi.StringOutput)
+	}
+
+	// Environment for the CLIs (kubectl, flux, linkerd) run by commands
+	cliEnvironment := pulumi.StringMap{
+		"KUBE_CONTEXT": pulumi.String(kubeContext),
+	}
+	if cluster.KubeconfigPath != "" {
+		cliEnvironment["KUBECONFIG"] = pulumi.String(cluster.KubeconfigPath)
+	}
+
+	// Create Kubernetes provider using the cluster. The resources of a created
+	// cluster that is gone are dropped from the state by `pulumi refresh`.
+	k8sProvider, err := kubernetes.NewProvider(ctx, stacks.Name(ctx, fmt.Sprintf("%s-provider", clusterName)), &kubernetes.ProviderArgs{
+		Kubeconfig:        cluster.Kubeconfig,
+		Context:           cluster.Context,
+		DeleteUnreachable: pulumi.Bool(clusterCfg.Provision),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Per-step versions, objects and durations, exported as "summary"
+	summary := newDeploySummary(ctx, cluster.Kubeconfig)
+
+	// The local registry and mirrors configure the Kind nodes directly
+	if cluster.Kind == nil && (components.LocalRegistry || len(clusterCfg.RegistryMirrors) > 0 || clusterCfg.PullThroughCache) {
+		return nil, fmt.Errorf("%[1]s:enableLocalRegistry, %[1]s:registryMirrors and %[1]s:pullThroughCache require %[1]s:clusterBackend=kind",
+			configNamespace)
+	}
+
+	// Local registry the nodes pull from as localhost:5001
+	var registry *kind.Registry
+	if components.LocalRegistry {
+		registry, err = kind.NewRegistry(ctx, stacks.Name(ctx, "local-registry"), &kind.RegistryArgs{
+			Cluster: cluster.Kind,
+		}, pulumi.Providers(k8sProvider))
 		if err != nil {
-			return err
+			return nil, err
 		}
+		exports["localRegistry"] = registry.Endpoint
+	}
 
-		// Install Linkerd before infrastructure deployment
-		linkerdInstall, err := local.NewCommand(ctx, "linkerd-install", &local.CommandArgs{
-			Create: pulumi.String(fmt.Sprintf("cd ../scripts && ./install-linkerd.sh %s", clusterName)),
-		}, pulumi.DependsOn([]pulumi.Resource{flux}))
+	// Pull images through mirrors to avoid Docker Hub rate limits
+	registryMirrors := clusterCfg.RegistryMirrors
+	if len(peer.RegistryMirrors) > 0 {
+		if cluster.Kind == nil {
+			_ = ctx.Log.Warn(fmt.Sprintf("ignoring the registry mirrors of peer stack %s, they require %s:clusterBackend=kind",
+				clusterCfg.PeerStack, configNamespace), nil)
+		} else {
+			registryMirrors = peerMirrors(ctx, peer, clusterCfg)
+		}
+	}
+	if len(registryMirrors) > 0 || clusterCfg.PullThroughCache {
+		mirrors, err := kind.NewMirrors(ctx, stacks.Name(ctx, "registry-mirrors"), &kind.MirrorsArgs{
+			Cluster:          cluster.Kind,
+			Mirrors:          registryMirrors,
+			PullThroughCache: clusterCfg.PullThroughCache,
+		})
 		if err != nil {
-			return err
+			return nil, err
 		}
+		exports["registryMirrors"] = mirrors.Mirrors
+	}
+
+	// Each platform step waits for the previous enabled one
+	platformDeps := []pulumi.Resource{k8sProvider}
+	// In-cluster resources outside of that chain, removed after Flux on destroy
+	teardownDeps := []pulumi.Resource{}
+	// Infrastructure components installed by the program instead
+	var skipInfra []string
+	// Objects of the infrastructure tree installed by the program instead
+	var excludeInfra []string
 
-		// Install Linkerd Viz
-		linkerdViz, err := local.NewCommand(ctx, "linkerd-viz-install", &local.CommandArgs{
-			Create: pulumi.String(fmt.Sprintf("cd ../scripts && ./install-linkerd-viz.sh %s", clusterName)),
-		}, pulumi.DependsOn([]pulumi.Resource{linkerdInstall}))
+	// Images built from the repository, pushed to the local registry when
+	// there is one, substituted into the manifests applied by the program
+	var imageBuilds []pulumi.Resource
+	var localImages map[string]string
+	if len(cfg.LocalImages) > 0 {
+		var endpoint string
+		var buildOpts []pulumi.ResourceOption
+		if registry != nil {
+			endpoint = kind.RegistryEndpoint(0)
+			buildOpts = append(buildOpts, pulumi.DependsOn([]pulumi.Resource{registry}))
+		}
+		localImages = make(map[string]string, len(cfg.LocalImages))
+		refs := pulumi.StringMap{}
+		for _, image := range cfg.LocalImages {
+			build, err := kind.NewImageBuild(ctx, stacks.Name(ctx, fmt.Sprintf("local-image-%s", strings.ReplaceAll(image.Name, "/", "-"))), &kind.ImageBuildArgs{
+				Cluster:  cluster.Kind,
+				Image:    image,
+				Registry: endpoint,
+			}, buildOpts...)
+			if err != nil {
+				return nil, err
+			}
+			imageBuilds = append(imageBuilds, build)
+			localImages[image.Name] = build.Image
+			refs[image.Name] = build.Ref
+		}
+		exports["localImages"] = refs
+	}
+	// The infrastructure and the extra directories wait for the images too
+	appDeps := func() []pulumi.Resource {
+		return append(append([]pulumi.Resource{}, platformDeps...), imageBuilds...)
+	}
+
+	// Images loaded into the nodes, so the platform starts without pulling them
+	if preloadCfg := cfg.Preload; preloadCfg != nil {
+		if images := preloadImages(ctx, preloadCfg, clusterCfg); len(images) > 0 {
+			preload, err := kind.NewPreload(ctx, stacks.Name(ctx, "preload-images"), &kind.PreloadArgs{
+				Cluster:     cluster.Kind,
+				Images:      images,
+				Concurrency: preloadCfg.Concurrency,
+			})
+			if err != nil {
+				return nil, err
+			}
+			platformDeps = append(platformDeps, preload)
+			exports["preloadedImages"] = pulumi.Map{
+				"images":  pulumi.Int(len(images)),
+				"loaded":  preload.Loaded,
+				"failed":  preload.Failed,
+				"seconds": preload.Seconds,
+			}
+		}
+	}
+
+	// NVIDIA runtime on the GPU node and the device plugin, checked before
+	// anything else is installed
+	if clusterCfg.GPU {
+		runtime, err := kind.NewGPU(ctx, stacks.Name(ctx, "gpu-runtime"), &kind.GPUArgs{
+			Cluster: cluster.Kind,
+		})
 		if err != nil {
-			return err
+			return nil, err
+		}
+		gpu, err := nvidia.NewDevicePlugin(ctx, stacks.Name(ctx, "gpu"), &nvidia.DevicePluginArgs{
+			Version:    clusterCfg.GPUDevicePluginVersion,
+			Kubeconfig: cluster.Kubeconfig,
+			Timeout:    timeouts.GPUCheck,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(append(platformDeps, runtime)))
+		if err != nil {
+			return nil, err
 		}
+		platformDeps = []pulumi.Resource{gpu}
+		exports["gpuAllocatable"] = gpu.Allocatable
+	}
 
-		// Deploy infrastructure components using Kustomize from actual YAML files
-		infrastructureResources, err := kustomize.NewDirectory(ctx, "infrastructure-resources", kustomize.DirectoryArgs{
-			Directory: pulumi.String(fmt.Sprintf("../flux/clusters/%s/infrastructure", clusterName)),
-		}, pulumi.Provider(k8sProvider), pulumi.DependsOn([]pulumi.Resource{linkerdViz}))
+	// Volumes backed by host directories, so their data outlives the cluster
+	var persistentStorage *kind.Storage
+	if len(clusterCfg.PersistentVolumes) > 0 {
+		storage, err := kind.NewStorage(ctx, stacks.Name(ctx, "persistent-storage"), &kind.StorageArgs{
+			ClassName: clusterCfg.StorageClass,
+			Volumes:   clusterCfg.PersistentVolumes,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
 		if err != nil {
-			return err
+			return nil, err
 		}
+		platformDeps = []pulumi.Resource{storage}
+		persistentStorage = storage
+		exports["storageClass"] = storage.ClassName
+	}
 
-		// Export cluster information
-		ctx.Export("clusterName", pulumi.String(clusterName))
-		ctx.Export("fluxInstanceDeployed", pulumi.String("deployed"))
-		ctx.Export("linkerdInstalled", pulumi.String("installed"))
-		ctx.Export("linkerdVizInstalled", pulumi.String("installed"))
-		ctx.Export("infrastructureResources", infrastructureResources.Resources)
+	// Resolve the public names of the services to the services themselves,
+	// instead of a round trip through the tunnel
+	if cfg.DNSRewrites != nil {
+		rewrites, err := coredns.NewRewrites(ctx, stacks.Name(ctx, "coredns-rewrites"), &coredns.RewritesArgs{
+			Hosts:      cfg.DNSRewrites,
+			Kubeconfig: cluster.Kubeconfig,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		platformDeps = []pulumi.Resource{rewrites}
+		exports["dnsRewrites"] = rewrites.Rewrites
+	}
 
-		return nil
-	})
+	// Check the cluster meets the requirements of Flux and Linkerd before
+	// installing anything on it
+	if cfg.ClusterChecks.Enabled && (components.Flux || components.Linkerd) {
+		checks, err := preflight.NewClusterChecks(ctx, stacks.Name(ctx, "cluster-checks"), &preflight.ClusterChecksArgs{
+			Flux:           components.Flux,
+			Linkerd:        components.Linkerd,
+			KubeContext:    kubeContext,
+			KubeconfigPath: cluster.KubeconfigPath,
+			Kubeconfig:     cluster.Kubeconfig,
+			Warnings:       cfg.ClusterChecks.Warnings,
+			Timeout:        timeouts.ClusterChecks,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		platformDeps = []pulumi.Resource{checks}
+	}
+
+	// Metrics before the infrastructure, whose HorizontalPodAutoscalers
+	// scale on them
+	if components.MetricsServer {
+		metricsServer, err := metricsserver.NewInstall(ctx, stacks.Name(ctx, "metrics-server"), &metricsserver.InstallArgs{
+			Version:     cfg.MetricsServer.Version,
+			InsecureTLS: cfg.MetricsServer.InsecureTLS,
+			Kubeconfig:  cluster.Kubeconfig,
+			Timeout:     cfg.MetricsServer.Timeout,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		platformDeps = []pulumi.Resource{metricsServer}
+		exports["metricsAvailable"] = metricsServer.Available
+		skipInfra = append(skipInfra, "metrics-server")
+	}
+
+	// KEDA before the infrastructure, its ScaledObjects once the workloads
+	// they scale exist
+	var kedaInstall *keda.Install
+	if components.KEDA {
+		kedaInstall, err = keda.NewInstall(ctx, stacks.Name(ctx, "keda"), &keda.InstallArgs{
+			Version:    cfg.KEDA.Version,
+			Kubeconfig: cluster.Kubeconfig,
+			Timeout:    cfg.KEDA.Timeout,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		platformDeps = []pulumi.Resource{kedaInstall}
+	}
+
+	// Monitoring first, to be able to debug Flux itself
+	var monitoringStack *monitoring.Stack
+	if peer.RemoteWrite != "" && !components.Monitoring {
+		_ = ctx.Log.Warn(fmt.Sprintf("ignoring the Prometheus of peer stack %s, %s:enableMonitoring is off", clusterCfg.PeerStack, configNamespace), nil)
+	}
+	if components.Monitoring {
+		stackArgs := &monitoring.StackArgs{
+			Version:             monitoringCfg.Version,
+			Retention:           monitoringCfg.Retention,
+			StorageSize:         monitoringCfg.StorageSize,
+			Persistence:         monitoringCfg.Persistence,
+			RemoteWriteNodePort: monitoringCfg.RemoteWriteNodePort,
+			// The dashboards come with every datasource
+			ProvisionedDatasources: cfg.GrafanaDashboards != nil,
+			Rotate:                 cfg.Rotate,
+			Timeout:                monitoringCfg.Timeout,
+		}
+		if peer.RemoteWrite != "" {
+			stackArgs.RemoteWrite = pulumi.String(peer.RemoteWrite)
+		}
+		stack, err := monitoring.NewStack(ctx, stacks.Name(ctx, "monitoring"), stackArgs, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		platformDeps = []pulumi.Resource{stack}
+		monitoringStack = stack
+		exports["grafana"] = pulumi.Map{
+			"url":      stack.GrafanaURL,
+			"username": stack.GrafanaUser,
+			"password": stack.GrafanaPassword,
+		}
+		skipInfra = append(skipInfra, "prometheus-operator")
+	}
+
+	// In-cluster S3 for the components storing objects, Loki and Tempo
+	// included
+	var objectStore *minio.Install
+	if components.MinIO {
+		buckets := append([]string{}, minioCfg.Buckets...)
+		if components.Logging && !slices.Contains(buckets, lokiBucket) {
+			buckets = append(buckets, lokiBucket)
+		}
+		if components.Tracing && !slices.Contains(buckets, tempoBucket) {
+			buckets = append(buckets, tempoBucket)
+		}
+		if components.Velero && veleroCfg.S3URL == "" && !slices.Contains(buckets, veleroCfg.Bucket) {
+			buckets = append(buckets, veleroCfg.Bucket)
+		}
+		objectStore, err = minio.NewInstall(ctx, stacks.Name(ctx, "minio"), &minio.InstallArgs{
+			Version:     minioCfg.Version,
+			Buckets:     buckets,
+			StorageSize: minioCfg.StorageSize,
+			Rotate:      cfg.Rotate,
+			Timeout:     minioCfg.Timeout,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		platformDeps = []pulumi.Resource{objectStore}
+		exports["minio"] = pulumi.Map{
+			"endpoint":  objectStore.Endpoint,
+			"accessKey": objectStore.AccessKey,
+			"secretKey": objectStore.SecretKey,
+			"buckets":   objectStore.Buckets,
+		}
+	}
+
+	// Backups of the PVs, restored before anything else uses them
+	if components.Velero {
+		storage := velero.ObjectStorage{
+			Endpoint:  pulumi.String(veleroCfg.S3URL),
+			Region:    veleroCfg.S3Region,
+			Bucket:    veleroCfg.Bucket,
+			AccessKey: veleroCfg.AccessKey,
+			SecretKey: veleroCfg.SecretKey,
+		}
+		if veleroCfg.S3URL == "" {
+			storage.Endpoint = objectStore.Endpoint
+			storage.AccessKey = objectStore.AccessKey
+			storage.SecretKey = objectStore.SecretKey
+		}
+		var schedule *velero.ScheduleArgs
+		if len(veleroCfg.Namespaces) > 0 {
+			schedule = &velero.ScheduleArgs{
+				Cron:       veleroCfg.Schedule,
+				Namespaces: veleroCfg.Namespaces,
+				TTL:        veleroCfg.TTL,
+			}
+		}
+		backups, err := velero.NewInstall(ctx, stacks.Name(ctx, "velero"), &velero.InstallArgs{
+			Version:        veleroCfg.Version,
+			Storage:        storage,
+			Schedule:       schedule,
+			RestoreFrom:    veleroCfg.RestoreFrom,
+			Kubeconfig:     cluster.Kubeconfig,
+			Timeout:        veleroCfg.Timeout,
+			RestoreTimeout: timeouts.VeleroRestore,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		platformDeps = []pulumi.Resource{backups}
+		exports["veleroBackupStorageLocation"] = backups.StorageLocation
+	}
+
+	// Logs next, so the ones of a failing Flux reconcile survive pod restarts
+	var lokiPushURL pulumi.StringOutput
+	if peer.LokiPush != "" && !components.Logging {
+		_ = ctx.Log.Warn(fmt.Sprintf("ignoring the Loki of peer stack %s, %s:enableLogging is off", clusterCfg.PeerStack, configNamespace), nil)
+	}
+	if components.Logging {
+		var objectStorage *logging.ObjectStorage
+		if objectStore != nil {
+			objectStorage = &logging.ObjectStorage{
+				Endpoint:  objectStore.Endpoint,
+				Bucket:    lokiBucket,
+				AccessKey: objectStore.AccessKey,
+				SecretKey: objectStore.SecretKey,
+			}
+		}
+		stackArgs := &logging.StackArgs{
+			LokiVersion:       loggingCfg.LokiVersion,
+			PromtailVersion:   loggingCfg.PromtailVersion,
+			Retention:         loggingCfg.Retention,
+			StorageSize:       loggingCfg.StorageSize,
+			Persistence:       loggingCfg.Persistence,
+			NodePort:          loggingCfg.NodePort,
+			GrafanaDatasource: components.Monitoring && cfg.GrafanaDashboards == nil,
+			ObjectStorage:     objectStorage,
+			Timeout:           loggingCfg.Timeout,
+		}
+		if peer.LokiPush != "" {
+			stackArgs.PeerPushURL = pulumi.String(peer.LokiPush)
+		}
+		stack, err := logging.NewStack(ctx, stacks.Name(ctx, "logging"), stackArgs, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		platformDeps = []pulumi.Resource{stack}
+		lokiPushURL = stack.PushURL
+		skipInfra = append(skipInfra, "loki")
+	}
+
+	// Traces, before the mesh extensions and collectors sending spans to it
+	var tempo *tracing.Tempo
+	if tracingCfg := cfg.Tracing; components.Tracing {
+		var objectStorage *tracing.ObjectStorage
+		if objectStore != nil {
+			objectStorage = &tracing.ObjectStorage{
+				Endpoint:  objectStore.Endpoint,
+				Bucket:    tempoBucket,
+				AccessKey: objectStore.AccessKey,
+				SecretKey: objectStore.SecretKey,
+			}
+		}
+		tempo, err = tracing.NewTempo(ctx, stacks.Name(ctx, "tracing"), &tracing.TempoArgs{
+			Version:           tracingCfg.Version,
+			Retention:         tracingCfg.Retention,
+			Receivers:         tracingCfg.Receivers,
+			Persistence:       tracingCfg.Persistence,
+			StorageSize:       tracingCfg.StorageSize,
+			GrafanaDatasource: components.Monitoring && cfg.GrafanaDashboards == nil,
+			ObjectStorage:     objectStorage,
+			Kubeconfig:        cluster.Kubeconfig,
+			Timeout:           tracingCfg.Timeout,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		platformDeps = []pulumi.Resource{tempo}
+		exports["tracing"] = pulumi.Map{
+			"url":              tempo.URL,
+			"otlpEndpoint":     tempo.OTLPEndpoint,
+			"otlpHttpEndpoint": tempo.OTLPHTTPEndpoint,
+		}
+		skipInfra = append(skipInfra, "tempo")
+	}
+
+	// Sealed Secrets before Flux and the infrastructure, so the controller
+	// is there when their SealedSecrets are applied
+	if components.SealedSecrets {
+		controllerArgs := &sealedsecrets.ControllerArgs{
+			Version:    sealedSecretsCfg.Version,
+			Kubeconfig: cluster.Kubeconfig,
+			Timeout:    sealedSecretsCfg.Timeout,
+		}
+		if sealedSecretsCfg.KeyPair {
+			controllerArgs.KeyPair = &sealedsecrets.KeyPair{
+				Certificate: sealedSecretsCfg.Certificate,
+				PrivateKey:  sealedSecretsCfg.PrivateKey,
+			}
+		}
+		controller, err := sealedsecrets.NewController(ctx, stacks.Name(ctx, "sealed-secrets"), controllerArgs,
+			pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		platformDeps = []pulumi.Resource{controller}
+		exports["sealedSecretsCertificate"] = controller.Certificate
+		excludeInfra = append(excludeInfra, "HelmRelease/flux-system/sealed-secrets")
+	}
+
+	// External Secrets before Flux and the infrastructure, so their
+	// ExternalSecrets find the operator
+	var secretStore pulumi.StringInput
+	if components.ExternalSecrets {
+		operator, err := externalsecrets.NewOperator(ctx, stacks.Name(ctx, "external-secrets"), &externalsecrets.OperatorArgs{
+			Version:    externalSecretsCfg.Version,
+			Kubeconfig: cluster.Kubeconfig,
+			Timeout:    externalSecretsCfg.Timeout,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		platformDeps = []pulumi.Resource{operator}
+		secretStore = operator.Store
+	}
+
+	// Kyverno before Flux and the infrastructure, so the objects they apply
+	// are admitted by the policies
+	if components.Kyverno {
+		policies, err := kyverno.NewInstall(ctx, stacks.Name(ctx, "kyverno"), &kyverno.InstallArgs{
+			Version:    kyvernoCfg.Version,
+			Policies:   kyvernoCfg.Policies,
+			Kubeconfig: cluster.Kubeconfig,
+			Timeout:    kyvernoCfg.Timeout,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		platformDeps = []pulumi.Resource{policies}
+		exports["kyvernoPolicies"] = policies.Policies
+	}
+
+	// Postgres before Flux and the infrastructure, whose apps may use it
+	if components.Postgres {
+		postgres, err := cnpg.NewPostgres(ctx, stacks.Name(ctx, "postgres"), &cnpg.PostgresArgs{
+			Version:      postgresCfg.Version,
+			Namespace:    postgresCfg.Namespace,
+			Name:         postgresCfg.Name,
+			Instances:    postgresCfg.Instances,
+			StorageSize:  postgresCfg.StorageSize,
+			StorageClass: postgresCfg.StorageClass,
+			Database:     postgresCfg.Database,
+			Owner:        postgresCfg.Owner,
+			Kubeconfig:   cluster.Kubeconfig,
+			Rotate:       cfg.Rotate,
+			Timeout:      postgresCfg.Timeout,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		platformDeps = []pulumi.Resource{postgres}
+		exports["postgres"] = pulumi.Map{
+			"readWriteHost":     postgres.ReadWriteHost,
+			"readOnlyHost":      postgres.ReadOnlyHost,
+			"database":          pulumi.String(postgresCfg.Database),
+			"owner":             pulumi.String(postgresCfg.Owner),
+			"ownerSecret":       postgres.OwnerSecret,
+			"superuserPassword": postgres.SuperuserPassword,
+		}
+	}
+
+	// Install pinned Flux controllers, or let flux bootstrap manage Flux from Git
+	if components.Flux {
+		flux, err := gitops.Deploy(ctx, &gitops.Args{
+			Flux:           fluxCfg,
+			Git:            gitCfg,
+			ClusterName:    clusterName,
+			KubeContext:    kubeContext,
+			KubeconfigPath: cluster.KubeconfigPath,
+			Timeout:        timeouts.FluxInstall,
+			Retry:          *retry,
+			Provider:       k8sProvider,
+			Previous:       previousDeployment,
+			DependsOn:      platformDeps,
+		})
+		if err != nil {
+			return nil, err
+		}
+		platformDeps = []pulumi.Resource{flux.Resource}
+		summary.installed("flux", flux.Version, fluxSelector)
+		addExports(exports, flux.Exports)
+		provisionOutputs["flux"] = flux.Output
+	}
+
+	// Push reconcile failures to a chat, from the notification-controller
+	if alertsCfg := cfg.FluxAlerts; alertsCfg.Type != "" {
+		alertsArgs := &fluxpkg.AlertsArgs{
+			Type:       alertsCfg.Type,
+			Channel:    alertsCfg.Channel,
+			Severity:   alertsCfg.Severity,
+			Namespaces: alertsCfg.Namespaces,
+		}
+		if alertsCfg.Type == fluxpkg.AlertTelegram {
+			alertsArgs.Token = alertsCfg.Token
+		} else {
+			alertsArgs.Address = alertsCfg.Address
+		}
+		alerts, err := fluxpkg.NewAlerts(ctx, stacks.Name(ctx, "flux-alerts"), alertsArgs, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		teardownDeps = append(teardownDeps, alerts)
+	}
+
+	// Bump the tags of images in the repository as new ones are pushed
+	if automationCfg := cfg.FluxImageAutomation; len(automationCfg.Images) > 0 {
+		automation, err := fluxpkg.NewImageAutomation(ctx, stacks.Name(ctx, "flux-image-automation"), &fluxpkg.ImageAutomationArgs{
+			Images:      automationCfg.Images,
+			URL:         automationCfg.URL,
+			Branch:      automationCfg.Branch,
+			Username:    automationCfg.Username,
+			Token:       automationCfg.Token,
+			AuthorName:  automationCfg.AuthorName,
+			AuthorEmail: automationCfg.AuthorEmail,
+			Interval:    automationCfg.Interval,
+			Kubeconfig:  cluster.Kubeconfig,
+			Timeout:     timeouts.FluxInstall,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		teardownDeps = append(teardownDeps, automation)
+		exports["fluxImageAutomation"] = pulumi.Map{
+			"images": automation.Images,
+			"status": automation.Status,
+		}
+	}
+
+	// Web UI of Flux, found by the service URL discovery
+	serviceURLs := cfg.ServiceURLs
+	var fluxUIReady pulumi.Output
+	if uiCfg := cfg.FluxUI; components.FluxUI {
+		ui, err := fluxpkg.NewUI(ctx, stacks.Name(ctx, "flux-ui"), &fluxpkg.UIArgs{
+			UI:         uiCfg.UI,
+			Version:    uiCfg.Version,
+			Host:       uiCfg.Host,
+			Rotate:     cfg.Rotate,
+			Kubeconfig: cluster.Kubeconfig,
+			Timeout:    timeouts.FluxInstall,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		teardownDeps = append(teardownDeps, ui)
+		fluxUIReady = ui.Version
+		service, port := fluxpkg.UIService(uiCfg.UI)
+		serviceURLs = append(serviceURLs, kube.ServiceRef{Name: "fluxUI", Namespace: fluxpkg.Namespace, Service: service, Port: int32(port)})
+		fluxUI := pulumi.Map{"ui": pulumi.String(uiCfg.UI), "version": ui.Version}
+		if uiCfg.UI == fluxpkg.UIWeaveGitOps {
+			fluxUI["adminUser"] = ui.AdminUser
+			fluxUI["adminPassword"] = ui.AdminPassword
+		}
+		exports["fluxUI"] = fluxUI
+	}
+
+	// Create namespaces first
+	namespaces, err := deployNamespaces(ctx, namespacesCfg, clusterCfg.ResourceLabels, k8sProvider)
+	if err != nil {
+		return nil, err
+	}
+	for _, namespace := range namespaces {
+		teardownDeps = append(teardownDeps, namespace)
+	}
+
+	// Isolate the namespaces created above
+	if netpolCfg := cfg.NetworkPolicies; netpolCfg.Enabled {
+		policies, err := deployNetworkPolicies(ctx, netpolCfg.Namespaces, netpolCfg, cfg.Mesh, k8sProvider, func(namespace string) []pulumi.Resource {
+			return []pulumi.Resource{namespaces[namespace]}
+		})
+		if err != nil {
+			return nil, err
+		}
+		teardownDeps = append(teardownDeps, policies...)
+		exports["networkPolicies"] = pulumi.ToStringArray(append(append([]string{}, netpolCfg.Namespaces...), netpolCfg.Extra...))
+	}
+
+	// Scoped kubeconfigs for CI, e.g. to run kubectl diff from GitHub Actions
+	if len(cfg.CIAccounts) > 0 {
+		accountDeps := append([]pulumi.Resource{}, platformDeps...)
+		for _, namespace := range namespaces {
+			accountDeps = append(accountDeps, namespace)
+		}
+		accounts, err := ciaccess.New(ctx, stacks.Name(ctx, "ci-access"), &ciaccess.Args{
+			Accounts:    cfg.CIAccounts,
+			ClusterName: clusterName,
+			Server:      lanServer,
+			Kubeconfig:  cluster.Kubeconfig,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(accountDeps))
+		if err != nil {
+			return nil, err
+		}
+		teardownDeps = append(teardownDeps, accounts)
+		exports["ciKubeconfigs"] = accounts.Kubeconfigs
+	}
+
+	// Read-only kubeconfig for the machines showing dashboards
+	if viewerCfg := cfg.Viewer; viewerCfg != nil {
+		viewerDeps := append([]pulumi.Resource{}, platformDeps...)
+		for _, namespace := range viewerCfg.Namespaces {
+			if created, ok := namespaces[namespace]; ok {
+				viewerDeps = append(viewerDeps, created)
+			}
+		}
+		viewer, err := ciaccess.New(ctx, stacks.Name(ctx, "viewer-access"), &ciaccess.Args{
+			Accounts: []ciaccess.Account{{
+				Name:        "viewer",
+				ClusterRole: "view",
+				Namespaces:  viewerCfg.Namespaces,
+				Rotation:    viewerCfg.Rotation,
+			}},
+			Namespace:   "viewer-access",
+			ClusterName: clusterName,
+			Server:      lanServer,
+			Kubeconfig:  cluster.Kubeconfig,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(viewerDeps))
+		if err != nil {
+			return nil, err
+		}
+		teardownDeps = append(teardownDeps, viewer)
+		exports["viewerKubeconfig"] = viewer.Kubeconfigs.MapIndex(pulumi.String("viewer"))
+	}
+
+	// Keep the public DNS records pointed at this network
+	if ddnsCfg.Enabled {
+		ddns, err := cloudflare.NewDDNS(ctx, stacks.Name(ctx, "cloudflare-ddns"), &cloudflare.DDNSArgs{
+			Token:   ddnsCfg.Token,
+			Zone:    ddnsCfg.Zone,
+			Records: ddnsCfg.Records,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn([]pulumi.Resource{namespaces[cloudflare.DDNSNamespace]}))
+		if err != nil {
+			return nil, err
+		}
+		teardownDeps = append(teardownDeps, ddns)
+		exports["cloudflareDdnsRecords"] = ddns.Records
+	}
+
+	// Secrets from config, in their namespaces before the infrastructure
+	// using them is applied
+	if len(externalSecretsCfg.Secrets) > 0 {
+		namespaceDeps := map[string][]pulumi.Resource{}
+		for name, namespace := range namespaces {
+			namespaceDeps[name] = []pulumi.Resource{namespace}
+		}
+		managed, err := externalsecrets.NewManagedSecrets(ctx, stacks.Name(ctx, "managed-secrets"), &externalsecrets.ManagedSecretsArgs{
+			Secrets:       externalSecretsCfg.Secrets,
+			Store:         secretStore,
+			NamespaceDeps: namespaceDeps,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		platformDeps = []pulumi.Resource{managed}
+		teardownDeps = append(teardownDeps, managed)
+		exports["managedSecrets"] = managed.Names
+	}
+
+	// DNS records for the Services and Ingresses of the cluster
+	if components.ExternalDNS {
+		externalDNS, err := externaldns.New(ctx, stacks.Name(ctx, "external-dns"), &externaldns.Args{
+			Version:       externalDNSCfg.Version,
+			Token:         externalDNSCfg.Token,
+			DomainFilters: externalDNSCfg.DomainFilters,
+			TXTOwnerID:    externalDNSCfg.TXTOwnerID,
+			Policy:        externalDNSCfg.Policy,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn([]pulumi.Resource{k8sProvider}))
+		if err != nil {
+			return nil, err
+		}
+		teardownDeps = append(teardownDeps, externalDNS)
+		exports["externalDns"] = pulumi.Map{
+			"txtOwnerId":    externalDNS.TXTOwnerID,
+			"domainFilters": externalDNS.DomainFilters,
+		}
+	}
+
+	// Install the mesh before infrastructure deployment. Switching home:mesh
+	// removes the resources of the previous mesh from the program, so the
+	// plan deletes one mesh and creates the other.
+	if err := warnMeshSwitch(ctx, previousDeployment, cfg.Mesh); err != nil {
+		return nil, err
+	}
+	exports["mesh"] = pulumi.String(cfg.Mesh)
+	if peer.Link != nil {
+		if err := sharePeerTrustAnchor(ctx, linkerdCfg, peer.Link, clusterCfg.PeerStack); err != nil {
+			return nil, err
+		}
+	}
+	if components.Linkerd {
+		linkerd, err := mesh.Deploy(ctx, &mesh.Args{
+			Linkerd:        linkerdCfg,
+			Viz:            components.LinkerdViz,
+			ClusterName:    clusterName,
+			ScriptsDir:     scriptsDir,
+			KubeContext:    kubeContext,
+			Kubeconfig:     cluster.Kubeconfig,
+			KubeconfigPath: cluster.KubeconfigPath,
+			CLIEnvironment: cliEnvironment,
+			Timeout:        timeouts.LinkerdInstall,
+			CheckTimeout:   timeouts.LinkerdCheck,
+			Retry:          *retry,
+			Provider:       k8sProvider,
+			Previous:       previousDeployment,
+			DependsOn:      platformDeps,
+			Existing:       existingLinkerd,
+		})
+		if err != nil {
+			return nil, err
+		}
+		platformDeps = []pulumi.Resource{linkerd.Resource}
+		summary.installed("linkerd", linkerd.Version, linkerdSelector)
+		if components.LinkerdViz {
+			summary.installed("linkerdViz", linkerd.VizVersion, linkerdVizSelector)
+		}
+		addExports(exports, linkerd.Exports)
+		for step, output := range linkerd.Outputs {
+			provisionOutputs[step] = output
+		}
+	}
+	if components.Istio {
+		istioMesh, err := istio.NewMesh(ctx, stacks.Name(ctx, "istio"), &istio.MeshArgs{
+			Version: istioCfg.Version,
+			Values:  istioCfg.Values,
+			Gateway: istioCfg.Gateway,
+			Timeout: istioCfg.Timeout,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		platformDeps = []pulumi.Resource{istioMesh}
+		summary.installed("istio", istioMesh.Version, istioSelector)
+	}
+
+	// cert-manager and its issuers must exist before any Certificate is applied
+	if components.CertManager {
+		certManager, err := certmanager.NewInstall(ctx, stacks.Name(ctx, "cert-manager"), &certmanager.InstallArgs{
+			Version:            certManagerCfg.Version,
+			CloudflareAPIToken: certManagerCfg.CloudflareAPIToken,
+			ACMEEmail:          certManagerCfg.ACMEEmail,
+			ACMEServer:         certManagerCfg.ACMEServer,
+			Kubeconfig:         cluster.Kubeconfig,
+			Timeout:            certManagerCfg.Timeout,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		platformDeps = []pulumi.Resource{certManager}
+		exports["clusterIssuers"] = certManager.Issuers
+
+		// The infrastructure copy would be a second owner of the same release
+		skipInfra = append(skipInfra, "cert-manager")
+	}
+
+	// Flagger drives its canaries through the mesh, so it comes after it
+	if components.Flagger {
+		flaggerInstall, err := flagger.NewInstall(ctx, stacks.Name(ctx, "flagger"), &flagger.InstallArgs{
+			Version:           flaggerCfg.Version,
+			LoadtesterVersion: flaggerCfg.LoadtesterVersion,
+			MeshProvider:      flaggerCfg.MeshProvider,
+			MetricsServer:     flaggerCfg.MetricsServer,
+			Loadtester:        flaggerCfg.Loadtester,
+			Analysis:          flaggerCfg.Analysis,
+			Canaries:          flaggerCfg.Canaries,
+			Kubeconfig:        cluster.Kubeconfig,
+			Timeout:           flaggerCfg.Timeout,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		platformDeps = []pulumi.Resource{flaggerInstall}
+		exports["flagger"] = pulumi.StringMap{
+			"version":       flaggerInstall.Version,
+			"loadtesterUrl": flaggerInstall.LoadtesterURL,
+		}
+		// Verification only, nothing depends on it
+		if flaggerCfg.Demo != nil {
+			exports["canaryDemo"] = runCanaryDemo(ctx, cluster.Kubeconfig, flaggerInstall, flaggerCfg)
+		}
+	}
+
+	// cloudflared with the tunnel token from config instead of a hand-made Secret
+	if components.CloudflareTunnel {
+		tunnelArgs := &cloudflare.TunnelArgs{
+			TunnelName: tunnelCfg.Name,
+			Token:      tunnelCfg.Token,
+			Replicas:   tunnelCfg.Replicas,
+			Image:      tunnelCfg.Image,
+			Routes:     tunnelCfg.Routes,
+			Kubeconfig: cluster.Kubeconfig,
+			Timeout:    tunnelCfg.Timeout,
+		}
+		if len(tunnelCfg.Routes) > 0 {
+			tunnelArgs.KnownServices = renderedServices(ctx, clusterCfg, tunnelCfg.Routes)
+		}
+		if components.UptimeKuma {
+			tunnelArgs.KnownServices = append(tunnelArgs.KnownServices, uptimekuma.Namespace+"/"+uptimekuma.ServiceName)
+		}
+		tunnel, err := cloudflare.NewTunnel(ctx, stacks.Name(ctx, "cloudflare-tunnel"), tunnelArgs, pulumi.Providers(k8sProvider), pulumi.DependsOn([]pulumi.Resource{k8sProvider}))
+		if err != nil {
+			return nil, err
+		}
+		teardownDeps = append(teardownDeps, tunnel)
+		exports["cloudflareTunnel"] = pulumi.Map{
+			"name":            tunnel.TunnelName,
+			"metricsEndpoint": tunnel.MetricsEndpoint,
+			"routes":          tunnel.Routes,
+		}
+		skipInfra = append(skipInfra, "cloudflare-tunnel")
+	}
+	if components.Flux && (gitCfg.Sync || fluxCfg.Mode == "bootstrap") {
+		for _, component := range skipInfra {
+			_ = ctx.Log.Warn(fmt.Sprintf("%[1]s is managed by Pulumi, remove %[2]s/%[1]s from the kustomization Flux reconciles to avoid two owners",
+				component, clusterCfg.InfraDir), nil)
+		}
+		for _, object := range excludeInfra {
+			_ = ctx.Log.Warn(fmt.Sprintf("%s is managed by Pulumi, remove it from the kustomization Flux reconciles in %s to avoid two owners",
+				object, clusterCfg.InfraDir), nil)
+		}
+	}
+
+	// LoadBalancer addresses for ingress, before anything creates such Services
+	if components.MetalLB {
+		if len(metallbCfg.Addresses) == 0 && cluster.DockerNetwork == "" {
+			return nil, fmt.Errorf("%s:metallbRange is required for a cluster without a Docker network", configNamespace)
+		}
+		metallb, err := metallbpkg.NewInstall(ctx, stacks.Name(ctx, "metallb"), &metallbpkg.InstallArgs{
+			Version:       metallbCfg.Version,
+			Addresses:     metallbCfg.Addresses,
+			DockerNetwork: cluster.DockerNetwork,
+			Kubeconfig:    cluster.Kubeconfig,
+			Timeout:       metallbCfg.Timeout,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		platformDeps = []pulumi.Resource{metallb}
+		exports["metallbPool"] = metallb.Addresses
+	}
+
+	// Ingress controller, ready to admit the Ingress objects of the
+	// infrastructure. Kind publishes the ports of the ingress-ready node on
+	// the host.
+	hostPort := cluster.Kind != nil
+	httpPort, httpsPort := 80, 443
+	for _, mapping := range clusterCfg.Kind.PortMappings {
+		switch mapping.ContainerPort {
+		case 80:
+			httpPort = mapping.HostPort
+		case 443:
+			httpsPort = mapping.HostPort
+		}
+	}
+	previousController, err := previousIngressController(previousDeployment)
+	if err != nil {
+		return nil, err
+	}
+	handover, err := newIngressHandover(ctx, previousController, ingressCfg.Controller, cliEnvironment, platformDeps)
+	if err != nil {
+		return nil, err
+	}
+	if handover != nil {
+		platformDeps = []pulumi.Resource{handover}
+	}
+	exports["ingressController"] = pulumi.String(ingressCfg.Controller)
+	if components.Ingress {
+		nginxArgs := &ingress.NginxArgs{
+			Version:    ingressCfg.Version,
+			HostPort:   hostPort,
+			HTTPPort:   httpPort,
+			HTTPSPort:  httpsPort,
+			Kubeconfig: cluster.Kubeconfig,
+			Timeout:    ingressCfg.Timeout,
+		}
+		var localCerts *localtls.Certificates
+		if cfg.LocalTLS != nil {
+			localCerts, err = loadLocalCertificates(ctx, cfg.LocalTLS, previousDeployment)
+			if err != nil {
+				return nil, err
+			}
+			nginxArgs.DefaultCertificate = ingress.Namespace + "/" + localTLSSecret
+		}
+		nginx, err := ingress.NewNginx(ctx, stacks.Name(ctx, "ingress-nginx"), nginxArgs, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		platformDeps = []pulumi.Resource{nginx}
+
+		// The default certificate, in the namespace the chart creates
+		if localCerts != nil {
+			certificate, err := localtls.NewDefaultCertificate(ctx, stacks.Name(ctx, "local-tls"), &localtls.DefaultCertificateArgs{
+				Certificates: localCerts,
+				Namespace:    ingress.Namespace,
+				Name:         localTLSSecret,
+			}, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+			if err != nil {
+				return nil, err
+			}
+			platformDeps = []pulumi.Resource{certificate}
+			exports[localCACertOutput] = certificate.CAPEM
+			exports[localTLSOutput] = pulumi.ToSecret(pulumi.StringMap{
+				"caPEM":    pulumi.String(localCerts.CAPEM),
+				"caKeyPEM": pulumi.String(localCerts.CAKeyPEM),
+				"certPEM":  pulumi.String(localCerts.CertPEM),
+				"keyPEM":   pulumi.String(localCerts.KeyPEM),
+			})
+		}
+		exports["ingressClass"] = nginx.ClassName
+		exports["ingressUrls"] = pulumi.StringMap{
+			"http":  nginx.HTTPURL,
+			"https": nginx.HTTPSURL,
+		}
+	}
+	if components.EnvoyGateway {
+		gateway, err := ingress.NewEnvoyGateway(ctx, stacks.Name(ctx, "envoy-gateway"), &ingress.EnvoyGatewayArgs{
+			Version:           ingressCfg.EnvoyGatewayVersion,
+			GatewayAPIVersion: ingressCfg.GatewayAPIVersion,
+			HostPort:          hostPort,
+			HTTPPort:          httpPort,
+			HTTPSPort:         httpsPort,
+			KubeContext:       kubeContext,
+			Environment:       cliEnvironment,
+			Kubeconfig:        cluster.Kubeconfig,
+			Timeout:           ingressCfg.Timeout,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		platformDeps = []pulumi.Resource{gateway}
+		exports["gatewayClass"] = gateway.ClassName
+		exports["gateway"] = gateway.Gateway
+		exports["ingressUrls"] = pulumi.StringMap{
+			"http":  gateway.HTTPURL,
+			"https": gateway.HTTPSURL,
+		}
+	}
+
+	// linkerd-multicluster comes after MetalLB, which gives its gateway an
+	// address. The link is deleted before the extension on destroy.
+	if multiclusterCfg := cfg.LinkerdMulticluster; multiclusterCfg != nil {
+		multicluster, err := linkerd.NewMulticluster(ctx, stacks.Name(ctx, "linkerd-multicluster"), &linkerd.MulticlusterArgs{
+			Version:      linkerdCfg.Version,
+			LoadBalancer: components.MetalLB,
+			Kubeconfig:   cluster.Kubeconfig,
+			Timeout:      timeouts.LinkerdInstall,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		platformDeps = []pulumi.Resource{multicluster}
+		multiclusterExports := pulumi.Map{
+			"gatewayAddress": multicluster.GatewayAddress,
+			"gatewayPort":    multicluster.GatewayPort,
+		}
+
+		if peer.Link != nil {
+			apiServer := multiclusterCfg.APIServerAddress
+			if apiServer == "" {
+				apiServer = fmt.Sprintf("https://%s-control-plane:6443", peer.Link.ClusterName)
+			}
+			link, err := linkerd.NewLink(ctx, stacks.Name(ctx, "linkerd-peer"), &linkerd.LinkArgs{
+				ClusterName:      peer.Link.ClusterName,
+				PeerKubeconfig:   pulumi.ToSecret(pulumi.String(peer.Link.Kubeconfig)).(pulumi.StringOutput),
+				APIServerAddress: apiServer,
+				GatewayAddress:   peer.Link.Gateway.Address,
+				GatewayPort:      peer.Link.Gateway.Port,
+				Selector:         multiclusterCfg.Selector,
+				KubeContext:      kubeContext,
+				KubeconfigPath:   cluster.KubeconfigPath,
+				Environment:      cliEnvironment,
+				CheckTimeout:     timeouts.LinkerdCheck,
+			}, pulumi.DependsOn([]pulumi.Resource{multicluster}))
+			if err != nil {
+				return nil, err
+			}
+			platformDeps = []pulumi.Resource{link}
+			multiclusterExports["link"] = pulumi.Map{
+				"cluster":  pulumi.String(peer.Link.ClusterName),
+				"selector": pulumi.String(linkerd.Selector(multiclusterCfg.Selector)),
+				"status":   link.Status,
+			}
+		}
+		exports[multiclusterOutput] = multiclusterExports
+	}
+
+	// Not a dependency of anything, so disabling it only removes it
+	if sampleCfg := cfg.MeshSample; sampleCfg != nil {
+		sample, err := linkerd.NewSample(ctx, stacks.Name(ctx, "mesh-sample"), &linkerd.SampleArgs{
+			Image:       sampleCfg.Image,
+			KubeContext: kubeContext,
+			Kubeconfig:  cluster.Kubeconfig,
+			Timeout:     sampleCfg.Timeout,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(platformDeps))
+		if err != nil {
+			return nil, err
+		}
+		exports["meshSample"] = pulumi.Map{
+			"namespace":   pulumi.String(linkerd.SampleNamespace),
+			"url":         sample.URL,
+			"portForward": sample.PortForward,
+			"status":      sample.Status,
+		}
+	}
+
+	// Deploy infrastructure components using Kustomize from actual YAML files,
+	// one directory per component following the dependency graph
+	infraApplied := pulumi.Array{}.ToArrayOutput()
+	var infraDirectories map[string]*kustomize.Directory
+	if components.Infrastructure {
+		// HelmRepositories are reconciled by Flux, establish them (and the
+		// CRDs) before the HelmReleases using them are applied
+		var bootstrap *infra.BootstrapArgs
+		if components.Flux && clusterCfg.InfraPrerequisites {
+			bootstrap = &infra.BootstrapArgs{
+				HelmRepositories: clusterCfg.HelmRepositories,
+				CRDManifests:     clusterCfg.CRDManifests,
+				Kubeconfig:       cluster.Kubeconfig,
+				Timeout:          timeouts.InfraPrerequisites,
+			}
+		}
+		infrastructure, err := infra.Deploy(ctx, &infra.Args{
+			Dir:              clusterCfg.InfraDir,
+			Dependencies:     clusterCfg.InfraDependencies,
+			Skip:             skipInfra,
+			Exclude:          excludeInfra,
+			Labels:           clusterCfg.ResourceLabels,
+			Mesh:             cfg.Mesh,
+			InjectNamespaces: clusterCfg.InjectNamespaces,
+			Images:           localImages,
+			Protect:          clusterCfg.Protect,
+			Timeout:          timeouts.InfraApply,
+			PreviewDiff:      clusterCfg.PreviewDiff,
+			KubeconfigPath:   cluster.KubeconfigPath,
+			KubeContext:      kubeContext,
+			Previous:         previousDeployment,
+			Bootstrap:        bootstrap,
+			Provider:         k8sProvider,
+			DependsOn:        appDeps(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range infrastructure.Directories {
+			teardownDeps = append(teardownDeps, dir)
+		}
+		infraDirectories = infrastructure.Directories
+		infraApplied = infrastructure.Applied
+		summary.applied("infrastructure", infrastructure.Inventory)
+		addExports(exports, infrastructure.Exports)
+	}
+
+	// Apply the extra kustomizations (e.g. per-machine overlays) after the
+	// infrastructure components they depend on
+	if len(clusterCfg.ExtraKustomizeDirs) > 0 {
+		extra, err := infra.DeployExtra(ctx, &infra.ExtraArgs{
+			Directories:      clusterCfg.ExtraKustomizeDirs,
+			Components:       infraDirectories,
+			Labels:           clusterCfg.ResourceLabels,
+			Mesh:             cfg.Mesh,
+			InjectNamespaces: clusterCfg.InjectNamespaces,
+			Images:           localImages,
+			Timeout:          timeouts.InfraApply,
+			Provider:         k8sProvider,
+			DependsOn:        appDeps(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range extra.Directories {
+			teardownDeps = append(teardownDeps, dir)
+		}
+		infraApplied = pulumi.All(infraApplied, extra.Applied).ApplyT(func(applied []interface{}) []interface{} {
+			return append(applied[0].([]interface{}), applied[1].([]interface{})...)
+		}).(pulumi.ArrayOutput)
+		summary.applied("extraKustomizeDirs", extra.Inventory)
+		addExports(exports, extra.Exports)
+	}
+
+	// Isolate the listed namespaces the infrastructure created
+	if netpolCfg := cfg.NetworkPolicies; len(netpolCfg.Extra) > 0 {
+		applied := append(append([]pulumi.Resource{}, teardownDeps...), platformDeps...)
+		policies, err := deployNetworkPolicies(ctx, netpolCfg.Extra, netpolCfg, cfg.Mesh, k8sProvider, func(string) []pulumi.Resource {
+			return applied
+		})
+		if err != nil {
+			return nil, err
+		}
+		teardownDeps = append(teardownDeps, policies...)
+	}
+
+	// Traces of the meshed services, sent to Tempo unless an external
+	// collector is configured. The proxies injected before the webhook send
+	// theirs once their pod is restarted.
+	var jaeger *linkerd.Jaeger
+	var jaegerExports pulumi.Map
+	if jaegerCfg := cfg.LinkerdJaeger; jaegerCfg != nil {
+		collector := jaegerCfg.Collector
+		jaegerDeps := append([]pulumi.Resource{}, platformDeps...)
+		if collector == "" {
+			// Tempo of home:enableTracing is already in platformDeps
+			dir, ok := infraDirectories["tempo"]
+			if tempo == nil && !ok {
+				return nil, fmt.Errorf("%[1]s:enableLinkerdJaeger requires %[1]s:linkerdJaegerCollector, %[1]s:enableTracing or the tempo component of the infrastructure", configNamespace)
+			}
+			collector = tracing.OTLPGRPCEndpoint
+			if tempo == nil {
+				jaegerDeps = append(jaegerDeps, dir)
+			}
+		}
+		jaeger, err = linkerd.NewJaeger(ctx, stacks.Name(ctx, "linkerd-jaeger"), &linkerd.JaegerArgs{
+			Version:           linkerdCfg.Version,
+			CollectorEndpoint: collector,
+			Timeout:           timeouts.LinkerdInstall,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(jaegerDeps))
+		if err != nil {
+			return nil, err
+		}
+		teardownDeps = append(teardownDeps, jaeger)
+		jaegerExports = pulumi.Map{"collectorEndpoint": jaeger.CollectorEndpoint}
+		exports["linkerdJaeger"] = jaegerExports
+	}
+
+	if kedaInstall != nil && len(cfg.KEDA.ScaledObjects) > 0 {
+		// Prometheus comes from the monitoring stack or the infrastructure
+		var prometheusDeps []pulumi.Resource
+		if monitoringStack != nil {
+			prometheusDeps = append(prometheusDeps, monitoringStack)
+		}
+		if dir, ok := infraDirectories["prometheus-operator"]; ok {
+			prometheusDeps = append(prometheusDeps, dir)
+		}
+		scaled, err := keda.NewScaledObjects(ctx, stacks.Name(ctx, "scaled-objects"), &keda.ScaledObjectsArgs{
+			ScaledObjects:  cfg.KEDA.ScaledObjects,
+			Install:        kedaInstall,
+			PrometheusDeps: prometheusDeps,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(append(append([]pulumi.Resource{}, teardownDeps...), platformDeps...)))
+		if err != nil {
+			return nil, err
+		}
+		teardownDeps = append(teardownDeps, scaled)
+		exports["scaledTargets"] = scaled.Targets
+	}
+
+	// Prometheus comes from the monitoring stack or the infrastructure
+	prometheusOperator, hasPrometheusOperator := infraDirectories["prometheus-operator"]
+	var prometheusDeps []pulumi.Resource
+	if monitoringStack != nil {
+		prometheusDeps = append(prometheusDeps, monitoringStack)
+	}
+	if hasPrometheusOperator {
+		prometheusDeps = append(prometheusDeps, prometheusOperator)
+	}
+
+	// Probes of the external hostnames and monitors and alerts of the
+	// platform components for that Prometheus, and Uptime Kuma
+	observabilityArgs := &observability.Args{
+		PlatformMonitors: monitoringCfg.PlatformMonitors,
+		Targets: monitoring.PlatformTargets{
+			Flux:                 components.Flux,
+			FluxNamespace:        fluxpkg.Namespace,
+			Linkerd:              components.Linkerd,
+			LinkerdNamespace:     linkerd.Namespace,
+			Tunnel:               components.CloudflareTunnel,
+			TunnelNamespace:      cloudflare.Namespace,
+			ExternalDNS:          components.ExternalDNS,
+			ExternalDNSNamespace: externaldns.Namespace,
+		},
+		Thresholds: monitoringCfg.Alerts,
+		Kubeconfig: cluster.Kubeconfig,
+		Timeout:    monitoringCfg.Timeout,
+		Provider:   k8sProvider,
+		Prometheus: prometheusDeps,
+		DependsOn:  platformDeps,
+		Deployed:   teardownDeps,
+	}
+	if components.BlackboxExporter {
+		observabilityArgs.Blackbox = cfg.Blackbox
+	}
+	if components.UptimeKuma {
+		observabilityArgs.UptimeKuma = cfg.UptimeKuma
+		if persistentStorage != nil {
+			observabilityArgs.StorageClass = persistentStorage.ClassName
+		}
+	}
+	observed, err := observability.Deploy(ctx, observabilityArgs)
+	if err != nil {
+		return nil, err
+	}
+	teardownDeps = append(teardownDeps, observed.Resources...)
+	serviceURLs = append(serviceURLs, observed.Services...)
+	addExports(exports, observed.Exports)
+
+	// Passwords of the home services the infrastructure expects in Secrets
+	if dir, ok := infraDirectories["homepage"]; ok {
+		creds, err := credentials.NewCredentials(ctx, stacks.Name(ctx, "homepage-credentials"), &credentials.CredentialsArgs{
+			Passwords: homepagePasswords,
+			Rotate:    cfg.Rotate,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn([]pulumi.Resource{dir}))
+		if err != nil {
+			return nil, err
+		}
+		teardownDeps = append(teardownDeps, creds)
+		exports["homepageCredentials"] = creds.Passwords
+	}
+
+	// Dashboards, and the datasources of whichever of Prometheus, Loki and
+	// Tempo are deployed
+	if cfg.GrafanaDashboards != nil {
+		datasources := []grafana.Datasource{
+			{Name: "Prometheus", UID: "prometheus", Type: "prometheus", URL: monitoring.PrometheusURL, Access: "proxy", IsDefault: true},
+		}
+		if components.Logging {
+			datasources = append(datasources, grafana.Datasource{Name: "Loki", UID: "loki", Type: "loki", URL: logging.LokiURL, Access: "proxy"})
+		}
+		if _, ok := infraDirectories["tempo"]; ok || components.Tracing {
+			datasources = append(datasources, grafana.Datasource{Name: "Tempo", UID: "tempo", Type: "tempo", URL: tracing.URL, Access: "proxy"})
+		}
+		dashboards, err := grafana.NewDashboards(ctx, stacks.Name(ctx, "grafana-dashboards"), &grafana.DashboardsArgs{
+			Dashboards:  cfg.GrafanaDashboards,
+			Datasources: datasources,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn([]pulumi.Resource{monitoringStack}))
+		if err != nil {
+			return nil, err
+		}
+		teardownDeps = append(teardownDeps, dashboards)
+		exports["grafanaDashboards"] = dashboards.Titles
+	}
+
+	// Wait until Flux has actually reconciled what was applied
+	deployed := []interface{}{infraApplied, summary.last}
+	if jaeger != nil {
+		deployed = append(deployed, jaeger.CollectorEndpoint)
+	}
+	if fluxUIReady != nil {
+		deployed = append(deployed, fluxUIReady)
+	}
+	if components.Flux {
+		reconciliation := waitForFluxReconciliation(ctx, cluster.Kubeconfig, infraApplied, fluxCfg.ReconcileTimeout)
+		exports["fluxReconciliation"] = reconciliation
+		deployed = append(deployed, reconciliation)
+
+		// Depends on everything else in the cluster, so destroy uninstalls
+		// Flux (and its finalizers) before deleting the objects it reconciles
+		_, err = fluxpkg.NewTeardown(ctx, stacks.Name(ctx, "flux-teardown"), &fluxpkg.TeardownArgs{
+			Context:    kubeContext,
+			Kubeconfig: cluster.KubeconfigPath,
+		}, pulumi.DependsOn(append(teardownDeps, platformDeps...)))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Check that the platform actually serves traffic
+	var smokeTests pulumi.Output
+	if len(cfg.SmokeTests.Checks) > 0 {
+		// Trace the requests through the proxies to check that Tempo gets
+		// their spans
+		var traceID string
+		if jaeger != nil && cfg.LinkerdJaeger.Collector == "" {
+			if traceID, err = newTraceID(); err != nil {
+				return nil, err
+			}
+		}
+		smokeTests = runSmokeTests(ctx, cluster.Kubeconfig, cfg.SmokeTests, traceID, timeouts.SmokeTests, deployed...)
+		exports["smokeTests"] = smokeTests
+		deployed = append(deployed, smokeTests)
+		if traceID != "" {
+			trace := verifySmokeTestTrace(ctx, cluster.Kubeconfig, traceID, timeouts.SmokeTests, smokeTests)
+			jaegerExports["smokeTestTrace"] = trace
+			deployed = append(deployed, trace)
+		}
+	}
+
+	// Objects the audited policies would have rejected
+	if components.Kyverno {
+		var audited []string
+		for _, policy := range kyvernoCfg.Policies {
+			if policy.Mode == kyverno.Audit {
+				audited = append(audited, policy.Name)
+			}
+		}
+		if len(audited) > 0 {
+			exports["policyViolations"] = countPolicyViolations(ctx, cluster.Kubeconfig, audited, deployed...)
+		}
+	}
+
+	// How to reach Grafana, Prometheus, the Linkerd dashboard and the
+	// configured Services once everything is deployed
+	exports["serviceUrls"] = discoverServiceURLs(ctx, cluster.Kubeconfig, kubeContext, serviceURLs, deployed...)
+
+	// Where other clusters ship their logs
+	if components.Logging {
+		exports["lokiPushEndpoint"] = lokiPushEndpoint(ctx, cluster.Kubeconfig, kubeContext, loggingCfg.NodePort, lokiPushURL, deployed...)
+	}
+	// Where other clusters remote_write their metrics
+	if components.Monitoring && monitoringCfg.RemoteWriteNodePort != 0 {
+		exports["prometheusRemoteWrite"] = prometheusRemoteWriteEndpoint(ctx, cluster.Kubeconfig, kubeContext, deployed...)
+	}
+
+	// Leave port forwards to the dashboards running on the host
+	if len(cfg.PortForwards) > 0 {
+		forwards, err := portforward.NewPortForwards(ctx, stacks.Name(ctx, "port-forwards"), &portforward.Args{
+			Cluster:    clusterName,
+			Forwards:   cfg.PortForwards,
+			Kubeconfig: cluster.KubeconfigPath,
+			Context:    kubeContext,
+		}, pulumi.DependsOn(append(teardownDeps, platformDeps...)))
+		if err != nil {
+			return nil, err
+		}
+		exports["portForwards"] = forwards.URLs
+	}
+
+	// What the deployment did, for CI and scripts
+	report, err := writeDeployReport(ctx, cfg.ReportPath, deployReport{
+		Stack:          stacks.Stack(ctx),
+		ClusterName:    clusterName,
+		KubeconfigPath: cluster.KubeconfigPath,
+		KubeContext:    kubeContext,
+		Versions:       componentVersions(cfg),
+	}, summary.steps, smokeTests, deployed...)
+	if err != nil {
+		return nil, err
+	}
+	exports["deployReport"] = report.Environment.MapIndex(pulumi.String("REPORT_PATH"))
+	// The members of an umbrella stack deployed after this one wait for it
+	if member := stacks.MemberOf(ctx); member != nil {
+		member.Resources = append(append([]pulumi.Resource{report}, platformDeps...), teardownDeps...)
+	}
+
+	// Export cluster information
+	exports["summary"] = summary.steps
+	exports["clusterName"] = pulumi.String(clusterName)
+	exports["kubeconfig"] = cluster.Kubeconfig
+	exports["kubeconfigPath"] = pulumi.String(cluster.KubeconfigPath)
+	exports["kubeContext"] = pulumi.String(kubeContext)
+	enabled := pulumi.BoolMap{
+		"flux":             pulumi.Bool(components.Flux),
+		"linkerd":          pulumi.Bool(components.Linkerd),
+		"linkerdViz":       pulumi.Bool(components.LinkerdViz),
+		"infrastructure":   pulumi.Bool(components.Infrastructure),
+		"localRegistry":    pulumi.Bool(components.LocalRegistry),
+		"metallb":          pulumi.Bool(components.MetalLB),
+		"ingress":          pulumi.Bool(components.Ingress),
+		"certManager":      pulumi.Bool(components.CertManager),
+		"cloudflareTunnel": pulumi.Bool(components.CloudflareTunnel),
+		"cloudflareDdns":   pulumi.Bool(ddnsCfg.Enabled),
+		"externalDns":      pulumi.Bool(components.ExternalDNS),
+		"monitoring":       pulumi.Bool(components.Monitoring),
+		"logging":          pulumi.Bool(components.Logging),
+		"tracing":          pulumi.Bool(components.Tracing),
+		"flagger":          pulumi.Bool(components.Flagger),
+		"minio":            pulumi.Bool(components.MinIO),
+		"velero":           pulumi.Bool(components.Velero),
+		"postgres":         pulumi.Bool(components.Postgres),
+		"sealedSecrets":    pulumi.Bool(components.SealedSecrets),
+		"externalSecrets":  pulumi.Bool(components.ExternalSecrets),
+		"kyverno":          pulumi.Bool(components.Kyverno),
+		"metricsServer":    pulumi.Bool(components.MetricsServer),
+		"keda":             pulumi.Bool(components.KEDA),
+		"istio":            pulumi.Bool(components.Istio),
+		"envoyGateway":     pulumi.Bool(components.EnvoyGateway),
+		"blackboxExporter": pulumi.Bool(components.BlackboxExporter),
+		"uptimeKuma":       pulumi.Bool(components.UptimeKuma),
+		"fluxUI":           pulumi.Bool(components.FluxUI),
+	}
+	exports["components"] = enabled
+	versions := componentVersions(cfg)
+	exports["componentVersions"] = pulumi.ToStringMap(versions)
+	changed, err := changedComponents(previousDeployment, enabled, versions)
+	if err != nil {
+		return nil, err
+	}
+	exports[changedComponentsOutput] = pulumi.ToStringArray(changed)
+	if adopted := adoptedNamespaces.Adopted(); len(adopted) > 0 {
+		exports[adopt.NamespacesOutput] = pulumi.ToStringArray(adopted)
+	}
+	// Destroy a ci stack once its TTL has elapsed
+	if cfg.Profile != "" {
+		exports["profile"] = pulumi.String(cfg.Profile)
+	}
+	if cfg.TTL > 0 {
+		expiresAt, err := stackExpiry(previousDeployment, cfg.TTL, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		teardown, err := ttl.NewTeardown(ctx, stacks.Name(ctx, "ttl-teardown"), &ttl.TeardownArgs{
+			Stack:     ctx.Stack(),
+			ExpiresAt: expiresAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+		exports[ttlOutput] = pulumi.String(cfg.TTL.String())
+		exports[ttl.ExpiresAtOutput] = teardown.ExpiresAt
+	}
+
+	// The end of the output of successful steps too, capped since it is
+	// stored with every update
+	if cfg.ProvisionLogLines > 0 && len(provisionOutputs) > 0 {
+		logs := pulumi.Map{}
+		for step, output := range provisionOutputs {
+			logs[step] = output.Tail(cfg.ProvisionLogLines)
+		}
+		exports["provisionLogs"] = logs
+	}
+
+	return exports, nil
+}
+
+// warnMeshSwitch warns when the mesh differs from the one of the previous
+// deployment: the update uninstalls one and installs the other, and meshed
+// workloads only get the new proxy once restarted
+func warnMeshSwitch(ctx *pulumi.Context, previousDeployment *previous.Deployment, mesh string) error {
+	var previousMesh string
+	found, err := previousDeployment.Output("mesh", &previousMesh)
+	if err != nil {
+		return err
+	}
+	if found && previousMesh != mesh {
+		_ = ctx.Log.Warn(fmt.Sprintf("home:mesh changed from %s to %s: this update removes %[1]s and installs %[2]s, restart the meshed workloads afterwards",
+			previousMesh, mesh), nil)
+	}
+	return nil
+}
+
+// addExports adds the stack outputs of a step to exports
+func addExports(exports, outputs pulumi.Map) {
+	for name, value := range outputs {
+		exports[name] = value
+	}
 }
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// deployMembers deploys the clusters of an umbrella stack in this program,
+// each after the ones it depends on. The names of the resources of a member
+// start with its own, and its outputs are exported under it, e.g.
+// studio.kubeconfig.
+func deployMembers(ctx *pulumi.Context, membersCfg *MembersConfig) (pulumi.Map, error) {
+	exports := pulumi.Map{}
+	members := make(map[string]*stacks.Member, len(membersCfg.Stacks))
+	names := pulumi.StringArray{}
+	for _, name := range membersCfg.Stacks {
+		member := &stacks.Member{Name: name}
+		for _, sibling := range membersCfg.Stacks {
+			if sibling != name {
+				member.Siblings = append(member.Siblings, sibling)
+			}
+		}
+		for _, dep := range membersCfg.Dependencies[name] {
+			member.DependsOn = append(member.DependsOn, members[dep].Resources...)
+		}
+		members[name] = member
+
+		memberExports, err := deploy(stacks.With(ctx, member), membersCfg.Configs[name])
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", name, err)
+		}
+		exports[name] = memberExports
+		names = append(names, pulumi.String(name))
+	}
+	exports["clusters"] = names
+	return exports, nil
+}
+
+// memberDependencies returns what the cluster of the member ctx deploys is
+// created after, nil for a stack deploying its own cluster
+func memberDependencies(ctx *pulumi.Context) []pulumi.Resource {
+	if member := stacks.MemberOf(ctx); member != nil {
+		return member.DependsOn
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+
+	"cluster-studio/internal/mesh"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// multiclusterOutput is the stack output with the gateway of
+// linkerd-multicluster, read by the stacks linking to this one, and the
+// status of the link to the peer stack
+const multiclusterOutput = "linkerdMulticluster"
+
+// multiclusterGateway is the gateway of a linkerd-multicluster output
+type multiclusterGateway struct {
+	Address string `json:"gatewayAddress"`
+	Port    int    `json:"gatewayPort"`
+}
+
+// sharePeerTrustAnchor installs Linkerd with the trust anchor of the peer
+// cluster, which the proxies of both clusters must share to talk through
+// the gateways. An imported trust anchor must be that one already.
+func sharePeerTrustAnchor(ctx *pulumi.Context, linkerdCfg *mesh.LinkerdConfig, link *peerLink, stack string) error {
+	if linkerdCfg.TrustAnchorPEM != "" {
+		if linkerdCfg.TrustAnchorPEM != link.Identity.TrustAnchorPEM {
+			return fmt.Errorf("%s:linkerdTrustAnchorPEM is not the trust anchor of peer stack %s, linked clusters must share it",
+				configNamespace, stack)
+		}
+		return nil
+	}
+	if link.Identity.TrustAnchorPEM == "" || link.Identity.TrustAnchorKeyPEM == "" {
+		return fmt.Errorf("peer stack %s exports no Linkerd trust anchor to share", stack)
+	}
+
+	_ = ctx.Log.Info(fmt.Sprintf("using the Linkerd trust anchor of peer stack %s", stack), nil)
+	linkerdCfg.TrustAnchorPEM = link.Identity.TrustAnchorPEM
+	linkerdCfg.TrustAnchorKeyPEM = link.Identity.TrustAnchorKeyPEM
+	return nil
+}
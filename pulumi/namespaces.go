@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+
+	"cluster-studio/pkg/monitoring"
+	"cluster-studio/pkg/netpol"
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// deployNamespaces creates the configured namespaces with the resource labels
+// of the stack. Namespaces removed from config are deleted on the next update.
+func deployNamespaces(ctx *pulumi.Context, namespaces []NamespaceConfig, resourceLabels map[string]string, k8sProvider *kubernetes.Provider) (map[string]*corev1.Namespace, error) {
+	created := make(map[string]*corev1.Namespace, len(namespaces))
+	for _, ns := range namespaces {
+		labels := pulumi.StringMap{}
+		for key, value := range ns.Labels {
+			labels[key] = pulumi.String(value)
+		}
+		for key, value := range resourceLabels {
+			labels[key] = pulumi.String(value)
+		}
+
+		namespace, err := corev1.NewNamespace(ctx, stacks.Name(ctx, fmt.Sprintf("namespace-%s", ns.Name)), &corev1.NamespaceArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:        pulumi.String(ns.Name),
+				Labels:      labels,
+				Annotations: pulumi.ToStringMap(ns.Annotations),
+			},
+		}, pulumi.Provider(k8sProvider))
+		if err != nil {
+			return nil, err
+		}
+		created[ns.Name] = namespace
+	}
+	return created, nil
+}
+
+// deployNetworkPolicies isolates each namespace with default-deny
+// NetworkPolicies, once the resources it was created by are done
+func deployNetworkPolicies(ctx *pulumi.Context, namespaces []string, netpolCfg *NetworkPolicyConfig, mesh string, k8sProvider *kubernetes.Provider, deps func(namespace string) []pulumi.Resource) ([]pulumi.Resource, error) {
+	var policies []pulumi.Resource
+	for _, namespace := range namespaces {
+		policy, err := netpol.NewDefaultDeny(ctx, stacks.Name(ctx, fmt.Sprintf("network-policies-%s", namespace)), &netpol.DefaultDenyArgs{
+			Namespace:           namespace,
+			Mesh:                mesh,
+			PrometheusNamespace: monitoring.Namespace,
+			DenyEgress:          netpolCfg.DenyEgress,
+		}, pulumi.Providers(k8sProvider), pulumi.DependsOn(deps(namespace)))
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+
+	"cluster-studio/internal/previous"
+	"cluster-studio/pkg/notify"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// changedComponentsOutput is the stack output listing the components
+// enabled, disabled or upgraded since the previous deployment
+const changedComponentsOutput = "changedComponents"
+
+// failedResource matches the name at the end of the URN of the resource an
+// update failed on
+var failedResource = regexp.MustCompile(`urn:pulumi:[^:\s]+::[^:\s]+::[^\s]*::([^:\s"]+)`)
+
+// deployNotification posts the outcome of an update to the webhook of
+// home:notifyWebhook. A nil one notifies nothing, e.g. during previews.
+type deployNotification struct {
+	config *NotifyConfig
+	stack  string
+	// Step running when the program failed, empty while resources are created
+	step    string
+	changed []string
+}
+
+// at records the step the program is in
+func (n *deployNotification) at(step string) {
+	if n != nil {
+		n.step = step
+	}
+}
+
+// changedIn reads the changed components from the stack outputs
+func (n *deployNotification) changedIn(exports pulumi.Map) {
+	if n == nil {
+		return
+	}
+	if changed, ok := exports[changedComponentsOutput].(pulumi.StringArray); ok {
+		for _, component := range changed {
+			n.changed = append(n.changed, string(component.(pulumi.String)))
+		}
+	}
+}
+
+// send posts the outcome of the update, err being what it failed with. The
+// update outcome doesn't depend on the webhook, failures are only printed.
+func (n *deployNotification) send(err error, duration time.Duration) {
+	if n == nil || (err == nil && !n.config.OnSuccess) {
+		return
+	}
+	deployment := notify.Deployment{
+		Stack:    n.stack,
+		Duration: duration,
+		Changed:  n.changed,
+		Err:      err,
+	}
+	if err != nil {
+		deployment.Step = n.step
+		if deployment.Step == "" {
+			deployment.Step = failedStep(err)
+		}
+	}
+	if err := notify.Post(context.Background(), n.config.Webhook, n.config.Format, deployment); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: deployment notification not sent: %v\n", err)
+	}
+}
+
+// failedStep names the resource an update failed on from its error, "deploy"
+// when the error doesn't name one
+func failedStep(err error) string {
+	if match := failedResource.FindStringSubmatch(err.Error()); match != nil {
+		return match[1]
+	}
+	return "deploy"
+}
+
+// changedComponents lists the components enabled, disabled or installed with
+// another chart version since the previous deployment, none on the first one
+func changedComponents(previousDeployment *previous.Deployment, enabled pulumi.BoolMap, versions map[string]string) ([]string, error) {
+	var previousEnabled map[string]bool
+	found, err := previousDeployment.Output("components", &previousEnabled)
+	if err != nil || !found {
+		return nil, err
+	}
+	var previousVersions map[string]string
+	if _, err := previousDeployment.Output("componentVersions", &previousVersions); err != nil {
+		return nil, err
+	}
+
+	changed := []string{}
+	for component, value := range enabled {
+		now := bool(value.(pulumi.Bool))
+		was, ok := previousEnabled[component]
+		switch {
+		case !ok || now != was:
+			changed = append(changed, component)
+		case previousVersions != nil && versions[component] != previousVersions[component]:
+			changed = append(changed, component)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
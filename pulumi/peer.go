@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"cluster-studio/internal/mesh"
+	"cluster-studio/internal/previous"
+	"cluster-studio/pkg/kind"
+	"cluster-studio/pkg/linkerd"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Outputs of the peer stack (home:peerStack) fed into the components
+const (
+	// Registry host -> mirror URL, configured in containerd on the Kind nodes
+	peerRegistryMirrorsOutput = "registryMirrors"
+	// URL Prometheus forwards its samples to
+	peerRemoteWriteOutput = "prometheusRemoteWrite"
+	// URL Promtail also ships the logs to
+	peerLokiPushOutput = "lokiPushEndpoint"
+	// Cluster and kubeconfig linked to with home:linkerdMulticlusterLink
+	peerClusterNameOutput = "clusterName"
+	peerKubeconfigOutput  = "kubeconfig"
+)
+
+// peerOutputs are the outputs read from the peer stack, empty when it
+// doesn't export them
+type peerOutputs struct {
+	RegistryMirrors map[string]string
+	RemoteWrite     string
+	LokiPush        string
+	// What linking to the peer cluster needs, nil unless requested
+	Link *peerLink
+}
+
+// peerLink is the cluster of the peer stack linked to with linkerd-multicluster
+type peerLink struct {
+	ClusterName string
+	// Kubeconfig the link is created with, from the host
+	Kubeconfig string
+	// Linkerd certificates, linked clusters share the trust anchor
+	Identity linkerd.Identity
+	Gateway  multiclusterGateway
+}
+
+// loadPeerOutputs reads the well-known outputs of the peer stack. An output
+// that is missing (e.g. the component is disabled there) or malformed is
+// skipped with a warning instead of failing the deployment. With link, the
+// outputs linking to the peer cluster needs are read too, and must exist.
+func loadPeerOutputs(ctx *pulumi.Context, stack string, link bool) (*peerOutputs, error) {
+	peer, err := previous.NewPeer(ctx, stack)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := &peerOutputs{}
+	for name, v := range map[string]interface{}{
+		peerRegistryMirrorsOutput: &outputs.RegistryMirrors,
+		peerRemoteWriteOutput:     &outputs.RemoteWrite,
+		peerLokiPushOutput:        &outputs.LokiPush,
+	} {
+		found, err := peer.Output(name, v)
+		if err != nil {
+			_ = ctx.Log.Warn(fmt.Sprintf("ignoring output %s of peer stack %s: %v", name, stack, err), nil)
+		} else if !found {
+			_ = ctx.Log.Warn(fmt.Sprintf("peer stack %s doesn't export %s, not using it", stack, name), nil)
+		}
+	}
+	if !link {
+		return outputs, nil
+	}
+
+	outputs.Link = &peerLink{}
+	for name, v := range map[string]interface{}{
+		peerClusterNameOutput: &outputs.Link.ClusterName,
+		peerKubeconfigOutput:  &outputs.Link.Kubeconfig,
+		mesh.IdentityOutput:   &outputs.Link.Identity,
+		multiclusterOutput:    &outputs.Link.Gateway,
+	} {
+		found, err := peer.Output(name, v)
+		if err != nil {
+			return nil, fmt.Errorf("peer stack %s: %w", stack, err)
+		}
+		if !found {
+			return nil, fmt.Errorf("peer stack %s doesn't export %s, deploy it with %s:enableLinkerdMulticluster before linking to it",
+				stack, name, configNamespace)
+		}
+	}
+	return outputs, nil
+}
+
+// peerMirrors returns the registry mirrors of the Kind nodes: those of the
+// peer stack overridden by the configured ones, and by the pull-through
+// cache for Docker Hub
+func peerMirrors(ctx *pulumi.Context, peer *peerOutputs, clusterCfg *ClusterConfig) map[string]string {
+	mirrors := map[string]string{}
+	registries := make([]string, 0, len(peer.RegistryMirrors))
+	for registry := range peer.RegistryMirrors {
+		registries = append(registries, registry)
+	}
+	sort.Strings(registries)
+	for _, registry := range registries {
+		if _, ok := clusterCfg.RegistryMirrors[registry]; ok || (clusterCfg.PullThroughCache && registry == kind.DockerHubRegistry) {
+			_ = ctx.Log.Info(fmt.Sprintf("using the configured %s mirror instead of the one of the peer stack", registry), nil)
+			continue
+		}
+		mirrors[registry] = peer.RegistryMirrors[registry]
+	}
+	for registry, mirror := range clusterCfg.RegistryMirrors {
+		mirrors[registry] = mirror
+	}
+	return mirrors
+}
@@ -0,0 +1,211 @@
+package certmanager
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// ChartRepo is the Helm repository serving the cert-manager chart
+	ChartRepo = "https://charts.jetstack.io"
+	// Namespace is where cert-manager is installed
+	Namespace = "cert-manager"
+	// SelfSignedIssuer is the name of the bootstrap self-signed ClusterIssuer
+	SelfSignedIssuer = "selfsigned"
+	// CloudflareIssuer is the name of the ACME ClusterIssuer solving DNS01 through Cloudflare
+	CloudflareIssuer = "letsencrypt-cloudflare"
+	// LetsEncryptServer is the production ACME directory of Let's Encrypt
+	LetsEncryptServer = "https://acme-v02.api.letsencrypt.org/directory"
+)
+
+// crds must be Established before issuers can be created
+var crds = []string{
+	"certificates.cert-manager.io",
+	"clusterissuers.cert-manager.io",
+	"issuers.cert-manager.io",
+}
+
+// InstallArgs configures the cert-manager installation
+type InstallArgs struct {
+	// Chart version (e.g. v1.18.2)
+	Version string
+	// Cloudflare API token (secret) with Zone:DNS:Edit permission. When set an
+	// ACME ClusterIssuer solving DNS01 challenges through Cloudflare is created.
+	CloudflareAPIToken pulumi.StringInput
+	// ACME account email and directory of the Cloudflare issuer
+	ACMEEmail  string
+	ACMEServer string
+	// Kubeconfig of the cluster (secret), used to wait for the CRDs and webhook
+	Kubeconfig pulumi.StringInput
+	// How long to wait for the controller, CRDs and webhook
+	Timeout time.Duration
+}
+
+// Install is cert-manager with its bootstrap ClusterIssuers
+type Install struct {
+	pulumi.ResourceState
+
+	// Names of the ClusterIssuers created
+	Issuers pulumi.StringArrayOutput `pulumi:"issuers"`
+}
+
+// NewInstall installs cert-manager with Helm and, once its CRDs and webhook
+// are ready, creates a self-signed ClusterIssuer and optionally a Cloudflare
+// DNS01 ClusterIssuer
+func NewInstall(ctx *pulumi.Context, name string, args *InstallArgs, opts ...pulumi.ResourceOption) (*Install, error) {
+	install := &Install{}
+	err := ctx.RegisterComponentResource("home:certmanager:Install", name, install, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := helmv3.NewRelease(ctx, stacks.Name(ctx, "cert-manager"), &helmv3.ReleaseArgs{
+		Name:            pulumi.String("cert-manager"),
+		Chart:           pulumi.String("cert-manager"),
+		Version:         pulumi.String(args.Version),
+		Namespace:       pulumi.String(Namespace),
+		CreateNamespace: pulumi.Bool(true),
+		RepositoryOpts:  helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+		Timeout:         pulumi.Int(int(args.Timeout.Seconds())),
+		Values: pulumi.Map{
+			"crds": pulumi.Map{"enabled": pulumi.Bool(true)},
+		},
+	}, pulumi.Parent(install))
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolves to the release once the CRDs are Established and the webhook serves
+	ready := pulumi.All(release.Status, args.Kubeconfig).ApplyT(func(values []interface{}) ([]pulumi.Resource, error) {
+		if ctx.DryRun() {
+			return []pulumi.Resource{release}, nil
+		}
+		if err := waitForWebhook(ctx, install, args, values[1].(string)); err != nil {
+			return nil, err
+		}
+		return []pulumi.Resource{release}, nil
+	}).(pulumi.ResourceArrayOutput)
+
+	_, err = apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-%s", name, SelfSignedIssuer), &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String("cert-manager.io/v1"),
+		Kind:       pulumi.String("ClusterIssuer"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Name: pulumi.String(SelfSignedIssuer),
+		},
+		OtherFields: kubernetes.UntypedArgs{
+			"spec": pulumi.Map{
+				"selfSigned": pulumi.Map{},
+			},
+		},
+	}, pulumi.Parent(install), pulumi.DependsOnInputs(ready))
+	if err != nil {
+		return nil, err
+	}
+	issuers := pulumi.StringArray{pulumi.String(SelfSignedIssuer)}
+
+	if args.CloudflareAPIToken != nil {
+		token, err := corev1.NewSecret(ctx, fmt.Sprintf("%s-cloudflare-api-token", name), &corev1.SecretArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:      pulumi.String("cloudflare-api-token"),
+				Namespace: pulumi.String(Namespace),
+			},
+			StringData: pulumi.StringMap{
+				"api-token": args.CloudflareAPIToken,
+			},
+		}, pulumi.Parent(install), pulumi.DependsOn([]pulumi.Resource{release}))
+		if err != nil {
+			return nil, err
+		}
+
+		server := args.ACMEServer
+		if server == "" {
+			server = LetsEncryptServer
+		}
+		_, err = apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-%s", name, CloudflareIssuer), &apiextensions.CustomResourceArgs{
+			ApiVersion: pulumi.String("cert-manager.io/v1"),
+			Kind:       pulumi.String("ClusterIssuer"),
+			Metadata: &metav1.ObjectMetaArgs{
+				Name: pulumi.String(CloudflareIssuer),
+			},
+			OtherFields: kubernetes.UntypedArgs{
+				"spec": pulumi.Map{
+					"acme": pulumi.Map{
+						"server": pulumi.String(server),
+						"email":  pulumi.String(args.ACMEEmail),
+						"privateKeySecretRef": pulumi.Map{
+							"name": pulumi.String(CloudflareIssuer + "-account-key"),
+						},
+						"solvers": pulumi.Array{
+							pulumi.Map{
+								"dns01": pulumi.Map{
+									"cloudflare": pulumi.Map{
+										"apiTokenSecretRef": pulumi.Map{
+											"name": token.Metadata.Name(),
+											"key":  pulumi.String("api-token"),
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		}, pulumi.Parent(install), pulumi.DependsOnInputs(ready))
+		if err != nil {
+			return nil, err
+		}
+		issuers = append(issuers, pulumi.String(CloudflareIssuer))
+	}
+
+	install.Issuers = issuers.ToStringArrayOutput()
+	err = ctx.RegisterResourceOutputs(install, pulumi.Map{
+		"issuers": install.Issuers,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return install, nil
+}
+
+// waitForWebhook waits for the cert-manager CRDs to be Established and the
+// webhook to have ready endpoints, without which issuers are rejected
+func waitForWebhook(ctx *pulumi.Context, install *Install, args *InstallArgs, kubeconfig string) error {
+	logf := func(format string, a ...interface{}) {
+		_ = ctx.Log.Info(fmt.Sprintf(format, a...), &pulumi.LogArgs{Resource: install})
+	}
+
+	dynamicClient, err := kube.NewDynamicClientFromKubeconfig(kubeconfig)
+	if err != nil {
+		return err
+	}
+	err = kube.WaitForCRDsEstablished(context.Background(), dynamicClient, crds, kube.PollOptions{
+		Description: "cert-manager CRDs to become Established",
+		Timeout:     args.Timeout,
+		Logf:        logf,
+	})
+	if err != nil {
+		return err
+	}
+
+	client, err := kube.NewClientsetFromKubeconfig(kubeconfig)
+	if err != nil {
+		return err
+	}
+	return kube.WaitForServiceEndpoints(context.Background(), client, Namespace, "cert-manager-webhook", kube.PollOptions{
+		Description: "cert-manager webhook to become ready",
+		Timeout:     args.Timeout,
+		Logf:        logf,
+	})
+}
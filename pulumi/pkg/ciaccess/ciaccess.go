@@ -0,0 +1,239 @@
+package ciaccess
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"cluster-studio/pkg/kube"
+
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	rbacv1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/rbac/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Namespace holds the ServiceAccounts and their token Secrets by default
+const Namespace = "ci-access"
+
+// defaultTimeout is how long the token controller may take by default
+const defaultTimeout = time.Minute
+
+// Rule grants verbs on resources of API groups ("" for the core group)
+type Rule struct {
+	APIGroups []string `json:"apiGroups"`
+	Resources []string `json:"resources"`
+	Verbs     []string `json:"verbs"`
+}
+
+// Account is a ServiceAccount used from CI, e.g. ci-readonly
+type Account struct {
+	// Name of the ServiceAccount and of its ClusterRole
+	Name string `json:"name"`
+	// What the account may do
+	Rules []Rule `json:"rules"`
+	// Existing ClusterRole bound instead of one created from Rules, e.g.
+	// the built-in view
+	ClusterRole string `json:"clusterRole"`
+	// Namespaces the rules apply in, empty for the whole cluster
+	Namespaces []string `json:"namespaces"`
+	// Bumped to issue a new token, which revokes the previous one
+	Rotation int `json:"rotation"`
+}
+
+// Args configures the CI accounts
+type Args struct {
+	Accounts []Account
+	// Namespace created for the ServiceAccounts, defaults to Namespace
+	Namespace string
+	// Name of the cluster in the kubeconfigs
+	ClusterName string
+	// API server URL the kubeconfigs point to, defaults to the one of
+	// Kubeconfig
+	Server string
+	// Kubeconfig the tokens are read with (secret)
+	Kubeconfig pulumi.StringInput
+	// How long the token controller may take to fill a token Secret,
+	// defaults to a minute
+	Timeout time.Duration
+}
+
+// Accounts are the CI ServiceAccounts and their kubeconfigs
+type Accounts struct {
+	pulumi.ResourceState
+
+	// Account -> standalone kubeconfig authenticating with its token (secret)
+	Kubeconfigs pulumi.StringMapOutput `pulumi:"kubeconfigs"`
+}
+
+// New creates a ServiceAccount per account, a ClusterRole with its rules
+// bound cluster-wide or in its namespaces, and a long-lived token Secret, and
+// assembles a kubeconfig with the token and the CA of the cluster. Bumping
+// the rotation of an account replaces only its token Secret and kubeconfig.
+func New(ctx *pulumi.Context, name string, args *Args, opts ...pulumi.ResourceOption) (*Accounts, error) {
+	accounts := &Accounts{}
+	err := ctx.RegisterComponentResource("home:ciaccess:Accounts", name, accounts, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaceName := args.Namespace
+	if namespaceName == "" {
+		namespaceName = Namespace
+	}
+	namespace, err := corev1.NewNamespace(ctx, fmt.Sprintf("%s-namespace", name), &corev1.NamespaceArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name: pulumi.String(namespaceName),
+		},
+	}, pulumi.Parent(accounts))
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := args.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	kubeconfigs := pulumi.StringMap{}
+	for _, account := range args.Accounts {
+		kubeconfig, err := newAccount(ctx, fmt.Sprintf("%s-%s", name, account.Name), account, namespace, args, timeout, accounts)
+		if err != nil {
+			return nil, err
+		}
+		kubeconfigs[account.Name] = kubeconfig
+	}
+
+	accounts.Kubeconfigs = pulumi.ToSecret(kubeconfigs).(pulumi.StringMapOutput)
+	err = ctx.RegisterResourceOutputs(accounts, pulumi.Map{
+		"kubeconfigs": accounts.Kubeconfigs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+// newAccount creates the resources of an account and returns its kubeconfig
+func newAccount(ctx *pulumi.Context, name string, account Account, namespace *corev1.Namespace, args *Args, timeout time.Duration, parent pulumi.Resource) (pulumi.StringOutput, error) {
+	serviceAccount, err := corev1.NewServiceAccount(ctx, name, &corev1.ServiceAccountArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(account.Name),
+			Namespace: namespace.Metadata.Name(),
+		},
+	}, pulumi.Parent(parent))
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+
+	roleRef := rbacv1.RoleRefArgs{
+		ApiGroup: pulumi.String("rbac.authorization.k8s.io"),
+		Kind:     pulumi.String("ClusterRole"),
+		Name:     pulumi.String(account.ClusterRole),
+	}
+	if account.ClusterRole == "" {
+		rules := rbacv1.PolicyRuleArray{}
+		for _, rule := range account.Rules {
+			apiGroups := rule.APIGroups
+			if len(apiGroups) == 0 {
+				apiGroups = []string{""}
+			}
+			rules = append(rules, rbacv1.PolicyRuleArgs{
+				ApiGroups: pulumi.ToStringArray(apiGroups),
+				Resources: pulumi.ToStringArray(rule.Resources),
+				Verbs:     pulumi.ToStringArray(rule.Verbs),
+			})
+		}
+		role, err := rbacv1.NewClusterRole(ctx, fmt.Sprintf("%s-role", name), &rbacv1.ClusterRoleArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name: pulumi.String(account.Name),
+			},
+			Rules: rules,
+		}, pulumi.Parent(parent))
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+		roleRef.Name = role.Metadata.Name().Elem()
+	}
+	subjects := rbacv1.SubjectArray{
+		rbacv1.SubjectArgs{
+			Kind:      pulumi.String("ServiceAccount"),
+			Name:      serviceAccount.Metadata.Name().Elem(),
+			Namespace: namespace.Metadata.Name().Elem(),
+		},
+	}
+	if len(account.Namespaces) == 0 {
+		_, err = rbacv1.NewClusterRoleBinding(ctx, fmt.Sprintf("%s-binding", name), &rbacv1.ClusterRoleBindingArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name: pulumi.String(account.Name),
+			},
+			RoleRef:  roleRef,
+			Subjects: subjects,
+		}, pulumi.Parent(parent))
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+	}
+	for _, bindingNamespace := range account.Namespaces {
+		_, err = rbacv1.NewRoleBinding(ctx, fmt.Sprintf("%s-%s-binding", name, bindingNamespace), &rbacv1.RoleBindingArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:      pulumi.String(account.Name),
+				Namespace: pulumi.String(bindingNamespace),
+			},
+			RoleRef:  roleRef,
+			Subjects: subjects,
+		}, pulumi.Parent(parent))
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+	}
+
+	// A new name on rotation replaces the Secret, and deleting the previous
+	// one invalidates its token
+	token, err := corev1.NewSecret(ctx, fmt.Sprintf("%s-token", name), &corev1.SecretArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(fmt.Sprintf("%s-token-%s", account.Name, strconv.Itoa(account.Rotation))),
+			Namespace: namespace.Metadata.Name(),
+			Annotations: pulumi.StringMap{
+				"kubernetes.io/service-account.name": serviceAccount.Metadata.Name().Elem(),
+			},
+		},
+		Type: pulumi.String("kubernetes.io/service-account-token"),
+	}, pulumi.Parent(parent))
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+
+	kubeconfig := pulumi.All(namespace.Metadata.Name().Elem(), token.Metadata.Name().Elem(), args.Kubeconfig).ApplyT(func(all []interface{}) (string, error) {
+		if ctx.DryRun() {
+			return "", nil
+		}
+		namespaceName, adminKubeconfig := all[0].(string), all[2].(string)
+		// kubectl defaults to the first namespace the account may read
+		contextNamespace := namespaceName
+		if len(account.Namespaces) > 0 {
+			contextNamespace = account.Namespaces[0]
+		}
+		server := args.Server
+		if server == "" {
+			var err error
+			if server, err = kube.Server(adminKubeconfig); err != nil {
+				return "", err
+			}
+		}
+		client, err := kube.NewClientsetFromKubeconfig(adminKubeconfig)
+		if err != nil {
+			return "", err
+		}
+		value, ca, err := kube.WaitForServiceAccountToken(context.Background(), client, namespaceName, all[1].(string), kube.PollOptions{
+			Interval: time.Second,
+			Timeout:  timeout,
+		})
+		if err != nil {
+			return "", err
+		}
+		return kube.TokenKubeconfig(args.ClusterName, server, ca, account.Name, value, contextNamespace)
+	}).(pulumi.StringOutput)
+	return pulumi.ToSecret(kubeconfig).(pulumi.StringOutput), nil
+}
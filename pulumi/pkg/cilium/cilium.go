@@ -0,0 +1,92 @@
+package cilium
+
+import (
+	"time"
+
+	"cluster-studio/pkg/stacks"
+
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// ChartRepo is the Helm repository serving the Cilium chart
+	ChartRepo = "https://helm.cilium.io/"
+	// Namespace is where Cilium is installed, next to the CNI it replaces
+	Namespace = "kube-system"
+)
+
+// InstallArgs configures the Cilium installation
+type InstallArgs struct {
+	// Chart version
+	Version string
+	// Replace kube-proxy, which must not be installed. Cilium then reaches
+	// the API server at APIServerHost:APIServerPort instead of through the
+	// kubernetes Service.
+	KubeProxyReplacement bool
+	APIServerHost        string
+	APIServerPort        int
+	// Install Hubble Relay and the Hubble UI
+	HubbleUI bool
+	// How long to wait for the agents, which the nodes wait for to be Ready
+	Timeout time.Duration
+}
+
+// Install is Cilium as the CNI of the cluster
+type Install struct {
+	pulumi.ResourceState
+
+	// Installed chart version, resolved once the agents are running
+	Version pulumi.StringOutput `pulumi:"version"`
+}
+
+// NewInstall installs Cilium with Helm. It must run on a cluster created
+// without a CNI, whose nodes only become Ready once it is installed.
+func NewInstall(ctx *pulumi.Context, name string, args *InstallArgs, opts ...pulumi.ResourceOption) (*Install, error) {
+	install := &Install{}
+	err := ctx.RegisterComponentResource("home:cilium:Install", name, install, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	values := pulumi.Map{
+		// The pod CIDRs are those Kind assigns to the nodes
+		"ipam":                 pulumi.Map{"mode": pulumi.String("kubernetes")},
+		"operator":             pulumi.Map{"replicas": pulumi.Int(1)},
+		"image":                pulumi.Map{"pullPolicy": pulumi.String("IfNotPresent")},
+		"kubeProxyReplacement": pulumi.Bool(args.KubeProxyReplacement),
+		"hubble": pulumi.Map{
+			"relay": pulumi.Map{"enabled": pulumi.Bool(args.HubbleUI)},
+			"ui":    pulumi.Map{"enabled": pulumi.Bool(args.HubbleUI)},
+		},
+	}
+	if args.KubeProxyReplacement {
+		values["k8sServiceHost"] = pulumi.String(args.APIServerHost)
+		values["k8sServicePort"] = pulumi.Int(args.APIServerPort)
+	}
+
+	release, err := helmv3.NewRelease(ctx, stacks.Name(ctx, "cilium"), &helmv3.ReleaseArgs{
+		Name:           pulumi.String("cilium"),
+		Chart:          pulumi.String("cilium"),
+		Version:        pulumi.String(args.Version),
+		Namespace:      pulumi.String(Namespace),
+		RepositoryOpts: helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+		Timeout:        pulumi.Int(int(args.Timeout.Seconds())),
+		Values:         values,
+	}, pulumi.Parent(install))
+	if err != nil {
+		return nil, err
+	}
+
+	install.Version = release.ID().ApplyT(func(pulumi.ID) string {
+		return args.Version
+	}).(pulumi.StringOutput)
+	err = ctx.RegisterResourceOutputs(install, pulumi.Map{
+		"version": install.Version,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return install, nil
+}
@@ -0,0 +1,145 @@
+package cloudflare
+
+import (
+	"fmt"
+	"strings"
+
+	appsv1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apps/v1"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// DDNSNamespace is where the DDNS updater runs
+	DDNSNamespace = "cloudflare-ddns"
+	// DefaultDDNSImage is the updater image used when none is configured
+	DefaultDDNSImage = "favonia/cloudflare-ddns:1.15.1"
+)
+
+// DDNSArgs configures the Cloudflare DDNS updater
+type DDNSArgs struct {
+	// Cloudflare API token (secret) with Zone:DNS:Edit permission
+	Token pulumi.StringInput
+	// Zone the records belong to (e.g. lucena.cloud)
+	Zone string
+	// Records to keep pointed at the public IP, relative to Zone ("@" is the
+	// apex) or fully qualified
+	Records []string
+	// Updater image, defaults to DefaultDDNSImage
+	Image string
+}
+
+// DDNS keeps Cloudflare DNS records pointed at the public IP of the network
+type DDNS struct {
+	pulumi.ResourceState
+
+	// Fully qualified records being updated
+	Records pulumi.StringArrayOutput `pulumi:"records"`
+}
+
+// NewDDNS creates the API token Secret and the updater Deployment in the
+// cloudflare-ddns namespace, which must already exist
+func NewDDNS(ctx *pulumi.Context, name string, args *DDNSArgs, opts ...pulumi.ResourceOption) (*DDNS, error) {
+	ddns := &DDNS{}
+	err := ctx.RegisterComponentResource("home:cloudflare:DDNS", name, ddns, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	records := QualifyRecords(args.Zone, args.Records)
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no DNS records to update in zone %s", args.Zone)
+	}
+	image := args.Image
+	if image == "" {
+		image = DefaultDDNSImage
+	}
+	labels := pulumi.StringMap{"app": pulumi.String("cloudflare-ddns")}
+
+	secret, err := corev1.NewSecret(ctx, fmt.Sprintf("%s-token", name), &corev1.SecretArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String("cloudflare-api-token"),
+			Namespace: pulumi.String(DDNSNamespace),
+		},
+		StringData: pulumi.StringMap{
+			"CLOUDFLARE_API_TOKEN": args.Token,
+		},
+	}, pulumi.Parent(ddns))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = appsv1.NewDeployment(ctx, fmt.Sprintf("%s-updater", name), &appsv1.DeploymentArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String("cloudflare-ddns"),
+			Namespace: pulumi.String(DDNSNamespace),
+		},
+		Spec: &appsv1.DeploymentSpecArgs{
+			Replicas: pulumi.Int(1),
+			Selector: &metav1.LabelSelectorArgs{MatchLabels: labels},
+			Template: &corev1.PodTemplateSpecArgs{
+				Metadata: &metav1.ObjectMetaArgs{Labels: labels},
+				Spec: &corev1.PodSpecArgs{
+					SecurityContext: &corev1.PodSecurityContextArgs{
+						RunAsNonRoot: pulumi.Bool(true),
+						RunAsUser:    pulumi.Int(1000),
+					},
+					Containers: corev1.ContainerArray{
+						&corev1.ContainerArgs{
+							Name:  pulumi.String("cloudflare-ddns"),
+							Image: pulumi.String(image),
+							EnvFrom: corev1.EnvFromSourceArray{
+								&corev1.EnvFromSourceArgs{
+									SecretRef: &corev1.SecretEnvSourceArgs{Name: secret.Metadata.Name()},
+								},
+							},
+							Env: corev1.EnvVarArray{
+								&corev1.EnvVarArgs{Name: pulumi.String("DOMAINS"), Value: pulumi.String(strings.Join(records, ","))},
+								&corev1.EnvVarArgs{Name: pulumi.String("PROXIED"), Value: pulumi.String("false")},
+								&corev1.EnvVarArgs{Name: pulumi.String("IP6_PROVIDER"), Value: pulumi.String("none")},
+							},
+							Resources: &corev1.ResourceRequirementsArgs{
+								Requests: pulumi.StringMap{"memory": pulumi.String("16Mi"), "cpu": pulumi.String("10m")},
+								Limits:   pulumi.StringMap{"memory": pulumi.String("64Mi"), "cpu": pulumi.String("50m")},
+							},
+						},
+					},
+				},
+			},
+		},
+	}, pulumi.Parent(ddns))
+	if err != nil {
+		return nil, err
+	}
+
+	ddns.Records = pulumi.ToStringArray(records).ToStringArrayOutput()
+	err = ctx.RegisterResourceOutputs(ddns, pulumi.Map{
+		"records": ddns.Records,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ddns, nil
+}
+
+// QualifyRecords turns record names relative to zone into fully qualified
+// names. "@" is the zone apex and names already ending in the zone are kept.
+func QualifyRecords(zone string, records []string) []string {
+	zone = strings.TrimSuffix(zone, ".")
+	qualified := make([]string, 0, len(records))
+	for _, record := range records {
+		record = strings.TrimSuffix(strings.TrimSpace(record), ".")
+		switch {
+		case record == "":
+			continue
+		case record == "@":
+			record = zone
+		case record != zone && !strings.HasSuffix(record, "."+zone):
+			record = record + "." + zone
+		}
+		qualified = append(qualified, record)
+	}
+	return qualified
+}
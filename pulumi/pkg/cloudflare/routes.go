@@ -0,0 +1,101 @@
+package cloudflare
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"cluster-studio/pkg/kube"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// ConfigPath is where cloudflared reads the rendered routes from
+const ConfigPath = "/etc/cloudflared/config.yaml"
+
+// OriginRequestKeys are the originRequest options cloudflared accepts on an
+// ingress rule
+var OriginRequestKeys = []string{
+	"access", "caPool", "connectTimeout", "disableChunkedEncoding", "http2Origin",
+	"httpHostHeader", "keepAliveConnections", "keepAliveTimeout", "noHappyEyeballs",
+	"noTLSVerify", "originServerName", "proxyType", "tcpKeepAlive", "tlsTimeout",
+}
+
+// Route sends the requests for a public hostname to an in-cluster Service
+type Route struct {
+	Hostname string `json:"hostname"`
+	// Service and its namespace the requests are proxied to
+	Service   string `json:"service"`
+	Namespace string `json:"namespace"`
+	Port      int    `json:"port"`
+	// http (default) or https
+	Scheme string `json:"scheme,omitempty"`
+	// Regular expression the path must match (optional)
+	Path string `json:"path,omitempty"`
+	// Options of the connection to the Service, see OriginRequestKeys
+	OriginRequest map[string]interface{} `json:"originRequest,omitempty"`
+}
+
+// Origin is the URL cloudflared proxies the route to
+func (r Route) Origin() string {
+	scheme := r.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s.%s.svc.cluster.local:%d", scheme, r.Service, r.Namespace, r.Port)
+}
+
+// ServiceKey is the namespace/name of the Service of the route
+func (r Route) ServiceKey() string {
+	return r.Namespace + "/" + r.Service
+}
+
+// RenderConfig returns the cloudflared config with an ingress rule per route,
+// in order, and the catch-all rule answering 404
+func RenderConfig(routes []Route) ([]byte, error) {
+	type rule struct {
+		Hostname      string                 `json:"hostname,omitempty"`
+		Path          string                 `json:"path,omitempty"`
+		Service       string                 `json:"service"`
+		OriginRequest map[string]interface{} `json:"originRequest,omitempty"`
+	}
+	rules := make([]rule, 0, len(routes)+1)
+	for _, route := range routes {
+		rules = append(rules, rule{
+			Hostname:      route.Hostname,
+			Path:          route.Path,
+			Service:       route.Origin(),
+			OriginRequest: route.OriginRequest,
+		})
+	}
+	rules = append(rules, rule{Service: "http_status:404"})
+	return yaml.Marshal(map[string]interface{}{"ingress": rules})
+}
+
+// WaitForServices polls until every Service (namespace/name) exists and
+// returns an error naming the missing ones on timeout
+func WaitForServices(ctx context.Context, client kubernetes.Interface, services []string, opts kube.PollOptions) error {
+	if opts.Description == "" {
+		opts.Description = "the Services of the tunnel routes to exist"
+	}
+	return kube.Poll(ctx, opts, func(ctx context.Context) (bool, string, error) {
+		var missing []string
+		for _, service := range services {
+			namespace, name, _ := strings.Cut(service, "/")
+			_, err := client.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				missing = append(missing, service)
+				continue
+			}
+			if err != nil {
+				return false, "", err
+			}
+		}
+		sort.Strings(missing)
+		return len(missing) == 0, fmt.Sprintf("missing %s", strings.Join(missing, ", ")), nil
+	})
+}
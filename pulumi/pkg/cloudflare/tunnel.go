@@ -0,0 +1,308 @@
+package cloudflare
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"time"
+
+	"cluster-studio/pkg/kube"
+
+	appsv1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apps/v1"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// Namespace is where cloudflared runs
+	Namespace = "cloudflare-tunnel"
+	// DefaultImage is the cloudflared image used when none is configured
+	DefaultImage = "cloudflare/cloudflared:2025.9.1"
+	// MetricsPort is the port cloudflared serves /metrics and /ready on
+	MetricsPort = 2000
+)
+
+// TunnelArgs configures the cloudflared deployment of a remotely managed tunnel
+type TunnelArgs struct {
+	// Name of the tunnel, informational (the token identifies it)
+	TunnelName string
+	// Tunnel token (secret)
+	Token pulumi.StringInput
+	// Number of cloudflared replicas
+	Replicas int
+	// cloudflared image, defaults to DefaultImage
+	Image string
+	// Routes rendered into the cloudflared config, none keeps the routes
+	// configured on the tunnel in the Cloudflare dashboard
+	Routes []Route
+	// Services (namespace/name) known to be deployed, e.g. found in the
+	// rendered manifests. The cluster is polled for the other ones.
+	KnownServices []string
+	// Kubeconfig of the cluster (secret), used to look up the Services
+	Kubeconfig pulumi.StringInput
+	// How long to wait for the Services of the routes
+	Timeout time.Duration
+}
+
+// Tunnel is cloudflared connecting the cluster to a Cloudflare Tunnel
+type Tunnel struct {
+	pulumi.ResourceState
+
+	// Name of the tunnel
+	TunnelName pulumi.StringOutput `pulumi:"tunnelName"`
+	// In-cluster URL of the cloudflared metrics
+	MetricsEndpoint pulumi.StringOutput `pulumi:"metricsEndpoint"`
+	// Origin of each routed hostname
+	Routes pulumi.StringMapOutput `pulumi:"routes"`
+}
+
+// NewTunnel creates the namespace, the token Secret and the cloudflared
+// Deployment and metrics Service. The pods carry a checksum of the token, so
+// rotating it rolls the deployment. With Routes, the ingress rules are
+// rendered into a ConfigMap once their Services exist, and the pods carry a
+// checksum of it too.
+func NewTunnel(ctx *pulumi.Context, name string, args *TunnelArgs, opts ...pulumi.ResourceOption) (*Tunnel, error) {
+	tunnel := &Tunnel{}
+	err := ctx.RegisterComponentResource("home:cloudflare:Tunnel", name, tunnel, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	image := args.Image
+	if image == "" {
+		image = DefaultImage
+	}
+	labels := pulumi.StringMap{"app": pulumi.String("cloudflared")}
+
+	namespace, err := corev1.NewNamespace(ctx, fmt.Sprintf("%s-namespace", name), &corev1.NamespaceArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name: pulumi.String(Namespace),
+			Labels: pulumi.StringMap{
+				"app.kubernetes.io/name":      pulumi.String("cloudflare-tunnel"),
+				"app.kubernetes.io/component": pulumi.String("networking"),
+			},
+		},
+	}, pulumi.Parent(tunnel))
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := corev1.NewSecret(ctx, fmt.Sprintf("%s-credentials", name), &corev1.SecretArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String("cloudflare-tunnel-credentials"),
+			Namespace: namespace.Metadata.Name(),
+		},
+		StringData: pulumi.StringMap{
+			"CLOUDFLARE_TOKEN": args.Token,
+		},
+	}, pulumi.Parent(tunnel))
+	if err != nil {
+		return nil, err
+	}
+
+	// Changes whenever the token does, without revealing it
+	tokenChecksum := pulumi.Unsecret(args.Token.ToStringOutput().ApplyT(func(token string) string {
+		sum := sha256.Sum256([]byte(token))
+		return hex.EncodeToString(sum[:])
+	})).(pulumi.StringOutput)
+
+	annotations := pulumi.StringMap{
+		"home.lucena.cloud/token-checksum": tokenChecksum,
+	}
+	runArgs := []string{"run", "--token", "$(TUNNEL_TOKEN)"}
+	var volumes corev1.VolumeArray
+	var mounts corev1.VolumeMountArray
+	routes := pulumi.StringMap{}
+	if len(args.Routes) > 0 {
+		rendered, err := RenderConfig(args.Routes)
+		if err != nil {
+			return nil, err
+		}
+		for _, route := range args.Routes {
+			routes[route.Hostname] = pulumi.String(route.Origin())
+		}
+		known := map[string]bool{}
+		for _, service := range args.KnownServices {
+			known[service] = true
+		}
+		var unknown []string
+		for _, route := range args.Routes {
+			if !known[route.ServiceKey()] {
+				unknown = append(unknown, route.ServiceKey())
+				known[route.ServiceKey()] = true
+			}
+		}
+		// Resolves once every Service exists, so no route points nowhere
+		config := pulumi.Unsecret(args.Kubeconfig.ToStringOutput().ApplyT(func(kubeconfig string) (string, error) {
+			if len(unknown) == 0 || ctx.DryRun() {
+				return string(rendered), nil
+			}
+			client, err := kube.NewClientsetFromKubeconfig(kubeconfig)
+			if err != nil {
+				return "", err
+			}
+			err = WaitForServices(context.Background(), client, unknown, kube.PollOptions{
+				Timeout: args.Timeout,
+				Logf: func(format string, a ...interface{}) {
+					_ = ctx.Log.Info(fmt.Sprintf(format, a...), &pulumi.LogArgs{Resource: tunnel})
+				},
+			})
+			if err != nil {
+				return "", fmt.Errorf("tunnel routes: %w", err)
+			}
+			return string(rendered), nil
+		})).(pulumi.StringOutput)
+
+		configMap, err := corev1.NewConfigMap(ctx, fmt.Sprintf("%s-config", name), &corev1.ConfigMapArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:      pulumi.String("cloudflared-config"),
+				Namespace: namespace.Metadata.Name(),
+			},
+			Data: pulumi.StringMap{
+				path.Base(ConfigPath): config,
+			},
+		}, pulumi.Parent(tunnel))
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(rendered)
+		annotations["home.lucena.cloud/config-checksum"] = pulumi.String(hex.EncodeToString(sum[:]))
+		runArgs = append([]string{"--config", ConfigPath}, runArgs...)
+		volumes = corev1.VolumeArray{
+			&corev1.VolumeArgs{
+				Name: pulumi.String("config"),
+				ConfigMap: &corev1.ConfigMapVolumeSourceArgs{
+					Name: configMap.Metadata.Name(),
+				},
+			},
+		}
+		mounts = corev1.VolumeMountArray{
+			&corev1.VolumeMountArgs{
+				Name:      pulumi.String("config"),
+				MountPath: pulumi.String(path.Dir(ConfigPath)),
+				ReadOnly:  pulumi.Bool(true),
+			},
+		}
+	}
+
+	probe := func(initialDelay, period, timeout int) *corev1.ProbeArgs {
+		return &corev1.ProbeArgs{
+			HttpGet: &corev1.HTTPGetActionArgs{
+				Path: pulumi.String("/ready"),
+				Port: pulumi.Int(MetricsPort),
+			},
+			FailureThreshold:    pulumi.Int(3),
+			InitialDelaySeconds: pulumi.Int(initialDelay),
+			PeriodSeconds:       pulumi.Int(period),
+			TimeoutSeconds:      pulumi.Int(timeout),
+		}
+	}
+	_, err = appsv1.NewDeployment(ctx, fmt.Sprintf("%s-cloudflared", name), &appsv1.DeploymentArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String("cloudflared"),
+			Namespace: namespace.Metadata.Name(),
+		},
+		Spec: &appsv1.DeploymentSpecArgs{
+			Replicas: pulumi.Int(args.Replicas),
+			Selector: &metav1.LabelSelectorArgs{MatchLabels: labels},
+			Template: &corev1.PodTemplateSpecArgs{
+				Metadata: &metav1.ObjectMetaArgs{
+					Labels:      labels,
+					Annotations: annotations,
+				},
+				Spec: &corev1.PodSpecArgs{
+					SecurityContext: &corev1.PodSecurityContextArgs{
+						RunAsNonRoot: pulumi.Bool(true),
+						RunAsUser:    pulumi.Int(1001),
+						FsGroup:      pulumi.Int(1001),
+					},
+					Volumes: volumes,
+					Containers: corev1.ContainerArray{
+						&corev1.ContainerArgs{
+							Name:  pulumi.String("cloudflared"),
+							Image: pulumi.String(image),
+							Args: pulumi.ToStringArray(append([]string{
+								"tunnel", "--no-autoupdate", "--loglevel", "info",
+								"--metrics", fmt.Sprintf("0.0.0.0:%d", MetricsPort),
+								"--protocol", "quic", "--retries", "5",
+								"--heartbeat-count", "5", "--heartbeat-interval", "5s",
+							}, runArgs...)),
+							VolumeMounts: mounts,
+							Env: corev1.EnvVarArray{
+								&corev1.EnvVarArgs{
+									Name: pulumi.String("TUNNEL_TOKEN"),
+									ValueFrom: &corev1.EnvVarSourceArgs{
+										SecretKeyRef: &corev1.SecretKeySelectorArgs{
+											Name: secret.Metadata.Name(),
+											Key:  pulumi.String("CLOUDFLARE_TOKEN"),
+										},
+									},
+								},
+							},
+							Resources: &corev1.ResourceRequirementsArgs{
+								Requests: pulumi.StringMap{"memory": pulumi.String("64Mi"), "cpu": pulumi.String("50m")},
+								Limits:   pulumi.StringMap{"memory": pulumi.String("128Mi"), "cpu": pulumi.String("100m")},
+							},
+							LivenessProbe:  probe(30, 10, 5),
+							ReadinessProbe: probe(10, 5, 3),
+							Ports: corev1.ContainerPortArray{
+								&corev1.ContainerPortArgs{
+									Name:          pulumi.String("metrics"),
+									ContainerPort: pulumi.Int(MetricsPort),
+									Protocol:      pulumi.String("TCP"),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}, pulumi.Parent(tunnel))
+	if err != nil {
+		return nil, err
+	}
+
+	service, err := corev1.NewService(ctx, fmt.Sprintf("%s-metrics", name), &corev1.ServiceArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String("cloudflared-metrics"),
+			Namespace: namespace.Metadata.Name(),
+			Labels: pulumi.StringMap{
+				"app.kubernetes.io/name":      pulumi.String("cloudflare-tunnel"),
+				"app.kubernetes.io/component": pulumi.String("metrics"),
+			},
+		},
+		Spec: &corev1.ServiceSpecArgs{
+			Type:     pulumi.String("ClusterIP"),
+			Selector: labels,
+			Ports: corev1.ServicePortArray{
+				&corev1.ServicePortArgs{
+					Name:       pulumi.String("metrics"),
+					Port:       pulumi.Int(MetricsPort),
+					TargetPort: pulumi.Int(MetricsPort),
+					Protocol:   pulumi.String("TCP"),
+				},
+			},
+		},
+	}, pulumi.Parent(tunnel))
+	if err != nil {
+		return nil, err
+	}
+
+	tunnel.TunnelName = pulumi.String(args.TunnelName).ToStringOutput()
+	tunnel.MetricsEndpoint = pulumi.Sprintf("http://%s.%s.svc.cluster.local:%d/metrics",
+		service.Metadata.Name().Elem(), Namespace, MetricsPort)
+	tunnel.Routes = routes.ToStringMapOutput()
+	err = ctx.RegisterResourceOutputs(tunnel, pulumi.Map{
+		"tunnelName":      tunnel.TunnelName,
+		"metricsEndpoint": tunnel.MetricsEndpoint,
+		"routes":          tunnel.Routes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tunnel, nil
+}
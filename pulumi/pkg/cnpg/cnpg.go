@@ -0,0 +1,223 @@
+package cnpg
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cluster-studio/pkg/credentials"
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// ChartRepo is the Helm repository serving the CloudNativePG chart
+	ChartRepo = "https://cloudnative-pg.github.io/charts"
+	// OperatorNamespace is where the operator is installed
+	OperatorNamespace = "cnpg-system"
+	// Superuser is the name of the Postgres superuser
+	Superuser = "postgres"
+)
+
+// crds must be Established before the Cluster can be created
+var crds = []string{
+	"clusters.postgresql.cnpg.io",
+}
+
+// PostgresArgs configures the operator and the Postgres cluster
+type PostgresArgs struct {
+	// Chart version of the operator
+	Version string
+	// Namespace and name of the Postgres cluster
+	Namespace string
+	Name      string
+	// Number of Postgres instances, the first one is the primary
+	Instances int
+	// Size of the volume of each instance (e.g. "10Gi")
+	StorageSize string
+	// StorageClass of the volumes, the default one when empty
+	StorageClass string
+	// Database created at bootstrap and the user owning it
+	Database string
+	Owner    string
+	// Kubeconfig of the cluster (secret), used to wait for the CRDs and the
+	// instances
+	Kubeconfig pulumi.StringInput
+	// Bumping it generates the superuser password again
+	Rotate int
+	// How long to wait for the operator and the instances
+	Timeout time.Duration
+}
+
+// Postgres is the CloudNativePG operator with one Postgres cluster
+type Postgres struct {
+	pulumi.ResourceState
+
+	// In-cluster DNS names of the read-write (primary) and read-only
+	// (replicas) Services
+	ReadWriteHost pulumi.StringOutput `pulumi:"readWriteHost"`
+	ReadOnlyHost  pulumi.StringOutput `pulumi:"readOnlyHost"`
+	// Superuser password (secret)
+	SuperuserPassword pulumi.StringOutput `pulumi:"superuserPassword"`
+	// Secret with the credentials of the owner, generated by the operator
+	OwnerSecret pulumi.StringOutput `pulumi:"ownerSecret"`
+}
+
+// NewPostgres installs the CloudNativePG operator with Helm, waits for its
+// CRDs and creates the Postgres cluster with a generated superuser password.
+// Its status is recorded in a ConfigMap of kube-system once every instance is
+// ready, so resources depending on the component find the database up.
+func NewPostgres(ctx *pulumi.Context, name string, args *PostgresArgs, opts ...pulumi.ResourceOption) (*Postgres, error) {
+	postgres := &Postgres{}
+	err := ctx.RegisterComponentResource("home:cnpg:Postgres", name, postgres, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	logf := func(format string, a ...interface{}) {
+		_ = ctx.Log.Info(fmt.Sprintf(format, a...), &pulumi.LogArgs{Resource: postgres})
+	}
+
+	release, err := helmv3.NewRelease(ctx, stacks.Name(ctx, "cnpg"), &helmv3.ReleaseArgs{
+		Name:            pulumi.String("cnpg"),
+		Chart:           pulumi.String("cloudnative-pg"),
+		Version:         pulumi.String(args.Version),
+		Namespace:       pulumi.String(OperatorNamespace),
+		CreateNamespace: pulumi.Bool(true),
+		RepositoryOpts:  helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+		Timeout:         pulumi.Int(int(args.Timeout.Seconds())),
+	}, pulumi.Parent(postgres))
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolves to the release once its CRDs are Established
+	established := pulumi.All(release.ID(), args.Kubeconfig).ApplyT(func(values []interface{}) ([]pulumi.Resource, error) {
+		if ctx.DryRun() {
+			return []pulumi.Resource{release}, nil
+		}
+		client, err := kube.NewDynamicClientFromKubeconfig(values[1].(string))
+		if err != nil {
+			return nil, err
+		}
+		err = kube.WaitForCRDsEstablished(context.Background(), client, crds, kube.PollOptions{
+			Description: "CloudNativePG CRDs to become Established",
+			Timeout:     args.Timeout,
+			Logf:        logf,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []pulumi.Resource{release}, nil
+	}).(pulumi.ResourceArrayOutput)
+
+	namespace, err := corev1.NewNamespace(ctx, fmt.Sprintf("%s-namespace", name), &corev1.NamespaceArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name: pulumi.String(args.Namespace),
+		},
+	}, pulumi.Parent(postgres))
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := credentials.NewPassword(ctx, fmt.Sprintf("%s-superuser-password", name), 32, args.Rotate, pulumi.Parent(postgres))
+	if err != nil {
+		return nil, err
+	}
+
+	superuser, err := corev1.NewSecret(ctx, fmt.Sprintf("%s-superuser", name), &corev1.SecretArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(args.Name + "-superuser"),
+			Namespace: namespace.Metadata.Name(),
+		},
+		Type: pulumi.String("kubernetes.io/basic-auth"),
+		StringData: pulumi.StringMap{
+			"username": pulumi.String(Superuser),
+			"password": password.Result,
+		},
+	}, pulumi.Parent(postgres))
+	if err != nil {
+		return nil, err
+	}
+
+	storage := pulumi.Map{"size": pulumi.String(args.StorageSize)}
+	if args.StorageClass != "" {
+		storage["storageClass"] = pulumi.String(args.StorageClass)
+	}
+	cluster, err := apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-cluster", name), &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String("postgresql.cnpg.io/v1"),
+		Kind:       pulumi.String("Cluster"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(args.Name),
+			Namespace: namespace.Metadata.Name(),
+		},
+		OtherFields: kubernetes.UntypedArgs{
+			"spec": pulumi.Map{
+				"instances":             pulumi.Int(args.Instances),
+				"storage":               storage,
+				"enableSuperuserAccess": pulumi.Bool(true),
+				"superuserSecret":       pulumi.Map{"name": superuser.Metadata.Name().Elem()},
+				"bootstrap": pulumi.Map{
+					"initdb": pulumi.Map{
+						"database": pulumi.String(args.Database),
+						"owner":    pulumi.String(args.Owner),
+					},
+				},
+			},
+		},
+	}, pulumi.Parent(postgres), pulumi.DependsOnInputs(established))
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolves once every instance is ready
+	status := pulumi.All(cluster.ID(), args.Kubeconfig).ApplyT(func(values []interface{}) (string, error) {
+		if ctx.DryRun() {
+			return "", nil
+		}
+		client, err := kube.NewDynamicClientFromKubeconfig(values[1].(string))
+		if err != nil {
+			return "", err
+		}
+		return WaitForCluster(context.Background(), client, args.Namespace, args.Name, kube.PollOptions{
+			Timeout: args.Timeout,
+			Logf:    logf,
+		})
+	}).(pulumi.StringOutput)
+
+	_, err = corev1.NewConfigMap(ctx, fmt.Sprintf("%s-report", name), &corev1.ConfigMapArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(name),
+			Namespace: pulumi.String("kube-system"),
+		},
+		Data: pulumi.StringMap{
+			"phase": status,
+		},
+	}, pulumi.Parent(postgres))
+	if err != nil {
+		return nil, err
+	}
+
+	postgres.ReadWriteHost = pulumi.Sprintf("%s-rw.%s.svc.cluster.local", args.Name, args.Namespace)
+	postgres.ReadOnlyHost = pulumi.Sprintf("%s-ro.%s.svc.cluster.local", args.Name, args.Namespace)
+	postgres.SuperuserPassword = password.Result
+	postgres.OwnerSecret = pulumi.String(args.Name + "-app").ToStringOutput()
+	err = ctx.RegisterResourceOutputs(postgres, pulumi.Map{
+		"readWriteHost":     postgres.ReadWriteHost,
+		"readOnlyHost":      postgres.ReadOnlyHost,
+		"superuserPassword": postgres.SuperuserPassword,
+		"ownerSecret":       postgres.OwnerSecret,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return postgres, nil
+}
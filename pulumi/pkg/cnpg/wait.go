@@ -0,0 +1,41 @@
+package cnpg
+
+import (
+	"context"
+	"fmt"
+
+	"cluster-studio/pkg/kube"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var clusterResource = schema.GroupVersionResource{Group: "postgresql.cnpg.io", Version: "v1", Resource: "clusters"}
+
+// WaitForCluster polls until every instance of the Postgres cluster is ready
+// and returns the phase it reports
+func WaitForCluster(ctx context.Context, client dynamic.Interface, namespace, name string, opts kube.PollOptions) (string, error) {
+	if opts.Description == "" {
+		opts.Description = fmt.Sprintf("Postgres cluster %s/%s to become ready", namespace, name)
+	}
+
+	var phase string
+	err := kube.Poll(ctx, opts, func(ctx context.Context) (bool, string, error) {
+		cluster, err := client.Resource(clusterResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, "not found", nil
+		}
+		if err != nil {
+			return false, "", err
+		}
+		instances, _, _ := unstructured.NestedInt64(cluster.Object, "spec", "instances")
+		ready, _, _ := unstructured.NestedInt64(cluster.Object, "status", "readyInstances")
+		phase, _, _ = unstructured.NestedString(cluster.Object, "status", "phase")
+		status := fmt.Sprintf("%d/%d instances ready, %s", ready, instances, phase)
+		return instances > 0 && ready == instances, status, nil
+	})
+	return phase, err
+}
@@ -0,0 +1,269 @@
+package coredns
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"cluster-studio/pkg/kube"
+
+	appsv1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apps/v1"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// Namespace, ConfigMap and Deployment of CoreDNS, the same on Kind and k3d
+	Namespace  = "kube-system"
+	ConfigMap  = "coredns"
+	Deployment = "coredns"
+	// ChecksumAnnotation of the CoreDNS pods, changing with the rewrites so
+	// they roll out
+	ChecksumAnnotation = "home.lucena.cloud/corefile-checksum"
+
+	// The rewrites are kept between these lines of the root server block, to
+	// be replaced on the next deployment
+	beginMarker = "# BEGIN home rewrites"
+	endMarker   = "# END home rewrites"
+	// rootServer opens the server block the rewrites are added to
+	rootServer = ".:53 {"
+)
+
+// Hosts maps hostnames to the in-cluster Service (service.namespace) they
+// resolve to
+type Hosts map[string]string
+
+// Target returns the in-cluster name host is rewritten to
+func (h Hosts) Target(host string) string {
+	return h[host] + ".svc.cluster.local"
+}
+
+// Render returns corefile with a rewrite of each host in its root server
+// block, in place of those of a previous Render, which are removed with no
+// hosts
+func Render(corefile string, hosts Hosts) (string, error) {
+	var lines []string
+	skipping := false
+	for _, line := range strings.Split(corefile, "\n") {
+		switch strings.TrimSpace(line) {
+		case beginMarker:
+			skipping = true
+			continue
+		case endMarker:
+			skipping = false
+			continue
+		}
+		if !skipping {
+			lines = append(lines, line)
+		}
+	}
+	if skipping {
+		return "", fmt.Errorf("the Corefile has no %q after %q", endMarker, beginMarker)
+	}
+	if len(hosts) == 0 {
+		return strings.Join(lines, "\n"), nil
+	}
+
+	names := make([]string, 0, len(hosts))
+	for host := range hosts {
+		names = append(names, host)
+	}
+	sort.Strings(names)
+	block := []string{"    " + beginMarker}
+	for _, host := range names {
+		block = append(block, fmt.Sprintf("    rewrite stop name exact %s %s answer auto", host, hosts.Target(host)))
+	}
+	block = append(block, "    "+endMarker)
+
+	for i, line := range lines {
+		if strings.TrimSpace(line) == rootServer {
+			rendered := append(append(append([]string{}, lines[:i+1]...), block...), lines[i+1:]...)
+			return strings.Join(rendered, "\n"), nil
+		}
+	}
+	return "", fmt.Errorf("the Corefile has no %q server block", rootServer)
+}
+
+// Validate checks that corefile parses: its tokens form server blocks whose
+// braces are balanced, and quotes are closed
+func Validate(corefile string) error {
+	depth := 0
+	for n, line := range strings.Split(corefile, "\n") {
+		tokens, err := tokenize(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n+1, err)
+		}
+		for i, token := range tokens {
+			switch token {
+			case "{":
+				if i == 0 {
+					return fmt.Errorf("line %d: block opened without a server key or directive", n+1)
+				}
+				depth++
+			case "}":
+				if depth == 0 {
+					return fmt.Errorf("line %d: unexpected }", n+1)
+				}
+				depth--
+			default:
+				if depth == 0 && i == len(tokens)-1 {
+					return fmt.Errorf("line %d: %q is outside of a server block", n+1, token)
+				}
+			}
+		}
+	}
+	if depth != 0 {
+		return errors.New("unclosed block at the end of the Corefile")
+	}
+	return nil
+}
+
+// tokenize splits a Corefile line on spaces, quotes grouping tokens and #
+// starting a comment
+func tokenize(line string) ([]string, error) {
+	var tokens []string
+	var token strings.Builder
+	quoted := false
+	flush := func() {
+		if token.Len() > 0 {
+			tokens = append(tokens, token.String())
+			token.Reset()
+		}
+	}
+	for _, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+			token.WriteRune(c)
+		case c == '#':
+			flush()
+			return tokens, nil
+		case c == ' ' || c == '\t':
+			flush()
+		case c == '{' || c == '}':
+			flush()
+			tokens = append(tokens, string(c))
+		default:
+			token.WriteRune(c)
+		}
+	}
+	if quoted {
+		return nil, errors.New("unclosed quote")
+	}
+	flush()
+	return tokens, nil
+}
+
+// readCorefile returns the Corefile of the cluster
+func readCorefile(kubeconfig string) (string, error) {
+	client, err := kube.NewClientsetFromKubeconfig(kubeconfig)
+	if err != nil {
+		return "", err
+	}
+	configMap, err := client.CoreV1().ConfigMaps(Namespace).Get(context.Background(), ConfigMap, k8smetav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to read the Corefile: %w", err)
+	}
+	return configMap.Data["Corefile"], nil
+}
+
+// RewritesArgs configures the rewrites of CoreDNS
+type RewritesArgs struct {
+	Hosts Hosts
+	// Kubeconfig of the cluster (secret), used to read the current Corefile
+	Kubeconfig pulumi.StringInput
+}
+
+// Rewrites are the rewrites patched into the Corefile of the cluster
+type Rewrites struct {
+	pulumi.ResourceState
+
+	// Hostname -> in-cluster name it resolves to
+	Rewrites pulumi.StringMapOutput `pulumi:"rewrites"`
+}
+
+// NewRewrites patches the rewrites into the Corefile read from the cluster and
+// restarts CoreDNS when they change
+func NewRewrites(ctx *pulumi.Context, name string, args *RewritesArgs, opts ...pulumi.ResourceOption) (*Rewrites, error) {
+	rewrites := &Rewrites{}
+	err := ctx.RegisterComponentResource("home:coredns:Rewrites", name, rewrites, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	corefile := args.Kubeconfig.ToStringOutput().ApplyT(func(kubeconfig string) (string, error) {
+		current, err := readCorefile(kubeconfig)
+		if err != nil {
+			// The cluster the preview creates doesn't exist yet
+			if ctx.DryRun() {
+				return "", nil
+			}
+			return "", err
+		}
+		corefile, err := Render(current, args.Hosts)
+		if err != nil {
+			return "", err
+		}
+		if err := Validate(corefile); err != nil {
+			return "", fmt.Errorf("the Corefile with the rewrites doesn't parse: %w", err)
+		}
+		return corefile, nil
+	}).(pulumi.StringOutput)
+	// The Corefile of the cluster holds no secret
+	corefile = pulumi.Unsecret(corefile).(pulumi.StringOutput)
+
+	// Forced, kubeadm and k3s own the Corefile
+	patch, err := corev1.NewConfigMapPatch(ctx, fmt.Sprintf("%s-corefile", name), &corev1.ConfigMapPatchArgs{
+		Metadata: &metav1.ObjectMetaPatchArgs{
+			Name:        pulumi.String(ConfigMap),
+			Namespace:   pulumi.String(Namespace),
+			Annotations: pulumi.StringMap{"pulumi.com/patchForce": pulumi.String("true")},
+		},
+		Data: pulumi.StringMap{"Corefile": corefile},
+	}, pulumi.Parent(rewrites))
+	if err != nil {
+		return nil, err
+	}
+
+	checksum := corefile.ApplyT(func(corefile string) string {
+		sum := sha256.Sum256([]byte(corefile))
+		return hex.EncodeToString(sum[:])
+	}).(pulumi.StringOutput)
+	_, err = appsv1.NewDeploymentPatch(ctx, fmt.Sprintf("%s-rollout", name), &appsv1.DeploymentPatchArgs{
+		Metadata: &metav1.ObjectMetaPatchArgs{
+			Name:      pulumi.String(Deployment),
+			Namespace: pulumi.String(Namespace),
+		},
+		Spec: &appsv1.DeploymentSpecPatchArgs{
+			Template: &corev1.PodTemplateSpecPatchArgs{
+				Metadata: &metav1.ObjectMetaPatchArgs{
+					Annotations: pulumi.StringMap{ChecksumAnnotation: checksum},
+				},
+			},
+		},
+	}, pulumi.Parent(rewrites), pulumi.DependsOn([]pulumi.Resource{patch}))
+	if err != nil {
+		return nil, err
+	}
+
+	targets := pulumi.StringMap{}
+	for host := range args.Hosts {
+		targets[host] = pulumi.String(args.Hosts.Target(host))
+	}
+	rewrites.Rewrites = targets.ToStringMapOutput()
+	err = ctx.RegisterResourceOutputs(rewrites, pulumi.Map{
+		"rewrites": rewrites.Rewrites,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rewrites, nil
+}
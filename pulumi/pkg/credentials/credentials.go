@@ -0,0 +1,91 @@
+package credentials
+
+import (
+	"fmt"
+	"strconv"
+
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi-random/sdk/v4/go/random"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// NewPassword generates an alphanumeric password stored in the stack state,
+// so it is the same on every deployment. It is only generated again when
+// rotate changes.
+func NewPassword(ctx *pulumi.Context, name string, length, rotate int, opts ...pulumi.ResourceOption) (*random.RandomPassword, error) {
+	args := &random.RandomPasswordArgs{
+		Length:  pulumi.Int(length),
+		Special: pulumi.Bool(false),
+	}
+	// Without keepers until the first rotation, adding them replaces the
+	// passwords generated before rotations existed
+	if rotate != 0 {
+		args.Keepers = pulumi.StringMap{"rotate": pulumi.String(strconv.Itoa(rotate))}
+	}
+	return random.NewRandomPassword(ctx, name, args, opts...)
+}
+
+// Password is a password kept in a Secret for a service to read
+type Password struct {
+	// Name of the password, in the resource names and the Passwords output
+	Name string
+	// Secret created with the password under Key, in Namespace
+	Namespace string
+	Secret    string
+	Key       string
+}
+
+// CredentialsArgs configures the generated passwords
+type CredentialsArgs struct {
+	Passwords []Password
+	// Bumping it generates every password again
+	Rotate int
+}
+
+// Credentials are generated passwords and the Secrets holding them
+type Credentials struct {
+	pulumi.ResourceState
+
+	// Name -> password, secret
+	Passwords pulumi.StringMapOutput `pulumi:"passwords"`
+}
+
+// NewCredentials generates the passwords and creates their Secrets. The
+// namespaces must exist.
+func NewCredentials(ctx *pulumi.Context, name string, args *CredentialsArgs, opts ...pulumi.ResourceOption) (*Credentials, error) {
+	credentials := &Credentials{}
+	err := ctx.RegisterComponentResource("home:credentials:Credentials", name, credentials, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	passwords := pulumi.StringMap{}
+	for _, p := range args.Passwords {
+		password, err := NewPassword(ctx, fmt.Sprintf("%s-%s", name, p.Name), 32, args.Rotate, pulumi.Parent(credentials))
+		if err != nil {
+			return nil, err
+		}
+		_, err = corev1.NewSecret(ctx, fmt.Sprintf("%s-%s-secret", name, p.Name), &corev1.SecretArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:      pulumi.String(p.Secret),
+				Namespace: pulumi.String(p.Namespace),
+			},
+			StringData: pulumi.StringMap{p.Key: password.Result},
+		}, pulumi.Parent(credentials))
+		if err != nil {
+			return nil, fmt.Errorf("secret %s/%s: %w", p.Namespace, p.Secret, err)
+		}
+		passwords[p.Name] = password.Result
+	}
+
+	credentials.Passwords = pulumi.ToSecret(passwords.ToStringMapOutput()).(pulumi.StringMapOutput)
+	err = ctx.RegisterResourceOutputs(credentials, pulumi.Map{
+		"passwords": credentials.Passwords,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return credentials, nil
+}
@@ -0,0 +1,120 @@
+package externaldns
+
+import (
+	"fmt"
+
+	"cluster-studio/pkg/stacks"
+
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// ChartRepo is the Helm repository serving the external-dns chart
+	ChartRepo = "https://kubernetes-sigs.github.io/external-dns/"
+	// Namespace is where external-dns is installed
+	Namespace = "external-dns"
+)
+
+// Args configures external-dns with the Cloudflare provider
+type Args struct {
+	// Chart version
+	Version string
+	// Cloudflare API token (secret) with Zone:DNS:Edit permission
+	Token pulumi.StringInput
+	// Domains external-dns may manage records in
+	DomainFilters []string
+	// Owner ID written to the TXT registry records, unique per cluster
+	TXTOwnerID string
+	// "sync" also deletes records, "upsert-only" never does
+	Policy string
+}
+
+// ExternalDNS manages DNS records for the Services and Ingresses of the cluster
+type ExternalDNS struct {
+	pulumi.ResourceState
+
+	// Owner ID of the TXT registry records
+	TXTOwnerID pulumi.StringOutput `pulumi:"txtOwnerId"`
+	// Domains records are managed in
+	DomainFilters pulumi.StringArrayOutput `pulumi:"domainFilters"`
+}
+
+// New creates the external-dns namespace and token Secret and installs the
+// chart with the Cloudflare provider
+func New(ctx *pulumi.Context, name string, args *Args, opts ...pulumi.ResourceOption) (*ExternalDNS, error) {
+	externalDNS := &ExternalDNS{}
+	err := ctx.RegisterComponentResource("home:externaldns:ExternalDNS", name, externalDNS, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if args.Policy != "sync" && args.Policy != "upsert-only" {
+		return nil, fmt.Errorf("invalid external-dns policy %q, use \"sync\" or \"upsert-only\"", args.Policy)
+	}
+
+	namespace, err := corev1.NewNamespace(ctx, fmt.Sprintf("%s-namespace", name), &corev1.NamespaceArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name: pulumi.String(Namespace),
+		},
+	}, pulumi.Parent(externalDNS))
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := corev1.NewSecret(ctx, fmt.Sprintf("%s-token", name), &corev1.SecretArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String("cloudflare-api-token"),
+			Namespace: namespace.Metadata.Name(),
+		},
+		StringData: pulumi.StringMap{
+			"api-token": args.Token,
+		},
+	}, pulumi.Parent(externalDNS))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = helmv3.NewRelease(ctx, stacks.Name(ctx, "external-dns"), &helmv3.ReleaseArgs{
+		Name:           pulumi.String("external-dns"),
+		Chart:          pulumi.String("external-dns"),
+		Version:        pulumi.String(args.Version),
+		Namespace:      namespace.Metadata.Name(),
+		RepositoryOpts: helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+		Values: pulumi.Map{
+			"provider":      pulumi.Map{"name": pulumi.String("cloudflare")},
+			"sources":       pulumi.ToStringArray([]string{"service", "ingress"}),
+			"domainFilters": pulumi.ToStringArray(args.DomainFilters),
+			"txtOwnerId":    pulumi.String(args.TXTOwnerID),
+			"policy":        pulumi.String(args.Policy),
+			"env": pulumi.Array{
+				pulumi.Map{
+					"name": pulumi.String("CF_API_TOKEN"),
+					"valueFrom": pulumi.Map{
+						"secretKeyRef": pulumi.Map{
+							"name": secret.Metadata.Name(),
+							"key":  pulumi.String("api-token"),
+						},
+					},
+				},
+			},
+		},
+	}, pulumi.Parent(externalDNS))
+	if err != nil {
+		return nil, err
+	}
+
+	externalDNS.TXTOwnerID = pulumi.String(args.TXTOwnerID).ToStringOutput()
+	externalDNS.DomainFilters = pulumi.ToStringArray(args.DomainFilters).ToStringArrayOutput()
+	err = ctx.RegisterResourceOutputs(externalDNS, pulumi.Map{
+		"txtOwnerId":    externalDNS.TXTOwnerID,
+		"domainFilters": externalDNS.DomainFilters,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return externalDNS, nil
+}
@@ -0,0 +1,226 @@
+package externalsecrets
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	rbacv1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/rbac/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// ChartRepo is the Helm repository serving the External Secrets chart
+	ChartRepo = "https://charts.external-secrets.io"
+	// Namespace is where the operator is installed and the values of the
+	// managed secrets are kept
+	Namespace = "external-secrets"
+	// StoreName is the ClusterSecretStore serving the managed secrets
+	StoreName = "pulumi"
+	// storeReader is the ServiceAccount the store reads the values with
+	storeReader = "pulumi-store-reader"
+)
+
+// crds must be Established before the ClusterSecretStore can be created
+var crds = []string{
+	"clustersecretstores.external-secrets.io",
+	"externalsecrets.external-secrets.io",
+}
+
+// OperatorArgs configures the External Secrets Operator
+type OperatorArgs struct {
+	// Chart version
+	Version string
+	// Kubeconfig of the cluster (secret), used to wait for the CRDs and the
+	// store
+	Kubeconfig pulumi.StringInput
+	// How long to wait for the operator and the store
+	Timeout time.Duration
+}
+
+// Operator is the External Secrets Operator with the ClusterSecretStore of
+// the managed secrets
+type Operator struct {
+	pulumi.ResourceState
+
+	// Name of the ClusterSecretStore
+	Store pulumi.StringOutput `pulumi:"store"`
+}
+
+// NewOperator installs the External Secrets Operator with Helm and a
+// ClusterSecretStore reading the Secrets of its namespace, where
+// NewManagedSecrets keeps the values from Pulumi config. Its status is
+// recorded in a ConfigMap of kube-system once the store is Ready, so
+// resources depending on the component find it serving.
+func NewOperator(ctx *pulumi.Context, name string, args *OperatorArgs, opts ...pulumi.ResourceOption) (*Operator, error) {
+	operator := &Operator{}
+	err := ctx.RegisterComponentResource("home:externalsecrets:Operator", name, operator, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	logf := func(format string, a ...interface{}) {
+		_ = ctx.Log.Info(fmt.Sprintf(format, a...), &pulumi.LogArgs{Resource: operator})
+	}
+
+	release, err := helmv3.NewRelease(ctx, stacks.Name(ctx, "external-secrets"), &helmv3.ReleaseArgs{
+		Name:            pulumi.String("external-secrets"),
+		Chart:           pulumi.String("external-secrets"),
+		Version:         pulumi.String(args.Version),
+		Namespace:       pulumi.String(Namespace),
+		CreateNamespace: pulumi.Bool(true),
+		RepositoryOpts:  helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+		Timeout:         pulumi.Int(int(args.Timeout.Seconds())),
+	}, pulumi.Parent(operator))
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolves to the release once its CRDs are Established
+	established := pulumi.All(release.ID(), args.Kubeconfig).ApplyT(func(values []interface{}) ([]pulumi.Resource, error) {
+		if ctx.DryRun() {
+			return []pulumi.Resource{release}, nil
+		}
+		client, err := kube.NewDynamicClientFromKubeconfig(values[1].(string))
+		if err != nil {
+			return nil, err
+		}
+		err = kube.WaitForCRDsEstablished(context.Background(), client, crds, kube.PollOptions{
+			Description: "External Secrets CRDs to become Established",
+			Timeout:     args.Timeout,
+			Logf:        logf,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []pulumi.Resource{release}, nil
+	}).(pulumi.ResourceArrayOutput)
+
+	// The store only reads the Secrets of the operator namespace
+	reader, err := corev1.NewServiceAccount(ctx, fmt.Sprintf("%s-store-reader", name), &corev1.ServiceAccountArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(storeReader),
+			Namespace: pulumi.String(Namespace),
+		},
+	}, pulumi.Parent(operator), pulumi.DependsOn([]pulumi.Resource{release}))
+	if err != nil {
+		return nil, err
+	}
+	role, err := rbacv1.NewRole(ctx, fmt.Sprintf("%s-store-reader", name), &rbacv1.RoleArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(storeReader),
+			Namespace: pulumi.String(Namespace),
+		},
+		Rules: rbacv1.PolicyRuleArray{
+			rbacv1.PolicyRuleArgs{
+				ApiGroups: pulumi.StringArray{pulumi.String("")},
+				Resources: pulumi.StringArray{pulumi.String("secrets")},
+				Verbs:     pulumi.StringArray{pulumi.String("get"), pulumi.String("list"), pulumi.String("watch")},
+			},
+		},
+	}, pulumi.Parent(operator), pulumi.DependsOn([]pulumi.Resource{release}))
+	if err != nil {
+		return nil, err
+	}
+	binding, err := rbacv1.NewRoleBinding(ctx, fmt.Sprintf("%s-store-reader", name), &rbacv1.RoleBindingArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(storeReader),
+			Namespace: pulumi.String(Namespace),
+		},
+		RoleRef: rbacv1.RoleRefArgs{
+			ApiGroup: pulumi.String("rbac.authorization.k8s.io"),
+			Kind:     pulumi.String("Role"),
+			Name:     role.Metadata.Name().Elem(),
+		},
+		Subjects: rbacv1.SubjectArray{
+			rbacv1.SubjectArgs{
+				Kind:      pulumi.String("ServiceAccount"),
+				Name:      reader.Metadata.Name().Elem(),
+				Namespace: pulumi.String(Namespace),
+			},
+		},
+	}, pulumi.Parent(operator))
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-store", name), &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String("external-secrets.io/v1"),
+		Kind:       pulumi.String("ClusterSecretStore"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Name: pulumi.String(StoreName),
+		},
+		OtherFields: kubernetes.UntypedArgs{
+			"spec": pulumi.Map{
+				"provider": pulumi.Map{
+					"kubernetes": pulumi.Map{
+						"remoteNamespace": pulumi.String(Namespace),
+						"server": pulumi.Map{
+							"caProvider": pulumi.Map{
+								"type":      pulumi.String("ConfigMap"),
+								"name":      pulumi.String("kube-root-ca.crt"),
+								"key":       pulumi.String("ca.crt"),
+								"namespace": pulumi.String(Namespace),
+							},
+						},
+						"auth": pulumi.Map{
+							"serviceAccount": pulumi.Map{
+								"name":      reader.Metadata.Name().Elem(),
+								"namespace": pulumi.String(Namespace),
+							},
+						},
+					},
+				},
+			},
+		},
+	}, pulumi.Parent(operator), pulumi.DependsOn([]pulumi.Resource{binding}), pulumi.DependsOnInputs(established))
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolves once the store is Ready
+	status := pulumi.All(store.ID(), args.Kubeconfig).ApplyT(func(values []interface{}) (string, error) {
+		if ctx.DryRun() {
+			return "", nil
+		}
+		client, err := kube.NewDynamicClientFromKubeconfig(values[1].(string))
+		if err != nil {
+			return "", err
+		}
+		return WaitForStore(context.Background(), client, StoreName, kube.PollOptions{
+			Timeout: args.Timeout,
+			Logf:    logf,
+		})
+	}).(pulumi.StringOutput)
+
+	_, err = corev1.NewConfigMap(ctx, fmt.Sprintf("%s-report", name), &corev1.ConfigMapArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(name),
+			Namespace: pulumi.String("kube-system"),
+		},
+		Data: pulumi.StringMap{
+			"store": status,
+		},
+	}, pulumi.Parent(operator))
+	if err != nil {
+		return nil, err
+	}
+
+	operator.Store = store.Metadata.Name().Elem()
+	err = ctx.RegisterResourceOutputs(operator, pulumi.Map{
+		"store": operator.Store,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return operator, nil
+}
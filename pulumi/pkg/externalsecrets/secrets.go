@@ -0,0 +1,122 @@
+package externalsecrets
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Secret is a Kubernetes Secret whose values come from Pulumi config
+type Secret struct {
+	// Name and namespace of the Secret
+	Name      string
+	Namespace string
+	// Keys and values (secret)
+	Data pulumi.StringMapInput
+}
+
+// ManagedSecretsArgs configures the managed secrets
+type ManagedSecretsArgs struct {
+	Secrets []Secret
+	// ClusterSecretStore the Secrets are pulled from, empty to create them
+	// directly in their namespace
+	Store pulumi.StringInput
+	// Resources each Secret waits for, by namespace (e.g. the Namespace)
+	NamespaceDeps map[string][]pulumi.Resource
+}
+
+// ManagedSecrets are the Secrets materialized from Pulumi config
+type ManagedSecrets struct {
+	pulumi.ResourceState
+
+	// namespace/name of every Secret, without the values
+	Names pulumi.StringArrayOutput `pulumi:"names"`
+}
+
+// NewManagedSecrets materializes each Secret from config, one resource per
+// Secret so changing a value only updates that Secret. With a store, the
+// values are kept in the operator namespace and an ExternalSecret in the
+// target namespace pulls them, so the cluster owns the resulting Secret.
+func NewManagedSecrets(ctx *pulumi.Context, name string, args *ManagedSecretsArgs, opts ...pulumi.ResourceOption) (*ManagedSecrets, error) {
+	managed := &ManagedSecrets{}
+	err := ctx.RegisterComponentResource("home:externalsecrets:ManagedSecrets", name, managed, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var names pulumi.StringArray
+	for _, secret := range args.Secrets {
+		ref := fmt.Sprintf("%s/%s", secret.Namespace, secret.Name)
+		deps := pulumi.DependsOn(args.NamespaceDeps[secret.Namespace])
+
+		if args.Store == nil {
+			_, err := corev1.NewSecret(ctx, fmt.Sprintf("%s-%s-%s", name, secret.Namespace, secret.Name), &corev1.SecretArgs{
+				Metadata: &metav1.ObjectMetaArgs{
+					Name:      pulumi.String(secret.Name),
+					Namespace: pulumi.String(secret.Namespace),
+				},
+				StringData: secret.Data,
+			}, pulumi.Parent(managed), deps)
+			if err != nil {
+				return nil, fmt.Errorf("managed secret %s: %w", ref, err)
+			}
+			names = append(names, pulumi.String(ref))
+			continue
+		}
+
+		// Namespaces have no dots, so namespace.name can't clash
+		key := fmt.Sprintf("%s.%s", secret.Namespace, secret.Name)
+		source, err := corev1.NewSecret(ctx, fmt.Sprintf("%s-%s-%s-source", name, secret.Namespace, secret.Name), &corev1.SecretArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:      pulumi.String(key),
+				Namespace: pulumi.String(Namespace),
+			},
+			StringData: secret.Data,
+		}, pulumi.Parent(managed))
+		if err != nil {
+			return nil, fmt.Errorf("managed secret %s: %w", ref, err)
+		}
+		_, err = apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-%s-%s", name, secret.Namespace, secret.Name), &apiextensions.CustomResourceArgs{
+			ApiVersion: pulumi.String("external-secrets.io/v1"),
+			Kind:       pulumi.String("ExternalSecret"),
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:      pulumi.String(secret.Name),
+				Namespace: pulumi.String(secret.Namespace),
+			},
+			OtherFields: kubernetes.UntypedArgs{
+				"spec": pulumi.Map{
+					"refreshInterval": pulumi.String("1m"),
+					"secretStoreRef": pulumi.Map{
+						"kind": pulumi.String("ClusterSecretStore"),
+						"name": args.Store,
+					},
+					"target": pulumi.Map{
+						"name":           pulumi.String(secret.Name),
+						"creationPolicy": pulumi.String("Owner"),
+					},
+					"dataFrom": pulumi.Array{
+						pulumi.Map{"extract": pulumi.Map{"key": pulumi.String(key)}},
+					},
+				},
+			},
+		}, pulumi.Parent(managed), deps, pulumi.DependsOn([]pulumi.Resource{source}))
+		if err != nil {
+			return nil, fmt.Errorf("managed secret %s: %w", ref, err)
+		}
+		names = append(names, pulumi.String(ref))
+	}
+
+	managed.Names = names.ToStringArrayOutput()
+	err = ctx.RegisterResourceOutputs(managed, pulumi.Map{
+		"names": managed.Names,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return managed, nil
+}
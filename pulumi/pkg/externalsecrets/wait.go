@@ -0,0 +1,46 @@
+package externalsecrets
+
+import (
+	"context"
+	"fmt"
+
+	"cluster-studio/pkg/kube"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var storeResource = schema.GroupVersionResource{Group: "external-secrets.io", Version: "v1", Resource: "clustersecretstores"}
+
+// WaitForStore polls until the ClusterSecretStore reports Ready=True and
+// returns the message of the condition
+func WaitForStore(ctx context.Context, client dynamic.Interface, name string, opts kube.PollOptions) (string, error) {
+	if opts.Description == "" {
+		opts.Description = fmt.Sprintf("ClusterSecretStore %s to become Ready", name)
+	}
+
+	var message string
+	err := kube.Poll(ctx, opts, func(ctx context.Context) (bool, string, error) {
+		store, err := client.Resource(storeResource).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, "not found", nil
+		}
+		if err != nil {
+			return false, "", err
+		}
+		conditions, _, _ := unstructured.NestedSlice(store.Object, "status", "conditions")
+		for _, c := range conditions {
+			cond, ok := c.(map[string]interface{})
+			if !ok || cond["type"] != "Ready" {
+				continue
+			}
+			message, _ = cond["message"].(string)
+			return cond["status"] == "True", message, nil
+		}
+		return false, "no Ready condition", nil
+	})
+	return message, err
+}
@@ -0,0 +1,113 @@
+package flagger
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Analysis is the canary analysis shared by the Canaries created from config
+type Analysis struct {
+	// Time between two analysis steps (e.g. "1m")
+	Interval string `json:"interval"`
+	// Failed checks before the canary is rolled back
+	Threshold int `json:"threshold"`
+	// Traffic weight of the canary at which it is promoted, and the weight
+	// added at each step (percent)
+	MaxWeight  int `json:"maxWeight"`
+	StepWeight int `json:"stepWeight"`
+	// Minimum request success rate (percent)
+	MinSuccessRate float64 `json:"minSuccessRate"`
+	// Maximum request duration (P99, milliseconds)
+	MaxRequestDuration int `json:"maxRequestDuration"`
+}
+
+// DefaultAnalysis is the analysis used when none is configured
+var DefaultAnalysis = Analysis{
+	Interval:           "1m",
+	Threshold:          5,
+	MaxWeight:          50,
+	StepWeight:         10,
+	MinSuccessRate:     99,
+	MaxRequestDuration: 500,
+}
+
+// Target is a Deployment progressively delivered by a Canary
+type Target struct {
+	Namespace  string `json:"namespace"`
+	Deployment string `json:"deployment"`
+	// Port of the Service Flagger generates for the Deployment
+	Port int `json:"port"`
+}
+
+// spec assembles spec.analysis for target. With a load tester the analysis
+// generates traffic against the canary, without which the metrics stay empty
+// on an idle cluster.
+func (a Analysis) spec(target Target, loadtesterURL string) map[string]interface{} {
+	analysis := map[string]interface{}{
+		"interval":   a.Interval,
+		"threshold":  a.Threshold,
+		"maxWeight":  a.MaxWeight,
+		"stepWeight": a.StepWeight,
+		"metrics": []interface{}{
+			map[string]interface{}{
+				"name":           "request-success-rate",
+				"thresholdRange": map[string]interface{}{"min": a.MinSuccessRate},
+				"interval":       "1m",
+			},
+			map[string]interface{}{
+				"name":           "request-duration",
+				"thresholdRange": map[string]interface{}{"max": a.MaxRequestDuration},
+				"interval":       "30s",
+			},
+		},
+	}
+	if loadtesterURL != "" {
+		analysis["webhooks"] = []interface{}{
+			map[string]interface{}{
+				"name":    "load-test",
+				"type":    "rollout",
+				"url":     loadtesterURL,
+				"timeout": "5s",
+				"metadata": map[string]interface{}{
+					"cmd": fmt.Sprintf("hey -z 1m -q 10 -c 2 http://%s-canary.%s:%d/",
+						target.Deployment, target.Namespace, target.Port),
+				},
+			},
+		}
+	}
+	return analysis
+}
+
+// canarySpec is the spec of the Canary of target
+func canarySpec(target Target, analysis Analysis, loadtesterURL string) map[string]interface{} {
+	return map[string]interface{}{
+		"targetRef": map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"name":       target.Deployment,
+		},
+		"service": map[string]interface{}{
+			"port": target.Port,
+		},
+		"analysis": analysis.spec(target, loadtesterURL),
+	}
+}
+
+// newCanary creates the Canary of target
+func newCanary(ctx *pulumi.Context, name string, target Target, analysis Analysis, loadtesterURL string, opts ...pulumi.ResourceOption) (*apiextensions.CustomResource, error) {
+	return apiextensions.NewCustomResource(ctx, name, &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String("flagger.app/v1beta1"),
+		Kind:       pulumi.String("Canary"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(target.Deployment),
+			Namespace: pulumi.String(target.Namespace),
+		},
+		OtherFields: kubernetes.UntypedArgs{
+			"spec": pulumi.ToMap(canarySpec(target, analysis, loadtesterURL)),
+		},
+	}, opts...)
+}
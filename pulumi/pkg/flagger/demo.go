@@ -0,0 +1,193 @@
+package flagger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"cluster-studio/pkg/kube"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// DemoNamespace is where the canary demo runs, deleted afterwards
+	DemoNamespace = "canary-demo"
+
+	// The demo rolls podinfo from demoFromTag to demoToTag
+	demoImage   = "ghcr.io/stefanprodan/podinfo"
+	demoFromTag = "6.9.1"
+	demoToTag   = "6.9.2"
+	demoName    = "podinfo"
+	demoPort    = 9898
+)
+
+var canaryResource = schema.GroupVersionResource{Group: "flagger.app", Version: "v1beta1", Resource: "canaries"}
+
+// DemoOptions configures RunDemo
+type DemoOptions struct {
+	// Mesh Flagger shifts traffic with, as in InstallArgs
+	MeshProvider string
+	// Load tester driving traffic to the canary
+	LoadtesterURL string
+	// Analysis of the demo Canary
+	Analysis Analysis
+	// How long the demo may take, promotion included
+	Timeout time.Duration
+	// Leave the demo namespace in place afterwards
+	Keep bool
+	// Logf receives the progress of the canary (optional)
+	Logf kube.Logf
+}
+
+// DemoResult is the outcome of the canary demo
+type DemoResult struct {
+	// Last phase of the Canary: "Succeeded" once promoted, or "Failed"
+	Phase string `json:"phase"`
+	// Tags the canary rolled from and to
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Failed checks of the analysis and the last condition message
+	FailedChecks int64  `json:"failedChecks"`
+	Message      string `json:"message,omitempty"`
+	Duration     string `json:"duration"`
+}
+
+// RunDemo deploys podinfo with a Canary into DemoNamespace, rolls it to a new
+// tag once Flagger initialized it and waits for the canary to be promoted. A
+// failed canary returns the result along with an error. The namespace is
+// deleted afterwards unless opts.Keep is set.
+func RunDemo(ctx context.Context, client kubernetes.Interface, dynamicClient dynamic.Interface, opts DemoOptions) (*DemoResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	start := time.Now()
+	result := &DemoResult{From: demoFromTag, To: demoToTag}
+
+	_, err := client.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: DemoNamespace, Annotations: meshInjection(opts.MeshProvider)},
+	}, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return nil, fmt.Errorf("failed to create namespace %s: %w", DemoNamespace, err)
+	}
+	if !opts.Keep {
+		defer func() {
+			_ = client.CoreV1().Namespaces().Delete(context.Background(), DemoNamespace, metav1.DeleteOptions{})
+		}()
+	}
+
+	if err := createDemoDeployment(ctx, client); err != nil {
+		return nil, err
+	}
+	target := Target{Namespace: DemoNamespace, Deployment: demoName, Port: demoPort}
+	canary := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "flagger.app/v1beta1",
+		"kind":       "Canary",
+		"metadata":   map[string]interface{}{"name": demoName, "namespace": DemoNamespace},
+		"spec":       canarySpec(target, opts.Analysis, opts.LoadtesterURL),
+	}}
+	_, err = dynamicClient.Resource(canaryResource).Namespace(DemoNamespace).Create(ctx, canary, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return nil, fmt.Errorf("failed to create the demo Canary: %w", err)
+	}
+
+	// Flagger creates the primary before it watches the Deployment for changes
+	err = waitForPhase(ctx, dynamicClient, result, opts, "Initialized", "Succeeded")
+	if err != nil {
+		return nil, err
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{"template": map[string]interface{}{"spec": map[string]interface{}{
+			"containers": []interface{}{map[string]interface{}{"name": demoName, "image": demoImage + ":" + demoToTag}},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = client.AppsV1().Deployments(DemoNamespace).Patch(ctx, demoName, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to roll the demo to %s: %w", demoToTag, err)
+	}
+
+	err = waitForPhase(ctx, dynamicClient, result, opts, "Succeeded")
+	result.Duration = time.Since(start).Round(time.Second).String()
+	return result, err
+}
+
+// createDemoDeployment creates podinfo at demoFromTag
+func createDemoDeployment(ctx context.Context, client kubernetes.Interface) error {
+	labels := map[string]string{"app.kubernetes.io/name": demoName}
+	replicas := int32(1)
+	_, err := client.AppsV1().Deployments(DemoNamespace).Create(ctx, &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: demoName},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:    demoName,
+						Image:   demoImage + ":" + demoFromTag,
+						Command: []string{"./podinfo", fmt.Sprintf("--port=%d", demoPort)},
+						Ports:   []corev1.ContainerPort{{Name: "http", ContainerPort: demoPort}},
+					}},
+				},
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create the demo Deployment: %w", err)
+	}
+	return nil
+}
+
+// waitForPhase polls the demo Canary until it reaches one of phases, and
+// fails as soon as it is Failed
+func waitForPhase(ctx context.Context, client dynamic.Interface, result *DemoResult, opts DemoOptions, phases ...string) error {
+	deadline, _ := ctx.Deadline()
+	err := kube.Poll(ctx, kube.PollOptions{
+		Description: fmt.Sprintf("Canary %s/%s to be %s", DemoNamespace, demoName, phases[0]),
+		Interval:    5 * time.Second,
+		Timeout:     time.Until(deadline),
+		Logf:        opts.Logf,
+	}, func(ctx context.Context) (bool, string, error) {
+		canary, err := client.Resource(canaryResource).Namespace(DemoNamespace).Get(ctx, demoName, metav1.GetOptions{})
+		if err != nil {
+			return false, "", err
+		}
+		result.Phase, _, _ = unstructured.NestedString(canary.Object, "status", "phase")
+		result.FailedChecks, _, _ = unstructured.NestedInt64(canary.Object, "status", "failedChecks")
+		weight, _, _ := unstructured.NestedInt64(canary.Object, "status", "canaryWeight")
+		conditions, _, _ := unstructured.NestedSlice(canary.Object, "status", "conditions")
+		for _, condition := range conditions {
+			if c, ok := condition.(map[string]interface{}); ok {
+				result.Message, _ = c["message"].(string)
+			}
+		}
+		if result.Phase == "Failed" {
+			return true, "", nil
+		}
+		for _, phase := range phases {
+			if result.Phase == phase {
+				return true, "", nil
+			}
+		}
+		return false, fmt.Sprintf("%s, canary weight %d%%, %d failed checks", result.Phase, weight, result.FailedChecks), nil
+	})
+	if err != nil {
+		return err
+	}
+	if result.Phase == "Failed" {
+		return fmt.Errorf("canary %s/%s failed after %d failed checks: %s", DemoNamespace, demoName, result.FailedChecks, result.Message)
+	}
+	return nil
+}
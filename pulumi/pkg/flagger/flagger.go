@@ -0,0 +1,178 @@
+package flagger
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/stacks"
+
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// ChartRepo is the Helm repository serving the flagger and loadtester charts
+	ChartRepo = "https://flagger.app"
+	// Namespace is where Flagger and its load tester are installed
+	Namespace = "flagger-system"
+	// LoadtesterName is the release and Service name of the load tester
+	LoadtesterName = "flagger-loadtester"
+)
+
+// crds must be Established before Canaries can be created
+var crds = []string{
+	"canaries.flagger.app",
+	"metrictemplates.flagger.app",
+	"alertproviders.flagger.app",
+}
+
+// InstallArgs configures the Flagger installation
+type InstallArgs struct {
+	// Chart versions
+	Version           string
+	LoadtesterVersion string
+	// Mesh Flagger shifts traffic with: "linkerd" (SMI TrafficSplit), "istio",
+	// or "kubernetes" for blue/green deployments without a mesh
+	MeshProvider string
+	// Prometheus the canary metrics are queried from
+	MetricsServer string
+	// Install the load tester the analysis webhooks call
+	Loadtester bool
+	// Analysis of the Canaries
+	Analysis Analysis
+	// Deployments to create a Canary for
+	Canaries []Target
+	// Kubeconfig of the cluster (secret), used to wait for the CRDs
+	Kubeconfig pulumi.StringInput
+	// How long to wait for each release to be ready
+	Timeout time.Duration
+}
+
+// Install is Flagger with its optional load tester and the Canaries
+type Install struct {
+	pulumi.ResourceState
+
+	// Chart version, resolved once Flagger is installed
+	Version pulumi.StringOutput `pulumi:"version"`
+	// In-cluster URL of the load tester, resolved once it is installed, empty
+	// without it
+	LoadtesterURL pulumi.StringOutput `pulumi:"loadtesterUrl"`
+}
+
+// NewInstall installs Flagger for the configured mesh and, once its CRDs are
+// Established, a Canary with the shared analysis for every target
+func NewInstall(ctx *pulumi.Context, name string, args *InstallArgs, opts ...pulumi.ResourceOption) (*Install, error) {
+	install := &Install{}
+	err := ctx.RegisterComponentResource("home:flagger:Install", name, install, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace, err := corev1.NewNamespace(ctx, fmt.Sprintf("%s-namespace", name), &corev1.NamespaceArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name: pulumi.String(Namespace),
+		},
+	}, pulumi.Parent(install))
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := helmv3.NewRelease(ctx, fmt.Sprintf("%s-controller", name), &helmv3.ReleaseArgs{
+		Name:           pulumi.String("flagger"),
+		Chart:          pulumi.String("flagger"),
+		Version:        pulumi.String(args.Version),
+		Namespace:      namespace.Metadata.Name(),
+		RepositoryOpts: helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+		Timeout:        pulumi.Int(int(args.Timeout.Seconds())),
+		Values: pulumi.Map{
+			"meshProvider":  pulumi.String(args.MeshProvider),
+			"metricsServer": pulumi.String(args.MetricsServer),
+			"crd":           pulumi.Map{"create": pulumi.Bool(true)},
+		},
+	}, pulumi.Parent(install))
+	if err != nil {
+		return nil, err
+	}
+
+	var loadtesterURL string
+	install.LoadtesterURL = pulumi.String("").ToStringOutput()
+	if args.Loadtester {
+		loadtesterURL = fmt.Sprintf("http://%s.%s/", LoadtesterName, Namespace)
+		loadtester, err := helmv3.NewRelease(ctx, stacks.Name(ctx, LoadtesterName), &helmv3.ReleaseArgs{
+			Name:           pulumi.String(LoadtesterName),
+			Chart:          pulumi.String("loadtester"),
+			Version:        pulumi.String(args.LoadtesterVersion),
+			Namespace:      namespace.Metadata.Name(),
+			RepositoryOpts: helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+			Timeout:        pulumi.Int(int(args.Timeout.Seconds())),
+			Values: pulumi.Map{
+				// The generated load must go through the mesh to show in the metrics
+				"podAnnotations": pulumi.ToStringMap(meshInjection(args.MeshProvider)),
+			},
+		}, pulumi.Parent(install))
+		if err != nil {
+			return nil, err
+		}
+		install.LoadtesterURL = loadtester.ID().ApplyT(func(pulumi.ID) string {
+			return loadtesterURL
+		}).(pulumi.StringOutput)
+	}
+
+	// Resolves to the release once the CRDs are Established
+	ready := pulumi.All(release.Status, args.Kubeconfig).ApplyT(func(values []interface{}) ([]pulumi.Resource, error) {
+		if ctx.DryRun() {
+			return []pulumi.Resource{release}, nil
+		}
+		dynamicClient, err := kube.NewDynamicClientFromKubeconfig(values[1].(string))
+		if err != nil {
+			return nil, err
+		}
+		err = kube.WaitForCRDsEstablished(context.Background(), dynamicClient, crds, kube.PollOptions{
+			Description: "Flagger CRDs to become Established",
+			Timeout:     args.Timeout,
+			Logf: func(format string, a ...interface{}) {
+				_ = ctx.Log.Info(fmt.Sprintf(format, a...), &pulumi.LogArgs{Resource: install})
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []pulumi.Resource{release}, nil
+	}).(pulumi.ResourceArrayOutput)
+
+	for _, target := range args.Canaries {
+		_, err = newCanary(ctx, fmt.Sprintf("%s-canary-%s-%s", name, target.Namespace, target.Deployment),
+			target, args.Analysis, loadtesterURL, pulumi.Parent(install), pulumi.DependsOnInputs(ready))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	install.Version = release.Status.ApplyT(func(helmv3.ReleaseStatus) string {
+		return args.Version
+	}).(pulumi.StringOutput)
+	err = ctx.RegisterResourceOutputs(install, pulumi.Map{
+		"version":       install.Version,
+		"loadtesterUrl": install.LoadtesterURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return install, nil
+}
+
+// meshInjection returns the pod annotations adding the proxy of meshProvider
+func meshInjection(meshProvider string) map[string]string {
+	switch meshProvider {
+	case "linkerd":
+		return map[string]string{"linkerd.io/inject": "enabled"}
+	case "istio":
+		return map[string]string{"sidecar.istio.io/inject": "true"}
+	}
+	return map[string]string{}
+}
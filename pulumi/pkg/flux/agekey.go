@@ -0,0 +1,170 @@
+package flux
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"fmt"
+	"strings"
+)
+
+const (
+	// SOPSSecretName is the Secret holding the age key the root
+	// Kustomization decrypts with
+	SOPSSecretName = "sops-age"
+	// ageIdentityPrefix and ageRecipientPrefix are the Bech32 prefixes of age
+	// private and public keys
+	ageIdentityPrefix  = "age-secret-key-"
+	ageRecipientPrefix = "age"
+)
+
+// AgeKey is an age X25519 key pair used by SOPS
+type AgeKey struct {
+	// Private key, AGE-SECRET-KEY-1...
+	Identity string `json:"identity"`
+	// Public key to encrypt with (sops --age), age1...
+	Recipient string `json:"recipient"`
+}
+
+// GenerateAgeKey creates a new age key pair, as age-keygen does
+func GenerateAgeKey() (*AgeKey, error) {
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate age key: %w", err)
+	}
+	identity := strings.ToUpper(bech32Encode(ageIdentityPrefix, private.Bytes()))
+	return &AgeKey{
+		Identity:  identity,
+		Recipient: bech32Encode(ageRecipientPrefix, private.PublicKey().Bytes()),
+	}, nil
+}
+
+// AgeRecipient returns the public key of an age private key. Comment lines
+// of an age-keygen key file are ignored.
+func AgeRecipient(identity string) (string, error) {
+	for _, line := range strings.Split(identity, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		prefix, data, err := bech32Decode(line)
+		if err != nil || prefix != ageIdentityPrefix {
+			return "", fmt.Errorf("not an age private key (AGE-SECRET-KEY-1...)")
+		}
+		private, err := ecdh.X25519().NewPrivateKey(data)
+		if err != nil {
+			return "", fmt.Errorf("invalid age private key: %w", err)
+		}
+		return bech32Encode(ageRecipientPrefix, private.PublicKey().Bytes()), nil
+	}
+	return "", fmt.Errorf("no age private key found")
+}
+
+// bech32Charset maps 5-bit values to Bech32 characters
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// bech32Encode encodes data as lowercase Bech32 (BIP 173) without the 90
+// character limit, which age keys exceed
+func bech32Encode(prefix string, data []byte) string {
+	values := convertBits(data, 8, 5, true)
+	checksum := bech32Checksum(prefix, values)
+
+	var b strings.Builder
+	b.WriteString(prefix)
+	b.WriteByte('1')
+	for _, v := range append(values, checksum...) {
+		b.WriteByte(bech32Charset[v])
+	}
+	return b.String()
+}
+
+// bech32Decode decodes a Bech32 string of any case and verifies its checksum
+func bech32Decode(s string) (string, []byte, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, fmt.Errorf("mixed case")
+	}
+	s = strings.ToLower(s)
+	separator := strings.LastIndexByte(s, '1')
+	if separator < 1 || separator+7 > len(s) {
+		return "", nil, fmt.Errorf("invalid separator position")
+	}
+	prefix := s[:separator]
+	var values []byte
+	for _, c := range s[separator+1:] {
+		v := strings.IndexRune(bech32Charset, c)
+		if v < 0 {
+			return "", nil, fmt.Errorf("invalid character %q", c)
+		}
+		values = append(values, byte(v))
+	}
+	if bech32Polymod(append(bech32ExpandPrefix(prefix), values...)) != 1 {
+		return "", nil, fmt.Errorf("invalid checksum")
+	}
+	data := convertBits(values[:len(values)-6], 5, 8, false)
+	if data == nil {
+		return "", nil, fmt.Errorf("invalid padding")
+	}
+	return prefix, data, nil
+}
+
+// bech32Checksum returns the six checksum values of prefix and values
+func bech32Checksum(prefix string, values []byte) []byte {
+	polymod := bech32Polymod(append(append(bech32ExpandPrefix(prefix), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	checksum := make([]byte, 6)
+	for i := range checksum {
+		checksum[i] = byte(polymod>>uint(5*(5-i))) & 31
+	}
+	return checksum
+}
+
+// bech32ExpandPrefix spreads the prefix over the checksum input
+func bech32ExpandPrefix(prefix string) []byte {
+	expanded := make([]byte, 0, 2*len(prefix)+1)
+	for i := 0; i < len(prefix); i++ {
+		expanded = append(expanded, prefix[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := 0; i < len(prefix); i++ {
+		expanded = append(expanded, prefix[i]&31)
+	}
+	return expanded
+}
+
+// bech32Polymod is the BCH checksum of Bech32
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+// convertBits regroups data from groups of from bits to groups of to bits,
+// returning nil when the padding is invalid
+func convertBits(data []byte, from, to uint, pad bool) []byte {
+	var acc, bits uint
+	var out []byte
+	maxv := uint(1)<<to - 1
+	for _, b := range data {
+		acc = acc<<from | uint(b)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(to-bits)&maxv))
+		}
+	} else if bits >= from || acc<<(to-bits)&maxv != 0 {
+		return nil
+	}
+	return out
+}
@@ -0,0 +1,127 @@
+package flux
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Provider types the alerts can be sent to
+const (
+	AlertSlack    = "slack"
+	AlertDiscord  = "discord"
+	AlertTelegram = "telegram"
+)
+
+// alertsName names the Provider, Alert and Secret
+const alertsName = "home-alerts"
+
+// AlertsArgs configures the notification-controller alerts
+type AlertsArgs struct {
+	// Provider type: AlertSlack, AlertDiscord or AlertTelegram
+	Type string
+	// Webhook URL of Slack and Discord (secret)
+	Address pulumi.StringInput
+	// Bot token of Telegram (secret)
+	Token pulumi.StringInput
+	// Channel posted to, the chat ID for Telegram (optional otherwise)
+	Channel string
+	// Lowest severity sent: "info" or "error"
+	Severity string
+	// Namespaces whose Kustomizations and HelmReleases are watched
+	Namespaces []string
+}
+
+// Alerts push the reconcile events of Flux to a chat
+type Alerts struct {
+	pulumi.ResourceState
+}
+
+// NewAlerts creates a Provider, whose address and token are kept in a Secret,
+// and an Alert forwarding the events of every Kustomization and HelmRelease
+// of the namespaces to it. Flux and its CRDs must be installed first.
+func NewAlerts(ctx *pulumi.Context, name string, args *AlertsArgs, opts ...pulumi.ResourceOption) (*Alerts, error) {
+	alerts := &Alerts{}
+	err := ctx.RegisterComponentResource("home:flux:Alerts", name, alerts, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	data := pulumi.StringMap{}
+	if args.Address != nil {
+		data["address"] = args.Address
+	}
+	if args.Token != nil {
+		data["token"] = args.Token
+	}
+	secret, err := corev1.NewSecret(ctx, fmt.Sprintf("%s-secret", name), &corev1.SecretArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(alertsName),
+			Namespace: pulumi.String(Namespace),
+		},
+		StringData: data,
+	}, pulumi.Parent(alerts))
+	if err != nil {
+		return nil, err
+	}
+
+	providerSpec := kubernetes.UntypedArgs{
+		"type":      args.Type,
+		"secretRef": kubernetes.UntypedArgs{"name": alertsName},
+	}
+	if args.Channel != "" {
+		providerSpec["channel"] = args.Channel
+	}
+	provider, err := apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-provider", name), &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String("notification.toolkit.fluxcd.io/v1beta3"),
+		Kind:       pulumi.String("Provider"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(alertsName),
+			Namespace: pulumi.String(Namespace),
+		},
+		OtherFields: kubernetes.UntypedArgs{"spec": providerSpec},
+	}, pulumi.Parent(alerts), pulumi.DependsOn([]pulumi.Resource{secret}))
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []interface{}
+	for _, namespace := range args.Namespaces {
+		for _, kind := range []string{"Kustomization", "HelmRelease"} {
+			sources = append(sources, kubernetes.UntypedArgs{
+				"kind":      kind,
+				"name":      "*",
+				"namespace": namespace,
+			})
+		}
+	}
+	_, err = apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-alert", name), &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String("notification.toolkit.fluxcd.io/v1beta3"),
+		Kind:       pulumi.String("Alert"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(alertsName),
+			Namespace: pulumi.String(Namespace),
+		},
+		OtherFields: kubernetes.UntypedArgs{
+			"spec": kubernetes.UntypedArgs{
+				"providerRef":   kubernetes.UntypedArgs{"name": alertsName},
+				"eventSeverity": args.Severity,
+				"eventSources":  sources,
+			},
+		},
+	}, pulumi.Parent(alerts), pulumi.DependsOn([]pulumi.Resource{provider}))
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.RegisterResourceOutputs(alerts, pulumi.Map{})
+	if err != nil {
+		return nil, err
+	}
+
+	return alerts, nil
+}
@@ -0,0 +1,114 @@
+package flux
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"cluster-studio/pkg/shell"
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// modeAnnotation marks the flux-system namespace of a bootstrapped cluster
+const modeAnnotation = "home.lucena.cloud/flux-mode"
+
+// BootstrapArgs configures `flux bootstrap github`
+type BootstrapArgs struct {
+	// Kube context to bootstrap
+	Context string
+	// Kubeconfig file containing Context (optional, defaults to $KUBECONFIG or ~/.kube/config)
+	Kubeconfig string
+	// Flux version to install (e.g. v2.6.4)
+	Version string
+	// Controllers to install, defaults to DefaultComponents
+	Components []string
+	// GitHub owner, repository, branch and cluster path
+	Owner      string
+	Repository string
+	Branch     string
+	Path       string
+	// Whether the owner is a user rather than an organization
+	Personal bool
+	// GitHub token (secret), passed through the environment
+	Token pulumi.StringInput
+	// How long the bootstrap may take, zero for no limit
+	Timeout time.Duration
+	// Retries of a failed bootstrap, which is idempotent
+	Retry shell.RetryPolicy
+}
+
+// Bootstrap is a Flux installation that manages its own manifests in Git
+type Bootstrap struct {
+	pulumi.ResourceState
+
+	// Installed Flux version, resolved once the installation has completed
+	Version pulumi.StringOutput `pulumi:"version"`
+	// Output of the bootstrap command, not registered as an output
+	Output *shell.Output
+}
+
+// NewBootstrap runs `flux bootstrap github`. Bootstrap is idempotent, so
+// re-running it against an already bootstrapped cluster only reconciles it.
+func NewBootstrap(ctx *pulumi.Context, name string, args *BootstrapArgs, opts ...pulumi.ResourceOption) (*Bootstrap, error) {
+	bootstrap := &Bootstrap{}
+	err := ctx.RegisterComponentResource("home:flux:Bootstrap", name, bootstrap, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	components := args.Components
+	if len(components) == 0 {
+		components = DefaultComponents
+	}
+
+	flags := fmt.Sprintf("--context %s --owner %s --repository %s --branch %s --path %s --version %s --components %s",
+		args.Context, args.Owner, args.Repository, args.Branch, args.Path, args.Version, strings.Join(components, ","))
+	if args.Personal {
+		flags += " --personal"
+	}
+
+	// The output is captured so that a failing bootstrap reports it in the error,
+	// then the namespace is marked so a later `flux install` delete leaves it alone
+	bootstrapCmd := fmt.Sprintf(`if ! output=$(flux bootstrap github %[1]s 2>&1); then
+  echo "flux bootstrap failed:" >&2
+  echo "$output" >&2
+  exit 1
+fi
+echo "$output"
+kubectl --context %[2]s annotate namespace %[3]s %[4]s=bootstrap --overwrite`, flags, args.Context, Namespace, modeAnnotation)
+	bootstrapCmd = guard("flux bootstrap", bootstrapCmd, args.Context, args.Timeout, args.Retry)
+
+	environment := pulumi.StringMap{
+		"GITHUB_TOKEN": args.Token,
+	}
+	for key, value := range kubeconfigEnvironment(args.Kubeconfig) {
+		environment[key] = value
+	}
+	bootstrapCommand, err := local.NewCommand(ctx, stacks.Name(ctx, "bootstrap-flux"), &local.CommandArgs{
+		Create:      pulumi.String(bootstrapCmd),
+		Update:      pulumi.String(bootstrapCmd),
+		Delete:      pulumi.String(forceUninstallCommand(args.Context)),
+		Environment: environment,
+		Interpreter: shell.Interpreter(),
+	}, pulumi.Parent(bootstrap))
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolves once the command has completed
+	bootstrap.Version = bootstrapCommand.Stdout.ApplyT(func(string) string {
+		return args.Version
+	}).(pulumi.StringOutput)
+	bootstrap.Output = shell.CommandOutput(bootstrapCommand)
+	err = ctx.RegisterResourceOutputs(bootstrap, pulumi.Map{
+		"version": bootstrap.Version,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return bootstrap, nil
+}
@@ -0,0 +1,43 @@
+package flux
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// GitHubKnownHosts is the github.com host key used when no known_hosts is configured
+const GitHubKnownHosts = "github.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl"
+
+// DeployKey is an SSH key pair used by the GitRepository
+type DeployKey struct {
+	PrivateKeyPEM string `json:"privateKeyPEM"`
+	PublicKey     string `json:"publicKey"`
+}
+
+// GenerateDeployKey creates a new ed25519 deploy key. The public key is in
+// authorized_keys format, ready to be added to GitHub.
+func GenerateDeployKey(comment string) (*DeployKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate deploy key: %w", err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(private, comment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode deploy key: %w", err)
+	}
+	sshPublic, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode deploy public key: %w", err)
+	}
+
+	authorizedKey := ssh.MarshalAuthorizedKey(sshPublic)
+	return &DeployKey{
+		PrivateKeyPEM: string(pem.EncodeToMemory(block)),
+		PublicKey:     fmt.Sprintf("%s %s", authorizedKey[:len(authorizedKey)-1], comment),
+	}, nil
+}
@@ -0,0 +1,237 @@
+package flux
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cluster-studio/pkg/kube"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// ImageAutomationComponents are the controllers image automation needs, on
+// top of the source-controller
+var ImageAutomationComponents = []string{
+	"image-reflector-controller",
+	"image-automation-controller",
+}
+
+const (
+	// imageAutomationName names the GitRepository pushed to and its Secret
+	imageAutomationName = "image-automation"
+	// imageAPIVersion is the API of the image automation objects
+	imageAPIVersion = "image.toolkit.fluxcd.io/v1beta2"
+	// automationLabel selects the ImagePolicy of each ImageUpdateAutomation
+	automationLabel = "home.lucena.cloud/image-automation"
+)
+
+// AutomatedImage is an image whose tag Flux bumps in the repository
+type AutomatedImage struct {
+	// Names the ImageRepository, ImagePolicy and ImageUpdateAutomation
+	Name string `json:"name"`
+	// Image without a tag, e.g. ghcr.io/brunovlucena/homepage
+	Image string `json:"image"`
+	// Semver range of the tags the policy picks from, e.g. ">=1.0.0"
+	Semver string `json:"semver"`
+	// Path of the repository whose setter markers are updated
+	Path string `json:"path"`
+	// How often the registry is scanned, defaults to 5m
+	Interval string `json:"interval"`
+}
+
+// ImageAutomationArgs configures the image automation
+type ImageAutomationArgs struct {
+	Images []AutomatedImage
+	// HTTPS URL and branch of the repository the tags are pushed to
+	URL    string
+	Branch string
+	// Username and token with write access to the repository (secret)
+	Username string
+	Token    pulumi.StringInput
+	// Author of the commits
+	AuthorName  string
+	AuthorEmail string
+	// How often the automations run
+	Interval string
+	// Kubeconfig of the cluster (secret), to verify the controllers
+	Kubeconfig pulumi.StringInput
+	// How long the controllers may take to become available
+	Timeout time.Duration
+}
+
+// ImageAutomation scans registries for new tags of images and commits them to
+// the repository
+type ImageAutomation struct {
+	pulumi.ResourceState
+
+	// Automated images
+	Images pulumi.StringArrayOutput `pulumi:"images"`
+	// "healthy" once the image controllers are available
+	Status pulumi.StringOutput `pulumi:"status"`
+}
+
+// NewImageAutomation creates a GitRepository with write credentials and, for
+// each image, an ImageRepository, an ImagePolicy picking the highest tag in
+// the range and an ImageUpdateAutomation committing it to the setter markers
+// of its path. Flux must be installed with ImageAutomationComponents first.
+func NewImageAutomation(ctx *pulumi.Context, name string, args *ImageAutomationArgs, opts ...pulumi.ResourceOption) (*ImageAutomation, error) {
+	automation := &ImageAutomation{}
+	err := ctx.RegisterComponentResource("home:flux:ImageAutomation", name, automation, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := corev1.NewSecret(ctx, fmt.Sprintf("%s-auth", name), &corev1.SecretArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(imageAutomationName),
+			Namespace: pulumi.String(Namespace),
+		},
+		StringData: pulumi.StringMap{
+			"username": pulumi.String(args.Username),
+			"password": args.Token,
+		},
+	}, pulumi.Parent(automation))
+	if err != nil {
+		return nil, err
+	}
+	repository, err := apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-repository", name), &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String("source.toolkit.fluxcd.io/v1"),
+		Kind:       pulumi.String("GitRepository"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(imageAutomationName),
+			Namespace: pulumi.String(Namespace),
+		},
+		OtherFields: kubernetes.UntypedArgs{
+			"spec": kubernetes.UntypedArgs{
+				"interval":  args.Interval,
+				"url":       args.URL,
+				"ref":       kubernetes.UntypedArgs{"branch": args.Branch},
+				"secretRef": kubernetes.UntypedArgs{"name": imageAutomationName},
+			},
+		},
+	}, pulumi.Parent(automation), pulumi.DependsOn([]pulumi.Resource{secret}))
+	if err != nil {
+		return nil, err
+	}
+
+	images := make([]string, 0, len(args.Images))
+	for _, image := range args.Images {
+		if err := newAutomatedImage(ctx, name, automation, repository, args, image); err != nil {
+			return nil, err
+		}
+		images = append(images, image.Image)
+	}
+
+	automation.Images = pulumi.ToStringArray(images).ToStringArrayOutput()
+	automation.Status = pulumi.All(repository.ID(), args.Kubeconfig).ApplyT(func(all []interface{}) (string, error) {
+		if ctx.DryRun() {
+			return "", nil
+		}
+		client, err := kube.NewClientsetFromKubeconfig(all[1].(string))
+		if err != nil {
+			return "", err
+		}
+		err = kube.WaitForDeploymentsAvailable(context.Background(), client, Namespace, ImageAutomationComponents, kube.PollOptions{
+			Timeout: args.Timeout,
+			Logf: func(format string, a ...interface{}) {
+				_ = ctx.Log.Info(fmt.Sprintf(format, a...), &pulumi.LogArgs{Resource: automation})
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+		return "healthy", nil
+	}).(pulumi.StringOutput)
+	err = ctx.RegisterResourceOutputs(automation, pulumi.Map{
+		"images": automation.Images,
+		"status": automation.Status,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return automation, nil
+}
+
+// newAutomatedImage creates the ImageRepository, ImagePolicy and
+// ImageUpdateAutomation of image
+func newAutomatedImage(ctx *pulumi.Context, name string, automation *ImageAutomation, repository pulumi.Resource, args *ImageAutomationArgs, image AutomatedImage) error {
+	interval := image.Interval
+	if interval == "" {
+		interval = "5m"
+	}
+	imageRepository, err := apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-%s-repository", name, image.Name), &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String(imageAPIVersion),
+		Kind:       pulumi.String("ImageRepository"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(image.Name),
+			Namespace: pulumi.String(Namespace),
+		},
+		OtherFields: kubernetes.UntypedArgs{
+			"spec": kubernetes.UntypedArgs{
+				"image":    image.Image,
+				"interval": interval,
+			},
+		},
+	}, pulumi.Parent(automation))
+	if err != nil {
+		return err
+	}
+
+	policy, err := apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-%s-policy", name, image.Name), &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String(imageAPIVersion),
+		Kind:       pulumi.String("ImagePolicy"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(image.Name),
+			Namespace: pulumi.String(Namespace),
+			Labels:    pulumi.StringMap{automationLabel: pulumi.String(image.Name)},
+		},
+		OtherFields: kubernetes.UntypedArgs{
+			"spec": kubernetes.UntypedArgs{
+				"imageRepositoryRef": kubernetes.UntypedArgs{"name": image.Name},
+				"policy": kubernetes.UntypedArgs{
+					"semver": kubernetes.UntypedArgs{"range": image.Semver},
+				},
+			},
+		},
+	}, pulumi.Parent(automation), pulumi.DependsOn([]pulumi.Resource{imageRepository}))
+	if err != nil {
+		return err
+	}
+
+	_, err = apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-%s", name, image.Name), &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String(imageAPIVersion),
+		Kind:       pulumi.String("ImageUpdateAutomation"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(image.Name),
+			Namespace: pulumi.String(Namespace),
+		},
+		OtherFields: kubernetes.UntypedArgs{
+			"spec": kubernetes.UntypedArgs{
+				"interval":  args.Interval,
+				"sourceRef": kubernetes.UntypedArgs{"kind": "GitRepository", "name": imageAutomationName},
+				"policySelector": kubernetes.UntypedArgs{
+					"matchLabels": kubernetes.UntypedArgs{automationLabel: image.Name},
+				},
+				"git": kubernetes.UntypedArgs{
+					"checkout": kubernetes.UntypedArgs{"ref": kubernetes.UntypedArgs{"branch": args.Branch}},
+					"commit": kubernetes.UntypedArgs{
+						"author":          kubernetes.UntypedArgs{"name": args.AuthorName, "email": args.AuthorEmail},
+						"messageTemplate": fmt.Sprintf("Update %s to {{range .Changed.Changes}}{{.NewValue}}{{end}}", image.Image),
+					},
+					"push": kubernetes.UntypedArgs{"branch": args.Branch},
+				},
+				"update": kubernetes.UntypedArgs{
+					"path":     image.Path,
+					"strategy": "Setters",
+				},
+			},
+		},
+	}, pulumi.Parent(automation), pulumi.DependsOn([]pulumi.Resource{repository, policy}))
+	return err
+}
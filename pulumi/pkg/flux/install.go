@@ -0,0 +1,143 @@
+package flux
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"cluster-studio/pkg/shell"
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// DefaultComponents are the controllers installed when none are configured
+var DefaultComponents = []string{
+	"source-controller",
+	"kustomize-controller",
+	"helm-controller",
+	"notification-controller",
+}
+
+// InstallArgs configures the Flux installation
+type InstallArgs struct {
+	// Kube context to install into
+	Context string
+	// Kubeconfig file containing Context (optional, defaults to $KUBECONFIG or ~/.kube/config)
+	Kubeconfig string
+	// Flux version to install (e.g. v2.6.4)
+	Version string
+	// Controllers to install, defaults to DefaultComponents
+	Components []string
+	// How long the install and check may take, zero for no limit
+	Timeout time.Duration
+	// Retries of a failed install, e.g. while the API server is settling
+	Retry shell.RetryPolicy
+}
+
+// Install is a pinned Flux installation that is verified with `flux check`
+type Install struct {
+	pulumi.ResourceState
+
+	// Installed Flux version, resolved once the installation has completed
+	Version pulumi.StringOutput `pulumi:"version"`
+	// Output of the install command, not registered as an output
+	Output *shell.Output
+}
+
+// NewInstall installs the Flux controllers at the configured version. Changing
+// the version or components re-runs the install as an upgrade.
+func NewInstall(ctx *pulumi.Context, name string, args *InstallArgs, opts ...pulumi.ResourceOption) (*Install, error) {
+	install := &Install{}
+	err := ctx.RegisterComponentResource("home:flux:Install", name, install, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	components := args.Components
+	if len(components) == 0 {
+		components = DefaultComponents
+	}
+
+	// Install (or upgrade) the controllers
+	installCmd := fmt.Sprintf("flux install --context %s --version %s --components %s",
+		args.Context, args.Version, strings.Join(components, ","))
+	installCmd = guard("flux install", installCmd, args.Context, args.Timeout, args.Retry)
+	installCommand, err := local.NewCommand(ctx, stacks.Name(ctx, "install-flux"), &local.CommandArgs{
+		Create:      pulumi.String(installCmd),
+		Update:      pulumi.String(installCmd),
+		Delete:      pulumi.String(uninstallCommand(args.Context)),
+		Environment: kubeconfigEnvironment(args.Kubeconfig),
+		Interpreter: shell.Interpreter(),
+	}, pulumi.Parent(install), pulumi.Aliases([]pulumi.Alias{{NoParent: pulumi.Bool(true)}}))
+	if err != nil {
+		return nil, err
+	}
+
+	// Fail if any of the controllers is unhealthy, again whenever the install
+	// runs again
+	checkCommand, err := local.NewCommand(ctx, stacks.Name(ctx, "check-flux"), &local.CommandArgs{
+		Create:      pulumi.String(guard("flux check", fmt.Sprintf("flux check --context %s", args.Context), args.Context, args.Timeout, args.Retry)),
+		Environment: kubeconfigEnvironment(args.Kubeconfig),
+		Interpreter: shell.Interpreter(),
+		Triggers:    pulumi.Array{pulumi.String(installCmd)},
+	}, pulumi.Parent(install), pulumi.DependsOn([]pulumi.Resource{installCommand}))
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolves once the controllers are healthy
+	install.Version = checkCommand.Stdout.ApplyT(func(string) string {
+		return args.Version
+	}).(pulumi.StringOutput)
+	install.Output = shell.CommandOutput(installCommand)
+	err = ctx.RegisterResourceOutputs(install, pulumi.Map{
+		"version": install.Version,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return install, nil
+}
+
+// uninstallCommand removes Flux unless it is already gone (see Teardown) or
+// the cluster has since been bootstrapped, in which case the controllers
+// belong to the bootstrap and must stay
+func uninstallCommand(kubeContext string) string {
+	return fmt.Sprintf(`if ! kubectl --context %[1]s get namespace %[2]s >/dev/null 2>&1; then
+  echo "Flux is already uninstalled"
+elif [ "$(kubectl --context %[1]s get namespace %[2]s -o jsonpath='{.metadata.annotations.%[3]s}' 2>/dev/null)" = "bootstrap" ]; then
+  echo "Flux is managed by flux bootstrap, skipping uninstall"
+else
+  flux uninstall --context %[1]s --silent
+fi`, kubeContext, Namespace, strings.ReplaceAll(modeAnnotation, ".", `\.`))
+}
+
+// guard retries a flux command according to policy and limits all attempts
+// to timeout, reporting the state of the Flux controllers when it is exceeded
+func guard(step, command, kubeContext string, timeout time.Duration, policy shell.RetryPolicy) string {
+	return shell.Guard(shell.Retry(command, step, policy), shell.GuardOptions{
+		Step:     step,
+		Timeout:  timeout,
+		Diagnose: fmt.Sprintf("kubectl --context %s -n %s get pods", kubeContext, Namespace),
+	})
+}
+
+// forceUninstallCommand removes Flux, whatever installed it, unless it is
+// already gone
+func forceUninstallCommand(kubeContext string) string {
+	return fmt.Sprintf(`if kubectl --context %[1]s get namespace %[2]s >/dev/null 2>&1; then
+  flux uninstall --context %[1]s --silent
+fi`, kubeContext, Namespace)
+}
+
+// kubeconfigEnvironment points flux and kubectl at a kubeconfig file, leaving
+// the environment alone (nil) when none is set
+func kubeconfigEnvironment(kubeconfig string) pulumi.StringMap {
+	if kubeconfig == "" {
+		return nil
+	}
+	return pulumi.StringMap{"KUBECONFIG": pulumi.String(kubeconfig)}
+}
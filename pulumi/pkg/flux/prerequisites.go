@@ -0,0 +1,205 @@
+package flux
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"cluster-studio/pkg/kube"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// PrerequisitesArgs configures the sources and CRDs the HelmReleases and
+// Kustomizations of the cluster need
+type PrerequisitesArgs struct {
+	// HelmRepositories applied by the component
+	HelmRepositories []HelmRepository
+	// CRD manifests applied by the component: files, directories or URLs
+	CRDManifests []string
+	// HelmRepositories (namespace/name) and CRDs applied elsewhere, e.g. by
+	// the infrastructure tree, that are waited for as well
+	WaitHelmRepositories []string
+	WaitCRDs             []string
+	// Resolves once the objects applied elsewhere exist (optional)
+	Applied pulumi.ArrayInput
+	// Kubeconfig of the cluster (secret)
+	Kubeconfig pulumi.StringInput
+	// How long to wait for the HelmRepositories and CRDs
+	Timeout time.Duration
+}
+
+// Prerequisites are the HelmRepositories and CRDs established before the
+// objects using them are applied
+type Prerequisites struct {
+	pulumi.ResourceState
+
+	// HelmRepositories (namespace/name) and CRDs waited for
+	HelmRepositories pulumi.StringArrayOutput `pulumi:"helmRepositories"`
+	CRDs             pulumi.StringArrayOutput `pulumi:"crds"`
+}
+
+// NewPrerequisites applies the HelmRepositories and CRD manifests, then waits
+// for every HelmRepository to be Ready and every CRD to be Established and
+// records them in a ConfigMap of kube-system. The ConfigMap is only created
+// once the wait succeeded, so resources depending on the component are not
+// applied before their sources and CRDs exist.
+func NewPrerequisites(ctx *pulumi.Context, name string, args *PrerequisitesArgs, opts ...pulumi.ResourceOption) (*Prerequisites, error) {
+	prerequisites := &Prerequisites{}
+	err := ctx.RegisterComponentResource("home:flux:Prerequisites", name, prerequisites, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	repositories := append([]string{}, args.WaitHelmRepositories...)
+	crds := append([]string{}, args.WaitCRDs...)
+	applied := pulumi.Array{}
+	if args.Applied != nil {
+		applied = append(applied, args.Applied)
+	}
+
+	for _, repository := range args.HelmRepositories {
+		interval := repository.Interval
+		if interval == "" {
+			interval = "1h"
+		}
+		spec := pulumi.Map{
+			"interval": pulumi.String(interval),
+			"url":      pulumi.String(repository.URL),
+		}
+		if repository.Type != "" {
+			spec["type"] = pulumi.String(repository.Type)
+		}
+		namespace := repository.Namespace
+		if namespace == "" {
+			namespace = Namespace
+		}
+		object, err := apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-helmrepository-%s-%s", name, namespace, repository.Name), &apiextensions.CustomResourceArgs{
+			ApiVersion: pulumi.String("source.toolkit.fluxcd.io/v1"),
+			Kind:       pulumi.String("HelmRepository"),
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:      pulumi.String(repository.Name),
+				Namespace: pulumi.String(namespace),
+			},
+			OtherFields: kubernetes.UntypedArgs{
+				"spec": spec,
+			},
+		}, pulumi.Parent(prerequisites))
+		if err != nil {
+			return nil, err
+		}
+		repositories = append(repositories, namespace+"/"+repository.Name)
+		applied = append(applied, object.ID())
+	}
+
+	for _, source := range args.CRDManifests {
+		readCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		data, err := kube.ReadManifests(readCtx, source)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("CRD manifests %s: %w", source, err)
+		}
+		objects, err := kube.DecodeObjects(data)
+		if err != nil {
+			return nil, fmt.Errorf("CRD manifests %s: %w", source, err)
+		}
+		for _, object := range objects {
+			if object.GetKind() != "CustomResourceDefinition" {
+				return nil, fmt.Errorf("CRD manifests %s: %s %s is not a CustomResourceDefinition", source, object.GetKind(), object.GetName())
+			}
+			spec, _ := object.Object["spec"].(map[string]interface{})
+			crd, err := apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-crd-%s", name, object.GetName()), &apiextensions.CustomResourceArgs{
+				ApiVersion: pulumi.String(object.GetAPIVersion()),
+				Kind:       pulumi.String(object.GetKind()),
+				Metadata: &metav1.ObjectMetaArgs{
+					Name:        pulumi.String(object.GetName()),
+					Labels:      pulumi.ToStringMap(object.GetLabels()),
+					Annotations: pulumi.ToStringMap(object.GetAnnotations()),
+				},
+				OtherFields: kubernetes.UntypedArgs{
+					"spec": pulumi.ToMap(spec),
+				},
+			}, pulumi.Parent(prerequisites))
+			if err != nil {
+				return nil, err
+			}
+			crds = append(crds, object.GetName())
+			applied = append(applied, crd.ID())
+		}
+	}
+	sort.Strings(repositories)
+	sort.Strings(crds)
+
+	// Resolves once everything applied here exists and everything listed is ready
+	status := pulumi.All(args.Kubeconfig, applied).ApplyT(func(values []interface{}) (string, error) {
+		data, err := json.MarshalIndent(map[string][]string{
+			"helmRepositories": repositories,
+			"crds":             crds,
+		}, "", "  ")
+		if err != nil || ctx.DryRun() {
+			return string(data), err
+		}
+
+		client, err := kube.NewDynamicClientFromKubeconfig(values[0].(string))
+		if err != nil {
+			return "", err
+		}
+		logf := func(format string, a ...interface{}) {
+			_ = ctx.Log.Info(fmt.Sprintf(format, a...), &pulumi.LogArgs{Resource: prerequisites})
+		}
+		if len(crds) > 0 {
+			err = kube.WaitForCRDsEstablished(context.Background(), client, crds, kube.PollOptions{
+				Timeout: args.Timeout,
+				Logf:    logf,
+			})
+			if err != nil {
+				return "", err
+			}
+		}
+		if len(repositories) > 0 {
+			err = WaitForHelmRepositories(context.Background(), client, repositories, kube.PollOptions{
+				Timeout: args.Timeout,
+				Logf:    logf,
+			})
+			if err != nil {
+				return "", err
+			}
+		}
+		return string(data), nil
+	}).(pulumi.StringOutput)
+
+	_, err = corev1.NewConfigMap(ctx, fmt.Sprintf("%s-report", name), &corev1.ConfigMapArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(name),
+			Namespace: pulumi.String("kube-system"),
+		},
+		Data: pulumi.StringMap{
+			"status.json": status,
+		},
+	}, pulumi.Parent(prerequisites))
+	if err != nil {
+		return nil, err
+	}
+
+	prerequisites.HelmRepositories = status.ApplyT(func(string) []string {
+		return repositories
+	}).(pulumi.StringArrayOutput)
+	prerequisites.CRDs = status.ApplyT(func(string) []string {
+		return crds
+	}).(pulumi.StringArrayOutput)
+	err = ctx.RegisterResourceOutputs(prerequisites, pulumi.Map{
+		"helmRepositories": prerequisites.HelmRepositories,
+		"crds":             prerequisites.CRDs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return prerequisites, nil
+}
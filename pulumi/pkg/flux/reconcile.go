@@ -0,0 +1,141 @@
+package flux
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"cluster-studio/pkg/kube"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// reconciledKinds are the Flux objects whose readiness is awaited
+var reconciledKinds = []struct {
+	Kind     string
+	Resource schema.GroupVersionResource
+}{
+	{"Kustomization", schema.GroupVersionResource{Group: "kustomize.toolkit.fluxcd.io", Version: "v1", Resource: "kustomizations"}},
+	{"HelmRelease", schema.GroupVersionResource{Group: "helm.toolkit.fluxcd.io", Version: "v2", Resource: "helmreleases"}},
+}
+
+// ObjectStatus is the reconciliation state of a single Flux object
+type ObjectStatus struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Ready     bool   `json:"ready"`
+	Reason    string `json:"reason,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// ReconcileStatus summarizes the Flux objects of the cluster
+type ReconcileStatus struct {
+	Ready   bool           `json:"ready"`
+	Total   int            `json:"total"`
+	Stuck   int            `json:"stuck"`
+	Objects []ObjectStatus `json:"objects"`
+}
+
+// WaitForReconciliation polls all Kustomizations and HelmReleases until they
+// report Ready=True. The last observed status is returned even on timeout so
+// it can be exported.
+func WaitForReconciliation(ctx context.Context, client dynamic.Interface, opts kube.PollOptions) (*ReconcileStatus, error) {
+	if opts.Description == "" {
+		opts.Description = "Flux Kustomizations and HelmReleases to become Ready"
+	}
+
+	status := &ReconcileStatus{}
+	err := kube.Poll(ctx, opts, func(ctx context.Context) (bool, string, error) {
+		current, err := reconcileStatus(ctx, client)
+		if err != nil {
+			return false, "", err
+		}
+		status = current
+		return current.Ready, current.summary(), nil
+	})
+	return status, err
+}
+
+func reconcileStatus(ctx context.Context, client dynamic.Interface) (*ReconcileStatus, error) {
+	status := &ReconcileStatus{}
+	for _, kind := range reconciledKinds {
+		list, err := client.Resource(kind.Resource).Namespace(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+		if apierrors.IsNotFound(err) {
+			// CRD not installed (yet)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %ss: %w", kind.Kind, err)
+		}
+		for i := range list.Items {
+			status.Objects = append(status.Objects, objectStatus(kind.Kind, &list.Items[i]))
+		}
+	}
+
+	sort.Slice(status.Objects, func(i, j int) bool {
+		a, b := status.Objects[i], status.Objects[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+
+	status.Total = len(status.Objects)
+	for _, obj := range status.Objects {
+		if !obj.Ready {
+			status.Stuck++
+		}
+	}
+	status.Ready = status.Stuck == 0
+	return status, nil
+}
+
+// objectStatus reads the Ready condition of a Flux object
+func objectStatus(kind string, obj *unstructured.Unstructured) ObjectStatus {
+	result := ObjectStatus{
+		Kind:      kind,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		Reason:    "Progressing",
+		Message:   "no Ready condition reported yet",
+	}
+
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if !ok || cond["type"] != "Ready" {
+			continue
+		}
+		result.Ready = cond["status"] == "True"
+		result.Reason, _ = cond["reason"].(string)
+		result.Message, _ = cond["message"].(string)
+	}
+	if result.Ready {
+		result.Message = ""
+	}
+	return result
+}
+
+// summary describes the objects that are not Ready yet
+func (s *ReconcileStatus) summary() string {
+	if s.Ready {
+		return fmt.Sprintf("%d/%d Ready", s.Total, s.Total)
+	}
+
+	var stuck []string
+	for _, obj := range s.Objects {
+		if !obj.Ready {
+			stuck = append(stuck, fmt.Sprintf("%s %s/%s: %s (%s)", obj.Kind, obj.Namespace, obj.Name, obj.Reason, obj.Message))
+		}
+	}
+	return fmt.Sprintf("%d/%d not Ready:\n  %s", s.Stuck, s.Total, strings.Join(stuck, "\n  "))
+}
@@ -0,0 +1,63 @@
+package flux
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"cluster-studio/pkg/kube"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var helmRepositoryResource = schema.GroupVersionResource{Group: "source.toolkit.fluxcd.io", Version: "v1", Resource: "helmrepositories"}
+
+// HelmRepository is a Flux HelmRepository source
+type HelmRepository struct {
+	Name string `json:"name"`
+	// Namespace of the object, defaults to flux-system
+	Namespace string `json:"namespace"`
+	URL       string `json:"url"`
+	// "default" or "oci" (optional)
+	Type string `json:"type"`
+	// How often the index is fetched, defaults to 1h
+	Interval string `json:"interval"`
+}
+
+// WaitForHelmRepositories polls until every HelmRepository (namespace/name)
+// exists and reports Ready=True, i.e. its index was fetched. OCI repositories
+// report no status and are not waited for.
+func WaitForHelmRepositories(ctx context.Context, client dynamic.Interface, refs []string, opts kube.PollOptions) error {
+	if opts.Description == "" {
+		opts.Description = "HelmRepositories to become Ready"
+	}
+
+	return kube.Poll(ctx, opts, func(ctx context.Context) (bool, string, error) {
+		var pending []string
+		for _, ref := range refs {
+			namespace, name, _ := strings.Cut(ref, "/")
+			repository, err := client.Resource(helmRepositoryResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				pending = append(pending, ref+" (not found)")
+				continue
+			}
+			if err != nil {
+				return false, "", err
+			}
+			if repoType, _, _ := unstructured.NestedString(repository.Object, "spec", "type"); repoType == "oci" {
+				continue
+			}
+			if status := objectStatus("HelmRepository", repository); !status.Ready {
+				pending = append(pending, fmt.Sprintf("%s: %s (%s)", ref, status.Reason, status.Message))
+			}
+		}
+		if len(pending) == 0 {
+			return true, fmt.Sprintf("%d/%d HelmRepositories Ready", len(refs), len(refs)), nil
+		}
+		return false, fmt.Sprintf("waiting for %s", strings.Join(pending, ", ")), nil
+	})
+}
@@ -0,0 +1,151 @@
+package flux
+
+import (
+	"strings"
+
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Namespace is where Flux and its sync objects live
+const Namespace = "flux-system"
+
+// syncName is the conventional name of the root GitRepository and Kustomization
+const syncName = "flux-system"
+
+// SyncArgs configures the GitRepository and root Kustomization
+type SyncArgs struct {
+	// Git repository URL (https:// or ssh://)
+	URL string
+	// Branch to track
+	Branch string
+	// Reference (e.g. refs/pull/12/head) or full commit SHA tracked instead
+	// of Branch (optional)
+	Ref string
+	// Path of the cluster directory inside the repository
+	Path string
+	// Reconcile interval of the GitRepository and Kustomization
+	Interval string
+	// Credentials for the GitRepository secret (username/password for HTTPS,
+	// identity/identity.pub/known_hosts for SSH). Nil for public repositories.
+	Credentials pulumi.StringMapInput
+	// age private key the root Kustomization decrypts SOPS files with (secret),
+	// nil to apply them as they are
+	AgeKey pulumi.StringInput
+}
+
+// Sync points Flux at this repository
+type Sync struct {
+	pulumi.ResourceState
+
+	// Root Kustomization of the cluster
+	Kustomization *apiextensions.CustomResource
+}
+
+// NewSync creates the GitRepository, its credentials and the root
+// Kustomization reconciling the cluster directory, with SOPS decryption when
+// an age key is given. A new key only updates the sops-age Secret.
+func NewSync(ctx *pulumi.Context, name string, args *SyncArgs, opts ...pulumi.ResourceOption) (*Sync, error) {
+	sync := &Sync{}
+	err := ctx.RegisterComponentResource("home:flux:Sync", name, sync, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := kubernetes.UntypedArgs{"branch": args.Branch}
+	switch {
+	case strings.HasPrefix(args.Ref, "refs/"):
+		ref = kubernetes.UntypedArgs{"name": args.Ref}
+	case args.Ref != "":
+		// The branch helps the source-controller find the commit
+		ref["commit"] = args.Ref
+	}
+	source := kubernetes.UntypedArgs{
+		"interval": args.Interval,
+		"url":      args.URL,
+		"ref":      ref,
+	}
+	var sourceDeps []pulumi.Resource
+	if args.Credentials != nil {
+		secret, err := corev1.NewSecret(ctx, stacks.Name(ctx, "flux-git-credentials"), &corev1.SecretArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:      pulumi.String(syncName),
+				Namespace: pulumi.String(Namespace),
+			},
+			StringData: args.Credentials,
+		}, pulumi.Parent(sync))
+		if err != nil {
+			return nil, err
+		}
+		source["secretRef"] = kubernetes.UntypedArgs{"name": syncName}
+		sourceDeps = append(sourceDeps, secret)
+	}
+
+	gitRepository, err := apiextensions.NewCustomResource(ctx, stacks.Name(ctx, "flux-git-repository"), &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String("source.toolkit.fluxcd.io/v1"),
+		Kind:       pulumi.String("GitRepository"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(syncName),
+			Namespace: pulumi.String(Namespace),
+		},
+		OtherFields: kubernetes.UntypedArgs{"spec": source},
+	}, pulumi.Parent(sync), pulumi.DependsOn(sourceDeps))
+	if err != nil {
+		return nil, err
+	}
+
+	spec := kubernetes.UntypedArgs{
+		"interval": args.Interval,
+		"path":     args.Path,
+		"prune":    true,
+		"sourceRef": kubernetes.UntypedArgs{
+			"kind": "GitRepository",
+			"name": syncName,
+		},
+	}
+	kustomizationDeps := []pulumi.Resource{gitRepository}
+	if args.AgeKey != nil {
+		ageSecret, err := corev1.NewSecret(ctx, stacks.Name(ctx, "flux-sops-age"), &corev1.SecretArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:      pulumi.String(SOPSSecretName),
+				Namespace: pulumi.String(Namespace),
+			},
+			StringData: pulumi.StringMap{
+				"age.agekey": args.AgeKey,
+			},
+		}, pulumi.Parent(sync))
+		if err != nil {
+			return nil, err
+		}
+		spec["decryption"] = kubernetes.UntypedArgs{
+			"provider":  "sops",
+			"secretRef": kubernetes.UntypedArgs{"name": SOPSSecretName},
+		}
+		kustomizationDeps = append(kustomizationDeps, ageSecret)
+	}
+
+	sync.Kustomization, err = apiextensions.NewCustomResource(ctx, stacks.Name(ctx, "flux-root-kustomization"), &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String("kustomize.toolkit.fluxcd.io/v1"),
+		Kind:       pulumi.String("Kustomization"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(syncName),
+			Namespace: pulumi.String(Namespace),
+		},
+		OtherFields: kubernetes.UntypedArgs{"spec": spec},
+	}, pulumi.Parent(sync), pulumi.DependsOn(kustomizationDeps))
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.RegisterResourceOutputs(sync, pulumi.Map{})
+	if err != nil {
+		return nil, err
+	}
+
+	return sync, nil
+}
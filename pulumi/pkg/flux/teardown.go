@@ -0,0 +1,55 @@
+package flux
+
+import (
+	"cluster-studio/pkg/shell"
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// TeardownArgs configures the Flux teardown
+type TeardownArgs struct {
+	// Kube context Flux is installed in
+	Context string
+	// Kubeconfig file containing Context (optional, defaults to $KUBECONFIG or ~/.kube/config)
+	Kubeconfig string
+}
+
+// Teardown uninstalls Flux when it is deleted and does nothing otherwise.
+// It must depend on every in-cluster resource: destroy then deletes it first,
+// so the finalizers of Kustomizations and HelmReleases are removed before
+// their namespaces are deleted instead of blocking the deletion.
+type Teardown struct {
+	pulumi.ResourceState
+}
+
+// NewTeardown registers the Flux teardown
+func NewTeardown(ctx *pulumi.Context, name string, args *TeardownArgs, opts ...pulumi.ResourceOption) (*Teardown, error) {
+	teardown := &Teardown{}
+	err := ctx.RegisterComponentResource("home:flux:Teardown", name, teardown, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// flux uninstall removes the finalizers of all Flux objects before
+	// deleting the controllers. Updated in place: a replacement would
+	// uninstall Flux right after the new command is created.
+	_, err = local.NewCommand(ctx, stacks.Name(ctx, "uninstall-flux"), &local.CommandArgs{
+		Create:      pulumi.String("true"),
+		Update:      pulumi.String("true"),
+		Delete:      pulumi.String(forceUninstallCommand(args.Context)),
+		Environment: kubeconfigEnvironment(args.Kubeconfig),
+		Interpreter: shell.Interpreter(),
+	}, pulumi.Parent(teardown))
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.RegisterResourceOutputs(teardown, pulumi.Map{})
+	if err != nil {
+		return nil, err
+	}
+
+	return teardown, nil
+}
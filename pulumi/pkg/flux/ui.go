@@ -0,0 +1,229 @@
+package flux
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cluster-studio/pkg/credentials"
+	"cluster-studio/pkg/kube"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	networkingv1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/networking/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Web UIs of Flux
+const (
+	UICapacitor   = "capacitor"
+	UIWeaveGitOps = "weave-gitops"
+)
+
+const (
+	// capacitorManifests is the OCI artifact of the Capacitor manifests
+	capacitorManifests = "oci://ghcr.io/gimlet-io/capacitor-manifests"
+	// weaveGitOpsChart is the OCI Helm chart of Weave GitOps
+	weaveGitOpsChart = "oci://ghcr.io/weaveworks/charts/weave-gitops"
+	// UIAdminUser is the user of Weave GitOps
+	UIAdminUser = "admin"
+)
+
+// UIService returns the Service and port of a UI, in Namespace
+func UIService(ui string) (string, int) {
+	if ui == UIWeaveGitOps {
+		return UIWeaveGitOps, 9001
+	}
+	return UICapacitor, 9000
+}
+
+// UIArgs configures the web UI of Flux
+type UIArgs struct {
+	// UICapacitor or UIWeaveGitOps
+	UI string
+	// Capacitor release (e.g. v0.4.8) or Weave GitOps chart version
+	Version string
+	// Host of an Ingress of class nginx routing to the UI (optional)
+	Host string
+	// Bumping it generates the admin password of Weave GitOps again
+	Rotate int
+	// Kubeconfig of the cluster (secret), to wait for Capacitor
+	Kubeconfig pulumi.StringInput
+	// How long the UI may take to become available
+	Timeout time.Duration
+}
+
+// UI is a web UI of Flux in Namespace
+type UI struct {
+	pulumi.ResourceState
+
+	// Admin user and password of Weave GitOps (secret), empty for Capacitor,
+	// which has no login
+	AdminUser     pulumi.StringOutput `pulumi:"adminUser"`
+	AdminPassword pulumi.StringOutput `pulumi:"adminPassword"`
+	// Resolves once the UI is available
+	Version pulumi.StringOutput `pulumi:"version"`
+}
+
+// NewUI installs Capacitor, applied by Flux from its OCI manifests and pruned
+// when removed, or Weave GitOps with Helm and a generated admin password.
+// Flux must be installed first.
+func NewUI(ctx *pulumi.Context, name string, args *UIArgs, opts ...pulumi.ResourceOption) (*UI, error) {
+	ui := &UI{}
+	err := ctx.RegisterComponentResource("home:flux:UI", name, ui, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var installed pulumi.StringOutput
+	if args.UI == UIWeaveGitOps {
+		password, err := credentials.NewPassword(ctx, fmt.Sprintf("%s-admin-password", name), 24, args.Rotate, pulumi.Parent(ui))
+		if err != nil {
+			return nil, err
+		}
+		release, err := helmv3.NewRelease(ctx, fmt.Sprintf("%s-release", name), &helmv3.ReleaseArgs{
+			Name:      pulumi.String(UIWeaveGitOps),
+			Chart:     pulumi.String(weaveGitOpsChart),
+			Version:   pulumi.String(args.Version),
+			Namespace: pulumi.String(Namespace),
+			Timeout:   pulumi.Int(int(args.Timeout.Seconds())),
+			Values: pulumi.Map{
+				"adminUser": pulumi.Map{
+					"create":       pulumi.Bool(true),
+					"username":     pulumi.String(UIAdminUser),
+					"passwordHash": password.BcryptHash,
+				},
+			},
+		}, pulumi.Parent(ui))
+		if err != nil {
+			return nil, err
+		}
+		installed = release.Status.ApplyT(func(helmv3.ReleaseStatus) string {
+			return args.Version
+		}).(pulumi.StringOutput)
+		ui.AdminUser = pulumi.String(UIAdminUser).ToStringOutput()
+		ui.AdminPassword = pulumi.ToSecret(password.Result).(pulumi.StringOutput)
+	} else {
+		if installed, err = newCapacitor(ctx, name, ui, args); err != nil {
+			return nil, err
+		}
+		ui.AdminUser = pulumi.String("").ToStringOutput()
+		ui.AdminPassword = pulumi.String("").ToStringOutput()
+	}
+
+	if args.Host != "" {
+		service, port := UIService(args.UI)
+		_, err = networkingv1.NewIngress(ctx, fmt.Sprintf("%s-ingress", name), &networkingv1.IngressArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:      pulumi.String(service),
+				Namespace: pulumi.String(Namespace),
+			},
+			Spec: &networkingv1.IngressSpecArgs{
+				IngressClassName: pulumi.String("nginx"),
+				Rules: networkingv1.IngressRuleArray{
+					&networkingv1.IngressRuleArgs{
+						Host: pulumi.String(args.Host),
+						Http: &networkingv1.HTTPIngressRuleValueArgs{
+							Paths: networkingv1.HTTPIngressPathArray{
+								&networkingv1.HTTPIngressPathArgs{
+									Path:     pulumi.String("/"),
+									PathType: pulumi.String("Prefix"),
+									Backend: &networkingv1.IngressBackendArgs{
+										Service: &networkingv1.IngressServiceBackendArgs{
+											Name: pulumi.String(service),
+											Port: &networkingv1.ServiceBackendPortArgs{Number: pulumi.Int(port)},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		}, pulumi.Parent(ui))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ui.Version = installed
+	err = ctx.RegisterResourceOutputs(ui, pulumi.Map{
+		"adminUser":     ui.AdminUser,
+		"adminPassword": ui.AdminPassword,
+		"version":       ui.Version,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ui, nil
+}
+
+// newCapacitor lets Flux apply the Capacitor manifests of the release, and
+// prune them once the Kustomization is deleted. The returned version resolves
+// once Capacitor is available.
+func newCapacitor(ctx *pulumi.Context, name string, ui *UI, args *UIArgs) (pulumi.StringOutput, error) {
+	source, err := apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-source", name), &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String("source.toolkit.fluxcd.io/v1"),
+		Kind:       pulumi.String("OCIRepository"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(UICapacitor),
+			Namespace: pulumi.String(Namespace),
+		},
+		OtherFields: kubernetes.UntypedArgs{
+			"spec": kubernetes.UntypedArgs{
+				"interval": "12h",
+				"url":      capacitorManifests,
+				"ref":      kubernetes.UntypedArgs{"tag": args.Version},
+			},
+		},
+	}, pulumi.Parent(ui))
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+
+	kustomization, err := apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-kustomization", name), &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String("kustomize.toolkit.fluxcd.io/v1"),
+		Kind:       pulumi.String("Kustomization"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(UICapacitor),
+			Namespace: pulumi.String(Namespace),
+		},
+		OtherFields: kubernetes.UntypedArgs{
+			"spec": kubernetes.UntypedArgs{
+				"interval":        "1h",
+				"retryInterval":   "2m",
+				"path":            "./",
+				"prune":           true,
+				"wait":            true,
+				"targetNamespace": Namespace,
+				"sourceRef":       kubernetes.UntypedArgs{"kind": "OCIRepository", "name": UICapacitor},
+			},
+		},
+	}, pulumi.Parent(ui), pulumi.DependsOn([]pulumi.Resource{source}))
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+
+	return pulumi.All(kustomization.ID(), args.Kubeconfig).ApplyT(func(all []interface{}) (string, error) {
+		if ctx.DryRun() {
+			return "", nil
+		}
+		client, err := kube.NewClientsetFromKubeconfig(all[1].(string))
+		if err != nil {
+			return "", err
+		}
+		err = kube.WaitForDeploymentsAvailable(context.Background(), client, Namespace, []string{UICapacitor}, kube.PollOptions{
+			Timeout: args.Timeout,
+			Logf: func(format string, a ...interface{}) {
+				_ = ctx.Log.Info(fmt.Sprintf(format, a...), &pulumi.LogArgs{Resource: ui})
+			},
+		})
+		if err != nil {
+			return "", fmt.Errorf("Capacitor is not available, check `flux get kustomization %s`: %w", UICapacitor, err)
+		}
+		return args.Version, nil
+	}).(pulumi.StringOutput), nil
+}
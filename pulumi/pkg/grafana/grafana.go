@@ -0,0 +1,168 @@
+package grafana
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"cluster-studio/pkg/monitoring"
+
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// ChecksumAnnotation holds the sha256 of a dashboard, so edits show as
+	// changes of the ConfigMap
+	ChecksumAnnotation = "home.lucena.cloud/checksum"
+	// DashboardLabel and DatasourceLabel are the labels the Grafana sidecars
+	// of kube-prometheus-stack load ConfigMaps by
+	DashboardLabel  = "grafana_dashboard"
+	DatasourceLabel = "grafana_datasource"
+)
+
+// Dashboard is a dashboard JSON model
+type Dashboard struct {
+	// Name of the file without .json, names the ConfigMap
+	Name  string
+	Title string
+	JSON  string
+}
+
+// Datasource is a datasource provisioned in Grafana
+type Datasource struct {
+	Name      string `yaml:"name"`
+	UID       string `yaml:"uid"`
+	Type      string `yaml:"type"`
+	URL       string `yaml:"url"`
+	Access    string `yaml:"access"`
+	IsDefault bool   `yaml:"isDefault"`
+}
+
+// LoadDashboards reads the *.json dashboards of dir
+func LoadDashboards(dir string) ([]Dashboard, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no dashboards (*.json) in %s", dir)
+	}
+	sort.Strings(files)
+
+	var dashboards []Dashboard
+	titles := map[string]string{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var model struct {
+			Title string `json:"title"`
+		}
+		if err := json.Unmarshal(data, &model); err != nil {
+			return nil, fmt.Errorf("dashboard %s: %w", file, err)
+		}
+		if model.Title == "" {
+			return nil, fmt.Errorf("dashboard %s has no title", file)
+		}
+		if other, ok := titles[model.Title]; ok {
+			return nil, fmt.Errorf("dashboards %s and %s are both titled %q", other, file, model.Title)
+		}
+		titles[model.Title] = file
+
+		name := strings.ToLower(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if msgs := validation.IsDNS1123Label("dashboard-" + name); len(msgs) > 0 {
+			return nil, fmt.Errorf("dashboard %s: the file name must be a DNS label: %s", file, strings.Join(msgs, ", "))
+		}
+		dashboards = append(dashboards, Dashboard{Name: name, Title: model.Title, JSON: string(data)})
+	}
+	return dashboards, nil
+}
+
+// DashboardsArgs configures the provisioned dashboards and datasources
+type DashboardsArgs struct {
+	Dashboards  []Dashboard
+	Datasources []Datasource
+}
+
+// Dashboards are dashboards and datasources loaded by the Grafana of the
+// monitoring stack
+type Dashboards struct {
+	pulumi.ResourceState
+
+	// Titles of the provisioned dashboards
+	Titles pulumi.StringArrayOutput `pulumi:"titles"`
+}
+
+// NewDashboards creates one ConfigMap per dashboard and one with the
+// datasources, in the namespace of the monitoring stack where its sidecars
+// load them from
+func NewDashboards(ctx *pulumi.Context, name string, args *DashboardsArgs, opts ...pulumi.ResourceOption) (*Dashboards, error) {
+	dashboards := &Dashboards{}
+	err := ctx.RegisterComponentResource("home:grafana:Dashboards", name, dashboards, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var titles []string
+	for _, dashboard := range args.Dashboards {
+		sum := sha256.Sum256([]byte(dashboard.JSON))
+		_, err := corev1.NewConfigMap(ctx, fmt.Sprintf("%s-%s", name, dashboard.Name), &corev1.ConfigMapArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:        pulumi.String("dashboard-" + dashboard.Name),
+				Namespace:   pulumi.String(monitoring.Namespace),
+				Labels:      pulumi.StringMap{DashboardLabel: pulumi.String("1")},
+				Annotations: pulumi.StringMap{ChecksumAnnotation: pulumi.String(hex.EncodeToString(sum[:]))},
+			},
+			Data: pulumi.StringMap{
+				dashboard.Name + ".json": pulumi.String(dashboard.JSON),
+			},
+		}, pulumi.Parent(dashboards))
+		if err != nil {
+			return nil, fmt.Errorf("dashboard %s: %w", dashboard.Name, err)
+		}
+		titles = append(titles, dashboard.Title)
+	}
+
+	if len(args.Datasources) > 0 {
+		provisioning, err := yaml.Marshal(map[string]interface{}{
+			"apiVersion":  1,
+			"datasources": args.Datasources,
+		})
+		if err != nil {
+			return nil, err
+		}
+		_, err = corev1.NewConfigMap(ctx, fmt.Sprintf("%s-datasources", name), &corev1.ConfigMapArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:      pulumi.String("datasources"),
+				Namespace: pulumi.String(monitoring.Namespace),
+				Labels:    pulumi.StringMap{DatasourceLabel: pulumi.String("1")},
+			},
+			Data: pulumi.StringMap{
+				"datasources.yaml": pulumi.String(string(provisioning)),
+			},
+		}, pulumi.Parent(dashboards))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dashboards.Titles = pulumi.ToStringArray(titles).ToStringArrayOutput()
+	err = ctx.RegisterResourceOutputs(dashboards, pulumi.Map{
+		"titles": dashboards.Titles,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dashboards, nil
+}
@@ -0,0 +1,259 @@
+package infra
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/kustomize"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/yaml"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// DefaultDependencies is the apply order of the known infrastructure
+// components. Config entries override these per component, and dependencies
+// on components that don't exist in the tree are ignored.
+var DefaultDependencies = map[string][]string{
+	"sealed-secrets":      {"repositories"},
+	"cert-manager":        {"repositories"},
+	"cloudflare-tunnel":   {"sealed-secrets"},
+	"metrics-server":      {"repositories"},
+	"prometheus-operator": {"repositories", "cert-manager", "sealed-secrets"},
+	"homepage":            {"repositories"},
+	"loki":                {"repositories"},
+	"alloy":               {"loki", "tempo"},
+	"tempo":               {"repositories"},
+	"k6-operator":         {"repositories"},
+	"agent-legacy":        {"prometheus-operator", "sealed-secrets"},
+	"agent-sre":           {"prometheus-operator", "sealed-secrets"},
+	"grafana-mcp":         {"prometheus-operator"},
+	"statuspage-exporter": {"prometheus-operator"},
+}
+
+// kustomization is the subset of kustomization.yaml needed to find components
+type kustomization struct {
+	Resources []string `yaml:"resources"`
+}
+
+// DiscoverComponents lists the component directories of an infrastructure
+// tree. The resources of the root kustomization.yaml are used when present so
+// that commented out components stay disabled; otherwise every subdirectory
+// containing a kustomization.yaml is a component.
+func DiscoverComponents(dir string) ([]string, error) {
+	var components []string
+
+	data, err := os.ReadFile(filepath.Join(dir, "kustomization.yaml"))
+	switch {
+	case err == nil:
+		var root kustomization
+		if err := yamlv3.Unmarshal(data, &root); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", filepath.Join(dir, "kustomization.yaml"), err)
+		}
+		for _, resource := range root.Resources {
+			info, err := os.Stat(filepath.Join(dir, resource))
+			if err != nil || !info.IsDir() {
+				return nil, fmt.Errorf("infrastructure resource %q in %s is not a component directory", resource, dir)
+			}
+			components = append(components, filepath.Clean(resource))
+		}
+	case os.IsNotExist(err):
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read infrastructure directory: %w", err)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(dir, entry.Name(), "kustomization.yaml")); err == nil {
+				components = append(components, entry.Name())
+			}
+		}
+	default:
+		return nil, err
+	}
+
+	if len(components) == 0 {
+		return nil, fmt.Errorf("no infrastructure components found in %s", dir)
+	}
+	return components, nil
+}
+
+// ComponentsArgs configures the per-component infrastructure deployment
+type ComponentsArgs struct {
+	// Infrastructure directory containing one kustomization per component
+	Dir string
+	// Component -> components it must be applied after
+	Dependencies map[string][]string
+	// Resources every component waits for (e.g. the service mesh)
+	DependsOn []pulumi.Resource
+	// Components managed outside of the infrastructure tree, or applied by
+	// another call, not applied
+	Skip []string
+	// Components applied by another call that the components can depend on
+	Applied map[string]*kustomize.Directory
+	// Labels and annotations injected into every applied object (optional)
+	Metadata *Metadata
+}
+
+// DeployComponents creates one kustomize.Directory per infrastructure
+// component. Components wait only for their own dependencies, so independent
+// ones are applied in parallel and a broken component only blocks its dependents.
+func DeployComponents(ctx *pulumi.Context, args *ComponentsArgs, opts ...pulumi.ResourceOption) (map[string]*kustomize.Directory, error) {
+	discovered, err := DiscoverComponents(args.Dir)
+	if err != nil {
+		return nil, err
+	}
+	skip := make(map[string]bool, len(args.Skip))
+	for _, component := range args.Skip {
+		skip[component] = true
+	}
+	var components []string
+	for _, component := range discovered {
+		if !skip[component] {
+			components = append(components, component)
+		}
+	}
+
+	order, err := applyOrder(components, args.Dependencies)
+	if err != nil {
+		return nil, err
+	}
+
+	directories := make(map[string]*kustomize.Directory, len(order))
+	for _, component := range order {
+		deps := append([]pulumi.Resource{}, args.DependsOn...)
+		for _, dep := range args.Dependencies[component] {
+			if dir, ok := directories[dep]; ok {
+				deps = append(deps, dir)
+			} else if dir, ok := args.Applied[dep]; ok {
+				deps = append(deps, dir)
+			}
+		}
+
+		dirArgs := kustomize.DirectoryArgs{
+			Directory: pulumi.String(filepath.Join(args.Dir, component)),
+			// The objects of the members of an umbrella stack don't collide
+			ResourcePrefix: stacks.Prefix(ctx),
+		}
+		if args.Metadata != nil {
+			dirArgs.Transformations = []yaml.Transformation{args.Metadata.Transformation()}
+		}
+		dir, err := kustomize.NewDirectory(ctx, stacks.Name(ctx, fmt.Sprintf("infrastructure-%s", component)), dirArgs, append(opts, pulumi.DependsOn(deps))...)
+		if err != nil {
+			return nil, fmt.Errorf("infrastructure component %s: %w", component, err)
+		}
+		directories[component] = dir
+	}
+
+	return directories, nil
+}
+
+// Applied returns an output that resolves once every object of the given
+// directories has been created or updated
+func Applied(directories map[string]*kustomize.Directory) pulumi.ArrayOutput {
+	names := make([]string, 0, len(directories))
+	for name := range directories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var ids pulumi.Array
+	for _, name := range names {
+		ids = append(ids, directories[name].Resources.ApplyT(func(resources interface{}) pulumi.ArrayOutput {
+			var objectIDs pulumi.Array
+			for _, resource := range resources.(map[string]pulumi.Resource) {
+				if custom, ok := resource.(pulumi.CustomResource); ok {
+					objectIDs = append(objectIDs, custom.ID())
+				}
+			}
+			return objectIDs.ToArrayOutput()
+		}))
+	}
+	return ids.ToArrayOutput()
+}
+
+// Inventory returns an output counting the objects of the given directories
+// by kind and namespace, resolved once they have all been applied
+func Inventory(directories map[string]*kustomize.Directory) pulumi.Output {
+	var resources pulumi.Array
+	for _, dir := range directories {
+		resources = append(resources, dir.Resources)
+	}
+	return pulumi.All(resources, Applied(directories)).ApplyT(func(args []interface{}) *kube.Inventory {
+		inventory := kube.NewInventory()
+		for _, dirResources := range args[0].([]interface{}) {
+			for key := range dirResources.(map[string]pulumi.Resource) {
+				inventory.Add(parseResourceKey(key))
+			}
+		}
+		return inventory
+	})
+}
+
+// parseResourceKey splits the key of a kustomize.Directory resource
+// ("apps/v1/Deployment::namespace/name") into kind, namespace and name
+func parseResourceKey(key string) (string, string, string) {
+	gvk, ref, _ := strings.Cut(key, "::")
+	kind := gvk[strings.LastIndex(gvk, "/")+1:]
+	namespace, name, namespaced := strings.Cut(ref, "/")
+	if !namespaced {
+		return kind, "", ref
+	}
+	return kind, namespace, name
+}
+
+// applyOrder sorts components so that every component comes after its
+// dependencies. Dependencies on unknown components are ignored.
+func applyOrder(components []string, dependencies map[string][]string) ([]string, error) {
+	known := make(map[string]bool, len(components))
+	for _, c := range components {
+		known[c] = true
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(components))
+	var order []string
+	var visit func(component string, path []string) error
+	visit = func(component string, path []string) error {
+		switch state[component] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("infrastructure dependency cycle: %s", strings.Join(append(path, component), " -> "))
+		}
+		state[component] = visiting
+
+		deps := append([]string{}, dependencies[component]...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if !known[dep] {
+				continue
+			}
+			if err := visit(dep, append(path, component)); err != nil {
+				return err
+			}
+		}
+
+		state[component] = done
+		order = append(order, component)
+		return nil
+	}
+
+	for _, component := range components {
+		if err := visit(component, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
@@ -0,0 +1,110 @@
+package infra
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/kustomize"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/yaml"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Directory is a kustomization outside of the infrastructure tree (e.g. a
+// per-machine overlay) applied as its own kustomize.Directory
+type Directory struct {
+	// Name of the directory, defaults to the base name of Dir
+	Name string `json:"name"`
+	// Path to the kustomization
+	Dir string `json:"dir"`
+	// Other directories or infrastructure components it must be applied
+	// after. Without it the directory waits for the whole infrastructure.
+	DependsOn []string `json:"dependsOn"`
+}
+
+// DirectoriesArgs configures DeployDirectories
+type DirectoriesArgs struct {
+	Directories []Directory
+	// Applied infrastructure components the directories can depend on
+	Components map[string]*kustomize.Directory
+	// Resources every directory waits for (e.g. the service mesh)
+	DependsOn []pulumi.Resource
+	// Labels and annotations injected into every applied object (optional)
+	Metadata *Metadata
+}
+
+// DeployDirectories creates one kustomize.Directory per directory, named
+// "extra-<name>". A directory without DependsOn is applied after every
+// component, otherwise only after those it lists, so a broken component
+// only blocks the directories depending on it.
+func DeployDirectories(ctx *pulumi.Context, args *DirectoriesArgs, opts ...pulumi.ResourceOption) (map[string]*kustomize.Directory, error) {
+	byName := make(map[string]Directory, len(args.Directories))
+	names := make([]string, 0, len(args.Directories))
+	dependencies := make(map[string][]string, len(args.Directories))
+	for _, dir := range args.Directories {
+		if _, ok := byName[dir.Name]; ok {
+			return nil, fmt.Errorf("extra kustomize directory %s is listed twice", dir.Name)
+		}
+		if _, ok := args.Components[dir.Name]; ok {
+			return nil, fmt.Errorf("extra kustomize directory %s has the name of an infrastructure component", dir.Name)
+		}
+		byName[dir.Name] = dir
+		names = append(names, dir.Name)
+		dependencies[dir.Name] = dir.DependsOn
+	}
+	for _, dir := range args.Directories {
+		for _, dep := range dir.DependsOn {
+			_, isDir := byName[dep]
+			_, isComponent := args.Components[dep]
+			if !isDir && !isComponent {
+				return nil, fmt.Errorf("extra kustomize directory %s depends on %s, which is neither an extra directory nor an applied infrastructure component", dir.Name, dep)
+			}
+		}
+	}
+
+	order, err := applyOrder(names, dependencies)
+	if err != nil {
+		return nil, err
+	}
+
+	components := make([]string, 0, len(args.Components))
+	for component := range args.Components {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+
+	directories := make(map[string]*kustomize.Directory, len(order))
+	for _, name := range order {
+		dir := byName[name]
+		deps := append([]pulumi.Resource{}, args.DependsOn...)
+		if len(dir.DependsOn) == 0 {
+			for _, component := range components {
+				deps = append(deps, args.Components[component])
+			}
+		}
+		for _, dep := range dir.DependsOn {
+			if applied, ok := directories[dep]; ok {
+				deps = append(deps, applied)
+			} else {
+				deps = append(deps, args.Components[dep])
+			}
+		}
+
+		dirArgs := kustomize.DirectoryArgs{
+			Directory:      pulumi.String(filepath.Clean(dir.Dir)),
+			ResourcePrefix: stacks.Prefix(ctx),
+		}
+		if args.Metadata != nil {
+			dirArgs.Transformations = []yaml.Transformation{args.Metadata.Transformation()}
+		}
+		applied, err := kustomize.NewDirectory(ctx, stacks.Name(ctx, fmt.Sprintf("extra-%s", name)), dirArgs, append(opts, pulumi.DependsOn(deps))...)
+		if err != nil {
+			return nil, fmt.Errorf("extra kustomize directory %s: %w", name, err)
+		}
+		directories[name] = applied
+	}
+
+	return directories, nil
+}
@@ -0,0 +1,194 @@
+package infra
+
+import (
+	"strings"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/yaml"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// linkerdInjectAnnotation enables proxy injection for a namespace or pod
+	linkerdInjectAnnotation = "linkerd.io/inject"
+	// istioInjectionLabel enables sidecar injection for a namespace
+	istioInjectionLabel = "istio-injection"
+	// istioPodInjectionLabel enables sidecar injection for a pod
+	istioPodInjectionLabel = "sidecar.istio.io/inject"
+)
+
+// podTemplateKinds are the workloads whose pod template is annotated for
+// injection. Jobs and CronJobs are left alone: their template is immutable
+// and a meshed Job never completes because the proxy keeps running.
+var podTemplateKinds = map[string]bool{
+	"Deployment":  true,
+	"StatefulSet": true,
+	"DaemonSet":   true,
+	"ReplicaSet":  true,
+}
+
+// Metadata is injected into every object applied from the infrastructure tree
+type Metadata struct {
+	// Labels set on every object, overriding the value from the manifest
+	Labels map[string]string
+	// Mesh the workloads of InjectNamespaces join: "linkerd", "istio" or
+	// "none" (no injection)
+	Mesh string
+	// Namespaces whose workloads are meshed
+	InjectNamespaces []string
+	// Objects left out as Kind/namespace/name (Kind/name when cluster
+	// scoped), e.g. the HelmRelease of a controller the program installs
+	Exclude []string
+	// Image repository -> image the containers using it run instead, e.g.
+	// the images built from the repository
+	Images map[string]string
+}
+
+// Transformation returns a kustomize transformation adding the labels to the
+// metadata of every object and the injection marker of the mesh to the
+// selected Namespaces and to the pod templates of workloads in them: the
+// linkerd.io/inject annotation for Linkerd, the istio-injection and
+// sidecar.istio.io/inject labels for Istio. Only metadata is changed, never
+// selectors, so existing objects are updated in place. Excluded objects are
+// turned into empty Lists, which create nothing. The containers of pods and
+// pod templates whose image is one of Images run the replacement instead.
+func (m *Metadata) Transformation() yaml.Transformation {
+	inject := make(map[string]bool, len(m.InjectNamespaces))
+	for _, ns := range m.InjectNamespaces {
+		inject[ns] = true
+	}
+	exclude := make(map[string]bool, len(m.Exclude))
+	for _, ref := range m.Exclude {
+		exclude[ref] = true
+	}
+
+	return func(state map[string]interface{}, _ ...pulumi.ResourceOption) {
+		kind, _ := state["kind"].(string)
+		metadata := nestedMap(state, "metadata")
+		if metadata == nil {
+			return
+		}
+		if ref := objectRef(kind, metadata); exclude[ref] {
+			for key := range state {
+				delete(state, key)
+			}
+			state["apiVersion"] = "v1"
+			state["kind"] = "List"
+			state["items"] = []interface{}{}
+			return
+		}
+		labels := nestedMap(metadata, "labels")
+		if labels == nil {
+			labels = map[string]interface{}{}
+			metadata["labels"] = labels
+		}
+		for key, value := range m.Labels {
+			labels[key] = value
+		}
+		if len(m.Images) > 0 {
+			m.replaceImages(kind, state)
+		}
+
+		name, _ := metadata["name"].(string)
+		namespace, _ := metadata["namespace"].(string)
+		switch {
+		case m.Mesh != "linkerd" && m.Mesh != "istio":
+			return
+		case kind == "Namespace" && inject[name]:
+			m.markInjected(metadata, false)
+		case podTemplateKinds[kind] && inject[namespace]:
+			template := nestedMap(nestedMap(state, "spec"), "template")
+			if template == nil {
+				return
+			}
+			templateMetadata := nestedMap(template, "metadata")
+			if templateMetadata == nil {
+				templateMetadata = map[string]interface{}{}
+				template["metadata"] = templateMetadata
+			}
+			m.markInjected(templateMetadata, true)
+		}
+	}
+}
+
+// replaceImages sets the image of the containers of a pod, pod template, Job
+// or CronJob whose image repository is one of Images
+func (m *Metadata) replaceImages(kind string, state map[string]interface{}) {
+	spec := nestedMap(state, "spec")
+	switch kind {
+	case "Pod":
+	case "CronJob":
+		spec = nestedMap(nestedMap(nestedMap(nestedMap(spec, "jobTemplate"), "spec"), "template"), "spec")
+	default:
+		spec = nestedMap(nestedMap(spec, "template"), "spec")
+	}
+	if spec == nil {
+		return
+	}
+	for _, field := range []string{"initContainers", "containers"} {
+		containers, _ := spec[field].([]interface{})
+		for _, container := range containers {
+			container, ok := container.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			image, _ := container["image"].(string)
+			if replacement, ok := m.Images[imageRepository(image)]; ok {
+				container["image"] = replacement
+			}
+		}
+	}
+}
+
+// imageRepository strips the tag and digest of an image reference
+func imageRepository(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+// objectRef returns Kind/namespace/name, or Kind/name without a namespace
+func objectRef(kind string, metadata map[string]interface{}) string {
+	name, _ := metadata["name"].(string)
+	if namespace, _ := metadata["namespace"].(string); namespace != "" {
+		return kind + "/" + namespace + "/" + name
+	}
+	return kind + "/" + name
+}
+
+// markInjected adds the injection marker of the mesh to the metadata of a
+// Namespace or of a pod template
+func (m *Metadata) markInjected(metadata map[string]interface{}, pod bool) {
+	switch {
+	case m.Mesh == "istio" && pod:
+		setMetadata(metadata, "labels", istioPodInjectionLabel, "true")
+	case m.Mesh == "istio":
+		setMetadata(metadata, "labels", istioInjectionLabel, "enabled")
+	default:
+		setMetadata(metadata, "annotations", linkerdInjectAnnotation, "enabled")
+	}
+}
+
+// setMetadata sets a label or annotation (field) unless the manifest already
+// sets it, so workloads can still opt out with linkerd.io/inject: disabled or
+// sidecar.istio.io/inject: "false"
+func setMetadata(metadata map[string]interface{}, field, key, value string) {
+	values := nestedMap(metadata, field)
+	if values == nil {
+		values = map[string]interface{}{}
+		metadata[field] = values
+	}
+	if _, ok := values[key]; !ok {
+		values[key] = value
+	}
+}
+
+// nestedMap returns obj[key] if it is a map
+func nestedMap(obj map[string]interface{}, key string) map[string]interface{} {
+	if obj == nil {
+		return nil
+	}
+	m, _ := obj[key].(map[string]interface{})
+	return m
+}
@@ -0,0 +1,108 @@
+package infra
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"cluster-studio/pkg/kube"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// Prerequisites are the HelmRepositories and CRDs defined by a component,
+// which the HelmReleases and custom resources of other components need
+type Prerequisites struct {
+	// HelmRepositories as namespace/name
+	HelmRepositories []string
+	// CustomResourceDefinition names (e.g. ipaddresspools.metallb.io)
+	CRDs []string
+}
+
+// DiscoverPrerequisites reads the YAML files of every component and lists the
+// HelmRepositories and CRDs it defines, skipping the files that don't parse.
+// Components defining none are left out. A HelmRepository without a
+// namespace gets the one of the component kustomization, or default.
+func DiscoverPrerequisites(dir string, components []string) (map[string]*Prerequisites, error) {
+	found := map[string]*Prerequisites{}
+	for _, component := range components {
+		root := filepath.Join(dir, component)
+		namespace := "default"
+		if data, err := os.ReadFile(filepath.Join(root, "kustomization.yaml")); err == nil {
+			var k struct {
+				Namespace string `yaml:"namespace"`
+			}
+			if err := yamlv3.Unmarshal(data, &k); err == nil && k.Namespace != "" {
+				namespace = k.Namespace
+			}
+		}
+
+		prerequisites := &Prerequisites{}
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			if ext := filepath.Ext(path); ext != ".yaml" && ext != ".yml" {
+				return nil
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			objects, err := kube.DecodeObjects(data)
+			if err != nil {
+				// Not a manifest, e.g. the template of a chart vendored in the component
+				return nil
+			}
+			for _, object := range objects {
+				switch {
+				case object.GetKind() == "HelmRepository" && object.GroupVersionKind().Group == "source.toolkit.fluxcd.io":
+					objectNamespace := object.GetNamespace()
+					if objectNamespace == "" {
+						objectNamespace = namespace
+					}
+					prerequisites.HelmRepositories = append(prerequisites.HelmRepositories, objectNamespace+"/"+object.GetName())
+				case object.GetKind() == "CustomResourceDefinition":
+					prerequisites.CRDs = append(prerequisites.CRDs, object.GetName())
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(prerequisites.HelmRepositories) > 0 || len(prerequisites.CRDs) > 0 {
+			sort.Strings(prerequisites.HelmRepositories)
+			sort.Strings(prerequisites.CRDs)
+			found[component] = prerequisites
+		}
+	}
+	return found, nil
+}
+
+// WithDependencies returns components and, transitively, every component
+// they depend on, sorted
+func WithDependencies(components []string, dependencies map[string][]string) []string {
+	seen := map[string]bool{}
+	var visit func(component string)
+	visit = func(component string) {
+		if seen[component] {
+			return
+		}
+		seen[component] = true
+		for _, dep := range dependencies[component] {
+			visit(dep)
+		}
+	}
+	for _, component := range components {
+		visit(component)
+	}
+
+	result := make([]string, 0, len(seen))
+	for component := range seen {
+		result = append(result, component)
+	}
+	sort.Strings(result)
+	return result
+}
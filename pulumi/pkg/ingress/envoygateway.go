@@ -0,0 +1,358 @@
+package ingress
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/shell"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	// EnvoyGatewayChart and EnvoyGatewayCRDsChart are the OCI charts of the
+	// controller and of its CRDs
+	EnvoyGatewayChart     = "oci://docker.io/envoyproxy/gateway-helm"
+	EnvoyGatewayCRDsChart = "oci://docker.io/envoyproxy/gateway-crds-helm"
+	// EnvoyGatewayNamespace is where Envoy Gateway and the Gateway run
+	EnvoyGatewayNamespace = "envoy-gateway-system"
+	// GatewayClassName is the name of the GatewayClass of Envoy Gateway
+	GatewayClassName = "envoy-gateway"
+	// GatewayName is the Gateway the HTTPRoutes of every namespace attach to
+	GatewayName = "default"
+	// GatewayCertificate is the TLS Secret of the HTTPS listener, in
+	// EnvoyGatewayNamespace. The program doesn't create it: until it exists
+	// (e.g. issued by cert-manager) only the HTTP listener serves.
+	GatewayCertificate = "gateway-tls"
+
+	// envoyProxyName configures the Envoy Deployment of the Gateway
+	envoyProxyName = "kind-host-ports"
+	// envoyPortOffset is added by Envoy Gateway to privileged listener ports
+	// to get the ports the Envoy container listens on
+	envoyPortOffset = 10000
+)
+
+// GatewayAPIManifest is the standard channel release of the Gateway API CRDs
+func GatewayAPIManifest(version string) string {
+	return fmt.Sprintf("https://github.com/kubernetes-sigs/gateway-api/releases/download/%s/standard-install.yaml", version)
+}
+
+// gatewayResource is the Gateway API kind the readiness of the Gateway is read from
+var gatewayResource = schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "gateways"}
+
+// EnvoyGatewayArgs configures the Envoy Gateway installation
+type EnvoyGatewayArgs struct {
+	// Chart version of Envoy Gateway (e.g. v1.5.1)
+	Version string
+	// Release of the Gateway API CRDs (e.g. v1.3.0)
+	GatewayAPIVersion string
+	// Bind ports 80/443 on the node labelled ingress-ready=true, which Kind
+	// publishes on the host through extraPortMappings. Otherwise Envoy is
+	// exposed with a Service of type LoadBalancer.
+	HostPort bool
+	// Host ports 80 and 443 of the node are published on (HostPort only)
+	HTTPPort, HTTPSPort int
+	// Kube context of the cluster and environment of the kubectl commands
+	// (KUBECONFIG)
+	KubeContext string
+	Environment pulumi.StringMap
+	// Kubeconfig of the cluster (secret), used to wait for the Gateway
+	Kubeconfig pulumi.StringInput
+	// How long to wait for the controller and the Gateway
+	Timeout time.Duration
+}
+
+// EnvoyGateway is Envoy Gateway with its GatewayClass and a Gateway every
+// namespace attaches HTTPRoutes to
+type EnvoyGateway struct {
+	pulumi.ResourceState
+
+	// Name of the GatewayClass
+	ClassName pulumi.StringOutput `pulumi:"className"`
+	// Gateway (namespace/name) of the HTTPRoutes
+	Gateway pulumi.StringOutput `pulumi:"gateway"`
+	// URLs the Gateway is reachable on, once it is programmed
+	HTTPURL  pulumi.StringOutput `pulumi:"httpUrl"`
+	HTTPSURL pulumi.StringOutput `pulumi:"httpsUrl"`
+}
+
+// NewEnvoyGateway applies the standard channel Gateway API CRDs, installs
+// Envoy Gateway with Helm, and creates the GatewayClass and the Gateway with
+// an HTTP listener and an HTTPS one serving GatewayCertificate. The URLs
+// resolve once the Gateway is programmed.
+func NewEnvoyGateway(ctx *pulumi.Context, name string, args *EnvoyGatewayArgs, opts ...pulumi.ResourceOption) (*EnvoyGateway, error) {
+	gateway := &EnvoyGateway{}
+	err := ctx.RegisterComponentResource("home:ingress:EnvoyGateway", name, gateway, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	environment := pulumi.StringMap{"MANIFEST": pulumi.String(GatewayAPIManifest(args.GatewayAPIVersion))}
+	for key, value := range args.Environment {
+		environment[key] = value
+	}
+	// Server-side, the CRDs are too large for the last-applied annotation
+	crds, err := local.NewCommand(ctx, fmt.Sprintf("%s-gateway-api-crds", name), &local.CommandArgs{
+		Create:      pulumi.Sprintf(`kubectl --context %s apply --server-side --force-conflicts -f "$MANIFEST"`, args.KubeContext),
+		Update:      pulumi.Sprintf(`kubectl --context %s apply --server-side --force-conflicts -f "$MANIFEST"`, args.KubeContext),
+		Delete:      pulumi.Sprintf(`kubectl --context %s delete --ignore-not-found -f "$MANIFEST"`, args.KubeContext),
+		Environment: environment,
+		Interpreter: shell.Interpreter(),
+	}, pulumi.Parent(gateway))
+	if err != nil {
+		return nil, err
+	}
+
+	// The CRDs of Envoy Gateway itself, the Gateway API ones are applied above
+	envoyCRDs, err := helmv3.NewRelease(ctx, fmt.Sprintf("%s-crds", name), &helmv3.ReleaseArgs{
+		Name:            pulumi.String("envoy-gateway-crds"),
+		Chart:           pulumi.String(EnvoyGatewayCRDsChart),
+		Version:         pulumi.String(args.Version),
+		Namespace:       pulumi.String(EnvoyGatewayNamespace),
+		CreateNamespace: pulumi.Bool(true),
+		Timeout:         pulumi.Int(int(args.Timeout.Seconds())),
+		Values: pulumi.Map{
+			"crds": pulumi.Map{
+				"gatewayAPI":   pulumi.Map{"enabled": pulumi.Bool(false)},
+				"envoyGateway": pulumi.Map{"enabled": pulumi.Bool(true)},
+			},
+		},
+	}, pulumi.Parent(gateway), pulumi.DependsOn([]pulumi.Resource{crds}))
+	if err != nil {
+		return nil, err
+	}
+
+	controller, err := helmv3.NewRelease(ctx, fmt.Sprintf("%s-controller", name), &helmv3.ReleaseArgs{
+		Name:            pulumi.String("envoy-gateway"),
+		Chart:           pulumi.String(EnvoyGatewayChart),
+		Version:         pulumi.String(args.Version),
+		Namespace:       pulumi.String(EnvoyGatewayNamespace),
+		CreateNamespace: pulumi.Bool(true),
+		SkipCrds:        pulumi.Bool(true),
+		Timeout:         pulumi.Int(int(args.Timeout.Seconds())),
+	}, pulumi.Parent(gateway), pulumi.DependsOn([]pulumi.Resource{envoyCRDs}))
+	if err != nil {
+		return nil, err
+	}
+
+	classSpec := pulumi.Map{
+		"controllerName": pulumi.String("gateway.envoyproxy.io/gatewayclass-controller"),
+	}
+	classDeps := []pulumi.Resource{controller}
+	if args.HostPort {
+		proxy, err := newHostPortProxy(ctx, name, gateway, controller)
+		if err != nil {
+			return nil, err
+		}
+		classSpec["parametersRef"] = pulumi.Map{
+			"group":     pulumi.String("gateway.envoyproxy.io"),
+			"kind":      pulumi.String("EnvoyProxy"),
+			"name":      pulumi.String(envoyProxyName),
+			"namespace": pulumi.String(EnvoyGatewayNamespace),
+		}
+		classDeps = append(classDeps, proxy)
+	}
+	class, err := apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-class", name), &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String("gateway.networking.k8s.io/v1"),
+		Kind:       pulumi.String("GatewayClass"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Name: pulumi.String(GatewayClassName),
+		},
+		OtherFields: kubernetes.UntypedArgs{
+			"spec": classSpec,
+		},
+	}, pulumi.Parent(gateway), pulumi.DependsOn(classDeps))
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-gateway", name), &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String("gateway.networking.k8s.io/v1"),
+		Kind:       pulumi.String("Gateway"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(GatewayName),
+			Namespace: pulumi.String(EnvoyGatewayNamespace),
+		},
+		OtherFields: kubernetes.UntypedArgs{
+			"spec": pulumi.Map{
+				"gatewayClassName": pulumi.String(GatewayClassName),
+				"listeners": pulumi.Array{
+					pulumi.Map{
+						"name":     pulumi.String("http"),
+						"protocol": pulumi.String("HTTP"),
+						"port":     pulumi.Int(80),
+						"allowedRoutes": pulumi.Map{
+							"namespaces": pulumi.Map{"from": pulumi.String("All")},
+						},
+					},
+					pulumi.Map{
+						"name":     pulumi.String("https"),
+						"protocol": pulumi.String("HTTPS"),
+						"port":     pulumi.Int(443),
+						"tls": pulumi.Map{
+							"mode": pulumi.String("Terminate"),
+							"certificateRefs": pulumi.Array{
+								pulumi.Map{"kind": pulumi.String("Secret"), "name": pulumi.String(GatewayCertificate)},
+							},
+						},
+						"allowedRoutes": pulumi.Map{
+							"namespaces": pulumi.Map{"from": pulumi.String("All")},
+						},
+					},
+				},
+			},
+		},
+	}, pulumi.Parent(gateway), pulumi.DependsOn([]pulumi.Resource{class}))
+	if err != nil {
+		return nil, err
+	}
+
+	host := pulumi.All(object.ID(), args.Kubeconfig).ApplyT(func(values []interface{}) (string, error) {
+		if ctx.DryRun() {
+			return "localhost", nil
+		}
+		client, err := kube.NewDynamicClientFromKubeconfig(values[1].(string))
+		if err != nil {
+			return "", err
+		}
+		address, err := WaitForGateway(context.Background(), client, EnvoyGatewayNamespace, GatewayName, kube.PollOptions{
+			Timeout: args.Timeout,
+			Logf: func(format string, a ...interface{}) {
+				_ = ctx.Log.Info(fmt.Sprintf(format, a...), &pulumi.LogArgs{Resource: gateway})
+			},
+		})
+		if err != nil || args.HostPort {
+			return "localhost", err
+		}
+		return address, nil
+	}).(pulumi.StringOutput)
+
+	gateway.ClassName = class.Metadata.Name().Elem()
+	gateway.Gateway = pulumi.Sprintf("%s/%s", EnvoyGatewayNamespace, object.Metadata.Name().Elem())
+	gateway.HTTPURL = host.ApplyT(func(host string) string {
+		return url("http", host, args.HTTPPort, 80)
+	}).(pulumi.StringOutput)
+	gateway.HTTPSURL = host.ApplyT(func(host string) string {
+		return url("https", host, args.HTTPSPort, 443)
+	}).(pulumi.StringOutput)
+	err = ctx.RegisterResourceOutputs(gateway, pulumi.Map{
+		"className": gateway.ClassName,
+		"gateway":   gateway.Gateway,
+		"httpUrl":   gateway.HTTPURL,
+		"httpsUrl":  gateway.HTTPSURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return gateway, nil
+}
+
+// newHostPortProxy creates the EnvoyProxy that runs the Envoy of the Gateway
+// on the ingress-ready node, with the container ports of the HTTP and HTTPS
+// listeners bound to host ports 80 and 443
+func newHostPortProxy(ctx *pulumi.Context, name string, parent pulumi.Resource, controller pulumi.Resource) (pulumi.Resource, error) {
+	return apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-proxy", name), &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String("gateway.envoyproxy.io/v1alpha1"),
+		Kind:       pulumi.String("EnvoyProxy"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(envoyProxyName),
+			Namespace: pulumi.String(EnvoyGatewayNamespace),
+		},
+		OtherFields: kubernetes.UntypedArgs{
+			"spec": pulumi.Map{
+				"provider": pulumi.Map{
+					"type": pulumi.String("Kubernetes"),
+					"kubernetes": pulumi.Map{
+						"envoyService": pulumi.Map{"type": pulumi.String("NodePort")},
+						"envoyDeployment": pulumi.Map{
+							"pod": pulumi.Map{
+								"nodeSelector": pulumi.Map{"ingress-ready": pulumi.String("true")},
+								"tolerations": pulumi.Array{
+									pulumi.Map{
+										"key":      pulumi.String("node-role.kubernetes.io/control-plane"),
+										"operator": pulumi.String("Exists"),
+										"effect":   pulumi.String("NoSchedule"),
+									},
+								},
+							},
+							"patch": pulumi.Map{
+								"type": pulumi.String("StrategicMerge"),
+								"value": pulumi.Map{
+									"spec": pulumi.Map{"template": pulumi.Map{"spec": pulumi.Map{
+										"containers": pulumi.Array{
+											pulumi.Map{
+												"name": pulumi.String("envoy"),
+												"ports": pulumi.Array{
+													pulumi.Map{
+														"name":          pulumi.String("http-host"),
+														"containerPort": pulumi.Int(80 + envoyPortOffset),
+														"hostPort":      pulumi.Int(80),
+														"protocol":      pulumi.String("TCP"),
+													},
+													pulumi.Map{
+														"name":          pulumi.String("https-host"),
+														"containerPort": pulumi.Int(443 + envoyPortOffset),
+														"hostPort":      pulumi.Int(443),
+														"protocol":      pulumi.String("TCP"),
+													},
+												},
+											},
+										},
+									}}},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}, pulumi.Parent(parent), pulumi.DependsOn([]pulumi.Resource{controller}))
+}
+
+// WaitForGateway polls until the Gateway is programmed and returns its first
+// address, "" when it reports none
+func WaitForGateway(ctx context.Context, client dynamic.Interface, namespace, name string, opts kube.PollOptions) (string, error) {
+	if opts.Description == "" {
+		opts.Description = fmt.Sprintf("Gateway %s/%s to be programmed", namespace, name)
+	}
+
+	var address string
+	err := kube.Poll(ctx, opts, func(ctx context.Context) (bool, string, error) {
+		gateway, err := client.Resource(gatewayResource).Namespace(namespace).Get(ctx, name, k8smetav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, "not found", nil
+		}
+		if err != nil {
+			return false, "", err
+		}
+		addresses, _, _ := unstructured.NestedSlice(gateway.Object, "status", "addresses")
+		if len(addresses) > 0 {
+			if first, ok := addresses[0].(map[string]interface{}); ok {
+				address, _, _ = unstructured.NestedString(first, "value")
+			}
+		}
+		conditions, _, _ := unstructured.NestedSlice(gateway.Object, "status", "conditions")
+		for _, condition := range conditions {
+			condition, ok := condition.(map[string]interface{})
+			if !ok || condition["type"] != "Programmed" {
+				continue
+			}
+			return condition["status"] == "True", fmt.Sprintf("Programmed=%v: %v", condition["status"], condition["message"]), nil
+		}
+		return false, "not programmed yet", nil
+	})
+	return address, err
+}
@@ -0,0 +1,197 @@
+package ingress
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/stacks"
+
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	networkingv1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/networking/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// ChartRepo is the Helm repository serving the ingress-nginx chart
+	ChartRepo = "https://kubernetes.github.io/ingress-nginx"
+	// Namespace is where ingress-nginx is installed
+	Namespace = "ingress-nginx"
+	// ClassName is the name of the (default) IngressClass
+	ClassName = "nginx"
+)
+
+// NginxArgs configures the ingress-nginx installation
+type NginxArgs struct {
+	// Chart version
+	Version string
+	// Bind ports 80/443 on the node labelled ingress-ready=true, which Kind
+	// publishes on the host through extraPortMappings. Otherwise the
+	// controller is exposed with a Service of type LoadBalancer.
+	HostPort bool
+	// Host ports 80 and 443 of the node are published on (HostPort only)
+	HTTPPort  int
+	HTTPSPort int
+	// Secret (namespace/name) served to clients without SNI or for hosts
+	// without a certificate of their own (optional)
+	DefaultCertificate string
+	// Kubeconfig of the cluster (secret), used to wait for the admission webhook
+	Kubeconfig pulumi.StringInput
+	// How long to wait for the controller and its admission webhook
+	Timeout time.Duration
+}
+
+// Nginx is the ingress-nginx controller with its default IngressClass
+type Nginx struct {
+	pulumi.ResourceState
+
+	// Name of the IngressClass
+	ClassName pulumi.StringOutput `pulumi:"className"`
+	// URLs the controller is reachable on
+	HTTPURL  pulumi.StringOutput `pulumi:"httpUrl"`
+	HTTPSURL pulumi.StringOutput `pulumi:"httpsUrl"`
+}
+
+// NewNginx installs ingress-nginx with Helm. The IngressClass is only created
+// once the admission webhook serves requests, so Ingress objects depending on
+// the component are never rejected by an unavailable webhook.
+func NewNginx(ctx *pulumi.Context, name string, args *NginxArgs, opts ...pulumi.ResourceOption) (*Nginx, error) {
+	nginx := &Nginx{}
+	err := ctx.RegisterComponentResource("home:ingress:Nginx", name, nginx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	controller := pulumi.Map{
+		// The IngressClass is managed below, once the webhook is ready
+		"ingressClassResource":     pulumi.Map{"enabled": pulumi.Bool(false)},
+		"ingressClass":             pulumi.String(ClassName),
+		"watchIngressWithoutClass": pulumi.Bool(true),
+	}
+	extraArgs := pulumi.Map{}
+	if args.DefaultCertificate != "" {
+		extraArgs["default-ssl-certificate"] = pulumi.String(args.DefaultCertificate)
+	}
+	if args.HostPort {
+		controller["hostPort"] = pulumi.Map{"enabled": pulumi.Bool(true)}
+		controller["service"] = pulumi.Map{"type": pulumi.String("NodePort")}
+		controller["publishService"] = pulumi.Map{"enabled": pulumi.Bool(false)}
+		extraArgs["publish-status-address"] = pulumi.String("localhost")
+		controller["nodeSelector"] = pulumi.Map{"ingress-ready": pulumi.String("true")}
+		controller["tolerations"] = pulumi.Array{
+			pulumi.Map{
+				"key":      pulumi.String("node-role.kubernetes.io/control-plane"),
+				"operator": pulumi.String("Exists"),
+				"effect":   pulumi.String("NoSchedule"),
+			},
+		}
+	} else {
+		controller["service"] = pulumi.Map{"type": pulumi.String("LoadBalancer")}
+	}
+	if len(extraArgs) > 0 {
+		controller["extraArgs"] = extraArgs
+	}
+
+	release, err := helmv3.NewRelease(ctx, stacks.Name(ctx, "ingress-nginx"), &helmv3.ReleaseArgs{
+		Name:            pulumi.String("ingress-nginx"),
+		Chart:           pulumi.String("ingress-nginx"),
+		Version:         pulumi.String(args.Version),
+		Namespace:       pulumi.String(Namespace),
+		CreateNamespace: pulumi.Bool(true),
+		RepositoryOpts:  helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+		Timeout:         pulumi.Int(int(args.Timeout.Seconds())),
+		Values: pulumi.Map{
+			"controller": controller,
+		},
+	}, pulumi.Parent(nginx))
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolves to the host the controller is reachable on, once the webhook is ready
+	host := pulumi.All(release.Status, args.Kubeconfig).ApplyT(func(values []interface{}) (string, error) {
+		return waitForController(ctx, nginx, args, values[1].(string))
+	}).(pulumi.StringOutput)
+
+	class, err := networkingv1.NewIngressClass(ctx, fmt.Sprintf("%s-class", name), &networkingv1.IngressClassArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name: pulumi.String(ClassName),
+			Annotations: pulumi.StringMap{
+				"ingressclass.kubernetes.io/is-default-class": pulumi.String("true"),
+			},
+		},
+		Spec: &networkingv1.IngressClassSpecArgs{
+			Controller: pulumi.String("k8s.io/ingress-nginx"),
+		},
+	}, pulumi.Parent(nginx), pulumi.DependsOnInputs(host.ApplyT(func(string) []pulumi.Resource {
+		return []pulumi.Resource{release}
+	}).(pulumi.ResourceArrayOutput)))
+	if err != nil {
+		return nil, err
+	}
+
+	nginx.ClassName = class.Metadata.Name().Elem()
+	nginx.HTTPURL = host.ApplyT(func(host string) string {
+		return url("http", host, args.HTTPPort, 80)
+	}).(pulumi.StringOutput)
+	nginx.HTTPSURL = host.ApplyT(func(host string) string {
+		return url("https", host, args.HTTPSPort, 443)
+	}).(pulumi.StringOutput)
+	err = ctx.RegisterResourceOutputs(nginx, pulumi.Map{
+		"className": nginx.ClassName,
+		"httpUrl":   nginx.HTTPURL,
+		"httpsUrl":  nginx.HTTPSURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return nginx, nil
+}
+
+// waitForController waits for the admission webhook to have ready endpoints
+// and returns the host the controller is reachable on
+func waitForController(ctx *pulumi.Context, nginx *Nginx, args *NginxArgs, kubeconfig string) (string, error) {
+	host := "localhost"
+	if ctx.DryRun() {
+		return host, nil
+	}
+
+	client, err := kube.NewClientsetFromKubeconfig(kubeconfig)
+	if err != nil {
+		return "", err
+	}
+	logf := func(format string, a ...interface{}) {
+		_ = ctx.Log.Info(fmt.Sprintf(format, a...), &pulumi.LogArgs{Resource: nginx})
+	}
+
+	err = kube.WaitForServiceEndpoints(context.Background(), client, Namespace, "ingress-nginx-controller-admission", kube.PollOptions{
+		Description: "ingress-nginx admission webhook to become ready",
+		Timeout:     args.Timeout,
+		Logf:        logf,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if !args.HostPort {
+		host, err = kube.WaitForLoadBalancer(context.Background(), client, Namespace, "ingress-nginx-controller", kube.PollOptions{
+			Timeout: args.Timeout,
+			Logf:    logf,
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return host, nil
+}
+
+// url formats a URL, leaving out the port when it is the default one
+func url(scheme, host string, port, defaultPort int) string {
+	if port == 0 || port == defaultPort {
+		return fmt.Sprintf("%s://%s", scheme, host)
+	}
+	return fmt.Sprintf("%s://%s:%d", scheme, host, port)
+}
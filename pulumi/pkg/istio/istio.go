@@ -0,0 +1,136 @@
+package istio
+
+import (
+	"fmt"
+	"time"
+
+	"cluster-studio/pkg/stacks"
+
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// ChartRepo is the Helm repository serving the Istio charts
+	ChartRepo = "https://istio-release.storage.googleapis.com/charts"
+	// Namespace is where the CRDs and istiod are installed
+	Namespace = "istio-system"
+	// GatewayNamespace is where the ingress gateway is installed
+	GatewayNamespace = "istio-ingress"
+	// InjectionLabel enables sidecar injection for a namespace
+	InjectionLabel = "istio-injection"
+	// PodInjectionLabel enables or disables sidecar injection for a pod
+	PodInjectionLabel = "sidecar.istio.io/inject"
+)
+
+// Values are the chart values of each release
+type Values struct {
+	Base    map[string]interface{} `json:"base,omitempty"`
+	Istiod  map[string]interface{} `json:"istiod,omitempty"`
+	Gateway map[string]interface{} `json:"gateway,omitempty"`
+}
+
+// MeshArgs configures the Istio installation
+type MeshArgs struct {
+	// Version of the Istio charts (e.g. 1.27.1)
+	Version string
+	// Values of the base, istiod and gateway charts
+	Values Values
+	// Install the ingress gateway
+	Gateway bool
+	// How long to wait for each release to be ready
+	Timeout time.Duration
+}
+
+// Mesh is the Istio control plane, with its CRDs and optional ingress gateway
+type Mesh struct {
+	pulumi.ResourceState
+
+	// Chart version, resolved once istiod is installed
+	Version pulumi.StringOutput `pulumi:"version"`
+}
+
+// NewMesh installs the base (CRDs), istiod and gateway charts, each after the
+// previous one
+func NewMesh(ctx *pulumi.Context, name string, args *MeshArgs, opts ...pulumi.ResourceOption) (*Mesh, error) {
+	mesh := &Mesh{}
+	err := ctx.RegisterComponentResource("home:istio:Mesh", name, mesh, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace, err := corev1.NewNamespace(ctx, fmt.Sprintf("%s-namespace", name), &corev1.NamespaceArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name: pulumi.String(Namespace),
+		},
+	}, pulumi.Parent(mesh))
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := helmv3.NewRelease(ctx, stacks.Name(ctx, "istio-base"), &helmv3.ReleaseArgs{
+		Name:           pulumi.String("istio-base"),
+		Chart:          pulumi.String("base"),
+		Version:        pulumi.String(args.Version),
+		Namespace:      namespace.Metadata.Name(),
+		RepositoryOpts: helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+		Timeout:        pulumi.Int(int(args.Timeout.Seconds())),
+		Values:         pulumi.ToMap(args.Values.Base),
+	}, pulumi.Parent(mesh))
+	if err != nil {
+		return nil, err
+	}
+
+	istiod, err := helmv3.NewRelease(ctx, stacks.Name(ctx, "istiod"), &helmv3.ReleaseArgs{
+		Name:           pulumi.String("istiod"),
+		Chart:          pulumi.String("istiod"),
+		Version:        pulumi.String(args.Version),
+		Namespace:      namespace.Metadata.Name(),
+		RepositoryOpts: helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+		Timeout:        pulumi.Int(int(args.Timeout.Seconds())),
+		Values:         pulumi.ToMap(args.Values.Istiod),
+	}, pulumi.Parent(mesh), pulumi.DependsOn([]pulumi.Resource{base}))
+	if err != nil {
+		return nil, err
+	}
+
+	if args.Gateway {
+		gatewayNamespace, err := corev1.NewNamespace(ctx, fmt.Sprintf("%s-gateway-namespace", name), &corev1.NamespaceArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name: pulumi.String(GatewayNamespace),
+				// The gateway pods get their proxy from the injection template
+				Labels: pulumi.StringMap{InjectionLabel: pulumi.String("enabled")},
+			},
+		}, pulumi.Parent(mesh))
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = helmv3.NewRelease(ctx, stacks.Name(ctx, "istio-ingressgateway"), &helmv3.ReleaseArgs{
+			Name:           pulumi.String("istio-ingressgateway"),
+			Chart:          pulumi.String("gateway"),
+			Version:        pulumi.String(args.Version),
+			Namespace:      gatewayNamespace.Metadata.Name(),
+			RepositoryOpts: helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+			Timeout:        pulumi.Int(int(args.Timeout.Seconds())),
+			Values:         pulumi.ToMap(args.Values.Gateway),
+		}, pulumi.Parent(mesh), pulumi.DependsOn([]pulumi.Resource{istiod}))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mesh.Version = istiod.Status.ApplyT(func(helmv3.ReleaseStatus) string {
+		return args.Version
+	}).(pulumi.StringOutput)
+	err = ctx.RegisterResourceOutputs(mesh, pulumi.Map{
+		"version": mesh.Version,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return mesh, nil
+}
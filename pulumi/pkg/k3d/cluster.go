@@ -0,0 +1,174 @@
+package k3d
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/shell"
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// ClusterArgs configures a k3d cluster
+type ClusterArgs struct {
+	// Name of the k3d cluster
+	Name string
+	// Number of agent (worker) nodes
+	Agents int
+	// rancher/k3s image to use (optional)
+	NodeImage string
+	// Port mappings passed to k3d cluster create --port, e.g. 80:80@loadbalancer
+	Ports []string
+	// Kubeconfig file the context of the cluster is written to, for the CLIs
+	// (optional, defaults to $KUBECONFIG or ~/.kube/config)
+	KubeconfigFile string
+	// How long creating (or reusing) the cluster may take
+	CreateTimeout time.Duration
+	// How long to wait for all nodes to become Ready
+	Timeout time.Duration
+	// Always delete and recreate the cluster instead of reusing an existing one
+	Recreate bool
+	// Bumped when the cluster was deleted outside of Pulumi, replacing the
+	// commands creating it and reading its kubeconfig so both run again
+	Generation int
+}
+
+// Cluster is a k3d cluster whose outputs resolve once all nodes are Ready
+type Cluster struct {
+	pulumi.ResourceState
+
+	// Name of the k3d cluster
+	Name pulumi.StringOutput `pulumi:"name"`
+	// Kube context of the cluster (k3d-<name>)
+	Context pulumi.StringOutput `pulumi:"context"`
+	// Kubeconfig of the cluster (secret)
+	Kubeconfig pulumi.StringOutput `pulumi:"kubeconfig"`
+	// Output of the create command, not registered as an output
+	Output *shell.Output
+}
+
+// KubeContext returns the kube context k3d creates for the named cluster
+func KubeContext(name string) string {
+	return fmt.Sprintf("k3d-%s", name)
+}
+
+// DockerNetwork returns the Docker network k3d creates for the named cluster
+func DockerNetwork(name string) string {
+	return fmt.Sprintf("k3d-%s", name)
+}
+
+// Exists reports whether `k3d cluster list` lists the named cluster
+func Exists(name string) (bool, error) {
+	out, err := exec.Command("k3d", "cluster", "list", "--output", "json").Output()
+	if err != nil {
+		return false, fmt.Errorf("failed to list the k3d clusters: %w", err)
+	}
+	var clusters []struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(out, &clusters); err != nil {
+		return false, fmt.Errorf("failed to parse the k3d clusters: %w", err)
+	}
+	for _, cluster := range clusters {
+		if cluster.Name == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// NewCluster creates (or reuses) a k3d cluster and waits for its nodes to be Ready
+func NewCluster(ctx *pulumi.Context, name string, args *ClusterArgs, opts ...pulumi.ResourceOption) (*Cluster, error) {
+	cluster := &Cluster{}
+	err := ctx.RegisterComponentResource("home:k3d:Cluster", name, cluster, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Update runs the same command so input changes don't replace (delete) the
+	// cluster, and a replacement deletes the old cluster before creating the new one
+	ensure := ensureCommand(args.Name, args.Agents, args.NodeImage, args.Ports, args.KubeconfigFile, args.Recreate)
+	ensure = shell.Guard(ensure, shell.GuardOptions{
+		Step:     fmt.Sprintf("creating k3d cluster %s", args.Name),
+		Timeout:  args.CreateTimeout,
+		Diagnose: fmt.Sprintf("k3d node list | grep -E '^NAME|k3d-%s-'", args.Name),
+	})
+	create, err := local.NewCommand(ctx, stacks.Name(ctx, fmt.Sprintf("create-k3d-cluster-%s", args.Name)), &local.CommandArgs{
+		Create:      pulumi.String(ensure),
+		Update:      pulumi.String(ensure),
+		Delete:      pulumi.String(deleteCommand(args.Name)),
+		Triggers:    generationTriggers(args.Generation),
+		Interpreter: shell.Interpreter(),
+	}, pulumi.Parent(cluster), pulumi.DeleteBeforeReplace(true))
+	if err != nil {
+		return nil, err
+	}
+
+	// Re-read whenever the cluster command runs again (e.g. recreation)
+	kubeconfig, err := local.NewCommand(ctx, stacks.Name(ctx, fmt.Sprintf("kubeconfig-%s", args.Name)), &local.CommandArgs{
+		Create:      pulumi.String(kubeconfigCommand(args.Name)),
+		Logging:     local.LoggingNone,
+		Triggers:    generationTriggers(args.Generation, create.Stdout),
+		Interpreter: shell.Interpreter(),
+	}, pulumi.Parent(cluster), pulumi.DependsOn([]pulumi.Resource{create}),
+		pulumi.AdditionalSecretOutputs([]string{"stdout"}))
+	if err != nil {
+		return nil, err
+	}
+
+	// k3d --wait only waits for the servers, wait for the agents as well
+	readyKubeconfig := kubeconfig.Stdout.ApplyT(func(config string) (string, error) {
+		if ctx.DryRun() {
+			return config, nil
+		}
+		client, err := kube.NewClientsetFromKubeconfig(config)
+		if err != nil {
+			return "", err
+		}
+		err = kube.WaitForNodesReady(context.Background(), client, kube.PollOptions{
+			Description: fmt.Sprintf("nodes of %s to become Ready", args.Name),
+			Timeout:     args.Timeout,
+			Logf: func(format string, a ...interface{}) {
+				_ = ctx.Log.Info(fmt.Sprintf(format, a...), &pulumi.LogArgs{Resource: cluster})
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+		return config, nil
+	}).(pulumi.StringOutput)
+
+	cluster.Name = pulumi.String(args.Name).ToStringOutput()
+	cluster.Context = pulumi.String(KubeContext(args.Name)).ToStringOutput()
+	cluster.Kubeconfig = pulumi.ToSecret(readyKubeconfig).(pulumi.StringOutput)
+	cluster.Output = shell.CommandOutput(create)
+	err = ctx.RegisterResourceOutputs(cluster, pulumi.Map{
+		"name":       cluster.Name,
+		"context":    cluster.Context,
+		"kubeconfig": cluster.Kubeconfig,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cluster, nil
+}
+
+// generationTriggers returns triggers followed by the generation of the
+// cluster, left out until the cluster is first recreated after an out-of-band
+// deletion so the commands of existing stacks keep their inputs
+func generationTriggers(generation int, triggers ...pulumi.Input) pulumi.ArrayInput {
+	if generation > 0 {
+		triggers = append(triggers, pulumi.Int(generation))
+	}
+	if len(triggers) == 0 {
+		return nil
+	}
+	return pulumi.Array(triggers)
+}
@@ -0,0 +1,60 @@
+package k3d
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"cluster-studio/pkg/shell"
+)
+
+// ensureCommand returns a shell command that makes sure the k3d cluster
+// exists and its context is in kubeconfigFile for the CLIs (flux, linkerd),
+// or in ~/.kube/config without it. An existing cluster whose API server
+// responds is reused; anything else is (re)created. With recreate set the
+// cluster is always deleted and created from scratch.
+func ensureCommand(name string, agents int, image string, ports []string, kubeconfigFile string, recreate bool) string {
+	createFlags := fmt.Sprintf("--agents %d --wait", agents)
+	if image != "" {
+		createFlags += fmt.Sprintf(" --image %s", image)
+	}
+	for _, port := range ports {
+		createFlags += fmt.Sprintf(" --port %s", port)
+	}
+	merge := fmt.Sprintf("k3d kubeconfig merge %s --kubeconfig-merge-default --kubeconfig-switch-context=false", name)
+	kubectl := "kubectl"
+	if kubeconfigFile != "" {
+		createFlags += " --kubeconfig-update-default=false"
+		merge = fmt.Sprintf("mkdir -p %[2]s && k3d kubeconfig merge %[1]s --output %[3]s",
+			name, shell.Quote(filepath.Dir(kubeconfigFile)), shell.Quote(kubeconfigFile))
+		kubectl += " --kubeconfig " + shell.Quote(kubeconfigFile)
+	} else {
+		createFlags += " --kubeconfig-update-default --kubeconfig-switch-context=false"
+	}
+	create := fmt.Sprintf("%[3]s\nk3d cluster create %[1]s %[2]s", name, createFlags, deleteCommand(name))
+	if kubeconfigFile != "" {
+		create += " && " + merge + " >/dev/null"
+	}
+	if recreate {
+		return create
+	}
+
+	return fmt.Sprintf(`if k3d cluster get %[1]s >/dev/null 2>&1 && %[3]s >/dev/null && %[4]s --context k3d-%[1]s get --raw /readyz >/dev/null 2>&1; then
+  echo "Reusing existing k3d cluster %[1]s"
+else
+  %[2]s
+fi`, name, create, merge, kubectl)
+}
+
+// deleteCommand returns a shell command that deletes the k3d cluster unless
+// it is already gone, failing with the error of k3d otherwise
+func deleteCommand(name string) string {
+	return fmt.Sprintf(`if k3d cluster get %[1]s >/dev/null 2>&1; then
+  k3d cluster delete %[1]s
+fi`, name)
+}
+
+// kubeconfigCommand returns a shell command that prints the cluster
+// kubeconfig to stdout without touching ~/.kube/config
+func kubeconfigCommand(name string) string {
+	return fmt.Sprintf("k3d kubeconfig get %s", name)
+}
@@ -0,0 +1,17 @@
+package k3d
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NodeImage returns the rancher/k3s image for a full Kubernetes version such
+// as "1.31.4". k3s images are tagged per patch release, so a minor version
+// alone cannot be resolved.
+func NodeImage(kubernetesVersion string) (string, error) {
+	version := strings.TrimPrefix(kubernetesVersion, "v")
+	if strings.Count(version, ".") != 2 {
+		return "", fmt.Errorf("k3d needs a full Kubernetes version such as 1.31.4, got %q", kubernetesVersion)
+	}
+	return fmt.Sprintf("rancher/k3s:v%s-k3s1", version), nil
+}
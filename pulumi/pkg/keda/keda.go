@@ -0,0 +1,188 @@
+package keda
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// ChartRepo is the Helm repository serving the KEDA chart
+	ChartRepo = "https://kedacore.github.io/charts"
+	// Namespace is where KEDA is installed
+	Namespace = "keda"
+)
+
+// crds must be Established before ScaledObjects can be created
+var crds = []string{
+	"scaledobjects.keda.sh",
+	"triggerauthentications.keda.sh",
+}
+
+// ScaledObject scales a Deployment on one trigger
+type ScaledObject struct {
+	// Name and namespace of the ScaledObject
+	Name      string
+	Namespace string
+	// Deployment scaled, in the same namespace
+	Deployment string
+	// Replica bounds, zero MinReplicas scales the Deployment to zero
+	MinReplicas int
+	MaxReplicas int
+	// Trigger type (e.g. "prometheus", "cron") and its metadata, for
+	// prometheus serverAddress, query and threshold
+	Trigger  string
+	Metadata map[string]string
+}
+
+// InstallArgs configures KEDA
+type InstallArgs struct {
+	// Chart version
+	Version string
+	// Kubeconfig of the cluster (secret), used to wait for the CRDs
+	Kubeconfig pulumi.StringInput
+	// How long to wait for the release and the CRDs
+	Timeout time.Duration
+}
+
+// Install is the KEDA operator
+type Install struct {
+	pulumi.ResourceState
+
+	// Resolves to the release once the CRDs are Established
+	Established pulumi.ResourceArrayOutput
+}
+
+// NewInstall installs KEDA with Helm. Resources depending on Established
+// are only created once its CRDs can serve them.
+func NewInstall(ctx *pulumi.Context, name string, args *InstallArgs, opts ...pulumi.ResourceOption) (*Install, error) {
+	install := &Install{}
+	err := ctx.RegisterComponentResource("home:keda:Install", name, install, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := helmv3.NewRelease(ctx, stacks.Name(ctx, "keda"), &helmv3.ReleaseArgs{
+		Name:            pulumi.String("keda"),
+		Chart:           pulumi.String("keda"),
+		Version:         pulumi.String(args.Version),
+		Namespace:       pulumi.String(Namespace),
+		CreateNamespace: pulumi.Bool(true),
+		RepositoryOpts:  helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+		Timeout:         pulumi.Int(int(args.Timeout.Seconds())),
+	}, pulumi.Parent(install))
+	if err != nil {
+		return nil, err
+	}
+
+	install.Established = pulumi.All(release.ID(), args.Kubeconfig).ApplyT(func(values []interface{}) ([]pulumi.Resource, error) {
+		if ctx.DryRun() {
+			return []pulumi.Resource{release}, nil
+		}
+		client, err := kube.NewDynamicClientFromKubeconfig(values[1].(string))
+		if err != nil {
+			return nil, err
+		}
+		err = kube.WaitForCRDsEstablished(context.Background(), client, crds, kube.PollOptions{
+			Description: "KEDA CRDs to become Established",
+			Timeout:     args.Timeout,
+			Logf: func(format string, a ...interface{}) {
+				_ = ctx.Log.Info(fmt.Sprintf(format, a...), &pulumi.LogArgs{Resource: install})
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []pulumi.Resource{release}, nil
+	}).(pulumi.ResourceArrayOutput)
+
+	err = ctx.RegisterResourceOutputs(install, pulumi.Map{})
+	if err != nil {
+		return nil, err
+	}
+
+	return install, nil
+}
+
+// ScaledObjectsArgs configures the ScaledObjects
+type ScaledObjectsArgs struct {
+	ScaledObjects []ScaledObject
+	// KEDA, whose CRDs the ScaledObjects wait for
+	Install *Install
+	// Resources the ScaledObjects with a prometheus trigger wait for (e.g.
+	// the monitoring stack)
+	PrometheusDeps []pulumi.Resource
+}
+
+// ScaledObjects are the ScaledObjects created from config
+type ScaledObjects struct {
+	pulumi.ResourceState
+
+	// namespace/deployment of every scaled Deployment
+	Targets pulumi.StringArrayOutput `pulumi:"targets"`
+}
+
+// NewScaledObjects creates one ScaledObject per entry once the KEDA CRDs are
+// Established, those querying Prometheus after it
+func NewScaledObjects(ctx *pulumi.Context, name string, args *ScaledObjectsArgs, opts ...pulumi.ResourceOption) (*ScaledObjects, error) {
+	scaled := &ScaledObjects{}
+	err := ctx.RegisterComponentResource("home:keda:ScaledObjects", name, scaled, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []string
+	for _, object := range args.ScaledObjects {
+		resourceOpts := []pulumi.ResourceOption{pulumi.Parent(scaled), pulumi.DependsOnInputs(args.Install.Established)}
+		if object.Trigger == "prometheus" {
+			resourceOpts = append(resourceOpts, pulumi.DependsOn(args.PrometheusDeps))
+		}
+		_, err := apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-%s-%s", name, object.Namespace, object.Name), &apiextensions.CustomResourceArgs{
+			ApiVersion: pulumi.String("keda.sh/v1alpha1"),
+			Kind:       pulumi.String("ScaledObject"),
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:      pulumi.String(object.Name),
+				Namespace: pulumi.String(object.Namespace),
+			},
+			OtherFields: kubernetes.UntypedArgs{
+				"spec": pulumi.Map{
+					"scaleTargetRef": pulumi.Map{
+						"kind": pulumi.String("Deployment"),
+						"name": pulumi.String(object.Deployment),
+					},
+					"minReplicaCount": pulumi.Int(object.MinReplicas),
+					"maxReplicaCount": pulumi.Int(object.MaxReplicas),
+					"triggers": pulumi.Array{
+						pulumi.Map{
+							"type":     pulumi.String(object.Trigger),
+							"metadata": pulumi.ToStringMap(object.Metadata),
+						},
+					},
+				},
+			},
+		}, resourceOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("ScaledObject %s/%s: %w", object.Namespace, object.Name, err)
+		}
+		targets = append(targets, fmt.Sprintf("%s/%s", object.Namespace, object.Deployment))
+	}
+
+	scaled.Targets = pulumi.ToStringArray(targets).ToStringArrayOutput()
+	err = ctx.RegisterResourceOutputs(scaled, pulumi.Map{
+		"targets": scaled.Targets,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return scaled, nil
+}
@@ -0,0 +1,171 @@
+package kind
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"cluster-studio/pkg/shell"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// LocalImage is an image built from a Dockerfile of the repository
+type LocalImage struct {
+	// Repository of the image, replaced in the manifests by the built
+	// image, e.g. home/api
+	Name string `json:"name"`
+	// Build context
+	Context string `json:"context"`
+	// Dockerfile, relative to Context (default Dockerfile)
+	Dockerfile string `json:"dockerfile"`
+	// Tag of the built image, defaults to the content hash of the build
+	// context so each change rolls out the workloads using it
+	Tag string `json:"tag"`
+}
+
+// buildScript builds $IMAGE and loads it into the nodes of $CLUSTER, or
+// pushes it when it is tagged for the local registry
+const buildScript = `set -eu
+docker build -t "$IMAGE" -f "$DOCKERFILE" "$CONTEXT"
+if [ -n "$REGISTRY" ]; then
+  docker push "$IMAGE"
+else
+  kind load docker-image "$IMAGE" --name "$CLUSTER"
+fi`
+
+// ImageBuildArgs configures the build of a local image
+type ImageBuildArgs struct {
+	// Cluster whose nodes run the image
+	Cluster *Cluster
+	// Image to build
+	Image LocalImage
+	// Endpoint of the local registry the image is pushed to (e.g.
+	// localhost:5001), empty to load it into the nodes instead
+	Registry string
+}
+
+// ImageBuild is an image built from the repository and made available to
+// the nodes of a Kind cluster
+type ImageBuild struct {
+	pulumi.ResourceState
+
+	// Reference of the built image, known before the build so it can be
+	// substituted into the manifests
+	Image string
+	// Reference of the built image, resolved once the nodes can run it
+	Ref pulumi.StringOutput `pulumi:"ref"`
+}
+
+// NewImageBuild builds the image with docker build and loads it into the
+// nodes, or pushes it to the local registry. It rebuilds whenever the
+// content of the build context or the Dockerfile changes.
+func NewImageBuild(ctx *pulumi.Context, name string, args *ImageBuildArgs, opts ...pulumi.ResourceOption) (*ImageBuild, error) {
+	build := &ImageBuild{}
+	err := ctx.RegisterComponentResource("home:kind:ImageBuild", name, build, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	dockerfile := args.Image.Dockerfile
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	dockerfile = filepath.Join(args.Image.Context, dockerfile)
+	hash, err := ContextHash(args.Image.Context, dockerfile)
+	if err != nil {
+		return nil, fmt.Errorf("image %s: %w", args.Image.Name, err)
+	}
+	tag := args.Image.Tag
+	if tag == "" {
+		tag = hash[:12]
+	}
+	build.Image = fmt.Sprintf("%s:%s", args.Image.Name, tag)
+	if args.Registry != "" {
+		build.Image = fmt.Sprintf("%s/%s", args.Registry, build.Image)
+	}
+
+	command, err := local.NewCommand(ctx, fmt.Sprintf("%s-build", name), &local.CommandArgs{
+		Create: pulumi.String(buildScript),
+		Update: pulumi.String(buildScript),
+		Environment: pulumi.StringMap{
+			"CLUSTER":    args.Cluster.Name,
+			"IMAGE":      pulumi.String(build.Image),
+			"CONTEXT":    pulumi.String(args.Image.Context),
+			"DOCKERFILE": pulumi.String(dockerfile),
+			"REGISTRY":   pulumi.String(args.Registry),
+		},
+		// The nodes of a recreated cluster no longer have the image
+		Triggers:    pulumi.Array{pulumi.String(hash), args.Cluster.Kubeconfig},
+		Interpreter: shell.Interpreter(),
+	}, pulumi.Parent(build), pulumi.DependsOn([]pulumi.Resource{args.Cluster}))
+	if err != nil {
+		return nil, err
+	}
+
+	build.Ref = command.Stdout.ApplyT(func(string) string {
+		return build.Image
+	}).(pulumi.StringOutput)
+	err = ctx.RegisterResourceOutputs(build, pulumi.Map{
+		"ref": build.Ref,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return build, nil
+}
+
+// ContextHash returns the SHA-256 of the paths, modes and contents of the
+// files of the build context and of the Dockerfile, which may live outside of
+// it. The .git directory is skipped.
+func ContextHash(context, dockerfile string) (string, error) {
+	hash := sha256.New()
+	addFile := func(path, name string, mode fs.FileMode) error {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		fmt.Fprintf(hash, "%s %o\n", name, mode.Perm())
+		_, err = io.Copy(hash, file)
+		return err
+	}
+
+	err := filepath.WalkDir(context, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && entry.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(context, path)
+		if err != nil {
+			return err
+		}
+		return addFile(path, filepath.ToSlash(rel), info.Mode())
+	})
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(dockerfile)
+	if err != nil {
+		return "", err
+	}
+	if err := addFile(dockerfile, "Dockerfile", info.Mode()); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
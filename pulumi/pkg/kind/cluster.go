@@ -0,0 +1,197 @@
+package kind
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/shell"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// ClusterArgs configures a Kind cluster
+type ClusterArgs struct {
+	// Name of the Kind cluster
+	Name string
+	// Path to a Kind cluster config file, takes precedence over Config
+	ConfigFile string
+	// Kind cluster config (YAML), e.g. rendered with Config.Render
+	Config string
+	// kindest/node image to use, overriding the config file (optional)
+	NodeImage string
+	// Kubeconfig file the context of the cluster is written to, for the CLIs
+	// (optional, defaults to $KUBECONFIG or ~/.kube/config)
+	KubeconfigFile string
+	// How long creating (or reusing) the cluster may take
+	CreateTimeout time.Duration
+	// How long to wait for all nodes to become Ready
+	Timeout time.Duration
+	// Hand out the kubeconfig without waiting for the nodes, which only
+	// become Ready once the CNI the caller installs is up
+	SkipNodeWait bool
+	// Always delete and recreate the cluster instead of reusing an existing one
+	Recreate bool
+	// Bumped when the cluster was deleted outside of Pulumi, replacing it
+	Generation int
+	// Docker network of the nodes (default DefaultNetwork). Kind creates it
+	// when missing, and only a network it created is removed with the cluster.
+	Network string
+	// Memory and CPU limits of every node (optional)
+	Limits NodeLimits
+}
+
+// Cluster is a Kind cluster whose outputs resolve once all nodes are Ready,
+// unless ClusterArgs.SkipNodeWait is set
+type Cluster struct {
+	pulumi.ResourceState
+
+	// Name of the Kind cluster
+	Name pulumi.StringOutput `pulumi:"name"`
+	// Kube context of the cluster (kind-<name>)
+	Context pulumi.StringOutput `pulumi:"context"`
+	// Kubeconfig of the cluster (secret)
+	Kubeconfig pulumi.StringOutput `pulumi:"kubeconfig"`
+	// Memory limit of the nodes in bytes and their number of CPUs as set on
+	// the control plane, 0 for none
+	MemoryLimit pulumi.Float64Output `pulumi:"memoryLimit"`
+	CPULimit    pulumi.Float64Output `pulumi:"cpuLimit"`
+	// Docker network of the nodes
+	Network string
+	// Log of the creation of the cluster, not registered as an output
+	Output *shell.Output
+}
+
+// KubeContext returns the kube context Kind creates for the named cluster
+func KubeContext(name string) string {
+	return fmt.Sprintf("kind-%s", name)
+}
+
+// Exists reports whether `kind get clusters` lists the named cluster
+func Exists(name string) (bool, error) {
+	return existsContext(context.Background(), name)
+}
+
+// clusterResource is the Kind cluster managed by the provider (see Provider)
+type clusterResource struct {
+	pulumi.CustomResourceState
+
+	// Nodes of the cluster and the image of its control plane, as read on
+	// the last refresh
+	Nodes pulumi.StringArrayOutput `pulumi:"nodes"`
+	Image pulumi.StringOutput      `pulumi:"image"`
+	// Kubeconfig of the cluster (secret)
+	Kubeconfig pulumi.StringOutput `pulumi:"kubeconfig"`
+	// Progress kind reported creating (or reusing) the cluster
+	Log pulumi.StringOutput `pulumi:"log"`
+	// Limits read from the control plane
+	MemoryLimit pulumi.Float64Output `pulumi:"memoryLimit"`
+	CPULimit    pulumi.Float64Output `pulumi:"cpuLimit"`
+}
+
+// NewCluster creates (or reuses) a Kind cluster and waits for its nodes to be Ready
+func NewCluster(ctx *pulumi.Context, name string, args *ClusterArgs, opts ...pulumi.ResourceOption) (*Cluster, error) {
+	cluster := &Cluster{}
+	err := ctx.RegisterComponentResource("home:kind:Cluster", name, cluster, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := pulumi.Map{
+		"name":     pulumi.String(args.Name),
+		"recreate": pulumi.Bool(args.Recreate),
+	}
+	if args.ConfigFile != "" {
+		inputs["configFile"] = pulumi.String(args.ConfigFile)
+	} else {
+		inputs["config"] = pulumi.String(args.Config)
+	}
+	if args.NodeImage != "" {
+		inputs["nodeImage"] = pulumi.String(args.NodeImage)
+	}
+	if args.KubeconfigFile != "" {
+		inputs["kubeconfigFile"] = pulumi.String(args.KubeconfigFile)
+	}
+	// Left out unless set, so existing clusters aren't replaced
+	if args.Network != "" && args.Network != DefaultNetwork {
+		inputs["network"] = pulumi.String(args.Network)
+	}
+	if args.Limits.Memory > 0 {
+		inputs["memory"] = pulumi.Float64(float64(args.Limits.Memory))
+	}
+	if args.Limits.CPUs > 0 {
+		inputs["cpus"] = pulumi.Float64(args.Limits.CPUs)
+	}
+	// Left out until the cluster is first recreated after an out-of-band
+	// deletion, replacing it
+	if args.Generation > 0 {
+		inputs["generation"] = pulumi.Int(args.Generation)
+	}
+
+	// The cluster used to be a command creating it, at the top level of the
+	// stack and then in the component: the provider adopts it in place
+	legacyName := pulumi.String(fmt.Sprintf("create-kind-cluster-%s", args.Name))
+	legacyType := pulumi.String("command:local:Command")
+	resourceOpts := []pulumi.ResourceOption{
+		pulumi.Parent(cluster),
+		pulumi.Aliases([]pulumi.Alias{
+			{Name: legacyName, Type: legacyType, NoParent: pulumi.Bool(true)},
+			{Name: legacyName, Type: legacyType},
+		}),
+		pulumi.AdditionalSecretOutputs([]string{"kubeconfig"}),
+	}
+	if args.CreateTimeout > 0 {
+		timeout := args.CreateTimeout.String()
+		resourceOpts = append(resourceOpts, pulumi.Timeouts(&pulumi.CustomTimeouts{Create: timeout, Update: timeout}))
+	}
+	created := &clusterResource{}
+	err = ctx.RegisterResource(ClusterType, fmt.Sprintf("kind-cluster-%s", args.Name), inputs, created, resourceOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Wait for all nodes to be Ready before handing out the kubeconfig
+	readyKubeconfig := created.Kubeconfig.ApplyT(func(config string) (string, error) {
+		if ctx.DryRun() || args.SkipNodeWait {
+			return config, nil
+		}
+		client, err := kube.NewClientsetFromKubeconfig(config)
+		if err != nil {
+			return "", err
+		}
+		err = kube.WaitForNodesReady(context.Background(), client, kube.PollOptions{
+			Description: fmt.Sprintf("nodes of %s to become Ready", args.Name),
+			Timeout:     args.Timeout,
+			Logf: func(format string, a ...interface{}) {
+				_ = ctx.Log.Info(fmt.Sprintf(format, a...), &pulumi.LogArgs{Resource: cluster})
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+		return config, nil
+	}).(pulumi.StringOutput)
+
+	cluster.Network = args.Network
+	if cluster.Network == "" {
+		cluster.Network = DefaultNetwork
+	}
+	cluster.MemoryLimit, cluster.CPULimit = created.MemoryLimit, created.CPULimit
+	cluster.Name = pulumi.String(args.Name).ToStringOutput()
+	cluster.Context = pulumi.String(KubeContext(args.Name)).ToStringOutput()
+	cluster.Kubeconfig = pulumi.ToSecret(readyKubeconfig).(pulumi.StringOutput)
+	cluster.Output = &shell.Output{Stdout: pulumi.String("").ToStringOutput(), Stderr: created.Log}
+	err = ctx.RegisterResourceOutputs(cluster, pulumi.Map{
+		"name":        cluster.Name,
+		"context":     cluster.Context,
+		"kubeconfig":  cluster.Kubeconfig,
+		"memoryLimit": cluster.MemoryLimit,
+		"cpuLimit":    cluster.CPULimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cluster, nil
+}
@@ -0,0 +1,73 @@
+package kind
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// removeContainerCommand returns a shell command that removes a container
+// unless it is already gone, failing with the error of docker otherwise
+func removeContainerCommand(name string) string {
+	return fmt.Sprintf(`if docker inspect %[1]s >/dev/null 2>&1; then
+  docker rm -f %[1]s
+fi`, name)
+}
+
+// configureNodesCommand returns a shell command that connects the registry
+// container to the network of the nodes and makes containerd on every node of
+// the cluster pull endpoint from it
+func configureNodesCommand(containerName, clusterName, endpoint, network string) string {
+	return fmt.Sprintf(`if [ "$(docker inspect -f '{{json (index .NetworkSettings.Networks "%[4]s")}}' %[1]s)" = null ]; then
+  docker network connect %[4]s %[1]s
+fi
+for node in $(kind get nodes --name %[2]s); do
+  docker exec "$node" mkdir -p /etc/containerd/certs.d/%[3]s
+  printf '[host."http://%[1]s:5000"]\n' | docker exec -i "$node" cp /dev/stdin /etc/containerd/certs.d/%[3]s/hosts.toml
+done`, containerName, clusterName, endpoint, network)
+}
+
+// configureMirrorsCommand returns a shell command that writes a containerd
+// hosts.toml on every node of the cluster, pulling each registry through its
+// mirror first and falling back to the registry itself
+func configureMirrorsCommand(clusterName string, mirrors map[string]string) string {
+	registries := make([]string, 0, len(mirrors))
+	for registry := range mirrors {
+		registries = append(registries, registry)
+	}
+	sort.Strings(registries)
+
+	var script strings.Builder
+	fmt.Fprintf(&script, "for node in $(kind get nodes --name %s); do\n", clusterName)
+	for _, registry := range registries {
+		hosts := fmt.Sprintf(`[host."%s"]\n  capabilities = ["pull", "resolve"]\n`, mirrors[registry])
+		fmt.Fprintf(&script, "  docker exec \"$node\" mkdir -p /etc/containerd/certs.d/%s\n", registry)
+		fmt.Fprintf(&script, "  printf '%s' | docker exec -i \"$node\" cp /dev/stdin /etc/containerd/certs.d/%s/hosts.toml\n", hosts, registry)
+	}
+	script.WriteString("done")
+	return script.String()
+}
+
+// configureGPUCommand returns a shell command that installs the NVIDIA
+// container toolkit on the nodes the host injected GPUs into and makes it
+// containerd's default runtime. A node without /dev/nvidia0 means the host is
+// not set up to pass GPUs to containers.
+func configureGPUCommand(clusterName string) string {
+	return fmt.Sprintf(`set -e
+for node in $(kind get nodes --name %[1]s); do
+  docker exec "$node" test -e /var/run/nvidia-container-devices/all || continue
+  if ! docker exec "$node" sh -c 'ls /dev/nvidia0' >/dev/null 2>&1; then
+    echo "Kind node $node has no NVIDIA device: install the NVIDIA driver and nvidia-container-toolkit on the host, set accept-nvidia-visible-devices-as-volume-mounts = true in /etc/nvidia-container-runtime/config.toml and make nvidia Docker's default runtime (nvidia-ctk runtime configure --runtime=docker --set-as-default), then recreate the cluster" >&2
+    exit 1
+  fi
+  docker exec "$node" umount -R /proc/driver/nvidia 2>/dev/null || true
+  docker exec "$node" sh -c 'command -v nvidia-ctk >/dev/null || {
+    apt-get update -q && apt-get install -yq curl gpg &&
+    curl -fsSL %[2]s/gpgkey | gpg --dearmor -o /usr/share/keyrings/nvidia-container-toolkit-keyring.gpg &&
+    curl -fsSL %[2]s/stable/deb/nvidia-container-toolkit.list | sed "s#deb https://#deb [signed-by=/usr/share/keyrings/nvidia-container-toolkit-keyring.gpg] https://#" > /etc/apt/sources.list.d/nvidia-container-toolkit.list &&
+    apt-get update -q && apt-get install -yq nvidia-container-toolkit
+  }'
+  docker exec "$node" nvidia-ctk runtime configure --runtime=containerd --set-as-default
+  docker exec "$node" systemctl restart containerd
+done`, clusterName, nvidiaContainerToolkitRepo)
+}
@@ -0,0 +1,189 @@
+package kind
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PortMapping forwards a host port to a port of the control-plane node
+type PortMapping struct {
+	ContainerPort int    `json:"containerPort" yaml:"containerPort"`
+	HostPort      int    `json:"hostPort" yaml:"hostPort"`
+	ListenAddress string `json:"listenAddress,omitempty" yaml:"listenAddress,omitempty"`
+	Protocol      string `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+}
+
+// Mount makes a host path available inside every node
+type Mount struct {
+	HostPath      string `json:"hostPath" yaml:"hostPath"`
+	ContainerPath string `json:"containerPath" yaml:"containerPath"`
+	ReadOnly      bool   `json:"readOnly,omitempty" yaml:"readOnly,omitempty"`
+}
+
+// DefaultPortMappings exposes the ingress controller on the host
+var DefaultPortMappings = []PortMapping{
+	{ContainerPort: 80, HostPort: 80, Protocol: "TCP"},
+	{ContainerPort: 443, HostPort: 443, Protocol: "TCP"},
+}
+
+// Config describes a generated Kind cluster config: one control-plane node
+// labelled ingress-ready=true and a number of workers
+type Config struct {
+	// Name of the Kind cluster
+	Name string
+	// Number of worker nodes
+	Workers int
+	// Port mappings of the control-plane node
+	PortMappings []PortMapping
+	// Mounts added to every node
+	Mounts []Mount
+	// Kubernetes feature gates enabled or disabled on all components
+	FeatureGates map[string]bool
+	// Raw TOML patches merged into the containerd config of every node
+	ContainerdConfigPatches []string
+	// Expose the host's NVIDIA GPUs to the first worker (or the control-plane
+	// without workers), labelled nvidia.com/gpu.present=true
+	GPU bool
+	// Create the cluster without kindnet, its nodes stay NotReady until a
+	// CNI is installed
+	DisableDefaultCNI bool
+	// Mode of kube-proxy: "iptables" (default), "ipvs" or "none" when the
+	// CNI replaces it
+	KubeProxyMode string
+	// IP family and subnets, the defaults of Kind when empty
+	Networking Networking
+	// Host address and port the API server is published on, 127.0.0.1 and
+	// a random port when empty
+	APIServerAddress string
+	APIServerPort    int
+	// Extra names and addresses the API server certificate is valid for,
+	// e.g. the LAN address of the host with APIServerAddress 0.0.0.0
+	APIServerCertSANs []string
+}
+
+// containerdRegistryPatch makes containerd read per-registry hosts.toml files
+// from /etc/containerd/certs.d, which is how registries are configured on the
+// nodes after creation (e.g. the local registry)
+const containerdRegistryPatch = `[plugins."io.containerd.grpc.v1.cri".registry]
+  config_path = "/etc/containerd/certs.d"`
+
+// controlPlanePatch labels the control-plane node so ingress controllers can
+// be scheduled on the node with the host port mappings
+const controlPlanePatch = `kind: InitConfiguration
+nodeRegistration:
+  kubeletExtraArgs:
+    node-labels: "ingress-ready=true"
+`
+
+// apiServerPatch adds the certificate SANs of the API server. The list
+// replaces the one of Kind, so its names are repeated.
+func (c *Config) apiServerPatch() string {
+	sans := []string{"localhost", "127.0.0.1"}
+	if c.APIServerAddress != "" && c.APIServerAddress != "0.0.0.0" {
+		sans = append(sans, c.APIServerAddress)
+	}
+	patch := "kind: ClusterConfiguration\napiServer:\n  certSANs:\n"
+	for _, san := range append(sans, c.APIServerCertSANs...) {
+		patch += fmt.Sprintf("  - %q\n", san)
+	}
+	return patch
+}
+
+// gpuMount asks the NVIDIA container runtime of the host to inject every GPU
+// into the node, which requires accept-nvidia-visible-devices-as-volume-mounts
+var gpuMount = Mount{HostPath: "/dev/null", ContainerPath: "/var/run/nvidia-container-devices/all"}
+
+// gpuPatch labels the node the GPUs are injected into, which the NVIDIA
+// device plugin is scheduled on
+const gpuPatch = `kind: %s
+nodeRegistration:
+  kubeletExtraArgs:
+    node-labels: "nvidia.com/gpu.present=true"
+`
+
+type clusterManifest struct {
+	Kind                    string          `yaml:"kind"`
+	APIVersion              string          `yaml:"apiVersion"`
+	Name                    string          `yaml:"name,omitempty"`
+	FeatureGates            map[string]bool `yaml:"featureGates,omitempty"`
+	ContainerdConfigPatches []string        `yaml:"containerdConfigPatches,omitempty"`
+	Networking              *networking     `yaml:"networking,omitempty"`
+	KubeadmConfigPatches    []string        `yaml:"kubeadmConfigPatches,omitempty"`
+	Nodes                   []nodeManifest  `yaml:"nodes"`
+}
+
+type networking struct {
+	Networking        `yaml:",inline"`
+	DisableDefaultCNI bool   `yaml:"disableDefaultCNI,omitempty"`
+	KubeProxyMode     string `yaml:"kubeProxyMode,omitempty"`
+	APIServerAddress  string `yaml:"apiServerAddress,omitempty"`
+	APIServerPort     int    `yaml:"apiServerPort,omitempty"`
+}
+
+type nodeManifest struct {
+	Role                 string        `yaml:"role"`
+	KubeadmConfigPatches []string      `yaml:"kubeadmConfigPatches,omitempty"`
+	ExtraPortMappings    []PortMapping `yaml:"extraPortMappings,omitempty"`
+	ExtraMounts          []Mount       `yaml:"extraMounts,omitempty"`
+}
+
+// Render returns the Kind cluster config as YAML
+func (c *Config) Render() (string, error) {
+	if c.Workers < 0 {
+		return "", fmt.Errorf("invalid number of workers %d", c.Workers)
+	}
+	if err := c.Networking.Validate(); err != nil {
+		return "", fmt.Errorf("invalid networking: %w", err)
+	}
+
+	manifest := clusterManifest{
+		Kind:                    "Cluster",
+		APIVersion:              "kind.x-k8s.io/v1alpha4",
+		Name:                    c.Name,
+		FeatureGates:            c.FeatureGates,
+		ContainerdConfigPatches: append([]string{containerdRegistryPatch}, c.ContainerdConfigPatches...),
+		Nodes: []nodeManifest{{
+			Role:                 "control-plane",
+			KubeadmConfigPatches: []string{controlPlanePatch},
+			ExtraPortMappings:    c.PortMappings,
+			ExtraMounts:          c.Mounts,
+		}},
+	}
+	if c.DisableDefaultCNI || c.KubeProxyMode != "" || c.Networking != (Networking{}) || c.APIServerAddress != "" || c.APIServerPort != 0 {
+		manifest.Networking = &networking{
+			Networking:        c.Networking,
+			DisableDefaultCNI: c.DisableDefaultCNI,
+			KubeProxyMode:     c.KubeProxyMode,
+			APIServerAddress:  c.APIServerAddress,
+			APIServerPort:     c.APIServerPort,
+		}
+	}
+	if len(c.APIServerCertSANs) > 0 {
+		manifest.KubeadmConfigPatches = []string{c.apiServerPatch()}
+	}
+	for i := 0; i < c.Workers; i++ {
+		manifest.Nodes = append(manifest.Nodes, nodeManifest{
+			Role:        "worker",
+			ExtraMounts: c.Mounts,
+		})
+	}
+	if c.GPU {
+		node := &manifest.Nodes[0]
+		if c.Workers > 0 {
+			node = &manifest.Nodes[1]
+			node.KubeadmConfigPatches = []string{fmt.Sprintf(gpuPatch, "JoinConfiguration")}
+		} else {
+			// The control-plane already sets node-labels in its InitConfiguration
+			node.KubeadmConfigPatches = []string{strings.Replace(controlPlanePatch, `"ingress-ready=true"`, `"ingress-ready=true,nvidia.com/gpu.present=true"`, 1)}
+		}
+		node.ExtraMounts = append(append([]Mount{}, node.ExtraMounts...), gpuMount)
+	}
+
+	out, err := yaml.Marshal(&manifest)
+	if err != nil {
+		return "", fmt.Errorf("failed to render Kind config: %w", err)
+	}
+	return string(out), nil
+}
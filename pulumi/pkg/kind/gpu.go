@@ -0,0 +1,55 @@
+package kind
+
+import (
+	"fmt"
+
+	"cluster-studio/pkg/shell"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// nvidiaContainerToolkitRepo serves the packages of the NVIDIA container toolkit
+const nvidiaContainerToolkitRepo = "https://nvidia.github.io/libnvidia-container"
+
+// GPUArgs configures the NVIDIA runtime of a Kind cluster
+type GPUArgs struct {
+	// Cluster rendered with Config.GPU
+	Cluster *Cluster
+}
+
+// GPU is the NVIDIA container runtime configured on the GPU node of a Kind
+// cluster
+type GPU struct {
+	pulumi.ResourceState
+}
+
+// NewGPU installs the NVIDIA container toolkit on the node the host injected
+// its GPUs into and makes nvidia containerd's default runtime, so the device
+// plugin and GPU workloads can use them. It fails when the node sees no GPU.
+func NewGPU(ctx *pulumi.Context, name string, args *GPUArgs, opts ...pulumi.ResourceOption) (*GPU, error) {
+	gpu := &GPU{}
+	err := ctx.RegisterComponentResource("home:kind:GPU", name, gpu, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Re-run whenever the cluster is recreated
+	_, err = local.NewCommand(ctx, fmt.Sprintf("%s-nodes", name), &local.CommandArgs{
+		Create: args.Cluster.Name.ApplyT(func(clusterName string) string {
+			return configureGPUCommand(clusterName)
+		}).(pulumi.StringOutput),
+		Triggers:    pulumi.Array{args.Cluster.Kubeconfig},
+		Interpreter: shell.Interpreter(),
+	}, pulumi.Parent(gpu), pulumi.DependsOn([]pulumi.Resource{args.Cluster}))
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.RegisterResourceOutputs(gpu, pulumi.Map{})
+	if err != nil {
+		return nil, err
+	}
+
+	return gpu, nil
+}
@@ -0,0 +1,45 @@
+package kind
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// nodeImageRepository is the repository of the Kind node images
+const nodeImageRepository = "kindest/node"
+
+// nodeImageTags maps Kubernetes minor versions to the latest kindest/node
+// patch release known to work with the pinned kind version
+var nodeImageTags = map[string]string{
+	"1.29": "v1.29.14",
+	"1.30": "v1.30.13",
+	"1.31": "v1.31.9",
+	"1.32": "v1.32.5",
+	"1.33": "v1.33.1",
+	"1.34": "v1.34.0",
+}
+
+var kubernetesVersionPattern = regexp.MustCompile(`^v?(\d+)\.(\d+)(\.\d+)?$`)
+
+// NodeImage returns the kindest/node image for a Kubernetes version. A minor
+// version ("1.31") resolves to a known patch release, a full version
+// ("1.31.4") is used as the tag as is.
+func NodeImage(kubernetesVersion string) (string, error) {
+	match := kubernetesVersionPattern.FindStringSubmatch(kubernetesVersion)
+	if match == nil {
+		return "", fmt.Errorf("invalid Kubernetes version %q, use e.g. 1.31 or 1.31.4", kubernetesVersion)
+	}
+
+	minor := match[1] + "." + match[2]
+	if match[3] != "" {
+		return fmt.Sprintf("%s:v%s", nodeImageRepository, strings.TrimPrefix(kubernetesVersion, "v")), nil
+	}
+
+	tag, ok := nodeImageTags[minor]
+	if !ok {
+		return "", fmt.Errorf("no known %s image for Kubernetes %s, set a full version (e.g. %s.0) instead",
+			nodeImageRepository, minor, minor)
+	}
+	return fmt.Sprintf("%s:%s", nodeImageRepository, tag), nil
+}
@@ -0,0 +1,94 @@
+package kind
+
+import (
+	"cluster-studio/pkg/shell"
+
+	"fmt"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// DefaultCacheName is the name of the pull-through cache container
+	DefaultCacheName = "kind-docker-cache"
+	// DockerHubRegistry is the registry the pull-through cache proxies
+	DockerHubRegistry = "docker.io"
+)
+
+// MirrorsArgs configures registry mirrors for the nodes of a Kind cluster
+type MirrorsArgs struct {
+	// Cluster whose nodes pull through the mirrors
+	Cluster *Cluster
+	// Registry host (e.g. docker.io, ghcr.io) -> mirror URL
+	Mirrors map[string]string
+	// Run a registry:2 pull-through cache for Docker Hub on the network of
+	// the nodes, used as the docker.io mirror unless Mirrors sets one
+	PullThroughCache bool
+}
+
+// Mirrors are the containerd registry mirrors configured on a Kind cluster
+type Mirrors struct {
+	pulumi.ResourceState
+
+	// Registry host -> mirror URL in use
+	Mirrors pulumi.StringMapOutput `pulumi:"mirrors"`
+}
+
+// NewMirrors configures containerd on every node to pull through the given
+// mirrors, optionally running a pull-through cache for Docker Hub. The Kind
+// config must set containerd's config_path, which Config.Render does.
+func NewMirrors(ctx *pulumi.Context, name string, args *MirrorsArgs, opts ...pulumi.ResourceOption) (*Mirrors, error) {
+	mirrors := &Mirrors{}
+	err := ctx.RegisterComponentResource("home:kind:Mirrors", name, mirrors, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := map[string]string{}
+	deps := []pulumi.Resource{args.Cluster}
+	if args.PullThroughCache {
+		// The cache lives on the network of the nodes, which exists once the
+		// cluster does
+		run := fmt.Sprintf(`if [ "$(docker inspect -f '{{.State.Running}}' %[1]s 2>/dev/null)" != true ]; then
+  if docker inspect %[1]s >/dev/null 2>&1; then docker rm -f %[1]s >/dev/null; fi
+  docker run -d --restart=always --network %[3]s -e REGISTRY_PROXY_REMOTEURL=https://registry-1.docker.io --name %[1]s %[2]s
+fi`, DefaultCacheName, DefaultRegistryImage, args.Cluster.Network)
+		cache, err := local.NewCommand(ctx, fmt.Sprintf("%s-cache", name), &local.CommandArgs{
+			Create:      pulumi.String(run),
+			Update:      pulumi.String(run),
+			Delete:      pulumi.String(removeContainerCommand(DefaultCacheName)),
+			Interpreter: shell.Interpreter(),
+		}, pulumi.Parent(mirrors), pulumi.DependsOn([]pulumi.Resource{args.Cluster}))
+		if err != nil {
+			return nil, err
+		}
+		deps = append(deps, cache)
+		hosts[DockerHubRegistry] = fmt.Sprintf("http://%s:5000", DefaultCacheName)
+	}
+	for registry, mirror := range args.Mirrors {
+		hosts[registry] = mirror
+	}
+
+	// Re-run whenever the cluster is recreated
+	_, err = local.NewCommand(ctx, fmt.Sprintf("%s-nodes", name), &local.CommandArgs{
+		Create: args.Cluster.Name.ApplyT(func(clusterName string) string {
+			return configureMirrorsCommand(clusterName, hosts)
+		}).(pulumi.StringOutput),
+		Triggers:    pulumi.Array{args.Cluster.Kubeconfig},
+		Interpreter: shell.Interpreter(),
+	}, pulumi.Parent(mirrors), pulumi.DependsOn(deps))
+	if err != nil {
+		return nil, err
+	}
+
+	mirrors.Mirrors = pulumi.ToStringMap(hosts).ToStringMapOutput()
+	err = ctx.RegisterResourceOutputs(mirrors, pulumi.Map{
+		"mirrors": mirrors.Mirrors,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return mirrors, nil
+}
@@ -0,0 +1,122 @@
+package kind
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Networking is the IP family and the pod and Service subnets of a Kind
+// cluster, which can only be set when it is created
+type Networking struct {
+	// "ipv4" (default), "ipv6" or "dual"
+	IPFamily string `json:"ipFamily" yaml:"ipFamily,omitempty"`
+	// One CIDR, or an IPv4 and an IPv6 CIDR separated by a comma for dual
+	PodSubnet     string `json:"podSubnet" yaml:"podSubnet,omitempty"`
+	ServiceSubnet string `json:"serviceSubnet" yaml:"serviceSubnet,omitempty"`
+}
+
+// defaultSubnets are the pod and Service subnets Kind uses for each family
+var defaultSubnets = map[string][2]string{
+	"ipv4": {"10.244.0.0/16", "10.96.0.0/16"},
+	"ipv6": {"fd00:10:244::/56", "fd00:10:96::/112"},
+	"dual": {"10.244.0.0/16,fd00:10:244::/56", "10.96.0.0/16,fd00:10:96::/112"},
+}
+
+// Effective returns n with the defaults of Kind for what is unset
+func (n Networking) Effective() Networking {
+	if n.IPFamily == "" {
+		n.IPFamily = "ipv4"
+	}
+	defaults := defaultSubnets[n.IPFamily]
+	if n.PodSubnet == "" {
+		n.PodSubnet = defaults[0]
+	}
+	if n.ServiceSubnet == "" {
+		n.ServiceSubnet = defaults[1]
+	}
+	return n
+}
+
+func (n Networking) String() string {
+	return fmt.Sprintf("%s with pods on %s and Services on %s", n.IPFamily, n.PodSubnet, n.ServiceSubnet)
+}
+
+// Validate checks that the subnets are CIDRs of the IP family, one of each
+// family for dual, and that the pod and Service subnets don't overlap,
+// defaults included
+func (n Networking) Validate() error {
+	n = n.Effective()
+	if _, ok := defaultSubnets[n.IPFamily]; !ok {
+		return fmt.Errorf("ipFamily %q is not ipv4, ipv6 or dual", n.IPFamily)
+	}
+
+	pods, err := parseSubnet("podSubnet", n.PodSubnet, n.IPFamily)
+	if err != nil {
+		return err
+	}
+	services, err := parseSubnet("serviceSubnet", n.ServiceSubnet, n.IPFamily)
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods {
+		for _, service := range services {
+			if pod.Overlaps(service) {
+				return fmt.Errorf("podSubnet %s overlaps serviceSubnet %s", pod, service)
+			}
+		}
+	}
+	return nil
+}
+
+// parseSubnet parses the comma separated CIDRs of a subnet and checks they
+// match the IP family
+func parseSubnet(field, subnet, ipFamily string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	v4, v6 := 0, 0
+	for _, cidr := range strings.Split(subnet, ",") {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field, err)
+		}
+		if prefix != prefix.Masked() {
+			return nil, fmt.Errorf("%s: %s has host bits set, use %s", field, prefix, prefix.Masked())
+		}
+		if prefix.Addr().Is4() {
+			v4++
+		} else {
+			v6++
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	var ok bool
+	switch ipFamily {
+	case "ipv4":
+		ok = v4 == 1 && v6 == 0
+	case "ipv6":
+		ok = v4 == 0 && v6 == 1
+	case "dual":
+		ok = v4 == 1 && v6 == 1
+	}
+	if !ok {
+		if ipFamily == "dual" {
+			return nil, fmt.Errorf("%s %q must be an IPv4 and an IPv6 CIDR for ipFamily dual", field, subnet)
+		}
+		return nil, fmt.Errorf("%s %q must be a single %s CIDR", field, subnet, ipFamily)
+	}
+	return prefixes, nil
+}
+
+// ParseNetworking returns the effective networking of a Kind cluster config
+func ParseNetworking(config string) (Networking, error) {
+	var cluster struct {
+		Networking Networking `yaml:"networking"`
+	}
+	if err := yaml.Unmarshal([]byte(config), &cluster); err != nil {
+		return Networking{}, fmt.Errorf("failed to read the networking of the Kind config: %w", err)
+	}
+	return cluster.Networking.Effective(), nil
+}
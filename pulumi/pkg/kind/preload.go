@@ -0,0 +1,143 @@
+package kind
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cluster-studio/pkg/shell"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// DefaultPreloadConcurrency is how many images are preloaded at once by default
+const DefaultPreloadConcurrency = 4
+
+// preloadImageScript pulls the image $1 unless Docker has it and loads it
+// into the nodes of $CLUSTER, printing one line for the result. A failure is
+// reported, not fatal: the nodes still pull the image themselves.
+const preloadImageScript = `image="$1"
+start=$(date +%s)
+if ! docker image inspect "$image" >/dev/null 2>&1; then
+  if ! out=$(docker pull -q "$image" 2>&1); then
+    echo "failed $image: $(printf '%s\n' "$out" | tail -n 1)"
+    exit 0
+  fi
+fi
+if ! out=$(kind load docker-image "$image" --name "$CLUSTER" 2>&1); then
+  echo "failed $image: $(printf '%s\n' "$out" | tail -n 1)"
+  exit 0
+fi
+echo "loaded $image $(( $(date +%s) - start ))"`
+
+// PreloadArgs configures the preloading of images into a Kind cluster
+type PreloadArgs struct {
+	// Cluster whose nodes get the images
+	Cluster *Cluster
+	// Images to preload, e.g. ghcr.io/fluxcd/source-controller:v1.6.2
+	Images []string
+	// How many images are pulled and loaded at once (default
+	// DefaultPreloadConcurrency)
+	Concurrency int
+}
+
+// Preload is the result of loading images into the nodes of a Kind cluster
+type Preload struct {
+	pulumi.ResourceState
+
+	// Number of images loaded into the nodes
+	Loaded pulumi.IntOutput `pulumi:"loaded"`
+	// Images that could not be loaded, with the reason
+	Failed pulumi.StringArrayOutput `pulumi:"failed"`
+	// Time spent pulling and loading them, in seconds
+	Seconds pulumi.IntOutput `pulumi:"seconds"`
+}
+
+// NewPreload pulls the images missing from the local Docker and loads them
+// into the nodes with `kind load docker-image`, Concurrency at a time, so
+// the workloads of a new cluster start without pulling them. Failures are
+// logged as warnings. It runs again whenever the cluster is recreated.
+func NewPreload(ctx *pulumi.Context, name string, args *PreloadArgs, opts ...pulumi.ResourceOption) (*Preload, error) {
+	preload := &Preload{}
+	err := ctx.RegisterComponentResource("home:kind:Preload", name, preload, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	concurrency := args.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultPreloadConcurrency
+	}
+	quoted := make([]string, 0, len(args.Images))
+	for _, image := range args.Images {
+		quoted = append(quoted, shell.Quote(image))
+	}
+	script := fmt.Sprintf(`start=$(date +%%s)
+printf '%%s\n' %s | xargs -P %d -I {} sh -c %s _ {}
+echo "took $(( $(date +%%s) - start ))"`, strings.Join(quoted, " "), concurrency, shell.Quote(preloadImageScript))
+
+	command, err := local.NewCommand(ctx, fmt.Sprintf("%s-load", name), &local.CommandArgs{
+		Create:      pulumi.String(script),
+		Environment: pulumi.StringMap{"CLUSTER": args.Cluster.Name},
+		Triggers:    pulumi.Array{args.Cluster.Kubeconfig},
+		Interpreter: shell.Interpreter(),
+	}, pulumi.Parent(preload), pulumi.DependsOn([]pulumi.Resource{args.Cluster}))
+	if err != nil {
+		return nil, err
+	}
+
+	result := command.Stdout.ApplyT(func(stdout string) preloadResult {
+		result := parsePreloadOutput(stdout)
+		if len(result.failed) > 0 && !ctx.DryRun() {
+			_ = ctx.Log.Warn(fmt.Sprintf("%d of %d images were not preloaded, the nodes pull them instead:\n  %s",
+				len(result.failed), len(args.Images), strings.Join(result.failed, "\n  ")), &pulumi.LogArgs{Resource: preload})
+		}
+		return result
+	})
+	preload.Loaded = result.ApplyT(func(result interface{}) int {
+		return result.(preloadResult).loaded
+	}).(pulumi.IntOutput)
+	preload.Failed = result.ApplyT(func(result interface{}) []string {
+		return result.(preloadResult).failed
+	}).(pulumi.StringArrayOutput)
+	preload.Seconds = result.ApplyT(func(result interface{}) int {
+		return result.(preloadResult).seconds
+	}).(pulumi.IntOutput)
+	err = ctx.RegisterResourceOutputs(preload, pulumi.Map{
+		"loaded":  preload.Loaded,
+		"failed":  preload.Failed,
+		"seconds": preload.Seconds,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return preload, nil
+}
+
+// preloadResult is the summary of the output of the preload command
+type preloadResult struct {
+	loaded  int
+	failed  []string
+	seconds int
+}
+
+// parsePreloadOutput reads the "loaded <image> <seconds>", "failed <image>:
+// <reason>" and final "took <seconds>" lines of the preload command
+func parsePreloadOutput(stdout string) preloadResult {
+	result := preloadResult{failed: []string{}}
+	for _, line := range strings.Split(stdout, "\n") {
+		switch verb, rest, _ := strings.Cut(strings.TrimSpace(line), " "); verb {
+		case "loaded":
+			result.loaded++
+		case "failed":
+			result.failed = append(result.failed, rest)
+		case "took":
+			result.seconds, _ = strconv.Atoi(rest)
+		}
+	}
+	sort.Strings(result.failed)
+	return result
+}
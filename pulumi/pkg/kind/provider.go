@@ -0,0 +1,561 @@
+package kind
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/shell"
+
+	"github.com/blang/semver"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/plugin"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/rpcutil"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"google.golang.org/grpc"
+)
+
+const (
+	// ProviderName is the package of the resource provider of Kind clusters,
+	// built from cmd/pulumi-resource-homekind
+	ProviderName = "homekind"
+	// ProviderVersion is the version the provider reports to the engine
+	ProviderVersion = "0.1.0"
+	// ClusterType is the type token of a Kind cluster managed by the provider
+	ClusterType = ProviderName + ":index:Cluster"
+)
+
+// Properties of a cluster: the inputs, then the state read from Kind
+const (
+	nameKey           resource.PropertyKey = "name"
+	configKey         resource.PropertyKey = "config"
+	configFileKey     resource.PropertyKey = "configFile"
+	configChecksumKey resource.PropertyKey = "configChecksum"
+	nodeImageKey      resource.PropertyKey = "nodeImage"
+	kubeconfigFileKey resource.PropertyKey = "kubeconfigFile"
+	recreateKey       resource.PropertyKey = "recreate"
+	generationKey     resource.PropertyKey = "generation"
+	networkKey        resource.PropertyKey = "network"
+	memoryKey         resource.PropertyKey = "memory"
+	cpusKey           resource.PropertyKey = "cpus"
+
+	nodesKey          resource.PropertyKey = "nodes"
+	imageKey          resource.PropertyKey = "image"
+	kubeconfigKey     resource.PropertyKey = "kubeconfig"
+	logKey            resource.PropertyKey = "log"
+	networkCreatedKey resource.PropertyKey = "networkCreated"
+	memoryLimitKey    resource.PropertyKey = "memoryLimit"
+	cpuLimitKey       resource.PropertyKey = "cpuLimit"
+)
+
+// replaceKeys are the inputs Kind can't change on a running cluster: the
+// nodes, their image, the networking and the Docker network are all set at
+// creation
+var replaceKeys = map[resource.PropertyKey]bool{
+	nameKey:           true,
+	configKey:         true,
+	configChecksumKey: true,
+	nodeImageKey:      true,
+	generationKey:     true,
+	networkKey:        true,
+}
+
+// limitKeys are the resource limits of the nodes, updated in place with
+// docker update. Docker can't lift a limit, removing one replaces the cluster.
+var limitKeys = map[resource.PropertyKey]bool{
+	memoryKey: true,
+	cpusKey:   true,
+}
+
+// inputKeys are the inputs of a cluster, in the order they are diffed
+var inputKeys = []resource.PropertyKey{
+	nameKey, configKey, configFileKey, configChecksumKey, nodeImageKey, kubeconfigFileKey, recreateKey, generationKey,
+	networkKey, memoryKey, cpusKey,
+}
+
+// Provider is the resource provider of Kind clusters. Unlike a command it
+// reads the cluster back on refresh, replaces it only for changes Kind can't
+// apply in place and drops it from the stack once deleted outside of Pulumi.
+type Provider struct {
+	plugin.UnimplementedProvider
+}
+
+// ServeProvider serves the provider to the Pulumi engine, which reads the
+// port it listens on from stdout, until the engine stops it
+func ServeProvider() error {
+	handle, err := rpcutil.ServeWithOptions(rpcutil.ServeOptions{
+		Init: func(srv *grpc.Server) error {
+			pulumirpc.RegisterResourceProviderServer(srv, plugin.NewProviderServer(&Provider{}))
+			return nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(handle.Port)
+	return <-handle.Done
+}
+
+func (p *Provider) Pkg() tokens.Package {
+	return ProviderName
+}
+
+func (p *Provider) Close() error {
+	return nil
+}
+
+func (p *Provider) SignalCancellation(context.Context) error {
+	return nil
+}
+
+func (p *Provider) GetPluginInfo(context.Context) (workspace.PluginInfo, error) {
+	version := semver.MustParse(ProviderVersion)
+	return workspace.PluginInfo{Name: ProviderName, Kind: apitype.ResourcePlugin, Version: &version}, nil
+}
+
+// The provider has no configuration
+func (p *Provider) CheckConfig(_ context.Context, req plugin.CheckConfigRequest) (plugin.CheckConfigResponse, error) {
+	return plugin.CheckConfigResponse{Properties: req.News}, nil
+}
+
+// DiffConfig reports no change, so the command the cluster used to be can be
+// aliased to it without being replaced for changing provider
+func (p *Provider) DiffConfig(context.Context, plugin.DiffConfigRequest) (plugin.DiffConfigResponse, error) {
+	return plugin.DiffResult{Changes: plugin.DiffNone}, nil
+}
+
+func (p *Provider) Configure(context.Context, plugin.ConfigureRequest) (plugin.ConfigureResponse, error) {
+	return plugin.ConfigureResponse{}, nil
+}
+
+// Check requires a name and records the checksum of the config file, so
+// editing it shows up in the diff
+func (p *Provider) Check(_ context.Context, req plugin.CheckRequest) (plugin.CheckResponse, error) {
+	news := req.News.Copy()
+	var failures []plugin.CheckFailure
+	if name := news[nameKey]; !name.IsComputed() && stringProperty(news, nameKey) == "" {
+		failures = append(failures, plugin.CheckFailure{Property: nameKey, Reason: "the name of the Kind cluster is required"})
+	}
+	if file := stringProperty(news, configFileKey); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			failures = append(failures, plugin.CheckFailure{Property: configFileKey, Reason: err.Error()})
+		} else {
+			sum := sha256.Sum256(data)
+			news[configChecksumKey] = resource.NewStringProperty(hex.EncodeToString(sum[:]))
+		}
+	}
+	return plugin.CheckResponse{Properties: news, Failures: failures}, nil
+}
+
+// Diff compares the inputs, replacing the cluster for those in replaceKeys,
+// and the node image with the one read on the last refresh
+func (p *Provider) Diff(_ context.Context, req plugin.DiffRequest) (plugin.DiffResponse, error) {
+	// The state of the command the cluster used to be: Update adopts the
+	// cluster it created
+	if !req.OldOutputs.HasValue(nameKey) {
+		return plugin.DiffResult{
+			Changes:      plugin.DiffSome,
+			DetailedDiff: plugin.NewDetailedDiffFromObjectDiff(req.OldInputs.Diff(req.NewInputs), true),
+		}, nil
+	}
+
+	detailed := map[string]plugin.PropertyDiff{}
+	for _, key := range inputKeys {
+		previous, next := req.OldInputs[key], req.NewInputs[key]
+		if previous.DeepEquals(next) && !next.ContainsUnknowns() {
+			continue
+		}
+		kind := plugin.DiffUpdate
+		// An unknown limit is set, only a removed one replaces the cluster
+		if replaceKeys[key] || (limitKeys[key] && !next.IsNumber() && !next.ContainsUnknowns()) {
+			kind = plugin.DiffUpdateReplace
+		}
+		detailed[string(key)] = plugin.PropertyDiff{Kind: kind, InputDiff: true}
+	}
+	if image := stringProperty(req.NewInputs, nodeImageKey); image != "" {
+		if running := stringProperty(req.OldOutputs, imageKey); running != "" && running != image {
+			detailed[string(nodeImageKey)] = plugin.PropertyDiff{Kind: plugin.DiffUpdateReplace}
+		}
+	}
+	if len(detailed) == 0 {
+		return plugin.DiffResult{Changes: plugin.DiffNone}, nil
+	}
+	// Kind can't create a cluster under the name of another one
+	return plugin.DiffResult{Changes: plugin.DiffSome, DetailedDiff: detailed, DeleteBeforeReplace: true}, nil
+}
+
+// Create creates the cluster, or reuses a healthy one of the same name
+// unless recreate is set
+func (p *Provider) Create(ctx context.Context, req plugin.CreateRequest) (plugin.CreateResponse, error) {
+	name := stringProperty(req.Properties, nameKey)
+	if req.Preview {
+		return plugin.CreateResponse{Properties: req.Properties}, nil
+	}
+	ctx, cancel := withTimeout(ctx, req.Timeout)
+	defer cancel()
+
+	var log bytes.Buffer
+	networkCreated, err := ensureCluster(ctx, req.Properties, boolProperty(req.Properties, recreateKey), &log)
+	if err != nil {
+		return plugin.CreateResponse{}, err
+	}
+	if err := applyLimits(ctx, name, req.Properties); err != nil {
+		return plugin.CreateResponse{}, err
+	}
+	state, err := readCluster(ctx, name, req.Properties, log.String())
+	if err != nil {
+		return plugin.CreateResponse{}, err
+	}
+	if state == nil {
+		return plugin.CreateResponse{}, fmt.Errorf("Kind cluster %s is missing right after its creation", name)
+	}
+	state[networkCreatedKey] = resource.NewBoolProperty(networkCreated)
+	return plugin.CreateResponse{ID: resource.ID(name), Properties: state}, nil
+}
+
+// Read returns the nodes, image and kubeconfig of the cluster, or no state
+// once it is gone, which drops it from the stack
+func (p *Provider) Read(ctx context.Context, req plugin.ReadRequest) (plugin.ReadResponse, error) {
+	inputs := req.Inputs
+	name := stringProperty(inputs, nameKey)
+	// Imported by name
+	if name == "" {
+		name = string(req.ID)
+		inputs = resource.PropertyMap{nameKey: resource.NewStringProperty(name)}
+	}
+	state, err := readCluster(ctx, name, inputs, stringProperty(req.State, logKey))
+	if err != nil || state == nil {
+		return plugin.ReadResponse{}, err
+	}
+	state[networkCreatedKey] = resource.NewBoolProperty(boolProperty(req.State, networkCreatedKey))
+	return plugin.ReadResponse{ReadResult: plugin.ReadResult{ID: resource.ID(name), Inputs: inputs, Outputs: state}}, nil
+}
+
+// Update exports the kubeconfig again, the other changes replace the
+// cluster. The command the cluster used to be is migrated here: its cluster
+// is reused, or created when it is gone.
+func (p *Provider) Update(ctx context.Context, req plugin.UpdateRequest) (plugin.UpdateResponse, error) {
+	name := stringProperty(req.NewInputs, nameKey)
+	if req.Preview {
+		return plugin.UpdateResponse{Properties: req.NewInputs}, nil
+	}
+	ctx, cancel := withTimeout(ctx, req.Timeout)
+	defer cancel()
+
+	var log bytes.Buffer
+	networkCreated, err := ensureCluster(ctx, req.NewInputs, false, &log)
+	if err != nil {
+		return plugin.UpdateResponse{}, err
+	}
+	if err := applyLimits(ctx, name, req.NewInputs); err != nil {
+		return plugin.UpdateResponse{}, err
+	}
+	state, err := readCluster(ctx, name, req.NewInputs, log.String())
+	if err != nil {
+		return plugin.UpdateResponse{}, err
+	}
+	if state == nil {
+		return plugin.UpdateResponse{}, fmt.Errorf("Kind cluster %s is missing right after its update", name)
+	}
+	state[networkCreatedKey] = resource.NewBoolProperty(networkCreated || boolProperty(req.OldOutputs, networkCreatedKey))
+	return plugin.UpdateResponse{Properties: state}, nil
+}
+
+// Delete deletes the cluster and removes its context from the kubeconfig.
+// Kind succeeds when the cluster is already gone. The Docker network is
+// removed only when the cluster created it and nothing else uses it.
+func (p *Provider) Delete(ctx context.Context, req plugin.DeleteRequest) (plugin.DeleteResponse, error) {
+	ctx, cancel := withTimeout(ctx, req.Timeout)
+	defer cancel()
+
+	name := stringProperty(req.Outputs, nameKey)
+	_, err := runKind(ctx, nil, append([]string{"delete", "cluster", "--name", name}, kubeconfigFlag(req.Outputs)...)...)
+	if err != nil {
+		return plugin.DeleteResponse{}, err
+	}
+	if network := stringProperty(req.Outputs, networkKey); network != "" && boolProperty(req.Outputs, networkCreatedKey) {
+		return plugin.DeleteResponse{}, removeNetwork(ctx, network)
+	}
+	return plugin.DeleteResponse{}, nil
+}
+
+// ensureCluster creates the cluster of inputs. An existing cluster whose API
+// server responds is reused and only its kubeconfig is exported, unless
+// recreate is set; anything else is deleted and created from scratch. The
+// progress reported by kind is written to log. It returns whether Kind
+// created the Docker network of the cluster.
+func ensureCluster(ctx context.Context, inputs resource.PropertyMap, recreate bool, log io.Writer) (bool, error) {
+	name := stringProperty(inputs, nameKey)
+	if !recreate {
+		healthy, err := clusterHealthy(ctx, name)
+		if err != nil {
+			return false, err
+		}
+		if healthy {
+			fmt.Fprintf(log, "Reusing existing Kind cluster %s\n", name)
+			_, err := runKind(ctx, log, append([]string{"export", "kubeconfig", "--name", name}, kubeconfigFlag(inputs)...)...)
+			return false, err
+		}
+	}
+
+	configFile := stringProperty(inputs, configFileKey)
+	if configFile == "" {
+		file, err := os.CreateTemp("", "kind-config-*.yaml")
+		if err != nil {
+			return false, err
+		}
+		defer os.Remove(file.Name())
+		_, err = file.WriteString(stringProperty(inputs, configKey))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to write the Kind config: %w", err)
+		}
+		configFile = file.Name()
+	}
+
+	if _, err := runKind(ctx, log, append([]string{"delete", "cluster", "--name", name}, kubeconfigFlag(inputs)...)...); err != nil {
+		return false, err
+	}
+	// Kind creates the network when it is missing, which is only known now
+	var env []string
+	networkCreated := false
+	if network := stringProperty(inputs, networkKey); network != "" {
+		exists, err := networkExists(ctx, network)
+		if err != nil {
+			return false, err
+		}
+		networkCreated = !exists
+		env = append(env, "KIND_EXPERIMENTAL_DOCKER_NETWORK="+network)
+	}
+	args := []string{"create", "cluster", "--name", name, "--config", configFile}
+	if image := stringProperty(inputs, nodeImageKey); image != "" {
+		args = append(args, "--image", image)
+	}
+	if _, err := runKindEnv(ctx, log, env, append(args, kubeconfigFlag(inputs)...)...); err != nil {
+		return networkCreated, fmt.Errorf("%w%s", err, diagnoseNodes(name))
+	}
+	return networkCreated, nil
+}
+
+// applyLimits sets the memory and CPU limits of inputs on every node
+func applyLimits(ctx context.Context, name string, inputs resource.PropertyMap) error {
+	limits := NodeLimits{Memory: int64(numberProperty(inputs, memoryKey)), CPUs: numberProperty(inputs, cpusKey)}
+	args := limits.updateArgs()
+	if len(args) == 0 {
+		return nil
+	}
+	out, err := runKind(ctx, nil, "get", "nodes", "--name", name)
+	if err != nil {
+		return err
+	}
+	args = append(append([]string{"update"}, args...), strings.Fields(out)...)
+	if out, err := exec.CommandContext(ctx, "docker", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to limit the resources of the nodes of %s: %w\n%s", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// networkExists reports whether Docker has the named network
+func networkExists(ctx context.Context, network string) (bool, error) {
+	out, err := exec.CommandContext(ctx, "docker", "network", "ls", "--quiet", "--filter", "name=^"+network+"$").Output()
+	if err != nil {
+		return false, fmt.Errorf("failed to list the Docker networks: %w", err)
+	}
+	return len(bytes.TrimSpace(out)) > 0, nil
+}
+
+// removeNetwork removes a Docker network unless other containers (another
+// cluster, a registry or a compose project) are still attached to it
+func removeNetwork(ctx context.Context, network string) error {
+	exists, err := networkExists(ctx, network)
+	if err != nil || !exists {
+		return err
+	}
+	out, err := exec.CommandContext(ctx, "docker", "network", "inspect", "--format", "{{len .Containers}}", network).Output()
+	if err != nil {
+		return fmt.Errorf("failed to inspect Docker network %s: %w", network, err)
+	}
+	if strings.TrimSpace(string(out)) != "0" {
+		return nil
+	}
+	if out, err := exec.CommandContext(ctx, "docker", "network", "rm", network).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to remove Docker network %s: %w\n%s", network, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// clusterHealthy reports whether the cluster exists and its API server is ready
+func clusterHealthy(ctx context.Context, name string) (bool, error) {
+	exists, err := existsContext(ctx, name)
+	if err != nil || !exists {
+		return false, err
+	}
+	kubeconfig, err := runKind(ctx, nil, "get", "kubeconfig", "--name", name)
+	if err != nil {
+		return false, nil
+	}
+	client, err := kube.NewClientsetFromKubeconfig(kubeconfig)
+	if err != nil {
+		return false, nil
+	}
+	_, err = client.Discovery().RESTClient().Get().AbsPath("/readyz").DoRaw(ctx)
+	return err == nil, nil
+}
+
+// readCluster returns inputs along with the nodes of the cluster, the image
+// of its control plane, its kubeconfig and the log of its creation, or nil
+// when the cluster is gone
+func readCluster(ctx context.Context, name string, inputs resource.PropertyMap, log string) (resource.PropertyMap, error) {
+	exists, err := existsContext(ctx, name)
+	if err != nil || !exists {
+		return nil, err
+	}
+	out, err := runKind(ctx, nil, "get", "nodes", "--name", name)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []resource.PropertyValue
+	image := ""
+	var memoryLimit, nanoCPUs float64
+	for _, node := range strings.Fields(out) {
+		nodes = append(nodes, resource.NewStringProperty(node))
+		if image == "" && strings.HasSuffix(node, "-control-plane") {
+			inspect, err := exec.CommandContext(ctx, "docker", "inspect", "--format",
+				"{{.Config.Image}} {{.HostConfig.Memory}} {{.HostConfig.NanoCpus}}", node).Output()
+			if err != nil {
+				return nil, fmt.Errorf("failed to inspect Kind node %s: %w", node, err)
+			}
+			fields := strings.Fields(string(inspect))
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("unexpected inspection of Kind node %s: %q", node, inspect)
+			}
+			image = fields[0]
+			memoryLimit, _ = strconv.ParseFloat(fields[1], 64)
+			nanoCPUs, _ = strconv.ParseFloat(fields[2], 64)
+		}
+	}
+	kubeconfig, err := runKind(ctx, nil, "get", "kubeconfig", "--name", name)
+	if err != nil {
+		return nil, err
+	}
+
+	state := inputs.Copy()
+	state[nodesKey] = resource.NewArrayProperty(nodes)
+	state[imageKey] = resource.NewStringProperty(image)
+	state[kubeconfigKey] = resource.MakeSecret(resource.NewStringProperty(kubeconfig))
+	state[logKey] = resource.NewStringProperty(log)
+	state[memoryLimitKey] = resource.NewNumberProperty(memoryLimit)
+	state[cpuLimitKey] = resource.NewNumberProperty(nanoCPUs / 1e9)
+	return state, nil
+}
+
+// existsContext is Exists bound to ctx
+func existsContext(ctx context.Context, name string) (bool, error) {
+	out, err := runKind(ctx, nil, "get", "clusters")
+	if err != nil {
+		return false, err
+	}
+	for _, cluster := range strings.Fields(out) {
+		if cluster == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// runKind runs kind with args and returns its stdout, or an error ending
+// with the last lines of its stderr. The progress kind reports on stderr is
+// also written to log (optional).
+func runKind(ctx context.Context, log io.Writer, args ...string) (string, error) {
+	return runKindEnv(ctx, log, nil, args...)
+}
+
+// runKindEnv is runKind with env added to the environment of kind
+func runKindEnv(ctx context.Context, log io.Writer, env []string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "kind", args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if log != nil {
+		cmd.Stderr = io.MultiWriter(&stderr, log)
+	}
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return "", fmt.Errorf("kind %s failed: %w\n%s", strings.Join(args, " "), err, shell.Tail(stderr.String(), shell.FailureTailLines))
+	}
+	return stdout.String(), nil
+}
+
+// diagnoseNodes lists the node containers of the cluster and their status,
+// to tell a node that failed to start from one that was never created
+func diagnoseNodes(name string) string {
+	out, err := exec.Command("docker", "ps", "-a", "--filter", "label=io.x-k8s.kind.cluster="+name,
+		"--format", "{{.Names}}: {{.Status}}").Output()
+	if err != nil || len(bytes.TrimSpace(out)) == 0 {
+		return ""
+	}
+	return "\nnode containers:\n" + strings.TrimRight(string(out), "\n")
+}
+
+// kubeconfigFlag returns the --kubeconfig flag of the kubeconfig file of a
+// cluster, none for the default one
+func kubeconfigFlag(props resource.PropertyMap) []string {
+	if file := stringProperty(props, kubeconfigFileKey); file != "" {
+		return []string{"--kubeconfig", file}
+	}
+	return nil
+}
+
+// withTimeout bounds ctx by the custom timeout of an operation, in seconds
+func withTimeout(ctx context.Context, timeout float64) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(timeout*float64(time.Second)))
+}
+
+// stringProperty returns a string property, empty when it is unset or unknown
+func stringProperty(props resource.PropertyMap, key resource.PropertyKey) string {
+	value := props[key]
+	if value.IsSecret() {
+		value = value.SecretValue().Element
+	}
+	if !value.IsString() {
+		return ""
+	}
+	return value.StringValue()
+}
+
+// numberProperty returns a number property, 0 when it is unset or unknown
+func numberProperty(props resource.PropertyMap, key resource.PropertyKey) float64 {
+	value := props[key]
+	if !value.IsNumber() {
+		return 0
+	}
+	return value.NumberValue()
+}
+
+// boolProperty returns a bool property, false when it is unset or unknown
+func boolProperty(props resource.PropertyMap, key resource.PropertyKey) bool {
+	value := props[key]
+	return value.IsBool() && value.BoolValue()
+}
@@ -0,0 +1,217 @@
+package kind
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/plugin"
+)
+
+// clusterInputs are the inputs of a cluster with the given changes
+func clusterInputs(changes resource.PropertyMap) resource.PropertyMap {
+	inputs := resource.PropertyMap{
+		nameKey:           resource.NewStringProperty("studio"),
+		configKey:         resource.NewStringProperty("kind: Cluster"),
+		nodeImageKey:      resource.NewStringProperty("kindest/node:v1.32.0"),
+		kubeconfigFileKey: resource.NewStringProperty("/tmp/kubeconfig"),
+		generationKey:     resource.NewNumberProperty(0),
+		memoryKey:         resource.NewNumberProperty(4 << 30),
+		cpusKey:           resource.NewNumberProperty(2),
+	}
+	for key, value := range changes {
+		if value.IsNull() {
+			delete(inputs, key)
+			continue
+		}
+		inputs[key] = value
+	}
+	return inputs
+}
+
+func TestProviderDiff(t *testing.T) {
+	unknown := resource.MakeComputed(resource.NewStringProperty(""))
+	state := func(image string) resource.PropertyMap {
+		outputs := clusterInputs(nil)
+		outputs[imageKey] = resource.NewStringProperty(image)
+		return outputs
+	}
+
+	for _, tc := range []struct {
+		name       string
+		oldInputs  resource.PropertyMap
+		oldOutputs resource.PropertyMap
+		newInputs  resource.PropertyMap
+		// Changed property -> kind of change, nil for no change
+		want    map[string]plugin.DiffKind
+		replace bool
+	}{
+		{
+			name:       "no change",
+			oldOutputs: state("kindest/node:v1.32.0"),
+			newInputs:  clusterInputs(nil),
+		},
+		{
+			name:       "node image",
+			oldOutputs: state("kindest/node:v1.32.0"),
+			newInputs:  clusterInputs(resource.PropertyMap{nodeImageKey: resource.NewStringProperty("kindest/node:v1.33.0")}),
+			want:       map[string]plugin.DiffKind{"nodeImage": plugin.DiffUpdateReplace},
+			replace:    true,
+		},
+		{
+			name:       "node image changed outside of Pulumi",
+			oldOutputs: state("kindest/node:v1.31.0"),
+			newInputs:  clusterInputs(nil),
+			want:       map[string]plugin.DiffKind{"nodeImage": plugin.DiffUpdateReplace},
+			replace:    true,
+		},
+		{
+			name:       "memory",
+			oldOutputs: state("kindest/node:v1.32.0"),
+			newInputs:  clusterInputs(resource.PropertyMap{memoryKey: resource.NewNumberProperty(8 << 30)}),
+			want:       map[string]plugin.DiffKind{"memory": plugin.DiffUpdate},
+		},
+		{
+			name:       "limit removed",
+			oldOutputs: state("kindest/node:v1.32.0"),
+			newInputs:  clusterInputs(resource.PropertyMap{cpusKey: resource.NewNullProperty()}),
+			want:       map[string]plugin.DiffKind{"cpus": plugin.DiffUpdateReplace},
+			replace:    true,
+		},
+		{
+			name:       "kubeconfig file",
+			oldOutputs: state("kindest/node:v1.32.0"),
+			newInputs:  clusterInputs(resource.PropertyMap{kubeconfigFileKey: resource.NewStringProperty("/tmp/other")}),
+			want:       map[string]plugin.DiffKind{"kubeconfigFile": plugin.DiffUpdate},
+		},
+		{
+			name:       "unknown config",
+			oldOutputs: state("kindest/node:v1.32.0"),
+			newInputs:  clusterInputs(resource.PropertyMap{configKey: unknown}),
+			want:       map[string]plugin.DiffKind{"config": plugin.DiffUpdateReplace},
+			replace:    true,
+		},
+		{
+			name:       "unknown kubeconfig file",
+			oldOutputs: state("kindest/node:v1.32.0"),
+			newInputs:  clusterInputs(resource.PropertyMap{kubeconfigFileKey: unknown}),
+			want:       map[string]plugin.DiffKind{"kubeconfigFile": plugin.DiffUpdate},
+		},
+		{
+			name:       "unknown limit",
+			oldOutputs: state("kindest/node:v1.32.0"),
+			newInputs:  clusterInputs(resource.PropertyMap{memoryKey: resource.MakeComputed(resource.NewNumberProperty(0))}),
+			want:       map[string]plugin.DiffKind{"memory": plugin.DiffUpdate},
+		},
+		{
+			// The command the cluster used to be has no name in its outputs
+			name:       "state of the command",
+			oldInputs:  resource.PropertyMap{"create": resource.NewStringProperty("kind create cluster")},
+			oldOutputs: resource.PropertyMap{"stdout": resource.NewStringProperty("")},
+			newInputs:  clusterInputs(nil),
+			want:       map[string]plugin.DiffKind{"name": plugin.DiffAdd, "create": plugin.DiffDelete},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			oldInputs := tc.oldInputs
+			if oldInputs == nil {
+				oldInputs = clusterInputs(nil)
+			}
+			diff, err := (&Provider{}).Diff(context.Background(), plugin.DiffRequest{
+				OldInputs:  oldInputs,
+				OldOutputs: tc.oldOutputs,
+				NewInputs:  tc.newInputs,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tc.want) == 0 {
+				if diff.Changes != plugin.DiffNone {
+					t.Errorf("got changes %v, want none", diff.DetailedDiff)
+				}
+				return
+			}
+			if diff.Changes != plugin.DiffSome {
+				t.Fatalf("got no change, want %v", tc.want)
+			}
+			for key, kind := range tc.want {
+				if got, ok := diff.DetailedDiff[key]; !ok || got.Kind != kind {
+					t.Errorf("%s: got %v, want %v", key, got.Kind, kind)
+				}
+			}
+			if got := diff.Replace(); got != tc.replace {
+				t.Errorf("got replace %t, want %t: %v", got, tc.replace, diff.DetailedDiff)
+			}
+		})
+	}
+}
+
+func TestProviderCheck(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "kind.yaml")
+	if err := os.WriteFile(configFile, []byte("kind: Cluster\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	check := func(news resource.PropertyMap) plugin.CheckResponse {
+		t.Helper()
+		resp, err := (&Provider{}).Check(context.Background(), plugin.CheckRequest{News: news})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := check(resource.PropertyMap{
+		nameKey:       resource.NewStringProperty("studio"),
+		configFileKey: resource.NewStringProperty(configFile),
+	})
+	if len(resp.Failures) > 0 {
+		t.Fatalf("unexpected failures %v", resp.Failures)
+	}
+	checksum := stringProperty(resp.Properties, configChecksumKey)
+	if checksum == "" {
+		t.Fatal("the checksum of the config file is not recorded")
+	}
+
+	// Editing the file changes the checksum, which replaces the cluster
+	if err := os.WriteFile(configFile, []byte("kind: Cluster\nnodes: []\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	resp = check(resource.PropertyMap{
+		nameKey:       resource.NewStringProperty("studio"),
+		configFileKey: resource.NewStringProperty(configFile),
+	})
+	if got := stringProperty(resp.Properties, configChecksumKey); got == checksum {
+		t.Error("the checksum didn't change with the config file")
+	}
+
+	// Without a config file there is no checksum
+	resp = check(resource.PropertyMap{nameKey: resource.NewStringProperty("studio")})
+	if resp.Properties.HasValue(configChecksumKey) {
+		t.Error("a checksum is recorded without a config file")
+	}
+
+	for _, tc := range []struct {
+		name string
+		news resource.PropertyMap
+		want resource.PropertyKey
+	}{
+		{"missing name", resource.PropertyMap{}, nameKey},
+		{"missing config file", resource.PropertyMap{
+			nameKey:       resource.NewStringProperty("studio"),
+			configFileKey: resource.NewStringProperty(filepath.Join(t.TempDir(), "missing.yaml")),
+		}, configFileKey},
+	} {
+		resp := check(tc.news)
+		if len(resp.Failures) != 1 || resp.Failures[0].Property != tc.want {
+			t.Errorf("%s: got failures %v, want one on %s", tc.name, resp.Failures, tc.want)
+		}
+	}
+
+	// A name only known after the preview is not a failure
+	resp = check(resource.PropertyMap{nameKey: resource.MakeComputed(resource.NewStringProperty(""))})
+	if len(resp.Failures) > 0 {
+		t.Errorf("an unknown name fails the check: %v", resp.Failures)
+	}
+}
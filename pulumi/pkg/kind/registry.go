@@ -0,0 +1,129 @@
+package kind
+
+import (
+	"cluster-studio/pkg/shell"
+
+	"fmt"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// DefaultRegistryName is the name of the registry container on the host
+	DefaultRegistryName = "kind-registry"
+	// DefaultRegistryPort is the host port the registry is published on
+	DefaultRegistryPort = 5001
+	// DefaultRegistryImage is the image the registry container runs
+	DefaultRegistryImage = "registry:2"
+)
+
+// RegistryArgs configures a local container registry for a Kind cluster
+type RegistryArgs struct {
+	// Cluster whose nodes pull from the registry
+	Cluster *Cluster
+	// Name of the registry container (default kind-registry)
+	ContainerName string
+	// Host port the registry is published on (default 5001)
+	Port int
+	// Registry image (default registry:2)
+	Image string
+}
+
+// Registry is a registry container on the host, reachable from the nodes of a
+// Kind cluster as localhost:<port>
+type Registry struct {
+	pulumi.ResourceState
+
+	// Endpoint to push images to and reference them by, e.g. localhost:5001
+	Endpoint pulumi.StringOutput `pulumi:"endpoint"`
+}
+
+// RegistryEndpoint is the endpoint of a registry published on port (0 for
+// DefaultRegistryPort), as seen from the host and the nodes
+func RegistryEndpoint(port int) string {
+	if port == 0 {
+		port = DefaultRegistryPort
+	}
+	return fmt.Sprintf("localhost:%d", port)
+}
+
+// NewRegistry runs the registry container, connects it to the network of the nodes,
+// configures containerd on every node to use it and publishes the
+// local-registry-hosting ConfigMap (KEP-1755). Destroying it removes the
+// container. The Kind config must set containerd's config_path, which
+// Config.Render does.
+func NewRegistry(ctx *pulumi.Context, name string, args *RegistryArgs, opts ...pulumi.ResourceOption) (*Registry, error) {
+	registry := &Registry{}
+	err := ctx.RegisterComponentResource("home:kind:Registry", name, registry, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	containerName := args.ContainerName
+	if containerName == "" {
+		containerName = DefaultRegistryName
+	}
+	port := args.Port
+	if port == 0 {
+		port = DefaultRegistryPort
+	}
+	image := args.Image
+	if image == "" {
+		image = DefaultRegistryImage
+	}
+	endpoint := RegistryEndpoint(port)
+
+	// Start the container unless it is already running
+	run := fmt.Sprintf(`if [ "$(docker inspect -f '{{.State.Running}}' %[1]s 2>/dev/null)" != true ]; then
+  if docker inspect %[1]s >/dev/null 2>&1; then docker rm -f %[1]s >/dev/null; fi
+  docker run -d --restart=always -p 127.0.0.1:%[2]d:5000 --network bridge --name %[1]s %[3]s
+fi`, containerName, port, image)
+	container, err := local.NewCommand(ctx, fmt.Sprintf("%s-container", name), &local.CommandArgs{
+		Create:      pulumi.String(run),
+		Update:      pulumi.String(run),
+		Delete:      pulumi.String(removeContainerCommand(containerName)),
+		Interpreter: shell.Interpreter(),
+	}, pulumi.Parent(registry))
+	if err != nil {
+		return nil, err
+	}
+
+	// Nodes resolve the registry by container name on their network.
+	// Re-run whenever the cluster is recreated.
+	nodes, err := local.NewCommand(ctx, fmt.Sprintf("%s-nodes", name), &local.CommandArgs{
+		Create: args.Cluster.Name.ApplyT(func(clusterName string) string {
+			return configureNodesCommand(containerName, clusterName, endpoint, args.Cluster.Network)
+		}).(pulumi.StringOutput),
+		Triggers:    pulumi.Array{args.Cluster.Kubeconfig},
+		Interpreter: shell.Interpreter(),
+	}, pulumi.Parent(registry), pulumi.DependsOn([]pulumi.Resource{container, args.Cluster}))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = corev1.NewConfigMap(ctx, fmt.Sprintf("%s-hosting", name), &corev1.ConfigMapArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String("local-registry-hosting"),
+			Namespace: pulumi.String("kube-public"),
+		},
+		Data: pulumi.StringMap{
+			"localRegistryHosting.v1": pulumi.Sprintf("host: \"%s\"\nhelp: \"https://kind.sigs.k8s.io/docs/user/local-registry/\"\n", endpoint),
+		},
+	}, pulumi.Parent(registry), pulumi.DependsOn([]pulumi.Resource{nodes}))
+	if err != nil {
+		return nil, err
+	}
+
+	registry.Endpoint = pulumi.String(endpoint).ToStringOutput()
+	err = ctx.RegisterResourceOutputs(registry, pulumi.Map{
+		"endpoint": registry.Endpoint,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return registry, nil
+}
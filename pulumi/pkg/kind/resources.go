@@ -0,0 +1,102 @@
+package kind
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultNetwork is the Docker network Kind puts the nodes on
+	DefaultNetwork = "kind"
+	// MinNodeMemory is the smallest memory limit of a node running a
+	// control plane (etcd, the API server and the controllers)
+	MinNodeMemory = 2 << 30
+	// MinNodeCPUs is the smallest CPU limit of a node
+	MinNodeCPUs = 1.0
+)
+
+var (
+	// networkPattern matches a Docker network name
+	networkPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+	// memoryPattern matches a Docker memory size, e.g. 8g or 4096m
+	memoryPattern = regexp.MustCompile(`^([0-9]+)([bkmg]?)$`)
+)
+
+// NodeLimits are the Docker resource limits of every node of a cluster
+type NodeLimits struct {
+	// Memory limit in bytes, 0 for none
+	Memory int64
+	// Number of CPUs, 0 for none
+	CPUs float64
+}
+
+// ValidateNetwork checks a Docker network name
+func ValidateNetwork(name string) error {
+	if !networkPattern.MatchString(name) {
+		return fmt.Errorf("invalid Docker network name %q", name)
+	}
+	return nil
+}
+
+// ParseMemory parses a memory size in the format of docker update --memory:
+// a number of bytes with an optional b, k, m or g unit
+func ParseMemory(value string) (int64, error) {
+	match := memoryPattern.FindStringSubmatch(strings.ToLower(value))
+	if match == nil {
+		return 0, fmt.Errorf("invalid memory size %q, use a number with a b, k, m or g unit, e.g. 8g", value)
+	}
+	size, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory size %q: %w", value, err)
+	}
+	shift := map[string]uint{"": 0, "b": 0, "k": 10, "m": 20, "g": 30}[match[2]]
+	if size > (1<<62)>>shift {
+		return 0, fmt.Errorf("invalid memory size %q: too large", value)
+	}
+	return size << shift, nil
+}
+
+// ParseMemoryLimit parses a memory limit of the nodes, rejecting one below
+// what a control plane needs
+func ParseMemoryLimit(value string) (int64, error) {
+	size, err := ParseMemory(value)
+	if err != nil {
+		return 0, err
+	}
+	if size < MinNodeMemory {
+		return 0, fmt.Errorf("memory limit %s is below the %dg a control plane needs", value, MinNodeMemory>>30)
+	}
+	return size, nil
+}
+
+// ParseCPULimit parses a CPU limit of the nodes, rejecting one below what a
+// control plane needs or above the hostCPUs Docker can give
+func ParseCPULimit(value string, hostCPUs int) (float64, error) {
+	count, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid CPU limit %q, use a number of CPUs, e.g. 4 or 1.5", value)
+	}
+	if count < MinNodeCPUs {
+		return 0, fmt.Errorf("CPU limit %s is below the %g CPU a control plane needs", value, MinNodeCPUs)
+	}
+	if hostCPUs > 0 && count > float64(hostCPUs) {
+		return 0, fmt.Errorf("CPU limit %s is more than the %d CPUs of the host", value, hostCPUs)
+	}
+	return count, nil
+}
+
+// updateArgs returns the arguments of docker update applying the limits
+func (l NodeLimits) updateArgs() []string {
+	var args []string
+	if l.Memory > 0 {
+		// No swap beyond the limit, or the node just swaps instead
+		memory := strconv.FormatInt(l.Memory, 10)
+		args = append(args, "--memory", memory, "--memory-swap", memory)
+	}
+	if l.CPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(l.CPUs, 'f', -1, 64))
+	}
+	return args
+}
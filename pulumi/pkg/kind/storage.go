@@ -0,0 +1,116 @@
+package kind
+
+import (
+	"fmt"
+
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	storagev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/storage/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// DefaultStorageClass is the class of the pre-provisioned volumes
+	DefaultStorageClass = "local-persistent"
+	// DefaultVolumeSize is the capacity of a volume without a size
+	DefaultVolumeSize = "10Gi"
+)
+
+// Volume is a host directory mounted into every node and offered as a
+// PersistentVolume, so its data survives the cluster being recreated
+type Volume struct {
+	// Name of the PersistentVolume
+	Name string `json:"name"`
+	// Directory on the host holding the data
+	HostPath string `json:"hostPath"`
+	// Path the directory is mounted at in the nodes
+	ContainerPath string `json:"containerPath"`
+	// Capacity of the PersistentVolume (e.g. 20Gi)
+	Size string `json:"size"`
+}
+
+// Mount returns the node mount of the volume
+func (v Volume) Mount() Mount {
+	return Mount{HostPath: v.HostPath, ContainerPath: v.ContainerPath}
+}
+
+// StorageArgs configures the persistent storage of a Kind cluster
+type StorageArgs struct {
+	// Name of the StorageClass (default local-persistent)
+	ClassName string
+	// Volumes mounted into the nodes by the Kind config
+	Volumes []Volume
+}
+
+// Storage is a StorageClass with PersistentVolumes backed by host directories
+type Storage struct {
+	pulumi.ResourceState
+
+	// Name of the StorageClass to set in PersistentVolumeClaims
+	ClassName pulumi.StringOutput `pulumi:"className"`
+}
+
+// NewStorage creates a StorageClass without provisioner and a
+// PersistentVolume per volume, bound to the path the Kind config mounts it
+// at. The volumes are retained: deleting a claim, the volumes or the cluster
+// never touches the data on the host.
+func NewStorage(ctx *pulumi.Context, name string, args *StorageArgs, opts ...pulumi.ResourceOption) (*Storage, error) {
+	storage := &Storage{}
+	err := ctx.RegisterComponentResource("home:kind:Storage", name, storage, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	className := args.ClassName
+	if className == "" {
+		className = DefaultStorageClass
+	}
+	class, err := storagev1.NewStorageClass(ctx, fmt.Sprintf("%s-class", name), &storagev1.StorageClassArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name: pulumi.String(className),
+		},
+		Provisioner:       pulumi.String("kubernetes.io/no-provisioner"),
+		ReclaimPolicy:     pulumi.String("Retain"),
+		VolumeBindingMode: pulumi.String("WaitForFirstConsumer"),
+	}, pulumi.Parent(storage))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, volume := range args.Volumes {
+		size := volume.Size
+		if size == "" {
+			size = DefaultVolumeSize
+		}
+		_, err := corev1.NewPersistentVolume(ctx, fmt.Sprintf("%s-volume-%s", name, volume.Name), &corev1.PersistentVolumeArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name: pulumi.String(volume.Name),
+			},
+			Spec: &corev1.PersistentVolumeSpecArgs{
+				Capacity: pulumi.StringMap{
+					"storage": pulumi.String(size),
+				},
+				AccessModes:                   pulumi.StringArray{pulumi.String("ReadWriteOnce")},
+				PersistentVolumeReclaimPolicy: pulumi.String("Retain"),
+				StorageClassName:              class.Metadata.Name().Elem(),
+				HostPath: &corev1.HostPathVolumeSourceArgs{
+					Path: pulumi.String(volume.ContainerPath),
+					Type: pulumi.String("Directory"),
+				},
+			},
+		}, pulumi.Parent(storage))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	storage.ClassName = class.Metadata.Name().Elem()
+	err = ctx.RegisterResourceOutputs(storage, pulumi.Map{
+		"className": storage.ClassName,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return storage, nil
+}
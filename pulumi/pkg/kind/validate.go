@@ -0,0 +1,155 @@
+package kind
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+
+	"cluster-studio/pkg/validate"
+
+	"gopkg.in/yaml.v3"
+)
+
+// clusterSchema is the kind.x-k8s.io/v1alpha4 Cluster, the fields Kind accepts
+type clusterSchema struct {
+	Kind                            string            `yaml:"kind"`
+	APIVersion                      string            `yaml:"apiVersion"`
+	Name                            string            `yaml:"name"`
+	FeatureGates                    map[string]bool   `yaml:"featureGates"`
+	RuntimeConfig                   map[string]string `yaml:"runtimeConfig"`
+	Networking                      networkingSchema  `yaml:"networking"`
+	Nodes                           []nodeSchema      `yaml:"nodes"`
+	KubeadmConfigPatches            []string          `yaml:"kubeadmConfigPatches"`
+	KubeadmConfigPatchesJSON6902    []yaml.Node       `yaml:"kubeadmConfigPatchesJSON6902"`
+	ContainerdConfigPatches         []string          `yaml:"containerdConfigPatches"`
+	ContainerdConfigPatchesJSON6902 []string          `yaml:"containerdConfigPatchesJSON6902"`
+}
+
+type networkingSchema struct {
+	IPFamily          string    `yaml:"ipFamily"`
+	APIServerPort     int32     `yaml:"apiServerPort"`
+	APIServerAddress  string    `yaml:"apiServerAddress"`
+	PodSubnet         string    `yaml:"podSubnet"`
+	ServiceSubnet     string    `yaml:"serviceSubnet"`
+	DisableDefaultCNI bool      `yaml:"disableDefaultCNI"`
+	KubeProxyMode     string    `yaml:"kubeProxyMode"`
+	DNSSearch         *[]string `yaml:"dnsSearch"`
+}
+
+type nodeSchema struct {
+	Role                         string            `yaml:"role"`
+	Image                        string            `yaml:"image"`
+	Labels                       map[string]string `yaml:"labels"`
+	ExtraMounts                  []mountSchema     `yaml:"extraMounts"`
+	ExtraPortMappings            []PortMapping     `yaml:"extraPortMappings"`
+	KubeadmConfigPatches         []string          `yaml:"kubeadmConfigPatches"`
+	KubeadmConfigPatchesJSON6902 []yaml.Node       `yaml:"kubeadmConfigPatchesJSON6902"`
+}
+
+type mountSchema struct {
+	HostPath       string `yaml:"hostPath"`
+	ContainerPath  string `yaml:"containerPath"`
+	ReadOnly       bool   `yaml:"readOnly"`
+	SelinuxRelabel bool   `yaml:"selinuxRelabel"`
+	Propagation    string `yaml:"propagation"`
+}
+
+// typeErrorLine splits the errors of the yaml decoder into line and reason
+var typeErrorLine = regexp.MustCompile(`^line (\d+): (.*?)(?: in type \S+)?$`)
+
+// ValidateConfig checks that data, read from file, is a Kind cluster config
+// Kind can create a cluster from: only known fields, and valid node roles,
+// port mappings and networking modes
+func ValidateConfig(file string, data []byte) []validate.Problem {
+	problem := func(path, format string, a ...interface{}) validate.Problem {
+		return validate.Problem{File: file, Path: path, Reason: fmt.Sprintf(format, a...)}
+	}
+
+	var cluster clusterSchema
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err := decoder.Decode(&cluster)
+	var typeErr *yaml.TypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		return []validate.Problem{problem("", "empty Kind config")}
+	case errors.As(err, &typeErr):
+		var problems []validate.Problem
+		for _, message := range typeErr.Errors {
+			if match := typeErrorLine.FindStringSubmatch(message); match != nil {
+				problems = append(problems, problem("line "+match[1], "%s", match[2]))
+			} else {
+				problems = append(problems, problem("", "%s", message))
+			}
+		}
+		return problems
+	case err != nil:
+		return []validate.Problem{problem("", "%v", err)}
+	}
+
+	var problems []validate.Problem
+	if cluster.Kind != "Cluster" {
+		problems = append(problems, problem("kind", "%q is not Cluster", cluster.Kind))
+	}
+	if cluster.APIVersion != "kind.x-k8s.io/v1alpha4" {
+		problems = append(problems, problem("apiVersion", "%q is not kind.x-k8s.io/v1alpha4", cluster.APIVersion))
+	}
+
+	switch cluster.Networking.IPFamily {
+	case "", "ipv4", "ipv6", "dual":
+		networking := Networking{
+			IPFamily:      cluster.Networking.IPFamily,
+			PodSubnet:     cluster.Networking.PodSubnet,
+			ServiceSubnet: cluster.Networking.ServiceSubnet,
+		}
+		if err := networking.Validate(); err != nil {
+			problems = append(problems, problem("networking", "%v", err))
+		}
+	default:
+		problems = append(problems, problem("networking.ipFamily", "%q is not ipv4, ipv6 or dual", cluster.Networking.IPFamily))
+	}
+	switch cluster.Networking.KubeProxyMode {
+	case "", "iptables", "ipvs", "nftables", "none":
+	default:
+		problems = append(problems, problem("networking.kubeProxyMode", "%q is not iptables, ipvs, nftables or none", cluster.Networking.KubeProxyMode))
+	}
+
+	// Without nodes Kind creates a single control-plane node
+	controlPlanes := 0
+	for i, node := range cluster.Nodes {
+		path := fmt.Sprintf("nodes[%d]", i)
+		switch node.Role {
+		case "control-plane":
+			controlPlanes++
+		case "worker":
+		default:
+			problems = append(problems, problem(path+".role", "%q is not control-plane or worker", node.Role))
+		}
+		for j, mapping := range node.ExtraPortMappings {
+			mappingPath := fmt.Sprintf("%s.extraPortMappings[%d]", path, j)
+			if mapping.ContainerPort < 1 || mapping.ContainerPort > 65535 {
+				problems = append(problems, problem(mappingPath+".containerPort", "%d is not a port", mapping.ContainerPort))
+			}
+			if mapping.HostPort < 0 || mapping.HostPort > 65535 {
+				problems = append(problems, problem(mappingPath+".hostPort", "%d is not a port", mapping.HostPort))
+			}
+			switch mapping.Protocol {
+			case "", "TCP", "UDP", "SCTP":
+			default:
+				problems = append(problems, problem(mappingPath+".protocol", "%q is not TCP, UDP or SCTP", mapping.Protocol))
+			}
+		}
+		for j, mount := range node.ExtraMounts {
+			if mount.HostPath == "" || mount.ContainerPath == "" {
+				problems = append(problems, problem(fmt.Sprintf("%s.extraMounts[%d]", path, j), "hostPath and containerPath are required"))
+			}
+		}
+	}
+	if len(cluster.Nodes) > 0 && controlPlanes == 0 {
+		problems = append(problems, problem("nodes", "no control-plane node"))
+	}
+
+	return problems
+}
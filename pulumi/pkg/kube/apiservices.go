@@ -0,0 +1,43 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var apiServiceResource = schema.GroupVersionResource{Group: "apiregistration.k8s.io", Version: "v1", Resource: "apiservices"}
+
+// WaitForAPIService polls until the named APIService (e.g.
+// v1beta1.metrics.k8s.io) reports Available=True
+func WaitForAPIService(ctx context.Context, client dynamic.Interface, name string, opts PollOptions) error {
+	if opts.Description == "" {
+		opts.Description = fmt.Sprintf("APIService %s to become Available", name)
+	}
+
+	return Poll(ctx, opts, func(ctx context.Context) (bool, string, error) {
+		service, err := client.Resource(apiServiceResource).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, "not found", nil
+		}
+		if err != nil {
+			return false, "", err
+		}
+		conditions, _, _ := unstructured.NestedSlice(service.Object, "status", "conditions")
+		for _, c := range conditions {
+			cond, ok := c.(map[string]interface{})
+			if !ok || cond["type"] != "Available" {
+				continue
+			}
+			reason, _ := cond["reason"].(string)
+			message, _ := cond["message"].(string)
+			return cond["status"] == "True", fmt.Sprintf("%s: %s", reason, message), nil
+		}
+		return false, "no Available condition", nil
+	})
+}
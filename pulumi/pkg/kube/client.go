@@ -0,0 +1,128 @@
+package kube
+
+import (
+	"fmt"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// NewRESTConfig loads the client configuration of the given kube context.
+// An empty kubeconfig path uses the default loading rules ($KUBECONFIG or ~/.kube/config).
+func NewRESTConfig(kubeconfig, kubeContext string) (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if kubeconfig != "" {
+		rules.ExplicitPath = kubeconfig
+	}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+
+	restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig for context %s: %w", kubeContext, err)
+	}
+	return restConfig, nil
+}
+
+// NewClientset builds a Kubernetes clientset for the given kube context.
+// An empty kubeconfig path uses the default loading rules ($KUBECONFIG or ~/.kube/config).
+func NewClientset(kubeconfig, kubeContext string) (*kubernetes.Clientset, error) {
+	restConfig, err := NewRESTConfig(kubeconfig, kubeContext)
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client for context %s: %w", kubeContext, err)
+	}
+
+	return clientset, nil
+}
+
+// NewClientsetFromKubeconfig builds a Kubernetes clientset from the current
+// context of an in-memory kubeconfig
+func NewClientsetFromKubeconfig(kubeconfig string) (*kubernetes.Clientset, error) {
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig([]byte(kubeconfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+
+	return clientset, nil
+}
+
+// NewDynamicClientFromKubeconfig builds a dynamic client (for custom resources)
+// from the current context of an in-memory kubeconfig
+func NewDynamicClientFromKubeconfig(kubeconfig string) (dynamic.Interface, error) {
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig([]byte(kubeconfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic Kubernetes client: %w", err)
+	}
+
+	return client, nil
+}
+
+// LoadKubeconfig reads a kubeconfig file and returns it reduced to a single
+// context with all certificates inlined, along with the name of that context.
+// An empty path uses the default loading rules, an empty kubeContext the
+// current context of the file.
+func LoadKubeconfig(path, kubeContext string) (string, string, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if path != "" {
+		rules.ExplicitPath = path
+	}
+	config, err := rules.Load()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+
+	if kubeContext == "" {
+		kubeContext = config.CurrentContext
+	}
+	if _, ok := config.Contexts[kubeContext]; !ok {
+		return "", "", fmt.Errorf("context %q not found in kubeconfig", kubeContext)
+	}
+	config.CurrentContext = kubeContext
+
+	if err := clientcmdapi.MinifyConfig(config); err != nil {
+		return "", "", fmt.Errorf("failed to reduce kubeconfig to context %s: %w", kubeContext, err)
+	}
+	if err := clientcmdapi.FlattenConfig(config); err != nil {
+		return "", "", fmt.Errorf("failed to inline kubeconfig certificates: %w", err)
+	}
+	data, err := clientcmd.Write(*config)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to serialize kubeconfig: %w", err)
+	}
+
+	return string(data), kubeContext, nil
+}
+
+// WithServer returns kubeconfig with the server URL of every cluster
+// replaced by server, e.g. to reach the API server from another machine
+func WithServer(kubeconfig, server string) (string, error) {
+	config, err := clientcmd.Load([]byte(kubeconfig))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+	for _, cluster := range config.Clusters {
+		cluster.Server = server
+	}
+	data, err := clientcmd.Write(*config)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize kubeconfig: %w", err)
+	}
+	return string(data), nil
+}
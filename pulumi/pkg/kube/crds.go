@@ -0,0 +1,55 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var crdResource = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+
+// WaitForCRDsEstablished polls until every named CustomResourceDefinition
+// (e.g. ipaddresspools.metallb.io) exists and reports Established=True
+func WaitForCRDsEstablished(ctx context.Context, client dynamic.Interface, names []string, opts PollOptions) error {
+	if opts.Description == "" {
+		opts.Description = "CRDs to become Established"
+	}
+
+	return Poll(ctx, opts, func(ctx context.Context) (bool, string, error) {
+		var pending []string
+		for _, name := range names {
+			crd, err := client.Resource(crdResource).Get(ctx, name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				pending = append(pending, name+" (not found)")
+				continue
+			}
+			if err != nil {
+				return false, "", err
+			}
+			if !isEstablished(crd) {
+				pending = append(pending, name)
+			}
+		}
+		if len(pending) == 0 {
+			return true, fmt.Sprintf("%d/%d CRDs Established", len(names), len(names)), nil
+		}
+		return false, fmt.Sprintf("waiting for %s", strings.Join(pending, ", ")), nil
+	})
+}
+
+func isEstablished(crd *unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(crd.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if ok && cond["type"] == "Established" {
+			return cond["status"] == "True"
+		}
+	}
+	return false
+}
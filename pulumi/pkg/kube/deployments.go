@@ -0,0 +1,57 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// WaitForDeploymentsAvailable polls until every Deployment of names in
+// namespace exists and reports Available=True with all its replicas updated
+func WaitForDeploymentsAvailable(ctx context.Context, client kubernetes.Interface, namespace string, names []string, opts PollOptions) error {
+	if opts.Description == "" {
+		opts.Description = fmt.Sprintf("Deployments %s in %s to become Available", strings.Join(names, ", "), namespace)
+	}
+
+	return Poll(ctx, opts, func(ctx context.Context) (bool, string, error) {
+		var pending []string
+		for _, name := range names {
+			deployment, err := client.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				pending = append(pending, name+" (not found)")
+				continue
+			}
+			if err != nil {
+				return false, "", err
+			}
+			if !isDeploymentAvailable(deployment) {
+				pending = append(pending, fmt.Sprintf("%s (%d/%d available)", name, deployment.Status.AvailableReplicas, deployment.Status.Replicas))
+			}
+		}
+		if len(pending) == 0 {
+			return true, fmt.Sprintf("%d/%d Deployments Available", len(names), len(names)), nil
+		}
+		return false, fmt.Sprintf("waiting for %s", strings.Join(pending, ", ")), nil
+	})
+}
+
+func isDeploymentAvailable(deployment *appsv1.Deployment) bool {
+	if deployment.Status.ObservedGeneration < deployment.Generation {
+		return false
+	}
+	if deployment.Spec.Replicas != nil && deployment.Status.UpdatedReplicas < *deployment.Spec.Replicas {
+		return false
+	}
+	for _, cond := range deployment.Status.Conditions {
+		if cond.Type == appsv1.DeploymentAvailable {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Inventory counts a set of Kubernetes objects
+type Inventory struct {
+	// Number of objects
+	Objects int `json:"objects"`
+	// Kind -> number of objects
+	Kinds map[string]int `json:"kinds"`
+	// Namespaces containing (or being) one of the objects, sorted
+	Namespaces []string `json:"namespaces"`
+}
+
+// NewInventory returns an empty inventory
+func NewInventory() *Inventory {
+	return &Inventory{Kinds: map[string]int{}}
+}
+
+// Add counts one object. Namespace objects count as touching themselves.
+func (i *Inventory) Add(kind, namespace, name string) {
+	i.Objects++
+	i.Kinds[kind]++
+	if kind == "Namespace" {
+		namespace = name
+	}
+	if namespace == "" {
+		return
+	}
+	idx := sort.SearchStrings(i.Namespaces, namespace)
+	if idx < len(i.Namespaces) && i.Namespaces[idx] == namespace {
+		return
+	}
+	i.Namespaces = append(i.Namespaces, "")
+	copy(i.Namespaces[idx+1:], i.Namespaces[idx:])
+	i.Namespaces[idx] = namespace
+}
+
+// ListInventory counts the objects of every listable kind matching a label
+// selector, e.g. everything an installer labels as part of its component
+func ListInventory(ctx context.Context, kubeconfig, selector string) (*Inventory, error) {
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig([]byte(kubeconfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create discovery client: %w", err)
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic Kubernetes client: %w", err)
+	}
+
+	// Partial discovery failures (e.g. an unavailable metrics API) are ignored
+	lists, err := discoveryClient.ServerPreferredResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, fmt.Errorf("failed to discover API resources: %w", err)
+	}
+
+	inventory := NewInventory()
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API group version %q: %w", list.GroupVersion, err)
+		}
+		for _, resource := range list.APIResources {
+			// Skip subresources and kinds that can't be listed
+			if strings.Contains(resource.Name, "/") || !hasVerb(resource.Verbs, "list") {
+				continue
+			}
+			objects, err := client.Resource(gv.WithResource(resource.Name)).List(ctx, metav1.ListOptions{LabelSelector: selector})
+			if apierrors.IsNotFound(err) || apierrors.IsForbidden(err) || apierrors.IsMethodNotSupported(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to list %s: %w", resource.Name, err)
+			}
+			for _, obj := range objects.Items {
+				inventory.Add(resource.Kind, obj.GetNamespace(), obj.GetName())
+			}
+		}
+	}
+	return inventory, nil
+}
+
+func hasVerb(verbs metav1.Verbs, verb string) bool {
+	for _, v := range verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
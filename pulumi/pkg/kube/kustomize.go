@@ -0,0 +1,120 @@
+package kube
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	yamlutil "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// KustomizeError is a kustomization kubectl failed to build
+type KustomizeError struct {
+	Dir     string
+	Message string
+}
+
+func (e *KustomizeError) Error() string {
+	return fmt.Sprintf("kubectl kustomize %s failed: %s", e.Dir, e.Message)
+}
+
+// Kustomize builds the kustomization of dir with `kubectl kustomize`. A
+// kustomization that doesn't build is reported as a *KustomizeError.
+func Kustomize(ctx context.Context, dir string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "kubectl", "kustomize", dir)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, &KustomizeError{Dir: dir, Message: strings.TrimSpace(stderr.String())}
+		}
+		return nil, fmt.Errorf("kubectl kustomize %s failed: %w", dir, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// SplitDocuments splits a multi-document YAML stream, dropping empty documents
+func SplitDocuments(data []byte) ([][]byte, error) {
+	var docs [][]byte
+	reader := yamlutil.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err != nil {
+			return docs, err
+		}
+		if len(bytes.TrimSpace(doc)) > 0 {
+			docs = append(docs, doc)
+		}
+	}
+}
+
+// DecodeObjects decodes the objects of a multi-document YAML stream
+func DecodeObjects(data []byte) ([]*unstructured.Unstructured, error) {
+	docs, err := SplitDocuments(data)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]*unstructured.Unstructured, 0, len(docs))
+	for _, doc := range docs {
+		object := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal(doc, &object.Object); err != nil {
+			return nil, err
+		}
+		if object.Object != nil {
+			objects = append(objects, object)
+		}
+	}
+	return objects, nil
+}
+
+// Images returns the sorted images of the containers, init containers and
+// ephemeral containers of the objects, pod templates of workloads included
+func Images(objects []*unstructured.Unstructured) []string {
+	seen := map[string]bool{}
+	for _, object := range objects {
+		collectImages(object.Object, seen)
+	}
+	images := make([]string, 0, len(seen))
+	for image := range seen {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images
+}
+
+// collectImages adds the images of the container lists found anywhere in
+// value to seen
+func collectImages(value interface{}, seen map[string]bool) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if containers, ok := field.([]interface{}); ok && (key == "containers" || key == "initContainers" || key == "ephemeralContainers") {
+				for _, container := range containers {
+					if container, ok := container.(map[string]interface{}); ok {
+						if image, ok := container["image"].(string); ok && image != "" {
+							seen[image] = true
+						}
+					}
+				}
+				continue
+			}
+			collectImages(field, seen)
+		}
+	case []interface{}:
+		for _, item := range value {
+			collectImages(item, seen)
+		}
+	}
+}
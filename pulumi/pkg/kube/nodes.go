@@ -0,0 +1,115 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// WaitForNodesReady polls until every node reports Ready=True
+func WaitForNodesReady(ctx context.Context, client kubernetes.Interface, opts PollOptions) error {
+	if opts.Description == "" {
+		opts.Description = "nodes to become Ready"
+	}
+
+	return Poll(ctx, opts, func(ctx context.Context) (bool, string, error) {
+		nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false, "", err
+		}
+		if len(nodes.Items) == 0 {
+			return false, "no nodes registered yet", nil
+		}
+
+		var notReady []string
+		for i := range nodes.Items {
+			if !isNodeReady(&nodes.Items[i]) {
+				notReady = append(notReady, describeNode(&nodes.Items[i]))
+			}
+		}
+		if len(notReady) == 0 {
+			return true, fmt.Sprintf("%d/%d nodes Ready", len(nodes.Items), len(nodes.Items)), nil
+		}
+
+		status := fmt.Sprintf("%d/%d nodes NotReady:\n  %s",
+			len(notReady), len(nodes.Items), strings.Join(notReady, "\n  "))
+		return false, status, nil
+	})
+}
+
+func isNodeReady(node *corev1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// describeNode summarizes why a node is not Ready: the unhealthy kubelet
+// conditions and its taints
+func describeNode(node *corev1.Node) string {
+	var problems []string
+	for _, cond := range node.Status.Conditions {
+		healthy := cond.Status == corev1.ConditionFalse
+		if cond.Type == corev1.NodeReady {
+			healthy = cond.Status == corev1.ConditionTrue
+		}
+		if healthy {
+			continue
+		}
+		problem := fmt.Sprintf("%s=%s", cond.Type, cond.Status)
+		if cond.Reason != "" {
+			problem += fmt.Sprintf(" (%s: %s)", cond.Reason, cond.Message)
+		}
+		problems = append(problems, problem)
+	}
+	if len(problems) == 0 {
+		problems = append(problems, "no Ready condition reported")
+	}
+
+	var taints []string
+	for _, taint := range node.Spec.Taints {
+		taints = append(taints, fmt.Sprintf("%s:%s", taint.Key, taint.Effect))
+	}
+
+	description := fmt.Sprintf("%s: %s", node.Name, strings.Join(problems, "; "))
+	if len(taints) > 0 {
+		description += fmt.Sprintf(" [taints: %s]", strings.Join(taints, ", "))
+	}
+	return description
+}
+
+// WaitForAllocatable polls until the nodes report some of the extended
+// resource (e.g. nvidia.com/gpu) as allocatable and returns the total
+func WaitForAllocatable(ctx context.Context, client kubernetes.Interface, resource string, opts PollOptions) (int64, error) {
+	if opts.Description == "" {
+		opts.Description = fmt.Sprintf("nodes to report allocatable %s", resource)
+	}
+
+	var total int64
+	err := Poll(ctx, opts, func(ctx context.Context) (bool, string, error) {
+		nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false, "", err
+		}
+		total = 0
+		var found []string
+		for i := range nodes.Items {
+			quantity, ok := nodes.Items[i].Status.Allocatable[corev1.ResourceName(resource)]
+			if ok && !quantity.IsZero() {
+				total += quantity.Value()
+				found = append(found, fmt.Sprintf("%s=%s", nodes.Items[i].Name, quantity.String()))
+			}
+		}
+		if total == 0 {
+			return false, fmt.Sprintf("no node reports allocatable %s yet", resource), nil
+		}
+		return true, fmt.Sprintf("allocatable %s: %s", resource, strings.Join(found, ", ")), nil
+	})
+	return total, err
+}
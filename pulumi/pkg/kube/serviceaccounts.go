@@ -0,0 +1,68 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// WaitForServiceAccountToken polls until the token controller has filled
+// the service-account-token Secret and returns its token and CA certificate
+func WaitForServiceAccountToken(ctx context.Context, client kubernetes.Interface, namespace, name string, opts PollOptions) (string, []byte, error) {
+	if opts.Description == "" {
+		opts.Description = fmt.Sprintf("the token of Secret %s/%s", namespace, name)
+	}
+
+	var token string
+	var ca []byte
+	err := Poll(ctx, opts, func(ctx context.Context) (bool, string, error) {
+		secret, err := client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, "", err
+		}
+		token, ca = string(secret.Data[corev1.ServiceAccountTokenKey]), secret.Data[corev1.ServiceAccountRootCAKey]
+		if token == "" {
+			return false, "no token yet", nil
+		}
+		return true, "", nil
+	})
+	return token, ca, err
+}
+
+// Server returns the API server URL of the current context of kubeconfig
+func Server(kubeconfig string) (string, error) {
+	config, err := clientcmd.Load([]byte(kubeconfig))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+	context, ok := config.Contexts[config.CurrentContext]
+	if !ok {
+		return "", fmt.Errorf("kubeconfig has no current context")
+	}
+	cluster, ok := config.Clusters[context.Cluster]
+	if !ok {
+		return "", fmt.Errorf("kubeconfig has no cluster %q", context.Cluster)
+	}
+	return cluster.Server, nil
+}
+
+// TokenKubeconfig returns a standalone kubeconfig authenticating to the API
+// server with a bearer token, as user in namespace of cluster
+func TokenKubeconfig(cluster, server string, ca []byte, user, token, namespace string) (string, error) {
+	contextName := fmt.Sprintf("%s@%s", user, cluster)
+	config := clientcmdapi.NewConfig()
+	config.Clusters[cluster] = &clientcmdapi.Cluster{Server: server, CertificateAuthorityData: ca}
+	config.AuthInfos[user] = &clientcmdapi.AuthInfo{Token: token}
+	config.Contexts[contextName] = &clientcmdapi.Context{Cluster: cluster, AuthInfo: user, Namespace: namespace}
+	config.CurrentContext = contextName
+	data, err := clientcmd.Write(*config)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize kubeconfig: %w", err)
+	}
+	return string(data), nil
+}
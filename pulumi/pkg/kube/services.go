@@ -0,0 +1,66 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// WaitForServiceEndpoints polls until the Service has at least one ready
+// endpoint, i.e. a pod behind it is able to serve (e.g. an admission webhook)
+func WaitForServiceEndpoints(ctx context.Context, client kubernetes.Interface, namespace, name string, opts PollOptions) error {
+	if opts.Description == "" {
+		opts.Description = fmt.Sprintf("Service %s/%s to have ready endpoints", namespace, name)
+	}
+
+	return Poll(ctx, opts, func(ctx context.Context) (bool, string, error) {
+		slices, err := client.DiscoveryV1().EndpointSlices(namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: discoveryv1.LabelServiceName + "=" + name,
+		})
+		if err != nil {
+			return false, "", err
+		}
+
+		ready, total := 0, 0
+		for _, slice := range slices.Items {
+			for _, endpoint := range slice.Endpoints {
+				total++
+				if endpoint.Conditions.Ready != nil && *endpoint.Conditions.Ready {
+					ready++
+				}
+			}
+		}
+		return ready > 0, fmt.Sprintf("%d/%d endpoints ready", ready, total), nil
+	})
+}
+
+// WaitForLoadBalancer polls until a Service of type LoadBalancer has been
+// assigned an address and returns it
+func WaitForLoadBalancer(ctx context.Context, client kubernetes.Interface, namespace, name string, opts PollOptions) (string, error) {
+	if opts.Description == "" {
+		opts.Description = fmt.Sprintf("Service %s/%s to get a load balancer address", namespace, name)
+	}
+
+	var address string
+	err := Poll(ctx, opts, func(ctx context.Context) (bool, string, error) {
+		service, err := client.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, "", err
+		}
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			if ingress.IP != "" {
+				address = ingress.IP
+			} else if ingress.Hostname != "" {
+				address = ingress.Hostname
+			}
+			if address != "" {
+				return true, fmt.Sprintf("address %s assigned", address), nil
+			}
+		}
+		return false, "no load balancer address assigned (is a load balancer such as MetalLB installed?)", nil
+	})
+	return address, err
+}
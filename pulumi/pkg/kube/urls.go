@@ -0,0 +1,213 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// httpRouteResource is the Gateway API kind routing to Services like an Ingress
+var httpRouteResource = schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "httproutes"}
+
+// ServiceRef is a Service whose URL is looked up after the deploy
+type ServiceRef struct {
+	// Key of the URL in the output (e.g. "grafana")
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+	// Service port to reach, defaults to the first one
+	Port int32 `json:"port,omitempty"`
+}
+
+// ServiceURL returns how to reach a Service from the host: the URL of an
+// Ingress or an HTTPRoute routing to it, a kubectl port-forward command for a
+// ClusterIP Service, or the NodePort on the IP of a node. found is false when
+// the Service doesn't exist (e.g. its component is not installed).
+func ServiceURL(ctx context.Context, client kubernetes.Interface, dynamicClient dynamic.Interface, kubeContext string, ref ServiceRef) (url string, found bool, err error) {
+	service, err := client.CoreV1().Services(ref.Namespace).Get(ctx, ref.Service, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get Service %s/%s: %w", ref.Namespace, ref.Service, err)
+	}
+	port, ok := servicePort(service, ref.Port)
+	if !ok {
+		return "", false, nil
+	}
+
+	url, err = ingressURL(ctx, client, service, port)
+	if err != nil {
+		return "", false, err
+	}
+	if url != "" {
+		return url, true, nil
+	}
+	url, err = httpRouteURL(ctx, dynamicClient, service, port)
+	if err != nil {
+		return "", false, err
+	}
+	if url != "" {
+		return url, true, nil
+	}
+
+	if service.Spec.Type == corev1.ServiceTypeClusterIP {
+		return fmt.Sprintf("kubectl --context %s -n %s port-forward svc/%s %d:%d",
+			kubeContext, ref.Namespace, ref.Service, port.Port, port.Port), true, nil
+	}
+
+	if port.NodePort == 0 {
+		return "", false, nil
+	}
+	nodeIP, err := NodeInternalIP(ctx, client)
+	if err != nil || nodeIP == "" {
+		return "", false, err
+	}
+	return fmt.Sprintf("http://%s:%d", nodeIP, port.NodePort), true, nil
+}
+
+// servicePort returns the port of the Service with the given number, or its
+// first port when number is 0
+func servicePort(service *corev1.Service, number int32) (corev1.ServicePort, bool) {
+	for _, port := range service.Spec.Ports {
+		if number == 0 || port.Port == number {
+			return port, true
+		}
+	}
+	return corev1.ServicePort{}, false
+}
+
+// ingressURL returns the URL of the first Ingress rule with a host routing to
+// the Service port, or "" when there is none
+func ingressURL(ctx context.Context, client kubernetes.Interface, service *corev1.Service, port corev1.ServicePort) (string, error) {
+	ingresses, err := client.NetworkingV1().Ingresses(service.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to list Ingresses in %s: %w", service.Namespace, err)
+	}
+	for _, ingress := range ingresses.Items {
+		for _, rule := range ingress.Spec.Rules {
+			if rule.Host == "" || rule.HTTP == nil {
+				continue
+			}
+			for _, path := range rule.HTTP.Paths {
+				if routesTo(path.Backend, service.Name, port) {
+					return fmt.Sprintf("%s://%s%s", ingressScheme(&ingress, rule.Host), rule.Host, path.Path), nil
+				}
+			}
+		}
+	}
+	return "", nil
+}
+
+func routesTo(backend networkingv1.IngressBackend, service string, port corev1.ServicePort) bool {
+	if backend.Service == nil || backend.Service.Name != service {
+		return false
+	}
+	switch {
+	case backend.Service.Port.Name != "":
+		return backend.Service.Port.Name == port.Name
+	case backend.Service.Port.Number != 0:
+		return backend.Service.Port.Number == port.Port
+	}
+	return true
+}
+
+// httpRouteURL returns the URL of the first HTTPRoute with a hostname whose
+// rule has a backend on the Service port, or "" when there is none or the
+// Gateway API is not installed
+func httpRouteURL(ctx context.Context, client dynamic.Interface, service *corev1.Service, port corev1.ServicePort) (string, error) {
+	routes, err := client.Resource(httpRouteResource).Namespace(service.Namespace).List(ctx, metav1.ListOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to list HTTPRoutes in %s: %w", service.Namespace, err)
+	}
+	for _, route := range routes.Items {
+		hostnames, _, _ := unstructured.NestedStringSlice(route.Object, "spec", "hostnames")
+		if len(hostnames) == 0 {
+			continue
+		}
+		rules, _, _ := unstructured.NestedSlice(route.Object, "spec", "rules")
+		for _, rule := range rules {
+			rule, ok := rule.(map[string]interface{})
+			if !ok || !routeRuleTo(rule, service.Name, port) {
+				continue
+			}
+			return fmt.Sprintf("http://%s%s", hostnames[0], routeRulePath(rule)), nil
+		}
+	}
+	return "", nil
+}
+
+// routeRuleTo reports whether an HTTPRoute rule has a backend on the Service
+// port, backends without a port match any
+func routeRuleTo(rule map[string]interface{}, service string, port corev1.ServicePort) bool {
+	backends, _, _ := unstructured.NestedSlice(rule, "backendRefs")
+	for _, backend := range backends {
+		backend, ok := backend.(map[string]interface{})
+		if !ok || backend["name"] != service {
+			continue
+		}
+		if kind, _, _ := unstructured.NestedString(backend, "kind"); kind != "" && kind != "Service" {
+			continue
+		}
+		number, found, _ := unstructured.NestedInt64(backend, "port")
+		if !found || int32(number) == port.Port {
+			return true
+		}
+	}
+	return false
+}
+
+// routeRulePath is the path of the first path match of an HTTPRoute rule, "/"
+// without one
+func routeRulePath(rule map[string]interface{}) string {
+	matches, _, _ := unstructured.NestedSlice(rule, "matches")
+	for _, match := range matches {
+		match, ok := match.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if path, _, _ := unstructured.NestedString(match, "path", "value"); path != "" {
+			return path
+		}
+	}
+	return "/"
+}
+
+// ingressScheme is https when the Ingress terminates TLS for host
+func ingressScheme(ingress *networkingv1.Ingress, host string) string {
+	for _, tls := range ingress.Spec.TLS {
+		for _, tlsHost := range tls.Hosts {
+			if tlsHost == host {
+				return "https"
+			}
+		}
+	}
+	return "http"
+}
+
+// NodeInternalIP returns the internal IP of the first node, "" without nodes
+func NodeInternalIP(ctx context.Context, client kubernetes.Interface) (string, error) {
+	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to list nodes: %w", err)
+	}
+	for _, node := range nodes.Items {
+		for _, address := range node.Status.Addresses {
+			if address.Type == corev1.NodeInternalIP {
+				return address.Address, nil
+			}
+		}
+	}
+	return "", nil
+}
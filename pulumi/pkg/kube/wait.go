@@ -0,0 +1,79 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Logf is used by the pollers to report progress
+type Logf func(format string, args ...interface{})
+
+// CheckFunc inspects the cluster once. It returns whether the wait is done and
+// a human readable description of the observed state.
+type CheckFunc func(ctx context.Context) (done bool, status string, err error)
+
+// PollOptions controls how Poll waits for a condition
+type PollOptions struct {
+	// Description of what is being waited for, used in logs and errors
+	Description string
+	// Interval between checks
+	Interval time.Duration
+	// Timeout for the whole wait
+	Timeout time.Duration
+	// Logf receives a line every time the observed state changes (optional)
+	Logf Logf
+}
+
+// Poll runs check until it reports done or the timeout expires. Errors returned
+// by check are treated as transient (the API server may still be settling) and
+// are reported as the observed state. On timeout the error includes the last
+// observed state. SIGINT and SIGTERM (Ctrl-C on `pulumi up`) stop the wait.
+func Poll(ctx context.Context, opts PollOptions, check CheckFunc) error {
+	if opts.Interval <= 0 {
+		opts.Interval = 5 * time.Second
+	}
+	if opts.Logf == nil {
+		opts.Logf = func(string, ...interface{}) {}
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	lastStatus := "no status observed yet"
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		done, status, err := check(ctx)
+		if err != nil {
+			status = fmt.Sprintf("error: %v", err)
+		}
+		if done {
+			opts.Logf("%s: done after %s", opts.Description, time.Since(start).Round(time.Second))
+			return nil
+		}
+		if status != lastStatus {
+			opts.Logf("%s: %s", opts.Description, status)
+			lastStatus = status
+		}
+
+		select {
+		case <-ctx.Done():
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("interrupted after %s waiting for %s, last observed status:\n%s",
+					time.Since(start).Round(time.Second), opts.Description, lastStatus)
+			}
+			return fmt.Errorf("timed out after %s waiting for %s, last observed status:\n%s",
+				opts.Timeout, opts.Description, lastStatus)
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package kyverno
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// ChartRepo is the Helm repository serving the Kyverno chart
+	ChartRepo = "https://kyverno.github.io/kyverno/"
+	// Namespace is where Kyverno is installed
+	Namespace = "kyverno"
+)
+
+// crds must be Established before the ClusterPolicies can be created
+var crds = []string{
+	"clusterpolicies.kyverno.io",
+}
+
+// InstallArgs configures Kyverno and its policies
+type InstallArgs struct {
+	// Chart version
+	Version string
+	// Baseline policies to create, with their mode
+	Policies []Policy
+	// Kubeconfig of the cluster (secret), used to wait for the CRDs and the
+	// policies
+	Kubeconfig pulumi.StringInput
+	// How long to wait for Kyverno and the policies
+	Timeout time.Duration
+}
+
+// Install is Kyverno with the baseline ClusterPolicies
+type Install struct {
+	pulumi.ResourceState
+
+	// Names of the created ClusterPolicies
+	Policies pulumi.StringArrayOutput `pulumi:"policies"`
+}
+
+// NewInstall installs Kyverno with Helm, waits for its CRDs and creates the
+// baseline ClusterPolicies. Its status is recorded in a ConfigMap of
+// kube-system once every policy is Ready, so objects applied by resources
+// depending on the component are admitted by the policies.
+func NewInstall(ctx *pulumi.Context, name string, args *InstallArgs, opts ...pulumi.ResourceOption) (*Install, error) {
+	install := &Install{}
+	err := ctx.RegisterComponentResource("home:kyverno:Install", name, install, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	logf := func(format string, a ...interface{}) {
+		_ = ctx.Log.Info(fmt.Sprintf(format, a...), &pulumi.LogArgs{Resource: install})
+	}
+
+	release, err := helmv3.NewRelease(ctx, stacks.Name(ctx, "kyverno"), &helmv3.ReleaseArgs{
+		Name:            pulumi.String("kyverno"),
+		Chart:           pulumi.String("kyverno"),
+		Version:         pulumi.String(args.Version),
+		Namespace:       pulumi.String(Namespace),
+		CreateNamespace: pulumi.Bool(true),
+		RepositoryOpts:  helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+		Timeout:         pulumi.Int(int(args.Timeout.Seconds())),
+	}, pulumi.Parent(install))
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolves to the release once its CRDs are Established
+	established := pulumi.All(release.ID(), args.Kubeconfig).ApplyT(func(values []interface{}) ([]pulumi.Resource, error) {
+		if ctx.DryRun() {
+			return []pulumi.Resource{release}, nil
+		}
+		client, err := kube.NewDynamicClientFromKubeconfig(values[1].(string))
+		if err != nil {
+			return nil, err
+		}
+		err = kube.WaitForCRDsEstablished(context.Background(), client, crds, kube.PollOptions{
+			Description: "Kyverno CRDs to become Established",
+			Timeout:     args.Timeout,
+			Logf:        logf,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []pulumi.Resource{release}, nil
+	}).(pulumi.ResourceArrayOutput)
+
+	var names []string
+	var ids pulumi.Array
+	for i := range args.Policies {
+		policy := &args.Policies[i]
+		rules, err := policy.rules()
+		if err != nil {
+			return nil, err
+		}
+		clusterPolicy, err := apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-%s", name, policy.Name), &apiextensions.CustomResourceArgs{
+			ApiVersion: pulumi.String("kyverno.io/v1"),
+			Kind:       pulumi.String("ClusterPolicy"),
+			Metadata: &metav1.ObjectMetaArgs{
+				Name: pulumi.String(policy.Name),
+			},
+			OtherFields: kubernetes.UntypedArgs{
+				"spec": pulumi.Map{
+					"background": pulumi.Bool(true),
+					"rules":      rules,
+				},
+			},
+		}, pulumi.Parent(install), pulumi.DependsOnInputs(established))
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, policy.Name)
+		ids = append(ids, clusterPolicy.ID())
+	}
+
+	// Resolves once every policy is Ready
+	status := pulumi.All(ids, args.Kubeconfig).ApplyT(func(values []interface{}) (string, error) {
+		if ctx.DryRun() {
+			return "", nil
+		}
+		client, err := kube.NewDynamicClientFromKubeconfig(values[1].(string))
+		if err != nil {
+			return "", err
+		}
+		err = WaitForPolicies(context.Background(), client, names, kube.PollOptions{
+			Timeout: args.Timeout,
+			Logf:    logf,
+		})
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d policies Ready", len(names)), nil
+	}).(pulumi.StringOutput)
+
+	_, err = corev1.NewConfigMap(ctx, fmt.Sprintf("%s-report", name), &corev1.ConfigMapArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(name),
+			Namespace: pulumi.String("kube-system"),
+		},
+		Data: pulumi.StringMap{
+			"policies": status,
+		},
+	}, pulumi.Parent(install))
+	if err != nil {
+		return nil, err
+	}
+
+	install.Policies = pulumi.ToStringArray(names).ToStringArrayOutput()
+	err = ctx.RegisterResourceOutputs(install, pulumi.Map{
+		"policies": install.Policies,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return install, nil
+}
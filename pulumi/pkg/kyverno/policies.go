@@ -0,0 +1,130 @@
+package kyverno
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Modes of a policy
+const (
+	// Enforce rejects violating objects when they are applied
+	Enforce = "enforce"
+	// Audit admits them and records the violation in a PolicyReport
+	Audit = "audit"
+	// Off leaves the policy out
+	Off = "off"
+)
+
+// Baseline is the policy bundle, by name
+var Baseline = map[string]func(p *Policy) pulumi.Array{
+	"disallow-latest-tag":     disallowLatestTag,
+	"require-resource-limits": requireResourceLimits,
+	"restrict-host-path":      restrictHostPath,
+}
+
+// PolicyNames returns the names of the baseline policies, sorted
+func PolicyNames() []string {
+	names := make([]string, 0, len(Baseline))
+	for name := range Baseline {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Policy is a baseline policy and where it applies
+type Policy struct {
+	Name string
+	// Enforce or Audit
+	Mode string
+	// Namespaces the policy doesn't apply to
+	ExcludeNamespaces []string
+}
+
+// rules returns the rules of the policy, validating Pods and, through
+// Kyverno's auto-generated rules, the controllers creating them
+func (p *Policy) rules() (pulumi.Array, error) {
+	rules, ok := Baseline[p.Name]
+	if !ok {
+		return nil, fmt.Errorf("unknown policy %s, use one of %s", p.Name, strings.Join(PolicyNames(), ", "))
+	}
+	return rules(p), nil
+}
+
+// rule validates Pods outside the excluded namespaces against pattern
+func (p *Policy) rule(name, message string, pattern pulumi.Map) pulumi.Map {
+	rule := pulumi.Map{
+		"name": pulumi.String(name),
+		"match": pulumi.Map{
+			"any": pulumi.Array{
+				pulumi.Map{"resources": pulumi.Map{"kinds": pulumi.ToStringArray([]string{"Pod"})}},
+			},
+		},
+		"validate": pulumi.Map{
+			"failureAction": pulumi.String(failureAction(p.Mode)),
+			"message":       pulumi.String(message),
+			"pattern":       pattern,
+		},
+	}
+	if len(p.ExcludeNamespaces) > 0 {
+		rule["exclude"] = pulumi.Map{
+			"any": pulumi.Array{
+				pulumi.Map{"resources": pulumi.Map{"namespaces": pulumi.ToStringArray(p.ExcludeNamespaces)}},
+			},
+		}
+	}
+	return rule
+}
+
+// failureAction is the Kyverno failure action of a mode
+func failureAction(mode string) string {
+	if mode == Enforce {
+		return "Enforce"
+	}
+	return "Audit"
+}
+
+func disallowLatestTag(p *Policy) pulumi.Array {
+	containers := func(image string) pulumi.Map {
+		return pulumi.Map{
+			"spec": pulumi.Map{
+				"containers":        pulumi.Array{pulumi.Map{"image": pulumi.String(image)}},
+				"=(initContainers)": pulumi.Array{pulumi.Map{"image": pulumi.String(image)}},
+			},
+		}
+	}
+	return pulumi.Array{
+		p.rule("require-image-tag", "An image tag is required.", containers("*:*")),
+		p.rule("validate-image-tag", "Using a mutable image tag e.g. 'latest' is not allowed.", containers("!*:latest")),
+	}
+}
+
+func requireResourceLimits(p *Policy) pulumi.Array {
+	return pulumi.Array{
+		p.rule("validate-resources", "CPU and memory limits are required.", pulumi.Map{
+			"spec": pulumi.Map{
+				"containers": pulumi.Array{pulumi.Map{
+					"resources": pulumi.Map{
+						"limits": pulumi.Map{
+							"cpu":    pulumi.String("?*"),
+							"memory": pulumi.String("?*"),
+						},
+					},
+				}},
+			},
+		}),
+	}
+}
+
+func restrictHostPath(p *Policy) pulumi.Array {
+	return pulumi.Array{
+		p.rule("host-path", "HostPath volumes are only allowed in the storage namespaces.", pulumi.Map{
+			"spec": pulumi.Map{
+				"=(volumes)": pulumi.Array{pulumi.Map{"X(hostPath)": pulumi.String("null")}},
+			},
+		}),
+	}
+}
@@ -0,0 +1,90 @@
+package kyverno
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"cluster-studio/pkg/kube"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var (
+	clusterPolicyResource       = schema.GroupVersionResource{Group: "kyverno.io", Version: "v1", Resource: "clusterpolicies"}
+	policyReportResource        = schema.GroupVersionResource{Group: "wgpolicyk8s.io", Version: "v1alpha2", Resource: "policyreports"}
+	clusterPolicyReportResource = schema.GroupVersionResource{Group: "wgpolicyk8s.io", Version: "v1alpha2", Resource: "clusterpolicyreports"}
+)
+
+// WaitForPolicies polls until every named ClusterPolicy reports Ready=True
+func WaitForPolicies(ctx context.Context, client dynamic.Interface, names []string, opts kube.PollOptions) error {
+	if opts.Description == "" {
+		opts.Description = "Kyverno policies to become Ready"
+	}
+
+	return kube.Poll(ctx, opts, func(ctx context.Context) (bool, string, error) {
+		var pending []string
+		for _, name := range names {
+			policy, err := client.Resource(clusterPolicyResource).Get(ctx, name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				pending = append(pending, name+" (not found)")
+				continue
+			}
+			if err != nil {
+				return false, "", err
+			}
+			if !isReady(policy) {
+				pending = append(pending, name)
+			}
+		}
+		if len(pending) == 0 {
+			return true, fmt.Sprintf("%d/%d policies Ready", len(names), len(names)), nil
+		}
+		return false, fmt.Sprintf("waiting for %s", strings.Join(pending, ", ")), nil
+	})
+}
+
+func isReady(policy *unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(policy.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if ok && cond["type"] == "Ready" {
+			return cond["status"] == "True"
+		}
+	}
+	return false
+}
+
+// CountViolations counts the failed results of the named policies in the
+// PolicyReports and ClusterPolicyReports, by policy
+func CountViolations(ctx context.Context, client dynamic.Interface, policies []string) (map[string]int, error) {
+	counts := make(map[string]int, len(policies))
+	for _, policy := range policies {
+		counts[policy] = 0
+	}
+
+	for _, gvr := range []schema.GroupVersionResource{policyReportResource, clusterPolicyReportResource} {
+		reports, err := client.Resource(gvr).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", gvr.Resource, err)
+		}
+		for _, report := range reports.Items {
+			results, _, _ := unstructured.NestedSlice(report.Object, "results")
+			for _, r := range results {
+				result, ok := r.(map[string]interface{})
+				if !ok || result["result"] != "fail" {
+					continue
+				}
+				policy, _ := result["policy"].(string)
+				if _, counted := counts[policy]; counted {
+					counts[policy]++
+				}
+			}
+		}
+	}
+	return counts, nil
+}
@@ -0,0 +1,93 @@
+package linkerd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Check is one check reported by `linkerd check --output json`
+type Check struct {
+	Category    string `json:"category"`
+	Description string `json:"description"`
+	// "success", "warning" or "error"
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+	Hint   string `json:"hint,omitempty"`
+}
+
+func (c Check) String() string {
+	if c.Error == "" {
+		return fmt.Sprintf("%s: %s", c.Category, c.Description)
+	}
+	return fmt.Sprintf("%s: %s: %s", c.Category, c.Description, c.Error)
+}
+
+// severities ranks the check results
+var severities = map[string]int{
+	"success": 0,
+	"warning": 1,
+	"error":   2,
+}
+
+// AtLeast reports whether the result of the check is as severe as severity
+// ("warning" or "error")
+func (c Check) AtLeast(severity string) bool {
+	return severities[c.Result] >= severities[severity]
+}
+
+// ParseCheck reads the checks of `linkerd check --output json`, and of the
+// check of the extensions (e.g. `linkerd viz check`)
+func ParseCheck(output string) ([]Check, error) {
+	var report struct {
+		Categories []struct {
+			CategoryName string `json:"categoryName"`
+			Checks       []struct {
+				Description string `json:"description"`
+				Hint        string `json:"hint"`
+				Error       string `json:"error"`
+				Result      string `json:"result"`
+			} `json:"checks"`
+		} `json:"categories"`
+	}
+	if err := json.Unmarshal([]byte(output), &report); err != nil {
+		return nil, fmt.Errorf("could not parse the linkerd check output %q: %w", strings.TrimSpace(output), err)
+	}
+
+	var checks []Check
+	for _, category := range report.Categories {
+		for _, check := range category.Checks {
+			checks = append(checks, Check{
+				Category:    category.CategoryName,
+				Description: check.Description,
+				Result:      check.Result,
+				Error:       check.Error,
+				Hint:        check.Hint,
+			})
+		}
+	}
+	return checks, nil
+}
+
+// RunCheck runs the linkerd CLI with args and "--output json" and parses the
+// checks it reports. The CLI exits with an error when a check fails, the
+// output tells which, so only an output that can't be parsed is an error.
+func RunCheck(ctx context.Context, kubeconfigPath string, args ...string) ([]Check, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "linkerd", append(args, "--output", "json")...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if kubeconfigPath != "" {
+		cmd.Env = append(os.Environ(), "KUBECONFIG="+kubeconfigPath)
+	}
+	runErr := cmd.Run()
+
+	checks, err := ParseCheck(stdout.String())
+	if err != nil && runErr != nil {
+		return nil, fmt.Errorf("linkerd %s failed: %v: %s", strings.Join(args, " "), runErr, strings.TrimSpace(stderr.String()))
+	}
+	return checks, err
+}
@@ -0,0 +1,149 @@
+package linkerd
+
+import (
+	"time"
+
+	"cluster-studio/pkg/stacks"
+
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// ChartRepo is the Helm repository serving the Linkerd charts
+const ChartRepo = "https://helm.linkerd.io/edge"
+
+// Namespace is where the Linkerd control plane is installed
+const Namespace = "linkerd"
+
+const (
+	// CRDsRelease and ControlPlaneRelease are the Helm releases of the
+	// control plane, in Namespace
+	CRDsRelease         = "linkerd-crds"
+	ControlPlaneRelease = "linkerd-control-plane"
+	// TrustRootsConfigMap holds the trust anchors of a running control plane
+	TrustRootsConfigMap = "linkerd-identity-trust-roots"
+)
+
+// ControlPlaneArgs configures the Linkerd Helm installation
+type ControlPlaneArgs struct {
+	// Chart version of linkerd-crds and linkerd-control-plane
+	Version string
+	// PEM encoded trust anchor certificate
+	TrustAnchorPEM pulumi.StringInput
+	// PEM encoded issuer certificate and key, signed by the trust anchor.
+	// Stored in the linkerd-identity-issuer Secret.
+	IssuerCertPEM pulumi.StringInput
+	IssuerKeyPEM  pulumi.StringInput
+	// How long to wait for the control plane deployments to become available
+	Timeout time.Duration
+	// Releases already installed, imported instead of installed (optional)
+	Import []string
+}
+
+// ControlPlane is the Linkerd CRDs and control plane installed with Helm
+type ControlPlane struct {
+	pulumi.ResourceState
+
+	// Installed chart version, resolved once the control plane is available
+	Version pulumi.StringOutput `pulumi:"version"`
+}
+
+// NewControlPlane installs the Linkerd CRDs followed by the control plane. The
+// component completes once the control plane deployments are available.
+func NewControlPlane(ctx *pulumi.Context, name string, args *ControlPlaneArgs, opts ...pulumi.ResourceOption) (*ControlPlane, error) {
+	controlPlane := &ControlPlane{}
+	err := ctx.RegisterComponentResource("home:linkerd:ControlPlane", name, controlPlane, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)}
+	timeout := pulumi.Int(int(args.Timeout.Seconds()))
+
+	// CRDs must exist before the control plane chart references them
+	crds, err := helmv3.NewRelease(ctx, stacks.Name(ctx, CRDsRelease), &helmv3.ReleaseArgs{
+		Name:            pulumi.String(CRDsRelease),
+		Chart:           pulumi.String(CRDsRelease),
+		Version:         pulumi.String(args.Version),
+		Namespace:       pulumi.String(Namespace),
+		CreateNamespace: pulumi.Bool(true),
+		RepositoryOpts:  repo,
+		Timeout:         timeout,
+		Values: pulumi.Map{
+			"installGatewayAPI": pulumi.Bool(true),
+		},
+	}, pulumi.Parent(controlPlane), importRelease(args.Import, CRDsRelease))
+	if err != nil {
+		return nil, err
+	}
+
+	// The issuer lives in a Secret managed here rather than in the chart values,
+	// so rotating it doesn't touch the Helm release. The one of an imported
+	// control plane was applied by someone else, it is taken over.
+	issuerMetadata := &metav1.ObjectMetaArgs{
+		Name:      pulumi.String("linkerd-identity-issuer"),
+		Namespace: pulumi.String(Namespace),
+	}
+	if len(args.Import) > 0 {
+		issuerMetadata.Annotations = pulumi.StringMap{"pulumi.com/patchForce": pulumi.String("true")}
+	}
+	issuer, err := corev1.NewSecret(ctx, stacks.Name(ctx, "linkerd-identity-issuer"), &corev1.SecretArgs{
+		Metadata: issuerMetadata,
+		Type:     pulumi.String("kubernetes.io/tls"),
+		StringData: pulumi.StringMap{
+			"ca.crt":  args.TrustAnchorPEM,
+			"tls.crt": args.IssuerCertPEM,
+			"tls.key": args.IssuerKeyPEM,
+		},
+	}, pulumi.Parent(controlPlane), pulumi.DependsOn([]pulumi.Resource{crds}))
+	if err != nil {
+		return nil, err
+	}
+
+	// Helm waits for the control plane deployments to become available
+	release, err := helmv3.NewRelease(ctx, stacks.Name(ctx, ControlPlaneRelease), &helmv3.ReleaseArgs{
+		Name:           pulumi.String(ControlPlaneRelease),
+		Chart:          pulumi.String(ControlPlaneRelease),
+		Version:        pulumi.String(args.Version),
+		Namespace:      pulumi.String(Namespace),
+		RepositoryOpts: repo,
+		Timeout:        timeout,
+		Values: pulumi.Map{
+			"identityTrustAnchorsPEM": args.TrustAnchorPEM,
+			"identity": pulumi.Map{
+				"externalCA": pulumi.Bool(true),
+				"issuer": pulumi.Map{
+					"scheme": pulumi.String("kubernetes.io/tls"),
+				},
+			},
+		},
+	}, pulumi.Parent(controlPlane), pulumi.DependsOn([]pulumi.Resource{crds, issuer}), importRelease(args.Import, ControlPlaneRelease))
+	if err != nil {
+		return nil, err
+	}
+
+	controlPlane.Version = release.Status.ApplyT(func(helmv3.ReleaseStatus) string {
+		return args.Version
+	}).(pulumi.StringOutput)
+	err = ctx.RegisterResourceOutputs(controlPlane, pulumi.Map{
+		"version": controlPlane.Version,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return controlPlane, nil
+}
+
+// importRelease imports the Helm release name from Namespace when it is one of
+// releases
+func importRelease(releases []string, name string) pulumi.ResourceOption {
+	for _, release := range releases {
+		if release == name {
+			return pulumi.Import(pulumi.ID(Namespace + "/" + name))
+		}
+	}
+	return pulumi.Import(nil)
+}
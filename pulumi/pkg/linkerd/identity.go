@@ -0,0 +1,180 @@
+package linkerd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+const (
+	trustAnchorCommonName = "root.linkerd.cluster.local"
+	issuerCommonName      = "identity.linkerd.cluster.local"
+)
+
+// Identity is the Linkerd trust anchor and the issuer certificate signed by it
+type Identity struct {
+	TrustAnchorPEM    string `json:"trustAnchorPEM"`
+	TrustAnchorKeyPEM string `json:"trustAnchorKeyPEM"`
+	IssuerCertPEM     string `json:"issuerCertPEM"`
+	IssuerKeyPEM      string `json:"issuerKeyPEM"`
+}
+
+// IdentityOptions controls how EnsureIdentity reuses or regenerates certificates
+type IdentityOptions struct {
+	// Validity of a newly generated trust anchor
+	TrustAnchorValidity time.Duration
+	// Validity of a newly generated issuer certificate
+	IssuerValidity time.Duration
+	// Regenerate the issuer even if the current one is still valid
+	RotateIssuer bool
+}
+
+// EnsureIdentity returns the identity to install. The trust anchor of previous
+// is kept as long as it is valid, so rotating the issuer never requires
+// re-issuing every proxy certificate. A missing or expired issuer is
+// regenerated, as is any issuer when RotateIssuer is set.
+func EnsureIdentity(previous *Identity, opts IdentityOptions) (*Identity, error) {
+	now := time.Now()
+	identity := &Identity{}
+
+	if previous != nil && certValid(previous.TrustAnchorPEM, now) && previous.TrustAnchorKeyPEM != "" {
+		identity.TrustAnchorPEM = previous.TrustAnchorPEM
+		identity.TrustAnchorKeyPEM = previous.TrustAnchorKeyPEM
+	} else {
+		certPEM, keyPEM, err := generateTrustAnchor(opts.TrustAnchorValidity)
+		if err != nil {
+			return nil, err
+		}
+		identity.TrustAnchorPEM = certPEM
+		identity.TrustAnchorKeyPEM = keyPEM
+	}
+
+	// The issuer can only be reused if it was signed by the anchor we keep
+	anchorKept := previous != nil && identity.TrustAnchorPEM == previous.TrustAnchorPEM
+	if anchorKept && !opts.RotateIssuer && certValid(previous.IssuerCertPEM, now) && previous.IssuerKeyPEM != "" {
+		identity.IssuerCertPEM = previous.IssuerCertPEM
+		identity.IssuerKeyPEM = previous.IssuerKeyPEM
+		return identity, nil
+	}
+
+	certPEM, keyPEM, err := generateIssuer(identity.TrustAnchorPEM, identity.TrustAnchorKeyPEM, opts.IssuerValidity)
+	if err != nil {
+		return nil, err
+	}
+	identity.IssuerCertPEM = certPEM
+	identity.IssuerKeyPEM = keyPEM
+
+	return identity, nil
+}
+
+// generateTrustAnchor creates a self-signed root CA
+func generateTrustAnchor(validity time.Duration) (string, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate trust anchor key: %w", err)
+	}
+
+	template, err := caTemplate(trustAnchorCommonName, validity)
+	if err != nil {
+		return "", "", err
+	}
+	template.MaxPathLen = 1
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create trust anchor certificate: %w", err)
+	}
+
+	return encode(der, key)
+}
+
+// generateIssuer creates an intermediate CA signed by the trust anchor
+func generateIssuer(anchorCertPEM, anchorKeyPEM string, validity time.Duration) (string, string, error) {
+	anchorCert, err := parseCertificate(anchorCertPEM)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid trust anchor certificate: %w", err)
+	}
+	anchorKey, err := parseKey(anchorKeyPEM)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid trust anchor key: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate issuer key: %w", err)
+	}
+
+	template, err := caTemplate(issuerCommonName, validity)
+	if err != nil {
+		return "", "", err
+	}
+	template.MaxPathLenZero = true
+
+	der, err := x509.CreateCertificate(rand.Reader, template, anchorCert, &key.PublicKey, anchorKey)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create issuer certificate: %w", err)
+	}
+
+	return encode(der, key)
+}
+
+func caTemplate(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil
+}
+
+func encode(der []byte, key *ecdsa.PrivateKey) (string, string, error) {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode private key: %w", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return string(certPEM), string(keyPEM), nil
+}
+
+func parseCertificate(certPEM string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parseKey(keyPEM string) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, errors.New("no PEM encoded key found")
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// certValid reports whether certPEM parses and is valid at t
+func certValid(certPEM string, t time.Time) bool {
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		return false
+	}
+	return t.After(cert.NotBefore) && t.Before(cert.NotAfter)
+}
@@ -0,0 +1,76 @@
+package linkerd
+
+import (
+	"fmt"
+	"time"
+
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// JaegerNamespace is where the linkerd-jaeger extension is installed
+const JaegerNamespace = "linkerd-jaeger"
+
+// JaegerArgs configures the linkerd-jaeger installation
+type JaegerArgs struct {
+	// Chart version, the same as the control plane
+	Version string
+	// OTLP gRPC endpoint (host:port) the proxies send their spans to
+	CollectorEndpoint string
+	// How long to wait for the extension
+	Timeout time.Duration
+}
+
+// Jaeger is the linkerd-jaeger extension, limited to the webhook configuring
+// the tracing of the injected proxies
+type Jaeger struct {
+	pulumi.ResourceState
+
+	// Where the proxies send their spans
+	CollectorEndpoint pulumi.StringOutput `pulumi:"collectorEndpoint"`
+}
+
+// NewJaeger installs the linkerd-jaeger extension with Helm. Its collector
+// and Jaeger UI are left out: the webhook points the proxies injected after
+// it straight at CollectorEndpoint. The control plane must be installed
+// first.
+func NewJaeger(ctx *pulumi.Context, name string, args *JaegerArgs, opts ...pulumi.ResourceOption) (*Jaeger, error) {
+	jaeger := &Jaeger{}
+	err := ctx.RegisterComponentResource("home:linkerd:Jaeger", name, jaeger, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := helmv3.NewRelease(ctx, fmt.Sprintf("%s-release", name), &helmv3.ReleaseArgs{
+		Name:            pulumi.String("linkerd-jaeger"),
+		Chart:           pulumi.String("linkerd-jaeger"),
+		Version:         pulumi.String(args.Version),
+		Namespace:       pulumi.String(JaegerNamespace),
+		CreateNamespace: pulumi.Bool(true),
+		RepositoryOpts:  helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+		Timeout:         pulumi.Int(int(args.Timeout.Seconds())),
+		Values: pulumi.Map{
+			"collector": pulumi.Map{"enabled": pulumi.Bool(false)},
+			"jaeger":    pulumi.Map{"enabled": pulumi.Bool(false)},
+			"webhook": pulumi.Map{
+				"collectorSvcAddr":       pulumi.String(args.CollectorEndpoint),
+				"collectorTraceProtocol": pulumi.String("opentelemetry"),
+			},
+		},
+	}, pulumi.Parent(jaeger))
+	if err != nil {
+		return nil, err
+	}
+
+	jaeger.CollectorEndpoint = release.Status.ApplyT(func(helmv3.ReleaseStatus) string {
+		return args.CollectorEndpoint
+	}).(pulumi.StringOutput)
+	err = ctx.RegisterResourceOutputs(jaeger, pulumi.Map{
+		"collectorEndpoint": jaeger.CollectorEndpoint,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return jaeger, nil
+}
@@ -0,0 +1,253 @@
+package linkerd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/shell"
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// MulticlusterNamespace is where the linkerd-multicluster extension is
+	// installed
+	MulticlusterNamespace = "linkerd-multicluster"
+	// GatewayNodePort and GatewayProbeNodePort expose the gateway on the
+	// nodes when it isn't a LoadBalancer
+	GatewayNodePort      = 30143
+	GatewayProbeNodePort = 30191
+
+	// gatewayService is the Service of the gateway created by the chart
+	gatewayService = "linkerd-gateway"
+	// gatewayPort is the port of the gateway Service of type LoadBalancer
+	gatewayPort = 4143
+)
+
+// MulticlusterArgs configures the linkerd-multicluster installation
+type MulticlusterArgs struct {
+	// Chart version, the same as the control plane
+	Version string
+	// Expose the gateway with a Service of type LoadBalancer, otherwise on
+	// GatewayNodePort of the nodes
+	LoadBalancer bool
+	// Kubeconfig of the cluster (secret), used to read the gateway address
+	Kubeconfig pulumi.StringInput
+	// How long to wait for the extension and the gateway address
+	Timeout time.Duration
+}
+
+// Multicluster is the linkerd-multicluster extension and its gateway
+type Multicluster struct {
+	pulumi.ResourceState
+
+	// Address and port the other clusters reach the gateway on
+	GatewayAddress pulumi.StringOutput `pulumi:"gatewayAddress"`
+	GatewayPort    pulumi.IntOutput    `pulumi:"gatewayPort"`
+}
+
+// NewMulticluster installs the linkerd-multicluster extension with Helm and
+// resolves the address of its gateway. The control plane must be installed
+// first.
+func NewMulticluster(ctx *pulumi.Context, name string, args *MulticlusterArgs, opts ...pulumi.ResourceOption) (*Multicluster, error) {
+	multicluster := &Multicluster{}
+	err := ctx.RegisterComponentResource("home:linkerd:Multicluster", name, multicluster, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	gateway := pulumi.Map{
+		"serviceType": pulumi.String("LoadBalancer"),
+	}
+	port := gatewayPort
+	if !args.LoadBalancer {
+		gateway = pulumi.Map{
+			"serviceType": pulumi.String("NodePort"),
+			"nodePort":    pulumi.Int(GatewayNodePort),
+			"probe":       pulumi.Map{"nodePort": pulumi.Int(GatewayProbeNodePort)},
+		}
+		port = GatewayNodePort
+	}
+
+	release, err := helmv3.NewRelease(ctx, stacks.Name(ctx, "linkerd-multicluster"), &helmv3.ReleaseArgs{
+		Name:            pulumi.String("linkerd-multicluster"),
+		Chart:           pulumi.String("linkerd-multicluster"),
+		Version:         pulumi.String(args.Version),
+		Namespace:       pulumi.String(MulticlusterNamespace),
+		CreateNamespace: pulumi.Bool(true),
+		RepositoryOpts:  helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+		Timeout:         pulumi.Int(int(args.Timeout.Seconds())),
+		Values: pulumi.Map{
+			"gateway": gateway,
+		},
+	}, pulumi.Parent(multicluster))
+	if err != nil {
+		return nil, err
+	}
+
+	multicluster.GatewayAddress = pulumi.All(release.Status, args.Kubeconfig).ApplyT(func(all []interface{}) (string, error) {
+		return gatewayAddress(ctx, multicluster, args, all[1].(string))
+	}).(pulumi.StringOutput)
+	multicluster.GatewayPort = pulumi.Int(port).ToIntOutput()
+	err = ctx.RegisterResourceOutputs(multicluster, pulumi.Map{
+		"gatewayAddress": multicluster.GatewayAddress,
+		"gatewayPort":    multicluster.GatewayPort,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return multicluster, nil
+}
+
+// gatewayAddress returns the load balancer address of the gateway, or the
+// internal IP of a node when it is exposed on a node port
+func gatewayAddress(ctx *pulumi.Context, multicluster *Multicluster, args *MulticlusterArgs, kubeconfig string) (string, error) {
+	if ctx.DryRun() {
+		return "", nil
+	}
+
+	client, err := kube.NewClientsetFromKubeconfig(kubeconfig)
+	if err != nil {
+		return "", err
+	}
+	if !args.LoadBalancer {
+		return kube.NodeInternalIP(context.Background(), client)
+	}
+	return kube.WaitForLoadBalancer(context.Background(), client, MulticlusterNamespace, gatewayService, kube.PollOptions{
+		Timeout: args.Timeout,
+		Logf: func(format string, a ...interface{}) {
+			_ = ctx.Log.Info(fmt.Sprintf(format, a...), &pulumi.LogArgs{Resource: multicluster})
+		},
+	})
+}
+
+// LinkArgs configures the link to a peer cluster
+type LinkArgs struct {
+	// Name of the peer cluster, names the Link and the mirrored Services
+	ClusterName string
+	// Kubeconfig of the peer cluster (secret), read by `linkerd multicluster
+	// link` for the credentials of the service mirror
+	PeerKubeconfig pulumi.StringInput
+	// API server of the peer cluster as reached from this cluster
+	APIServerAddress string
+	// Gateway of the peer cluster
+	GatewayAddress string
+	GatewayPort    int
+	// Labels of the peer Services mirrored into this cluster
+	Selector map[string]string
+	// Kube context of this cluster
+	KubeContext string
+	// Kubeconfig file of `linkerd multicluster check`, empty for the default one
+	KubeconfigPath string
+	// Environment of the linkerd and kubectl commands (KUBE_CONTEXT, KUBECONFIG)
+	Environment pulumi.StringMap
+	// How long `linkerd multicluster check` may take
+	CheckTimeout time.Duration
+}
+
+// Link is the Link to a peer cluster and its service mirror
+type Link struct {
+	pulumi.ResourceState
+
+	// "linked", or the failed checks of the link
+	Status pulumi.StringOutput `pulumi:"status"`
+}
+
+// NewLink applies the output of `linkerd multicluster link` run against the
+// peer cluster: the Link, the service mirror and the credentials it reads
+// the peer with. Deleting it unlinks, which also removes the mirrored
+// Services. The extension must be installed first.
+func NewLink(ctx *pulumi.Context, name string, args *LinkArgs, opts ...pulumi.ResourceOption) (*Link, error) {
+	link := &Link{}
+	err := ctx.RegisterComponentResource("home:linkerd:Link", name, link, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := fmt.Sprintf("--cluster-name %s --api-server-address %s --gateway-addresses %s --gateway-port %d --selector %s",
+		args.ClusterName, args.APIServerAddress, args.GatewayAddress, args.GatewayPort, Selector(args.Selector))
+	// The peer kubeconfig is only written to a file for the duration of the
+	// command, and the manifests are captured so a failed link isn't applied
+	linkCmd := fmt.Sprintf(`peer=$(mktemp)
+trap 'rm -f "$peer"' EXIT
+printf '%%s' "$PEER_KUBECONFIG" > "$peer"
+if ! manifests=$(linkerd multicluster link --kubeconfig "$peer" %[1]s); then
+  echo "linkerd multicluster link failed" >&2
+  exit 1
+fi
+printf '%%s\n' "$manifests" | kubectl --context %[2]s apply -f -`, flags, args.KubeContext)
+
+	environment := pulumi.StringMap{"PEER_KUBECONFIG": args.PeerKubeconfig}
+	for key, value := range args.Environment {
+		environment[key] = value
+	}
+	command, err := local.NewCommand(ctx, fmt.Sprintf("%s-link", name), &local.CommandArgs{
+		Create:      pulumi.String(linkCmd),
+		Update:      pulumi.String(linkCmd),
+		Delete:      pulumi.String(fmt.Sprintf("linkerd multicluster unlink --context %[1]s --cluster-name %[2]s | kubectl --context %[1]s delete --ignore-not-found -f -", args.KubeContext, args.ClusterName)),
+		Environment: environment,
+		Interpreter: shell.Interpreter(),
+	}, pulumi.Parent(link))
+	if err != nil {
+		return nil, err
+	}
+
+	link.Status = command.Stdout.ApplyT(func(string) (string, error) {
+		if ctx.DryRun() {
+			return "", nil
+		}
+		checkCtx, cancel := context.WithTimeout(context.Background(), args.CheckTimeout)
+		defer cancel()
+		checks, err := RunCheck(checkCtx, args.KubeconfigPath, "multicluster", "check", "--context", args.KubeContext, "--wait", args.CheckTimeout.String())
+		if err != nil {
+			return "", err
+		}
+		return linkStatus(checks), nil
+	}).(pulumi.StringOutput)
+	err = ctx.RegisterResourceOutputs(link, pulumi.Map{
+		"status": link.Status,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return link, nil
+}
+
+// Selector formats labels as a label selector, sorted by key
+func Selector(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", key, labels[key]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// linkStatus summarizes the checks of `linkerd multicluster check`. A link
+// whose gateway can't be probed is reported rather than failing the update,
+// the peer may just be down.
+func linkStatus(checks []Check) string {
+	var failed []string
+	for _, check := range checks {
+		if check.AtLeast("error") {
+			failed = append(failed, check.String())
+		}
+	}
+	if len(failed) > 0 {
+		return "unhealthy: " + strings.Join(failed, "; ")
+	}
+	return "linked"
+}
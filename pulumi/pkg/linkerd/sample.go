@@ -0,0 +1,257 @@
+package linkerd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"cluster-studio/pkg/kube"
+
+	appsv1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apps/v1"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	k8scorev1 "k8s.io/api/core/v1"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// SampleNamespace is the injected namespace of the sample workload
+	SampleNamespace = "mesh-sample"
+	// SampleImage is the podinfo image used when none is configured
+	SampleImage = "ghcr.io/stefanprodan/podinfo:6.9.2"
+
+	// sampleName names the Deployment, its Service and its pods
+	sampleName = "podinfo"
+	samplePort = 9898
+	// proxyContainer and proxyAdminPort are the sidecar injected by Linkerd
+	// and the port of its admin server
+	proxyContainer = "linkerd-proxy"
+	proxyAdminPort = "4191"
+)
+
+// SampleArgs configures the sample workload
+type SampleArgs struct {
+	// podinfo image, defaults to SampleImage
+	Image string
+	// Kube context of the cluster, for the port-forward command
+	KubeContext string
+	// Kubeconfig of the cluster (secret), used to inspect the pods
+	Kubeconfig pulumi.StringInput
+	// How long the pod may take to become Ready with its proxy
+	Timeout time.Duration
+}
+
+// Sample is podinfo deployed into an injected namespace, verifying the
+// injection and the proxy
+type Sample struct {
+	pulumi.ResourceState
+
+	// In-cluster URL of podinfo
+	URL pulumi.StringOutput `pulumi:"url"`
+	// kubectl command forwarding podinfo to localhost
+	PortForward pulumi.StringOutput `pulumi:"portForward"`
+	// "meshed by <image of the proxy>" once verified
+	Status pulumi.StringOutput `pulumi:"status"`
+}
+
+// NewSample deploys podinfo into SampleNamespace, annotated for injection,
+// and fails unless its pod becomes Ready with a proxy whose admin server
+// reports ready. The control plane must be installed first.
+func NewSample(ctx *pulumi.Context, name string, args *SampleArgs, opts ...pulumi.ResourceOption) (*Sample, error) {
+	sample := &Sample{}
+	err := ctx.RegisterComponentResource("home:linkerd:Sample", name, sample, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	image := args.Image
+	if image == "" {
+		image = SampleImage
+	}
+	labels := pulumi.StringMap{"app.kubernetes.io/name": pulumi.String(sampleName)}
+
+	namespace, err := corev1.NewNamespace(ctx, fmt.Sprintf("%s-namespace", name), &corev1.NamespaceArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:        pulumi.String(SampleNamespace),
+			Annotations: pulumi.StringMap{"linkerd.io/inject": pulumi.String("enabled")},
+		},
+	}, pulumi.Parent(sample))
+	if err != nil {
+		return nil, err
+	}
+
+	deployment, err := appsv1.NewDeployment(ctx, fmt.Sprintf("%s-podinfo", name), &appsv1.DeploymentArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(sampleName),
+			Namespace: namespace.Metadata.Name(),
+		},
+		Spec: &appsv1.DeploymentSpecArgs{
+			Replicas: pulumi.Int(1),
+			Selector: &metav1.LabelSelectorArgs{MatchLabels: labels},
+			Template: &corev1.PodTemplateSpecArgs{
+				Metadata: &metav1.ObjectMetaArgs{Labels: labels},
+				Spec: &corev1.PodSpecArgs{
+					Containers: corev1.ContainerArray{
+						&corev1.ContainerArgs{
+							Name:    pulumi.String(sampleName),
+							Image:   pulumi.String(image),
+							Command: pulumi.ToStringArray([]string{"./podinfo", fmt.Sprintf("--port=%d", samplePort)}),
+							Resources: &corev1.ResourceRequirementsArgs{
+								Requests: pulumi.StringMap{"memory": pulumi.String("32Mi"), "cpu": pulumi.String("10m")},
+								Limits:   pulumi.StringMap{"memory": pulumi.String("64Mi")},
+							},
+							ReadinessProbe: &corev1.ProbeArgs{
+								HttpGet: &corev1.HTTPGetActionArgs{
+									Path: pulumi.String("/readyz"),
+									Port: pulumi.Int(samplePort),
+								},
+								PeriodSeconds: pulumi.Int(5),
+							},
+							Ports: corev1.ContainerPortArray{
+								&corev1.ContainerPortArgs{
+									Name:          pulumi.String("http"),
+									ContainerPort: pulumi.Int(samplePort),
+									Protocol:      pulumi.String("TCP"),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}, pulumi.Parent(sample))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = corev1.NewService(ctx, fmt.Sprintf("%s-service", name), &corev1.ServiceArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(sampleName),
+			Namespace: namespace.Metadata.Name(),
+		},
+		Spec: &corev1.ServiceSpecArgs{
+			Selector: labels,
+			Ports: corev1.ServicePortArray{
+				&corev1.ServicePortArgs{
+					Name:       pulumi.String("http"),
+					Port:       pulumi.Int(samplePort),
+					TargetPort: pulumi.String("http"),
+				},
+			},
+		},
+	}, pulumi.Parent(sample))
+	if err != nil {
+		return nil, err
+	}
+
+	// The Deployment is only created once its pod is Ready, proxy included
+	sample.Status = pulumi.All(deployment.ID(), args.Kubeconfig).ApplyT(func(all []interface{}) (string, error) {
+		if ctx.DryRun() {
+			return "", nil
+		}
+		client, err := kube.NewClientsetFromKubeconfig(all[1].(string))
+		if err != nil {
+			return "", err
+		}
+		return verifySample(context.Background(), client, kube.PollOptions{
+			Description: fmt.Sprintf("pod of %s/%s to be Ready with %s", SampleNamespace, sampleName, proxyContainer),
+			Interval:    2 * time.Second,
+			Timeout:     args.Timeout,
+			Logf: func(format string, a ...interface{}) {
+				_ = ctx.Log.Info(fmt.Sprintf(format, a...), &pulumi.LogArgs{Resource: sample})
+			},
+		})
+	}).(pulumi.StringOutput)
+	sample.URL = pulumi.Sprintf("http://%s.%s.svc.cluster.local:%d", sampleName, SampleNamespace, samplePort)
+	sample.PortForward = pulumi.Sprintf("kubectl --context %s -n %s port-forward svc/%s %d:%d",
+		args.KubeContext, SampleNamespace, sampleName, samplePort, samplePort)
+	err = ctx.RegisterResourceOutputs(sample, pulumi.Map{
+		"url":         sample.URL,
+		"portForward": sample.PortForward,
+		"status":      sample.Status,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sample, nil
+}
+
+// verifySample waits for a Ready pod of the sample, fails right away when it
+// runs without a proxy, and asks the admin server of the proxy whether it is
+// ready through the API server
+func verifySample(ctx context.Context, client kubernetes.Interface, opts kube.PollOptions) (string, error) {
+	var pod *k8scorev1.Pod
+	err := kube.Poll(ctx, opts, func(ctx context.Context) (bool, string, error) {
+		pods, err := client.CoreV1().Pods(SampleNamespace).List(ctx, k8smetav1.ListOptions{
+			LabelSelector: "app.kubernetes.io/name=" + sampleName,
+		})
+		if err != nil {
+			return false, "", err
+		}
+		var states []string
+		for i := range pods.Items {
+			current := &pods.Items[i]
+			ready, total := readyContainers(current)
+			states = append(states, fmt.Sprintf("%s %d/%d ready", current.Name, ready, total))
+			if current.DeletionTimestamp == nil && ready == total && total > 0 {
+				pod = current
+				return true, "", nil
+			}
+		}
+		if len(states) == 0 {
+			return false, "no pods", nil
+		}
+		return false, strings.Join(states, ", "), nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	proxy := proxyImage(pod)
+	if proxy == "" {
+		return "", fmt.Errorf("pod %s/%s runs without %s: the namespace wasn't injected, is the proxy injector of Linkerd running?",
+			SampleNamespace, pod.Name, proxyContainer)
+	}
+	body, err := client.CoreV1().Pods(SampleNamespace).ProxyGet("http", pod.Name, proxyAdminPort, "/ready", nil).DoRaw(ctx)
+	if err != nil {
+		return "", fmt.Errorf("the %s of pod %s/%s doesn't serve its admin endpoint: %w", proxyContainer, SampleNamespace, pod.Name, err)
+	}
+	if strings.TrimSpace(string(body)) != "ready" {
+		return "", fmt.Errorf("the %s of pod %s/%s isn't ready: %s", proxyContainer, SampleNamespace, pod.Name, strings.TrimSpace(string(body)))
+	}
+	return fmt.Sprintf("meshed by %s", proxy), nil
+}
+
+// readyContainers counts the ready containers of pod, init containers
+// running as sidecars included
+func readyContainers(pod *k8scorev1.Pod) (int, int) {
+	ready, total := 0, 0
+	for _, statuses := range [][]k8scorev1.ContainerStatus{pod.Status.ContainerStatuses, pod.Status.InitContainerStatuses} {
+		for _, status := range statuses {
+			// Sidecar init containers keep running, the others complete
+			if status.State.Terminated != nil && status.State.Terminated.ExitCode == 0 {
+				continue
+			}
+			total++
+			if status.Ready {
+				ready++
+			}
+		}
+	}
+	return ready, total
+}
+
+// proxyImage returns the image of the proxy injected into pod, empty when
+// there is none. Recent proxies run as a native sidecar (an init container).
+func proxyImage(pod *k8scorev1.Pod) string {
+	for _, container := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+		if container.Name == proxyContainer {
+			return container.Image
+		}
+	}
+	return ""
+}
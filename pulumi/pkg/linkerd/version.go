@@ -0,0 +1,82 @@
+package linkerd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// CompareVersions compares two chart versions (e.g. 2025.9.2 or 1.16.11)
+// part by part and returns -1, 0 or 1 when a is older than, the same as or
+// newer than b
+func CompareVersions(a, b string) (int, error) {
+	aParts, err := versionParts(a)
+	if err != nil {
+		return 0, err
+	}
+	bParts, err := versionParts(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var x, y int
+		if i < len(aParts) {
+			x = aParts[i]
+		}
+		if i < len(bParts) {
+			y = bParts[i]
+		}
+		switch {
+		case x < y:
+			return -1, nil
+		case x > y:
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// CLIVersion returns the release of the Linkerd CLI shipping a chart version
+// of the edge channel, e.g. edge-25.9.2 for 2025.9.2, and an empty string for
+// other charts
+func CLIVersion(chartVersion string) string {
+	parts, err := versionParts(chartVersion)
+	if err != nil || len(parts) != 3 || parts[0] < 2000 {
+		return ""
+	}
+	return fmt.Sprintf("edge-%d.%d.%d", parts[0]-2000, parts[1], parts[2])
+}
+
+func versionParts(version string) ([]int, error) {
+	var parts []int
+	for _, field := range strings.Split(strings.TrimPrefix(version, "v"), ".") {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Linkerd version %q", version)
+		}
+		parts = append(parts, n)
+	}
+	return parts, nil
+}
+
+// RunningVersion returns the version the control plane runs, the image tag
+// of the destination controller (e.g. edge-25.9.2)
+func RunningVersion(ctx context.Context, client kubernetes.Interface) (string, error) {
+	deployment, err := client.AppsV1().Deployments(Namespace).Get(ctx, "linkerd-destination", metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to read the Linkerd control plane version: %w", err)
+	}
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		if container.Name != "destination" {
+			continue
+		}
+		if i := strings.LastIndex(container.Image, ":"); i >= 0 {
+			return container.Image[i+1:], nil
+		}
+	}
+	return "", fmt.Errorf("no destination container with a tagged image in %s/linkerd-destination", Namespace)
+}
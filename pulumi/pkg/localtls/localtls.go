@@ -0,0 +1,262 @@
+package localtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"time"
+
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// caOrganization is the organization of generated CAs, how they show up in
+// the trust stores of the devices
+const caOrganization = "home local development CA"
+
+// Certificates are the local CA and the wildcard certificate signed by it
+type Certificates struct {
+	CAPEM    string `json:"caPEM"`
+	CAKeyPEM string `json:"caKeyPEM"`
+	CertPEM  string `json:"certPEM"`
+	KeyPEM   string `json:"keyPEM"`
+}
+
+// Options controls how Ensure reuses or regenerates the certificates
+type Options struct {
+	// Domain suffix the certificate is issued for, as *.Domain and Domain
+	Domain string
+	// Validity of a newly generated CA and certificate
+	CAValidity   time.Duration
+	CertValidity time.Duration
+	// Certificates expiring within this window are renewed
+	RenewBefore time.Duration
+}
+
+// Ensure returns the certificates to install. The CA of previous is kept while
+// it is outside the renewal window, so the devices trusting it keep doing so.
+// The certificate is renewed when it is about to expire, was issued for
+// another domain or by another CA.
+func Ensure(previous *Certificates, opts Options) (*Certificates, error) {
+	renewAt := time.Now().Add(opts.RenewBefore)
+	certs := &Certificates{}
+
+	if previous != nil && validAt(previous.CAPEM, renewAt) && previous.CAKeyPEM != "" {
+		certs.CAPEM = previous.CAPEM
+		certs.CAKeyPEM = previous.CAKeyPEM
+	} else {
+		caPEM, caKeyPEM, err := generateCA(opts.CAValidity)
+		if err != nil {
+			return nil, err
+		}
+		certs.CAPEM = caPEM
+		certs.CAKeyPEM = caKeyPEM
+	}
+
+	caKept := previous != nil && certs.CAPEM == previous.CAPEM
+	if caKept && validAt(previous.CertPEM, renewAt) && covers(previous.CertPEM, opts.Domain) && previous.KeyPEM != "" {
+		certs.CertPEM = previous.CertPEM
+		certs.KeyPEM = previous.KeyPEM
+		return certs, nil
+	}
+
+	certPEM, keyPEM, err := generateCertificate(certs.CAPEM, certs.CAKeyPEM, opts.Domain, opts.CertValidity)
+	if err != nil {
+		return nil, err
+	}
+	certs.CertPEM = certPEM
+	certs.KeyPEM = keyPEM
+
+	return certs, nil
+}
+
+// NotAfter returns when certPEM expires
+func NotAfter(certPEM string) (time.Time, error) {
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
+
+// generateCA creates a self-signed CA allowed to sign server certificates only
+func generateCA(validity time.Duration) (string, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate CA key: %w", err)
+	}
+
+	template, err := newTemplate(validity)
+	if err != nil {
+		return "", "", err
+	}
+	template.Subject = pkix.Name{Organization: []string{caOrganization}, CommonName: caOrganization}
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	template.BasicConstraintsValid = true
+	template.IsCA = true
+	template.MaxPathLenZero = true
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+
+	return encode(der, key)
+}
+
+// generateCertificate creates a server certificate for *.domain and domain
+// signed by the CA
+func generateCertificate(caPEM, caKeyPEM, domain string, validity time.Duration) (string, string, error) {
+	caCert, err := parseCertificate(caPEM)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid CA certificate: %w", err)
+	}
+	caKey, err := parseKey(caKeyPEM)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid CA key: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate certificate key: %w", err)
+	}
+
+	template, err := newTemplate(validity)
+	if err != nil {
+		return "", "", err
+	}
+	template.Subject = pkix.Name{Organization: []string{caOrganization}, CommonName: "*." + domain}
+	template.DNSNames = []string{"*." + domain, domain}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	return encode(der, key)
+}
+
+func newTemplate(validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+func encode(der []byte, key *ecdsa.PrivateKey) (string, string, error) {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode private key: %w", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return string(certPEM), string(keyPEM), nil
+}
+
+func parseCertificate(certPEM string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parseKey(keyPEM string) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, errors.New("no PEM encoded key found")
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// validAt reports whether certPEM parses and is still valid at t
+func validAt(certPEM string, t time.Time) bool {
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		return false
+	}
+	return t.Before(cert.NotAfter)
+}
+
+// covers reports whether certPEM is issued for *.domain and domain
+func covers(certPEM, domain string) bool {
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(cert.DNSNames, "*."+domain) && slices.Contains(cert.DNSNames, domain)
+}
+
+// DefaultCertificateArgs configures the TLS Secret of the certificate
+type DefaultCertificateArgs struct {
+	Certificates *Certificates
+	// Secret created, in the namespace of the ingress controller
+	Namespace string
+	Name      string
+}
+
+// DefaultCertificate is the wildcard certificate stored as a TLS Secret
+type DefaultCertificate struct {
+	pulumi.ResourceState
+
+	// namespace/name of the Secret, for --default-ssl-certificate
+	Secret pulumi.StringOutput `pulumi:"secret"`
+	// PEM of the CA to trust on the devices
+	CAPEM pulumi.StringOutput `pulumi:"caPem"`
+}
+
+// NewDefaultCertificate stores the certificate in a TLS Secret, with the CA
+// under ca.crt
+func NewDefaultCertificate(ctx *pulumi.Context, name string, args *DefaultCertificateArgs, opts ...pulumi.ResourceOption) (*DefaultCertificate, error) {
+	certificate := &DefaultCertificate{}
+	err := ctx.RegisterComponentResource("home:localtls:DefaultCertificate", name, certificate, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := corev1.NewSecret(ctx, fmt.Sprintf("%s-secret", name), &corev1.SecretArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(args.Name),
+			Namespace: pulumi.String(args.Namespace),
+		},
+		Type: pulumi.String("kubernetes.io/tls"),
+		StringData: pulumi.StringMap{
+			"tls.crt": pulumi.String(args.Certificates.CertPEM),
+			"tls.key": pulumi.ToSecret(pulumi.String(args.Certificates.KeyPEM)).(pulumi.StringOutput),
+			"ca.crt":  pulumi.String(args.Certificates.CAPEM),
+		},
+	}, pulumi.Parent(certificate))
+	if err != nil {
+		return nil, err
+	}
+
+	certificate.Secret = pulumi.Sprintf("%s/%s", secret.Metadata.Namespace().Elem(), secret.Metadata.Name().Elem())
+	certificate.CAPEM = pulumi.String(args.Certificates.CAPEM).ToStringOutput()
+	err = ctx.RegisterResourceOutputs(certificate, pulumi.Map{
+		"secret": certificate.Secret,
+		"caPem":  certificate.CAPEM,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return certificate, nil
+}
@@ -0,0 +1,286 @@
+package logging
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"cluster-studio/pkg/monitoring"
+	"cluster-studio/pkg/stacks"
+
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// ChartRepo is the Helm repository serving the loki and promtail charts
+	ChartRepo = "https://grafana.github.io/helm-charts"
+	// Namespace is where Loki and Promtail are installed, the one of the loki
+	// component of the infrastructure tree
+	Namespace = "loki"
+	// LokiReleaseName matches the HelmRelease of the infrastructure tree
+	LokiReleaseName = "loki"
+	// PromtailReleaseName is the release shipping the pod logs to Loki
+	PromtailReleaseName = "promtail"
+	// LokiURL is the in-cluster URL of Loki
+	LokiURL = "http://" + LokiReleaseName + "." + Namespace + ".svc.cluster.local:3100"
+	// PushPath is the Loki API path log shippers push to
+	PushPath = "/loki/api/v1/push"
+)
+
+// StackArgs configures the Loki and Promtail installation
+type StackArgs struct {
+	// Chart versions
+	LokiVersion     string
+	PromtailVersion string
+	// How long Loki keeps logs (e.g. "7d")
+	Retention string
+	// Size of the Loki volume (e.g. "10Gi")
+	StorageSize string
+	// Store the chunks and index on a persistent volume, otherwise on an
+	// emptyDir volume lost with the pod
+	Persistence bool
+	// Expose Loki on this node port so other clusters can push to it, 0 keeps
+	// it ClusterIP
+	NodePort int
+	// Provision a Loki datasource for the Grafana of the monitoring stack
+	GrafanaDatasource bool
+	// Loki of another cluster Promtail also ships the logs to (optional)
+	PeerPushURL pulumi.StringInput
+	// S3 bucket Loki keeps the chunks and index in instead of the filesystem
+	// (optional)
+	ObjectStorage *ObjectStorage
+	// How long to wait for each release to be ready
+	Timeout time.Duration
+}
+
+// ObjectStorage is an S3 bucket, e.g. one of the in-cluster MinIO
+type ObjectStorage struct {
+	// URL of the S3 API (e.g. http://minio.minio.svc.cluster.local:9000)
+	Endpoint pulumi.StringInput
+	Bucket   string
+	// Credentials (secrets)
+	AccessKey pulumi.StringInput
+	SecretKey pulumi.StringInput
+}
+
+// Stack is Loki in single-binary mode with Promtail shipping the logs of
+// every pod to it
+type Stack struct {
+	pulumi.ResourceState
+
+	// In-cluster URL of the Loki API
+	URL pulumi.StringOutput `pulumi:"url"`
+	// In-cluster URL log shippers push to
+	PushURL pulumi.StringOutput `pulumi:"pushUrl"`
+}
+
+// NewStack installs Loki and Promtail with Helm
+func NewStack(ctx *pulumi.Context, name string, args *StackArgs, opts ...pulumi.ResourceOption) (*Stack, error) {
+	stack := &Stack{}
+	err := ctx.RegisterComponentResource("home:logging:Stack", name, stack, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace, err := corev1.NewNamespace(ctx, fmt.Sprintf("%s-namespace", name), &corev1.NamespaceArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name: pulumi.String(Namespace),
+		},
+	}, pulumi.Parent(stack))
+	if err != nil {
+		return nil, err
+	}
+
+	url := LokiURL
+	loki, err := helmv3.NewRelease(ctx, stacks.Name(ctx, LokiReleaseName), &helmv3.ReleaseArgs{
+		Name:           pulumi.String(LokiReleaseName),
+		Chart:          pulumi.String("loki"),
+		Version:        pulumi.String(args.LokiVersion),
+		Namespace:      namespace.Metadata.Name(),
+		RepositoryOpts: helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+		Timeout:        pulumi.Int(int(args.Timeout.Seconds())),
+		Values:         lokiValues(args),
+	}, pulumi.Parent(stack))
+	if err != nil {
+		return nil, err
+	}
+
+	clients := pulumi.Array{
+		pulumi.Map{"url": pulumi.String(url + PushPath)},
+	}
+	if args.PeerPushURL != nil {
+		clients = append(clients, pulumi.Map{"url": args.PeerPushURL})
+	}
+	_, err = helmv3.NewRelease(ctx, stacks.Name(ctx, PromtailReleaseName), &helmv3.ReleaseArgs{
+		Name:           pulumi.String(PromtailReleaseName),
+		Chart:          pulumi.String("promtail"),
+		Version:        pulumi.String(args.PromtailVersion),
+		Namespace:      namespace.Metadata.Name(),
+		RepositoryOpts: helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+		Timeout:        pulumi.Int(int(args.Timeout.Seconds())),
+		Values: pulumi.Map{
+			"config": pulumi.Map{
+				"clients": clients,
+			},
+		},
+	}, pulumi.Parent(stack), pulumi.DependsOn([]pulumi.Resource{loki}))
+	if err != nil {
+		return nil, err
+	}
+
+	// The chart Service stays ClusterIP, a NodePort one next to it lets other
+	// clusters push through any node
+	if args.NodePort != 0 {
+		_, err = corev1.NewService(ctx, fmt.Sprintf("%s-push", name), &corev1.ServiceArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:      pulumi.String("loki-push"),
+				Namespace: namespace.Metadata.Name(),
+			},
+			Spec: &corev1.ServiceSpecArgs{
+				Type: pulumi.String("NodePort"),
+				Selector: pulumi.StringMap{
+					"app.kubernetes.io/name":      pulumi.String("loki"),
+					"app.kubernetes.io/instance":  pulumi.String(LokiReleaseName),
+					"app.kubernetes.io/component": pulumi.String("single-binary"),
+				},
+				Ports: corev1.ServicePortArray{
+					corev1.ServicePortArgs{
+						Name:       pulumi.String("http-metrics"),
+						Port:       pulumi.Int(3100),
+						TargetPort: pulumi.String("http-metrics"),
+						NodePort:   pulumi.Int(args.NodePort),
+					},
+				},
+			},
+		}, pulumi.Parent(stack), pulumi.DependsOn([]pulumi.Resource{loki}))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The Grafana sidecar of kube-prometheus-stack loads the datasources of
+	// the ConfigMaps labelled grafana_datasource in its namespace
+	if args.GrafanaDatasource {
+		_, err = corev1.NewConfigMap(ctx, fmt.Sprintf("%s-grafana-datasource", name), &corev1.ConfigMapArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:      pulumi.String("loki-datasource"),
+				Namespace: pulumi.String(monitoring.Namespace),
+				Labels:    pulumi.StringMap{"grafana_datasource": pulumi.String("1")},
+			},
+			Data: pulumi.StringMap{
+				"loki.yaml": pulumi.String(fmt.Sprintf(`apiVersion: 1
+datasources:
+  - name: Loki
+    type: loki
+    uid: loki
+    access: proxy
+    url: %s
+`, url)),
+			},
+		}, pulumi.Parent(stack), pulumi.DependsOn([]pulumi.Resource{loki}))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stack.URL = pulumi.String(url).ToStringOutput()
+	stack.PushURL = pulumi.String(url + PushPath).ToStringOutput()
+	err = ctx.RegisterResourceOutputs(stack, pulumi.Map{
+		"url":     stack.URL,
+		"pushUrl": stack.PushURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stack, nil
+}
+
+// lokiValues assembles the values of the loki chart in single-binary mode,
+// the only mode a single Kind node can run. The chunks and index go to the
+// object storage when set, otherwise to the filesystem, an emptyDir without
+// persistence.
+func lokiValues(args *StackArgs) pulumi.Map {
+	persistence := pulumi.Map{"enabled": pulumi.Bool(false)}
+	if args.Persistence {
+		persistence = pulumi.Map{
+			"enabled": pulumi.Bool(true),
+			"size":    pulumi.String(args.StorageSize),
+		}
+	}
+
+	store := "filesystem"
+	storage := pulumi.Map{"type": pulumi.String("filesystem")}
+	if s3 := args.ObjectStorage; s3 != nil {
+		store = "s3"
+		// Loki takes the host and port, and whether to use plain HTTP
+		endpoint := s3.Endpoint.ToStringOutput()
+		storage = pulumi.Map{
+			"type": pulumi.String("s3"),
+			"bucketNames": pulumi.Map{
+				"chunks": pulumi.String(s3.Bucket),
+				"ruler":  pulumi.String(s3.Bucket),
+				"admin":  pulumi.String(s3.Bucket),
+			},
+			"s3": pulumi.Map{
+				"endpoint": endpoint.ApplyT(func(endpoint string) string {
+					return strings.TrimPrefix(strings.TrimPrefix(endpoint, "https://"), "http://")
+				}),
+				"accessKeyId":      s3.AccessKey,
+				"secretAccessKey":  s3.SecretKey,
+				"s3ForcePathStyle": pulumi.Bool(true),
+				"insecure": endpoint.ApplyT(func(endpoint string) bool {
+					return strings.HasPrefix(endpoint, "http://")
+				}),
+			},
+		}
+	}
+
+	return pulumi.Map{
+		"deploymentMode": pulumi.String("SingleBinary"),
+		"loki": pulumi.Map{
+			"auth_enabled": pulumi.Bool(false),
+			"commonConfig": pulumi.Map{"replication_factor": pulumi.Int(1)},
+			"storage":      storage,
+			"schemaConfig": pulumi.Map{
+				"configs": pulumi.Array{
+					pulumi.Map{
+						"from":         pulumi.String("2024-04-01"),
+						"store":        pulumi.String("tsdb"),
+						"object_store": pulumi.String(store),
+						"schema":       pulumi.String("v13"),
+						"index": pulumi.Map{
+							"prefix": pulumi.String("index_"),
+							"period": pulumi.String("24h"),
+						},
+					},
+				},
+			},
+			"limits_config": pulumi.Map{
+				"retention_period": pulumi.String(args.Retention),
+			},
+			// The compactor is what deletes the logs past the retention period
+			"compactor": pulumi.Map{
+				"retention_enabled":    pulumi.Bool(true),
+				"delete_request_store": pulumi.String(store),
+			},
+		},
+		"singleBinary": pulumi.Map{
+			"replicas":    pulumi.Int(1),
+			"persistence": persistence,
+		},
+		// The scalable targets and caches are for object storage setups
+		"read":         pulumi.Map{"replicas": pulumi.Int(0)},
+		"write":        pulumi.Map{"replicas": pulumi.Int(0)},
+		"backend":      pulumi.Map{"replicas": pulumi.Int(0)},
+		"chunksCache":  pulumi.Map{"enabled": pulumi.Bool(false)},
+		"resultsCache": pulumi.Map{"enabled": pulumi.Bool(false)},
+		"gateway":      pulumi.Map{"enabled": pulumi.Bool(false)},
+		"lokiCanary":   pulumi.Map{"enabled": pulumi.Bool(false)},
+		"test":         pulumi.Map{"enabled": pulumi.Bool(false)},
+		"minio":        pulumi.Map{"enabled": pulumi.Bool(false)},
+	}
+}
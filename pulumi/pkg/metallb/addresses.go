@@ -0,0 +1,125 @@
+package metallb
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os/exec"
+	"strings"
+)
+
+// inspectFormat prints the subnets of a Docker network separated by spaces
+const inspectFormat = "{{range .IPAM.Config}}{{.Subnet}} {{end}}"
+
+// InspectNetwork returns the subnets of a Docker network as a whitespace
+// separated list, which fails until the network exists
+func InspectNetwork(network string) (string, error) {
+	out, err := exec.Command("docker", "network", "inspect", network, "-f", inspectFormat).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("docker network inspect %s: %w: %s", network, err, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// RangesOutside returns the address ranges (CIDRs or start-end ranges) not
+// entirely inside one of the subnets, a whitespace separated list as printed
+// by docker network inspect
+func RangesOutside(ranges []string, subnets string) ([]string, error) {
+	var prefixes []netip.Prefix
+	for _, subnet := range strings.Fields(subnets) {
+		prefix, err := netip.ParsePrefix(subnet)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet %q: %w", subnet, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	var outside []string
+	for _, addresses := range ranges {
+		first, last, err := ParseRange(addresses)
+		if err != nil {
+			return nil, err
+		}
+		inside := false
+		for _, prefix := range prefixes {
+			if prefix.Contains(first) && prefix.Contains(last) {
+				inside = true
+			}
+		}
+		if !inside {
+			outside = append(outside, addresses)
+		}
+	}
+	return outside, nil
+}
+
+// ParseRange returns the first and last address of a CIDR or of a start-end
+// range of a single IP family
+func ParseRange(addresses string) (netip.Addr, netip.Addr, error) {
+	if strings.Contains(addresses, "/") {
+		prefix, err := netip.ParsePrefix(addresses)
+		if err != nil {
+			return netip.Addr{}, netip.Addr{}, err
+		}
+		prefix = prefix.Masked()
+		last := prefix.Addr().AsSlice()
+		for bit := prefix.Bits(); bit < len(last)*8; bit++ {
+			last[bit/8] |= 1 << (7 - bit%8)
+		}
+		end, _ := netip.AddrFromSlice(last)
+		return prefix.Addr(), end, nil
+	}
+
+	start, end, ok := strings.Cut(addresses, "-")
+	if !ok {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("use a CIDR or a start-end range")
+	}
+	first, err := netip.ParseAddr(strings.TrimSpace(start))
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, err
+	}
+	last, err := netip.ParseAddr(strings.TrimSpace(end))
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, err
+	}
+	if first.Is4() != last.Is4() || last.Less(first) {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("%s is not after %s in the same IP family", last, first)
+	}
+	return first, last, nil
+}
+
+// RangeFromSubnets returns an address range near the end of the first IPv4
+// subnet in a whitespace separated list (as printed by docker network
+// inspect), e.g. 172.18.255.200-172.18.255.250 for 172.18.0.0/16. Docker
+// hands out container addresses from the start of the subnet, so the end is
+// free for load balancers.
+func RangeFromSubnets(subnets string) (string, error) {
+	for _, subnet := range strings.Fields(subnets) {
+		_, network, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return "", fmt.Errorf("invalid subnet %q: %w", subnet, err)
+		}
+		ip := network.IP.To4()
+		if ip == nil {
+			continue
+		}
+		ones, bits := network.Mask.Size()
+		if bits-ones < 8 {
+			return "", fmt.Errorf("subnet %s is too small, set the range explicitly", subnet)
+		}
+
+		// Broadcast address of the subnet
+		last := make(net.IP, len(ip))
+		for i := range ip {
+			last[i] = ip[i] | ^network.Mask[i]
+		}
+		return fmt.Sprintf("%s-%s", offset(last, -55), offset(last, -5)), nil
+	}
+	return "", fmt.Errorf("no IPv4 subnet in %q", strings.TrimSpace(subnets))
+}
+
+// offset adds delta to an IPv4 address
+func offset(ip net.IP, delta int) net.IP {
+	n := int(ip[0])<<24 | int(ip[1])<<16 | int(ip[2])<<8 | int(ip[3])
+	n += delta
+	return net.IPv4(byte(n>>24), byte(n>>16), byte(n>>8), byte(n)).To4()
+}
@@ -0,0 +1,184 @@
+package metallb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/shell"
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// ChartRepo is the Helm repository serving the MetalLB chart
+const ChartRepo = "https://metallb.github.io/metallb"
+
+// Namespace is where MetalLB is installed
+const Namespace = "metallb-system"
+
+// crds must be Established before the pool can be created
+var crds = []string{
+	"ipaddresspools.metallb.io",
+	"l2advertisements.metallb.io",
+}
+
+// InstallArgs configures the MetalLB installation
+type InstallArgs struct {
+	// Chart version
+	Version string
+	// Address ranges of the pool (e.g. 172.18.255.200-172.18.255.250). When
+	// empty a range is taken from the end of DockerNetwork's IPv4 subnet.
+	Addresses []string
+	// Docker network of the cluster nodes (e.g. kind), the Addresses must be
+	// inside it (optional with Addresses)
+	DockerNetwork string
+	// Kubeconfig of the cluster (secret), used to wait for the CRDs
+	Kubeconfig pulumi.StringInput
+	// How long to wait for the controller and the CRDs
+	Timeout time.Duration
+}
+
+// Install is MetalLB in L2 mode with a single address pool
+type Install struct {
+	pulumi.ResourceState
+
+	// Address ranges of the configured pool
+	Addresses pulumi.StringArrayOutput `pulumi:"addresses"`
+}
+
+// NewInstall installs MetalLB with Helm, waits for its CRDs to be Established
+// and creates the IPAddressPool and L2Advertisement
+func NewInstall(ctx *pulumi.Context, name string, args *InstallArgs, opts ...pulumi.ResourceOption) (*Install, error) {
+	install := &Install{}
+	err := ctx.RegisterComponentResource("home:metallb:Install", name, install, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := pulumi.ToStringArray(args.Addresses).ToStringArrayOutput()
+	if len(args.Addresses) == 0 && args.DockerNetwork == "" {
+		return nil, fmt.Errorf("MetalLB needs either address ranges or the Docker network to take them from")
+	}
+	if args.DockerNetwork != "" {
+		// The network only exists once the cluster does, so inspect it at
+		// apply time: the pool is taken from it, or the given ranges must be
+		// inside it
+		network, err := local.NewCommand(ctx, fmt.Sprintf("%s-network", name), &local.CommandArgs{
+			Create:      pulumi.String(fmt.Sprintf(`docker network inspect %s -f '%s'`, args.DockerNetwork, inspectFormat)),
+			Interpreter: shell.Interpreter(),
+		}, pulumi.Parent(install))
+		if err != nil {
+			return nil, err
+		}
+		addresses = network.Stdout.ApplyT(func(subnets string) ([]string, error) {
+			if len(args.Addresses) > 0 {
+				// Nothing is inspected in a preview
+				if ctx.DryRun() {
+					return args.Addresses, nil
+				}
+				outside, err := RangesOutside(args.Addresses, subnets)
+				if err != nil {
+					return nil, err
+				}
+				if len(outside) > 0 {
+					return nil, fmt.Errorf("MetalLB ranges %s are outside Docker network %s (%s)",
+						strings.Join(outside, ", "), args.DockerNetwork, strings.TrimSpace(subnets))
+				}
+				return args.Addresses, nil
+			}
+			addressRange, err := RangeFromSubnets(subnets)
+			if err != nil {
+				return nil, fmt.Errorf("failed to derive a MetalLB range from Docker network %s: %w", args.DockerNetwork, err)
+			}
+			return []string{addressRange}, nil
+		}).(pulumi.StringArrayOutput)
+	}
+
+	timeout := pulumi.Int(int(args.Timeout.Seconds()))
+	release, err := helmv3.NewRelease(ctx, stacks.Name(ctx, "metallb"), &helmv3.ReleaseArgs{
+		Name:            pulumi.String("metallb"),
+		Chart:           pulumi.String("metallb"),
+		Version:         pulumi.String(args.Version),
+		Namespace:       pulumi.String(Namespace),
+		CreateNamespace: pulumi.Bool(true),
+		RepositoryOpts:  helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+		Timeout:         timeout,
+	}, pulumi.Parent(install))
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolves to the release once its CRDs are Established
+	established := pulumi.All(release.Status, args.Kubeconfig).ApplyT(func(values []interface{}) ([]pulumi.Resource, error) {
+		if ctx.DryRun() {
+			return []pulumi.Resource{release}, nil
+		}
+		client, err := kube.NewDynamicClientFromKubeconfig(values[1].(string))
+		if err != nil {
+			return nil, err
+		}
+		err = kube.WaitForCRDsEstablished(context.Background(), client, crds, kube.PollOptions{
+			Description: "MetalLB CRDs to become Established",
+			Timeout:     args.Timeout,
+			Logf: func(format string, a ...interface{}) {
+				_ = ctx.Log.Info(fmt.Sprintf(format, a...), &pulumi.LogArgs{Resource: install})
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []pulumi.Resource{release}, nil
+	}).(pulumi.ResourceArrayOutput)
+
+	pool, err := apiextensions.NewCustomResource(ctx, stacks.Name(ctx, "metallb-pool"), &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String("metallb.io/v1beta1"),
+		Kind:       pulumi.String("IPAddressPool"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String("default"),
+			Namespace: pulumi.String(Namespace),
+		},
+		OtherFields: kubernetes.UntypedArgs{
+			"spec": pulumi.Map{
+				"addresses": addresses,
+			},
+		},
+	}, pulumi.Parent(install), pulumi.DependsOnInputs(established))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = apiextensions.NewCustomResource(ctx, stacks.Name(ctx, "metallb-l2"), &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String("metallb.io/v1beta1"),
+		Kind:       pulumi.String("L2Advertisement"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String("default"),
+			Namespace: pulumi.String(Namespace),
+		},
+		OtherFields: kubernetes.UntypedArgs{
+			"spec": pulumi.Map{
+				"ipAddressPools": pulumi.StringArray{pulumi.String("default")},
+			},
+		},
+	}, pulumi.Parent(install), pulumi.DependsOn([]pulumi.Resource{pool}))
+	if err != nil {
+		return nil, err
+	}
+
+	install.Addresses = addresses
+	err = ctx.RegisterResourceOutputs(install, pulumi.Map{
+		"addresses": install.Addresses,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return install, nil
+}
@@ -0,0 +1,126 @@
+package metricsserver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/stacks"
+
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// ChartRepo is the Helm repository serving the metrics-server chart
+	ChartRepo = "https://kubernetes-sigs.github.io/metrics-server/"
+	// Namespace is where metrics-server is installed, the namespace of the
+	// infrastructure component it replaces
+	Namespace = "metrics-server"
+	// APIService is the APIService metrics-server registers
+	APIService = "v1beta1.metrics.k8s.io"
+)
+
+// InstallArgs configures metrics-server
+type InstallArgs struct {
+	// Chart version
+	Version string
+	// Skip the verification of the kubelet serving certificates, which are
+	// self-signed on Kind
+	InsecureTLS bool
+	// Kubeconfig of the cluster (secret), used to check the metrics API
+	Kubeconfig pulumi.StringInput
+	// How long to wait for the release and the metrics API
+	Timeout time.Duration
+}
+
+// Install is metrics-server serving the metrics API
+type Install struct {
+	pulumi.ResourceState
+
+	// Whether the metrics API reported Available
+	Available pulumi.BoolOutput `pulumi:"available"`
+}
+
+// NewInstall installs metrics-server with Helm and fails unless the metrics
+// API becomes Available. Its status is recorded in a ConfigMap of
+// kube-system, so HorizontalPodAutoscalers applied by resources depending on
+// the component find metrics.
+func NewInstall(ctx *pulumi.Context, name string, args *InstallArgs, opts ...pulumi.ResourceOption) (*Install, error) {
+	install := &Install{}
+	err := ctx.RegisterComponentResource("home:metricsserver:Install", name, install, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	serverArgs := pulumi.StringArray{
+		pulumi.String("--kubelet-preferred-address-types=InternalIP,ExternalIP,Hostname"),
+		pulumi.String("--kubelet-use-node-status-port"),
+		pulumi.String("--metric-resolution=15s"),
+	}
+	if args.InsecureTLS {
+		serverArgs = append(serverArgs, pulumi.String("--kubelet-insecure-tls"))
+	}
+	release, err := helmv3.NewRelease(ctx, stacks.Name(ctx, "metrics-server"), &helmv3.ReleaseArgs{
+		Name:            pulumi.String("metrics-server"),
+		Chart:           pulumi.String("metrics-server"),
+		Version:         pulumi.String(args.Version),
+		Namespace:       pulumi.String(Namespace),
+		CreateNamespace: pulumi.Bool(true),
+		RepositoryOpts:  helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+		Timeout:         pulumi.Int(int(args.Timeout.Seconds())),
+		Values: pulumi.Map{
+			"args": serverArgs,
+		},
+	}, pulumi.Parent(install))
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolves once the metrics API is Available
+	available := pulumi.All(release.ID(), args.Kubeconfig).ApplyT(func(values []interface{}) (bool, error) {
+		if ctx.DryRun() {
+			return false, nil
+		}
+		client, err := kube.NewDynamicClientFromKubeconfig(values[1].(string))
+		if err != nil {
+			return false, err
+		}
+		err = kube.WaitForAPIService(context.Background(), client, APIService, kube.PollOptions{
+			Timeout: args.Timeout,
+			Logf: func(format string, a ...interface{}) {
+				_ = ctx.Log.Info(fmt.Sprintf(format, a...), &pulumi.LogArgs{Resource: install})
+			},
+		})
+		if err != nil {
+			return false, fmt.Errorf("the metrics API is not Available, check the metrics-server logs: %w", err)
+		}
+		return true, nil
+	}).(pulumi.BoolOutput)
+
+	_, err = corev1.NewConfigMap(ctx, fmt.Sprintf("%s-report", name), &corev1.ConfigMapArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(name),
+			Namespace: pulumi.String("kube-system"),
+		},
+		Data: pulumi.StringMap{
+			"available": pulumi.Sprintf("%t", available),
+		},
+	}, pulumi.Parent(install))
+	if err != nil {
+		return nil, err
+	}
+
+	install.Available = available
+	err = ctx.RegisterResourceOutputs(install, pulumi.Map{
+		"available": install.Available,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return install, nil
+}
@@ -0,0 +1,152 @@
+package minio
+
+import (
+	"fmt"
+	"time"
+
+	"cluster-studio/pkg/credentials"
+	"cluster-studio/pkg/stacks"
+
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi-random/sdk/v4/go/random"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// ChartRepo is the Helm repository serving the MinIO chart
+	ChartRepo = "https://charts.min.io/"
+	// Namespace is where MinIO is installed
+	Namespace = "minio"
+	// ReleaseName is also the name of the S3 Service
+	ReleaseName = "minio"
+	// Host is the in-cluster address of the S3 API
+	Host = ReleaseName + "." + Namespace + ".svc.cluster.local:9000"
+)
+
+// InstallArgs configures the MinIO installation
+type InstallArgs struct {
+	// Chart version
+	Version string
+	// Buckets created once MinIO is up
+	Buckets []string
+	// Size of the data volume (e.g. "20Gi")
+	StorageSize string
+	// Bumping it generates the root password again
+	Rotate int
+	// How long to wait for the release and the bucket Job
+	Timeout time.Duration
+}
+
+// Install is a single MinIO server with generated root credentials
+type Install struct {
+	pulumi.ResourceState
+
+	// In-cluster URL of the S3 API
+	Endpoint pulumi.StringOutput `pulumi:"endpoint"`
+	// Root credentials, both secrets
+	AccessKey pulumi.StringOutput `pulumi:"accessKey"`
+	SecretKey pulumi.StringOutput `pulumi:"secretKey"`
+	// Buckets created
+	Buckets pulumi.StringArrayOutput `pulumi:"buckets"`
+}
+
+// NewInstall installs MinIO in standalone mode with Helm. The root
+// credentials are generated once and kept in a Secret, and the chart's
+// post-install Job creates the buckets, keeping their data when they are
+// removed from the list.
+func NewInstall(ctx *pulumi.Context, name string, args *InstallArgs, opts ...pulumi.ResourceOption) (*Install, error) {
+	install := &Install{}
+	err := ctx.RegisterComponentResource("home:minio:Install", name, install, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace, err := corev1.NewNamespace(ctx, fmt.Sprintf("%s-namespace", name), &corev1.NamespaceArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name: pulumi.String(Namespace),
+		},
+	}, pulumi.Parent(install))
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := random.NewRandomString(ctx, fmt.Sprintf("%s-root-user", name), &random.RandomStringArgs{
+		Length:  pulumi.Int(16),
+		Special: pulumi.Bool(false),
+		Upper:   pulumi.Bool(false),
+	}, pulumi.Parent(install))
+	if err != nil {
+		return nil, err
+	}
+	password, err := credentials.NewPassword(ctx, fmt.Sprintf("%s-root-password", name), 32, args.Rotate, pulumi.Parent(install))
+	if err != nil {
+		return nil, err
+	}
+	accessKey := pulumi.ToSecret(user.Result).(pulumi.StringOutput)
+
+	secret, err := corev1.NewSecret(ctx, fmt.Sprintf("%s-root", name), &corev1.SecretArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String("minio-root"),
+			Namespace: namespace.Metadata.Name(),
+		},
+		StringData: pulumi.StringMap{
+			"rootUser":     accessKey,
+			"rootPassword": password.Result,
+		},
+	}, pulumi.Parent(install))
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := pulumi.Array{}
+	for _, bucket := range args.Buckets {
+		buckets = append(buckets, pulumi.Map{
+			"name":   pulumi.String(bucket),
+			"policy": pulumi.String("none"),
+			"purge":  pulumi.Bool(false),
+		})
+	}
+	_, err = helmv3.NewRelease(ctx, stacks.Name(ctx, ReleaseName), &helmv3.ReleaseArgs{
+		Name:           pulumi.String(ReleaseName),
+		Chart:          pulumi.String("minio"),
+		Version:        pulumi.String(args.Version),
+		Namespace:      namespace.Metadata.Name(),
+		RepositoryOpts: helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+		Timeout:        pulumi.Int(int(args.Timeout.Seconds())),
+		Values: pulumi.Map{
+			"mode":           pulumi.String("standalone"),
+			"replicas":       pulumi.Int(1),
+			"existingSecret": secret.Metadata.Name().Elem(),
+			"persistence": pulumi.Map{
+				"enabled": pulumi.Bool(true),
+				"size":    pulumi.String(args.StorageSize),
+			},
+			// The chart requests 16Gi of memory by default
+			"resources": pulumi.Map{
+				"requests": pulumi.Map{"memory": pulumi.String("512Mi")},
+			},
+			"buckets": buckets,
+		},
+	}, pulumi.Parent(install))
+	if err != nil {
+		return nil, err
+	}
+
+	install.Endpoint = pulumi.String("http://" + Host).ToStringOutput()
+	install.AccessKey = accessKey
+	install.SecretKey = password.Result
+	install.Buckets = pulumi.ToStringArray(args.Buckets).ToStringArrayOutput()
+	err = ctx.RegisterResourceOutputs(install, pulumi.Map{
+		"endpoint":  install.Endpoint,
+		"accessKey": install.AccessKey,
+		"secretKey": install.SecretKey,
+		"buckets":   install.Buckets,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return install, nil
+}
@@ -0,0 +1,143 @@
+package monitoring
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// BlackboxReleaseName is the release of the prometheus-blackbox-exporter chart
+	BlackboxReleaseName = "blackbox-exporter"
+	// BlackboxJob is the job label of the probe samples
+	BlackboxJob = "blackbox"
+	// blackboxProber is the in-cluster address of the exporter
+	blackboxProber = BlackboxReleaseName + "-prometheus-blackbox-exporter." + Namespace + ".svc:9115"
+)
+
+// Blackbox modules a probe can use
+const (
+	// ModuleHTTP expects a 2xx response to a GET of the URL
+	ModuleHTTP = "http_2xx"
+	// ModuleTCP expects a TCP connection to host:port to be accepted
+	ModuleTCP = "tcp"
+)
+
+// Probe is a URL probed by the blackbox exporter
+type Probe struct {
+	// Name of the Probe object
+	Name string
+	// URL for ModuleHTTP, host:port for ModuleTCP
+	URL    string
+	Module string
+	// How often the URL is probed
+	Interval time.Duration
+}
+
+// BlackboxArgs configures the blackbox exporter and its probes
+type BlackboxArgs struct {
+	// Chart version
+	Version string
+	Probes  []Probe
+	// Kubeconfig of the cluster (secret), used to wait for the operator CRDs
+	Kubeconfig pulumi.StringInput
+	// How long to wait for the release and the operator CRDs
+	Timeout time.Duration
+}
+
+// Blackbox is the blackbox exporter with a Probe object per URL
+type Blackbox struct {
+	pulumi.ResourceState
+
+	// namespace/name of each Probe
+	Probes pulumi.StringArrayOutput `pulumi:"probes"`
+}
+
+// NewBlackbox installs prometheus-blackbox-exporter with Helm next to
+// Prometheus and, once the Prometheus operator CRDs are Established, creates
+// the Probe objects Prometheus scrapes through the exporter
+func NewBlackbox(ctx *pulumi.Context, name string, args *BlackboxArgs, opts ...pulumi.ResourceOption) (*Blackbox, error) {
+	blackbox := &Blackbox{}
+	err := ctx.RegisterComponentResource("home:monitoring:Blackbox", name, blackbox, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := helmv3.NewRelease(ctx, fmt.Sprintf("%s-release", name), &helmv3.ReleaseArgs{
+		Name:           pulumi.String(BlackboxReleaseName),
+		Chart:          pulumi.String("prometheus-blackbox-exporter"),
+		Version:        pulumi.String(args.Version),
+		Namespace:      pulumi.String(Namespace),
+		RepositoryOpts: helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+		Timeout:        pulumi.Int(int(args.Timeout.Seconds())),
+		Values: pulumi.Map{
+			"config": pulumi.Map{
+				"modules": pulumi.Map{
+					ModuleHTTP: pulumi.Map{
+						"prober":  pulumi.String("http"),
+						"timeout": pulumi.String("5s"),
+						"http": pulumi.Map{
+							"preferred_ip_protocol": pulumi.String("ip4"),
+							"follow_redirects":      pulumi.Bool(true),
+						},
+					},
+					ModuleTCP: pulumi.Map{
+						"prober":  pulumi.String("tcp"),
+						"timeout": pulumi.String("5s"),
+						"tcp":     pulumi.Map{"preferred_ip_protocol": pulumi.String("ip4")},
+					},
+				},
+			},
+		},
+	}, pulumi.Parent(blackbox))
+	if err != nil {
+		return nil, err
+	}
+
+	established := waitForOperatorCRDs(ctx, blackbox, args.Kubeconfig, args.Timeout)
+	probes := make([]string, 0, len(args.Probes))
+	for _, probe := range args.Probes {
+		_, err := apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-%s", name, probe.Name), &apiextensions.CustomResourceArgs{
+			ApiVersion: pulumi.String("monitoring.coreos.com/v1"),
+			Kind:       pulumi.String("Probe"),
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:      pulumi.String(probe.Name),
+				Namespace: pulumi.String(Namespace),
+				Labels:    pulumi.StringMap{"release": pulumi.String(ReleaseName)},
+			},
+			OtherFields: kubernetes.UntypedArgs{
+				"spec": pulumi.Map{
+					"jobName":  pulumi.String(BlackboxJob),
+					"module":   pulumi.String(probe.Module),
+					"interval": pulumi.String(PromDuration(probe.Interval)),
+					"prober":   pulumi.Map{"url": pulumi.String(blackboxProber)},
+					"targets": pulumi.Map{
+						"staticConfig": pulumi.Map{
+							"static": pulumi.ToStringArray([]string{probe.URL}),
+							"labels": pulumi.StringMap{"module": pulumi.String(probe.Module)},
+						},
+					},
+				},
+			},
+		}, pulumi.Parent(blackbox), pulumi.DependsOn([]pulumi.Resource{release}), pulumi.DependsOnInputs(established))
+		if err != nil {
+			return nil, err
+		}
+		probes = append(probes, Namespace+"/"+probe.Name)
+	}
+
+	blackbox.Probes = pulumi.ToStringArray(probes).ToStringArrayOutput()
+	err = ctx.RegisterResourceOutputs(blackbox, pulumi.Map{
+		"probes": blackbox.Probes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return blackbox, nil
+}
@@ -0,0 +1,234 @@
+package monitoring
+
+import (
+	"fmt"
+	"time"
+
+	"cluster-studio/pkg/credentials"
+	"cluster-studio/pkg/stacks"
+
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// ChartRepo is the Helm repository serving the kube-prometheus-stack chart
+	ChartRepo = "https://prometheus-community.github.io/helm-charts"
+	// Namespace is where the stack is installed, the one of the
+	// prometheus-operator component of the infrastructure tree
+	Namespace = "prometheus"
+	// ReleaseName matches the HelmRelease of the infrastructure tree, so the
+	// Service names are the same whichever installs the stack
+	ReleaseName = "prometheus-operator"
+	// GrafanaUser is the Grafana admin user
+	GrafanaUser = "admin"
+	// PrometheusURL is the in-cluster URL of Prometheus
+	PrometheusURL = "http://" + ReleaseName + "-kube-p-prometheus." + Namespace + ".svc.cluster.local:9090"
+	// RemoteWritePath is the Prometheus API path remote_write pushes to
+	RemoteWritePath = "/api/v1/write"
+)
+
+// StackArgs configures the kube-prometheus-stack installation
+type StackArgs struct {
+	// Chart version
+	Version string
+	// How long Prometheus keeps metrics (e.g. "7d")
+	Retention string
+	// Size of the Prometheus volume (e.g. "10Gi")
+	StorageSize string
+	// Store Prometheus, Alertmanager and Grafana data on persistent volumes,
+	// otherwise on emptyDir volumes lost with their pods
+	Persistence bool
+	// Accept remote_write on this node port so other clusters can push their
+	// metrics, 0 disables the receiver
+	RemoteWriteNodePort int
+	// URL Prometheus forwards its samples to with remote_write (optional)
+	RemoteWrite pulumi.StringInput
+	// The datasources are provisioned by ConfigMaps of the program, the chart
+	// then doesn't create its default Prometheus one
+	ProvisionedDatasources bool
+	// Bumping it generates the Grafana admin password again
+	Rotate int
+	// How long to wait for the release to be ready
+	Timeout time.Duration
+}
+
+// Stack is kube-prometheus-stack: the Prometheus operator, Prometheus,
+// Alertmanager and Grafana
+type Stack struct {
+	pulumi.ResourceState
+
+	// In-cluster URL of Grafana
+	GrafanaURL pulumi.StringOutput `pulumi:"grafanaUrl"`
+	// Grafana admin credentials, the password is a secret
+	GrafanaUser     pulumi.StringOutput `pulumi:"grafanaUser"`
+	GrafanaPassword pulumi.StringOutput `pulumi:"grafanaPassword"`
+}
+
+// NewStack installs kube-prometheus-stack with Helm, with a generated
+// Grafana admin password kept in a Secret
+func NewStack(ctx *pulumi.Context, name string, args *StackArgs, opts ...pulumi.ResourceOption) (*Stack, error) {
+	stack := &Stack{}
+	err := ctx.RegisterComponentResource("home:monitoring:Stack", name, stack, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace, err := corev1.NewNamespace(ctx, fmt.Sprintf("%s-namespace", name), &corev1.NamespaceArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name: pulumi.String(Namespace),
+		},
+	}, pulumi.Parent(stack))
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := credentials.NewPassword(ctx, fmt.Sprintf("%s-grafana-password", name), 24, args.Rotate, pulumi.Parent(stack))
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := corev1.NewSecret(ctx, fmt.Sprintf("%s-grafana-admin", name), &corev1.SecretArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String("grafana-admin"),
+			Namespace: namespace.Metadata.Name(),
+		},
+		StringData: pulumi.StringMap{
+			"admin-user":     pulumi.String(GrafanaUser),
+			"admin-password": password.Result,
+		},
+	}, pulumi.Parent(stack))
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := helmv3.NewRelease(ctx, stacks.Name(ctx, ReleaseName), &helmv3.ReleaseArgs{
+		Name:           pulumi.String(ReleaseName),
+		Chart:          pulumi.String("kube-prometheus-stack"),
+		Version:        pulumi.String(args.Version),
+		Namespace:      namespace.Metadata.Name(),
+		RepositoryOpts: helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+		Timeout:        pulumi.Int(int(args.Timeout.Seconds())),
+		Values:         values(args, secret.Metadata.Name().Elem()),
+	}, pulumi.Parent(stack))
+	if err != nil {
+		return nil, err
+	}
+
+	// The chart Service stays ClusterIP, a NodePort one next to it lets other
+	// clusters remote_write through any node
+	if args.RemoteWriteNodePort != 0 {
+		_, err = corev1.NewService(ctx, fmt.Sprintf("%s-remote-write", name), &corev1.ServiceArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:      pulumi.String("prometheus-remote-write"),
+				Namespace: namespace.Metadata.Name(),
+			},
+			Spec: &corev1.ServiceSpecArgs{
+				Type: pulumi.String("NodePort"),
+				Selector: pulumi.StringMap{
+					"app.kubernetes.io/name":      pulumi.String("prometheus"),
+					"operator.prometheus.io/name": pulumi.String(ReleaseName + "-kube-p-prometheus"),
+				},
+				Ports: corev1.ServicePortArray{
+					corev1.ServicePortArgs{
+						Name:       pulumi.String("http-web"),
+						Port:       pulumi.Int(9090),
+						TargetPort: pulumi.Int(9090),
+						NodePort:   pulumi.Int(args.RemoteWriteNodePort),
+					},
+				},
+			},
+		}, pulumi.Parent(stack), pulumi.DependsOn([]pulumi.Resource{release}))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stack.GrafanaURL = pulumi.String(fmt.Sprintf("http://%s-grafana.%s.svc.cluster.local", ReleaseName, Namespace)).ToStringOutput()
+	stack.GrafanaUser = pulumi.String(GrafanaUser).ToStringOutput()
+	stack.GrafanaPassword = password.Result
+	err = ctx.RegisterResourceOutputs(stack, pulumi.Map{
+		"grafanaUrl":      stack.GrafanaURL,
+		"grafanaUser":     stack.GrafanaUser,
+		"grafanaPassword": stack.GrafanaPassword,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stack, nil
+}
+
+// values assembles the chart values. Without persistence every component
+// keeps its data on an emptyDir, which Kind clusters without a storage
+// provisioner can always schedule.
+func values(args *StackArgs, adminSecret pulumi.StringInput) pulumi.Map {
+	prometheusStorage := pulumi.Map{"emptyDir": pulumi.Map{}}
+	alertmanagerStorage := pulumi.Map{"emptyDir": pulumi.Map{}}
+	grafanaPersistence := pulumi.Map{"enabled": pulumi.Bool(false)}
+	if args.Persistence {
+		prometheusStorage = volumeClaimTemplate(args.StorageSize)
+		alertmanagerStorage = volumeClaimTemplate("1Gi")
+		grafanaPersistence = pulumi.Map{
+			"enabled": pulumi.Bool(true),
+			"size":    pulumi.String("1Gi"),
+		}
+	}
+
+	prometheusSpec := pulumi.Map{
+		"retention":   pulumi.String(args.Retention),
+		"storageSpec": prometheusStorage,
+		// Pick up the ServiceMonitors and PodMonitors of every namespace
+		"serviceMonitorSelectorNilUsesHelmValues": pulumi.Bool(false),
+		"podMonitorSelectorNilUsesHelmValues":     pulumi.Bool(false),
+	}
+	if args.RemoteWriteNodePort != 0 {
+		prometheusSpec["enableRemoteWriteReceiver"] = pulumi.Bool(true)
+	}
+	if args.RemoteWrite != nil {
+		prometheusSpec["remoteWrite"] = pulumi.Array{
+			pulumi.Map{"url": args.RemoteWrite},
+		}
+	}
+
+	grafana := pulumi.Map{
+		"admin": pulumi.Map{
+			"existingSecret": adminSecret,
+			"userKey":        pulumi.String("admin-user"),
+			"passwordKey":    pulumi.String("admin-password"),
+		},
+		"persistence": grafanaPersistence,
+	}
+	if args.ProvisionedDatasources {
+		grafana["sidecar"] = pulumi.Map{
+			"datasources": pulumi.Map{"defaultDatasourceEnabled": pulumi.Bool(false)},
+		}
+	}
+
+	return pulumi.Map{
+		"prometheus": pulumi.Map{
+			"prometheusSpec": prometheusSpec,
+		},
+		"alertmanager": pulumi.Map{
+			"alertmanagerSpec": pulumi.Map{
+				"storage": alertmanagerStorage,
+			},
+		},
+		"grafana": grafana,
+	}
+}
+
+func volumeClaimTemplate(size string) pulumi.Map {
+	return pulumi.Map{
+		"volumeClaimTemplate": pulumi.Map{
+			"spec": pulumi.Map{
+				"accessModes": pulumi.ToStringArray([]string{"ReadWriteOnce"}),
+				"resources": pulumi.Map{
+					"requests": pulumi.Map{"storage": pulumi.String(size)},
+				},
+			},
+		},
+	}
+}
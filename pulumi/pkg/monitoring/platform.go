@@ -0,0 +1,305 @@
+package monitoring
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cluster-studio/pkg/kube"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// operatorCRDs are the Prometheus operator CRDs the monitors and rules need
+var operatorCRDs = []string{
+	"servicemonitors.monitoring.coreos.com",
+	"podmonitors.monitoring.coreos.com",
+	"probes.monitoring.coreos.com",
+	"prometheusrules.monitoring.coreos.com",
+}
+
+// AlertThresholds tune the rules of the platform components
+type AlertThresholds struct {
+	// How long Flux must fail to reconcile before alerting
+	FluxFailureFor time.Duration
+	// Fraction of failed inbound requests of a meshed workload (e.g. 0.05)
+	LinkerdErrorRate float64
+	// How long the error rate must stay above LinkerdErrorRate
+	LinkerdErrorRateFor time.Duration
+	// How long the tunnel may have no connection to Cloudflare
+	TunnelDownFor time.Duration
+	// How long a blackbox probe must fail before alerting
+	ProbeFailureFor time.Duration
+}
+
+// PlatformTargets are the platform components to scrape, each in its
+// well-known namespace
+type PlatformTargets struct {
+	// Flux controllers in FluxNamespace
+	Flux          bool
+	FluxNamespace string
+	// Linkerd control plane in LinkerdNamespace and the proxies of every namespace
+	Linkerd          bool
+	LinkerdNamespace string
+	// cloudflared metrics Service in TunnelNamespace
+	Tunnel          bool
+	TunnelNamespace string
+	// external-dns Service in ExternalDNSNamespace
+	ExternalDNS          bool
+	ExternalDNSNamespace string
+	// Probes of the blackbox exporter, scraped through their own Probe objects
+	Probes bool
+}
+
+// PlatformMonitorsArgs configures the monitors of the platform components
+type PlatformMonitorsArgs struct {
+	Targets    PlatformTargets
+	Thresholds AlertThresholds
+	// Kubeconfig of the cluster (secret), used to wait for the operator CRDs
+	Kubeconfig pulumi.StringInput
+	// How long to wait for the operator CRDs, which Flux may install
+	Timeout time.Duration
+}
+
+// PlatformMonitors are the ServiceMonitors, PodMonitors and PrometheusRule of
+// the platform components
+type PlatformMonitors struct {
+	pulumi.ResourceState
+
+	// namespace/name of each ServiceMonitor and PodMonitor
+	Monitors pulumi.StringArrayOutput `pulumi:"monitors"`
+	// namespace/name of the PrometheusRule, empty without rules
+	Rules pulumi.StringOutput `pulumi:"rules"`
+}
+
+// NewPlatformMonitors creates, once the Prometheus operator CRDs are
+// Established, a monitor per target and a PrometheusRule with the alerts of
+// Flux, Linkerd, the tunnel and the blackbox probes. The objects carry the
+// release label the kube-prometheus-stack of the program and of the
+// infrastructure select.
+func NewPlatformMonitors(ctx *pulumi.Context, name string, args *PlatformMonitorsArgs, opts ...pulumi.ResourceOption) (*PlatformMonitors, error) {
+	platform := &PlatformMonitors{}
+	err := ctx.RegisterComponentResource("home:monitoring:PlatformMonitors", name, platform, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	established := waitForOperatorCRDs(ctx, platform, args.Kubeconfig, args.Timeout)
+	resourceOpts := []pulumi.ResourceOption{pulumi.Parent(platform), pulumi.DependsOnInputs(established)}
+
+	var monitors []string
+	newMonitor := func(kind, suffix, namespace string, spec pulumi.Map) error {
+		_, err := apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-%s", name, suffix), &apiextensions.CustomResourceArgs{
+			ApiVersion: pulumi.String("monitoring.coreos.com/v1"),
+			Kind:       pulumi.String(kind),
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:      pulumi.String(suffix),
+				Namespace: pulumi.String(namespace),
+				Labels:    pulumi.StringMap{"release": pulumi.String(ReleaseName)},
+			},
+			OtherFields: kubernetes.UntypedArgs{"spec": spec},
+		}, resourceOpts...)
+		if err != nil {
+			return err
+		}
+		monitors = append(monitors, namespace+"/"+suffix)
+		return nil
+	}
+
+	targets, thresholds := args.Targets, args.Thresholds
+	var groups pulumi.Array
+	if targets.Flux {
+		err = newMonitor("PodMonitor", "flux-system", targets.FluxNamespace, pulumi.Map{
+			"namespaceSelector": pulumi.Map{"matchNames": pulumi.ToStringArray([]string{targets.FluxNamespace})},
+			"selector": pulumi.Map{
+				"matchExpressions": pulumi.Array{
+					pulumi.Map{
+						"key":      pulumi.String("app"),
+						"operator": pulumi.String("In"),
+						"values": pulumi.ToStringArray([]string{
+							"helm-controller", "image-automation-controller", "image-reflector-controller",
+							"kustomize-controller", "notification-controller", "source-controller",
+						}),
+					},
+				},
+			},
+			"podMetricsEndpoints": pulumi.Array{pulumi.Map{"port": pulumi.String("http-prom")}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, ruleGroup("flux", alertRule{
+			Alert: "FluxReconciliationFailing",
+			Expr: fmt.Sprintf(`sum by (controller) (rate(controller_runtime_reconcile_errors_total{namespace=%q}[5m])) > 0`,
+				targets.FluxNamespace),
+			For:     thresholds.FluxFailureFor,
+			Summary: "{{ $labels.controller }} of Flux keeps failing to reconcile",
+		}))
+	}
+	if targets.Linkerd {
+		err = newMonitor("PodMonitor", "linkerd-control-plane", targets.LinkerdNamespace, pulumi.Map{
+			"namespaceSelector": pulumi.Map{"matchNames": pulumi.ToStringArray([]string{targets.LinkerdNamespace})},
+			"selector": pulumi.Map{
+				"matchExpressions": pulumi.Array{
+					pulumi.Map{"key": pulumi.String("linkerd.io/control-plane-component"), "operator": pulumi.String("Exists")},
+				},
+			},
+			"podMetricsEndpoints": pulumi.Array{pulumi.Map{"port": pulumi.String("admin-http")}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = newMonitor("PodMonitor", "linkerd-proxies", targets.LinkerdNamespace, pulumi.Map{
+			"namespaceSelector": pulumi.Map{"any": pulumi.Bool(true)},
+			"selector": pulumi.Map{
+				"matchExpressions": pulumi.Array{
+					pulumi.Map{"key": pulumi.String("linkerd.io/control-plane-ns"), "operator": pulumi.String("Exists")},
+				},
+			},
+			"podMetricsEndpoints": pulumi.Array{pulumi.Map{"port": pulumi.String("linkerd-admin")}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, ruleGroup("linkerd", alertRule{
+			Alert: "LinkerdHighErrorRate",
+			Expr: fmt.Sprintf(`sum by (namespace, deployment) (rate(response_total{direction="inbound", classification="failure"}[5m]))`+
+				` / sum by (namespace, deployment) (rate(response_total{direction="inbound"}[5m])) > %g`, thresholds.LinkerdErrorRate),
+			For:     thresholds.LinkerdErrorRateFor,
+			Summary: fmt.Sprintf("More than %g%% of the requests to {{ $labels.namespace }}/{{ $labels.deployment }} fail", thresholds.LinkerdErrorRate*100),
+		}))
+	}
+	if targets.Tunnel {
+		err = newMonitor("ServiceMonitor", "cloudflared", targets.TunnelNamespace, pulumi.Map{
+			"selector": pulumi.Map{
+				"matchLabels": pulumi.StringMap{
+					"app.kubernetes.io/name":      pulumi.String("cloudflare-tunnel"),
+					"app.kubernetes.io/component": pulumi.String("metrics"),
+				},
+			},
+			"endpoints": pulumi.Array{pulumi.Map{"port": pulumi.String("metrics")}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		connections := fmt.Sprintf(`cloudflared_tunnel_ha_connections{namespace=%q}`, targets.TunnelNamespace)
+		groups = append(groups, ruleGroup("cloudflared", alertRule{
+			Alert:   "CloudflareTunnelDown",
+			Expr:    fmt.Sprintf(`sum(%[1]s) < 1 or absent(%[1]s)`, connections),
+			For:     thresholds.TunnelDownFor,
+			Summary: "The Cloudflare Tunnel has no connection to Cloudflare",
+		}))
+	}
+	if targets.ExternalDNS {
+		err = newMonitor("ServiceMonitor", "external-dns", targets.ExternalDNSNamespace, pulumi.Map{
+			"selector": pulumi.Map{
+				"matchLabels": pulumi.StringMap{"app.kubernetes.io/name": pulumi.String("external-dns")},
+			},
+			"endpoints": pulumi.Array{pulumi.Map{"port": pulumi.String("http")}},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if targets.Probes {
+		groups = append(groups, ruleGroup("blackbox", alertRule{
+			Alert:   "ProbeFailing",
+			Expr:    fmt.Sprintf(`probe_success{job=%q} == 0`, BlackboxJob),
+			For:     thresholds.ProbeFailureFor,
+			Summary: "{{ $labels.instance }} doesn't respond to the {{ $labels.module }} probe",
+		}))
+	}
+
+	rules := pulumi.String("").ToStringOutput()
+	if len(groups) > 0 {
+		rule, err := apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-rules", name), &apiextensions.CustomResourceArgs{
+			ApiVersion: pulumi.String("monitoring.coreos.com/v1"),
+			Kind:       pulumi.String("PrometheusRule"),
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:      pulumi.String("platform-alerts"),
+				Namespace: pulumi.String(Namespace),
+				Labels:    pulumi.StringMap{"release": pulumi.String(ReleaseName)},
+			},
+			OtherFields: kubernetes.UntypedArgs{
+				"spec": pulumi.Map{"groups": groups},
+			},
+		}, resourceOpts...)
+		if err != nil {
+			return nil, err
+		}
+		rules = pulumi.Sprintf("%s/%s", Namespace, rule.Metadata.Name().Elem())
+	}
+
+	platform.Monitors = pulumi.ToStringArray(monitors).ToStringArrayOutput()
+	platform.Rules = rules
+	err = ctx.RegisterResourceOutputs(platform, pulumi.Map{
+		"monitors": platform.Monitors,
+		"rules":    platform.Rules,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return platform, nil
+}
+
+// waitForOperatorCRDs resolves once the Prometheus operator CRDs are
+// Established, for the resources of component to depend on
+func waitForOperatorCRDs(ctx *pulumi.Context, component pulumi.Resource, kubeconfig pulumi.StringInput, timeout time.Duration) pulumi.ResourceArrayOutput {
+	return kubeconfig.ToStringOutput().ApplyT(func(kubeconfig string) ([]pulumi.Resource, error) {
+		if ctx.DryRun() {
+			return nil, nil
+		}
+		client, err := kube.NewDynamicClientFromKubeconfig(kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		err = kube.WaitForCRDsEstablished(context.Background(), client, operatorCRDs, kube.PollOptions{
+			Description: "Prometheus operator CRDs to become Established",
+			Timeout:     timeout,
+			Logf: func(format string, a ...interface{}) {
+				_ = ctx.Log.Info(fmt.Sprintf(format, a...), &pulumi.LogArgs{Resource: component})
+			},
+		})
+		return nil, err
+	}).(pulumi.ResourceArrayOutput)
+}
+
+// alertRule is an alerting rule of a PrometheusRule group
+type alertRule struct {
+	Alert   string
+	Expr    string
+	For     time.Duration
+	Summary string
+}
+
+// ruleGroup returns a PrometheusRule group with the rules, labelled warning
+func ruleGroup(name string, rules ...alertRule) pulumi.Map {
+	var array pulumi.Array
+	for _, rule := range rules {
+		array = append(array, pulumi.Map{
+			"alert":       pulumi.String(rule.Alert),
+			"expr":        pulumi.String(rule.Expr),
+			"for":         pulumi.String(PromDuration(rule.For)),
+			"labels":      pulumi.StringMap{"severity": pulumi.String("warning")},
+			"annotations": pulumi.StringMap{"summary": pulumi.String(rule.Summary)},
+		})
+	}
+	return pulumi.Map{"name": pulumi.String(name), "rules": array}
+}
+
+// PromDuration formats d the way Prometheus parses durations ("15m", not
+// "15m0s")
+func PromDuration(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+	return fmt.Sprintf("%ds", d/time.Second)
+}
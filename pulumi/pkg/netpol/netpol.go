@@ -0,0 +1,225 @@
+package netpol
+
+import (
+	"fmt"
+
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	networkingv1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/networking/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// OptOutAnnotation set to "disabled" on a namespace leaves it open
+	OptOutAnnotation = "home.lucena.cloud/network-policy"
+	// namespaceLabel is set by Kubernetes on every namespace
+	namespaceLabel = "kubernetes.io/metadata.name"
+)
+
+// LinkerdNamespaces hold the control plane and viz components reaching the
+// proxies on their tap (4190) and admin (4191) ports
+var LinkerdNamespaces = []string{"linkerd", "linkerd-viz"}
+
+// linkerdPorts are the proxy ports the Linkerd control plane connects to
+var linkerdPorts = []int{4190, 4191}
+
+// linkerdControlPlanePorts are the identity (8080), destination (8086) and
+// policy (8090) ports the proxies connect to in the linkerd namespace
+var linkerdControlPlanePorts = []int{8080, 8086, 8090}
+
+// apiServerPort is the port of the Kubernetes API server on the nodes (Kind
+// and k3d). Policies see the connections to the kubernetes Service once they
+// are translated to it.
+const apiServerPort = 6443
+
+// DefaultDenyArgs configures the NetworkPolicies of a namespace
+type DefaultDenyArgs struct {
+	// Namespace the policies are created in
+	Namespace string
+	// Service mesh of the cluster, "linkerd" allows its control plane in
+	Mesh string
+	// Namespace of the Prometheus scraping every pod, none when empty
+	PrometheusNamespace string
+	// Deny egress too, except DNS, the Kubernetes API and, with the
+	// "linkerd" mesh, its control plane
+	DenyEgress bool
+}
+
+// DefaultDeny are the NetworkPolicies isolating a namespace
+type DefaultDeny struct {
+	pulumi.ResourceState
+
+	// Names of the NetworkPolicies
+	Policies pulumi.StringArrayOutput `pulumi:"policies"`
+}
+
+// NewDefaultDeny denies all ingress to the pods of a namespace, then allows
+// the Linkerd control plane and Prometheus back in. With DenyEgress, egress
+// is denied as well except for DNS lookups to kube-system, the Kubernetes API
+// the controllers of the namespace watch and, with the "linkerd" mesh, the
+// control plane the proxies need to start.
+func NewDefaultDeny(ctx *pulumi.Context, name string, args *DefaultDenyArgs, opts ...pulumi.ResourceOption) (*DefaultDeny, error) {
+	deny := &DefaultDeny{}
+	err := ctx.RegisterComponentResource("home:netpol:DefaultDeny", name, deny, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	create := func(policyName string, spec *networkingv1.NetworkPolicySpecArgs) error {
+		_, err := networkingv1.NewNetworkPolicy(ctx, fmt.Sprintf("%s-%s", name, policyName), &networkingv1.NetworkPolicyArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:      pulumi.String(policyName),
+				Namespace: pulumi.String(args.Namespace),
+			},
+			Spec: spec,
+		}, pulumi.Parent(deny))
+		if err != nil {
+			return err
+		}
+		names = append(names, policyName)
+		return nil
+	}
+
+	policyTypes := pulumi.StringArray{pulumi.String("Ingress")}
+	if args.DenyEgress {
+		policyTypes = append(policyTypes, pulumi.String("Egress"))
+	}
+	if err := create("default-deny", &networkingv1.NetworkPolicySpecArgs{
+		PodSelector: metav1.LabelSelectorArgs{},
+		PolicyTypes: policyTypes,
+	}); err != nil {
+		return nil, err
+	}
+
+	if args.DenyEgress {
+		err := create("allow-dns", &networkingv1.NetworkPolicySpecArgs{
+			PodSelector: metav1.LabelSelectorArgs{},
+			PolicyTypes: pulumi.StringArray{pulumi.String("Egress")},
+			Egress: networkingv1.NetworkPolicyEgressRuleArray{
+				networkingv1.NetworkPolicyEgressRuleArgs{
+					To: networkingv1.NetworkPolicyPeerArray{
+						networkingv1.NetworkPolicyPeerArgs{
+							NamespaceSelector: fromNamespaces("kube-system"),
+							PodSelector: metav1.LabelSelectorArgs{
+								MatchLabels: pulumi.StringMap{"k8s-app": pulumi.String("kube-dns")},
+							},
+						},
+					},
+					Ports: networkingv1.NetworkPolicyPortArray{
+						networkingv1.NetworkPolicyPortArgs{Protocol: pulumi.String("UDP"), Port: pulumi.Int(53)},
+						networkingv1.NetworkPolicyPortArgs{Protocol: pulumi.String("TCP"), Port: pulumi.Int(53)},
+					},
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		// The API server runs on the host network of the nodes, outside of
+		// any namespace a peer could select
+		err = create("allow-apiserver", &networkingv1.NetworkPolicySpecArgs{
+			PodSelector: metav1.LabelSelectorArgs{},
+			PolicyTypes: pulumi.StringArray{pulumi.String("Egress")},
+			Egress: networkingv1.NetworkPolicyEgressRuleArray{
+				networkingv1.NetworkPolicyEgressRuleArgs{
+					Ports: networkingv1.NetworkPolicyPortArray{
+						networkingv1.NetworkPolicyPortArgs{Protocol: pulumi.String("TCP"), Port: pulumi.Int(apiServerPort)},
+					},
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if args.Mesh == "linkerd" && args.DenyEgress {
+		var ports networkingv1.NetworkPolicyPortArray
+		for _, port := range linkerdControlPlanePorts {
+			ports = append(ports, networkingv1.NetworkPolicyPortArgs{Protocol: pulumi.String("TCP"), Port: pulumi.Int(port)})
+		}
+		err := create("allow-linkerd-control-plane", &networkingv1.NetworkPolicySpecArgs{
+			PodSelector: metav1.LabelSelectorArgs{},
+			PolicyTypes: pulumi.StringArray{pulumi.String("Egress")},
+			Egress: networkingv1.NetworkPolicyEgressRuleArray{
+				networkingv1.NetworkPolicyEgressRuleArgs{
+					To: networkingv1.NetworkPolicyPeerArray{
+						networkingv1.NetworkPolicyPeerArgs{NamespaceSelector: fromNamespaces("linkerd")},
+					},
+					Ports: ports,
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if args.Mesh == "linkerd" {
+		var ports networkingv1.NetworkPolicyPortArray
+		for _, port := range linkerdPorts {
+			ports = append(ports, networkingv1.NetworkPolicyPortArgs{Protocol: pulumi.String("TCP"), Port: pulumi.Int(port)})
+		}
+		err := create("allow-linkerd", &networkingv1.NetworkPolicySpecArgs{
+			PodSelector: metav1.LabelSelectorArgs{},
+			PolicyTypes: pulumi.StringArray{pulumi.String("Ingress")},
+			Ingress: networkingv1.NetworkPolicyIngressRuleArray{
+				networkingv1.NetworkPolicyIngressRuleArgs{
+					From: networkingv1.NetworkPolicyPeerArray{
+						networkingv1.NetworkPolicyPeerArgs{NamespaceSelector: fromNamespaces(LinkerdNamespaces...)},
+					},
+					Ports: ports,
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Metrics ports differ per workload, so Prometheus may reach any port
+	if args.PrometheusNamespace != "" {
+		err := create("allow-prometheus", &networkingv1.NetworkPolicySpecArgs{
+			PodSelector: metav1.LabelSelectorArgs{},
+			PolicyTypes: pulumi.StringArray{pulumi.String("Ingress")},
+			Ingress: networkingv1.NetworkPolicyIngressRuleArray{
+				networkingv1.NetworkPolicyIngressRuleArgs{
+					From: networkingv1.NetworkPolicyPeerArray{
+						networkingv1.NetworkPolicyPeerArgs{
+							NamespaceSelector: fromNamespaces(args.PrometheusNamespace),
+							PodSelector: metav1.LabelSelectorArgs{
+								MatchLabels: pulumi.StringMap{"app.kubernetes.io/name": pulumi.String("prometheus")},
+							},
+						},
+					},
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	deny.Policies = pulumi.ToStringArray(names).ToStringArrayOutput()
+	err = ctx.RegisterResourceOutputs(deny, pulumi.Map{
+		"policies": deny.Policies,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deny, nil
+}
+
+// fromNamespaces selects the given namespaces by name
+func fromNamespaces(namespaces ...string) metav1.LabelSelectorArgs {
+	return metav1.LabelSelectorArgs{
+		MatchExpressions: metav1.LabelSelectorRequirementArray{
+			metav1.LabelSelectorRequirementArgs{
+				Key:      pulumi.String(namespaceLabel),
+				Operator: pulumi.String("In"),
+				Values:   pulumi.ToStringArray(namespaces),
+			},
+		},
+	}
+}
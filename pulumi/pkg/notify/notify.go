@@ -0,0 +1,91 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Payload formats of the webhooks
+const (
+	Slack   = "slack"
+	Discord = "discord"
+)
+
+// maxExcerpt bounds the error quoted in a message, Discord rejects content
+// over 2000 characters
+const maxExcerpt = 1500
+
+// Deployment is the outcome of a deployment
+type Deployment struct {
+	Stack    string
+	Duration time.Duration
+	// Components enabled, disabled or upgraded by the deployment
+	Changed []string
+	// Error of a failed deployment and the step it failed in, nil on success
+	Err  error
+	Step string
+}
+
+// Text renders the deployment as the message posted
+func (d Deployment) Text() string {
+	var text strings.Builder
+	if d.Err == nil {
+		fmt.Fprintf(&text, "✅ %s deployed in %s", d.Stack, d.Duration.Round(time.Second))
+	} else {
+		fmt.Fprintf(&text, "❌ %s failed after %s", d.Stack, d.Duration.Round(time.Second))
+		if d.Step != "" {
+			fmt.Fprintf(&text, " at %s", d.Step)
+		}
+	}
+	if len(d.Changed) > 0 {
+		fmt.Fprintf(&text, "\nChanged: %s", strings.Join(d.Changed, ", "))
+	}
+	if d.Err != nil {
+		excerpt := d.Err.Error()
+		if len(excerpt) > maxExcerpt {
+			excerpt = excerpt[:maxExcerpt] + "…"
+		}
+		fmt.Fprintf(&text, "\n```\n%s\n```", excerpt)
+	}
+	return text.String()
+}
+
+// Post posts the deployment to a Slack or Discord incoming webhook
+func Post(ctx context.Context, url, format string, d Deployment) error {
+	var payload map[string]string
+	switch format {
+	case Slack:
+		payload = map[string]string{"text": d.Text()}
+	case Discord:
+		payload = map[string]string{"content": d.Text()}
+	default:
+		return fmt.Errorf("unknown webhook format %q", format)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// The URL holds the webhook token, keep it out of the error
+		return fmt.Errorf("posting to the %s webhook failed", format)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("the %s webhook responded with %s", format, resp.Status)
+	}
+	return nil
+}
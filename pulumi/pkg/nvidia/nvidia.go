@@ -0,0 +1,132 @@
+package nvidia
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/stacks"
+
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	nodev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/node/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// ChartRepo is the Helm repository serving the NVIDIA device plugin chart
+	ChartRepo = "https://nvidia.github.io/k8s-device-plugin"
+	// Namespace is where the device plugin is installed
+	Namespace = "nvidia-device-plugin"
+	// RuntimeClass runs pods with the NVIDIA container runtime of the nodes
+	RuntimeClass = "nvidia"
+	// GPUResource is the extended resource the device plugin advertises
+	GPUResource = "nvidia.com/gpu"
+)
+
+// hostPrerequisites explains what the host needs for GPUs to reach the nodes
+const hostPrerequisites = `the nodes report no allocatable nvidia.com/gpu, check the host: the NVIDIA driver is loaded (nvidia-smi works), nvidia-container-toolkit is installed, /etc/nvidia-container-runtime/config.toml sets accept-nvidia-visible-devices-as-volume-mounts = true and nvidia is Docker's default runtime (nvidia-ctk runtime configure --runtime=docker --set-as-default). Recreate the cluster after fixing them`
+
+// DevicePluginArgs configures the NVIDIA device plugin installation
+type DevicePluginArgs struct {
+	// Chart version (e.g. 0.17.4)
+	Version string
+	// Kubeconfig of the cluster (secret), used to check the allocatable GPUs
+	Kubeconfig pulumi.StringInput
+	// How long to wait for the nodes to report allocatable GPUs
+	Timeout time.Duration
+}
+
+// DevicePlugin is the NVIDIA device plugin DaemonSet with its RuntimeClass
+type DevicePlugin struct {
+	pulumi.ResourceState
+
+	// Number of GPUs allocatable across the nodes
+	Allocatable pulumi.IntOutput `pulumi:"allocatable"`
+}
+
+// NewDevicePlugin creates the nvidia RuntimeClass, installs the device plugin
+// with Helm and waits for the nodes to report allocatable nvidia.com/gpu,
+// recorded in a ConfigMap of kube-system. The ConfigMap is only created once
+// GPUs are allocatable, so resources depending on the component don't start
+// before, and the update fails with the host prerequisites otherwise.
+func NewDevicePlugin(ctx *pulumi.Context, name string, args *DevicePluginArgs, opts ...pulumi.ResourceOption) (*DevicePlugin, error) {
+	plugin := &DevicePlugin{}
+	err := ctx.RegisterComponentResource("home:nvidia:DevicePlugin", name, plugin, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	runtimeClass, err := nodev1.NewRuntimeClass(ctx, fmt.Sprintf("%s-runtimeclass", name), &nodev1.RuntimeClassArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name: pulumi.String(RuntimeClass),
+		},
+		Handler: pulumi.String(RuntimeClass),
+	}, pulumi.Parent(plugin))
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := helmv3.NewRelease(ctx, stacks.Name(ctx, "nvidia-device-plugin"), &helmv3.ReleaseArgs{
+		Name:            pulumi.String("nvidia-device-plugin"),
+		Chart:           pulumi.String("nvidia-device-plugin"),
+		Version:         pulumi.String(args.Version),
+		Namespace:       pulumi.String(Namespace),
+		CreateNamespace: pulumi.Bool(true),
+		RepositoryOpts:  helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+		Values: pulumi.Map{
+			"runtimeClassName": pulumi.String(RuntimeClass),
+		},
+		Timeout: pulumi.Int(int(args.Timeout.Seconds())),
+	}, pulumi.Parent(plugin), pulumi.DependsOn([]pulumi.Resource{runtimeClass}))
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolves to the number of allocatable GPUs once there is at least one
+	allocatable := pulumi.All(release.ID(), args.Kubeconfig).ApplyT(func(values []interface{}) (int, error) {
+		if ctx.DryRun() {
+			return 0, nil
+		}
+		client, err := kube.NewClientsetFromKubeconfig(values[1].(string))
+		if err != nil {
+			return 0, err
+		}
+		total, err := kube.WaitForAllocatable(context.Background(), client, GPUResource, kube.PollOptions{
+			Timeout: args.Timeout,
+			Logf: func(format string, a ...interface{}) {
+				_ = ctx.Log.Info(fmt.Sprintf(format, a...), &pulumi.LogArgs{Resource: plugin})
+			},
+		})
+		if err != nil {
+			return 0, fmt.Errorf("%w\n%s", err, hostPrerequisites)
+		}
+		return int(total), nil
+	}).(pulumi.IntOutput)
+
+	_, err = corev1.NewConfigMap(ctx, fmt.Sprintf("%s-report", name), &corev1.ConfigMapArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(name),
+			Namespace: pulumi.String("kube-system"),
+		},
+		Data: pulumi.StringMap{
+			"allocatable": allocatable.ApplyT(strconv.Itoa).(pulumi.StringOutput),
+		},
+	}, pulumi.Parent(plugin))
+	if err != nil {
+		return nil, err
+	}
+
+	plugin.Allocatable = allocatable
+	err = ctx.RegisterResourceOutputs(plugin, pulumi.Map{
+		"allocatable": plugin.Allocatable,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return plugin, nil
+}
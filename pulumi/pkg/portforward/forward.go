@@ -0,0 +1,77 @@
+package portforward
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Forward forwards a port on localhost to a port of a Service
+type Forward struct {
+	Namespace string
+	Service   string
+	// Port on localhost
+	LocalPort int
+	// Port of the Service
+	RemotePort int
+}
+
+// Parse reads a forward written as namespace/service:localPort:remotePort,
+// e.g. linkerd-viz/web:8084:8084
+func Parse(spec string) (Forward, error) {
+	target, ports, _ := strings.Cut(spec, ":")
+	namespace, service, ok := strings.Cut(target, "/")
+	localPort, remotePort, ok2 := strings.Cut(ports, ":")
+	if !ok || !ok2 || namespace == "" || service == "" {
+		return Forward{}, fmt.Errorf("invalid port forward %q, use namespace/service:localPort:remotePort", spec)
+	}
+
+	forward := Forward{Namespace: namespace, Service: service}
+	var err error
+	if forward.LocalPort, err = parsePort(localPort); err != nil {
+		return Forward{}, fmt.Errorf("invalid local port in port forward %q: %w", spec, err)
+	}
+	if forward.RemotePort, err = parsePort(remotePort); err != nil {
+		return Forward{}, fmt.Errorf("invalid remote port in port forward %q: %w", spec, err)
+	}
+	return forward, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("%q is not a port number", s)
+	}
+	return port, nil
+}
+
+// String returns the forward in the form read by Parse
+func (f Forward) String() string {
+	return fmt.Sprintf("%s/%s:%d:%d", f.Namespace, f.Service, f.LocalPort, f.RemotePort)
+}
+
+// URL is the address of the forward on localhost
+func (f Forward) URL() string {
+	return fmt.Sprintf("http://localhost:%d", f.LocalPort)
+}
+
+// CheckPorts fails when a local port is used by two forwards or is already
+// taken by another process, naming the forward that can't be started
+func CheckPorts(forwards []Forward) error {
+	used := map[int]Forward{}
+	for _, forward := range forwards {
+		if other, ok := used[forward.LocalPort]; ok {
+			return fmt.Errorf("port forwards %s and %s both use local port %d", other, forward, forward.LocalPort)
+		}
+		used[forward.LocalPort] = forward
+
+		listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", forward.LocalPort))
+		if err != nil {
+			return fmt.Errorf("local port %d of port forward %s is already in use (%v), free it or pick another local port",
+				forward.LocalPort, forward, err)
+		}
+		_ = listener.Close()
+	}
+	return nil
+}
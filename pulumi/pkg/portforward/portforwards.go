@@ -0,0 +1,88 @@
+package portforward
+
+import (
+	"fmt"
+
+	"cluster-studio/pkg/shell"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Args configures the port forwards of a cluster
+type Args struct {
+	// Cluster the forwards belong to, names the pidfile
+	Cluster  string
+	Forwards []Forward
+	// Kubeconfig path, empty for the default loading rules
+	Kubeconfig string
+	// Kube context of the cluster
+	Context string
+}
+
+// PortForwards is a supervisor process on the host keeping the forwards running
+type PortForwards struct {
+	pulumi.ResourceState
+
+	// namespace/service -> URL on localhost, resolved once the supervisor runs
+	URLs pulumi.StringMapOutput `pulumi:"urls"`
+}
+
+// NewPortForwards (re)starts the supervisor, this program run with the
+// port-forward command, on every update. The executable of a run is gone by
+// the time the forwards are destroyed, so destroy stops the supervisor with
+// the shell through its pidfile.
+func NewPortForwards(ctx *pulumi.Context, name string, args *Args, opts ...pulumi.ResourceOption) (*PortForwards, error) {
+	forwards := &PortForwards{}
+	err := ctx.RegisterComponentResource("home:portforward:PortForwards", name, forwards, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Updated in place: a replacement would stop the supervisor started below
+	pidfile := shell.Quote(PIDFile(args.Cluster))
+	mkdir := pulumi.String(fmt.Sprintf("mkdir -p \"$(dirname %s)\"", pidfile))
+	supervisor, err := local.NewCommand(ctx, name, &local.CommandArgs{
+		Create: mkdir,
+		Update: mkdir,
+		Delete: pulumi.String(fmt.Sprintf(`pidfile=%s
+if [ -f "$pidfile" ]; then
+  kill "$(cat "$pidfile")" 2>/dev/null || true
+  rm -f "$pidfile"
+fi`, pidfile)),
+		Interpreter: shell.Interpreter(),
+	}, pulumi.Parent(forwards))
+	if err != nil {
+		return nil, err
+	}
+
+	forwards.URLs = supervisor.ID().ApplyT(func(pulumi.ID) (map[string]string, error) {
+		urls := map[string]string{}
+		for _, forward := range args.Forwards {
+			urls[forward.Namespace+"/"+forward.Service] = forward.URL()
+		}
+		if ctx.DryRun() {
+			return urls, nil
+		}
+
+		pid, err := Start(StartOptions{
+			Cluster:    args.Cluster,
+			Forwards:   args.Forwards,
+			Kubeconfig: args.Kubeconfig,
+			Context:    args.Context,
+		})
+		if err != nil {
+			return nil, err
+		}
+		_ = ctx.Log.Info(fmt.Sprintf("port forwards supervised by pid %d, log in %s", pid, LogFile(args.Cluster)), &pulumi.LogArgs{Resource: forwards})
+		return urls, nil
+	}).(pulumi.StringMapOutput)
+	err = ctx.RegisterResourceOutputs(forwards, pulumi.Map{
+		"urls": forwards.URLs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return forwards, nil
+}
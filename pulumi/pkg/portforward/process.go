@@ -0,0 +1,223 @@
+package portforward
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"cluster-studio/pkg/kube"
+)
+
+// Command is the first argument that runs the program as the port forward
+// supervisor instead of the Pulumi program:
+//
+//	<program> port-forward start|stop|run --cluster <name> [--kubeconfig <path>] [--context <name>] [forward...]
+const Command = "port-forward"
+
+// startTimeout bounds how long Start waits for the supervisor to come up
+const startTimeout = 30 * time.Second
+
+// stateDir holds the pidfiles and logs of the supervisors
+func stateDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "homelab", "port-forwards")
+}
+
+// PIDFile is the pidfile of the supervisor of a cluster
+func PIDFile(cluster string) string {
+	return filepath.Join(stateDir(), cluster+".pid")
+}
+
+// LogFile is the log of the supervisor of a cluster
+func LogFile(cluster string) string {
+	return filepath.Join(stateDir(), cluster+".log")
+}
+
+// Main runs the port-forward command with the arguments following it
+func Main(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s start|stop|run --cluster <name> [--kubeconfig <path>] [--context <name>] [forward...]", Command)
+	}
+	action := args[0]
+	flags := flag.NewFlagSet(Command+" "+action, flag.ContinueOnError)
+	cluster := flags.String("cluster", "", "cluster the forwards belong to")
+	kubeconfig := flags.String("kubeconfig", "", "kubeconfig path (default $KUBECONFIG or ~/.kube/config)")
+	kubeContext := flags.String("context", "", "kube context")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *cluster == "" {
+		return fmt.Errorf("%s %s: --cluster is required", Command, action)
+	}
+
+	var forwards []Forward
+	for _, spec := range flags.Args() {
+		forward, err := Parse(spec)
+		if err != nil {
+			return err
+		}
+		forwards = append(forwards, forward)
+	}
+
+	switch action {
+	case "start":
+		pid, err := Start(StartOptions{Cluster: *cluster, Forwards: forwards, Kubeconfig: *kubeconfig, Context: *kubeContext})
+		if err != nil {
+			return err
+		}
+		for _, forward := range forwards {
+			fmt.Printf("%s -> %s\n", forward.URL(), forward)
+		}
+		fmt.Printf("port forwards supervised by pid %d, log in %s\n", pid, LogFile(*cluster))
+		return nil
+	case "stop":
+		return Stop(PIDFile(*cluster))
+	case "run":
+		return run(*cluster, *kubeconfig, *kubeContext, forwards)
+	}
+	return fmt.Errorf("unknown %s action %q, use start, stop or run", Command, action)
+}
+
+// StartOptions configures Start
+type StartOptions struct {
+	// Cluster the forwards belong to, names the pidfile and the log
+	Cluster  string
+	Forwards []Forward
+	// Kubeconfig path, empty for the default loading rules
+	Kubeconfig string
+	// Kube context of the cluster
+	Context string
+}
+
+// Start replaces the running supervisor of the cluster, if any, with a new
+// one detached from the caller and waits until it is up. Local ports that
+// are already taken fail the start. Returns the pid of the supervisor.
+func Start(opts StartOptions) (int, error) {
+	if len(opts.Forwards) == 0 {
+		return 0, fmt.Errorf("%s start: no forwards given", Command)
+	}
+	pidfile := PIDFile(opts.Cluster)
+	if err := Stop(pidfile); err != nil {
+		return 0, err
+	}
+	if err := CheckPorts(opts.Forwards); err != nil {
+		return 0, err
+	}
+
+	if err := os.MkdirAll(stateDir(), 0o755); err != nil {
+		return 0, fmt.Errorf("failed to create %s: %w", stateDir(), err)
+	}
+	logPath := LogFile(opts.Cluster)
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create supervisor log: %w", err)
+	}
+	defer logFile.Close()
+
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("failed to find the supervisor executable: %w", err)
+	}
+	args := []string{Command, "run", "--cluster", opts.Cluster, "--kubeconfig", opts.Kubeconfig, "--context", opts.Context}
+	for _, forward := range opts.Forwards {
+		args = append(args, forward.String())
+	}
+	// The supervisor gets its own session so that it outlives the process
+	// that started it and isn't interrupted along with `pulumi up`
+	cmd := exec.Command(executable, args...)
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("failed to start the port forward supervisor: %w", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	deadline := time.After(startTimeout)
+	for {
+		if pid, err := readPID(pidfile); err == nil && pid == cmd.Process.Pid {
+			return pid, nil
+		}
+		select {
+		case err := <-exited:
+			output, _ := os.ReadFile(logPath)
+			return 0, fmt.Errorf("port forward supervisor exited (%v):\n%s", err, strings.TrimSpace(string(output)))
+		case <-deadline:
+			_ = cmd.Process.Kill()
+			return 0, fmt.Errorf("port forward supervisor didn't start within %s, see %s", startTimeout, logPath)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// run supervises the forwards until it receives SIGTERM or SIGINT, with its
+// pid in the pidfile of the cluster
+func run(cluster, kubeconfig, kubeContext string, forwards []Forward) error {
+	restConfig, err := kube.NewRESTConfig(kubeconfig, kubeContext)
+	if err != nil {
+		return err
+	}
+
+	pidfile := PIDFile(cluster)
+	if err := os.WriteFile(pidfile, []byte(strconv.Itoa(os.Getpid())), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", pidfile, err)
+	}
+	defer os.Remove(pidfile)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+	for _, forward := range forwards {
+		log.Printf("forwarding %s to %s", forward.URL(), forward)
+	}
+	return Supervise(ctx, restConfig, forwards, log.Printf)
+}
+
+// Stop terminates the supervisor whose pid is in pidfile and removes it.
+// Nothing happens when no supervisor is running.
+func Stop(pidfile string) error {
+	pid, err := readPID(pidfile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := syscall.Kill(pid, syscall.SIGTERM); err == nil {
+		for i := 0; i < 100 && syscall.Kill(pid, 0) == nil; i++ {
+			time.Sleep(100 * time.Millisecond)
+		}
+		if syscall.Kill(pid, 0) == nil {
+			return fmt.Errorf("port forward supervisor %d didn't stop", pid)
+		}
+	}
+	if err := os.Remove(pidfile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func readPID(pidfile string) (int, error) {
+	data, err := os.ReadFile(pidfile)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid pidfile %s: %w", pidfile, err)
+	}
+	return pid, nil
+}
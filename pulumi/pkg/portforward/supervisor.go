@@ -0,0 +1,163 @@
+package portforward
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"cluster-studio/pkg/kube"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// reconnectDelay is the wait before forwarding again after the pod went away
+// or couldn't be found
+const reconnectDelay = 5 * time.Second
+
+// Supervise forwards every port until ctx is done. A forward whose pod is
+// deleted or restarted is reconnected to a ready pod of the Service.
+func Supervise(ctx context.Context, restConfig *rest.Config, forwards []Forward, logf kube.Logf) error {
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	transport, upgrader, err := spdy.RoundTripperFor(restConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create port forward transport: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	for _, forward := range forwards {
+		wg.Add(1)
+		go func(forward Forward) {
+			defer wg.Done()
+			for {
+				err := forwardOnce(ctx, client, restConfig, &http.Client{Transport: transport}, upgrader, forward)
+				if ctx.Err() != nil {
+					return
+				}
+				logf("%s: %v, retrying in %s", forward, err, reconnectDelay)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(reconnectDelay):
+				}
+			}
+		}(forward)
+	}
+	wg.Wait()
+	return nil
+}
+
+// forwardOnce forwards the port to one pod of the Service until the
+// connection is lost or ctx is done
+func forwardOnce(ctx context.Context, client kubernetes.Interface, restConfig *rest.Config, httpClient *http.Client, upgrader spdy.Upgrader, forward Forward) error {
+	pod, podPort, err := resolvePod(ctx, client, forward)
+	if err != nil {
+		return err
+	}
+
+	url := client.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(forward.Namespace).Name(pod).SubResource("portforward").URL()
+	dialer := spdy.NewDialer(upgrader, httpClient, http.MethodPost, url)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			close(stop)
+		case <-done:
+		}
+	}()
+
+	forwarder, err := portforward.NewOnAddresses(dialer, []string{"127.0.0.1"},
+		[]string{fmt.Sprintf("%d:%d", forward.LocalPort, podPort)}, stop, nil, io.Discard, io.Discard)
+	if err != nil {
+		return err
+	}
+	if err := forwarder.ForwardPorts(); err != nil {
+		return fmt.Errorf("forward to pod %s: %w", pod, err)
+	}
+	return fmt.Errorf("forward to pod %s stopped", pod)
+}
+
+// resolvePod picks a ready pod behind the Service and the container port the
+// Service port targets
+func resolvePod(ctx context.Context, client kubernetes.Interface, forward Forward) (string, int32, error) {
+	service, err := client.CoreV1().Services(forward.Namespace).Get(ctx, forward.Service, metav1.GetOptions{})
+	if err != nil {
+		return "", 0, err
+	}
+	var servicePort *corev1.ServicePort
+	for i := range service.Spec.Ports {
+		if int(service.Spec.Ports[i].Port) == forward.RemotePort {
+			servicePort = &service.Spec.Ports[i]
+		}
+	}
+	if servicePort == nil {
+		return "", 0, fmt.Errorf("Service %s/%s has no port %d", forward.Namespace, forward.Service, forward.RemotePort)
+	}
+	if len(service.Spec.Selector) == 0 {
+		return "", 0, fmt.Errorf("Service %s/%s has no pod selector", forward.Namespace, forward.Service)
+	}
+
+	pods, err := client.CoreV1().Pods(forward.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(service.Spec.Selector).String(),
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !isPodReady(pod) {
+			continue
+		}
+		if port, ok := targetPort(pod, servicePort); ok {
+			return pod.Name, port, nil
+		}
+	}
+	return "", 0, fmt.Errorf("no ready pod behind Service %s/%s", forward.Namespace, forward.Service)
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
+		return false
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// targetPort resolves the target port of a Service port, which may name a
+// container port, on a pod
+func targetPort(pod *corev1.Pod, servicePort *corev1.ServicePort) (int32, bool) {
+	target := servicePort.TargetPort
+	if target.StrVal == "" {
+		if target.IntVal == 0 {
+			return servicePort.Port, true
+		}
+		return target.IntVal, true
+	}
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.Name == target.StrVal {
+				return port.ContainerPort, true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,255 @@
+package preflight
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"cluster-studio/pkg/flux"
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/linkerd"
+
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CheckResult is one check reported by `flux check --pre` or `linkerd check --pre`
+type CheckResult struct {
+	Tool  string `json:"tool"`
+	Check string `json:"check"`
+	// "ok", "warning" or "error"
+	Result  string `json:"result"`
+	Message string `json:"message,omitempty"`
+}
+
+func (r CheckResult) String() string {
+	if r.Message == "" {
+		return fmt.Sprintf("%s: %s", r.Tool, r.Check)
+	}
+	return fmt.Sprintf("%s: %s: %s", r.Tool, r.Check, r.Message)
+}
+
+// ParseFluxCheck reads the checks of `flux check --pre` from its output,
+// "✔" lines passed and "✗" lines failed. The closing summary is not a check.
+func ParseFluxCheck(output string) []CheckResult {
+	var results []CheckResult
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		var result string
+		switch {
+		case strings.HasPrefix(line, "✔"):
+			result = "ok"
+		case strings.HasPrefix(line, "✗"):
+			result = "error"
+		default:
+			continue
+		}
+		check := strings.TrimSpace(strings.TrimLeft(line, "✔✗"))
+		if strings.HasPrefix(check, "prerequisites checks") {
+			continue
+		}
+		results = append(results, CheckResult{Tool: "flux", Check: check, Result: result})
+	}
+	return results
+}
+
+// Downgrade turns the failures of the checks matching one of patterns
+// (case-insensitive substrings of the check) into warnings
+func Downgrade(results []CheckResult, patterns []string) []CheckResult {
+	downgraded := make([]CheckResult, 0, len(results))
+	for _, result := range results {
+		if result.Result == "error" {
+			for _, pattern := range patterns {
+				if strings.Contains(strings.ToLower(result.Check), strings.ToLower(pattern)) {
+					result.Result = "warning"
+					break
+				}
+			}
+		}
+		downgraded = append(downgraded, result)
+	}
+	return downgraded
+}
+
+// ChecksErr returns a single error listing every failed check, or nil
+func ChecksErr(results []CheckResult) error {
+	var failed []string
+	for _, result := range results {
+		if result.Result == "error" {
+			failed = append(failed, result.String())
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("cluster pre-flight checks failed:\n  - %s", strings.Join(failed, "\n  - "))
+}
+
+// ClusterChecksArgs configures the pre-install checks
+type ClusterChecksArgs struct {
+	// Run `flux check --pre` until Flux is installed
+	Flux bool
+	// Run `linkerd check --pre` until Linkerd is installed
+	Linkerd bool
+	// Context and kubeconfig file (empty for the default one) the CLIs use
+	KubeContext    string
+	KubeconfigPath string
+	// Kubeconfig of the cluster (secret), used to tell whether the tools are
+	// already installed
+	Kubeconfig pulumi.StringInput
+	// Checks whose failure is only a warning, matched case-insensitively as
+	// substrings of the check descriptions
+	Warnings []string
+	// How long each check may take
+	Timeout time.Duration
+}
+
+// ClusterChecks runs the pre-install checks of the CLIs against the cluster
+type ClusterChecks struct {
+	pulumi.ResourceState
+
+	// Failed checks downgraded to warnings
+	Warnings pulumi.StringArrayOutput `pulumi:"warnings"`
+}
+
+// NewClusterChecks runs the checks of the tools not installed yet and records
+// their results in a ConfigMap of kube-system. The ConfigMap is only created
+// once every check passed, so resources depending on the component are not
+// installed on a cluster failing them.
+func NewClusterChecks(ctx *pulumi.Context, name string, args *ClusterChecksArgs, opts ...pulumi.ResourceOption) (*ClusterChecks, error) {
+	checks := &ClusterChecks{}
+	err := ctx.RegisterComponentResource("home:preflight:ClusterChecks", name, checks, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	results := args.Kubeconfig.ToStringOutput().ApplyT(func(kubeconfig string) ([]CheckResult, error) {
+		if ctx.DryRun() {
+			return nil, nil
+		}
+		results, err := runClusterChecks(kubeconfig, args)
+		if err != nil {
+			return nil, err
+		}
+		results = Downgrade(results, args.Warnings)
+		for _, result := range results {
+			if result.Result == "warning" {
+				_ = ctx.Log.Warn(result.String(), &pulumi.LogArgs{Resource: checks})
+			}
+		}
+		return results, ChecksErr(results)
+	})
+
+	report := results.ApplyT(func(results interface{}) (string, error) {
+		if results.([]CheckResult) == nil {
+			results = []CheckResult{}
+		}
+		data, err := json.MarshalIndent(results, "", "  ")
+		return string(data), err
+	}).(pulumi.StringOutput)
+	_, err = corev1.NewConfigMap(ctx, fmt.Sprintf("%s-report", name), &corev1.ConfigMapArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(name),
+			Namespace: pulumi.String("kube-system"),
+		},
+		Data: pulumi.StringMap{
+			"results.json": report,
+		},
+	}, pulumi.Parent(checks))
+	if err != nil {
+		return nil, err
+	}
+
+	checks.Warnings = results.ApplyT(func(results interface{}) []string {
+		var warnings []string
+		for _, result := range results.([]CheckResult) {
+			if result.Result == "warning" {
+				warnings = append(warnings, result.String())
+			}
+		}
+		return warnings
+	}).(pulumi.StringArrayOutput)
+	err = ctx.RegisterResourceOutputs(checks, pulumi.Map{
+		"warnings": checks.Warnings,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return checks, nil
+}
+
+// runClusterChecks runs the pre-install check of each enabled tool whose
+// namespace does not exist yet: the checks fail against an installation
+func runClusterChecks(kubeconfig string, args *ClusterChecksArgs) ([]CheckResult, error) {
+	client, err := kube.NewClientsetFromKubeconfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	installed := func(namespace string) (bool, error) {
+		_, err := client.CoreV1().Namespaces().Get(context.Background(), namespace, k8smetav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	var results []CheckResult
+	if args.Flux {
+		found, err := installed(flux.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			output := runFluxCheck(args)
+			fluxResults := ParseFluxCheck(output)
+			if len(fluxResults) == 0 {
+				return nil, fmt.Errorf("flux check --pre reported no checks: %s", strings.TrimSpace(output))
+			}
+			results = append(results, fluxResults...)
+		}
+	}
+	if args.Linkerd {
+		found, err := installed(linkerd.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			ctx, cancel := context.WithTimeout(context.Background(), args.Timeout)
+			checks, err := linkerd.RunCheck(ctx, args.KubeconfigPath, "check", "--pre", "--context", args.KubeContext)
+			cancel()
+			if err != nil {
+				return nil, err
+			}
+			for _, check := range checks {
+				result := check.Result
+				if result == "success" {
+					result = "ok"
+				}
+				results = append(results, CheckResult{Tool: "linkerd", Check: check.Description, Result: result, Message: check.Error})
+			}
+		}
+	}
+	return results, nil
+}
+
+// runFluxCheck runs `flux check --pre` and returns its output. flux logs the
+// checks to stderr and exits with an error when one fails, the output tells
+// which.
+func runFluxCheck(args *ClusterChecksArgs) string {
+	ctx, cancel := context.WithTimeout(context.Background(), args.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "flux", "check", "--pre", "--context", args.KubeContext)
+	if args.KubeconfigPath != "" {
+		cmd.Env = append(os.Environ(), "KUBECONFIG="+args.KubeconfigPath)
+	}
+	out, _ := cmd.CombinedOutput()
+	return string(out)
+}
@@ -0,0 +1,209 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tool is a CLI the deployment shells out to
+type Tool struct {
+	// Binary name looked up in PATH
+	Name string
+	// Arguments printing the client version
+	VersionArgs []string
+	// Minimum required version (e.g. "0.20.0"), empty for any
+	MinVersion string
+}
+
+// KnownTools lists how to query the version of each supported CLI
+var KnownTools = map[string][]string{
+	"kind":    {"version"},
+	"k3d":     {"version"},
+	"flux":    {"version", "--client"},
+	"kubectl": {"version", "--client"},
+	"linkerd": {"version", "--client", "--short"},
+	"helm":    {"version", "--short"},
+	"docker":  {"version", "--format", "{{.Client.Version}}"},
+	"pulumi":  {"version"},
+}
+
+// Report is the outcome of the pre-flight checks
+type Report struct {
+	// Detected version per tool
+	Versions map[string]string
+	// Everything that is missing or too old
+	Problems []string
+}
+
+// Err returns a single error listing every problem, or nil
+func (r *Report) Err() error {
+	if len(r.Problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("pre-flight checks failed:\n  - %s", strings.Join(r.Problems, "\n  - "))
+}
+
+// Run checks every tool and, when checkDocker is set, that the Docker daemon
+// responds. All problems are collected instead of stopping at the first one.
+func Run(tools []Tool, checkDocker bool) *Report {
+	report := &Report{Versions: map[string]string{}}
+
+	sort.Slice(tools, func(i, j int) bool { return tools[i].Name < tools[j].Name })
+	for _, tool := range tools {
+		version, err := toolVersion(tool)
+		if err != nil {
+			report.Problems = append(report.Problems, err.Error())
+			continue
+		}
+		report.Versions[tool.Name] = version
+
+		if tool.MinVersion != "" && compareVersions(version, tool.MinVersion) < 0 {
+			report.Problems = append(report.Problems,
+				fmt.Sprintf("%s %s is older than the required %s", tool.Name, version, tool.MinVersion))
+		}
+	}
+
+	if checkDocker {
+		if err := pingDocker(); err != nil {
+			report.Problems = append(report.Problems, err.Error())
+		}
+	}
+
+	return report
+}
+
+// CheckWritableDirs records a problem for every path that is not an
+// existing directory the current user can create files in
+func (r *Report) CheckWritableDirs(paths []string) {
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			r.Problems = append(r.Problems, fmt.Sprintf("host path %s: %v", path, err))
+			continue
+		}
+		if !info.IsDir() {
+			r.Problems = append(r.Problems, fmt.Sprintf("host path %s is not a directory", path))
+			continue
+		}
+		probe, err := os.CreateTemp(path, ".preflight-*")
+		if err != nil {
+			r.Problems = append(r.Problems, fmt.Sprintf("host path %s is not writable: %v", path, err))
+			continue
+		}
+		probe.Close()
+		os.Remove(probe.Name())
+	}
+}
+
+// CheckPortFree records a problem when address:port can't be listened on,
+// unless it is published by the owner container, the node of a cluster
+// created before
+func (r *Report) CheckPortFree(address string, port int, owner string) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(address, strconv.Itoa(port)))
+	if err == nil {
+		listener.Close()
+		return
+	}
+	out, dockerErr := exec.Command("docker", "port", owner).Output()
+	if dockerErr == nil && strings.Contains(string(out), fmt.Sprintf(":%d\n", port)) {
+		return
+	}
+	r.Problems = append(r.Problems, fmt.Sprintf("port %d on %s is in use: %v", port, address, err))
+}
+
+var versionPattern = regexp.MustCompile(`v?(\d+)\.(\d+)(?:\.(\d+))?`)
+
+func toolVersion(tool Tool) (string, error) {
+	path, err := exec.LookPath(tool.Name)
+	if err != nil {
+		return "", fmt.Errorf("%s not found in PATH", tool.Name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, path, tool.VersionArgs...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s %s failed: %v: %s", tool.Name, strings.Join(tool.VersionArgs, " "), err, strings.TrimSpace(string(out)))
+	}
+
+	match := versionPattern.FindString(string(out))
+	if match == "" {
+		return "", fmt.Errorf("could not detect %s version from %q", tool.Name, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimPrefix(match, "v"), nil
+}
+
+// compareVersions compares dotted numeric versions, returning -1, 0 or 1
+func compareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < 3; i++ {
+		switch {
+		case pa[i] < pb[i]:
+			return -1
+		case pa[i] > pb[i]:
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionParts(v string) [3]int {
+	var parts [3]int
+	match := versionPattern.FindStringSubmatch(v)
+	if match == nil {
+		return parts
+	}
+	for i := 0; i < 3; i++ {
+		parts[i], _ = strconv.Atoi(match[i+1])
+	}
+	return parts
+}
+
+// pingDocker calls /_ping on the Docker socket ($DOCKER_HOST or the default unix socket)
+func pingDocker() error {
+	socket := "/var/run/docker.sock"
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		if !strings.HasPrefix(host, "unix://") {
+			// Remote daemons are only checked through the docker CLI
+			return dockerInfo()
+		}
+		socket = strings.TrimPrefix(host, "unix://")
+	}
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	resp, err := client.Get("http://docker/_ping")
+	if err != nil {
+		return fmt.Errorf("Docker daemon is not reachable on %s: %v", socket, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Docker daemon on %s responded with %s", socket, resp.Status)
+	}
+	return nil
+}
+
+func dockerInfo() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "docker", "info", "--format", "{{.ServerVersion}}").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Docker daemon is not reachable: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package sealedsecrets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"cluster-studio/pkg/kube"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// WaitForCertificate polls the controller through the API server service
+// proxy until it serves its active public certificate, and returns it
+func WaitForCertificate(ctx context.Context, client kubernetes.Interface, opts kube.PollOptions) (string, error) {
+	if opts.Description == "" {
+		opts.Description = "the Sealed Secrets controller to serve its certificate"
+	}
+
+	var cert string
+	err := kube.Poll(ctx, opts, func(ctx context.Context) (bool, string, error) {
+		data, err := client.CoreV1().Services(Namespace).ProxyGet("http", ControllerName, "8080", "/v1/cert.pem", nil).DoRaw(ctx)
+		if err != nil {
+			return false, err.Error(), nil
+		}
+		if !strings.Contains(string(data), "BEGIN CERTIFICATE") {
+			return false, fmt.Sprintf("unexpected response %q", truncate(string(data), 80)), nil
+		}
+		cert = string(data)
+		return true, "", nil
+	})
+	return cert, err
+}
+
+// truncate shortens s to at most n bytes
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,145 @@
+package sealedsecrets
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/stacks"
+
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// ChartRepo is the Helm repository serving the Sealed Secrets chart
+	ChartRepo = "https://bitnami-labs.github.io/sealed-secrets"
+	// Namespace and ControllerName are the defaults of kubeseal, so it finds
+	// the controller without --controller-namespace or --controller-name
+	Namespace      = "kube-system"
+	ControllerName = "sealed-secrets-controller"
+	// keyLabel marks the Secrets holding the sealing key pairs
+	keyLabel = "sealedsecrets.bitnami.com/sealed-secrets-key"
+)
+
+// KeyPair is a sealing key pair brought along instead of a generated one, so
+// the SealedSecrets of the tree can still be decrypted on a recreated cluster
+type KeyPair struct {
+	// PEM encoded certificate and private key (secrets)
+	Certificate pulumi.StringInput
+	PrivateKey  pulumi.StringInput
+}
+
+// ControllerArgs configures the Sealed Secrets controller
+type ControllerArgs struct {
+	// Chart version of the controller
+	Version string
+	// Key pair of the controller, generated by it when nil
+	KeyPair *KeyPair
+	// Kubeconfig of the cluster (secret), used to fetch the certificate
+	Kubeconfig pulumi.StringInput
+	// How long to wait for the controller and its certificate
+	Timeout time.Duration
+}
+
+// Controller is the Sealed Secrets controller
+type Controller struct {
+	pulumi.ResourceState
+
+	// Active public certificate, for kubeseal --cert
+	Certificate pulumi.StringOutput `pulumi:"certificate"`
+}
+
+// NewController installs the Sealed Secrets controller with Helm, with the
+// given key pair when there is one, and fetches the public certificate it
+// seals with. Its status is recorded in a ConfigMap of kube-system once the
+// certificate is served, so resources depending on the component (e.g. Flux
+// applying SealedSecrets) find the controller up.
+func NewController(ctx *pulumi.Context, name string, args *ControllerArgs, opts ...pulumi.ResourceOption) (*Controller, error) {
+	controller := &Controller{}
+	err := ctx.RegisterComponentResource("home:sealedsecrets:Controller", name, controller, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	logf := func(format string, a ...interface{}) {
+		_ = ctx.Log.Info(fmt.Sprintf(format, a...), &pulumi.LogArgs{Resource: controller})
+	}
+
+	// The controller picks up labelled key pairs at startup, so the Secret
+	// must exist before the release
+	releaseOpts := []pulumi.ResourceOption{pulumi.Parent(controller)}
+	if args.KeyPair != nil {
+		key, err := corev1.NewSecret(ctx, fmt.Sprintf("%s-key", name), &corev1.SecretArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:      pulumi.String(ControllerName + "-key"),
+				Namespace: pulumi.String(Namespace),
+				Labels:    pulumi.StringMap{keyLabel: pulumi.String("active")},
+			},
+			Type: pulumi.String("kubernetes.io/tls"),
+			StringData: pulumi.StringMap{
+				"tls.crt": args.KeyPair.Certificate,
+				"tls.key": args.KeyPair.PrivateKey,
+			},
+		}, pulumi.Parent(controller))
+		if err != nil {
+			return nil, err
+		}
+		releaseOpts = append(releaseOpts, pulumi.DependsOn([]pulumi.Resource{key}))
+	}
+
+	release, err := helmv3.NewRelease(ctx, stacks.Name(ctx, "sealed-secrets"), &helmv3.ReleaseArgs{
+		Name:           pulumi.String(ControllerName),
+		Chart:          pulumi.String("sealed-secrets"),
+		Version:        pulumi.String(args.Version),
+		Namespace:      pulumi.String(Namespace),
+		RepositoryOpts: helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+		Timeout:        pulumi.Int(int(args.Timeout.Seconds())),
+		Values: pulumi.Map{
+			"fullnameOverride": pulumi.String(ControllerName),
+		},
+	}, releaseOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	certificate := pulumi.All(release.ID(), args.Kubeconfig).ApplyT(func(values []interface{}) (string, error) {
+		if ctx.DryRun() {
+			return "", nil
+		}
+		client, err := kube.NewClientsetFromKubeconfig(values[1].(string))
+		if err != nil {
+			return "", err
+		}
+		return WaitForCertificate(context.Background(), client, kube.PollOptions{
+			Timeout: args.Timeout,
+			Logf:    logf,
+		})
+	}).(pulumi.StringOutput)
+
+	_, err = corev1.NewConfigMap(ctx, fmt.Sprintf("%s-report", name), &corev1.ConfigMapArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(name),
+			Namespace: pulumi.String("kube-system"),
+		},
+		Data: pulumi.StringMap{
+			"certificate": certificate,
+		},
+	}, pulumi.Parent(controller))
+	if err != nil {
+		return nil, err
+	}
+
+	controller.Certificate = certificate
+	err = ctx.RegisterResourceOutputs(controller, pulumi.Map{
+		"certificate": controller.Certificate,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return controller, nil
+}
@@ -0,0 +1,35 @@
+package shell
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Checksum returns the sha256 of the files at paths, the files of a directory
+// in lexical order, names included. Passed as the triggers of a command
+// running them, an edit re-runs the command.
+func Checksum(paths ...string) (string, error) {
+	hash := sha256.New()
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(hash, "%s %d\n", filepath.ToSlash(file), len(data))
+			hash.Write(data)
+			return nil
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to checksum %s: %w", path, err)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,91 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// TimeoutExitCode is the exit code of a guarded command that timed out
+const TimeoutExitCode = 124
+
+// GuardOptions configures Guard
+type GuardOptions struct {
+	// Name of the step, used in the timeout error (e.g. "flux install")
+	Step string
+	// How long the command may run, zero for no limit
+	Timeout time.Duration
+	// Command printing the state of what was being waited for, run after a
+	// timeout so the error shows how far the step got (optional)
+	Diagnose string
+}
+
+// Guard wraps a shell script so that it is stopped, together with every
+// process it started, when it exceeds the timeout or when the command itself
+// is interrupted or killed (e.g. Ctrl-C on `pulumi up`). A timeout exits with
+// TimeoutExitCode after printing the step, the timeout and the output of
+// Diagnose to stderr. Any other failure repeats the end of the stderr of
+// script.
+func Guard(script string, opts GuardOptions) string {
+	seconds := int(opts.Timeout.Seconds())
+	timedOut := "false"
+	if seconds > 0 {
+		timedOut = fmt.Sprintf(`[ $(( $(date +%%s) - start )) -ge %d ]`, seconds)
+	}
+
+	diagnose := ""
+	if opts.Diagnose != "" {
+		diagnose = fmt.Sprintf("\n  echo \"last observed state:\" >&2\n  { %s; } >&2 2>&1 || true", opts.Diagnose)
+	}
+
+	return fmt.Sprintf(`kill_tree() {
+  for child in $(pgrep -P "$1"); do kill_tree "$child" "$2"; done
+  kill "-$2" "$1" 2>/dev/null
+}
+marker=$(mktemp)
+rm -f "$marker"
+sh -c %[1]s &
+pid=$!
+parent=$$
+trap 'kill_tree $pid TERM' INT TERM HUP
+(
+  start=$(date +%%s)
+  while kill -0 $pid 2>/dev/null; do
+    if ! kill -0 $parent 2>/dev/null; then
+      kill_tree $pid TERM
+      exit
+    fi
+    if %[2]s; then
+      touch "$marker"
+      kill_tree $pid TERM
+      sleep 10
+      kill_tree $pid KILL
+      exit
+    fi
+    sleep 1
+  done
+) >/dev/null 2>&1 &
+watchdog=$!
+status=0
+wait $pid || status=$?
+kill $watchdog 2>/dev/null
+if [ -e "$marker" ]; then
+  rm -f "$marker"
+  echo "%[3]s timed out after %[4]s" >&2%[5]s
+  exit %[6]d
+fi
+exit $status`, Quote(tailOnFailure(script, opts.Step)), timedOut, opts.Step, opts.Timeout, diagnose, TimeoutExitCode)
+}
+
+// Interpreter returns the interpreter of the local commands: a POSIX shell
+// whatever the platform default is, which the command strings are written for
+func Interpreter() pulumi.StringArray {
+	return pulumi.StringArray{pulumi.String("/bin/sh"), pulumi.String("-c")}
+}
+
+// Quote returns s as a single-quoted shell word
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
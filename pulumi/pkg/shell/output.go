@@ -0,0 +1,68 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// FailureTailLines is how many lines of stderr Guard repeats after a
+	// failure, so they end up at the bottom of the error
+	FailureTailLines = 30
+	// MaxLineLength caps the lines returned by Tail
+	MaxLineLength = 300
+)
+
+// Output is the stdout and stderr of a command resource
+type Output struct {
+	Stdout pulumi.StringOutput
+	Stderr pulumi.StringOutput
+}
+
+// CommandOutput returns the output of command, resolving once it has run
+func CommandOutput(command *local.Command) *Output {
+	return &Output{Stdout: command.Stdout, Stderr: command.Stderr}
+}
+
+// Tail returns the last lines of stdout and stderr, keyed by stream
+func (o *Output) Tail(lines int) pulumi.StringMapOutput {
+	return pulumi.All(o.Stdout, o.Stderr).ApplyT(func(streams []interface{}) map[string]string {
+		return map[string]string{
+			"stdout": Tail(streams[0].(string), lines),
+			"stderr": Tail(streams[1].(string), lines),
+		}
+	}).(pulumi.StringMapOutput)
+}
+
+// Tail returns the last lines of output, each cut to MaxLineLength
+func Tail(output string, lines int) string {
+	all := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	for i, line := range all {
+		if len(line) > MaxLineLength {
+			all[i] = line[:MaxLineLength] + "..."
+		}
+	}
+	return strings.Join(all, "\n")
+}
+
+// tailOnFailure wraps a shell script so that when it fails the last
+// FailureTailLines lines of its stderr are printed again, after whatever
+// else it printed. A script killed by a signal is left to Guard.
+func tailOnFailure(script, step string) string {
+	return fmt.Sprintf(`log=$(mktemp)
+rc=$(mktemp)
+trap 'rm -f "$log" "$rc"' EXIT
+{ { sh -c %[1]s 2>&1 1>&3 3>&-; echo $? >"$rc"; } | tee "$log" >&2; } 3>&1
+status=$(cat "$rc")
+if [ "$status" -ne 0 ] && [ "$status" -le 128 ] && [ -s "$log" ]; then
+  echo "%[2]s failed with exit status $status, last %[3]d lines of stderr:" >&2
+  tail -n %[3]d "$log" | sed 's/^/  /' >&2
+fi
+exit "$status"`, Quote(script), strings.ReplaceAll(step, `"`, `\"`), FailureTailLines)
+}
@@ -0,0 +1,66 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RetryablePatterns match the output of a failed attempt that is worth
+// retrying: the API server or a webhook wasn't ready yet, or the network was
+const RetryablePatterns = "connection refused|connection reset|i/o timeout|TLS handshake timeout|" +
+	"context deadline exceeded|timed out|timeout|failed calling webhook|no endpoints available|" +
+	"the server is currently unable to handle the request|ServiceUnavailable|etcdserver: leader changed|" +
+	"too many requests"
+
+// PermanentPatterns match the output of a failure retrying won't fix. They
+// take precedence over RetryablePatterns (e.g. an invalid --timeout flag).
+const PermanentPatterns = "unknown flag|unknown command|invalid argument|forbidden|unauthorized|" +
+	"no such file or directory|command not found"
+
+// RetryPolicy controls how often a failing command is retried
+type RetryPolicy struct {
+	// Total number of attempts, 1 or less disables retries
+	Attempts int
+	// Delay before the first retry, doubled for every further retry up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// Retry wraps a shell script so that it is run again, after a growing delay,
+// as long as it fails with output matching RetryablePatterns and none of
+// PermanentPatterns. Every retry is reported on stderr with the matched
+// reason. A script killed by a signal is never retried.
+func Retry(script, step string, policy RetryPolicy) string {
+	if policy.Attempts <= 1 {
+		return script
+	}
+	backoff := int(policy.Backoff.Seconds())
+	if backoff < 1 {
+		backoff = 1
+	}
+	maxBackoff := int(policy.MaxBackoff.Seconds())
+	if maxBackoff < backoff {
+		maxBackoff = backoff
+	}
+
+	return fmt.Sprintf(`attempt=1
+delay=%[2]d
+log=$(mktemp)
+rc=$(mktemp)
+trap 'rm -f "$log" "$rc"' EXIT
+while :; do
+  { { sh -c %[1]s 2>&1 1>&3 3>&-; echo $? >"$rc"; } | tee "$log" >&2; } 3>&1
+  status=$(cat "$rc")
+  [ "$status" -eq 0 ] && exit 0
+  if [ $attempt -ge %[4]d ] || [ "$status" -gt 128 ] || grep -qiE %[5]s "$log" || ! grep -qiE %[6]s "$log"; then
+    exit "$status"
+  fi
+  reason=$(grep -oiE %[6]s "$log" | head -n 1)
+  echo "%[7]s failed (attempt $attempt/%[4]d: $reason), retrying in ${delay}s" >&2
+  sleep $delay
+  attempt=$((attempt + 1))
+  delay=$((delay * 2))
+  [ $delay -gt %[3]d ] && delay=%[3]d
+done`, Quote(script), backoff, maxBackoff, policy.Attempts, Quote(PermanentPatterns), Quote(RetryablePatterns), strings.ReplaceAll(step, `"`, `\"`))
+}
@@ -0,0 +1,204 @@
+package smoketest
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/shell"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultImage runs the checks when no image is configured
+const DefaultImage = "curlimages/curl:8.10.1"
+
+// requestTimeout bounds each request of the test pod
+const requestTimeout = 10 * time.Second
+
+// Check is an endpoint requested from inside the cluster
+type Check struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Expected HTTP status, any 2xx or 3xx when 0
+	ExpectStatus int `json:"expectStatus,omitempty"`
+	// A failing optional check is reported without failing the deploy
+	Optional bool `json:"optional,omitempty"`
+}
+
+// Result is the outcome of a Check
+type Result struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Passed   bool   `json:"passed"`
+	Optional bool   `json:"optional"`
+	// HTTP status, 0 when no response was received
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Options configures Run
+type Options struct {
+	// Namespace the test pod runs in
+	Namespace string
+	// Image with sh and curl
+	Image string
+	// How long the test pod may take to run every check
+	Timeout time.Duration
+	// Logf receives the progress of the test pod (optional)
+	Logf kube.Logf
+	// Trace ID (32 hex digits) of sampled W3C and B3 trace context headers
+	// sent with every request, so the mesh proxies record spans, empty for
+	// none
+	TraceID string
+}
+
+// Run requests every check from a short-lived pod and returns one result per
+// check. The pod is deleted afterwards, whatever the outcome.
+func Run(ctx context.Context, client kubernetes.Interface, checks []Check, opts Options) ([]Result, error) {
+	if opts.Image == "" {
+		opts.Image = DefaultImage
+	}
+
+	pod, err := client.CoreV1().Pods(opts.Namespace).Create(ctx, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "smoke-test-",
+			// A proxy sidecar would keep the pod running after the checks
+			Labels: map[string]string{
+				"app.kubernetes.io/name":  "smoke-test",
+				"sidecar.istio.io/inject": "false",
+			},
+			Annotations: map[string]string{"linkerd.io/inject": "disabled"},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			Containers: []corev1.Container{{
+				Name:    "curl",
+				Image:   opts.Image,
+				Command: []string{"sh", "-c", script(checks, opts.TraceID)},
+			}},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the smoke test pod: %w", err)
+	}
+	defer func() {
+		_ = client.CoreV1().Pods(opts.Namespace).Delete(context.Background(), pod.Name, metav1.DeleteOptions{})
+	}()
+
+	var phase corev1.PodPhase
+	var message string
+	err = kube.Poll(ctx, kube.PollOptions{
+		Description: fmt.Sprintf("smoke test pod %s/%s to complete", opts.Namespace, pod.Name),
+		Interval:    2 * time.Second,
+		Timeout:     opts.Timeout,
+		Logf:        opts.Logf,
+	}, func(ctx context.Context) (bool, string, error) {
+		current, err := client.CoreV1().Pods(opts.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, "", err
+		}
+		phase, message = current.Status.Phase, current.Status.Message
+		return phase == corev1.PodSucceeded || phase == corev1.PodFailed, string(phase), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if phase == corev1.PodFailed {
+		return nil, fmt.Errorf("smoke test pod %s/%s failed: %s", opts.Namespace, pod.Name, message)
+	}
+
+	logs, err := client.CoreV1().Pods(opts.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{}).DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the smoke test pod logs: %w", err)
+	}
+	return parse(checks, string(logs)), nil
+}
+
+// script requests every check in turn and prints one
+// "smoke-test <index> <http status> <curl exit code>" line per check
+func script(checks []Check, traceID string) string {
+	var b strings.Builder
+	for i, check := range checks {
+		var headers string
+		if traceID != "" {
+			// One span per check, all in the same trace
+			spanID := fmt.Sprintf("%016x", i+1)
+			headers = fmt.Sprintf("-H %s -H %s ",
+				shell.Quote(fmt.Sprintf("traceparent: 00-%s-%s-01", traceID, spanID)),
+				shell.Quote(fmt.Sprintf("b3: %s-%s-1", traceID, spanID)))
+		}
+		fmt.Fprintf(&b, "status=$(curl -s -o /dev/null -w '%%{http_code}' --max-time %d %s%s); echo \"smoke-test %d ${status:-000} $?\"\n",
+			int(requestTimeout.Seconds()), headers, shell.Quote(check.URL), i)
+	}
+	return b.String()
+}
+
+// parse reads the lines printed by script. A check without a line failed to run.
+func parse(checks []Check, logs string) []Result {
+	results := make([]Result, len(checks))
+	for i, check := range checks {
+		results[i] = Result{Name: check.Name, URL: check.URL, Optional: check.Optional, Error: "not run"}
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(logs))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 4 || fields[0] != "smoke-test" {
+			continue
+		}
+		i, err := strconv.Atoi(fields[1])
+		if err != nil || i < 0 || i >= len(checks) {
+			continue
+		}
+		status, _ := strconv.Atoi(fields[2])
+		exitCode, _ := strconv.Atoi(fields[3])
+
+		result := &results[i]
+		result.Status = status
+		result.Error = ""
+		switch {
+		case exitCode != 0:
+			result.Error = curlError(exitCode)
+		case checks[i].ExpectStatus != 0 && status != checks[i].ExpectStatus:
+			result.Error = fmt.Sprintf("status %d, want %d", status, checks[i].ExpectStatus)
+		case checks[i].ExpectStatus == 0 && (status < 200 || status >= 400):
+			result.Error = fmt.Sprintf("status %d", status)
+		default:
+			result.Passed = true
+		}
+	}
+	return results
+}
+
+// curlError describes the curl exit codes of unreachable endpoints
+func curlError(exitCode int) string {
+	switch exitCode {
+	case 6:
+		return "could not resolve host"
+	case 7:
+		return "connection refused"
+	case 28:
+		return fmt.Sprintf("no response within %s", requestTimeout)
+	case 35, 60:
+		return "TLS handshake failed"
+	}
+	return fmt.Sprintf("curl exited with %d", exitCode)
+}
+
+// FailedMandatory returns the failed checks that are not optional
+func FailedMandatory(results []Result) []Result {
+	var failed []Result
+	for _, result := range results {
+		if !result.Passed && !result.Optional {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
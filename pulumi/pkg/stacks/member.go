@@ -0,0 +1,76 @@
+package stacks
+
+import (
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Member is a cluster deployed by an umbrella stack, in the same program as
+// the other members
+type Member struct {
+	// Name of the cluster, which prefixes the names of its resources
+	Name string
+	// Other members of the umbrella stack, which it can peer with
+	Siblings []string
+	// Resources of the members it is deployed after
+	DependsOn []pulumi.Resource
+	// Resources deployed for the cluster, what the members deployed after
+	// it wait for
+	Resources []pulumi.Resource
+}
+
+// memberKey holds the member a context deploys
+type memberKey struct{}
+
+// With returns a context deploying the resources of member
+func With(ctx *pulumi.Context, member *Member) *pulumi.Context {
+	return ctx.WithValue(memberKey{}, member)
+}
+
+// MemberOf returns the member ctx deploys, nil for a stack deploying its own
+// cluster
+func MemberOf(ctx *pulumi.Context) *Member {
+	member, _ := ctx.Value(memberKey{}).(*Member)
+	return member
+}
+
+// Name returns the name of a resource deployed with ctx, prefixed with the
+// member so the members don't collide. It is unchanged for a stack deploying
+// its own cluster, whose resources keep their URNs.
+func Name(ctx *pulumi.Context, name string) string {
+	if member := MemberOf(ctx); member != nil {
+		return member.Name + "-" + name
+	}
+	return name
+}
+
+// Prefix returns what the names of the resources deployed with ctx start
+// with, empty for a stack deploying its own cluster. It prefixes the
+// resources named after their objects, e.g. those of a kustomize directory.
+func Prefix(ctx *pulumi.Context) string {
+	if member := MemberOf(ctx); member != nil {
+		return member.Name
+	}
+	return ""
+}
+
+// Owns reports whether the resource registered as name is deployed with ctx,
+// and returns its name without the prefix of the member. A stack
+// transformation registered by a member applies to its resources only.
+func Owns(ctx *pulumi.Context, name string) (string, bool) {
+	member := MemberOf(ctx)
+	if member == nil {
+		return name, true
+	}
+	return strings.CutPrefix(name, member.Name+"-")
+}
+
+// Stack returns the stack whose defaults ctx deploys with: the member, or the
+// current stack
+func Stack(ctx *pulumi.Context) string {
+	if member := MemberOf(ctx); member != nil {
+		return member.Name
+	}
+	return ctx.Stack()
+}
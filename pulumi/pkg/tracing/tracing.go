@@ -0,0 +1,315 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/monitoring"
+
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// ChartRepo is the Helm repository serving the tempo chart
+	ChartRepo = "https://grafana.github.io/helm-charts"
+	// Namespace is where Tempo is installed, the one of the tempo component
+	// of the infrastructure tree
+	Namespace = "tempo"
+	// ReleaseName matches the HelmRelease of the infrastructure tree
+	ReleaseName = "tempo"
+	// HTTPPort serves the Tempo API
+	HTTPPort = 3200
+	// URL is the in-cluster URL of the Tempo API
+	URL = "http://" + ReleaseName + "." + Namespace + ".svc.cluster.local:3200"
+	// OTLPGRPCEndpoint and OTLPHTTPEndpoint receive OTLP spans
+	OTLPGRPCEndpoint = ReleaseName + "." + Namespace + ".svc.cluster.local:4317"
+	OTLPHTTPEndpoint = "http://" + ReleaseName + "." + Namespace + ".svc.cluster.local:4318"
+)
+
+// Receiver protocols Tempo can accept spans with
+const (
+	ReceiverOTLPGRPC = "otlp-grpc"
+	ReceiverOTLPHTTP = "otlp-http"
+	ReceiverJaeger   = "jaeger"
+)
+
+// Receivers are the supported receiver protocols
+var Receivers = []string{ReceiverOTLPGRPC, ReceiverOTLPHTTP, ReceiverJaeger}
+
+// TempoArgs configures the Tempo installation
+type TempoArgs struct {
+	// Chart version
+	Version string
+	// How long Tempo keeps traces (e.g. "72h")
+	Retention string
+	// Protocols the distributor receives spans with, see Receivers
+	Receivers []string
+	// Keep the traces on a persistent volume of StorageSize instead of an
+	// emptyDir volume lost with the pod
+	Persistence bool
+	StorageSize string
+	// Provision a Tempo datasource for the Grafana of the monitoring stack
+	GrafanaDatasource bool
+	// S3 bucket Tempo keeps the traces in instead of the filesystem
+	// (optional)
+	ObjectStorage *ObjectStorage
+	// Kubeconfig of the cluster (secret), used to wait for Tempo to be ready
+	Kubeconfig pulumi.StringInput
+	// How long to wait for the release and for Tempo to be ready
+	Timeout time.Duration
+}
+
+// ObjectStorage is an S3 bucket, e.g. one of the in-cluster MinIO
+type ObjectStorage struct {
+	// URL of the S3 API (e.g. http://minio.minio.svc.cluster.local:9000)
+	Endpoint pulumi.StringInput
+	Bucket   string
+	// Credentials (secrets)
+	AccessKey pulumi.StringInput
+	SecretKey pulumi.StringInput
+}
+
+// Tempo is Grafana Tempo in single-binary mode
+type Tempo struct {
+	pulumi.ResourceState
+
+	// In-cluster URL of the Tempo API
+	URL pulumi.StringOutput `pulumi:"url"`
+	// OTLP endpoints, resolved once Tempo is ready. Empty when the protocol
+	// isn't received.
+	OTLPEndpoint     pulumi.StringOutput `pulumi:"otlpEndpoint"`
+	OTLPHTTPEndpoint pulumi.StringOutput `pulumi:"otlpHttpEndpoint"`
+}
+
+// NewTempo installs Tempo with Helm and waits for its ready endpoint
+func NewTempo(ctx *pulumi.Context, name string, args *TempoArgs, opts ...pulumi.ResourceOption) (*Tempo, error) {
+	tempo := &Tempo{}
+	err := ctx.RegisterComponentResource("home:tracing:Tempo", name, tempo, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace, err := corev1.NewNamespace(ctx, fmt.Sprintf("%s-namespace", name), &corev1.NamespaceArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name: pulumi.String(Namespace),
+		},
+	}, pulumi.Parent(tempo))
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := helmv3.NewRelease(ctx, fmt.Sprintf("%s-release", name), &helmv3.ReleaseArgs{
+		Name:           pulumi.String(ReleaseName),
+		Chart:          pulumi.String("tempo"),
+		Version:        pulumi.String(args.Version),
+		Namespace:      namespace.Metadata.Name(),
+		RepositoryOpts: helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+		Timeout:        pulumi.Int(int(args.Timeout.Seconds())),
+		Values:         tempoValues(args),
+	}, pulumi.Parent(tempo))
+	if err != nil {
+		return nil, err
+	}
+
+	// The Grafana sidecar of kube-prometheus-stack loads the datasources of
+	// the ConfigMaps labelled grafana_datasource in its namespace
+	if args.GrafanaDatasource {
+		_, err = corev1.NewConfigMap(ctx, fmt.Sprintf("%s-grafana-datasource", name), &corev1.ConfigMapArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:      pulumi.String("tempo-datasource"),
+				Namespace: pulumi.String(monitoring.Namespace),
+				Labels:    pulumi.StringMap{"grafana_datasource": pulumi.String("1")},
+			},
+			Data: pulumi.StringMap{
+				"tempo.yaml": pulumi.String(fmt.Sprintf(`apiVersion: 1
+datasources:
+  - name: Tempo
+    type: tempo
+    uid: tempo
+    access: proxy
+    url: %s
+`, URL)),
+			},
+		}, pulumi.Parent(tempo), pulumi.DependsOn([]pulumi.Resource{release}))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ready := pulumi.All(release.Status, args.Kubeconfig).ApplyT(func(all []interface{}) (string, error) {
+		if ctx.DryRun() {
+			return URL, nil
+		}
+		client, err := kube.NewClientsetFromKubeconfig(all[1].(string))
+		if err != nil {
+			return "", err
+		}
+		err = WaitForReady(context.Background(), client, kube.PollOptions{
+			Timeout: args.Timeout,
+			Logf: func(format string, a ...interface{}) {
+				_ = ctx.Log.Info(fmt.Sprintf(format, a...), &pulumi.LogArgs{Resource: tempo})
+			},
+		})
+		return URL, err
+	}).(pulumi.StringOutput)
+	tempo.URL = ready
+	tempo.OTLPEndpoint = endpointWhenReady(ready, args.Receivers, ReceiverOTLPGRPC, OTLPGRPCEndpoint)
+	tempo.OTLPHTTPEndpoint = endpointWhenReady(ready, args.Receivers, ReceiverOTLPHTTP, OTLPHTTPEndpoint)
+	err = ctx.RegisterResourceOutputs(tempo, pulumi.Map{
+		"url":              tempo.URL,
+		"otlpEndpoint":     tempo.OTLPEndpoint,
+		"otlpHttpEndpoint": tempo.OTLPHTTPEndpoint,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tempo, nil
+}
+
+// endpointWhenReady returns endpoint once ready resolves, or an empty string
+// when receiver isn't one of receivers
+func endpointWhenReady(ready pulumi.StringOutput, receivers []string, receiver, endpoint string) pulumi.StringOutput {
+	return ready.ApplyT(func(string) string {
+		for _, enabled := range receivers {
+			if enabled == receiver {
+				return endpoint
+			}
+		}
+		return ""
+	}).(pulumi.StringOutput)
+}
+
+// tempoValues assembles the values of the tempo chart, which runs Tempo as a
+// single binary. The traces go to the object storage when set, otherwise to
+// the filesystem, an emptyDir without persistence.
+func tempoValues(args *TempoArgs) pulumi.Map {
+	receivers := pulumi.Map{}
+	otlp := pulumi.Map{}
+	for _, receiver := range args.Receivers {
+		switch receiver {
+		case ReceiverOTLPGRPC:
+			otlp["grpc"] = pulumi.Map{"endpoint": pulumi.String("0.0.0.0:4317")}
+		case ReceiverOTLPHTTP:
+			otlp["http"] = pulumi.Map{"endpoint": pulumi.String("0.0.0.0:4318")}
+		case ReceiverJaeger:
+			receivers["jaeger"] = pulumi.Map{
+				"protocols": pulumi.Map{
+					"grpc":        pulumi.Map{"endpoint": pulumi.String("0.0.0.0:14250")},
+					"thrift_http": pulumi.Map{"endpoint": pulumi.String("0.0.0.0:14268")},
+				},
+			}
+		}
+	}
+	if len(otlp) > 0 {
+		receivers["otlp"] = pulumi.Map{"protocols": otlp}
+	}
+
+	trace := pulumi.Map{
+		"backend": pulumi.String("local"),
+		"local":   pulumi.Map{"path": pulumi.String("/var/tempo/traces")},
+		"wal":     pulumi.Map{"path": pulumi.String("/var/tempo/wal")},
+	}
+	if s3 := args.ObjectStorage; s3 != nil {
+		// Tempo takes the host and port, and whether to use plain HTTP
+		endpoint := s3.Endpoint.ToStringOutput()
+		trace = pulumi.Map{
+			"backend": pulumi.String("s3"),
+			"s3": pulumi.Map{
+				"bucket": pulumi.String(s3.Bucket),
+				"endpoint": endpoint.ApplyT(func(endpoint string) string {
+					return strings.TrimPrefix(strings.TrimPrefix(endpoint, "https://"), "http://")
+				}),
+				"access_key":     s3.AccessKey,
+				"secret_key":     s3.SecretKey,
+				"forcepathstyle": pulumi.Bool(true),
+				"insecure": endpoint.ApplyT(func(endpoint string) bool {
+					return strings.HasPrefix(endpoint, "http://")
+				}),
+			},
+			"wal": pulumi.Map{"path": pulumi.String("/var/tempo/wal")},
+		}
+	}
+
+	persistence := pulumi.Map{"enabled": pulumi.Bool(false)}
+	if args.Persistence {
+		persistence = pulumi.Map{
+			"enabled": pulumi.Bool(true),
+			"size":    pulumi.String(args.StorageSize),
+		}
+	}
+
+	return pulumi.Map{
+		"tempo": pulumi.Map{
+			"retention": pulumi.String(args.Retention),
+			"receivers": receivers,
+			"storage":   pulumi.Map{"trace": trace},
+		},
+		"persistence": persistence,
+	}
+}
+
+// WaitForReady polls the ready endpoint of Tempo through the API server until
+// it answers
+func WaitForReady(ctx context.Context, client kubernetes.Interface, opts kube.PollOptions) error {
+	if opts.Description == "" {
+		opts.Description = fmt.Sprintf("Tempo %s/%s to be ready", Namespace, ReleaseName)
+	}
+	return kube.Poll(ctx, opts, func(ctx context.Context) (bool, string, error) {
+		body, err := client.CoreV1().Services(Namespace).ProxyGet("http", ReleaseName, fmt.Sprint(HTTPPort), "/ready", nil).DoRaw(ctx)
+		if err != nil {
+			return false, "", err
+		}
+		return true, strings.TrimSpace(string(body)), nil
+	})
+}
+
+// trace is the part of a trace returned by the Tempo API that is counted, in
+// the OTLP JSON format of both API versions
+type trace struct {
+	Batches []batch `json:"batches"`
+	Trace   struct {
+		ResourceSpans []batch `json:"resourceSpans"`
+	} `json:"trace"`
+}
+
+type batch struct {
+	ScopeSpans []struct {
+		Spans []json.RawMessage `json:"spans"`
+	} `json:"scopeSpans"`
+}
+
+// WaitForTrace polls Tempo through the API server until it returns spans of
+// traceID, and returns how many it has
+func WaitForTrace(ctx context.Context, client kubernetes.Interface, traceID string, opts kube.PollOptions) (int, error) {
+	if opts.Description == "" {
+		opts.Description = fmt.Sprintf("trace %s in Tempo", traceID)
+	}
+	var spans int
+	err := kube.Poll(ctx, opts, func(ctx context.Context) (bool, string, error) {
+		body, err := client.CoreV1().Services(Namespace).ProxyGet("http", ReleaseName, fmt.Sprint(HTTPPort), "/api/traces/"+traceID, nil).DoRaw(ctx)
+		if err != nil {
+			return false, "", err
+		}
+		var found trace
+		if err := json.Unmarshal(body, &found); err != nil {
+			return false, "", fmt.Errorf("invalid trace: %w", err)
+		}
+		spans = 0
+		for _, batch := range append(found.Batches, found.Trace.ResourceSpans...) {
+			for _, scope := range batch.ScopeSpans {
+				spans += len(scope.Spans)
+			}
+		}
+		return spans > 0, fmt.Sprintf("%d spans", spans), nil
+	})
+	return spans, err
+}
@@ -0,0 +1,100 @@
+package ttl
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"cluster-studio/pkg/shell"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// ExpiresAtOutput is the stack output the scheduler compares with the expiry
+// it was started for before destroying the stack
+const ExpiresAtOutput = "expiresAt"
+
+// prelude sets the pid file and the log of the scheduler of the stack
+const prelude = `state="${XDG_STATE_HOME:-$HOME/.local/state}/home"
+export PIDFILE="$state/$STACK-teardown.pid" LOGFILE="$state/$STACK-teardown.log"
+`
+
+// schedulerScript waits for the expiry and destroys the stack, unless a later
+// update moved the expiry or the stack is already gone. It forgets its pid
+// first, so deleting the Teardown during that destroy doesn't kill it.
+const schedulerScript = `while [ "$(date +%s)" -lt "$EXPIRES" ]; do sleep 60; done
+if [ "$(pulumi stack output ` + ExpiresAtOutput + ` --stack "$STACK" 2>/dev/null)" != "$EXPIRES_AT" ]; then
+  echo "stack $STACK no longer expires at $EXPIRES_AT, nothing to destroy"
+  exit 0
+fi
+rm -f "$PIDFILE"
+echo "stack $STACK expired at $EXPIRES_AT, destroying it"
+exec pulumi destroy --yes --skip-preview --stack "$STACK"`
+
+// stopScript stops the scheduler started for the stack, if any
+const stopScript = `if [ -f "$PIDFILE" ]; then
+  kill "$(cat "$PIDFILE")" 2>/dev/null || true
+  rm -f "$PIDFILE"
+fi`
+
+// TeardownArgs configures the scheduled destroy
+type TeardownArgs struct {
+	// Stack destroyed with `pulumi destroy`, from the directory of the program
+	Stack string
+	// When the stack is destroyed
+	ExpiresAt time.Time
+}
+
+// Teardown runs `pulumi destroy` on the stack once it has expired, from a
+// scheduler detached from the update. Each update moving the expiry replaces
+// the scheduler, deleting the Teardown stops it.
+type Teardown struct {
+	pulumi.ResourceState
+
+	// Expiry of the stack (RFC 3339)
+	ExpiresAt pulumi.StringOutput `pulumi:"expiresAt"`
+}
+
+// NewTeardown starts the scheduler of the stack. The stack must export the
+// expiry as ExpiresAtOutput.
+func NewTeardown(ctx *pulumi.Context, name string, args *TeardownArgs, opts ...pulumi.ResourceOption) (*Teardown, error) {
+	teardown := &Teardown{}
+	err := ctx.RegisterComponentResource("home:ttl:Teardown", name, teardown, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := args.ExpiresAt.UTC().Format(time.RFC3339)
+	start := prelude + fmt.Sprintf(`set -eu
+mkdir -p "$state"
+%s
+nohup sh -c %s >"$LOGFILE" 2>&1 </dev/null &
+echo $! >"$PIDFILE"
+echo "stack $STACK will be destroyed at $EXPIRES_AT, see $LOGFILE"`, stopScript, shell.Quote(schedulerScript))
+
+	_, err = local.NewCommand(ctx, fmt.Sprintf("%s-scheduler", name), &local.CommandArgs{
+		Create: pulumi.String(start),
+		Update: pulumi.String(start),
+		Delete: pulumi.String(prelude + stopScript),
+		Environment: pulumi.StringMap{
+			"STACK":      pulumi.String(args.Stack),
+			"EXPIRES":    pulumi.String(strconv.FormatInt(args.ExpiresAt.Unix(), 10)),
+			"EXPIRES_AT": pulumi.String(expiresAt),
+		},
+		Interpreter: shell.Interpreter(),
+	}, pulumi.Parent(teardown))
+	if err != nil {
+		return nil, err
+	}
+
+	teardown.ExpiresAt = pulumi.String(expiresAt).ToStringOutput()
+	err = ctx.RegisterResourceOutputs(teardown, pulumi.Map{
+		"expiresAt": teardown.ExpiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return teardown, nil
+}
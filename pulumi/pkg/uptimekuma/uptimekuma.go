@@ -0,0 +1,181 @@
+package uptimekuma
+
+import (
+	"fmt"
+
+	appsv1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apps/v1"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// Namespace is where Uptime Kuma runs
+	Namespace = "uptime-kuma"
+	// ServiceName is the Service of the web UI
+	ServiceName = "uptime-kuma"
+	// Port is the port of the web UI
+	Port = 3001
+	// Image is the Uptime Kuma image, the version is its tag
+	Image = "louislam/uptime-kuma"
+	// DefaultStorageSize is the size of the data volume without one
+	DefaultStorageSize = "1Gi"
+)
+
+// Args configures Uptime Kuma
+type Args struct {
+	// Image tag (e.g. 1.23.16)
+	Version string
+	// StorageClass of the data volume, the default class when empty
+	StorageClass pulumi.StringInput
+	// PersistentVolume the data claim binds to (optional), e.g. one backed
+	// by a host directory so the data outlives the cluster
+	VolumeName string
+	// Size of the data volume
+	StorageSize string
+}
+
+// UptimeKuma is the Uptime Kuma status page with its data on a volume
+type UptimeKuma struct {
+	pulumi.ResourceState
+
+	// In-cluster URL of the web UI
+	URL pulumi.StringOutput `pulumi:"url"`
+}
+
+// New creates the namespace, the data claim, a single-replica Deployment
+// replaced in place (the volume is ReadWriteOnce) and its Service
+func New(ctx *pulumi.Context, name string, args *Args, opts ...pulumi.ResourceOption) (*UptimeKuma, error) {
+	kuma := &UptimeKuma{}
+	err := ctx.RegisterComponentResource("home:uptimekuma:UptimeKuma", name, kuma, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := pulumi.StringMap{"app.kubernetes.io/name": pulumi.String("uptime-kuma")}
+	namespace, err := corev1.NewNamespace(ctx, fmt.Sprintf("%s-namespace", name), &corev1.NamespaceArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name: pulumi.String(Namespace),
+		},
+	}, pulumi.Parent(kuma))
+	if err != nil {
+		return nil, err
+	}
+
+	size := args.StorageSize
+	if size == "" {
+		size = DefaultStorageSize
+	}
+	claimSpec := &corev1.PersistentVolumeClaimSpecArgs{
+		AccessModes: pulumi.ToStringArray([]string{"ReadWriteOnce"}),
+		Resources: &corev1.ResourceRequirementsArgs{
+			Requests: pulumi.StringMap{"storage": pulumi.String(size)},
+		},
+		StorageClassName: args.StorageClass,
+	}
+	if args.VolumeName != "" {
+		claimSpec.VolumeName = pulumi.String(args.VolumeName)
+	}
+	claim, err := corev1.NewPersistentVolumeClaim(ctx, fmt.Sprintf("%s-data", name), &corev1.PersistentVolumeClaimArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String("uptime-kuma-data"),
+			Namespace: namespace.Metadata.Name(),
+		},
+		Spec: claimSpec,
+	}, pulumi.Parent(kuma))
+	if err != nil {
+		return nil, err
+	}
+
+	probe := func(initialDelay int) *corev1.ProbeArgs {
+		return &corev1.ProbeArgs{
+			TcpSocket:           &corev1.TCPSocketActionArgs{Port: pulumi.Int(Port)},
+			InitialDelaySeconds: pulumi.Int(initialDelay),
+			PeriodSeconds:       pulumi.Int(10),
+		}
+	}
+	_, err = appsv1.NewDeployment(ctx, fmt.Sprintf("%s-deployment", name), &appsv1.DeploymentArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String("uptime-kuma"),
+			Namespace: namespace.Metadata.Name(),
+		},
+		Spec: &appsv1.DeploymentSpecArgs{
+			Replicas: pulumi.Int(1),
+			Strategy: &appsv1.DeploymentStrategyArgs{Type: pulumi.String("Recreate")},
+			Selector: &metav1.LabelSelectorArgs{MatchLabels: labels},
+			Template: &corev1.PodTemplateSpecArgs{
+				Metadata: &metav1.ObjectMetaArgs{Labels: labels},
+				Spec: &corev1.PodSpecArgs{
+					Containers: corev1.ContainerArray{
+						&corev1.ContainerArgs{
+							Name:  pulumi.String("uptime-kuma"),
+							Image: pulumi.String(fmt.Sprintf("%s:%s", Image, args.Version)),
+							Ports: corev1.ContainerPortArray{
+								&corev1.ContainerPortArgs{
+									Name:          pulumi.String("http"),
+									ContainerPort: pulumi.Int(Port),
+									Protocol:      pulumi.String("TCP"),
+								},
+							},
+							VolumeMounts: corev1.VolumeMountArray{
+								&corev1.VolumeMountArgs{
+									Name:      pulumi.String("data"),
+									MountPath: pulumi.String("/app/data"),
+								},
+							},
+							Resources: &corev1.ResourceRequirementsArgs{
+								Requests: pulumi.StringMap{"memory": pulumi.String("128Mi"), "cpu": pulumi.String("50m")},
+								Limits:   pulumi.StringMap{"memory": pulumi.String("512Mi")},
+							},
+							LivenessProbe:  probe(60),
+							ReadinessProbe: probe(10),
+						},
+					},
+					Volumes: corev1.VolumeArray{
+						&corev1.VolumeArgs{
+							Name: pulumi.String("data"),
+							PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSourceArgs{
+								ClaimName: claim.Metadata.Name().Elem(),
+							},
+						},
+					},
+				},
+			},
+		},
+	}, pulumi.Parent(kuma))
+	if err != nil {
+		return nil, err
+	}
+
+	service, err := corev1.NewService(ctx, fmt.Sprintf("%s-service", name), &corev1.ServiceArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(ServiceName),
+			Namespace: namespace.Metadata.Name(),
+		},
+		Spec: &corev1.ServiceSpecArgs{
+			Type:     pulumi.String("ClusterIP"),
+			Selector: labels,
+			Ports: corev1.ServicePortArray{
+				&corev1.ServicePortArgs{
+					Name:       pulumi.String("http"),
+					Port:       pulumi.Int(Port),
+					TargetPort: pulumi.String("http"),
+					Protocol:   pulumi.String("TCP"),
+				},
+			},
+		},
+	}, pulumi.Parent(kuma))
+	if err != nil {
+		return nil, err
+	}
+
+	kuma.URL = pulumi.Sprintf("http://%s.%s.svc.cluster.local:%d", service.Metadata.Name().Elem(), Namespace, Port)
+	err = ctx.RegisterResourceOutputs(kuma, pulumi.Map{
+		"url": kuma.URL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return kuma, nil
+}
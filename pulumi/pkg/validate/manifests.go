@@ -0,0 +1,95 @@
+package validate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cluster-studio/pkg/kube"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+)
+
+// strictDecoder rejects unknown and duplicate fields of the built-in kinds
+var strictDecoder = serializer.NewCodecFactory(scheme.Scheme, serializer.EnableStrict).UniversalDeserializer()
+
+// Kustomization renders dir with `kubectl kustomize` and validates the
+// objects it produces
+func Kustomization(dir string) ([]Problem, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	data, err := kube.Kustomize(ctx, dir)
+	var kustomizeErr *kube.KustomizeError
+	switch {
+	case errors.As(err, &kustomizeErr):
+		// A broken kustomization is a problem of the tree, not of the run
+		return []Problem{{File: dir, Reason: kustomizeErr.Message}}, nil
+	case err != nil:
+		return nil, err
+	}
+	return Manifests(dir, data), nil
+}
+
+// Manifests validates the objects of a multi-document YAML stream rendered
+// from source. Every object needs an apiVersion, a kind and a name, and the
+// ones of built-in kinds must match their schema. Custom resources are not
+// checked, their CRDs are not known before they are installed.
+func Manifests(source string, data []byte) []Problem {
+	docs, err := kube.SplitDocuments(data)
+	var problems []Problem
+	for i, doc := range docs {
+		problems = append(problems, validateObject(source, fmt.Sprintf("document %d", i+1), doc)...)
+	}
+	if err != nil {
+		problems = append(problems, Problem{File: source, Path: fmt.Sprintf("document %d", len(docs)+1), Reason: err.Error()})
+	}
+	return problems
+}
+
+// validateObject validates one document, reported as doc until its kind and
+// name are known
+func validateObject(source, doc string, data []byte) []Problem {
+	var object unstructured.Unstructured
+	if err := yaml.Unmarshal(data, &object.Object); err != nil {
+		return []Problem{{File: source, Path: doc, Reason: err.Error()}}
+	}
+	if object.Object == nil {
+		return nil
+	}
+
+	var problems []Problem
+	for _, required := range []struct{ field, value string }{
+		{"apiVersion", object.GetAPIVersion()},
+		{"kind", object.GetKind()},
+		{"metadata.name", object.GetName()},
+	} {
+		if required.value == "" {
+			problems = append(problems, Problem{File: source, Path: doc, Reason: fmt.Sprintf("missing %s", required.field)})
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+
+	id := fmt.Sprintf("%s/%s", object.GetKind(), object.GetName())
+	if object.GetNamespace() != "" {
+		id = fmt.Sprintf("%s/%s/%s", object.GetKind(), object.GetNamespace(), object.GetName())
+	}
+	_, _, err := strictDecoder.Decode(data, nil, nil)
+	switch {
+	case err == nil, runtime.IsNotRegisteredError(err):
+		return nil
+	}
+	if strict, ok := runtime.AsStrictDecodingError(err); ok {
+		for _, fieldErr := range strict.Errors() {
+			problems = append(problems, Problem{File: source, Path: id, Reason: fieldErr.Error()})
+		}
+		return problems
+	}
+	return []Problem{{File: source, Path: id, Reason: err.Error()}}
+}
@@ -0,0 +1,35 @@
+package validate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Problem is an invalid value found in a file
+type Problem struct {
+	// File, or directory of a rendered kustomization
+	File string
+	// Where in the file: a field (e.g. "nodes[1].role"), a line or a rendered
+	// object (e.g. "Deployment/default/podinfo")
+	Path   string
+	Reason string
+}
+
+func (p Problem) String() string {
+	if p.Path == "" {
+		return fmt.Sprintf("%s: %s", p.File, p.Reason)
+	}
+	return fmt.Sprintf("%s: %s: %s", p.File, p.Path, p.Reason)
+}
+
+// Err returns a single error listing every problem, or nil
+func Err(problems []Problem) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	lines := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		lines = append(lines, problem.String())
+	}
+	return fmt.Errorf("validation failed:\n  - %s", strings.Join(lines, "\n  - "))
+}
@@ -0,0 +1,126 @@
+package velero
+
+import (
+	"context"
+	"fmt"
+
+	"cluster-studio/pkg/kube"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var (
+	backupStorageLocationResource = schema.GroupVersionResource{Group: "velero.io", Version: "v1", Resource: "backupstoragelocations"}
+	backupResource                = schema.GroupVersionResource{Group: "velero.io", Version: "v1", Resource: "backups"}
+	restoreResource               = schema.GroupVersionResource{Group: "velero.io", Version: "v1", Resource: "restores"}
+)
+
+// WaitForBackupStorageLocation polls until the backup storage location
+// reports the Available phase, i.e. Velero can reach its bucket, and returns
+// when it was last validated
+func WaitForBackupStorageLocation(ctx context.Context, client dynamic.Interface, name string, opts kube.PollOptions) (string, error) {
+	if opts.Description == "" {
+		opts.Description = fmt.Sprintf("backup storage location %s to become Available", name)
+	}
+
+	var validated string
+	err := kube.Poll(ctx, opts, func(ctx context.Context) (bool, string, error) {
+		location, err := client.Resource(backupStorageLocationResource).Namespace(Namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, "not found", nil
+		}
+		if err != nil {
+			return false, "", err
+		}
+		phase, _, _ := unstructured.NestedString(location.Object, "status", "phase")
+		if phase == "Available" {
+			validated, _, _ = unstructured.NestedString(location.Object, "status", "lastValidationTime")
+			return true, phase, nil
+		}
+		message, _, _ := unstructured.NestedString(location.Object, "status", "message")
+		return false, fmt.Sprintf("phase %q %s", phase, message), nil
+	})
+	return validated, err
+}
+
+// Restore restores backup into the cluster unless it was already restored,
+// i.e. the Restore restore-<backup> exists, and waits for it to complete. The
+// backup is first waited for, as Velero only lists the backups of the bucket
+// once it has synced the storage location.
+func Restore(ctx context.Context, client dynamic.Interface, backup string, opts kube.PollOptions) error {
+	name := "restore-" + backup
+	restores := client.Resource(restoreResource).Namespace(Namespace)
+	_, err := restores.Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		if opts.Logf != nil {
+			opts.Logf("Restore %s exists, backup %s was already restored", name, backup)
+		}
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	backupOpts := opts
+	backupOpts.Description = fmt.Sprintf("backup %s to be synced from the bucket", backup)
+	err = kube.Poll(ctx, backupOpts, func(ctx context.Context) (bool, string, error) {
+		found, err := client.Resource(backupResource).Namespace(Namespace).Get(ctx, backup, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, "not found", nil
+		}
+		if err != nil {
+			return false, "", err
+		}
+		phase, _, _ := unstructured.NestedString(found.Object, "status", "phase")
+		return phase == "Completed" || phase == "PartiallyFailed", fmt.Sprintf("phase %q", phase), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	restore := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "velero.io/v1",
+		"kind":       "Restore",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": Namespace,
+		},
+		"spec": map[string]interface{}{
+			"backupName": backup,
+		},
+	}}
+	if _, err := restores.Create(ctx, restore, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create Restore %s: %w", name, err)
+	}
+
+	restoreOpts := opts
+	restoreOpts.Description = fmt.Sprintf("Restore %s to complete", name)
+	var failed string
+	err = kube.Poll(ctx, restoreOpts, func(ctx context.Context) (bool, string, error) {
+		found, err := restores.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, "", err
+		}
+		phase, _, _ := unstructured.NestedString(found.Object, "status", "phase")
+		switch phase {
+		case "Completed":
+			return true, phase, nil
+		case "PartiallyFailed", "Failed", "FailedValidation":
+			reason, _, _ := unstructured.NestedString(found.Object, "status", "failureReason")
+			failed = fmt.Sprintf("%s %s", phase, reason)
+			return true, phase, nil
+		}
+		return false, fmt.Sprintf("phase %q", phase), nil
+	})
+	if err != nil {
+		return err
+	}
+	if failed != "" {
+		return fmt.Errorf("Restore %s of backup %s ended %s, see velero restore describe %s --details", name, backup, failed, name)
+	}
+	return nil
+}
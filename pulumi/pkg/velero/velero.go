@@ -0,0 +1,261 @@
+package velero
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// ChartRepo is the Helm repository serving the Velero chart
+	ChartRepo = "https://vmware-tanzu.github.io/helm-charts"
+	// Namespace is where Velero is installed
+	Namespace = "velero"
+	// StorageLocation is the name of the backup storage location
+	StorageLocation = "default"
+	// AWSPluginImage is the object store plugin for S3 compatible storage
+	AWSPluginImage = "velero/velero-plugin-for-aws:v1.12.1"
+)
+
+// ObjectStorage is the S3 bucket backups are written to
+type ObjectStorage struct {
+	// URL of the S3 API (e.g. http://minio.minio.svc.cluster.local:9000)
+	Endpoint pulumi.StringInput
+	Region   string
+	Bucket   string
+	// Credentials (secrets)
+	AccessKey pulumi.StringInput
+	SecretKey pulumi.StringInput
+}
+
+// ScheduleArgs describes the daily backup
+type ScheduleArgs struct {
+	// Cron expression (e.g. "0 3 * * *")
+	Cron string
+	// Namespaces included in the backup
+	Namespaces []string
+	// How long each backup is kept (e.g. "720h")
+	TTL string
+}
+
+// InstallArgs configures the Velero installation
+type InstallArgs struct {
+	// Chart version
+	Version string
+	// Bucket of the backup storage location
+	Storage ObjectStorage
+	// Backup schedule, none when nil
+	Schedule *ScheduleArgs
+	// Backup restored once the storage location is Available, unless it was
+	// already restored (optional)
+	RestoreFrom string
+	// Kubeconfig of the cluster (secret)
+	Kubeconfig pulumi.StringInput
+	// How long to wait for the release and the storage location
+	Timeout time.Duration
+	// How long to wait for the restore
+	RestoreTimeout time.Duration
+}
+
+// Install is Velero with a backup storage location on S3
+type Install struct {
+	pulumi.ResourceState
+
+	// Status of the backup storage location: name, phase, lastValidationTime
+	// and the backup restored from, if any
+	StorageLocation pulumi.StringMapOutput `pulumi:"storageLocation"`
+}
+
+// NewInstall installs Velero with the AWS plugin and the node agent, so pod
+// volumes are backed up with the file system backup, creates the backup
+// schedule and, with RestoreFrom, restores that backup. The status is
+// recorded in a ConfigMap of kube-system once the storage location is
+// Available and the restore completed, so resources depending on the
+// component are applied to the restored cluster.
+func NewInstall(ctx *pulumi.Context, name string, args *InstallArgs, opts ...pulumi.ResourceOption) (*Install, error) {
+	install := &Install{}
+	err := ctx.RegisterComponentResource("home:velero:Install", name, install, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace, err := corev1.NewNamespace(ctx, fmt.Sprintf("%s-namespace", name), &corev1.NamespaceArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name: pulumi.String(Namespace),
+		},
+	}, pulumi.Parent(install))
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := pulumi.All(args.Storage.AccessKey, args.Storage.SecretKey).ApplyT(func(keys []interface{}) string {
+		return fmt.Sprintf("[default]\naws_access_key_id=%s\naws_secret_access_key=%s\n", keys[0], keys[1])
+	}).(pulumi.StringOutput)
+	secret, err := corev1.NewSecret(ctx, fmt.Sprintf("%s-credentials", name), &corev1.SecretArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String("velero-credentials"),
+			Namespace: namespace.Metadata.Name(),
+		},
+		StringData: pulumi.StringMap{
+			"cloud": pulumi.ToSecret(credentials).(pulumi.StringOutput),
+		},
+	}, pulumi.Parent(install))
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := helmv3.NewRelease(ctx, stacks.Name(ctx, "velero"), &helmv3.ReleaseArgs{
+		Name:           pulumi.String("velero"),
+		Chart:          pulumi.String("velero"),
+		Version:        pulumi.String(args.Version),
+		Namespace:      namespace.Metadata.Name(),
+		RepositoryOpts: helmv3.RepositoryOptsArgs{Repo: pulumi.String(ChartRepo)},
+		Timeout:        pulumi.Int(int(args.Timeout.Seconds())),
+		Values:         values(args, secret.Metadata.Name().Elem()),
+	}, pulumi.Parent(install))
+	if err != nil {
+		return nil, err
+	}
+
+	if args.Schedule != nil {
+		_, err = apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-daily", name), &apiextensions.CustomResourceArgs{
+			ApiVersion: pulumi.String("velero.io/v1"),
+			Kind:       pulumi.String("Schedule"),
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:      pulumi.String("daily"),
+				Namespace: namespace.Metadata.Name(),
+			},
+			OtherFields: kubernetes.UntypedArgs{
+				"spec": pulumi.Map{
+					"schedule": pulumi.String(args.Schedule.Cron),
+					"template": pulumi.Map{
+						"includedNamespaces":       pulumi.ToStringArray(args.Schedule.Namespaces),
+						"storageLocation":          pulumi.String(StorageLocation),
+						"defaultVolumesToFsBackup": pulumi.Bool(true),
+						"ttl":                      pulumi.String(args.Schedule.TTL),
+					},
+				},
+			},
+		}, pulumi.Parent(install), pulumi.DependsOn([]pulumi.Resource{release}))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Resolves once the storage location is Available and the restore is done
+	status := pulumi.All(release.ID(), args.Kubeconfig).ApplyT(func(values []interface{}) (string, error) {
+		report := map[string]string{
+			"name":  StorageLocation,
+			"phase": "Available",
+		}
+		if args.RestoreFrom != "" {
+			report["restoredFrom"] = args.RestoreFrom
+		}
+		if ctx.DryRun() {
+			data, err := json.MarshalIndent(report, "", "  ")
+			return string(data), err
+		}
+
+		client, err := kube.NewDynamicClientFromKubeconfig(values[1].(string))
+		if err != nil {
+			return "", err
+		}
+		logf := func(format string, a ...interface{}) {
+			_ = ctx.Log.Info(fmt.Sprintf(format, a...), &pulumi.LogArgs{Resource: install})
+		}
+		report["lastValidationTime"], err = WaitForBackupStorageLocation(context.Background(), client, StorageLocation, kube.PollOptions{
+			Timeout: args.Timeout,
+			Logf:    logf,
+		})
+		if err != nil {
+			return "", err
+		}
+		if args.RestoreFrom != "" {
+			err = Restore(context.Background(), client, args.RestoreFrom, kube.PollOptions{
+				Timeout: args.RestoreTimeout,
+				Logf:    logf,
+			})
+			if err != nil {
+				return "", err
+			}
+		}
+		data, err := json.MarshalIndent(report, "", "  ")
+		return string(data), err
+	}).(pulumi.StringOutput)
+
+	_, err = corev1.NewConfigMap(ctx, fmt.Sprintf("%s-report", name), &corev1.ConfigMapArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(name),
+			Namespace: pulumi.String("kube-system"),
+		},
+		Data: pulumi.StringMap{
+			"status.json": status,
+		},
+	}, pulumi.Parent(install))
+	if err != nil {
+		return nil, err
+	}
+
+	install.StorageLocation = status.ApplyT(func(data string) (map[string]string, error) {
+		var report map[string]string
+		err := json.Unmarshal([]byte(data), &report)
+		return report, err
+	}).(pulumi.StringMapOutput)
+	err = ctx.RegisterResourceOutputs(install, pulumi.Map{
+		"storageLocation": install.StorageLocation,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return install, nil
+}
+
+// values assembles the chart values: the AWS plugin as an init container,
+// the storage location on the bucket and no volume snapshots, which Kind
+// volumes don't support
+func values(args *InstallArgs, credentialsSecret pulumi.StringInput) pulumi.Map {
+	return pulumi.Map{
+		"initContainers": pulumi.Array{
+			pulumi.Map{
+				"name":  pulumi.String("velero-plugin-for-aws"),
+				"image": pulumi.String(AWSPluginImage),
+				"volumeMounts": pulumi.Array{
+					pulumi.Map{"mountPath": pulumi.String("/target"), "name": pulumi.String("plugins")},
+				},
+			},
+		},
+		"credentials": pulumi.Map{
+			"existingSecret": credentialsSecret,
+		},
+		"configuration": pulumi.Map{
+			"backupStorageLocation": pulumi.Array{
+				pulumi.Map{
+					"name":     pulumi.String(StorageLocation),
+					"provider": pulumi.String("aws"),
+					"bucket":   pulumi.String(args.Storage.Bucket),
+					"default":  pulumi.Bool(true),
+					"config": pulumi.Map{
+						"region":           pulumi.String(args.Storage.Region),
+						"s3ForcePathStyle": pulumi.String("true"),
+						"s3Url":            args.Storage.Endpoint,
+					},
+				},
+			},
+			"volumeSnapshotLocation": pulumi.Array{},
+		},
+		"snapshotsEnabled": pulumi.Bool(false),
+		"deployNodeAgent":  pulumi.Bool(true),
+	}
+}
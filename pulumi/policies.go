@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/kyverno"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// countPolicyViolations counts, once everything in after has resolved, the
+// objects failing the audited Kyverno policies. Audited policies only report,
+// so the violations are logged rather than failing the update.
+func countPolicyViolations(ctx *pulumi.Context, kubeconfig pulumi.StringOutput, policies []string, after ...interface{}) pulumi.IntMapOutput {
+	return pulumi.All(append([]interface{}{kubeconfig}, after...)...).ApplyT(func(args []interface{}) (map[string]int, error) {
+		if ctx.DryRun() {
+			return map[string]int{}, nil
+		}
+
+		client, err := kube.NewDynamicClientFromKubeconfig(args[0].(string))
+		if err != nil {
+			return nil, err
+		}
+		counts, err := kyverno.CountViolations(context.Background(), client, policies)
+		if err != nil {
+			_ = ctx.Log.Warn(fmt.Sprintf("skipping the policy violation count: %v", err), nil)
+			return map[string]int{}, nil
+		}
+		total := 0
+		for _, policy := range policies {
+			if counts[policy] > 0 {
+				_ = ctx.Log.Warn(fmt.Sprintf("%d object(s) violate the audited policy %s, see kubectl get policyreports -A", counts[policy], policy), nil)
+			}
+			total += counts[policy]
+		}
+		counts["total"] = total
+		return counts, nil
+	}).(pulumi.IntMapOutput)
+}
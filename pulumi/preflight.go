@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+
+	"cluster-studio/pkg/preflight"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// runPreflight verifies the CLIs and the Docker daemon needed by the enabled
+// components and the host paths of the persistent volumes before any resource
+// is created, and exports the detected versions
+func runPreflight(ctx *pulumi.Context, cfg Config) error {
+	// The tools of each cluster of an umbrella stack, exported under its name
+	if cfg.Members != nil {
+		versions := pulumi.Map{}
+		for _, stack := range cfg.Members.Stacks {
+			report := checkTools(cfg.Members.Configs[stack])
+			if err := report.Err(); err != nil {
+				return fmt.Errorf("cluster %s: %w", stack, err)
+			}
+			versions[stack] = pulumi.ToStringMap(report.Versions)
+		}
+		ctx.Export("toolVersions", versions)
+		return nil
+	}
+	report := checkTools(cfg)
+	ctx.Export("toolVersions", pulumi.ToStringMap(report.Versions))
+	return report.Err()
+}
+
+// checkTools runs the preflight checks of the cluster cfg deploys
+func checkTools(cfg Config) *preflight.Report {
+	clusterCfg, components, minVersions := cfg.Cluster, cfg.Components, cfg.ToolVersions
+
+	required := []string{"kubectl"}
+	if clusterCfg.Provision {
+		required = append(required, clusterCfg.Backend)
+	}
+	if components.Flux {
+		required = append(required, "flux")
+	}
+	// linkerd check gates the installation whatever the install method
+	if components.Linkerd || components.LinkerdViz {
+		required = append(required, "linkerd")
+	}
+	useDocker := components.LocalRegistry || len(clusterCfg.RegistryMirrors) > 0 || clusterCfg.PullThroughCache ||
+		(components.MetalLB && clusterCfg.Provision) || cfg.Preload != nil || len(cfg.LocalImages) > 0
+	if useDocker {
+		required = append(required, "docker")
+	}
+
+	tools := make([]preflight.Tool, 0, len(required))
+	for _, name := range required {
+		tools = append(tools, preflight.Tool{
+			Name:        name,
+			VersionArgs: preflight.KnownTools[name],
+			MinVersion:  minVersions[name],
+		})
+	}
+
+	// The Docker daemon is only needed to run local clusters and registries
+	report := preflight.Run(tools, clusterCfg.Provision || useDocker)
+	// The persistent volumes are mounted into the nodes from the host
+	hostPaths := make([]string, 0, len(clusterCfg.PersistentVolumes))
+	for _, volume := range clusterCfg.PersistentVolumes {
+		hostPaths = append(hostPaths, volume.HostPath)
+	}
+	report.CheckWritableDirs(hostPaths)
+	// Kind fails late when the fixed API server port is taken, unless by the
+	// control plane of this cluster
+	if clusterCfg.Kind.APIServerPort != 0 {
+		address := clusterCfg.Kind.APIServerAddress
+		if address == "" {
+			address = "127.0.0.1"
+		}
+		report.CheckPortFree(address, clusterCfg.Kind.APIServerPort, clusterCfg.Name+"-control-plane")
+	}
+	return report
+}
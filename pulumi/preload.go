@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"cluster-studio/pkg/infra"
+	"cluster-studio/pkg/kube"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// preloadImages returns the sorted images to preload: those of
+// home:preloadImages and, with home:preloadInfraImages, those of the
+// containers of the infrastructure tree and of the extra kustomize
+// directories. A directory that doesn't render is skipped with a warning,
+// the validation reports it.
+func preloadImages(ctx *pulumi.Context, preloadCfg *PreloadConfig, clusterCfg *ClusterConfig) []string {
+	seen := map[string]bool{}
+	for _, image := range preloadCfg.Images {
+		seen[image] = true
+	}
+
+	if preloadCfg.InfraImages {
+		dirs, err := kustomizeDirs(clusterCfg)
+		if err != nil {
+			_ = ctx.Log.Warn(fmt.Sprintf("not preloading the images of the infrastructure tree: %v", err), nil)
+		}
+		for _, dir := range dirs {
+			objects, err := renderKustomization(dir)
+			if err != nil {
+				_ = ctx.Log.Warn(fmt.Sprintf("not preloading the images of %s: %v", dir, err), nil)
+				continue
+			}
+			for _, image := range kube.Images(objects) {
+				seen[image] = true
+			}
+		}
+	}
+
+	images := make([]string, 0, len(seen))
+	for image := range seen {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images
+}
+
+// kustomizeDirs returns the components of the infrastructure tree followed
+// by the extra kustomize directories. The extra directories are returned even
+// when the tree can't be listed.
+func kustomizeDirs(clusterCfg *ClusterConfig) ([]string, error) {
+	var dirs []string
+	components, err := infra.DiscoverComponents(clusterCfg.InfraDir)
+	for _, component := range components {
+		dirs = append(dirs, filepath.Join(clusterCfg.InfraDir, component))
+	}
+	for _, dir := range clusterCfg.ExtraKustomizeDirs {
+		dirs = append(dirs, dir.Dir)
+	}
+	return dirs, err
+}
+
+// renderKustomization renders dir and decodes its objects
+func renderKustomization(dir string) ([]*unstructured.Unstructured, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	data, err := kube.Kustomize(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	return kube.DecodeObjects(data)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	fluxpkg "cluster-studio/pkg/flux"
+	"cluster-studio/pkg/kube"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// waitForFluxReconciliation waits, once applied resolves, for all Flux
+// Kustomizations and HelmReleases to become Ready and returns their final
+// state as a structured output. Stuck objects fail the update.
+func waitForFluxReconciliation(ctx *pulumi.Context, kubeconfig pulumi.StringOutput, applied pulumi.ArrayOutput, timeout time.Duration) pulumi.Output {
+	return pulumi.All(kubeconfig, applied).ApplyT(func(args []interface{}) (map[string]interface{}, error) {
+		if ctx.DryRun() {
+			return map[string]interface{}{}, nil
+		}
+
+		client, err := kube.NewDynamicClientFromKubeconfig(args[0].(string))
+		if err != nil {
+			return nil, err
+		}
+		status, err := fluxpkg.WaitForReconciliation(context.Background(), client, kube.PollOptions{
+			Interval: 10 * time.Second,
+			Timeout:  timeout,
+			Logf: func(format string, a ...interface{}) {
+				_ = ctx.Log.Info(fmt.Sprintf(format, a...), nil)
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return jsonMap(status)
+	})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+
+	"cluster-studio/pkg/shell"
+	"cluster-studio/pkg/stacks"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// deployReport is the JSON report written after the deployment
+type deployReport struct {
+	Stack          string `json:"stack"`
+	ClusterName    string `json:"clusterName"`
+	KubeconfigPath string `json:"kubeconfigPath,omitempty"`
+	KubeContext    string `json:"kubeContext"`
+	// Component -> chart version of what the program installed with Helm
+	Versions map[string]string `json:"versions"`
+	// The summary output: duration, version and applied objects of each step
+	Steps map[string]interface{} `json:"steps"`
+	// The smokeTests output, when enabled
+	SmokeTests interface{} `json:"smokeTests,omitempty"`
+}
+
+// writeDeployReport writes report as JSON to path once every output of after
+// resolves. It is a command so that it runs again whenever the report changes,
+// and destroy removes the file.
+func writeDeployReport(ctx *pulumi.Context, path string, report deployReport, steps pulumi.Map, smokeTests pulumi.Output, after ...interface{}) (*local.Command, error) {
+	var results interface{} = pulumi.Map{}
+	if smokeTests != nil {
+		results = smokeTests
+	}
+	content := pulumi.All(append([]interface{}{steps, results}, after...)...).ApplyT(func(args []interface{}) (string, error) {
+		report.Steps = args[0].(map[string]interface{})
+		if smokeTests != nil {
+			report.SmokeTests = args[1]
+		}
+		raw, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(raw) + "\n", nil
+	}).(pulumi.StringOutput)
+
+	// The steps are secret because they are read with the kubeconfig, the
+	// report itself holds no credentials
+	content = pulumi.Unsecret(content).(pulumi.StringOutput)
+
+	// Rewritten in place: replacing the command would delete the new file
+	write := pulumi.String(`printf '%s' "$REPORT" > "$REPORT_PATH"`)
+	return local.NewCommand(ctx, stacks.Name(ctx, "deploy-report"), &local.CommandArgs{
+		Create: write,
+		Update: write,
+		Delete: pulumi.String(`rm -f "$REPORT_PATH"`),
+		Environment: pulumi.StringMap{
+			"REPORT":      content,
+			"REPORT_PATH": pulumi.String(path),
+		},
+		Interpreter: shell.Interpreter(),
+	})
+}
+
+// componentVersions returns the chart versions of the components the program
+// installs with Helm, Flux and the mesh are in the summary steps
+func componentVersions(cfg Config) map[string]string {
+	components := cfg.Components
+	versions := map[string]string{}
+	for component, version := range map[string]struct {
+		enabled bool
+		version string
+	}{
+		"metallb":         {components.MetalLB, cfg.MetalLB.Version},
+		"ingress":         {components.Ingress, cfg.Ingress.Version},
+		"envoyGateway":    {components.EnvoyGateway, cfg.Ingress.EnvoyGatewayVersion},
+		"gatewayApi":      {components.EnvoyGateway, cfg.Ingress.GatewayAPIVersion},
+		"certManager":     {components.CertManager, cfg.CertManager.Version},
+		"externalDns":     {components.ExternalDNS, cfg.ExternalDNS.Version},
+		"monitoring":      {components.Monitoring, cfg.Monitoring.Version},
+		"loki":            {components.Logging, cfg.Logging.LokiVersion},
+		"promtail":        {components.Logging, cfg.Logging.PromtailVersion},
+		"tempo":           {components.Tracing, cfg.Tracing.Version},
+		"flagger":         {components.Flagger, cfg.Flagger.Version},
+		"minio":           {components.MinIO, cfg.MinIO.Version},
+		"velero":          {components.Velero, cfg.Velero.Version},
+		"postgres":        {components.Postgres, cfg.Postgres.Version},
+		"sealedSecrets":   {components.SealedSecrets, cfg.SealedSecrets.Version},
+		"externalSecrets": {components.ExternalSecrets, cfg.ExternalSecrets.Version},
+		"kyverno":         {components.Kyverno, cfg.Kyverno.Version},
+		"metricsServer":   {components.MetricsServer, cfg.MetricsServer.Version},
+		"keda":            {components.KEDA, cfg.KEDA.Version},
+		"blackbox":        {components.BlackboxExporter, cfg.Blackbox.Version},
+		"uptimeKuma":      {components.UptimeKuma, cfg.UptimeKuma.Version},
+		"fluxUI":          {components.FluxUI, cfg.FluxUI.Version},
+	} {
+		if version.enabled {
+			versions[component] = version.version
+		}
+	}
+	return versions
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/smoketest"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// runSmokeTests requests the configured endpoints from a test pod once every
+// output of after resolves and returns the result of each check as a
+// structured output. A failed mandatory check fails the update. The requests
+// carry the sampled trace traceID unless it is empty.
+func runSmokeTests(ctx *pulumi.Context, kubeconfig pulumi.StringOutput, smokeTestsCfg *SmokeTestsConfig, traceID string, timeout time.Duration, after ...interface{}) pulumi.Output {
+	return pulumi.All(append([]interface{}{kubeconfig}, after...)...).ApplyT(func(args []interface{}) (map[string]interface{}, error) {
+		if ctx.DryRun() {
+			return map[string]interface{}{}, nil
+		}
+
+		client, err := kube.NewClientsetFromKubeconfig(args[0].(string))
+		if err != nil {
+			return nil, err
+		}
+		results, err := smoketest.Run(context.Background(), client, smokeTestsCfg.Checks, smoketest.Options{
+			Namespace: smokeTestsCfg.Namespace,
+			Image:     smokeTestsCfg.Image,
+			TraceID:   traceID,
+			Timeout:   timeout,
+			Logf: func(format string, a ...interface{}) {
+				_ = ctx.Log.Info(fmt.Sprintf(format, a...), nil)
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, result := range results {
+			switch {
+			case result.Passed:
+				_ = ctx.Log.Info(fmt.Sprintf("smoke test %s passed (%d)", result.Name, result.Status), nil)
+			case result.Optional:
+				_ = ctx.Log.Warn(fmt.Sprintf("optional smoke test %s failed: %s: %s", result.Name, result.URL, result.Error), nil)
+			}
+		}
+		if failed := smoketest.FailedMandatory(results); len(failed) > 0 {
+			var lines []string
+			for _, result := range failed {
+				lines = append(lines, fmt.Sprintf("%s: %s: %s", result.Name, result.URL, result.Error))
+			}
+			return nil, fmt.Errorf("%d mandatory smoke test(s) failed:\n  %s", len(failed), strings.Join(lines, "\n  "))
+		}
+
+		return jsonMap(map[string]interface{}{
+			"passed": true,
+			"checks": results,
+		})
+	})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"cluster-studio/pkg/kube"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Label selectors matching the objects installed by the CLI and Helm installers
+const (
+	fluxSelector       = "app.kubernetes.io/part-of=flux"
+	linkerdSelector    = "linkerd.io/control-plane-ns=linkerd,!linkerd.io/extension"
+	linkerdVizSelector = "linkerd.io/extension=viz"
+	istioSelector      = "app.kubernetes.io/part-of=istio"
+)
+
+// stepSummary is one entry of the summary output
+type stepSummary struct {
+	Version  string `json:"version,omitempty"`
+	Duration string `json:"duration"`
+	*kube.Inventory
+}
+
+// deploySummary collects the per-step entries of the summary output. Steps
+// run one after the other, so the duration of a step is the time between the
+// completion of the previous recorded step (or the cluster) and its own; the
+// infrastructure entry therefore includes the platform components applied
+// right before it.
+type deploySummary struct {
+	ctx        *pulumi.Context
+	kubeconfig pulumi.StringOutput
+	// Completion time of the last recorded step
+	last  pulumi.Output
+	steps pulumi.Map
+}
+
+func newDeploySummary(ctx *pulumi.Context, kubeconfig pulumi.StringOutput) *deploySummary {
+	return &deploySummary{
+		ctx:        ctx,
+		kubeconfig: kubeconfig,
+		last:       completedAt(kubeconfig),
+		steps:      pulumi.Map{},
+	}
+}
+
+// installed records a step whose objects are found in the cluster by label
+// selector once version resolves
+func (s *deploySummary) installed(name string, version pulumi.StringOutput, selector string) {
+	started, completed := s.last, completedAt(version)
+	s.last = completed
+	s.steps[name] = pulumi.All(started, completed, version, s.kubeconfig).ApplyT(func(args []interface{}) (map[string]interface{}, error) {
+		inventory := kube.NewInventory()
+		if !s.ctx.DryRun() {
+			var err error
+			inventory, err = kube.ListInventory(context.Background(), args[3].(string), selector)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return jsonMap(stepSummary{
+			Version:   args[2].(string),
+			Duration:  duration(args[0], args[1]),
+			Inventory: inventory,
+		})
+	})
+}
+
+// applied records a step whose objects are known to the program, resolved
+// with the inventory
+func (s *deploySummary) applied(name string, inventory pulumi.Output) {
+	started, completed := s.last, completedAt(inventory)
+	s.last = completed
+	s.steps[name] = pulumi.All(started, completed, inventory).ApplyT(func(args []interface{}) (map[string]interface{}, error) {
+		return jsonMap(stepSummary{
+			Duration:  duration(args[0], args[1]),
+			Inventory: args[2].(*kube.Inventory),
+		})
+	})
+}
+
+// completedAt resolves to the time output resolved
+func completedAt(output pulumi.Output) pulumi.Output {
+	return output.ApplyT(func(interface{}) time.Time {
+		return time.Now()
+	})
+}
+
+func duration(started, completed interface{}) string {
+	return completed.(time.Time).Sub(started.(time.Time)).Round(time.Second).String()
+}
+
+// jsonMap round-trips v through JSON so the output has plain field names
+func jsonMap(v interface{}) (map[string]interface{}, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
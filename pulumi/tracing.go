@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/tracing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// newTraceID returns a random W3C trace ID
+func newTraceID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// verifySmokeTestTrace waits, once the smoke tests have run, for Tempo to
+// return the spans the mesh proxies recorded for their requests, and returns
+// the trace ID and the number of spans
+func verifySmokeTestTrace(ctx *pulumi.Context, kubeconfig pulumi.StringOutput, traceID string, timeout time.Duration, smokeTests pulumi.Output) pulumi.Output {
+	return pulumi.All(kubeconfig, smokeTests).ApplyT(func(args []interface{}) (map[string]interface{}, error) {
+		if ctx.DryRun() {
+			return map[string]interface{}{}, nil
+		}
+
+		client, err := kube.NewClientsetFromKubeconfig(args[0].(string))
+		if err != nil {
+			return nil, err
+		}
+		spans, err := tracing.WaitForTrace(context.Background(), client, traceID, kube.PollOptions{
+			Description: fmt.Sprintf("the spans of the smoke tests (trace %s) in Tempo", traceID),
+			Interval:    5 * time.Second,
+			Timeout:     timeout,
+			Logf: func(format string, a ...interface{}) {
+				_ = ctx.Log.Info(fmt.Sprintf(format, a...), nil)
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return jsonMap(map[string]interface{}{
+			"traceId": traceID,
+			"spans":   spans,
+		})
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+
+	"cluster-studio/pkg/cloudflare"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// renderedServices returns the Services (namespace/name) of the tunnel routes
+// found in the rendered infrastructure tree and extra kustomize directories.
+// A directory that doesn't render is skipped: the cluster is polled for the
+// Services not found.
+func renderedServices(ctx *pulumi.Context, clusterCfg *ClusterConfig, routes []cloudflare.Route) []string {
+	wanted := map[string]bool{}
+	for _, route := range routes {
+		wanted[route.ServiceKey()] = true
+	}
+
+	dirs, err := kustomizeDirs(clusterCfg)
+	if err != nil {
+		_ = ctx.Log.Debug(fmt.Sprintf("not looking up the tunnel routes in the infrastructure tree: %v", err), nil)
+	}
+	var found []string
+	for _, dir := range dirs {
+		if len(wanted) == 0 {
+			break
+		}
+		objects, err := renderKustomization(dir)
+		if err != nil {
+			_ = ctx.Log.Debug(fmt.Sprintf("not looking up the tunnel routes in %s: %v", dir, err), nil)
+			continue
+		}
+		for _, object := range objects {
+			key := object.GetNamespace() + "/" + object.GetName()
+			if object.GetKind() == "Service" && wanted[key] {
+				found = append(found, key)
+				delete(wanted, key)
+			}
+		}
+	}
+	return found
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"cluster-studio/pkg/kube"
+	"cluster-studio/pkg/logging"
+	"cluster-studio/pkg/monitoring"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// discoverServiceURLs looks up, once every output of after resolves, how to
+// reach the given Services from the host and returns name -> URL. Services
+// that don't exist or can't be inspected are left out instead of failing the update.
+func discoverServiceURLs(ctx *pulumi.Context, kubeconfig pulumi.StringOutput, kubeContext string, refs []kube.ServiceRef, after ...interface{}) pulumi.StringMapOutput {
+	return pulumi.All(append([]interface{}{kubeconfig}, after...)...).ApplyT(func(args []interface{}) map[string]string {
+		urls := map[string]string{}
+		if ctx.DryRun() {
+			return urls
+		}
+
+		client, err := kube.NewClientsetFromKubeconfig(args[0].(string))
+		if err != nil {
+			_ = ctx.Log.Warn(fmt.Sprintf("skipping service URL discovery: %v", err), nil)
+			return urls
+		}
+		dynamicClient, err := kube.NewDynamicClientFromKubeconfig(args[0].(string))
+		if err != nil {
+			_ = ctx.Log.Warn(fmt.Sprintf("skipping service URL discovery: %v", err), nil)
+			return urls
+		}
+		for _, ref := range refs {
+			url, found, err := kube.ServiceURL(context.Background(), client, dynamicClient, kubeContext, ref)
+			if err != nil {
+				_ = ctx.Log.Warn(fmt.Sprintf("no URL for %s: %v", ref.Name, err), nil)
+				continue
+			}
+			if found {
+				urls[ref.Name] = url
+			}
+		}
+		return urls
+	}).(pulumi.StringMapOutput)
+}
+
+// lokiPushEndpoint returns the URL other clusters push logs to: the node
+// port Service of Loki when there is one, the in-cluster URL otherwise
+func lokiPushEndpoint(ctx *pulumi.Context, kubeconfig pulumi.StringOutput, kubeContext string, nodePort int, inCluster pulumi.StringOutput, after ...interface{}) pulumi.StringOutput {
+	if nodePort == 0 {
+		return inCluster
+	}
+
+	ref := kube.ServiceRef{Name: "loki", Namespace: logging.Namespace, Service: "loki-push"}
+	return nodePortEndpoint(ctx, kubeconfig, kubeContext, ref, logging.PushPath, inCluster, after...)
+}
+
+// prometheusRemoteWriteEndpoint returns the URL other clusters remote_write
+// to through the node port Service of Prometheus
+func prometheusRemoteWriteEndpoint(ctx *pulumi.Context, kubeconfig pulumi.StringOutput, kubeContext string, after ...interface{}) pulumi.StringOutput {
+	ref := kube.ServiceRef{Name: "prometheus", Namespace: monitoring.Namespace, Service: "prometheus-remote-write"}
+	inCluster := pulumi.String(monitoring.PrometheusURL + monitoring.RemoteWritePath).ToStringOutput()
+	return nodePortEndpoint(ctx, kubeconfig, kubeContext, ref, monitoring.RemoteWritePath, inCluster, after...)
+}
+
+// nodePortEndpoint returns the URL of path on the Service ref as reachable
+// from the host, inCluster when it can't be discovered
+func nodePortEndpoint(ctx *pulumi.Context, kubeconfig pulumi.StringOutput, kubeContext string, ref kube.ServiceRef, path string, inCluster pulumi.StringOutput, after ...interface{}) pulumi.StringOutput {
+	urls := discoverServiceURLs(ctx, kubeconfig, kubeContext, []kube.ServiceRef{ref}, after...)
+	return pulumi.All(urls, inCluster).ApplyT(func(args []interface{}) string {
+		if url, ok := args[0].(map[string]string)[ref.Name]; ok {
+			return url + path
+		}
+		return args[1].(string)
+	}).(pulumi.StringOutput)
+}
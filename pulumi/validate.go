@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"cluster-studio/pkg/infra"
+	"cluster-studio/pkg/kind"
+	"cluster-studio/pkg/validate"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// runValidation checks the Kind config and renders and validates every
+// component of the infrastructure tree and every extra kustomize directory
+// before any resource is created, so a typo fails the update before the
+// cluster exists. home:skipValidation bypasses it in emergencies.
+func runValidation(ctx *pulumi.Context, cfg Config) error {
+	if cfg.Members != nil {
+		for _, stack := range cfg.Members.Stacks {
+			if err := runValidation(ctx, cfg.Members.Configs[stack]); err != nil {
+				return fmt.Errorf("cluster %s: %w", stack, err)
+			}
+		}
+		return nil
+	}
+	if cfg.SkipValidation {
+		_ = ctx.Log.Warn("home:skipValidation is set, the Kind config and the infrastructure tree are not validated", nil)
+		return nil
+	}
+
+	var problems []validate.Problem
+	clusterCfg := cfg.Cluster
+	if clusterCfg.Provision && clusterCfg.Backend == "kind" {
+		file := clusterCfg.KindConfigPath
+		if file == "" {
+			file = "generated Kind config"
+		}
+		problems = append(problems, kind.ValidateConfig(file, []byte(clusterCfg.KindConfig))...)
+	}
+
+	if cfg.Components.Infrastructure {
+		components, err := infra.DiscoverComponents(clusterCfg.InfraDir)
+		if err != nil {
+			return err
+		}
+		for _, component := range components {
+			componentProblems, err := validate.Kustomization(filepath.Join(clusterCfg.InfraDir, component))
+			if err != nil {
+				return err
+			}
+			problems = append(problems, componentProblems...)
+		}
+	}
+
+	for _, dir := range clusterCfg.ExtraKustomizeDirs {
+		dirProblems, err := validate.Kustomization(dir.Dir)
+		if err != nil {
+			return err
+		}
+		problems = append(problems, dirProblems...)
+	}
+
+	return validate.Err(problems)
+}
@@ -6,7 +6,9 @@
 set -euo pipefail
 
 CLUSTER_NAME="${1:-homelab}"
-CONTEXT="kind-${CLUSTER_NAME}"
+CONTEXT="${KUBE_CONTEXT:-kind-${CLUSTER_NAME}}"
+# Release of the Linkerd CLI installed when it is missing, passed by Pulumi
+LINKERD_VERSION="${LINKERD_VERSION:-}"
 
 echo "📊 Installing Linkerd Viz on cluster: ${CLUSTER_NAME}"
 
@@ -14,10 +16,18 @@ echo "📊 Installing Linkerd Viz on cluster: ${CLUSTER_NAME}"
 check_linkerd_cli() {
     if ! command -v linkerd &> /dev/null; then
         echo "❌ Linkerd CLI not found. Installing..."
-        curl --proto '=https' --tlsv1.2 -sSfL https://run.linkerd.io/install | sh
+        if [ -n "${LINKERD_VERSION}" ]; then
+            curl --proto '=https' --tlsv1.2 -sSfL https://run.linkerd.io/install-edge | LINKERD2_VERSION="${LINKERD_VERSION}" sh
+        else
+            curl --proto '=https' --tlsv1.2 -sSfL https://run.linkerd.io/install | sh
+        fi
         export PATH=$PATH:$HOME/.linkerd2/bin
     else
         echo "✅ Linkerd CLI found"
+        CLI_VERSION=$(linkerd version --client --short 2>/dev/null || true)
+        if [ -n "${LINKERD_VERSION}" ] && [ "${CLI_VERSION}" != "${LINKERD_VERSION}" ]; then
+            echo "⚠️  Linkerd CLI is ${CLI_VERSION}, ${LINKERD_VERSION} is configured"
+        fi
     fi
 }
 
@@ -6,19 +6,36 @@
 set -euo pipefail
 
 CLUSTER_NAME="${1:-homelab}"
-CONTEXT="kind-${CLUSTER_NAME}"
+CONTEXT="${KUBE_CONTEXT:-kind-${CLUSTER_NAME}}"
 CLEANUP_EXISTING="${2:-false}"
 
+# Settings passed by Pulumi, the defaults match a manual run
+LINKERD_VERSION="${LINKERD_VERSION:-}"
+LINKERD_HA="${LINKERD_HA:-false}"
+VIZ_ENABLED="${VIZ_ENABLED:-true}"
+# Identity certificates (PEM), generated by linkerd install when unset
+LINKERD_TRUST_ANCHOR_PEM="${LINKERD_TRUST_ANCHOR_PEM:-}"
+LINKERD_ISSUER_CERT_PEM="${LINKERD_ISSUER_CERT_PEM:-}"
+LINKERD_ISSUER_KEY_PEM="${LINKERD_ISSUER_KEY_PEM:-}"
+
 echo "🚀 Installing Linkerd on cluster: ${CLUSTER_NAME}"
 
 # Function to check if linkerd CLI is installed
 check_linkerd_cli() {
     if ! command -v linkerd &> /dev/null; then
         echo "❌ Linkerd CLI not found. Installing..."
-        curl --proto '=https' --tlsv1.2 -sSfL https://run.linkerd.io/install | sh
+        if [ -n "${LINKERD_VERSION}" ]; then
+            curl --proto '=https' --tlsv1.2 -sSfL https://run.linkerd.io/install-edge | LINKERD2_VERSION="${LINKERD_VERSION}" sh
+        else
+            curl --proto '=https' --tlsv1.2 -sSfL https://run.linkerd.io/install | sh
+        fi
         export PATH=$PATH:$HOME/.linkerd2/bin
     else
         echo "✅ Linkerd CLI found"
+        CLI_VERSION=$(linkerd version --client --short 2>/dev/null || true)
+        if [ -n "${LINKERD_VERSION}" ] && [ "${CLI_VERSION}" != "${LINKERD_VERSION}" ]; then
+            echo "⚠️  Linkerd CLI is ${CLI_VERSION}, ${LINKERD_VERSION} is configured"
+        fi
     fi
 }
 
@@ -103,7 +120,22 @@ install_crds() {
 # Function to install Linkerd control plane
 install_control_plane() {
     echo "🎛️ Installing Linkerd control plane..."
-    if ! linkerd install --context "${CONTEXT}" | kubectl --context "${CONTEXT}" apply -f -; then
+    INSTALL_FLAGS=()
+    if [ "${LINKERD_HA}" = "true" ]; then
+        INSTALL_FLAGS+=(--ha)
+    fi
+    # The certificates are only written to files for the installation
+    if [ -n "${LINKERD_TRUST_ANCHOR_PEM}" ]; then
+        IDENTITY_DIR=$(mktemp -d)
+        trap 'rm -rf "${IDENTITY_DIR}"' EXIT
+        printf '%s\n' "${LINKERD_TRUST_ANCHOR_PEM}" > "${IDENTITY_DIR}/ca.crt"
+        printf '%s\n' "${LINKERD_ISSUER_CERT_PEM}" > "${IDENTITY_DIR}/issuer.crt"
+        printf '%s\n' "${LINKERD_ISSUER_KEY_PEM}" > "${IDENTITY_DIR}/issuer.key"
+        INSTALL_FLAGS+=(--identity-trust-anchors-file "${IDENTITY_DIR}/ca.crt"
+            --identity-issuer-certificate-file "${IDENTITY_DIR}/issuer.crt"
+            --identity-issuer-key-file "${IDENTITY_DIR}/issuer.key")
+    fi
+    if ! linkerd install --context "${CONTEXT}" ${INSTALL_FLAGS[@]+"${INSTALL_FLAGS[@]}"} | kubectl --context "${CONTEXT}" apply -f -; then
         echo "❌ Failed to install Linkerd control plane"
         exit 1
     fi
@@ -148,7 +180,9 @@ show_status() {
     echo ""
     echo "📊 Available Services:"
     kubectl --context "${CONTEXT}" get pods -n linkerd
-    kubectl --context "${CONTEXT}" get pods -n linkerd-viz
+    if [ "${VIZ_ENABLED}" = "true" ]; then
+        kubectl --context "${CONTEXT}" get pods -n linkerd-viz
+    fi
 }
 
 # Main installation flow
@@ -163,7 +197,9 @@ main() {
     install_crds
     install_control_plane
     wait_for_ready
-    install_viz
+    if [ "${VIZ_ENABLED}" = "true" ]; then
+        install_viz
+    fi
     show_status
     
     echo ""